@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"pigpak/internal/config"
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+	"pigpak/internal/webdav"
+)
+
+const adminUsage = `usage: pigpak admin [-db path] <command> [flags]
+
+Administers users and their data on the database directly, without the bot.
+Commands that change data only report what they would do unless -apply is
+given; passwd changes the password right away.
+
+commands:
+  users                                      list users and their storage
+  passwd -user ID [-password P]              reset a user's WebDAV password; without
+                                             -password one is generated and printed,
+                                             "-password -" reads it from stdin
+  purge-trash [-user ID] [-older-than D]     delete trash items for good, of one user
+                                             or everyone
+  sizes                                      recompute file sizes from their parts;
+                                             folder and drive totals follow
+  verify [-user ID] [-limit N]               download files from Telegram and compare
+                                             them with their SHA-256; needs BOT_TOKEN.
+                                             -apply records the missing checksums
+  migrate [-to VERSION]                      apply pending migrations, or revert to VERSION
+`
+
+// runAdminCommand implements "pigpak admin" and returns the process exit
+// code.
+func runAdminCommand(args []string, stdout, stderr io.Writer) int {
+	_, defaultPath := config.StoragePaths()
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, adminUsage) }
+	path := fs.String("db", defaultPath, "database path")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if _, err := os.Stat(*path); err != nil {
+		fmt.Fprintf(stderr, "database %s: %v\n", *path, err)
+		return 1
+	}
+	store, err := db.Open(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "db open error: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	ctx := db.WithActor(context.Background(), db.AuditSourceCLI, 0)
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "users":
+		err = dbUsers(ctx, store, stdout)
+	case "passwd":
+		err = adminPasswd(ctx, store, rest, stdout, stderr)
+	case "purge-trash":
+		err = adminPurgeTrash(ctx, store, rest, stdout, stderr)
+	case "sizes":
+		err = adminSizes(ctx, store, rest, stdout, stderr)
+	case "verify":
+		err = adminVerify(ctx, store, rest, stdout, stderr)
+	case "migrate":
+		err = dbMigrate(ctx, store, rest, stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown admin command %q\n\n", cmd)
+		fs.Usage()
+		return 2
+	}
+	if err == flag.ErrHelp {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+		return 1
+	}
+	return 0
+}
+
+func adminPasswd(ctx context.Context, store *db.Store, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("passwd", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	userID := fs.Int64("user", 0, "user id")
+	password := fs.String("password", "", `new password; "-" reads it from stdin`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *userID == 0 {
+		return errors.New("-user is required")
+	}
+	u, err := store.GetUserSummary(ctx, *userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user %d", *userID)
+	}
+	if err != nil {
+		return err
+	}
+	generated := false
+	switch *password {
+	case "":
+		*password, generated = randomPassword(), true
+	case "-":
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if err := store.SetWebDAVPassword(ctx, u.UserID, *password); err != nil {
+		return err
+	}
+	name := u.Username
+	if name == "" {
+		name = "-"
+	}
+	fmt.Fprintf(stdout, "WebDAV password of user %d (%s) set\n", u.UserID, name)
+	if generated {
+		fmt.Fprintln(stdout, *password)
+	}
+	return nil
+}
+
+// randomPassword returns 16 random bytes in hex.
+func randomPassword() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func adminPurgeTrash(ctx context.Context, store *db.Store, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("purge-trash", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	userID := fs.Int64("user", 0, "only this user's trash")
+	olderThan := fs.Duration("older-than", 0, "only items deleted longer ago than this")
+	apply := fs.Bool("apply", false, "commit the change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	perUser, err := store.PurgeTrash(ctx, *userID, *olderThan, *apply)
+	if err != nil {
+		return err
+	}
+	users := make([]int64, 0, len(perUser))
+	var total int64
+	for id, n := range perUser {
+		users = append(users, id)
+		total += n
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USER\tITEMS")
+	for _, id := range users {
+		fmt.Fprintf(tw, "%d\t%d\n", id, perUser[id])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d trash item(s)\n", total)
+	reportApplied(stdout, *apply)
+	return nil
+}
+
+func adminSizes(ctx context.Context, store *db.Store, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("sizes", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	apply := fs.Bool("apply", false, "commit the change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fixes, err := store.RecomputeFileSizes(ctx, *apply)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tUSER\tRECORDED\tPARTS\tNAME")
+	for _, f := range fixes {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%s\n", f.FileID, f.UserID, f.Recorded, f.Parts, f.Name)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d file(s) with a wrong size\n", len(fixes))
+	reportApplied(stdout, *apply)
+	return nil
+}
+
+// adminVerify downloads files and compares them with their recorded size
+// and checksum, as the bot's Verify button does for one file.
+func adminVerify(ctx context.Context, store *db.Store, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	userID := fs.Int64("user", 0, "only this user's files")
+	limit := fs.Int("limit", 0, "verify at most this many files; 0 verifies all")
+	apply := fs.Bool("apply", false, "record the checksums of files without one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	tg, err := newTelegramClient(cfg)
+	if err != nil {
+		return err
+	}
+	var users []int64
+	if *userID != 0 {
+		users = []int64{*userID}
+	} else {
+		summaries, err := store.ListUserSummaries(ctx)
+		if err != nil {
+			return err
+		}
+		for _, u := range summaries {
+			users = append(users, u.UserID)
+		}
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tUSER\tSIZE\tRESULT\tNAME")
+	checked, bad, recorded := 0, 0, 0
+files:
+	for _, uid := range users {
+		files, err := store.ListAllFiles(ctx, uid)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if file.IsEmpty() {
+				continue
+			}
+			if *limit > 0 && checked >= *limit {
+				break files
+			}
+			checked++
+			sum, result := verifyDownload(ctx, tg, store, file)
+			switch {
+			case result != "":
+				bad++
+			case file.SHA256 == "" && *apply:
+				if err := store.SetFileChecksum(ctx, file.UserID, file.ID, sum); err != nil {
+					return err
+				}
+				recorded++
+				result = "ok, checksum recorded"
+			case file.SHA256 == "":
+				result = "ok, no checksum on record"
+			default:
+				result = "ok"
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%s\n", file.ID, file.UserID, file.Size, result, file.Name)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d file(s) verified, %d failed, %d checksum(s) recorded\n", checked, bad, recorded)
+	if bad > 0 {
+		return fmt.Errorf("%d file(s) failed verification", bad)
+	}
+	return nil
+}
+
+// verifyDownload reads a file from Telegram and returns its SHA-256, and
+// what is wrong with it, or "" when nothing.
+func verifyDownload(ctx context.Context, tg telegram.BotAPI, store *db.Store, file db.File) (string, string) {
+	r, err := webdav.OpenFileReader(ctx, tg, store, nil, file)
+	if err != nil {
+		return "", "error: " + err.Error()
+	}
+	defer r.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", "error: " + err.Error()
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	switch {
+	case n != file.Size:
+		return sum, fmt.Sprintf("size mismatch: read %d bytes", n)
+	case file.SHA256 != "" && sum != file.SHA256:
+		return sum, "checksum mismatch: " + sum
+	}
+	return sum, ""
+}
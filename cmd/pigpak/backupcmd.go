@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pigpak/internal/backup"
+	"pigpak/internal/config"
+	"pigpak/internal/db"
+)
+
+const backupUsage = `usage: pigpak backup [-db path] [-o file] [-encrypt]
+
+Writes a consistent snapshot of the database, with a manifest of the
+settings it depends on, while the bot keeps running. -encrypt protects the
+snapshot with BACKUP_PASSPHRASE. The default output is a timestamped file
+in DATA_DIR; "-o -" writes to stdout.
+
+flags:
+`
+
+const restoreUsage = `usage: pigpak restore [-db path] [-force] <file>
+
+Replaces the database with a snapshot from "pigpak backup" or the scheduled
+backup. Stop the bot first. Encrypted snapshots read BACKUP_PASSPHRASE. An
+existing database is only replaced with -force, and is kept next to it with
+a .pre-restore suffix.
+
+flags:
+`
+
+// runBackupCommand implements "pigpak backup" and returns the process exit
+// code.
+func runBackupCommand(args []string, stdout, stderr io.Writer) int {
+	dataDir, defaultPath := config.StoragePaths()
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, backupUsage)
+		fs.PrintDefaults()
+	}
+	path := fs.String("db", defaultPath, "database path")
+	out := fs.String("o", "", "output file")
+	encrypt := fs.Bool("encrypt", false, "encrypt with BACKUP_PASSPHRASE")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	passphrase := os.Getenv("BACKUP_PASSPHRASE")
+	if *encrypt && passphrase == "" {
+		fmt.Fprintln(stderr, "-encrypt needs BACKUP_PASSPHRASE")
+		return 2
+	}
+	if _, err := os.Stat(*path); err != nil {
+		fmt.Fprintf(stderr, "database %s: %v\n", *path, err)
+		return 1
+	}
+	store, err := db.Open(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "db open error: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	// The manifest is a convenience; a backup must not fail because the
+	// environment lacks BOT_TOKEN.
+	var settings map[string]string
+	if cfg, err := config.Load(); err == nil {
+		settings = backup.ConfigManifest(cfg)
+	}
+	if *out == "" {
+		name := "pigpak-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz"
+		if *encrypt {
+			name += ".enc"
+		}
+		*out = filepath.Join(dataDir, name)
+	}
+	if err := writeBackup(context.Background(), store, *out, dataDir, settings, *encrypt, passphrase, stdout); err != nil {
+		fmt.Fprintf(stderr, "backup: %v\n", err)
+		return 1
+	}
+	if *out != "-" {
+		fmt.Fprintf(stdout, "wrote %s\n", *out)
+	}
+	return 0
+}
+
+func writeBackup(ctx context.Context, store *db.Store, out, tmpDir string, settings map[string]string, encrypt bool, passphrase string, stdout io.Writer) (err error) {
+	w := stdout
+	if out != "-" {
+		f, err := os.OpenFile(out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(out)
+			}
+		}()
+		w = f
+	}
+	if encrypt {
+		enc, err := backup.NewEncrypter(w, passphrase)
+		if err != nil {
+			return err
+		}
+		if _, err := backup.Write(ctx, store, enc, tmpDir, settings); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	_, err = backup.Write(ctx, store, w, tmpDir, settings)
+	return err
+}
+
+// runRestoreCommand implements "pigpak restore" and returns the process
+// exit code.
+func runRestoreCommand(args []string, stdout, stderr io.Writer) int {
+	_, defaultPath := config.StoragePaths()
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, restoreUsage)
+		fs.PrintDefaults()
+	}
+	path := fs.String("db", defaultPath, "database path")
+	force := fs.Bool("force", false, "replace an existing database")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if _, err := os.Stat(*path); err == nil && !*force {
+		fmt.Fprintf(stderr, "database %s exists; rerun with -force to replace it\n", *path)
+		return 1
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "restore: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	m, err := backup.Restore(context.Background(), f, os.Getenv("BACKUP_PASSPHRASE"), *path)
+	if err != nil {
+		fmt.Fprintf(stderr, "restore: %v\n", err)
+		if errors.Is(err, backup.ErrBadPassphrase) || errors.Is(err, backup.ErrPassphraseRequired) {
+			return 2
+		}
+		return 1
+	}
+	fmt.Fprintf(stdout, "restored %s from snapshot of %s (schema version %d)\n", *path, m.CreatedAt.Format(time.RFC3339), m.SchemaVersion)
+	if chat := m.Config["STORAGE_CHAT_ID"]; chat != "" {
+		fmt.Fprintf(stdout, "the snapshot was taken with STORAGE_CHAT_ID=%s\n", chat)
+	}
+	return 0
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"pigpak/internal/config"
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+	"pigpak/internal/telegram/telegramtest"
+	"pigpak/internal/webdav"
+)
+
+const benchUsage = `usage: pigpak bench [flags]
+
+Uploads and downloads synthetic files through the WebDAV server, the
+Telegram client and a scratch database, then reports throughput, per-part
+request timings and allocations. By default Telegram is replaced by an
+in-process fake; -real uses BOT_TOKEN, TELEGRAM_API_URL and STORAGE_CHAT_ID
+from the environment and leaves the uploaded messages in the storage chat.
+
+flags:
+`
+
+const (
+	benchUserID   = 1
+	benchUsername = "bench"
+	benchPassword = "bench"
+)
+
+// runBench implements "pigpak bench" and returns the process exit code.
+func runBench(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, benchUsage)
+		fs.PrintDefaults()
+	}
+	sizesFlag := fs.String("sizes", "1MiB,16MiB,64MiB", "comma-separated file sizes")
+	partFlag := fs.String("part-size", "8MiB", "maximum part size; smaller than the files to exercise splitting")
+	runs := fs.Int("runs", 3, "uploads and downloads per size")
+	latency := fs.Duration("latency", 0, "delay added to every fake Telegram request")
+	useReal := fs.Bool("real", false, "use the Telegram Bot API from the environment")
+	seed := fs.Int64("seed", 1, "seed for the synthetic file contents")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	sizes, err := parseSizes(*sizesFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "-sizes: %v\n", err)
+		return 2
+	}
+	partSize, err := parseSize(*partFlag)
+	if err != nil {
+		fmt.Fprintf(stderr, "-part-size: %v\n", err)
+		return 2
+	}
+	if *runs < 1 {
+		fmt.Fprintln(stderr, "-runs must be at least 1")
+		return 2
+	}
+
+	var cfg config.Config
+	if *useReal {
+		if cfg, err = config.Load(); err != nil {
+			fmt.Fprintf(stderr, "config error: %v\n", err)
+			return 1
+		}
+		if cfg.StorageChatID == 0 {
+			fmt.Fprintln(stderr, "STORAGE_CHAT_ID is required with -real")
+			return 1
+		}
+	} else {
+		fake := telegramtest.NewServer()
+		fake.Latency = *latency
+		defer fake.Close()
+		cfg.BotToken = telegramtest.Token
+		cfg.TelegramAPIURL = fake.URL
+		cfg.StorageChatID = -100
+	}
+	cfg.MaxPartSizeBytes = partSize
+
+	b, err := newBench(cfg, *useReal)
+	if err != nil {
+		fmt.Fprintf(stderr, "bench setup: %v\n", err)
+		return 1
+	}
+	defer b.close()
+
+	target := "fake Telegram server"
+	if *useReal {
+		target = cfg.TelegramAPIURL
+	}
+	fmt.Fprintf(stdout, "target: %s, part size %s, %d run(s) per size, %s\n\n", target, formatSize(partSize), *runs, runtime.Version())
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "SIZE\tPARTS\tUPLOAD MB/s\tDOWNLOAD MB/s\tSEND min/avg/max\tFETCH min/avg/max\tALLOCS/run\tALLOC MB/run\t")
+	rng := rand.New(rand.NewSource(*seed))
+	for _, size := range sizes {
+		data := make([]byte, size)
+		rng.Read(data)
+		res, err := b.measure(context.Background(), data, *runs)
+		if err != nil {
+			tw.Flush()
+			fmt.Fprintf(stderr, "%s: %v\n", formatSize(int64(size)), err)
+			return 1
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.1f\t%s\t%s\t%d\t%.1f\t\n",
+			formatSize(int64(size)), res.parts,
+			throughput(size, res.upload), throughput(size, res.download),
+			res.send, res.fetch,
+			res.mallocs, float64(res.allocBytes)/(1<<20))
+	}
+	if err := tw.Flush(); err != nil {
+		return 1
+	}
+	fmt.Fprintln(stdout, "\nSEND is one sendDocument call, FETCH one file download; both include reading the body.")
+	if !*useReal {
+		fmt.Fprintln(stdout, "Allocations include the in-process fake Telegram server.")
+	}
+	return 0
+}
+
+type bench struct {
+	dir   string
+	store *db.Store
+	trips *tripRecorder
+	srv   *httptest.Server
+}
+
+func newBench(cfg config.Config, useReal bool) (*bench, error) {
+	dir, err := os.MkdirTemp("", "pigpak-bench-")
+	if err != nil {
+		return nil, err
+	}
+	b := &bench{dir: dir}
+	ok := false
+	defer func() {
+		if !ok {
+			b.close()
+		}
+	}()
+	if b.store, err = db.Open(filepath.Join(dir, "bench.db")); err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if _, err := b.store.EnsureUser(ctx, benchUserID); err != nil {
+		return nil, err
+	}
+	if err := b.store.UpsertUserProfile(ctx, benchUserID, benchUsername); err != nil {
+		return nil, err
+	}
+	if err := b.store.SetWebDAVPassword(ctx, benchUserID, benchPassword); err != nil {
+		return nil, err
+	}
+	tg := telegram.NewClient(cfg.BotToken, cfg.TelegramAPIURL, cfg.TelegramHTTPTimeout)
+	if !useReal {
+		// Telegram's flood limits do not apply to the fake server.
+		tg.Limiter = nil
+	}
+	b.trips = &tripRecorder{next: http.DefaultTransport}
+	if cfg.TelegramProxy != "" {
+		transport, err := telegram.ProxyTransport(cfg.TelegramProxy)
+		if err != nil {
+			return nil, err
+		}
+		b.trips.next = transport
+	}
+	tg.HTTP.Transport = b.trips
+	srv, err := webdav.NewServer(cfg, b.store, tg)
+	if err != nil {
+		return nil, err
+	}
+	b.srv = httptest.NewServer(srv.Handler())
+	ok = true
+	return b, nil
+}
+
+func (b *bench) close() {
+	if b.srv != nil {
+		b.srv.Close()
+	}
+	if b.store != nil {
+		b.store.Close()
+	}
+	os.RemoveAll(b.dir)
+}
+
+type benchResult struct {
+	parts      int
+	upload     time.Duration
+	download   time.Duration
+	send       tripStats
+	fetch      tripStats
+	mallocs    uint64
+	allocBytes uint64
+}
+
+// measure uploads and downloads data runs times and returns the averages.
+func (b *bench) measure(ctx context.Context, data []byte, runs int) (benchResult, error) {
+	var res benchResult
+	want := sha256.Sum256(data)
+	b.trips.reset()
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < runs; i++ {
+		url := fmt.Sprintf("%s/bench-%d-%d.bin", b.srv.URL, len(data), i)
+		start := time.Now()
+		if err := b.do(ctx, http.MethodPut, url, bytes.NewReader(data), int64(len(data)), nil); err != nil {
+			return res, fmt.Errorf("upload: %w", err)
+		}
+		res.upload += time.Since(start)
+
+		h := sha256.New()
+		start = time.Now()
+		if err := b.do(ctx, http.MethodGet, url, nil, 0, h); err != nil {
+			return res, fmt.Errorf("download: %w", err)
+		}
+		res.download += time.Since(start)
+		var got [sha256.Size]byte
+		h.Sum(got[:0])
+		if got != want {
+			return res, fmt.Errorf("run %d: downloaded content does not match", i+1)
+		}
+		if err := b.do(ctx, http.MethodDelete, url, nil, 0, nil); err != nil {
+			return res, fmt.Errorf("delete: %w", err)
+		}
+	}
+	runtime.ReadMemStats(&after)
+	res.upload /= time.Duration(runs)
+	res.download /= time.Duration(runs)
+	res.mallocs = (after.Mallocs - before.Mallocs) / uint64(runs)
+	res.allocBytes = (after.TotalAlloc - before.TotalAlloc) / uint64(runs)
+	res.send = b.trips.stats("sendDocument")
+	res.fetch = b.trips.stats("download")
+	res.parts = res.send.count / runs
+	return res, nil
+}
+
+func (b *bench) do(ctx context.Context, method, url string, body io.Reader, size int64, out io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	req.SetBasicAuth(benchUsername, benchPassword)
+	resp, err := b.srv.Client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		out = io.Discard
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return nil
+}
+
+// tripRecorder times Telegram requests by method, from sending the request
+// until the response body is closed.
+type tripRecorder struct {
+	next  http.RoundTripper
+	mu    sync.Mutex
+	trips map[string][]time.Duration
+}
+
+func (t *tripRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+	if strings.HasPrefix(req.URL.Path, "/file/") {
+		method = "download"
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.record(method, time.Since(start))
+		return nil, err
+	}
+	resp.Body = &timedBody{ReadCloser: resp.Body, done: func() { t.record(method, time.Since(start)) }}
+	return resp, nil
+}
+
+func (t *tripRecorder) record(method string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.trips == nil {
+		t.trips = make(map[string][]time.Duration)
+	}
+	t.trips[method] = append(t.trips[method], d)
+}
+
+func (t *tripRecorder) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trips = nil
+}
+
+func (t *tripRecorder) stats(method string) tripStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trips := append([]time.Duration(nil), t.trips[method]...)
+	if len(trips) == 0 {
+		return tripStats{}
+	}
+	sort.Slice(trips, func(i, j int) bool { return trips[i] < trips[j] })
+	var total time.Duration
+	for _, d := range trips {
+		total += d
+	}
+	return tripStats{
+		count: len(trips),
+		min:   trips[0],
+		avg:   total / time.Duration(len(trips)),
+		max:   trips[len(trips)-1],
+	}
+}
+
+type timedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+type tripStats struct {
+	count         int
+	min, avg, max time.Duration
+}
+
+func (s tripStats) String() string {
+	if s.count == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s/%s/%s", roundDuration(s.min), roundDuration(s.avg), roundDuration(s.max))
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+func throughput(size int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(size) / (1 << 20) / d.Seconds()
+}
+
+func parseSizes(value string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := parseSize(field)
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			return nil, fmt.Errorf("size %q must be positive", field)
+		}
+		sizes = append(sizes, int(n))
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("no sizes given")
+	}
+	return sizes, nil
+}
+
+// parseSize reads a byte count with an optional binary suffix such as
+// "512KiB" or "16MiB".
+func parseSize(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+		{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	mult := int64(1)
+	number := value
+	for _, u := range units {
+		if rest, ok := strings.CutSuffix(value, u.suffix); ok {
+			mult, number = u.mult, rest
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * mult, nil
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30 && n%(1<<30) == 0:
+		return fmt.Sprintf("%dGiB", n>>30)
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", n>>10)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
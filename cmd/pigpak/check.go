@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"text/tabwriter"
+	"time"
+
+	"pigpak/internal/config"
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+	"pigpak/internal/webhook"
+)
+
+const checkUsage = `usage: pigpak check [-timeout d]
+
+Loads the configuration and checks it without starting anything: the bot
+token's format and that Telegram accepts it, that the database can be
+written, that the bot can post to the storage chat and users' own storage
+chats, the WebDAV TLS certificate, and that webhook URLs can be reached.
+Opening the database applies pending migrations, as starting pigpak would.
+Webhooks are sent a HEAD request, not an event. The exit status is 1 when
+a check fails; warnings do not fail.
+
+flags:
+`
+
+// botTokenFormat is the shape of tokens @BotFather hands out: the bot's
+// user id, a colon and a 35-character secret.
+var botTokenFormat = regexp.MustCompile(`^[0-9]+:[A-Za-z0-9_-]{30,}$`)
+
+// certExpiryWarning is how long before expiry a certificate is reported.
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// checkReport collects the results of pigpak check.
+type checkReport struct {
+	tw     *tabwriter.Writer
+	failed int
+	warned int
+}
+
+func (r *checkReport) ok(check, format string, args ...any) {
+	r.line("ok", check, format, args...)
+}
+
+func (r *checkReport) warn(check, format string, args ...any) {
+	r.warned++
+	r.line("WARN", check, format, args...)
+}
+
+func (r *checkReport) fail(check, format string, args ...any) {
+	r.failed++
+	r.line("FAIL", check, format, args...)
+}
+
+func (r *checkReport) line(status, check, format string, args ...any) {
+	fmt.Fprintf(r.tw, "%s\t%s\t%s\n", status, check, fmt.Sprintf(format, args...))
+}
+
+// runCheckCommand implements "pigpak check" and returns the process exit
+// code.
+func runCheckCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, checkUsage)
+		fs.PrintDefaults()
+	}
+	timeout := fs.Duration("timeout", 10*time.Second, "limit for each network check")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	report := &checkReport{tw: tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)}
+	cfg, err := config.Load()
+	if err != nil {
+		report.fail("config", "%v", err)
+		return finishCheck(report, stdout)
+	}
+	report.ok("config", "loaded")
+
+	ctx := context.Background()
+	tg := checkTelegram(ctx, report, cfg, *timeout)
+	store := checkDatabase(report, cfg)
+	if store != nil {
+		defer store.Close()
+	}
+	if tg != nil {
+		checkStorageChats(ctx, report, cfg, tg, store, *timeout)
+	}
+	checkTLS(report, cfg)
+	if store != nil {
+		checkWebhooks(ctx, report, cfg, store, *timeout)
+	}
+	return finishCheck(report, stdout)
+}
+
+func finishCheck(report *checkReport, stdout io.Writer) int {
+	if err := report.tw.Flush(); err != nil {
+		return 1
+	}
+	fmt.Fprintf(stdout, "%d failed, %d warning(s)\n", report.failed, report.warned)
+	if report.failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkTelegram checks the bot token and returns a client for the checks
+// that need one, or nil when Telegram cannot be used.
+func checkTelegram(ctx context.Context, report *checkReport, cfg config.Config, timeout time.Duration) telegram.BotAPI {
+	if !botTokenFormat.MatchString(cfg.BotToken) {
+		report.fail("bot token", "does not look like a token from @BotFather (<bot id>:<secret>)")
+		return nil
+	}
+	tg, err := newTelegramClient(cfg)
+	if err != nil {
+		report.fail("bot token", "%v", err)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	me, err := tg.GetMe(ctx)
+	if err != nil {
+		report.fail("bot token", "getMe at %s: %v", cfg.TelegramAPIURL, err)
+		return nil
+	}
+	if cfg.BotUsername != "" && cfg.BotUsername != me.Username {
+		report.warn("bot token", "token is for @%s but BOT_USERNAME is %s", me.Username, cfg.BotUsername)
+		return tg
+	}
+	report.ok("bot token", "@%s (%d)", me.Username, me.ID)
+	return tg
+}
+
+// checkDatabase checks that the database and its directory can be written,
+// SQLite keeping its journal next to the file, and opens it.
+func checkDatabase(report *checkReport, cfg config.Config) *db.Store {
+	dir := filepath.Dir(cfg.DBPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		report.fail("database", "%v", err)
+		return nil
+	}
+	probe, err := os.CreateTemp(dir, ".pigpak-check-*")
+	if err != nil {
+		report.fail("database", "directory %s is not writable: %v", dir, err)
+		return nil
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	exists := true
+	if f, err := os.OpenFile(cfg.DBPath, os.O_RDWR, 0); err == nil {
+		f.Close()
+	} else if errors.Is(err, os.ErrNotExist) {
+		exists = false
+	} else {
+		report.fail("database", "%s is not writable: %v", cfg.DBPath, err)
+		return nil
+	}
+	store, err := db.Open(cfg.DBPath)
+	if err != nil {
+		report.fail("database", "open %s: %v", cfg.DBPath, err)
+		return nil
+	}
+	version, err := store.SchemaVersion(context.Background())
+	if err != nil {
+		report.fail("database", "%s: %v", cfg.DBPath, err)
+		store.Close()
+		return nil
+	}
+	if exists {
+		report.ok("database", "%s, schema version %d", cfg.DBPath, version)
+	} else {
+		report.ok("database", "%s created, schema version %d", cfg.DBPath, version)
+	}
+	return store
+}
+
+// checkStorageChats checks STORAGE_CHAT_ID and the users' own storage
+// chats the way /storage does, without the test message.
+func checkStorageChats(ctx context.Context, report *checkReport, cfg config.Config, tg telegram.BotAPI, store *db.Store, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	me, err := tg.GetMe(ctx)
+	if err != nil {
+		report.fail("storage chat", "getMe: %v", err)
+		return
+	}
+	if cfg.StorageChatID == 0 {
+		report.warn("storage chat", "STORAGE_CHAT_ID is not set; only users with their own storage chat can upload")
+	} else {
+		checkStorageChat(ctx, report, tg, me.ID, "storage chat", cfg.StorageChatID)
+	}
+	if store == nil {
+		return
+	}
+	users, err := store.ListUserSummaries(ctx)
+	if err != nil {
+		report.fail("user storage", "%v", err)
+		return
+	}
+	for _, u := range users {
+		us, err := store.GetUserStorage(ctx, u.UserID)
+		if err != nil || us.ChatID == cfg.StorageChatID {
+			continue
+		}
+		checkStorageChat(ctx, report, tg, me.ID, fmt.Sprintf("user %d storage", u.UserID), us.ChatID)
+	}
+}
+
+func checkStorageChat(ctx context.Context, report *checkReport, tg telegram.BotAPI, botID int64, check string, chatID int64) {
+	chat, err := tg.GetChat(ctx, chatID)
+	if err != nil {
+		report.fail(check, "chat %d: the bot cannot see it: %v", chatID, err)
+		return
+	}
+	member, err := tg.GetChatMember(ctx, chat.ID, botID)
+	if err != nil {
+		report.fail(check, "chat %d: %v", chatID, err)
+		return
+	}
+	name := fmt.Sprintf("%q (%d)", chat.Title, chat.ID)
+	switch {
+	case !member.IsMember():
+		report.fail(check, "%s: the bot is not in it", name)
+	case chat.Type == "channel" && (member.Status != "administrator" || !member.CanPostMessages):
+		report.fail(check, "%s: the bot is not an admin allowed to post", name)
+	case member.Status == "administrator" && !member.CanDeleteMessages:
+		report.warn(check, "%s: the bot cannot delete messages, so deleted files stay in the chat", name)
+	default:
+		report.ok(check, "%s, %s", name, chat.Type)
+	}
+}
+
+// checkTLS checks WEB_DAV_TLS_CERT: that it loads with its key, is valid
+// now and for a while, covers WEB_DAV_PUBLIC_URL and chains to a trusted
+// root.
+func checkTLS(report *checkReport, cfg config.Config) {
+	if len(cfg.WebDAVACMEDomains) > 0 {
+		report.ok("tls", "certificates for %v come from Let's Encrypt", cfg.WebDAVACMEDomains)
+		return
+	}
+	if cfg.WebDAVTLSCert == "" {
+		return
+	}
+	pair, err := tls.LoadX509KeyPair(cfg.WebDAVTLSCert, cfg.WebDAVTLSKey)
+	if err != nil {
+		report.fail("tls", "%v", err)
+		return
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		report.fail("tls", "%s: %v", cfg.WebDAVTLSCert, err)
+		return
+	}
+	now := time.Now()
+	switch {
+	case now.After(leaf.NotAfter):
+		report.fail("tls", "%s expired on %s", cfg.WebDAVTLSCert, leaf.NotAfter.Format(time.DateOnly))
+		return
+	case now.Before(leaf.NotBefore):
+		report.fail("tls", "%s is not valid before %s", cfg.WebDAVTLSCert, leaf.NotBefore.Format(time.DateOnly))
+		return
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range pair.Certificate[1:] {
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			intermediates.AddCert(cert)
+		}
+	}
+	opts := x509.VerifyOptions{Intermediates: intermediates}
+	if u, err := url.Parse(cfg.WebDAVPublicURL); err == nil && u.Hostname() != "" {
+		opts.DNSName = u.Hostname()
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		report.warn("tls", "%s: %v", cfg.WebDAVTLSCert, err)
+		return
+	}
+	if left := leaf.NotAfter.Sub(now); left < certExpiryWarning {
+		report.warn("tls", "%s expires on %s", cfg.WebDAVTLSCert, leaf.NotAfter.Format(time.DateOnly))
+		return
+	}
+	report.ok("tls", "%s, valid until %s", cfg.WebDAVTLSCert, leaf.NotAfter.Format(time.DateOnly))
+}
+
+// checkWebhooks probes every webhook's URL.
+func checkWebhooks(ctx context.Context, report *checkReport, cfg config.Config, store *db.Store, timeout time.Duration) {
+	hooks, err := store.ListAllWebhooks(ctx)
+	if err != nil {
+		report.fail("webhooks", "%v", err)
+		return
+	}
+	dispatcher := webhook.New(cfg, store)
+	for _, h := range hooks {
+		check := fmt.Sprintf("webhook %d", h.ID)
+		probeCtx, cancel := context.WithTimeout(ctx, timeout)
+		status, err := dispatcher.Probe(probeCtx, h.URL)
+		cancel()
+		if err != nil {
+			report.fail(check, "%s: %v", h.URL, err)
+			continue
+		}
+		report.ok(check, "%s answered %d", h.URL, status)
+	}
+}
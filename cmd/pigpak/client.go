@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"pigpak/internal/apierror"
+)
+
+const clientUsage = `usage: pigpak <command> [flags] <args>
+
+Talks to a pigpak server's REST API, for scripts and cron jobs:
+
+	pigpak ls [-l] [-json] [path]   list a folder
+	pigpak put <local> <path>       upload a file; "-" reads stdin
+	pigpak get [-o file] <path>     download a file; "-o -" writes stdout
+	pigpak rm [-r] <path>           move a file, or with -r a folder, to the trash
+	pigpak share [-days n] [-share-password p] <path>
+	                                share a file and print its link
+
+Paths start at the root of your primary drive. A put to a folder, or to a
+path ending in "/", keeps the local file's name; a put over an existing
+file replaces it. Downloads are checked against the stored SHA-256.
+
+Flags default to PIGPAK_REMOTE, the server's WebDAV URL, and PIGPAK_TOKEN,
+an API token from /tokens in the bot; PIGPAK_USER and PIGPAK_PASSWORD sign
+in with a WebDAV login instead.
+
+flags:
+`
+
+// clientCommands are the subcommands runClientCommand serves.
+var clientCommands = map[string]bool{"ls": true, "put": true, "get": true, "rm": true, "share": true}
+
+// remoteClient calls the REST API of one server.
+type remoteClient struct {
+	base     *url.URL
+	token    string
+	user     string
+	password string
+	http     *http.Client
+}
+
+type remoteDir struct {
+	PublicID  string    `json:"public_id"`
+	Name      string    `json:"name"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type remoteFile struct {
+	PublicID   string    `json:"public_id"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+type remoteChildren struct {
+	Dirs  []remoteDir  `json:"dirs"`
+	Files []remoteFile `json:"files"`
+}
+
+// remoteEntry is what a path names: a folder, a file, or, when both are
+// nil, nothing yet in the folder dirID.
+type remoteEntry struct {
+	dirID string
+	name  string
+	dir   *remoteDir
+	file  *remoteFile
+}
+
+// runClientCommand implements the client subcommands and returns the
+// process exit code.
+func runClientCommand(name string, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, clientUsage)
+		fs.PrintDefaults()
+	}
+	remote := fs.String("remote", os.Getenv("PIGPAK_REMOTE"), "server URL")
+	token := fs.String("token", os.Getenv("PIGPAK_TOKEN"), "API token")
+	user := fs.String("user", os.Getenv("PIGPAK_USER"), "WebDAV username, when no token is given")
+	password := fs.String("password", os.Getenv("PIGPAK_PASSWORD"), "WebDAV password")
+	timeout := fs.Duration("timeout", 0, "give up after this long; 0 waits as long as a transfer takes")
+	long := fs.Bool("l", false, "ls: show sizes and times")
+	asJSON := fs.Bool("json", false, "ls: print the API's JSON")
+	out := fs.String("o", "", "get: output file, default the file's name")
+	recursive := fs.Bool("r", false, "rm: remove folders too")
+	days := fs.Int("days", 0, "share: expire after this many days; 0 never expires")
+	sharePassword := fs.String("share-password", "", "share: password the link asks for")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	c, err := newRemoteClient(*remote, *token, *user, *password)
+	if err != nil {
+		fmt.Fprintf(stderr, "pigpak %s: %v\n", name, err)
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	rest := fs.Args()
+	switch {
+	case name == "ls" && len(rest) <= 1:
+		p := "/"
+		if len(rest) == 1 {
+			p = rest[0]
+		}
+		err = c.ls(ctx, stdout, p, *long, *asJSON)
+	case name == "put" && len(rest) == 2:
+		err = c.put(ctx, rest[0], rest[1])
+	case name == "get" && len(rest) == 1:
+		err = c.get(ctx, stdout, rest[0], *out)
+	case name == "rm" && len(rest) == 1:
+		err = c.rm(ctx, rest[0], *recursive)
+	case name == "share" && len(rest) == 1:
+		err = c.share(ctx, stdout, rest[0], *days, *sharePassword)
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "pigpak %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+func newRemoteClient(remote, token, user, password string) (*remoteClient, error) {
+	if remote == "" {
+		return nil, errors.New("no server; set -remote or PIGPAK_REMOTE")
+	}
+	base, err := url.Parse(strings.TrimRight(remote, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("remote %q is not an http(s) URL", remote)
+	}
+	if token == "" && (user == "" || password == "") {
+		return nil, errors.New("no credentials; set -token or PIGPAK_TOKEN, or -user and -password")
+	}
+	return &remoteClient{base: base, token: token, user: user, password: password, http: &http.Client{}}, nil
+}
+
+// url returns p, an escaped absolute path, under the server's URL.
+func (c *remoteClient) url(p string) string {
+	return c.base.String() + p
+}
+
+// call sends a JSON request to the API and decodes the JSON answer into
+// out, when set.
+func (c *remoteClient) call(ctx context.Context, method, p string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(p), r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	c.authorize(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return responseError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *remoteClient) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		return
+	}
+	req.SetBasicAuth(c.user, c.password)
+}
+
+// responseError turns an error answer into an error, with the message of
+// the API's error envelope when it has one.
+func responseError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var envelope struct {
+		Error apierror.Error `json:"error"`
+	}
+	if json.Unmarshal(data, &envelope) == nil && envelope.Error.Message != "" {
+		return fmt.Errorf("%s: %s", resp.Status, envelope.Error.Message)
+	}
+	if text := strings.TrimSpace(string(data)); text != "" && len(text) < 200 {
+		return fmt.Errorf("%s: %s", resp.Status, text)
+	}
+	return errors.New(resp.Status)
+}
+
+func (c *remoteClient) children(ctx context.Context, dirID string) (remoteChildren, error) {
+	var out remoteChildren
+	err := c.call(ctx, http.MethodGet, "/api/v1/dirs/"+dirID+"/children", nil, &out)
+	return out, err
+}
+
+// resolve walks p from the root of the primary drive. Every segment but
+// the last must be a folder; the last may be missing.
+func (c *remoteClient) resolve(ctx context.Context, p string) (remoteEntry, error) {
+	var root remoteDir
+	if err := c.call(ctx, http.MethodGet, "/api/v1/dirs/root", nil, &root); err != nil {
+		return remoteEntry{}, err
+	}
+	entry := remoteEntry{dirID: root.PublicID, dir: &root}
+	var segments []string
+	for _, seg := range strings.Split(p, "/") {
+		if seg != "" && seg != "." {
+			segments = append(segments, seg)
+		}
+	}
+	for i, seg := range segments {
+		if entry.dir == nil {
+			return remoteEntry{}, fmt.Errorf("%s: no such folder", "/"+path.Join(segments[:i]...))
+		}
+		list, err := c.children(ctx, entry.dir.PublicID)
+		if err != nil {
+			return remoteEntry{}, err
+		}
+		entry = remoteEntry{dirID: entry.dir.PublicID, name: seg}
+		for j := range list.Dirs {
+			if list.Dirs[j].Name == seg {
+				entry.dir = &list.Dirs[j]
+			}
+		}
+		for j := range list.Files {
+			if entry.dir == nil && list.Files[j].Name == seg {
+				entry.file = &list.Files[j]
+			}
+		}
+		if entry.file != nil && i < len(segments)-1 {
+			return remoteEntry{}, fmt.Errorf("%s: not a folder", "/"+path.Join(segments[:i+1]...))
+		}
+	}
+	return entry, nil
+}
+
+func (c *remoteClient) ls(ctx context.Context, w io.Writer, p string, long, asJSON bool) error {
+	entry, err := c.resolve(ctx, p)
+	if err != nil {
+		return err
+	}
+	var list remoteChildren
+	switch {
+	case entry.dir != nil:
+		if list, err = c.children(ctx, entry.dir.PublicID); err != nil {
+			return err
+		}
+	case entry.file != nil:
+		list = remoteChildren{Dirs: []remoteDir{}, Files: []remoteFile{*entry.file}}
+	default:
+		return fmt.Errorf("%s: not found", p)
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+	if !long {
+		for _, d := range list.Dirs {
+			fmt.Fprintln(w, d.Name+"/")
+		}
+		for _, f := range list.Files {
+			fmt.Fprintln(w, f.Name)
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, d := range list.Dirs {
+		fmt.Fprintf(tw, "-\t%s\t%s/\t\n", d.UpdatedAt.Local().Format("2006-01-02 15:04"), d.Name)
+	}
+	for _, f := range list.Files {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t\n", f.Size, f.ModifiedAt.Local().Format("2006-01-02 15:04"), f.Name)
+	}
+	return tw.Flush()
+}
+
+type remoteUpload struct {
+	Path     string `json:"path"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// put uploads local to p. The API hands out a temporary WebDAV login for
+// the target folder, and the content goes up with a WebDAV PUT.
+func (c *remoteClient) put(ctx context.Context, local, p string) error {
+	var body io.Reader
+	size := int64(-1)
+	name := filepath.Base(local)
+	if local == "-" {
+		body, name = os.Stdin, ""
+	} else {
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a folder", local)
+		}
+		body, size = f, info.Size()
+	}
+	entry, err := c.resolve(ctx, p)
+	if err != nil {
+		return err
+	}
+	dirID := entry.dirID
+	switch {
+	case entry.dir != nil:
+		dirID = entry.dir.PublicID
+	case strings.HasSuffix(p, "/"):
+		return fmt.Errorf("%s: no such folder", p)
+	default:
+		name = entry.name
+	}
+	if name == "" {
+		return errors.New("uploading stdin needs a file name in the path")
+	}
+	var up remoteUpload
+	if err := c.call(ctx, http.MethodPost, "/api/v1/uploads", map[string]any{"dir_id": dirID, "name": name}, &up); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.url(up.Path), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.SetBasicAuth(up.Username, up.Password)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// get downloads p to out, the file's name by default, through a temporary
+// file so a failed download leaves nothing behind.
+func (c *remoteClient) get(ctx context.Context, stdout io.Writer, p, out string) error {
+	entry, err := c.resolve(ctx, p)
+	if err != nil {
+		return err
+	}
+	if entry.file == nil {
+		if entry.dir != nil {
+			return fmt.Errorf("%s is a folder", p)
+		}
+		return fmt.Errorf("%s: not found", p)
+	}
+	file := entry.file
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/api/v1/files/"+file.PublicID+"/download"), nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if out == "-" {
+		return copyChecked(stdout, resp.Body, *file)
+	}
+	if out == "" {
+		out = file.Name
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(out), "."+filepath.Base(out)+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := copyChecked(tmp, resp.Body, *file); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	_ = os.Chtimes(tmp.Name(), file.ModifiedAt, file.ModifiedAt)
+	return os.Rename(tmp.Name(), out)
+}
+
+// copyChecked copies a download, failing when its size or SHA-256 differ
+// from the file's.
+func copyChecked(w io.Writer, r io.Reader, file remoteFile) error {
+	sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, sum), r)
+	if err != nil {
+		return err
+	}
+	if n != file.Size {
+		return fmt.Errorf("download of %s ended after %d of %d bytes", file.Name, n, file.Size)
+	}
+	if file.SHA256 != "" && hex.EncodeToString(sum.Sum(nil)) != file.SHA256 {
+		return fmt.Errorf("download of %s does not match its SHA-256", file.Name)
+	}
+	return nil
+}
+
+func (c *remoteClient) rm(ctx context.Context, p string, recursive bool) error {
+	entry, err := c.resolve(ctx, p)
+	if err != nil {
+		return err
+	}
+	switch {
+	case entry.file != nil:
+		return c.call(ctx, http.MethodDelete, "/api/v1/files/"+entry.file.PublicID, nil, nil)
+	case entry.dir != nil && entry.name == "":
+		return errors.New("cannot remove the root folder")
+	case entry.dir != nil && !recursive:
+		return fmt.Errorf("%s is a folder; use -r", p)
+	case entry.dir != nil:
+		return c.call(ctx, http.MethodDelete, "/api/v1/dirs/"+entry.dir.PublicID, nil, nil)
+	}
+	return fmt.Errorf("%s: not found", p)
+}
+
+type remoteShare struct {
+	Path string `json:"path"`
+	URL  string `json:"url"`
+}
+
+// share shares the file p and prints its link: the server's public URL
+// when it has one, otherwise the link under -remote.
+func (c *remoteClient) share(ctx context.Context, w io.Writer, p string, days int, password string) error {
+	entry, err := c.resolve(ctx, p)
+	if err != nil {
+		return err
+	}
+	if entry.file == nil {
+		if entry.dir != nil {
+			return fmt.Errorf("%s is a folder; only files can be shared", p)
+		}
+		return fmt.Errorf("%s: not found", p)
+	}
+	var share remoteShare
+	req := map[string]any{"file_id": entry.file.PublicID, "expires_in_days": days, "password": password}
+	if err := c.call(ctx, http.MethodPost, "/api/v1/shares", req, &share); err != nil {
+		return err
+	}
+	link := share.URL
+	if link == "" {
+		link = c.url(share.Path)
+	}
+	fmt.Fprintln(w, link)
+	return nil
+}
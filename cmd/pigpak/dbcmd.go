@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"pigpak/internal/config"
+	"pigpak/internal/db"
+)
+
+const dbUsage = `usage: pigpak db [-db path] <command> [flags]
+
+Operates on the database directly, for recovery when the bot cannot run.
+Stop the bot first. Commands that change data only report what they would
+do unless -apply is given.
+
+commands:
+  users                                      list users and their storage
+  move-subtree -dir ID -to USER [-parent ID] hand a folder over to another user
+  rewrite-chat -from CHAT -to CHAT           point storage records at a new chat id
+  orphans [-older-than 24h]                  file abandoned WebDAV uploads under
+                                             "/Recovered uploads" and drop dangling parts
+  schema                                     list schema migrations and which are applied
+  migrate -to VERSION                        apply or revert migrations up to VERSION
+  audit [-user ID] [-action A] [-since 24h]  show the audit log, newest first; an action
+        [-limit N]                           ending in "." matches a group, e.g. "file."
+`
+
+// runDBCommand implements "pigpak db" and returns the process exit code.
+func runDBCommand(args []string, stdout, stderr io.Writer) int {
+	_, defaultPath := config.StoragePaths()
+	fs := flag.NewFlagSet("db", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, dbUsage) }
+	path := fs.String("db", defaultPath, "database path")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if _, err := os.Stat(*path); err != nil {
+		fmt.Fprintf(stderr, "database %s: %v\n", *path, err)
+		return 1
+	}
+	store, err := db.Open(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "db open error: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	ctx := db.WithActor(context.Background(), db.AuditSourceCLI, 0)
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "users":
+		err = dbUsers(ctx, store, stdout)
+	case "move-subtree":
+		err = dbMoveSubtree(ctx, store, rest, stdout, stderr)
+	case "rewrite-chat":
+		err = dbRewriteChat(ctx, store, rest, stdout, stderr)
+	case "orphans":
+		err = dbOrphans(ctx, store, rest, stdout, stderr)
+	case "schema":
+		err = dbSchema(ctx, store, stdout)
+	case "migrate":
+		err = dbMigrate(ctx, store, rest, stdout, stderr)
+	case "audit":
+		err = dbAudit(ctx, store, rest, stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown db command %q\n\n", cmd)
+		fs.Usage()
+		return 2
+	}
+	if err == flag.ErrHelp {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+		return 1
+	}
+	return 0
+}
+
+func dbUsers(ctx context.Context, store *db.Store, stdout io.Writer) error {
+	users, err := store.ListUserSummaries(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USER\tUSERNAME\tDRIVES\tFOLDERS\tFILES\tBYTES\tCREATED")
+	for _, u := range users {
+		name := u.Username
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d\t%d\t%s\n", u.UserID, name, u.Drives, u.Dirs, u.Files, u.Bytes, u.CreatedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func dbMoveSubtree(ctx context.Context, store *db.Store, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("move-subtree", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dirID := fs.Int64("dir", 0, "folder id to move")
+	toUser := fs.Int64("to", 0, "receiving user id")
+	parentID := fs.Int64("parent", 0, "receiving folder id (default: the user's root)")
+	apply := fs.Bool("apply", false, "commit the change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dirID == 0 || *toUser == 0 {
+		return fmt.Errorf("-dir and -to are required")
+	}
+	move, err := store.MoveSubtreeToUser(ctx, *dirID, *toUser, *parentID, *apply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "folder %d: user %d -> user %d, under folder %d\n", move.DirID, move.FromUserID, move.ToUserID, move.ParentID)
+	fmt.Fprintf(stdout, "%d folder(s), %d file(s), %d bytes\n", move.Dirs, move.Files, move.Bytes)
+	fmt.Fprintf(stdout, "%d rule/favorite/page/request/shortcut/login setting(s) of user %d dropped\n", move.Dropped, move.FromUserID)
+	reportApplied(stdout, *apply)
+	return nil
+}
+
+func dbRewriteChat(ctx context.Context, store *db.Store, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("rewrite-chat", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	from := fs.Int64("from", 0, "old storage chat id")
+	to := fs.Int64("to", 0, "new storage chat id")
+	apply := fs.Bool("apply", false, "commit the change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == 0 || *to == 0 || *from == *to {
+		return fmt.Errorf("-from and -to must be two different chat ids")
+	}
+	out, err := store.RewriteStorageChat(ctx, *from, *to, *apply)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "chat %d -> %d\n", *from, *to)
+	fmt.Fprintf(stdout, "drives: %d\nuser storage chats: %d\nblobs: %d\nblob copies: %d\nupload parts: %d\nfile messages: %d\nqueued deletions: %d\n", out.Drives, out.UserStorage, out.Blobs, out.Copies, out.UploadParts, out.FileMessages, out.Deletions)
+	fmt.Fprintln(stdout, "STORAGE_CHAT_ID is not stored in the database; update it in the environment if it named the old chat.")
+	reportApplied(stdout, *apply)
+	return nil
+}
+
+func dbOrphans(ctx context.Context, store *db.Store, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("orphans", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	olderThan := fs.Duration("older-than", 24*time.Hour, "only recover uploads untouched for this long")
+	apply := fs.Bool("apply", false, "commit the change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	recovered, dropped, err := store.RecoverAbandonedUploads(ctx, *olderThan, *apply)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "UPLOAD\tUSER\tPARTS\tBYTES\tRESULT")
+	for _, r := range recovered {
+		result := r.Path
+		if r.Skipped != "" {
+			result = "removed: " + r.Skipped
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%s\n", r.UploadID, r.UserID, r.Parts, r.Bytes, result)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d abandoned upload(s), %d dangling part row(s)\n", len(recovered), dropped)
+	reportApplied(stdout, *apply)
+	return nil
+}
+
+func dbSchema(ctx context.Context, store *db.Store, stdout io.Writer) error {
+	list, err := store.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tREVERSIBLE\tAPPLIED")
+	for _, m := range list {
+		applied := "-"
+		if m.AppliedAt.Valid {
+			applied = m.AppliedAt.Time.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%t\t%s\n", m.Version, m.Name, m.Reversible, applied)
+	}
+	return tw.Flush()
+}
+
+func dbMigrate(ctx context.Context, store *db.Store, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	to := fs.Int("to", db.LatestSchemaVersion(), "target schema version")
+	apply := fs.Bool("apply", false, "commit the change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	current, err := store.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "schema version %d -> %d\n", current, *to)
+	if *apply {
+		if err := store.MigrateTo(ctx, *to); err != nil {
+			return err
+		}
+	}
+	reportApplied(stdout, *apply)
+	return nil
+}
+
+func dbAudit(ctx context.Context, store *db.Store, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	userID := fs.Int64("user", 0, "only events on this user's data")
+	action := fs.String("action", "", "only this action, or group ending in \".\"")
+	since := fs.Duration("since", 0, "only events this recent")
+	limit := fs.Int("limit", 50, "maximum events to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	q := db.AuditQuery{UserID: *userID, Action: *action, Limit: *limit}
+	if *since > 0 {
+		q.Since = time.Now().Add(-*since)
+	}
+	events, err := store.ListAudit(ctx, q)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tUSER\tACTOR\tSOURCE\tACTION\tTARGET\tDETAIL")
+	for _, ev := range events {
+		target := ev.Target
+		if ev.TargetID != 0 {
+			target = fmt.Sprintf("%s (%d)", target, ev.TargetID)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n", ev.ID, ev.CreatedAt.Format(time.RFC3339), ev.UserID, ev.ActorID, ev.Source, ev.Action, target, ev.Detail)
+	}
+	return tw.Flush()
+}
+
+func reportApplied(w io.Writer, apply bool) {
+	if apply {
+		fmt.Fprintln(w, "applied")
+		return
+	}
+	fmt.Fprintln(w, "dry run: nothing changed, rerun with -apply")
+}
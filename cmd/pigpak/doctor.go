@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"pigpak/internal/config"
+	"pigpak/internal/db"
+)
+
+const doctorUsage = `usage: pigpak doctor [-db path] [-repair]
+
+Checks the database for rows that point at something missing or at another
+user's data: file and upload parts without their file or upload, files and
+folders whose folder is gone, drives without a root, users whose current
+folder is gone and blob reference counts that drifted. With -repair the
+problems are fixed in one transaction: misplaced files and folders move to
+"/Lost and found" of their owner and dangling rows are dropped. Stop the bot
+before repairing. The exit status is 1 while problems remain.
+
+flags:
+`
+
+// runDoctorCommand implements "pigpak doctor" and returns the process exit
+// code.
+func runDoctorCommand(args []string, stdout, stderr io.Writer) int {
+	_, defaultPath := config.StoragePaths()
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, doctorUsage)
+		fs.PrintDefaults()
+	}
+	path := fs.String("db", defaultPath, "database path")
+	repair := fs.Bool("repair", false, "fix the problems found")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if _, err := os.Stat(*path); err != nil {
+		fmt.Fprintf(stderr, "database %s: %v\n", *path, err)
+		return 1
+	}
+	store, err := db.Open(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "db open error: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	ctx := db.WithActor(context.Background(), db.AuditSourceCLI, 0)
+	var problems []db.IntegrityProblem
+	if *repair {
+		problems, err = store.RepairIntegrity(ctx)
+	} else {
+		problems, err = store.CheckIntegrity(ctx)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "doctor: %v\n", err)
+		return 1
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(stdout, "no problems found")
+		return 0
+	}
+	for _, p := range problems {
+		fmt.Fprintln(stdout, p)
+		if *repair {
+			fmt.Fprintf(stdout, "  repaired %d\n", p.Repaired)
+		}
+	}
+	if *repair {
+		return 0
+	}
+	fmt.Fprintln(stdout, "rerun with -repair to fix them")
+	return 1
+}
+
+// logIntegrity runs the integrity checks at startup and logs what they find.
+// Problems are only reported; repairing them is left to "pigpak doctor".
+func logIntegrity(store *db.Store) {
+	problems, err := store.CheckIntegrity(context.Background())
+	if err != nil {
+		log.Printf("integrity check failed: %v", err)
+		return
+	}
+	for _, p := range problems {
+		log.Printf("integrity: %s", p)
+	}
+	if len(problems) > 0 {
+		log.Printf("integrity: stop the bot and run \"pigpak doctor -repair\" to fix %d problem(s)", len(problems))
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"pigpak/internal/config"
+)
+
+const mainUsage = `usage: pigpak [flags] [command]
+
+Without a command, pigpak runs the bot, and with WEB_DAV_ENABLE the WebDAV
+server, API and gateway next to it. Settings come from, in order of
+precedence, these flags, the environment, the --config file and the
+defaults; see .env.example for all of them.
+
+commands:
+  check                    check the configuration without starting anything
+  db, admin, doctor        inspect and repair the database offline
+  backup, restore          snapshot the database and restore a snapshot
+  ls, put, get, rm, share  talk to a server's REST API
+  bench                    benchmark a server
+  openapi                  print the OpenAPI document of the REST API
+
+Run "pigpak <command> -h" for a command's flags.
+
+flags:
+`
+
+// version is the release, set at build time with
+// -ldflags "-X main.version=v1.2.3"; otherwise the module version or VCS
+// revision Go recorded is shown.
+var version = ""
+
+// globalFlag is a flag that sets an environment variable, taking
+// precedence over the variable and the config file.
+type globalFlag struct {
+	name, env, usage string
+}
+
+var globalFlags = []globalFlag{
+	{"data-dir", "DATA_DIR", "directory for the database, caches and certificates"},
+	{"db-path", "DB_PATH", "database path; defaults to bot.db in -data-dir"},
+	{"webdav-addr", "WEB_DAV_ADDR", "listen address of the WebDAV server, API and gateway"},
+	{"admin-socket", "ADMIN_SOCKET", "Unix socket to serve the admin gRPC service on"},
+	{"log-level", "LOG_LEVEL", `"info", or "debug" to add source locations and microseconds`},
+}
+
+// parseGlobalFlags parses the flags before the command, applies them and
+// the config file to the environment, and returns the command and its
+// arguments. exit is -1 to carry on, or the status to exit with.
+func parseGlobalFlags(args []string, stdout, stderr io.Writer) ([]string, int) {
+	fs := flag.NewFlagSet("pigpak", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, mainUsage)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", os.Getenv("PIGPAK_CONFIG"), "YAML or TOML config file (PIGPAK_CONFIG)")
+	showVersion := fs.Bool("version", false, "print the version and exit")
+	values := make(map[string]*string, len(globalFlags))
+	for _, f := range globalFlags {
+		values[f.name] = fs.String(f.name, "", f.usage+" ("+f.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, 0
+		}
+		return nil, 2
+	}
+	if *showVersion {
+		fmt.Fprintln(stdout, versionString())
+		return nil, 0
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, g := range globalFlags {
+			if g.name == f.Name && err == nil {
+				err = os.Setenv(g.env, *values[g.name])
+			}
+		}
+	})
+	if err == nil && *configPath != "" {
+		err = config.ApplyFile(*configPath)
+	}
+	if err == nil {
+		err = configureLogging(os.Getenv("LOG_LEVEL"))
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "config error: %v\n", err)
+		return nil, 1
+	}
+	return fs.Args(), -1
+}
+
+// configureLogging applies LOG_LEVEL. pigpak logs everything at one level;
+// debug only adds where each line comes from.
+func configureLogging(level string) error {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "", "info":
+	case "debug":
+		log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+	default:
+		return fmt.Errorf("unknown LOG_LEVEL %q; use info or debug", level)
+	}
+	return nil
+}
+
+// versionString describes the build for --version.
+func versionString() string {
+	v, revision, modified := version, "", false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+	}
+	if v == "" {
+		v = "devel"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	// Pseudo-versions carry the revision already.
+	if revision != "" && !strings.Contains(v, revision) {
+		if modified {
+			revision += "-dirty"
+		}
+		v += " (" + revision + ")"
+	}
+	return fmt.Sprintf("pigpak %s %s %s/%s", v, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pigpak/internal/config"
+)
+
+func TestSettingPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("[web_dav]\naddr = \":3000\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+		env  string
+		want string
+	}{
+		{"defaults", nil, "", ":8081"},
+		{"file over defaults", []string{"-config", path}, "", ":3000"},
+		{"environment over file", []string{"-config", path}, ":2000", ":2000"},
+		{"flag over environment and file", []string{"-config", path, "-webdav-addr", ":1000"}, ":2000", ":1000"},
+		{"flag over file", []string{"-webdav-addr", ":1000", "-config", path}, "", ":1000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BOT_TOKEN", "123:abc")
+			t.Setenv("PIGPAK_CONFIG", "")
+			t.Setenv("WEB_DAV_ADDR", tt.env)
+			if tt.env == "" {
+				os.Unsetenv("WEB_DAV_ADDR")
+			}
+
+			if _, exit := parseGlobalFlags(tt.args, io.Discard, io.Discard); exit != -1 {
+				t.Fatalf("parseGlobalFlags(%q) exited with %d", tt.args, exit)
+			}
+			cfg, err := config.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.WebDAVAddr != tt.want {
+				t.Fatalf("WebDAVAddr = %q, want %q", cfg.WebDAVAddr, tt.want)
+			}
+		})
+	}
+}
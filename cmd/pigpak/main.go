@@ -2,20 +2,68 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
 	"syscall"
 	"time"
 
+	"pigpak/internal/adminrpc"
+	"pigpak/internal/api"
+	"pigpak/internal/auth"
 	"pigpak/internal/bot"
 	"pigpak/internal/config"
 	"pigpak/internal/db"
+	"pigpak/internal/diag"
+	"pigpak/internal/gateway"
 	"pigpak/internal/telegram"
 	"pigpak/internal/webdav"
+	"pigpak/internal/webhook"
+	"pigpak/internal/webui"
 )
 
 func main() {
+	rest, exit := parseGlobalFlags(os.Args[1:], os.Stdout, os.Stderr)
+	if exit >= 0 {
+		os.Exit(exit)
+	}
+	os.Args = append(os.Args[:1], rest...)
+	if len(os.Args) > 1 && os.Args[1] == "db" {
+		os.Exit(runDBCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(runAdminCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		os.Exit(runBackupCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(runRestoreCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctorCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheckCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "openapi" {
+		os.Exit(runOpenAPICommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && clientCommands[os.Args[1]] {
+		os.Exit(runClientCommand(os.Args[1], os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 {
+		fmt.Fprintf(os.Stderr, "unknown command %q; see pigpak -h\n", os.Args[1])
+		os.Exit(2)
+	}
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("config error: %v", err)
@@ -29,21 +77,64 @@ func main() {
 		log.Fatalf("db open error: %v", err)
 	}
 	defer store.Close()
+	logIntegrity(store)
 
-	tg := telegram.NewClient(cfg.BotToken, cfg.TelegramAPIURL, cfg.TelegramHTTPTimeout)
+	tg, err := newTelegramClient(cfg)
+	if err != nil {
+		log.Fatalf("telegram proxy error: %v", err)
+	}
+	if err := applyChatMigrations(&cfg, store, tg); err != nil {
+		log.Fatalf("chat migrations error: %v", err)
+	}
 	botRunner := bot.New(cfg, store, tg)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	var srv *webdav.Server
 	if cfg.WebDAVEnable {
-		srv, err := webdav.NewServer(cfg, store, tg)
+		srv, err = webdav.NewServer(cfg, store, tg)
 		if err != nil {
 			log.Fatalf("webdav error: %v", err)
 		}
+		secret := auth.ResolveSecret(cfg.SessionSecret, cfg.BotToken)
+		sessions, err := auth.NewSessions(secret, cfg.SessionTTL)
+		if err != nil {
+			log.Fatalf("session error: %v", err)
+		}
+		srv.Handle("/auth/", &auth.Handler{
+			BotToken: cfg.BotToken,
+			Sessions: sessions,
+			OnLogin: func(ctx context.Context, user auth.TelegramUser) error {
+				if _, err := store.EnsureUser(ctx, user.ID); err != nil {
+					return err
+				}
+				if err := store.UpsertUserProfile(ctx, user.ID, user.Username); err != nil {
+					return err
+				}
+				if err := store.RecordAudit(ctx, db.AuditEvent{UserID: user.ID, ActorID: user.ID, Source: db.AuditSourceAPI, Action: db.AuditAuthLogin, Target: user.Username}); err != nil {
+					log.Printf("login audit: %v", err)
+				}
+				return nil
+			},
+		})
+		signer, err := auth.NewSigner(secret)
+		if err != nil {
+			log.Fatalf("signer error: %v", err)
+		}
+		cors := api.CORS{Origins: cfg.CORSOrigins, Headers: cfg.CORSHeaders, MaxAge: cfg.CORSMaxAge}
+		srv.Handle("/api/", cors.Wrap(&api.Handler{Store: store, Sessions: sessions, PublicURL: cfg.WebDAVPublicURL, Signer: signer, Uploads: srv}))
+		public := cors.Wrap(&gateway.Handler{Store: store, Telegram: tg, Signer: signer, Cache: srv.PartCache()})
+		srv.Handle("/dl/", public)
+		srv.Handle("/thumb/", public)
+		srv.Handle("/p/", public)
+		srv.Handle("/s/", public)
+		srv.Handle("/stream/", public)
+		srv.Handle("/zip/", public)
+		srv.Handle("/ui/", &webui.Handler{BotUsername: cfg.BotUsername, Telegram: tg})
 		go func() {
 			log.Printf("webdav listening on %s", cfg.WebDAVAddr)
-			if err := srv.ListenAndServe(); err != nil {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 				log.Printf("webdav server stopped: %v", err)
 			}
 		}()
@@ -51,14 +142,101 @@ func main() {
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	webdavDone := make(chan struct{})
 	go func() {
 		<-sigCh
 		cancel()
-		time.Sleep(1 * time.Second)
-		os.Exit(0)
+		if srv != nil {
+			shutdownCtx, stop := context.WithTimeout(context.Background(), cfg.WebDAVShutdownTimeout)
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Printf("webdav shutdown: %v", err)
+			}
+			stop()
+		}
+		close(webdavDone)
+	}()
+	go func() {
+		// Run and Shutdown return once their work drains; this only
+		// bounds a shutdown that hangs anyway.
+		<-ctx.Done()
+		time.Sleep(max(bot.DrainTimeout, cfg.WebDAVShutdownTimeout) + 10*time.Second)
+		log.Printf("shutdown timed out")
+		os.Exit(1)
 	}()
 
+	go webhook.New(cfg, store).Run(ctx)
+	if cfg.AdminSocket != "" {
+		admin := &adminrpc.Server{Store: store, Bot: botRunner, Uploads: srv, Started: time.Now()}
+		go func() {
+			log.Printf("admin service listening on %s", cfg.AdminSocket)
+			if err := admin.ListenAndServe(ctx, cfg.AdminSocket); err != nil {
+				log.Printf("admin service stopped: %v", err)
+			}
+		}()
+	}
+	if cfg.DebugAddr != "" {
+		expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+		if srv != nil {
+			expvar.Publish("uploads_running", expvar.Func(func() any { return srv.UploadsRunning() }))
+			if cache := srv.PartCache(); cache != nil {
+				expvar.Publish("part_cache_bytes", expvar.Func(func() any { return cache.Size() }))
+			}
+		}
+		go func() {
+			log.Printf("diagnostics listening on %s", cfg.DebugAddr)
+			if err := diag.ListenAndServe(ctx, cfg.DebugAddr, cfg.DebugToken); err != nil {
+				log.Printf("diagnostics server stopped: %v", err)
+			}
+		}()
+	}
+
 	if err := botRunner.Run(ctx); err != nil {
 		log.Printf("bot stopped: %v", err)
 	}
+	if ctx.Err() != nil {
+		<-webdavDone
+	}
+}
+
+// newTelegramClient returns a Bot API client set up from cfg.
+func newTelegramClient(cfg config.Config) (*telegram.Client, error) {
+	tg := telegram.NewClient(cfg.BotToken, cfg.TelegramAPIURL, cfg.TelegramHTTPTimeout)
+	tg.LocalMode = cfg.TelegramLocalAPI
+	tg.MaxResumes = cfg.TelegramDownloadResumes
+	if len(cfg.AllowedUpdates) > 0 {
+		tg.AllowedUpdates = cfg.AllowedUpdates
+	}
+	if cfg.TelegramProxy != "" {
+		transport, err := telegram.ProxyTransport(cfg.TelegramProxy)
+		if err != nil {
+			return nil, err
+		}
+		tg.HTTP.Transport = transport
+	}
+	return tg, nil
+}
+
+// applyChatMigrations redirects storage chats Telegram upgraded to
+// supergroups, both those recorded earlier and those reported from now on.
+func applyChatMigrations(cfg *config.Config, store *db.Store, tg *telegram.Client) error {
+	migrations, err := store.ChatMigrations(context.Background())
+	if err != nil {
+		return err
+	}
+	for from, to := range migrations {
+		tg.SetChatMigration(from, to)
+	}
+	if to := tg.ResolveChat(cfg.StorageChatID); to != cfg.StorageChatID {
+		log.Printf("STORAGE_CHAT_ID %d was upgraded to supergroup %d; update the environment", cfg.StorageChatID, to)
+		cfg.StorageChatID = to
+	}
+	tg.OnChatMigrated = func(from, to int64) {
+		out, err := store.MigrateStorageChat(context.Background(), from, to)
+		if err != nil {
+			log.Printf("chat %d migrated to %d, storage records not updated: %v", from, to, err)
+			return
+		}
+		log.Printf("chat %d migrated to %d: %d drive(s), %d blob(s), %d copy(ies) updated", from, to, out.Drives, out.Blobs, out.Copies)
+	}
+	return nil
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"pigpak/internal/api"
+)
+
+const openAPIUsage = `usage: pigpak openapi [-o file]
+
+Writes the OpenAPI 3 document of the REST API, the one the server answers
+GET /api/v1/openapi.json with, for generating client SDKs without a
+running server.
+
+flags:
+`
+
+// runOpenAPICommand implements "pigpak openapi" and returns the process
+// exit code.
+func runOpenAPICommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("openapi", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, openAPIUsage)
+		fs.PrintDefaults()
+	}
+	out := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	doc, err := json.MarshalIndent(api.OpenAPI(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(stderr, "openapi: %v\n", err)
+		return 1
+	}
+	doc = append(doc, '\n')
+	if *out == "" {
+		_, _ = stdout.Write(doc)
+		return 0
+	}
+	if err := os.WriteFile(*out, doc, 0o644); err != nil {
+		fmt.Fprintf(stderr, "openapi: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -3,6 +3,11 @@ module pigpak
 go 1.22
 
 require (
+	github.com/BurntSushi/toml v1.4.0
+	golang.org/x/crypto v0.28.0
 	golang.org/x/net v0.30.0
+	google.golang.org/grpc v1.67.1
+	google.golang.org/protobuf v1.35.1
+	gopkg.in/yaml.v3 v3.0.1
 	modernc.org/sqlite v1.27.0
 )
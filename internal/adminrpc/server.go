@@ -0,0 +1,384 @@
+// Package adminrpc serves the admin gRPC service of adminpb/admin.proto on
+// a Unix socket, apart from the user-facing REST API, for provisioning and
+// monitoring systems on the same host. The socket is the only guard: it is
+// created mode 0600, so only the user pigpak runs as can call it.
+package adminrpc
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"pigpak/internal/adminrpc/adminpb"
+	"pigpak/internal/bot"
+	"pigpak/internal/db"
+	"pigpak/internal/webdav"
+)
+
+// Server implements adminpb.AdminServer.
+type Server struct {
+	adminpb.UnimplementedAdminServer
+
+	Store *db.Store
+	// Bot checks storage chats and runs maintenance passes and backups;
+	// nil makes those calls unavailable.
+	Bot *bot.Bot
+	// Uploads counts the uploads in flight for GetStats; nil when WebDAV
+	// is disabled.
+	Uploads *webdav.Server
+	// Started is when the server process started.
+	Started time.Time
+}
+
+// ListenAndServe serves s on the Unix socket at path until ctx is done,
+// replacing a socket left behind by an earlier run.
+func (s *Server) ListenAndServe(ctx context.Context, path string) error {
+	lis, err := listenPrivate(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	server := grpc.NewServer()
+	adminpb.RegisterAdminServer(server, s)
+	go func() {
+		<-ctx.Done()
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			log.Printf("admin socket: forcing shutdown")
+			server.Stop()
+		}
+	}()
+	if err := server.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// listenPrivate listens on a Unix socket at path that only the current user
+// can connect to. The socket is created in a fresh 0700 directory, made
+// 0600 there and only then moved to path, so no other user can connect in
+// between.
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".pigpak-admin-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "admin.sock")
+	lis, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// The socket moves away from tmp; path is removed by the caller.
+	lis.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		lis.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	return lis, nil
+}
+
+func (s *Server) ListUsers(ctx context.Context, _ *adminpb.ListUsersRequest) (*adminpb.ListUsersResponse, error) {
+	users, err := s.Store.ListUserSummaries(ctx)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	resp := &adminpb.ListUsersResponse{}
+	for _, u := range users {
+		pu, err := s.user(ctx, u)
+		if err != nil {
+			return nil, rpcError(err)
+		}
+		resp.Users = append(resp.Users, pu)
+	}
+	return resp, nil
+}
+
+func (s *Server) GetUser(ctx context.Context, req *adminpb.GetUserRequest) (*adminpb.User, error) {
+	u, err := s.Store.GetUserSummary(ctx, req.GetUserId())
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	pu, err := s.user(ctx, u)
+	return pu, rpcError(err)
+}
+
+func (s *Server) CreateUser(ctx context.Context, req *adminpb.CreateUserRequest) (*adminpb.User, error) {
+	if req.GetUserId() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id must be a Telegram user id")
+	}
+	if _, err := s.Store.EnsureUser(ctx, req.GetUserId()); err != nil {
+		return nil, rpcError(err)
+	}
+	if err := s.Store.UpsertUserProfile(ctx, req.GetUserId(), req.GetUsername()); err != nil {
+		return nil, rpcError(err)
+	}
+	return s.GetUser(ctx, &adminpb.GetUserRequest{UserId: req.GetUserId()})
+}
+
+func (s *Server) SetWebDAVPassword(ctx context.Context, req *adminpb.SetWebDAVPasswordRequest) (*adminpb.SetWebDAVPasswordResponse, error) {
+	if err := s.requireUser(ctx, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	if req.GetPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "password cannot be empty")
+	}
+	if err := s.Store.SetWebDAVPassword(ctx, req.GetUserId(), req.GetPassword()); err != nil {
+		return nil, rpcError(err)
+	}
+	return &adminpb.SetWebDAVPasswordResponse{}, nil
+}
+
+func (s *Server) SetStorageChat(ctx context.Context, req *adminpb.SetStorageChatRequest) (*adminpb.SetStorageChatResponse, error) {
+	if err := s.requireUser(ctx, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	if req.GetChatId() == 0 {
+		if err := s.Store.ClearUserStorage(ctx, req.GetUserId()); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, rpcError(err)
+		}
+		return &adminpb.SetStorageChatResponse{}, nil
+	}
+	if s.Bot == nil {
+		return nil, status.Error(codes.Unavailable, "storage chats cannot be checked")
+	}
+	title, err := s.Bot.SetStorageChat(ctx, req.GetUserId(), req.GetChatId())
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return &adminpb.SetStorageChatResponse{Title: title}, nil
+}
+
+func (s *Server) CreateAPIToken(ctx context.Context, req *adminpb.CreateAPITokenRequest) (*adminpb.CreateAPITokenResponse, error) {
+	if err := s.requireUser(ctx, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	scopes := req.GetScopes()
+	if len(scopes) == 0 {
+		scopes = []string{db.TokenScopeAPI, db.TokenScopeWebDAV}
+	}
+	var expiresAt *time.Time
+	if ttl := req.GetTtl(); ttl != nil {
+		if ttl.AsDuration() <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "ttl must be positive")
+		}
+		at := time.Now().Add(ttl.AsDuration())
+		expiresAt = &at
+	}
+	token := db.APITokenPrefix + randomToken(20)
+	t, err := s.Store.CreateAPIToken(ctx, req.GetUserId(), req.GetName(), token, scopes, req.GetReadOnly(), expiresAt)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return &adminpb.CreateAPITokenResponse{Info: apiToken(t), Token: token}, nil
+}
+
+func (s *Server) ListAPITokens(ctx context.Context, req *adminpb.ListAPITokensRequest) (*adminpb.ListAPITokensResponse, error) {
+	if err := s.requireUser(ctx, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	tokens, err := s.Store.ListAPITokens(ctx, req.GetUserId())
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	resp := &adminpb.ListAPITokensResponse{}
+	for _, t := range tokens {
+		resp.Tokens = append(resp.Tokens, apiToken(t))
+	}
+	return resp, nil
+}
+
+func (s *Server) RevokeAPIToken(ctx context.Context, req *adminpb.RevokeAPITokenRequest) (*adminpb.RevokeAPITokenResponse, error) {
+	if err := s.Store.RevokeAPIToken(ctx, req.GetUserId(), req.GetTokenId()); err != nil {
+		return nil, rpcError(err)
+	}
+	return &adminpb.RevokeAPITokenResponse{}, nil
+}
+
+func (s *Server) GetUsage(ctx context.Context, req *adminpb.GetUsageRequest) (*adminpb.GetUsageResponse, error) {
+	if err := s.requireUser(ctx, req.GetUserId()); err != nil {
+		return nil, err
+	}
+	usage, err := s.Store.ListDriveUsage(ctx, req.GetUserId())
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	resp := &adminpb.GetUsageResponse{}
+	for _, u := range usage {
+		resp.Drives = append(resp.Drives, &adminpb.DriveUsage{
+			DriveId: u.Drive.ID,
+			Label:   u.Drive.Label,
+			Folders: u.Dirs,
+			Files:   u.Files,
+			Bytes:   u.Bytes,
+		})
+		resp.Bytes += u.Bytes
+	}
+	return resp, nil
+}
+
+func (s *Server) GetQuota(ctx context.Context, req *adminpb.GetQuotaRequest) (*adminpb.Quota, error) {
+	q, err := s.Store.GetQuota(ctx, req.GetUserId())
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return &adminpb.Quota{LimitBytes: q.Limit, UsedBytes: q.Used}, nil
+}
+
+func (s *Server) SetQuota(ctx context.Context, req *adminpb.SetQuotaRequest) (*adminpb.Quota, error) {
+	if req.GetLimitBytes() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit_bytes cannot be negative")
+	}
+	if err := s.Store.SetQuota(ctx, req.GetUserId(), req.GetLimitBytes()); err != nil {
+		return nil, rpcError(err)
+	}
+	return s.GetQuota(ctx, &adminpb.GetQuotaRequest{UserId: req.GetUserId()})
+}
+
+func (s *Server) GetStats(ctx context.Context, _ *adminpb.GetStatsRequest) (*adminpb.Stats, error) {
+	users, err := s.Store.ListUserSummaries(ctx)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	version, err := s.Store.SchemaVersion(ctx)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	stats := &adminpb.Stats{Users: int64(len(users)), SchemaVersion: int32(version)}
+	for _, u := range users {
+		stats.Files += u.Files
+		stats.Folders += u.Dirs
+		stats.Bytes += u.Bytes
+	}
+	if s.Uploads != nil {
+		stats.UploadsRunning = int64(s.Uploads.UploadsRunning())
+	}
+	if !s.Started.IsZero() {
+		stats.StartedAt = timestamppb.New(s.Started)
+	}
+	return stats, nil
+}
+
+func (s *Server) RunMaintenance(ctx context.Context, _ *adminpb.RunMaintenanceRequest) (*adminpb.RunMaintenanceResponse, error) {
+	if s.Bot == nil {
+		return nil, status.Error(codes.Unavailable, "maintenance is not available")
+	}
+	report, err := s.Bot.RunMaintenance(ctx)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return &adminpb.RunMaintenanceResponse{Report: report}, nil
+}
+
+func (s *Server) RunBackup(ctx context.Context, _ *adminpb.RunBackupRequest) (*adminpb.RunBackupResponse, error) {
+	if s.Bot == nil {
+		return nil, status.Error(codes.Unavailable, "backups are not available")
+	}
+	if err := s.Bot.Backup(ctx); err != nil {
+		return nil, rpcError(err)
+	}
+	return &adminpb.RunBackupResponse{}, nil
+}
+
+// requireUser answers NOT_FOUND for users pigpak does not know, so calls
+// on a mistyped id do not leave rows behind.
+func (s *Server) requireUser(ctx context.Context, userID int64) error {
+	_, err := s.Store.GetUserSummary(ctx, userID)
+	return rpcError(err)
+}
+
+func (s *Server) user(ctx context.Context, u db.UserSummary) (*adminpb.User, error) {
+	pu := &adminpb.User{
+		Id:        u.UserID,
+		Username:  u.Username,
+		Drives:    u.Drives,
+		Folders:   u.Dirs,
+		Files:     u.Files,
+		Bytes:     u.Bytes,
+		CreatedAt: timestamppb.New(u.CreatedAt),
+	}
+	set, err := s.Store.WebDAVPasswordSet(ctx, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	pu.WebdavPasswordSet = set
+	storage, err := s.Store.GetUserStorage(ctx, u.UserID)
+	if err == nil {
+		pu.StorageChatId = storage.ChatID
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return pu, nil
+}
+
+func apiToken(t db.APIToken) *adminpb.APIToken {
+	pt := &adminpb.APIToken{
+		Id:        t.ID,
+		Name:      t.Name,
+		Prefix:    t.Prefix,
+		Scopes:    t.Scopes,
+		ReadOnly:  t.ReadOnly,
+		CreatedAt: timestamppb.New(t.CreatedAt),
+	}
+	if t.ExpiresAt.Valid {
+		pt.ExpiresAt = timestamppb.New(t.ExpiresAt.Time)
+	}
+	if t.LastUsedAt.Valid {
+		pt.LastUsedAt = timestamppb.New(t.LastUsedAt.Time)
+	}
+	return pt
+}
+
+// rpcError maps store and bot errors to status codes.
+func rpcError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sql.ErrNoRows):
+		return status.Error(codes.NotFound, "not found")
+	case errors.Is(err, os.ErrInvalid), errors.Is(err, bot.ErrStorageChat):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, os.ErrExist):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, bot.ErrBusy):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, bot.ErrNoBackup):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// randomToken returns n random bytes in hex.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,189 @@
+// Package api serves pigpak's JSON API for scripts and sync clients.
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"pigpak/internal/apierror"
+	"pigpak/internal/auth"
+	"pigpak/internal/db"
+	"pigpak/internal/webdav"
+)
+
+// maxRequestBody bounds JSON request bodies.
+const maxRequestBody = 1 << 20
+
+// Handler serves:
+//
+//	POST   /api/stat-batch              metadata for many paths in one round trip
+//	GET    /api/v1/dirs/{id}            a folder; "root" is the primary drive's root
+//	GET    /api/v1/dirs/{id}/children   the folders and files in a folder
+//	GET    /api/v1/dirs/{id}/archive    redirect to a signed ZIP archive of a folder
+//	POST   /api/v1/dirs                 create a folder
+//	PATCH  /api/v1/dirs/{id}            rename or move a folder
+//	DELETE /api/v1/dirs/{id}            move a folder to the trash
+//	GET    /api/v1/files/{id}           a file
+//	PATCH  /api/v1/files/{id}           rename or move a file
+//	DELETE /api/v1/files/{id}           move a file to the trash
+//	GET    /api/v1/files/{id}/download  redirect to a signed download link
+//	GET    /api/v1/files/{id}/stream    redirect to a signed inline stream
+//	GET    /api/v1/shares               list shares
+//	POST   /api/v1/shares               share a file
+//	PATCH  /api/v1/shares/{id}          set or remove a share's password
+//	DELETE /api/v1/shares/{id}          revoke a share
+//	GET    /api/v1/trash                list the trash
+//	POST   /api/v1/trash/{id}/restore   restore a trash item
+//	DELETE /api/v1/trash/{id}           delete a trash item for good
+//	POST   /api/v1/uploads              start an upload, done with WebDAV PUT
+//	*      /api/v1/tus/[{id}]           resumable uploads over tus; see TusPath
+//	GET    /api/v1/openapi.json         the OpenAPI 3 document of the v1 API
+//
+// Requests authenticate with an API token or a session token (both as
+// Authorization: Bearer; sessions also by cookie) or with the user's WebDAV
+// username and password.
+type Handler struct {
+	Store    *db.Store
+	Sessions *auth.Sessions
+	// PublicURL is WEB_DAV_PUBLIC_URL, which share and upload URLs in
+	// responses start with; without it responses carry paths only.
+	PublicURL string
+	// Signer signs the download and stream links files redirect to; nil
+	// disables them.
+	Signer *auth.Signer
+	// Uploads stores the resumable uploads sent to TusPath; nil disables
+	// them.
+	Uploads *webdav.Server
+}
+
+// readRoutes change nothing, so read-only API tokens may call them, as well
+// as GET on the v1 resources.
+var readRoutes = map[string]bool{
+	"/api/stat-batch": true,
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == OpenAPIPath && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		serveOpenAPI(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, TusPath) && r.Method == http.MethodOptions {
+		serveTusOptions(w)
+		return
+	}
+	userID, readOnly, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if readOnly && !readRoutes[r.URL.Path] && !isV1Read(r) {
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden, "read-only token", nil)
+		return
+	}
+	r = r.WithContext(db.WithActor(r.Context(), db.AuditSourceAPI, userID))
+	switch {
+	case r.URL.Path == "/api/stat-batch":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			apierror.Write(w, r, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed", nil)
+			return
+		}
+		h.statBatch(w, r, userID)
+	case strings.HasPrefix(r.URL.Path, TusPath):
+		h.serveTus(w, r, userID)
+	case strings.HasPrefix(r.URL.Path, "/api/v1/"):
+		h.serveV1(w, r, userID)
+	default:
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "not found", nil)
+	}
+}
+
+// authenticate resolves the calling user and whether their credential is
+// read-only, writing a 401 when it cannot.
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (int64, bool, bool) {
+	if username, password, ok := r.BasicAuth(); ok {
+		userID, err := h.verifyWebDAV(r, username, password)
+		if err == nil {
+			return userID, false, true
+		}
+		if !errors.Is(err, errBadCredentials) {
+			apierror.WriteError(w, r, err)
+			return 0, false, false
+		}
+	} else if token, ok := bearerAPIToken(r); ok {
+		t, valid, err := h.Store.VerifyAPIToken(r.Context(), token, db.TokenScopeAPI)
+		if err != nil {
+			apierror.WriteError(w, r, err)
+			return 0, false, false
+		}
+		if valid {
+			return t.UserID, t.ReadOnly, true
+		}
+	} else if h.Sessions != nil {
+		if claims, err := h.Sessions.FromRequest(r); err == nil {
+			return claims.UserID(), false, true
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="pigpak"`)
+	apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+	return 0, false, false
+}
+
+var errBadCredentials = errors.New("bad credentials")
+
+// bearerAPIToken returns the API token in the Authorization header, if the
+// bearer credential is one rather than a session token.
+func bearerAPIToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, strings.HasPrefix(token, db.APITokenPrefix)
+}
+
+func (h *Handler) verifyWebDAV(r *http.Request, username, password string) (int64, error) {
+	// Temporary WebDAV logins are scoped to WebDAV and not accepted here.
+	if username == "" || strings.HasPrefix(username, db.WebDAVTempUsernamePrefix) {
+		return 0, errBadCredentials
+	}
+	userID, err := h.Store.GetUserIDByUsername(r.Context(), username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errBadCredentials
+		}
+		return 0, err
+	}
+	ok, err := h.Store.VerifyWebDAVPassword(r.Context(), userID, password)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		err := h.Store.RecordAudit(r.Context(), db.AuditEvent{UserID: userID, Source: db.AuditSourceAPI, Action: db.AuditAuthFailed, Detail: "from " + r.RemoteAddr})
+		if err != nil {
+			log.Printf("api audit: %v", err)
+		}
+		return 0, errBadCredentials
+	}
+	return userID, nil
+}
+
+// decodeJSON reads a JSON request body into v, answering 400 on failure.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "invalid JSON body: "+err.Error(), nil)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
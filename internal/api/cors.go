@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsHeaders are the request headers browsers may always send across
+// origins: what the API, tus uploads and ranged downloads take.
+var corsHeaders = []string{
+	"Authorization", "Content-Type", "If-Match", "If-None-Match", "Range",
+	"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Defer-Length",
+	"X-HTTP-Method-Override", "X-Request-Id",
+}
+
+// corsExposed are the response headers scripts of other origins may read.
+var corsExposed = []string{
+	"Content-Disposition", "Content-Length", "Content-Range", "ETag", "Location", "Retry-After", "X-Request-Id",
+	"Tus-Resumable", "Tus-Version", "Tus-Extension", "Upload-Offset", "Upload-Length",
+	"X-Pigpak-Archive-Files", "X-Pigpak-Archive-Bytes",
+}
+
+const corsMethods = "GET, HEAD, POST, PATCH, DELETE, OPTIONS"
+
+// CORS lets browser clients served from other origins, such as a Mini App
+// on its own domain, call the handlers it wraps.
+type CORS struct {
+	// Origins are the allowed origins, as scheme://host[:port]; "*" allows
+	// any. Allowed origins other than "*" may send credentials, for the
+	// session cookie.
+	Origins []string
+	// Headers are request headers allowed on top of corsHeaders.
+	Headers []string
+	// MaxAge is how long browsers may cache a preflight; 0 leaves it to
+	// them.
+	MaxAge time.Duration
+}
+
+// Wrap answers preflight requests from allowed origins and marks the
+// responses of next as readable by them. Without Origins it returns next.
+func (c CORS) Wrap(next http.Handler) http.Handler {
+	if len(c.Origins) == 0 {
+		return next
+	}
+	allowHeaders := strings.Join(append(append([]string{}, corsHeaders...), c.Headers...), ", ")
+	exposeHeaders := strings.Join(corsExposed, ", ")
+	maxAge := ""
+	if c.MaxAge > 0 {
+		maxAge = strconv.Itoa(int(c.MaxAge / time.Second))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed, wildcard := c.allows(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if allowed {
+			if wildcard {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		if !preflight {
+			if allowed {
+				w.Header().Set("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Preflights carry no credentials, so they are answered here
+		// rather than refused by authentication. One from an origin not
+		// allowed gets no CORS headers, which makes the browser give up.
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+			w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+			if maxAge != "" {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allows reports whether origin may call, and whether that is because any
+// origin may.
+func (c CORS) allows(origin string) (allowed, wildcard bool) {
+	for _, o := range c.Origins {
+		if o == "*" {
+			return true, true
+		}
+		if strings.EqualFold(o, origin) {
+			return true, false
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// OpenAPIPath serves the OpenAPI 3 document of the v1 API. It needs no
+// credentials: it describes the API, not anyone's drive.
+const OpenAPIPath = "/api/v1/openapi.json"
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  []byte
+)
+
+// serveOpenAPI answers GET /api/v1/openapi.json.
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		doc, err := json.MarshalIndent(OpenAPI(), "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		openAPIDoc = doc
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, _ = w.Write(openAPIDoc)
+}
+
+// OpenAPI returns the OpenAPI 3 document of the v1 API, generated from the
+// routes it serves and the Go types of their bodies.
+func OpenAPI() map[string]any {
+	g := &openAPIGen{schemas: map[string]any{
+		"Error": map[string]any{
+			"type":     "object",
+			"required": []string{"error"},
+			"properties": map[string]any{
+				"error": map[string]any{
+					"type":     "object",
+					"required": []string{"code", "message"},
+					"properties": map[string]any{
+						"code":       map[string]any{"type": "string"},
+						"message":    map[string]any{"type": "string"},
+						"details":    map[string]any{},
+						"request_id": map[string]any{"type": "string"},
+					},
+				},
+			},
+		},
+	}}
+	paths := map[string]map[string]any{}
+	for _, rt := range v1Routes {
+		success := map[string]any{"description": http.StatusText(rt.status)}
+		if rt.response != nil {
+			success["content"] = jsonContent(g.ref(reflect.TypeOf(rt.response)))
+		}
+		op := map[string]any{
+			"operationId": operationID(rt),
+			"summary":     rt.summary,
+			"responses": map[string]any{
+				strconv.Itoa(rt.status): success,
+				"default": map[string]any{
+					"description": "Error",
+					"content":     jsonContent(map[string]any{"$ref": "#/components/schemas/Error"}),
+				},
+			},
+		}
+		if rt.request != nil {
+			op["requestBody"] = map[string]any{"required": true, "content": jsonContent(g.ref(reflect.TypeOf(rt.request)))}
+		}
+		if param := pathParam(rt.path); param != "" {
+			desc := strings.ToUpper(param[:1]) + strings.ReplaceAll(param[1:], "_", " ") + "."
+			switch param {
+			case "dir_id":
+				desc = `Folder public id, or "root" for the root of the primary drive.`
+			case "file_id":
+				desc = "File public id."
+			}
+			op["parameters"] = []any{map[string]any{"name": param, "in": "path", "required": true, "description": desc, "schema": map[string]any{"type": "string"}}}
+		}
+		if paths[rt.path] == nil {
+			paths[rt.path] = map[string]any{}
+		}
+		paths[rt.path][strings.ToLower(rt.method)] = op
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "pigpak",
+			"version":     "1",
+			"description": "Folders, files, shares and uploads of a pigpak drive. Uploads hand out a WebDAV login; the content itself is sent with PUT to the returned URL. Resumable uploads follow the tus 1.0 protocol at /api/v1/tus/ instead.",
+		},
+		"servers": []any{map[string]any{"url": "/api/v1"}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]any{
+				"token": map[string]any{"type": "http", "scheme": "bearer", "description": "An API token or a session token."},
+				"basic": map[string]any{"type": "http", "scheme": "basic", "description": "The WebDAV username and password."},
+			},
+		},
+		"security": []any{map[string]any{"token": []string{}}, map[string]any{"basic": []string{}}},
+	}
+}
+
+// operationID names a route for generated clients, as in getDirsChildren.
+func operationID(rt v1Route) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(rt.method))
+	for _, seg := range strings.Split(strings.Trim(rt.path, "/"), "/") {
+		if strings.HasPrefix(seg, "{") {
+			continue
+		}
+		b.WriteString(exportName(seg))
+	}
+	return b.String()
+}
+
+func pathParam(p string) string {
+	for _, seg := range strings.Split(p, "/") {
+		if strings.HasPrefix(seg, "{") {
+			return strings.Trim(seg, "{}")
+		}
+	}
+	return ""
+}
+
+func jsonContent(schema any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// openAPIGen turns body types into component schemas.
+type openAPIGen struct {
+	schemas map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// ref returns the schema of t, adding struct types to the components and
+// referring to them there.
+func (g *openAPIGen) ref(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		return g.ref(t.Elem())
+	case t.Kind() == reflect.Slice:
+		return map[string]any{"type": "array", "items": g.ref(t.Elem())}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int32:
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Struct:
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = nil
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// object describes a struct. Response fields are required unless they are
+// pointers or omitempty; request fields are all optional.
+func (g *openAPIGen) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		props[name] = g.ref(f.Type)
+		if !strings.HasSuffix(t.Name(), "Request") && f.Type.Kind() != reflect.Pointer && !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	out := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
+
+// schemaName exports a body type's name, dropping a JSON suffix: dirJSON
+// is Dir, createDirRequest CreateDirRequest.
+func schemaName(t reflect.Type) string {
+	return exportName(strings.TrimSuffix(t.Name(), "JSON"))
+}
+
+func exportName(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
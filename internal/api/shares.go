@@ -0,0 +1,152 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"pigpak/internal/apierror"
+	"pigpak/internal/db"
+	"pigpak/internal/gateway"
+)
+
+type createShareRequest struct {
+	// FileID is the file's public id.
+	FileID        string `json:"file_id"`
+	ExpiresInDays int    `json:"expires_in_days"`
+	Password      string `json:"password"`
+}
+
+// patchShareRequest sets the password of a share; an empty one removes it.
+type patchShareRequest struct {
+	Password *string `json:"password"`
+}
+
+type shareJSON struct {
+	ID        int64      `json:"id"`
+	FileID    string     `json:"file_id"`
+	FileName  string     `json:"file_name"`
+	Token     string     `json:"token"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Uses      int64      `json:"uses"`
+	Password  bool       `json:"password"`
+	// Recipient is the Telegram user a restricted share is for; those open
+	// in the bot only and have no Path.
+	Recipient string `json:"recipient,omitempty"`
+	// Path is the share's HTTP download on the WebDAV port; URL is the same
+	// under WEB_DAV_PUBLIC_URL, when one is set.
+	Path string `json:"path,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+type sharesResponse struct {
+	Shares []shareJSON `json:"shares"`
+}
+
+func (h *Handler) newShareJSON(share db.Share, file db.File) shareJSON {
+	out := shareJSON{ID: share.ID, FileID: file.PublicID, FileName: file.Name, Token: share.Token, Uses: share.Uses, Password: share.HasPassword()}
+	if share.ExpiresAt.Valid {
+		exp := share.ExpiresAt.Time
+		out.ExpiresAt = &exp
+	}
+	if share.Restricted() {
+		out.Recipient = share.RecipientUsername
+		if out.Recipient == "" {
+			out.Recipient = "id " + strconv.FormatInt(share.RecipientID, 10)
+		}
+		return out
+	}
+	out.Path = gateway.SharePath(share, file)
+	out.URL = h.publicURL(out.Path)
+	return out
+}
+
+func (h *Handler) listShares(w http.ResponseWriter, r *http.Request, userID int64) {
+	ctx := r.Context()
+	shares, err := h.Store.ListSharesByUser(ctx, userID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	out := sharesResponse{Shares: make([]shareJSON, 0, len(shares))}
+	for _, share := range shares {
+		file, err := h.Store.GetFileByID(ctx, userID, share.FileID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			apierror.WriteError(w, r, err)
+			return
+		}
+		out.Shares = append(out.Shares, h.newShareJSON(share, file))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) createShare(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req createShareRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "expires_in_days cannot be negative", nil)
+		return
+	}
+	ctx := r.Context()
+	file, err := h.Store.GetFileByPublicID(ctx, userID, req.FileID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		exp := time.Now().UTC().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &exp
+	}
+	share, err := h.Store.CreateShare(ctx, userID, file.ID, randomToken(8), expiresAt)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if req.Password != "" {
+		if err := h.Store.SetSharePassword(ctx, userID, share.ID, req.Password); err != nil {
+			apierror.WriteError(w, r, err)
+			return
+		}
+	}
+	h.writeShare(w, r, userID, share.ID, http.StatusCreated)
+}
+
+func (h *Handler) patchShare(w http.ResponseWriter, r *http.Request, userID, shareID int64) {
+	var req patchShareRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Password != nil {
+		if err := h.Store.SetSharePassword(r.Context(), userID, shareID, *req.Password); err != nil {
+			apierror.WriteError(w, r, err)
+			return
+		}
+	}
+	h.writeShare(w, r, userID, shareID, http.StatusOK)
+}
+
+func (h *Handler) deleteShare(w http.ResponseWriter, r *http.Request, userID, shareID int64) {
+	if err := h.Store.RevokeShare(r.Context(), userID, shareID); err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeShare answers with userID's share shareID as it is now.
+func (h *Handler) writeShare(w http.ResponseWriter, r *http.Request, userID, shareID int64, status int) {
+	share, file, err := h.Store.GetShare(r.Context(), userID, shareID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, status, h.newShareJSON(share, file))
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"pigpak/internal/apierror"
+	"pigpak/internal/db"
+)
+
+// maxStatBatch caps the number of paths per stat-batch request.
+const maxStatBatch = 1000
+
+type statBatchRequest struct {
+	Paths []string `json:"paths"`
+}
+
+type statResult struct {
+	Path   string     `json:"path"`
+	Exists bool       `json:"exists"`
+	Type   string     `json:"type,omitempty"`
+	Size   int64      `json:"size,omitempty"`
+	MTime  *time.Time `json:"mtime,omitempty"`
+	// FileUniqueID changes whenever the stored content does, so clients can
+	// use it to detect modified files.
+	FileUniqueID string `json:"file_unique_id,omitempty"`
+	// SHA256 is the hex checksum of the content, when pigpak knows it.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+type statBatchResponse struct {
+	Results []statResult `json:"results"`
+}
+
+// statBatch answers POST /api/stat-batch. Paths are relative to the root of
+// the user's primary drive, the same tree WebDAV serves.
+func (h *Handler) statBatch(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req statBatchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Paths) > maxStatBatch {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, fmt.Sprintf("at most %d paths per request", maxStatBatch), map[string]int{"max_paths": maxStatBatch})
+		return
+	}
+	rootID, err := h.Store.GetRootDirID(r.Context(), userID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	res := &pathResolver{store: h.Store, userID: userID, dirs: map[string]*db.Directory{}}
+	root, err := h.Store.GetDirByID(r.Context(), userID, rootID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	res.dirs["/"] = &root
+	out := statBatchResponse{Results: make([]statResult, 0, len(req.Paths))}
+	for _, p := range req.Paths {
+		result, err := res.stat(r.Context(), p)
+		if err != nil {
+			apierror.WriteError(w, r, err)
+			return
+		}
+		out.Results = append(out.Results, result)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// pathResolver looks up paths, remembering folders so that sibling paths in
+// one batch share lookups. A nil entry marks a folder known not to exist.
+type pathResolver struct {
+	store  *db.Store
+	userID int64
+	dirs   map[string]*db.Directory
+}
+
+func (p *pathResolver) stat(ctx context.Context, name string) (statResult, error) {
+	clean := path.Clean("/" + strings.TrimSpace(name))
+	result := statResult{Path: clean}
+	if dir, err := p.dir(ctx, clean); err != nil {
+		return result, err
+	} else if dir != nil {
+		mtime := dir.UpdatedAt
+		result.Exists, result.Type, result.MTime = true, "dir", &mtime
+		return result, nil
+	}
+	parent, err := p.dir(ctx, path.Dir(clean))
+	if err != nil || parent == nil {
+		return result, err
+	}
+	file, err := p.store.GetFileByName(ctx, p.userID, parent.ID, path.Base(clean))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return result, nil
+		}
+		return result, err
+	}
+	mtime := file.CreatedAt
+	result.Exists, result.Type, result.Size, result.MTime = true, "file", file.Size, &mtime
+	result.FileUniqueID, result.SHA256 = file.FileUniqueID, file.SHA256
+	return result, nil
+}
+
+// dir resolves a cleaned folder path, returning nil when it does not exist.
+func (p *pathResolver) dir(ctx context.Context, clean string) (*db.Directory, error) {
+	if dir, ok := p.dirs[clean]; ok {
+		return dir, nil
+	}
+	parent, err := p.dir(ctx, path.Dir(clean))
+	if err != nil || parent == nil {
+		p.dirs[clean] = nil
+		return nil, err
+	}
+	dir, err := p.store.GetDirByName(ctx, p.userID, parent.ID, path.Base(clean))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			p.dirs[clean] = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+	p.dirs[clean] = &dir
+	return &dir, nil
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"pigpak/internal/apierror"
+	"pigpak/internal/db"
+)
+
+type trashItemJSON struct {
+	ID   int64  `json:"id"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Path is where the item was deleted from.
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Files     int64     `json:"files"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type trashListResponse struct {
+	Items []trashItemJSON `json:"items"`
+}
+
+// restoreRequest says where a trash item goes back to. Left empty, as {},
+// that is the folder it was deleted from, or the root of the primary drive
+// when that folder is gone, under its old name. ParentID is a folder's
+// public id.
+type restoreRequest struct {
+	ParentID string `json:"parent_id"`
+	Name     string `json:"name"`
+}
+
+func (h *Handler) listTrash(w http.ResponseWriter, r *http.Request, userID int64) {
+	items, err := h.Store.ListTrash(r.Context(), userID, r.URL.Query().Get("q"))
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	out := trashListResponse{Items: make([]trashItemJSON, 0, len(items))}
+	for _, item := range items {
+		out.Items = append(out.Items, trashItemJSON{ID: item.ID, Kind: item.Kind, Name: item.Name, Path: item.OriginalPath, Size: item.Size, Files: item.Files, DeletedAt: item.DeletedAt})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) restoreTrash(w http.ResponseWriter, r *http.Request, userID, itemID int64) {
+	var req restoreRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	item, err := h.Store.GetTrashItem(ctx, userID, itemID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	name := item.Name
+	if req.Name != "" {
+		if !validName(w, r, req.Name) {
+			return
+		}
+		name = req.Name
+	}
+	var parentID int64
+	if req.ParentID != "" {
+		dir, err := h.dirByRef(ctx, userID, req.ParentID)
+		if err != nil {
+			apierror.WriteError(w, r, err)
+			return
+		}
+		parentID = dir.ID
+	} else if parentID, err = h.originalParent(r, userID, item); err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if err := h.Store.RestoreTrashItem(ctx, userID, itemID, parentID, name); err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// originalParent returns the folder item was deleted from, or the root of
+// the primary drive when it is gone.
+func (h *Handler) originalParent(r *http.Request, userID int64, item db.TrashItem) (int64, error) {
+	if item.OriginalParentID != 0 {
+		if _, err := h.Store.GetDirByID(r.Context(), userID, item.OriginalParentID); err == nil {
+			return item.OriginalParentID, nil
+		}
+	}
+	return h.Store.GetRootDirID(r.Context(), userID)
+}
+
+func (h *Handler) purgeTrash(w http.ResponseWriter, r *http.Request, userID, itemID int64) {
+	if err := h.Store.PurgeTrashItem(r.Context(), userID, itemID); err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"pigpak/internal/apierror"
+	"pigpak/internal/webdav"
+)
+
+// TusPath is where resumable uploads follow the tus 1.0 protocol
+// (https://tus.io/protocols/resumable-upload): POST creates an upload,
+// HEAD reports its offset, PATCH appends to it and DELETE drops it. The
+// Upload-Metadata of a POST names the file with filename (or name) and the
+// folder with dir_id, a folder's public id, the root of the primary drive
+// when left out.
+const TusPath = "/api/v1/tus/"
+
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,creation-with-upload,termination"
+	tusContentType = "application/offset+octet-stream"
+)
+
+// serveTusOptions answers the discovery request, which tus clients and
+// browsers' CORS preflights send without credentials.
+func serveTusOptions(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) serveTus(w http.ResponseWriter, r *http.Request, userID int64) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if h.Uploads == nil {
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "resumable uploads are not available", nil)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		apierror.Write(w, r, http.StatusPreconditionFailed, apierror.CodeBadRequest, "unsupported tus version", nil)
+		return
+	}
+	method := r.Method
+	// Some proxies and browsers only pass GET and POST along.
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" && method == http.MethodPost {
+		method = override
+	}
+	rest := strings.TrimPrefix(r.URL.Path, TusPath)
+	if rest == "" {
+		if method != http.MethodPost {
+			w.Header().Set("Allow", "OPTIONS, POST")
+			apierror.Write(w, r, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed", nil)
+			return
+		}
+		h.createTus(w, r, userID)
+		return
+	}
+	uploadID, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || uploadID <= 0 {
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "not found", nil)
+		return
+	}
+	switch method {
+	case http.MethodHead:
+		h.headTus(w, r, userID, uploadID)
+	case http.MethodPatch:
+		h.patchTus(w, r, userID, uploadID)
+	case http.MethodDelete:
+		h.deleteTus(w, r, userID, uploadID)
+	default:
+		w.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		apierror.Write(w, r, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+func (h *Handler) createTus(w http.ResponseWriter, r *http.Request, userID int64) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "Upload-Defer-Length is not supported", nil)
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "invalid Upload-Length", nil)
+		return
+	}
+	meta, ok := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if !ok {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "invalid Upload-Metadata", nil)
+		return
+	}
+	name := meta["filename"]
+	if name == "" {
+		name = meta["name"]
+	}
+	if !validName(w, r, name) {
+		return
+	}
+	ctx := r.Context()
+	dir, err := h.dirByRef(ctx, userID, meta["dir_id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "invalid dir_id", nil)
+		return
+	}
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	up, err := h.Uploads.CreateUpload(ctx, userID, dir.ID, name, size)
+	if err != nil {
+		writeTusError(w, r, err)
+		return
+	}
+	if up.ID == 0 {
+		// Empty files are stored as soon as they are created.
+		w.Header().Set("Upload-Offset", "0")
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.Header().Set("Location", TusPath+strconv.FormatInt(up.ID, 10))
+	offset := int64(0)
+	if r.Header.Get("Content-Type") == tusContentType && r.ContentLength != 0 {
+		offset, _, err = h.Uploads.WriteUpload(ctx, userID, up.ID, 0, r.ContentLength, r.Body)
+		if err != nil {
+			writeTusError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) headTus(w http.ResponseWriter, r *http.Request, userID, uploadID int64) {
+	up, err := h.Store.GetWebDAVUploadByID(r.Context(), userID, uploadID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.UploadedSize, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(up.TotalSize, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) patchTus(w http.ResponseWriter, r *http.Request, userID, uploadID int64) {
+	if r.Header.Get("Content-Type") != tusContentType {
+		apierror.Write(w, r, http.StatusUnsupportedMediaType, apierror.CodeBadRequest, "Content-Type must be "+tusContentType, nil)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "invalid Upload-Offset", nil)
+		return
+	}
+	offset, _, err = h.Uploads.WriteUpload(r.Context(), userID, uploadID, offset, r.ContentLength, r.Body)
+	if err != nil {
+		writeTusError(w, r, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) deleteTus(w http.ResponseWriter, r *http.Request, userID, uploadID int64) {
+	ctx := r.Context()
+	if _, err := h.Store.GetWebDAVUploadByID(ctx, userID, uploadID); err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if err := h.Store.DeleteWebDAVUpload(ctx, uploadID); err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTusError answers a failed tus request with the status its clients
+// act on: 409 sends them to HEAD for the offset, 503 to retry later.
+func writeTusError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, webdav.ErrUploadOffset):
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict, err.Error(), nil)
+	case errors.Is(err, webdav.ErrUploadTooLarge):
+		apierror.Write(w, r, http.StatusRequestEntityTooLarge, apierror.CodeBadRequest, err.Error(), nil)
+	case errors.Is(err, webdav.ErrUploadsBusy):
+		w.Header().Set("Retry-After", strconv.Itoa(webdav.UploadRetryAfter))
+		apierror.Write(w, r, http.StatusServiceUnavailable, apierror.CodeRateLimited, err.Error(), nil)
+	default:
+		apierror.WriteError(w, r, err)
+	}
+}
+
+// parseTusMetadata reads Upload-Metadata: comma-separated keys, each with
+// an optional base64 value.
+func parseTusMetadata(header string) (map[string]string, bool) {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if key == "" || err != nil {
+			return nil, false
+		}
+		meta[key] = string(value)
+	}
+	return meta, true
+}
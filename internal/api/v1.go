@@ -0,0 +1,596 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigpak/internal/apierror"
+	"pigpak/internal/db"
+	"pigpak/internal/gateway"
+)
+
+const (
+	// defaultUploadHours and maxUploadHours bound the lifetime of the
+	// WebDAV logins POST /api/v1/uploads hands out.
+	defaultUploadHours = 1
+	maxUploadHours     = 24
+	// downloadLinkTTL is how long the links GET /api/v1/files/{id}/download
+	// redirects to stay valid.
+	downloadLinkTTL = time.Hour
+	// streamLinkTTL is the same for GET /api/v1/files/{id}/stream, long
+	// enough for a film to finish playing.
+	streamLinkTTL = 12 * time.Hour
+	// archiveLinkTTL is the same for GET /api/v1/dirs/{id}/archive. The
+	// link is only checked when the download starts.
+	archiveLinkTTL = time.Hour
+)
+
+// dirJSON and fileJSON name folders and files by their public ids; the
+// database row ids never leave the server.
+type dirJSON struct {
+	PublicID string `json:"public_id"`
+	// ParentID is the parent folder's public id, left out for the root
+	// folder of a drive.
+	ParentID  string    `json:"parent_id,omitempty"`
+	Name      string    `json:"name"`
+	Revision  int64     `json:"revision"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type fileJSON struct {
+	PublicID     string    `json:"public_id"`
+	DirID        string    `json:"dir_id"`
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	MimeType     string    `json:"mime_type,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	FileUniqueID string    `json:"file_unique_id,omitempty"`
+	Revision     int64     `json:"revision"`
+	CreatedAt    time.Time `json:"created_at"`
+	ModifiedAt   time.Time `json:"modified_at"`
+}
+
+type childrenResponse struct {
+	Dirs  []dirJSON  `json:"dirs"`
+	Files []fileJSON `json:"files"`
+}
+
+type createDirRequest struct {
+	// ParentID is a folder's public id; left out, the folder is created in
+	// the root of the primary drive.
+	ParentID string `json:"parent_id"`
+	Name     string `json:"name"`
+}
+
+// patchRequest renames and moves a folder or file; fields left out keep
+// their value. With DryRun nothing changes and the answer is the
+// db.MoveReport of the move, as WebDAV gives for MOVE with X-Dry-Run: T.
+type patchRequest struct {
+	Name     *string `json:"name"`
+	ParentID *string `json:"parent_id"`
+	DryRun   bool    `json:"dry_run,omitempty"`
+}
+
+type trashResponse struct {
+	TrashID int64 `json:"trash_id"`
+}
+
+type createUploadRequest struct {
+	// DirID is a folder's public id; left out, the upload goes into the
+	// root of the primary drive.
+	DirID          string `json:"dir_id"`
+	Name           string `json:"name"`
+	ExpiresInHours int    `json:"expires_in_hours"`
+}
+
+// uploadResponse tells the client where to PUT the content. The login is
+// an ordinary temporary WebDAV login limited to the target folder, so
+// large uploads can be resumed with Content-Range.
+type uploadResponse struct {
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	URL       string    `json:"url,omitempty"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// isV1Read reports whether r is a read of the v1 API, which read-only
+// tokens may make.
+func isV1Read(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/v1/") && (r.Method == http.MethodGet || r.Method == http.MethodHead)
+}
+
+// v1Route is one operation of the v1 API. The table both routes requests
+// and generates the OpenAPI document, so the two cannot drift apart.
+type v1Route struct {
+	method string
+	// path is relative to /api/v1. A {dir_id} segment takes a folder's
+	// public id or "root", {file_id} a file's public id, and other {..._id}
+	// segments a numeric id.
+	path    string
+	summary string
+	// request and response are zero values of the JSON bodies, nil for
+	// none; status is the status of success.
+	request  any
+	response any
+	status   int
+	// handle serves routes without an id segment, handleID those with one.
+	handle   func(*Handler, http.ResponseWriter, *http.Request, int64)
+	handleID func(*Handler, http.ResponseWriter, *http.Request, int64, int64)
+}
+
+var v1Routes = []v1Route{
+	{method: http.MethodGet, path: "/dirs/{dir_id}", summary: "Get a folder", response: dirJSON{}, status: http.StatusOK, handleID: (*Handler).getDir},
+	{method: http.MethodPatch, path: "/dirs/{dir_id}", summary: "Rename or move a folder, or with dry_run report what that would do", request: patchRequest{}, response: dirJSON{}, status: http.StatusOK, handleID: (*Handler).patchDir},
+	{method: http.MethodDelete, path: "/dirs/{dir_id}", summary: "Move a folder to the trash", response: trashResponse{}, status: http.StatusOK, handleID: (*Handler).deleteDir},
+	{method: http.MethodGet, path: "/dirs/{dir_id}/children", summary: "List the folders and files in a folder", response: childrenResponse{}, status: http.StatusOK, handleID: (*Handler).listChildren},
+	{method: http.MethodGet, path: "/dirs/{dir_id}/archive", summary: "Redirect to a signed link that downloads a folder as a ZIP archive", status: http.StatusFound, handleID: (*Handler).archiveDir},
+	{method: http.MethodPost, path: "/dirs", summary: "Create a folder", request: createDirRequest{}, response: dirJSON{}, status: http.StatusCreated, handle: (*Handler).createDir},
+	{method: http.MethodGet, path: "/files/{file_id}", summary: "Get a file", response: fileJSON{}, status: http.StatusOK, handleID: (*Handler).getFile},
+	{method: http.MethodPatch, path: "/files/{file_id}", summary: "Rename or move a file, or with dry_run report what that would do", request: patchRequest{}, response: fileJSON{}, status: http.StatusOK, handleID: (*Handler).patchFile},
+	{method: http.MethodDelete, path: "/files/{file_id}", summary: "Move a file to the trash", response: trashResponse{}, status: http.StatusOK, handleID: (*Handler).deleteFile},
+	{method: http.MethodGet, path: "/files/{file_id}/download", summary: "Redirect to a signed download link of a file", status: http.StatusFound, handleID: (*Handler).downloadFile},
+	{method: http.MethodGet, path: "/files/{file_id}/stream", summary: "Redirect to a signed link that plays a file inline, for browsers and media players", status: http.StatusFound, handleID: (*Handler).streamFile},
+	{method: http.MethodGet, path: "/shares", summary: "List your shares", response: sharesResponse{}, status: http.StatusOK, handle: (*Handler).listShares},
+	{method: http.MethodPost, path: "/shares", summary: "Share a file", request: createShareRequest{}, response: shareJSON{}, status: http.StatusCreated, handle: (*Handler).createShare},
+	{method: http.MethodPatch, path: "/shares/{share_id}", summary: "Set or remove the password of a share", request: patchShareRequest{}, response: shareJSON{}, status: http.StatusOK, handleID: (*Handler).patchShare},
+	{method: http.MethodDelete, path: "/shares/{share_id}", summary: "Revoke a share", status: http.StatusNoContent, handleID: (*Handler).deleteShare},
+	{method: http.MethodGet, path: "/trash", summary: "List the trash, most recently deleted first", response: trashListResponse{}, status: http.StatusOK, handle: (*Handler).listTrash},
+	{method: http.MethodPost, path: "/trash/{trash_id}/restore", summary: "Restore a trash item", request: restoreRequest{}, status: http.StatusNoContent, handleID: (*Handler).restoreTrash},
+	{method: http.MethodDelete, path: "/trash/{trash_id}", summary: "Delete a trash item for good", status: http.StatusNoContent, handleID: (*Handler).purgeTrash},
+	{method: http.MethodPost, path: "/uploads", summary: "Start an upload, sent with WebDAV PUT", request: createUploadRequest{}, response: uploadResponse{}, status: http.StatusCreated, handle: (*Handler).createUpload},
+}
+
+// match reports whether rest, a path relative to /api/v1, is the route's,
+// returning its id segment.
+func (rt v1Route) match(rest string) (string, bool) {
+	want := strings.Split(strings.Trim(rt.path, "/"), "/")
+	got := strings.Split(strings.Trim(rest, "/"), "/")
+	if len(want) != len(got) {
+		return "", false
+	}
+	var id string
+	for i, seg := range want {
+		switch {
+		case strings.HasPrefix(seg, "{"):
+			id = got[i]
+		case seg != got[i]:
+			return "", false
+		}
+	}
+	return id, true
+}
+
+// serveV1 routes the /api/v1 resources.
+func (h *Handler) serveV1(w http.ResponseWriter, r *http.Request, userID int64) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1")
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	var allow []string
+	for _, rt := range v1Routes {
+		segment, ok := rt.match(rest)
+		if !ok {
+			continue
+		}
+		if rt.method != method {
+			allow = append(allow, rt.method)
+			continue
+		}
+		if rt.handleID == nil {
+			rt.handle(h, w, r, userID)
+			return
+		}
+		id, ok := h.parseID(w, r, userID, rt.path, segment)
+		if ok {
+			rt.handleID(h, w, r, userID, id)
+		}
+		return
+	}
+	if len(allow) > 0 {
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		apierror.Write(w, r, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "not found", nil)
+}
+
+// parseID resolves the id segment of a route to a row id. Folders and
+// files are named by public id, where "root" names the root of the primary
+// drive.
+func (h *Handler) parseID(w http.ResponseWriter, r *http.Request, userID int64, routePath, segment string) (int64, bool) {
+	switch {
+	case segment == "":
+	case strings.Contains(routePath, "{dir_id}"):
+		dir, err := h.dirByRef(r.Context(), userID, segment)
+		if err != nil {
+			apierror.WriteError(w, r, err)
+			return 0, false
+		}
+		return dir.ID, true
+	case strings.Contains(routePath, "{file_id}"):
+		file, err := h.Store.GetFileByPublicID(r.Context(), userID, segment)
+		if err != nil {
+			apierror.WriteError(w, r, err)
+			return 0, false
+		}
+		return file.ID, true
+	}
+	id, err := strconv.ParseInt(segment, 10, 64)
+	if err != nil || id <= 0 {
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "not found", nil)
+		return 0, false
+	}
+	return id, true
+}
+
+// dirByRef returns the folder with public id ref, or the root of the
+// primary drive for "" and "root".
+func (h *Handler) dirByRef(ctx context.Context, userID int64, ref string) (db.Directory, error) {
+	if ref != "" && ref != "root" {
+		return h.Store.GetDirByPublicID(ctx, userID, ref)
+	}
+	id, err := h.Store.GetRootDirID(ctx, userID)
+	if err != nil {
+		return db.Directory{}, err
+	}
+	return h.Store.GetDirByID(ctx, userID, id)
+}
+
+// publicDirID returns the public id of folder dirID.
+func (h *Handler) publicDirID(ctx context.Context, userID, dirID int64) (string, error) {
+	dir, err := h.Store.GetDirByID(ctx, userID, dirID)
+	return dir.PublicID, err
+}
+
+func (h *Handler) getDir(w http.ResponseWriter, r *http.Request, userID, dirID int64) {
+	ctx := r.Context()
+	dir, err := h.Store.GetDirByID(ctx, userID, dirID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	var parent string
+	if dir.ParentID.Valid {
+		if parent, err = h.publicDirID(ctx, userID, dir.ParentID.Int64); err != nil {
+			apierror.WriteError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, newDirJSON(dir, parent))
+}
+
+func (h *Handler) listChildren(w http.ResponseWriter, r *http.Request, userID, dirID int64) {
+	ctx := r.Context()
+	parent, err := h.Store.GetDirByID(ctx, userID, dirID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	dirs, err := h.Store.ListDirs(ctx, userID, dirID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	files, err := h.Store.ListFiles(ctx, userID, dirID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	out := childrenResponse{Dirs: make([]dirJSON, 0, len(dirs)), Files: make([]fileJSON, 0, len(files))}
+	for _, dir := range dirs {
+		out.Dirs = append(out.Dirs, newDirJSON(dir, parent.PublicID))
+	}
+	for _, file := range files {
+		out.Files = append(out.Files, newFileJSON(file, parent.PublicID))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) createDir(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req createDirRequest
+	if !decodeJSON(w, r, &req) || !validName(w, r, req.Name) {
+		return
+	}
+	parent, err := h.dirByRef(r.Context(), userID, req.ParentID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	dir, err := h.Store.CreateDir(r.Context(), userID, parent.ID, req.Name)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newDirJSON(dir, parent.PublicID))
+}
+
+func (h *Handler) patchDir(w http.ResponseWriter, r *http.Request, userID, dirID int64) {
+	var req patchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	dir, err := h.Store.GetDirByID(ctx, userID, dirID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	name, parentID := dir.Name, dir.ParentID.Int64
+	if !h.applyPatch(w, r, userID, req, &name, &parentID) {
+		return
+	}
+	plan, err := h.Store.PlanDirMove(ctx, userID, dirID, parentID, name)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if req.DryRun {
+		writeMoveReport(w, plan)
+		return
+	}
+	if !movePlanOK(w, r, plan) {
+		return
+	}
+	if err := h.Store.MoveAndRename(ctx, userID, dirID, 0, parentID, name); err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	h.getDir(w, r, userID, dirID)
+}
+
+func (h *Handler) deleteDir(w http.ResponseWriter, r *http.Request, userID, dirID int64) {
+	dir, err := h.Store.GetDirByID(r.Context(), userID, dirID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if !dir.ParentID.Valid {
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden, "a drive's root folder cannot be deleted", nil)
+		return
+	}
+	item, err := h.Store.TrashDir(r.Context(), userID, dirID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, trashResponse{TrashID: item.ID})
+}
+
+// archiveDir redirects to a signed /zip/ link, which streams the folder
+// and everything below it as a ZIP archive.
+func (h *Handler) archiveDir(w http.ResponseWriter, r *http.Request, userID, dirID int64) {
+	if h.Signer == nil {
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "archives are not available", nil)
+		return
+	}
+	dir, err := h.Store.GetDirByID(r.Context(), userID, dirID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, gateway.ArchivePath(h.Signer, dir, time.Now().Add(archiveLinkTTL)), http.StatusFound)
+}
+
+func (h *Handler) getFile(w http.ResponseWriter, r *http.Request, userID, fileID int64) {
+	ctx := r.Context()
+	file, err := h.Store.GetFileByID(ctx, userID, fileID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	dir, err := h.publicDirID(ctx, userID, file.DirID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newFileJSON(file, dir))
+}
+
+// downloadFile redirects to a signed /dl/ link, so browsers and scripts
+// can fetch a file with the credentials they call the API with.
+func (h *Handler) downloadFile(w http.ResponseWriter, r *http.Request, userID, fileID int64) {
+	if h.Signer == nil {
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "downloads are not available", nil)
+		return
+	}
+	file, err := h.Store.GetFileByID(r.Context(), userID, fileID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, gateway.DownloadPath(h.Signer, file, time.Now().Add(downloadLinkTTL)), http.StatusFound)
+}
+
+// streamFile redirects to a signed /stream/ link, which serves the file
+// inline for players to seek in.
+func (h *Handler) streamFile(w http.ResponseWriter, r *http.Request, userID, fileID int64) {
+	if h.Signer == nil {
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "streams are not available", nil)
+		return
+	}
+	file, err := h.Store.GetFileByID(r.Context(), userID, fileID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, gateway.StreamPath(h.Signer, file, time.Now().Add(streamLinkTTL)), http.StatusFound)
+}
+
+func (h *Handler) patchFile(w http.ResponseWriter, r *http.Request, userID, fileID int64) {
+	var req patchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	file, err := h.Store.GetFileByID(ctx, userID, fileID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	name, dirID := file.Name, file.DirID
+	if !h.applyPatch(w, r, userID, req, &name, &dirID) {
+		return
+	}
+	plan, err := h.Store.PlanFileMove(ctx, userID, fileID, dirID, name)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if req.DryRun {
+		writeMoveReport(w, plan)
+		return
+	}
+	if !movePlanOK(w, r, plan) {
+		return
+	}
+	if err := h.Store.MoveAndRename(ctx, userID, 0, fileID, dirID, name); err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	h.getFile(w, r, userID, fileID)
+}
+
+func (h *Handler) deleteFile(w http.ResponseWriter, r *http.Request, userID, fileID int64) {
+	item, err := h.Store.TrashFile(r.Context(), userID, fileID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, trashResponse{TrashID: item.ID})
+}
+
+// applyPatch applies req to name and parentID, checking that a new parent
+// folder exists.
+func (h *Handler) applyPatch(w http.ResponseWriter, r *http.Request, userID int64, req patchRequest, name *string, parentID *int64) bool {
+	if req.Name != nil {
+		if !validName(w, r, *req.Name) {
+			return false
+		}
+		*name = *req.Name
+	}
+	if req.ParentID != nil {
+		dir, err := h.dirByRef(r.Context(), userID, *req.ParentID)
+		if err != nil {
+			apierror.WriteError(w, r, err)
+			return false
+		}
+		*parentID = dir.ID
+	}
+	return true
+}
+
+// movePlanOK answers 409 for a move that cannot run. The API never
+// replaces what is at the target.
+func movePlanOK(w http.ResponseWriter, r *http.Request, plan db.MovePlan) bool {
+	if len(plan.Conflicts) > 0 {
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict, plan.Conflicts[0], map[string][]string{"conflicts": plan.Conflicts})
+		return false
+	}
+	if plan.TargetExists {
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict, "name already exists", nil)
+		return false
+	}
+	return true
+}
+
+// writeMoveReport answers a dry run with the report of the move, with 200
+// whether or not it could run; ok tells.
+func writeMoveReport(w http.ResponseWriter, plan db.MovePlan) {
+	report := plan.Report(false)
+	if plan.TargetExists {
+		report.Conflicts = append(report.Conflicts, "name already exists")
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (h *Handler) createUpload(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req createUploadRequest
+	if !decodeJSON(w, r, &req) || !validName(w, r, req.Name) {
+		return
+	}
+	hours := req.ExpiresInHours
+	if hours == 0 {
+		hours = defaultUploadHours
+	}
+	if hours < 0 || hours > maxUploadHours {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, fmt.Sprintf("expires_in_hours must be between 1 and %d", maxUploadHours), nil)
+		return
+	}
+	ctx := r.Context()
+	dir, err := h.dirByRef(ctx, userID, req.DirID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	dirID := dir.ID
+	if _, err := h.Store.GetDirByName(ctx, userID, dirID, req.Name); err == nil {
+		apierror.Write(w, r, http.StatusConflict, apierror.CodeConflict, "a folder has that name", nil)
+		return
+	}
+	password := randomToken(16)
+	expiresAt := time.Now().UTC().Add(time.Duration(hours) * time.Hour)
+	cred, err := h.Store.CreateWebDAVTempCredential(ctx, userID, db.WebDAVTempUsernamePrefix+randomToken(4), password, expiresAt, false, dirID)
+	if err != nil {
+		apierror.WriteError(w, r, err)
+		return
+	}
+	// The login sees the folder as its root.
+	p := "/" + url.PathEscape(req.Name)
+	writeJSON(w, http.StatusCreated, uploadResponse{Method: http.MethodPut, Path: p, URL: h.publicURL(p), Username: cred.Username, Password: password, ExpiresAt: cred.ExpiresAt})
+}
+
+// publicURL returns p under PublicURL, or "" without one.
+func (h *Handler) publicURL(p string) string {
+	if h.PublicURL == "" {
+		return ""
+	}
+	return strings.TrimRight(h.PublicURL, "/") + p
+}
+
+// validName answers 400 for names that cannot be stored.
+func validName(w http.ResponseWriter, r *http.Request, name string) bool {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "invalid name", nil)
+		return false
+	}
+	return true
+}
+
+// newDirJSON describes dir, whose parent folder has public id parent.
+func newDirJSON(dir db.Directory, parent string) dirJSON {
+	return dirJSON{PublicID: dir.PublicID, ParentID: parent, Name: dir.Name, Revision: dir.Revision, CreatedAt: dir.CreatedAt, UpdatedAt: dir.UpdatedAt}
+}
+
+// newFileJSON describes file, whose folder has public id dir.
+func newFileJSON(file db.File, dir string) fileJSON {
+	return fileJSON{
+		PublicID:     file.PublicID,
+		DirID:        dir,
+		Name:         file.Name,
+		Size:         file.Size,
+		MimeType:     file.MimeType,
+		SHA256:       file.SHA256,
+		FileUniqueID: file.FileUniqueID,
+		Revision:     file.Revision,
+		CreatedAt:    file.CreatedAt,
+		ModifiedAt:   file.ModifiedAt,
+	}
+}
+
+// randomToken returns n random bytes in hex.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,133 @@
+// Package apierror defines the JSON error envelope shared by pigpak's HTTP
+// endpoints:
+//
+//	{"error": {"code": "not_found", "message": "file not found", "details": {...}, "request_id": "..."}}
+//
+// Codes are stable and meant for clients to branch on; messages are for
+// people and may change.
+package apierror
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// Code is a machine-readable error code.
+type Code string
+
+const (
+	CodeBadRequest       Code = "bad_request"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeNotFound         Code = "not_found"
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	CodeConflict         Code = "conflict"
+	CodeInvalidLink      Code = "invalid_link"
+	CodeLinkExpired      Code = "link_expired"
+	CodeRateLimited      Code = "rate_limited"
+	CodeQuotaExceeded    Code = "quota_exceeded"
+	// CodeFileUnavailable means Telegram no longer serves the stored file.
+	CodeFileUnavailable Code = "file_unavailable"
+	// CodeUpstream means Telegram could not be reached or failed transiently.
+	CodeUpstream Code = "upstream_unavailable"
+	CodeInternal Code = "internal"
+)
+
+// RequestIDHeader carries the request ID in requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+// Error is the body of an error response.
+type Error struct {
+	Code      Code   `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type envelope struct {
+	Error Error `json:"error"`
+}
+
+// Write sends an error response with the given status.
+func Write(w http.ResponseWriter, r *http.Request, status int, code Code, message string, details any) {
+	body := envelope{Error: Error{Code: code, Message: message, Details: details, RequestID: RequestID(r.Context())}}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// WriteError maps err onto the taxonomy and sends it. Internal errors are
+// reported without their text, which may leak paths or tokens.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code, message := Classify(err)
+	if code == CodeRateLimited {
+		if wait := telegram.RetryAfter(err); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		}
+	}
+	Write(w, r, status, code, message, nil)
+}
+
+// Classify returns the HTTP status, code and client-safe message for err.
+func Classify(err error) (int, Code, string) {
+	var tgErr *telegram.TelegramError
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound, CodeNotFound, "not found"
+	case errors.Is(err, os.ErrExist):
+		return http.StatusConflict, CodeConflict, "name already exists"
+	case errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden, CodeForbidden, "permission denied"
+	case errors.Is(err, db.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage, CodeQuotaExceeded, "storage quota exceeded"
+	case telegram.IsFileReferenceError(err):
+		return http.StatusGone, CodeFileUnavailable, "file is no longer available from Telegram"
+	case errors.As(err, &tgErr) && tgErr.RetryAfter > 0:
+		return http.StatusServiceUnavailable, CodeRateLimited, "rate limited by Telegram, retry later"
+	case telegram.IsTransient(err):
+		return http.StatusBadGateway, CodeUpstream, "Telegram is unavailable, retry later"
+	}
+	return http.StatusInternalServerError, CodeInternal, "internal error"
+}
+
+type requestIDKey struct{}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// WithRequestID tags every request with an ID, reusing a well-formed
+// incoming X-Request-ID, and echoes it in the response.
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestID returns the ID assigned by WithRequestID, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"pigpak/internal/apierror"
+)
+
+// maxLoginAge bounds how old Telegram auth_date may be when exchanged for a session.
+const maxLoginAge = 24 * time.Hour
+
+// Handler exchanges Telegram login data for session cookies.
+//
+//	POST /auth/telegram  Login Widget fields (query string or form)
+//	POST /auth/webapp    Mini App initData (form field "init_data" or raw body)
+//	POST /auth/logout    clears the session cookie
+//	GET  /auth/session   reports the current session
+type Handler struct {
+	BotToken string
+	Sessions *Sessions
+	// OnLogin is called for every verified login, e.g. to create the user.
+	OnLogin func(ctx context.Context, user TelegramUser) error
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/auth/telegram":
+		h.login(w, r, func() (TelegramUser, error) {
+			if err := r.ParseForm(); err != nil {
+				return TelegramUser{}, err
+			}
+			return VerifyLoginWidget(h.BotToken, r.Form, maxLoginAge)
+		})
+	case "/auth/webapp":
+		h.login(w, r, func() (TelegramUser, error) {
+			if err := r.ParseForm(); err != nil {
+				return TelegramUser{}, err
+			}
+			return VerifyWebAppInitData(h.BotToken, r.Form.Get("init_data"), maxLoginAge)
+		})
+	case "/auth/logout":
+		// Only POST, so a cross-site GET such as an <img> cannot sign the
+		// user out.
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			apierror.Write(w, r, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed", nil)
+			return
+		}
+		h.Sessions.ClearCookie(w)
+		w.WriteHeader(http.StatusNoContent)
+	case "/auth/session":
+		claims, err := h.Sessions.FromRequest(r)
+		if err != nil {
+			apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"user_id": claims.UserID(), "expires_at": time.Unix(claims.ExpiresAt, 0).UTC()})
+	default:
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "not found", nil)
+	}
+}
+
+func (h *Handler) login(w http.ResponseWriter, r *http.Request, verify func() (TelegramUser, error)) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		apierror.Write(w, r, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	user, err := verify()
+	if err != nil {
+		status, code := http.StatusUnauthorized, apierror.CodeUnauthorized
+		if !errors.Is(err, ErrInvalidSignature) && !errors.Is(err, ErrExpired) {
+			status, code = http.StatusBadRequest, apierror.CodeBadRequest
+		}
+		apierror.Write(w, r, status, code, err.Error(), nil)
+		return
+	}
+	if h.OnLogin != nil {
+		if err := h.OnLogin(r.Context(), user); err != nil {
+			log.Printf("auth login hook: %v", err)
+			apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "login failed", nil)
+			return
+		}
+	}
+	token, expires, err := h.Sessions.Issue(user.ID)
+	if err != nil {
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "login failed", nil)
+		return
+	}
+	h.Sessions.SetCookie(w, r, token, expires)
+	writeJSON(w, http.StatusOK, map[string]any{"user_id": user.ID, "token": token, "expires_at": expires})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigpak/internal/apierror"
+)
+
+// CookieName is the cookie carrying the session token.
+const CookieName = "pigpak_session"
+
+// Sessions issues and verifies HS256 JWT session tokens whose subject is the
+// Telegram user ID, which is also the user ID used throughout the store.
+type Sessions struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// Claims are the JWT claims carried by a session token.
+type Claims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// UserID returns the numeric user ID of the session subject.
+func (c Claims) UserID() int64 {
+	id, _ := strconv.ParseInt(c.Subject, 10, 64)
+	return id
+}
+
+// NewSessions creates a session issuer. An empty secret is rejected.
+func NewSessions(secret []byte, ttl time.Duration) (*Sessions, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("session secret is required")
+	}
+	if ttl <= 0 {
+		ttl = 30 * 24 * time.Hour
+	}
+	return &Sessions{secret: secret, ttl: ttl}, nil
+}
+
+// DeriveSecret derives a session secret from the bot token for deployments
+// that do not configure one explicitly.
+func DeriveSecret(botToken string) []byte {
+	mac := hmac.New(sha256.New, []byte("pigpak-session"))
+	_, _ = mac.Write([]byte(botToken))
+	return mac.Sum(nil)
+}
+
+// ResolveSecret returns the configured secret, or one derived from the bot
+// token when none is configured.
+func ResolveSecret(configured, botToken string) []byte {
+	if configured != "" {
+		return []byte(configured)
+	}
+	return DeriveSecret(botToken)
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Issue creates a signed token for userID.
+func (s *Sessions) Issue(userID int64) (string, time.Time, error) {
+	issued := time.Now().UTC()
+	expires := issued.Add(s.ttl)
+	claims := Claims{Subject: strconv.FormatInt(userID, 10), IssuedAt: issued.Unix(), ExpiresAt: expires.Unix()}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(body)
+	return signingInput + "." + s.sign(signingInput), expires, nil
+}
+
+// Verify checks a token signature and expiry.
+func (s *Sessions) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return Claims{}, ErrInvalidSignature
+	}
+	expect := s.sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expect), []byte(parts[2])) {
+		return Claims{}, ErrInvalidSignature
+	}
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, ErrInvalidSignature
+	}
+	var claims Claims
+	if err := json.Unmarshal(body, &claims); err != nil || claims.UserID() == 0 {
+		return Claims{}, ErrInvalidSignature
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrExpired
+	}
+	return claims, nil
+}
+
+func (s *Sessions) sign(input string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	_, _ = mac.Write([]byte(input))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SetCookie writes the session cookie.
+func (s *Sessions) SetCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ClearCookie expires the session cookie.
+func (s *Sessions) ClearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: CookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+}
+
+// FromRequest extracts and verifies a session from the cookie or an
+// "Authorization: Bearer" header.
+func (s *Sessions) FromRequest(r *http.Request) (Claims, error) {
+	token := ""
+	if value := r.Header.Get("Authorization"); strings.HasPrefix(value, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(value, "Bearer "))
+	} else if cookie, err := r.Cookie(CookieName); err == nil {
+		token = cookie.Value
+	}
+	if token == "" {
+		return Claims{}, http.ErrNoCookie
+	}
+	return s.Verify(token)
+}
+
+type userKey struct{}
+
+// WithUserID stores an authenticated user ID in ctx.
+func WithUserID(ctx context.Context, userID int64) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+// UserID returns the authenticated user ID stored by Require.
+func UserID(ctx context.Context) (int64, bool) {
+	userID, ok := ctx.Value(userKey{}).(int64)
+	return userID, ok && userID != 0
+}
+
+// Require rejects requests without a valid session and stores the user ID in
+// the request context otherwise.
+func (s *Sessions) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := s.FromRequest(r)
+		if err != nil {
+			apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), claims.UserID())))
+	})
+}
@@ -0,0 +1,76 @@
+package auth_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"pigpak/internal/auth"
+)
+
+var sessionSecret = []byte("session-secret")
+
+// forgeToken builds a JWT from a raw header and claims, signed with HS256
+// under secret.
+func forgeToken(secret []byte, header, claims string) string {
+	input := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestSessionsVerify(t *testing.T) {
+	sessions, err := auth.NewSessions(sessionSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := sessions.Issue(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := auth.NewSessions([]byte("other-secret"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, _, err := other.Issue(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := `{"alg":"HS256","typ":"JWT"}`
+	live := `{"sub":"42","iat":1,"exp":` + unix(time.Now().Add(time.Hour)) + `}`
+	parts := strings.Split(token, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"issued", token, nil},
+		{"forged with the secret", forgeToken(sessionSecret, header, live), nil},
+		{"tampered claims", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"43","iat":1,"exp":9999999999}`)) + "." + parts[2], auth.ErrInvalidSignature},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])), auth.ErrInvalidSignature},
+		{"wrong secret", otherToken, auth.ErrInvalidSignature},
+		{"expired exp", forgeToken(sessionSecret, header, `{"sub":"42","iat":1,"exp":`+unix(time.Now().Add(-time.Minute))+`}`), auth.ErrExpired},
+		{"alg none", forgeToken(sessionSecret, `{"alg":"none","typ":"JWT"}`, live), auth.ErrInvalidSignature},
+		{"alg HS512", forgeToken(sessionSecret, `{"alg":"HS512","typ":"JWT"}`, live), auth.ErrInvalidSignature},
+		{"unknown kid", forgeToken(sessionSecret, `{"alg":"HS256","kid":"old","typ":"JWT"}`, live), auth.ErrInvalidSignature},
+		{"no subject", forgeToken(sessionSecret, header, `{"iat":1,"exp":9999999999}`), auth.ErrInvalidSignature},
+		{"two parts", parts[0] + "." + parts[1], auth.ErrInvalidSignature},
+		{"empty", "", auth.ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := sessions.Verify(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && claims.UserID() != 42 {
+				t.Fatalf("user id = %d, want 42", claims.UserID())
+			}
+		})
+	}
+}
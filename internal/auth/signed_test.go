@@ -0,0 +1,54 @@
+package auth_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"pigpak/internal/auth"
+)
+
+func TestSignerVerify(t *testing.T) {
+	signer, err := auth.NewSigner([]byte("url-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := auth.NewSigner([]byte("other-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	const resource = "/p/abc/report.pdf"
+	signed := signer.Sign(resource, now.Add(time.Hour))
+	with := func(key, value string) url.Values {
+		query := url.Values{"exp": {signed.Get("exp")}, "sig": {signed.Get("sig")}}
+		query.Set(key, value)
+		return query
+	}
+
+	tests := []struct {
+		name     string
+		resource string
+		query    url.Values
+		now      time.Time
+		want     error
+	}{
+		{"valid", resource, signed, now, nil},
+		{"never expires", resource, signer.Sign(resource, time.Time{}), now.AddDate(10, 0, 0), nil},
+		{"other resource", "/p/abc/secret.pdf", signed, now, auth.ErrInvalidSignature},
+		{"tampered exp", resource, with("exp", unix(now.Add(48*time.Hour))), now, auth.ErrInvalidSignature},
+		{"tampered sig", resource, with("sig", other.Sign(resource, now.Add(time.Hour)).Get("sig")), now, auth.ErrInvalidSignature},
+		{"wrong secret", resource, other.Sign(resource, now.Add(time.Hour)), now, auth.ErrInvalidSignature},
+		{"sig not base64", resource, with("sig", "!!"), now, auth.ErrInvalidSignature},
+		{"no exp", resource, url.Values{"sig": {signed.Get("sig")}}, now, auth.ErrInvalidSignature},
+		{"expired", resource, signed, now.Add(2 * time.Hour), auth.ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := signer.Verify(tt.resource, tt.query, tt.now); !errors.Is(err, tt.want) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSignature is returned when Telegram-signed data fails verification.
+var ErrInvalidSignature = errors.New("invalid telegram signature")
+
+// ErrExpired is returned when signed data or a session is too old.
+var ErrExpired = errors.New("authorization expired")
+
+// TelegramUser is the identity asserted by Telegram login data.
+type TelegramUser struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username,omitempty"`
+	FirstName string    `json:"first_name,omitempty"`
+	LastName  string    `json:"last_name,omitempty"`
+	PhotoURL  string    `json:"photo_url,omitempty"`
+	AuthDate  time.Time `json:"-"`
+}
+
+// VerifyLoginWidget validates the fields sent by the Telegram Login Widget.
+// The secret key is SHA-256 of the bot token, see
+// https://core.telegram.org/widgets/login#checking-authorization.
+func VerifyLoginWidget(botToken string, fields url.Values, maxAge time.Duration) (TelegramUser, error) {
+	secret := sha256.Sum256([]byte(botToken))
+	if err := checkHash(fields, secret[:]); err != nil {
+		return TelegramUser{}, err
+	}
+	authDate, err := checkAuthDate(fields, maxAge)
+	if err != nil {
+		return TelegramUser{}, err
+	}
+	id, err := strconv.ParseInt(fields.Get("id"), 10, 64)
+	if err != nil || id == 0 {
+		return TelegramUser{}, errors.New("login data has no user id")
+	}
+	return TelegramUser{
+		ID:        id,
+		Username:  fields.Get("username"),
+		FirstName: fields.Get("first_name"),
+		LastName:  fields.Get("last_name"),
+		PhotoURL:  fields.Get("photo_url"),
+		AuthDate:  authDate,
+	}, nil
+}
+
+// VerifyWebAppInitData validates Telegram Mini App initData. The secret key
+// is HMAC-SHA-256 of the bot token keyed with "WebAppData".
+func VerifyWebAppInitData(botToken, initData string, maxAge time.Duration) (TelegramUser, error) {
+	fields, err := url.ParseQuery(initData)
+	if err != nil {
+		return TelegramUser{}, ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte("WebAppData"))
+	_, _ = mac.Write([]byte(botToken))
+	if err := checkHash(fields, mac.Sum(nil)); err != nil {
+		return TelegramUser{}, err
+	}
+	authDate, err := checkAuthDate(fields, maxAge)
+	if err != nil {
+		return TelegramUser{}, err
+	}
+	var user TelegramUser
+	if err := json.Unmarshal([]byte(fields.Get("user")), &user); err != nil || user.ID == 0 {
+		return TelegramUser{}, errors.New("init data has no user")
+	}
+	user.AuthDate = authDate
+	return user, nil
+}
+
+func checkHash(fields url.Values, secret []byte) error {
+	got, err := hex.DecodeString(fields.Get("hash"))
+	if err != nil || len(got) == 0 {
+		return ErrInvalidSignature
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if key == "hash" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, key+"="+fields.Get(key))
+	}
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(strings.Join(lines, "\n")))
+	if !hmac.Equal(mac.Sum(nil), got) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func checkAuthDate(fields url.Values, maxAge time.Duration) (time.Time, error) {
+	unix, err := strconv.ParseInt(fields.Get("auth_date"), 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("login data has no auth_date")
+	}
+	authDate := time.Unix(unix, 0).UTC()
+	if maxAge > 0 && time.Since(authDate) > maxAge {
+		return time.Time{}, ErrExpired
+	}
+	return authDate, nil
+}
@@ -0,0 +1,129 @@
+package auth_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"pigpak/internal/auth"
+)
+
+const botToken = "123456:test-token"
+
+// signFields adds the hash Telegram would send for fields under secret.
+func signFields(fields url.Values, secret []byte) url.Values {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, key+"="+fields.Get(key))
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	fields.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+	return fields
+}
+
+func widgetSecret(token string) []byte {
+	secret := sha256.Sum256([]byte(token))
+	return secret[:]
+}
+
+func webAppSecret(token string) []byte {
+	mac := hmac.New(sha256.New, []byte("WebAppData"))
+	mac.Write([]byte(token))
+	return mac.Sum(nil)
+}
+
+func unix(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func TestVerifyLoginWidget(t *testing.T) {
+	now := time.Now()
+	login := func(authDate time.Time) url.Values {
+		return url.Values{"id": {"42"}, "username": {"alice"}, "first_name": {"Alice"}, "auth_date": {unix(authDate)}}
+	}
+	tests := []struct {
+		name   string
+		fields url.Values
+		want   error
+	}{
+		{"valid hash", signFields(login(now), widgetSecret(botToken)), nil},
+		{"tampered field", func() url.Values {
+			fields := signFields(login(now), widgetSecret(botToken))
+			fields.Set("id", "43")
+			return fields
+		}(), auth.ErrInvalidSignature},
+		{"added field", func() url.Values {
+			fields := signFields(login(now), widgetSecret(botToken))
+			fields.Set("last_name", "Mallory")
+			return fields
+		}(), auth.ErrInvalidSignature},
+		{"wrong bot token", signFields(login(now), widgetSecret("654321:other-token")), auth.ErrInvalidSignature},
+		{"web app secret", signFields(login(now), webAppSecret(botToken)), auth.ErrInvalidSignature},
+		{"no hash", login(now), auth.ErrInvalidSignature},
+		{"expired auth_date", signFields(login(now.Add(-2*time.Hour)), widgetSecret(botToken)), auth.ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := auth.VerifyLoginWidget(botToken, tt.fields, time.Hour)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyLoginWidget error = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			if user.ID != 42 || user.Username != "alice" || user.FirstName != "Alice" || user.AuthDate.Unix() != now.Unix() {
+				t.Fatalf("user = %+v, want alice (42) logged in at %v", user, now.Unix())
+			}
+		})
+	}
+}
+
+func TestVerifyWebAppInitData(t *testing.T) {
+	now := time.Now()
+	initData := func(authDate time.Time) url.Values {
+		return url.Values{"query_id": {"AAE"}, "user": {`{"id":42,"username":"alice"}`}, "auth_date": {unix(authDate)}}
+	}
+	tests := []struct {
+		name     string
+		initData string
+		want     error
+	}{
+		{"valid hash", signFields(initData(now), webAppSecret(botToken)).Encode(), nil},
+		{"tampered field", func() string {
+			fields := signFields(initData(now), webAppSecret(botToken))
+			fields.Set("user", `{"id":43,"username":"mallory"}`)
+			return fields.Encode()
+		}(), auth.ErrInvalidSignature},
+		{"wrong bot token", signFields(initData(now), webAppSecret("654321:other-token")).Encode(), auth.ErrInvalidSignature},
+		{"login widget secret", signFields(initData(now), widgetSecret(botToken)).Encode(), auth.ErrInvalidSignature},
+		{"malformed query", "user=%zz", auth.ErrInvalidSignature},
+		{"expired auth_date", signFields(initData(now.Add(-2*time.Hour)), webAppSecret(botToken)).Encode(), auth.ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := auth.VerifyWebAppInitData(botToken, tt.initData, time.Hour)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyWebAppInitData error = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			if user.ID != 42 || user.Username != "alice" || user.AuthDate.Unix() != now.Unix() {
+				t.Fatalf("user = %+v, want alice (42) logged in at %v", user, now.Unix())
+			}
+		})
+	}
+}
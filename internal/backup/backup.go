@@ -0,0 +1,220 @@
+// Package backup writes and restores pigpak snapshots: a gzipped tar holding
+// an online copy of the SQLite database and a manifest describing it,
+// optionally encrypted with a passphrase.
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"pigpak/internal/config"
+	"pigpak/internal/db"
+)
+
+// FormatVersion is the snapshot layout this build writes and reads.
+const FormatVersion = 1
+
+const (
+	manifestEntry = "manifest.json"
+	databaseEntry = "pigpak.db"
+)
+
+// Manifest describes a snapshot.
+type Manifest struct {
+	Format        int       `json:"format"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Config holds the settings the data depends on, such as the storage
+	// chat. Secrets are never recorded.
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// ConfigManifest picks the settings worth recording with a snapshot.
+func ConfigManifest(cfg config.Config) map[string]string {
+	out := map[string]string{
+		"MAX_PART_SIZE_BYTES": strconv.FormatInt(cfg.MaxPartSizeBytes, 10),
+		"TELEGRAM_LOCAL_API":  strconv.FormatBool(cfg.TelegramLocalAPI),
+	}
+	if cfg.StorageChatID != 0 {
+		out["STORAGE_CHAT_ID"] = strconv.FormatInt(cfg.StorageChatID, 10)
+	}
+	if cfg.BotUsername != "" {
+		out["BOT_USERNAME"] = cfg.BotUsername
+	}
+	if cfg.WebDAVPublicURL != "" {
+		out["WEB_DAV_PUBLIC_URL"] = cfg.WebDAVPublicURL
+	}
+	return out
+}
+
+// Write streams a snapshot of store to w. The database copy is staged in
+// tmpDir, which should be on a disk with room for it.
+func Write(ctx context.Context, store *db.Store, w io.Writer, tmpDir string, settings map[string]string) (Manifest, error) {
+	version, err := store.SchemaVersion(ctx)
+	if err != nil {
+		return Manifest{}, err
+	}
+	staged, err := os.CreateTemp(tmpDir, "pigpak-backup-*.db")
+	if err != nil {
+		return Manifest{}, err
+	}
+	stagedPath := staged.Name()
+	staged.Close()
+	defer os.Remove(stagedPath)
+	if err := store.Backup(ctx, stagedPath); err != nil {
+		return Manifest{}, fmt.Errorf("copy database: %w", err)
+	}
+	m := Manifest{Format: FormatVersion, SchemaVersion: version, CreatedAt: time.Now().UTC(), Config: settings}
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return Manifest{}, err
+	}
+	f, err := os.Open(stagedPath)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: manifestEntry, Mode: 0o600, Size: int64(len(manifest)), ModTime: m.CreatedAt}); err != nil {
+		return Manifest{}, err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return Manifest{}, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: databaseEntry, Mode: 0o600, Size: info.Size(), ModTime: m.CreatedAt}); err != nil {
+		return Manifest{}, err
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return Manifest{}, err
+	}
+	if err := tw.Close(); err != nil {
+		return Manifest{}, err
+	}
+	return m, gz.Close()
+}
+
+// ErrPassphraseRequired reports an encrypted snapshot read without a
+// passphrase.
+var ErrPassphraseRequired = errors.New("snapshot is encrypted; set BACKUP_PASSPHRASE")
+
+// Restore unpacks the snapshot in r as the database at dbPath. Encrypted
+// snapshots need passphrase. The database is checked before it replaces
+// anything; an existing database is kept next to it as dbPath.pre-restore.
+// Nothing may have dbPath open while this runs.
+func Restore(ctx context.Context, r io.Reader, passphrase, dbPath string) (Manifest, error) {
+	br := bufio.NewReader(r)
+	if head, err := br.Peek(len(magic)); err == nil && bytes.Equal(head, []byte(magic)) {
+		if passphrase == "" {
+			return Manifest{}, ErrPassphraseRequired
+		}
+		dec, err := NewDecrypter(br, passphrase)
+		if err != nil {
+			return Manifest{}, err
+		}
+		r = dec
+	} else {
+		r = br
+	}
+	gz, err := gzip.NewReader(r)
+	if errors.Is(err, ErrBadPassphrase) {
+		return Manifest{}, err
+	}
+	if err != nil {
+		return Manifest{}, fmt.Errorf("not a pigpak snapshot: %w", err)
+	}
+	defer gz.Close()
+
+	staged := dbPath + ".restore"
+	defer os.Remove(staged)
+	var m Manifest
+	var haveManifest, haveDB bool
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Manifest{}, err
+		}
+		switch hdr.Name {
+		case manifestEntry:
+			if err := json.NewDecoder(tr).Decode(&m); err != nil {
+				return Manifest{}, fmt.Errorf("read manifest: %w", err)
+			}
+			haveManifest = true
+		case databaseEntry:
+			if err := writeFile(staged, tr); err != nil {
+				return Manifest{}, err
+			}
+			haveDB = true
+		}
+	}
+	if !haveManifest || !haveDB {
+		return Manifest{}, errors.New("snapshot is incomplete")
+	}
+	if m.Format > FormatVersion {
+		return Manifest{}, fmt.Errorf("snapshot format %d is newer than this build supports", m.Format)
+	}
+	if m.SchemaVersion > db.LatestSchemaVersion() {
+		return Manifest{}, fmt.Errorf("snapshot schema version %d is newer than this build (%d)", m.SchemaVersion, db.LatestSchemaVersion())
+	}
+	if err := checkDatabase(ctx, staged); err != nil {
+		return Manifest{}, err
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := os.Rename(dbPath, dbPath+".pre-restore"); err != nil {
+			return Manifest{}, err
+		}
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return Manifest{}, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0o755); err != nil {
+		return Manifest{}, err
+	}
+	return m, os.Rename(staged, dbPath)
+}
+
+func writeFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// checkDatabase opens the staged copy, which also brings its schema up to
+// date, and runs SQLite's integrity check on it.
+func checkDatabase(ctx context.Context, path string) error {
+	store, err := db.Open(path)
+	if err != nil {
+		return fmt.Errorf("open snapshot database: %w", err)
+	}
+	defer store.Close()
+	return store.IntegrityCheck(ctx)
+}
@@ -0,0 +1,196 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Encrypted snapshots start with magic, a key derivation salt and a nonce
+// prefix, followed by AES-256-GCM sealed chunks. Each chunk is a flag byte,
+// marking the last chunk, and a big-endian length; the flag is
+// authenticated so a truncated snapshot fails to decrypt.
+const (
+	magic         = "PIGPAKB1"
+	saltSize      = 16
+	prefixSize    = 8
+	chunkSize     = 1 << 20
+	kdfIterations = 200_000
+)
+
+// ErrBadPassphrase reports a snapshot that does not decrypt with the
+// passphrase given, or that was corrupted.
+var ErrBadPassphrase = errors.New("wrong passphrase or corrupted snapshot")
+
+// deriveKey is PBKDF2-HMAC-SHA256 producing one 32-byte block.
+func deriveKey(passphrase string, salt []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(passphrase))
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < kdfIterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type encrypter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	seq    uint32
+	buf    []byte
+	closed bool
+}
+
+// NewEncrypter returns a writer that encrypts what is written to it into w.
+// Close must be called to write the final chunk; it does not close w.
+func NewEncrypter(w io.Writer, passphrase string) (io.WriteCloser, error) {
+	header := make([]byte, len(magic)+saltSize+prefixSize)
+	copy(header, magic)
+	if _, err := rand.Read(header[len(magic):]); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(passphrase, header[len(magic):len(magic)+saltSize])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encrypter{w: w, aead: aead, prefix: header[len(magic)+saltSize:], buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (e *encrypter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypter")
+	}
+	n := 0
+	for len(p) > 0 {
+		take := min(chunkSize-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:take]...)
+		p, n = p[take:], n+take
+		if len(e.buf) == chunkSize {
+			if err := e.flush(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (e *encrypter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+func (e *encrypter) flush(last bool) error {
+	head := []byte{0, 0, 0, 0, 0}
+	if last {
+		head[0] = 1
+	}
+	sealed := e.aead.Seal(nil, e.nonce(), e.buf, head[:1])
+	binary.BigEndian.PutUint32(head[1:], uint32(len(sealed)))
+	e.seq++
+	e.buf = e.buf[:0]
+	if _, err := e.w.Write(head); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *encrypter) nonce() []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, e.prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], e.seq)
+	return nonce
+}
+
+type decrypter struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	seq    uint32
+	plain  []byte
+	done   bool
+}
+
+// NewDecrypter returns a reader yielding the plaintext of the encrypted
+// snapshot in r.
+func NewDecrypter(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, len(magic)+saltSize+prefixSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrBadPassphrase
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, errors.New("not an encrypted pigpak snapshot")
+	}
+	aead, err := newGCM(passphrase, header[len(magic):len(magic)+saltSize])
+	if err != nil {
+		return nil, err
+	}
+	return &decrypter{r: r, aead: aead, prefix: header[len(magic)+saltSize:]}, nil
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decrypter) next() error {
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, head); err != nil {
+		return ErrBadPassphrase
+	}
+	size := binary.BigEndian.Uint32(head[1:])
+	if size > chunkSize+uint32(d.aead.Overhead()) {
+		return ErrBadPassphrase
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return ErrBadPassphrase
+	}
+	nonce := make([]byte, 12)
+	copy(nonce, d.prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], d.seq)
+	plain, err := d.aead.Open(sealed[:0], nonce, sealed, head[:1])
+	if err != nil {
+		return ErrBadPassphrase
+	}
+	d.seq++
+	d.plain = plain
+	d.done = head[0] == 1
+	return nil
+}
@@ -0,0 +1,159 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// maxAPITokenDays caps the lifetime given with /tokens add.
+const maxAPITokenDays = 3650
+
+const apiTokensUsage = `Usage:
+/tokens to list your API tokens
+/tokens add <name> [ro] [api] [webdav] [<days>d] to create one
+/tokens revoke <name> to revoke it
+
+A token works with the API (Authorization: Bearer <token>) and as a WebDAV password next to your usual username; name api or webdav to allow only one. ro makes it read-only. Without a lifetime it lasts until revoked.`
+
+func (b *Bot) handleAPITokensCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/tokens" {
+		return false
+	}
+	switch {
+	case len(fields) == 1:
+		body, markup, err := b.apiTokensView(ctx, userID)
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Failed to load tokens: %v", err))
+			return true
+		}
+		_, _ = b.tg.SendMessage(ctx, chatID, body, markup)
+	case strings.EqualFold(fields[1], "add") && len(fields) >= 3:
+		b.addAPIToken(ctx, userID, chatID, fields[2], fields[3:])
+	case strings.EqualFold(fields[1], "revoke") && len(fields) == 3:
+		b.revokeAPITokenByName(ctx, userID, chatID, fields[2])
+	default:
+		b.sendText(ctx, chatID, apiTokensUsage)
+	}
+	return true
+}
+
+func (b *Bot) addAPIToken(ctx context.Context, userID, chatID int64, name string, opts []string) {
+	var scopes []string
+	readOnly := false
+	var expiresAt *time.Time
+	for _, opt := range opts {
+		switch opt = strings.ToLower(opt); {
+		case opt == "ro" || opt == "readonly":
+			readOnly = true
+		case opt == db.TokenScopeAPI || opt == db.TokenScopeWebDAV:
+			scopes = append(scopes, opt)
+		case strings.HasSuffix(opt, "d"):
+			days, err := strconv.Atoi(strings.TrimSuffix(opt, "d"))
+			if err != nil || days <= 0 || days > maxAPITokenDays {
+				b.sendText(ctx, chatID, fmt.Sprintf("Lifetime must be between 1d and %dd.", maxAPITokenDays))
+				return
+			}
+			exp := time.Now().UTC().Add(time.Duration(days) * 24 * time.Hour)
+			expiresAt = &exp
+		default:
+			b.sendText(ctx, chatID, apiTokensUsage)
+			return
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = []string{db.TokenScopeAPI, db.TokenScopeWebDAV}
+	}
+	token := db.APITokenPrefix + randomToken(32)
+	t, err := b.store.CreateAPIToken(ctx, userID, name, token, scopes, readOnly, expiresAt)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Create token failed: %v", err))
+		return
+	}
+	text := fmt.Sprintf("API token %s\n%s\n\n%s\n\nThe token is shown only once. Revoke it with /tokens revoke %s", t.Name, token, apiTokenAccess(t), t.Name)
+	b.sendText(ctx, chatID, text)
+}
+
+// revokeAPITokenByName revokes every token of the user called name.
+func (b *Bot) revokeAPITokenByName(ctx context.Context, userID, chatID int64, name string) {
+	tokens, err := b.store.ListAPITokens(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Revoke failed: %v", err))
+		return
+	}
+	revoked := 0
+	for _, t := range tokens {
+		if t.Name != name {
+			continue
+		}
+		if err := b.store.RevokeAPIToken(ctx, userID, t.ID); err != nil && err != sql.ErrNoRows {
+			b.sendText(ctx, chatID, fmt.Sprintf("Revoke failed: %v", err))
+			return
+		}
+		revoked++
+	}
+	if revoked == 0 {
+		b.sendText(ctx, chatID, "No such token.")
+		return
+	}
+	b.sendText(ctx, chatID, fmt.Sprintf("Revoked %s.", name))
+}
+
+func (b *Bot) apiTokensView(ctx context.Context, userID int64) (string, *telegram.InlineKeyboardMarkup, error) {
+	tokens, err := b.store.ListAPITokens(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(tokens) == 0 {
+		return "No API tokens.\n\n" + apiTokensUsage, nil, nil
+	}
+	lines := []string{"API tokens:"}
+	var rows [][]telegram.InlineKeyboardButton
+	current := time.Now().UTC()
+	for _, t := range tokens {
+		used := "never used"
+		if t.LastUsedAt.Valid {
+			used = "last used " + t.LastUsedAt.Time.Format("2006-01-02 15:04 MST")
+		}
+		expiry := ""
+		if t.ExpiresAt.Valid {
+			expiry = ", until " + t.ExpiresAt.Time.Format("2006-01-02")
+			if !current.Before(t.ExpiresAt.Time) {
+				expiry = ", expired"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s…) - %s%s, %s", t.Name, t.Prefix, apiTokenAccess(t), expiry, used))
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: "Revoke " + t.Name, CallbackData: fmt.Sprintf("untoken:%d", t.ID)}})
+	}
+	return strings.Join(lines, "\n"), &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+// revokeAPIToken revokes a token from the list view and refreshes it.
+func (b *Bot) revokeAPIToken(ctx context.Context, userID, chatID int64, msgID int, tokenID int64) {
+	if err := b.store.RevokeAPIToken(ctx, userID, tokenID); err != nil && err != sql.ErrNoRows {
+		b.sendText(ctx, chatID, fmt.Sprintf("Revoke failed: %v", err))
+		return
+	}
+	body, markup, err := b.apiTokensView(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load tokens: %v", err))
+		return
+	}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, body, markup)
+}
+
+// apiTokenAccess describes where a token works and what it may do.
+func apiTokenAccess(t db.APIToken) string {
+	mode := "read-write"
+	if t.ReadOnly {
+		mode = "read-only"
+	}
+	return fmt.Sprintf("Access: %s, %s", mode, strings.Join(t.Scopes, " and "))
+}
@@ -0,0 +1,39 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// auditPruneInterval spaces out passes dropping expired audit log entries,
+// and outboxPruneInterval those dropping processed outbox events.
+const (
+	auditPruneInterval  = time.Hour
+	outboxPruneInterval = time.Hour
+)
+
+// pruneAudit drops audit log entries older than AUDIT_RETENTION.
+func (b *Bot) pruneAudit(ctx context.Context) {
+	n, err := b.store.PruneAudit(ctx, b.cfg.AuditRetention)
+	if err != nil {
+		log.Printf("prune audit log: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("pruned %d audit log entries", n)
+	}
+}
+
+// pruneOutbox drops outbox events older than OUTBOX_RETENTION that every
+// consumer has processed.
+func (b *Bot) pruneOutbox(ctx context.Context) {
+	n, err := b.store.PruneOutbox(ctx, b.cfg.OutboxRetention)
+	if err != nil {
+		log.Printf("prune outbox: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("pruned %d outbox events", n)
+	}
+}
@@ -0,0 +1,84 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"pigpak/internal/backup"
+)
+
+// uploadBackup writes an encrypted snapshot of the database and uploads it
+// to the storage chat. Runs in the background; backupRunning keeps passes
+// from overlapping when an upload outlasts BACKUP_INTERVAL.
+func (b *Bot) uploadBackup(ctx context.Context) {
+	if !b.backupRunning.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer b.backupRunning.Store(false)
+		if err := b.writeBackupToChat(ctx); err != nil {
+			log.Printf("scheduled backup: %v", err)
+		}
+	}()
+}
+
+// ErrBusy is returned when a maintenance pass or backup is started while
+// another is running.
+var ErrBusy = errors.New("already running")
+
+// ErrNoBackup is returned by Backup when BACKUP_PASSPHRASE or
+// STORAGE_CHAT_ID is not set.
+var ErrNoBackup = errors.New("backups need BACKUP_PASSPHRASE and STORAGE_CHAT_ID")
+
+// Backup writes and uploads a snapshot as uploadBackup does, waiting for
+// the upload. It returns ErrBusy when a backup is already running.
+func (b *Bot) Backup(ctx context.Context) error {
+	if b.cfg.BackupPassphrase == "" || b.cfg.StorageChatID == 0 {
+		return ErrNoBackup
+	}
+	if !b.backupRunning.CompareAndSwap(false, true) {
+		return ErrBusy
+	}
+	defer b.backupRunning.Store(false)
+	return b.writeBackupToChat(ctx)
+}
+
+func (b *Bot) writeBackupToChat(ctx context.Context) error {
+	f, err := os.CreateTemp(b.cfg.DataDir, "pigpak-backup-*.tar.gz.enc")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	enc, err := backup.NewEncrypter(f, b.cfg.BackupPassphrase)
+	if err != nil {
+		return err
+	}
+	m, err := backup.Write(ctx, b.store, enc, b.cfg.DataDir, backup.ConfigManifest(b.cfg))
+	if err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if size > b.cfg.MaxPartSizeBytes {
+		return fmt.Errorf("snapshot is %s, over the %s upload limit", formatBytes(size), formatBytes(b.cfg.MaxPartSizeBytes))
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	name := "pigpak-" + m.CreatedAt.Format("20060102-150405") + ".tar.gz.enc"
+	if _, err := b.tg.UploadDocument(ctx, b.cfg.StorageChatID, name, f); err != nil {
+		return err
+	}
+	log.Printf("uploaded backup %s (%s) to the storage chat", name, formatBytes(size))
+	return nil
+}
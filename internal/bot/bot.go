@@ -5,11 +5,15 @@ import (
 	"crypto/rand"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"pigpak/internal/auth"
 	"pigpak/internal/config"
 	"pigpak/internal/db"
+	"pigpak/internal/media"
 	"pigpak/internal/telegram"
 )
 
@@ -17,16 +21,39 @@ import (
 type Bot struct {
 	cfg         config.Config
 	store       *db.Store
-	tg          *telegram.Client
+	tg          telegram.BotAPI
 	botUsername string
+	signer      *auth.Signer
+	// notifyQueued is set while notifications wait in the offline queue.
+	notifyQueued atomic.Bool
+	// lastAuditPrune is when old audit log entries were last dropped.
+	lastAuditPrune time.Time
+	// lastOutboxPrune is when processed outbox events were last dropped.
+	lastOutboxPrune time.Time
+	// lastUploadExpiry is when stale WebDAV upload sessions were last
+	// dropped.
+	lastUploadExpiry time.Time
+	// lastBackup is when the scheduled backup last started; backupRunning
+	// is set while one is being written or uploaded.
+	lastBackup    time.Time
+	backupRunning atomic.Bool
+	// lastMaintenance is when the scheduled maintenance pass last started.
+	lastMaintenance time.Time
+	maintenance     maintenanceState
+	updates         *dispatcher
 }
 
 // New creates a bot instance.
-func New(cfg config.Config, store *db.Store, tg *telegram.Client) *Bot {
-	return &Bot{cfg: cfg, store: store, tg: tg, botUsername: cfg.BotUsername}
+func New(cfg config.Config, store *db.Store, tg telegram.BotAPI) *Bot {
+	signer, _ := auth.NewSigner(auth.ResolveSecret(cfg.SessionSecret, cfg.BotToken))
+	b := &Bot{cfg: cfg, store: store, tg: tg, botUsername: cfg.BotUsername, signer: signer}
+	b.updates = newDispatcher(cfg.UpdateWorkers, b.handleUpdate)
+	return b
 }
 
-// Run starts polling and handling updates.
+// Run starts polling and handling updates. Updates from different users are
+// handled concurrently. Once ctx is cancelled Run stops polling and waits up
+// to DrainTimeout for in-flight updates before returning.
 func (b *Bot) Run(ctx context.Context) error {
 	if b.botUsername == "" {
 		if me, err := b.tg.GetMe(ctx); err == nil {
@@ -34,32 +61,170 @@ func (b *Bot) Run(ctx context.Context) error {
 		}
 	}
 
-	offset := 0
+	backlog := false
+	b.notifyQueued.Store(true)
+	go b.runNotificationFlusher(ctx)
+	go b.runStorageSweeper(ctx)
+	// The first scheduled backup waits a full interval, so restarts do not
+	// each upload a snapshot.
+	b.lastBackup = time.Now()
+	defer func() {
+		if !b.updates.wait(DrainTimeout) {
+			log.Printf("stopped with updates still running after %s", DrainTimeout)
+		}
+		b.confirmUpdates()
+	}()
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
 		}
-		updates, err := b.tg.GetUpdates(ctx, offset, int(b.cfg.PollTimeout.Seconds()))
+		// While draining a backlog, skip long polling so queued batches are fetched back to back.
+		timeout := int(b.cfg.PollTimeout.Seconds())
+		if backlog {
+			timeout = 0
+		}
+		// Updates still waiting for a handler are not confirmed, so Telegram
+		// sends them again; widen the batch so new ones still fit.
+		limit := min(b.cfg.UpdatesLimit+b.updates.pending(), 100)
+		updates, err := b.tg.GetUpdates(ctx, b.updates.offset(), limit, timeout)
 		if err != nil {
 			log.Printf("getUpdates error: %v", err)
-			time.Sleep(2 * time.Second)
+			// The client already retried transient failures; a flood wait
+			// longer than it honours is left for us to sit out.
+			wait := 2 * time.Second
+			if d := telegram.RetryAfter(err); d > wait {
+				wait = d
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
 			continue
 		}
-		for _, upd := range updates {
-			offset = upd.UpdateID + 1
-			if upd.Message != nil {
-				b.handleMessage(ctx, upd.Message)
-				continue
-			}
-			if upd.CallbackQuery != nil {
-				b.handleCallback(ctx, upd.CallbackQuery)
+		fresh := b.updates.unseen(updates)
+		backlog = b.cfg.UpdatesLimit > 0 && len(fresh) >= b.cfg.UpdatesLimit
+		if b.cfg.AuditRetention > 0 && time.Since(b.lastAuditPrune) >= auditPruneInterval {
+			b.lastAuditPrune = time.Now()
+			b.pruneAudit(ctx)
+		}
+		if b.cfg.OutboxRetention > 0 && time.Since(b.lastOutboxPrune) >= outboxPruneInterval {
+			b.lastOutboxPrune = time.Now()
+			b.pruneOutbox(ctx)
+		}
+		if b.cfg.WebDAVUploadTTL > 0 && time.Since(b.lastUploadExpiry) >= uploadExpiryInterval {
+			b.lastUploadExpiry = time.Now()
+			b.expireUploads(ctx)
+		}
+		if b.cfg.MaintenanceInterval > 0 && time.Since(b.lastMaintenance) >= b.cfg.MaintenanceInterval {
+			b.lastMaintenance = time.Now()
+			b.startMaintenance(ctx, nil)
+		}
+		if b.cfg.BackupInterval > 0 && time.Since(b.lastBackup) >= b.cfg.BackupInterval {
+			b.lastBackup = time.Now()
+			b.uploadBackup(ctx)
+		}
+		if len(fresh) == 0 && len(updates) > 0 {
+			// Only updates already queued came back. Wait for a handler to
+			// pick one up rather than polling again straight away.
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-b.updates.started:
+			case <-time.After(time.Second):
 			}
+			continue
+		}
+		b.processBatch(ctx, fresh)
+	}
+}
+
+// processBatch dispatches one getUpdates batch, dropping navigation
+// callbacks that a later callback on the same message supersedes. It stops
+// early if ctx is cancelled while the dispatcher is full; the rest of the
+// batch stays unconfirmed and is fetched again on the next start.
+func (b *Bot) processBatch(ctx context.Context, updates []telegram.Update) {
+	for _, upd := range coalesceUpdates(updates) {
+		if upd.skip {
+			_ = b.tg.AnswerCallbackQuery(ctx, upd.CallbackQuery.ID, "")
+			b.updates.settle(upd.UpdateID)
+			continue
+		}
+		if !b.updates.dispatch(ctx, upd.Update) {
+			return
 		}
 	}
 }
 
+// confirmUpdates moves the getUpdates offset past every update handed to a
+// handler before Run returns, so a restart neither repeats them nor loses
+// those that were still waiting.
+func (b *Bot) confirmUpdates() {
+	offset := b.updates.offset()
+	if offset == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := b.tg.GetUpdates(ctx, offset, 1, 0); err != nil {
+		log.Printf("confirm updates: %v", err)
+	}
+}
+
+func (b *Bot) handleUpdate(ctx context.Context, upd telegram.Update) {
+	var actorID int64
+	if id := updateUserID(upd); id > 0 {
+		actorID = id
+	}
+	ctx = db.WithActor(ctx, db.AuditSourceBot, actorID)
+	if upd.Message != nil {
+		b.handleMessage(ctx, upd.Message)
+		return
+	}
+	if upd.CallbackQuery != nil {
+		b.handleCallback(ctx, upd.CallbackQuery)
+		return
+	}
+	if upd.ChannelPost != nil {
+		b.handleChannelPost(ctx, upd.ChannelPost)
+	}
+}
+
+type batchedUpdate struct {
+	telegram.Update
+	skip bool
+}
+
+// coalesceUpdates marks navigation callbacks as skippable when a newer
+// navigation callback for the same chat message appears later in the batch.
+func coalesceUpdates(updates []telegram.Update) []batchedUpdate {
+	type messageKey struct {
+		chatID    int64
+		messageID int
+	}
+	out := make([]batchedUpdate, len(updates))
+	latest := make(map[messageKey]int)
+	for i, upd := range updates {
+		out[i] = batchedUpdate{Update: upd}
+		cb := upd.CallbackQuery
+		if cb == nil || cb.Message == nil || !isNavigationCallback(cb.Data) {
+			continue
+		}
+		key := messageKey{chatID: cb.Message.Chat.ID, messageID: cb.Message.MessageID}
+		if prev, ok := latest[key]; ok {
+			out[prev].skip = true
+		}
+		latest[key] = i
+	}
+	return out
+}
+
+func isNavigationCallback(data string) bool {
+	return strings.HasPrefix(data, "nav:") || strings.HasPrefix(data, "pick:")
+}
+
 func (b *Bot) handleMessage(ctx context.Context, msg *telegram.Message) {
 	if msg == nil || msg.From == nil {
 		return
@@ -74,17 +239,77 @@ func (b *Bot) handleMessage(ctx context.Context, msg *telegram.Message) {
 	b.trackUser(ctx, msg.From)
 
 	if msg.Text != "" {
-		if b.handleStart(ctx, userID, chatID, msg.Text) {
+		if b.handleStart(ctx, msg.From, chatID, msg.Text) {
+			return
+		}
+		if b.handleSetupCommand(ctx, msg.From, chatID, msg.Text) {
 			return
 		}
 		if b.handleWebDAVCommand(ctx, chatID, msg.From, msg.Text) {
 			return
 		}
+		if b.handleExportCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleFavoritesCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleTagCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleDebugCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handlePublishCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleRulesCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleUsageCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleFsckCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleFileRequestCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleTrashCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleSearchCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleSharesCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleShareFolderCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleAPITokensCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleWebhooksCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleShortcutCommand(ctx, msg.From, chatID, msg.Text) {
+			return
+		}
+		if b.handleStorageCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handlePartSizeCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
+		if b.handleMaintenanceCommand(ctx, userID, chatID, msg.Text) {
+			return
+		}
 		if b.handlePendingText(ctx, userID, chatID, msg.Text) {
 			return
 		}
 		if strings.HasPrefix(msg.Text, "/help") {
-			b.sendHelp(ctx, chatID)
+			b.sendHelp(ctx, chatID, msg.From)
 			return
 		}
 		b.sendDirectoryView(ctx, userID, chatID, 0, 0)
@@ -92,25 +317,33 @@ func (b *Bot) handleMessage(ctx context.Context, msg *telegram.Message) {
 	}
 
 	if file := extractFile(msg); file != nil {
-		b.handleUpload(ctx, userID, chatID, file)
+		b.handleUpload(ctx, msg.From, chatID, file)
 		return
 	}
 }
 
-func (b *Bot) handleStart(ctx context.Context, userID, chatID int64, text string) bool {
+func (b *Bot) handleStart(ctx context.Context, user *telegram.User, chatID int64, text string) bool {
 	if !strings.HasPrefix(text, "/start") {
 		return false
 	}
+	userID := user.ID
 	parts := strings.Fields(text)
 	if len(parts) > 1 {
 		payload := parts[1]
 		if strings.HasPrefix(payload, "share_") {
 			token := strings.TrimPrefix(payload, "share_")
-			b.handleSharePreview(ctx, userID, chatID, token)
+			b.handleSharePreview(ctx, user, chatID, token)
 			return true
 		}
+		if strings.HasPrefix(payload, "req_") {
+			b.handleFileRequestStart(ctx, user, chatID, strings.TrimPrefix(payload, "req_"))
+			return true
+		}
+	}
+	if b.maybeStartOnboarding(ctx, user, chatID) {
+		return true
 	}
-	b.sendHelp(ctx, chatID)
+	b.sendHelp(ctx, chatID, user)
 	b.sendDirectoryView(ctx, userID, chatID, 0, 0)
 	return true
 }
@@ -172,14 +405,41 @@ func (b *Bot) handlePendingText(ctx context.Context, userID, chatID int64, text
 		_ = b.store.ClearPendingAction(ctx, userID)
 		b.sendDirectoryView(ctx, userID, chatID, file.DirID, 0)
 		return true
+	case "new_drive", "rename_drive", "drive_storage":
+		b.handleDriveText(ctx, userID, chatID, state, text)
+		return true
+	case "share_private":
+		b.handleSharePrivateText(ctx, userID, chatID, state, text)
+		return true
+	case "share_password":
+		b.handleSharePasswordText(ctx, userID, chatID, state, text)
+		return true
+	case "user_storage":
+		b.handleStorageText(ctx, userID, chatID, text)
+		return true
+	case "onboard_webdav":
+		b.handleOnboardingPassword(ctx, userID, chatID, state, text)
+		return true
+	case "tag_file":
+		b.handleTagText(ctx, userID, chatID, state, text)
+		return true
+	case "trash_search":
+		_ = b.store.ClearPendingAction(ctx, userID)
+		b.sendTrashView(ctx, userID, chatID, text)
+		return true
 	default:
 		return false
 	}
 }
 
-func (b *Bot) sendHelp(ctx context.Context, chatID int64) {
-	text := "Send files to upload. Use the buttons to browse folders, share files, and manage directories. Use /webdav or /webdav set <password> for WebDAV access."
-	_, _ = b.tg.SendMessage(ctx, chatID, text, nil)
+func (b *Bot) sendHelp(ctx context.Context, chatID int64, user *telegram.User) {
+	_, _ = b.tg.SendMessage(ctx, chatID, b.helpText(user), nil)
+}
+
+func (b *Bot) helpText(user *telegram.User) string {
+	text := "Send files to upload. Use the buttons to browse folders, share files, and manage directories. Use /webdav or /webdav set <password> for WebDAV access and /webdav temp <hours> [ro] [/folder] for a short-lived login. Use /tokens to create revocable API tokens and WebDAV app passwords, /webhooks to have file and share events posted to your own URLs and /storage to keep WebDAV uploads in your own private channel or group. Use /partsize to change the part size your WebDAV uploads are split into. Use the Drives button in a root folder to switch drives and /usage to see how much each holds. Use /rules to file uploads into folders automatically. Use /fav to manage quick destinations, /shortcut <path or link> to add a shortcut to the current folder, /tag [name] to list tags or find files tagged with one, /search to find files by name, type, size, date or folder (e.g. /search type:video size:>100MB), /shares to list and revoke your share links, /sharefolder [days] [password] to share the current folder as a web gallery, /export for a JSON/CSV dump of your drive, /publish to turn the current folder into a public download page, /request to let others upload into the current folder, /trash [name] to search and restore deleted files and /fsck to list files Telegram no longer serves. Use /setup to rerun the setup wizard."
+	text += "\n\n" + b.sizeGuidance(user)
+	return text
 }
 
 func (b *Bot) sendText(ctx context.Context, chatID int64, text string) {
@@ -199,6 +459,13 @@ func (b *Bot) handleWebDAVCommand(ctx context.Context, chatID int64, user *teleg
 		b.sendText(ctx, chatID, "WebDAV is disabled on this server.")
 		return true
 	}
+	if len(fields) >= 2 && user != nil {
+		switch strings.ToLower(fields[1]) {
+		case "temp", "revoke":
+			b.handleWebDAVTempCommand(ctx, chatID, user, fields[1:])
+			return true
+		}
+	}
 	if user == nil || strings.TrimSpace(user.Username) == "" {
 		b.sendText(ctx, chatID, "Set a Telegram username first, then retry /webdav.")
 		return true
@@ -258,8 +525,8 @@ func (b *Bot) sendWebDAVInfo(ctx context.Context, chatID int64, user *telegram.U
 		setHint = "\nSet it with: /webdav set <password>"
 	}
 	uploadNote := ""
-	if b.cfg.StorageChatID == 0 {
-		uploadNote = "\nNote: WebDAV uploads are disabled (STORAGE_CHAT_ID not set)."
+	if _, err := b.store.GetUserStorage(ctx, user.ID); err != nil && b.cfg.StorageChatID == 0 {
+		uploadNote = "\nNote: WebDAV uploads are disabled until you set up a storage chat with /storage."
 	}
 	text := fmt.Sprintf("WebDAV URL: %s\nUsername: %s\nPassword: %s%s%s", url, user.Username, passwordStatus, setHint, uploadNote)
 	b.sendText(ctx, chatID, text)
@@ -276,27 +543,62 @@ func (b *Bot) webdavURL() string {
 	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
 		return addr
 	}
+	scheme := "http://"
+	if b.cfg.WebDAVTLS() {
+		scheme = "https://"
+	}
 	if strings.HasPrefix(addr, ":") {
-		return "http://<server-host>" + addr
+		host := "<server-host>"
+		if len(b.cfg.WebDAVACMEDomains) > 0 {
+			host = b.cfg.WebDAVACMEDomains[0]
+			if addr == ":443" {
+				addr = ""
+			}
+		}
+		return scheme + host + addr
 	}
-	return "http://" + addr
+	return scheme + addr
 }
 
-func (b *Bot) handleUpload(ctx context.Context, userID, chatID int64, file *incomingFile) {
+func (b *Bot) handleUpload(ctx context.Context, user *telegram.User, chatID int64, file *incomingFile) {
+	userID := user.ID
+	if b.handleRestoreUpload(ctx, userID, chatID, file) {
+		return
+	}
+	b.probeUpload(ctx, file)
+	if b.handleFileRequestUpload(ctx, user, chatID, file) {
+		return
+	}
 	dirID, err := b.store.GetCurrentDirID(ctx, userID)
 	if err != nil {
 		b.sendText(ctx, chatID, "Failed to locate current folder.")
 		return
 	}
-	rec, err := b.store.CreateFile(ctx, userID, dirID, file.Name, file.FileID, file.FileUniqueID, file.Size, file.MimeType)
+	targetID, rule := b.uploadTarget(ctx, userID, dirID, file)
+	rec, err := b.store.CreateFile(ctx, userID, targetID, file.Name, file.FileID, file.FileUniqueID, file.Size, file.MimeType)
+	if err != nil && rule != nil {
+		// The rule's folder may already hold a file with this name; keep the
+		// upload where the user is instead of failing it.
+		rule = nil
+		rec, err = b.store.CreateFile(ctx, userID, dirID, file.Name, file.FileID, file.FileUniqueID, file.Size, file.MimeType)
+	}
 	if err != nil {
 		b.sendText(ctx, chatID, fmt.Sprintf("Save file failed: %v", err))
 		return
 	}
+	b.recordFileMessage(ctx, userID, rec.ID, file)
+	b.recordFileMetadata(ctx, userID, rec.ID, file)
+	b.recordThumbnail(ctx, userID, rec.ID, file)
 	b.sendFileDetail(ctx, userID, chatID, rec, "")
+	if rule != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Filed into %s by rule \"%s\".", b.ruleTargetPath(ctx, userID, *rule), rule.Expr))
+	}
+	if note := b.downloadNote(rec.Size); note != "" {
+		b.sendText(ctx, chatID, note)
+	}
 }
 
-func (b *Bot) handleSharePreview(ctx context.Context, userID, chatID int64, token string) {
+func (b *Bot) handleSharePreview(ctx context.Context, user *telegram.User, chatID int64, token string) {
 	share, file, err := b.store.GetShareByToken(ctx, token)
 	if err != nil {
 		b.sendText(ctx, chatID, "Share not found.")
@@ -306,11 +608,15 @@ func (b *Bot) handleSharePreview(ctx context.Context, userID, chatID int64, toke
 		b.sendText(ctx, chatID, "Share link expired.")
 		return
 	}
+	if !b.canOpenShare(ctx, share, user) {
+		b.sendText(ctx, chatID, "This share is for another Telegram user.")
+		return
+	}
 	text := fmt.Sprintf("Shared file: %s\nSize: %s", file.Name, formatBytes(file.Size))
 	markup := &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{
 		{{Text: "Save to my drive", CallbackData: fmt.Sprintf("share_save:%s", token)}},
 	}}
-	_, _ = b.tg.SendMessage(ctx, chatID, text, markup)
+	b.sendWithPreview(ctx, chatID, file, text, markup)
 }
 
 func (b *Bot) handleCallback(ctx context.Context, cb *telegram.CallbackQuery) {
@@ -364,11 +670,11 @@ func (b *Bot) handleCallback(ctx context.Context, cb *telegram.CallbackQuery) {
 		b.sendText(ctx, chatID, "Send new file name.")
 	case strings.HasPrefix(data, "deldir:"):
 		dirID := parseInt64(strings.TrimPrefix(data, "deldir:"))
-		if err := b.store.DeleteDirRecursive(ctx, userID, dirID); err != nil {
+		rootID := b.driveRootFor(ctx, userID, dirID)
+		if _, err := b.store.TrashDir(ctx, userID, dirID); err != nil {
 			b.sendText(ctx, chatID, fmt.Sprintf("Delete folder failed: %v", err))
 			return
 		}
-		rootID, _ := b.store.GetRootDirID(ctx, userID)
 		b.editDirectoryView(ctx, userID, chatID, msgID, rootID, 0)
 	case strings.HasPrefix(data, "delfile:"):
 		fileID := parseInt64(strings.TrimPrefix(data, "delfile:"))
@@ -377,7 +683,7 @@ func (b *Bot) handleCallback(ctx context.Context, cb *telegram.CallbackQuery) {
 			b.sendText(ctx, chatID, "File not found.")
 			return
 		}
-		if err := b.store.DeleteFile(ctx, userID, fileID); err != nil {
+		if _, err := b.store.TrashFile(ctx, userID, fileID); err != nil {
 			b.sendText(ctx, chatID, fmt.Sprintf("Delete file failed: %v", err))
 			return
 		}
@@ -389,16 +695,9 @@ func (b *Bot) handleCallback(ctx context.Context, cb *telegram.CallbackQuery) {
 			b.sendText(ctx, chatID, "File not found.")
 			return
 		}
-		parts, err := b.store.ListFileParts(ctx, file.ID)
-		if err != nil {
-			b.sendText(ctx, chatID, fmt.Sprintf("Load parts failed: %v", err))
-			return
-		}
-		if len(parts) == 0 {
-			_, _ = b.tg.SendDocument(ctx, chatID, file.FileID, file.Name, nil)
-			return
-		}
-		b.sendFileParts(ctx, chatID, file, parts)
+		b.sendStoredFile(ctx, userID, chatID, msgID, file)
+	case strings.HasPrefix(data, "verify:"):
+		b.startVerify(ctx, userID, chatID, msgID, parseInt64(strings.TrimPrefix(data, "verify:")))
 	case strings.HasPrefix(data, "share:"):
 		parts := strings.Split(data, ":")
 		if len(parts) != 3 {
@@ -417,26 +716,128 @@ func (b *Bot) handleCallback(ctx context.Context, cb *telegram.CallbackQuery) {
 			expiresAt = &exp
 		}
 		token := randomToken(16)
-		share, err := b.store.CreateShare(ctx, file.ID, token, expiresAt)
+		share, err := b.store.CreateShare(ctx, userID, file.ID, token, expiresAt)
 		if err != nil {
 			b.sendText(ctx, chatID, fmt.Sprintf("Share failed: %v", err))
 			return
 		}
 		link := b.shareURL(share.Token)
 		b.editFileDetail(ctx, userID, chatID, msgID, file, link)
-	case strings.HasPrefix(data, "mvfile:"):
-		fileID := parseInt64(strings.TrimPrefix(data, "mvfile:"))
-		_ = b.store.SetPendingAction(ctx, userID, "move_file", fileID, "")
-		rootID, _ := b.store.GetRootDirID(ctx, userID)
+	case data == "reqdone":
+		b.endFileRequestSession(ctx, userID, chatID)
+	case strings.HasPrefix(data, "shareto:"):
+		fileID := parseInt64(strings.TrimPrefix(data, "shareto:"))
+		if _, err := b.store.GetFileByID(ctx, userID, fileID); err != nil {
+			b.sendText(ctx, chatID, "File not found.")
+			return
+		}
+		_ = b.store.SetPendingAction(ctx, userID, "share_private", fileID, "")
+		b.sendText(ctx, chatID, "Send @username or a numeric Telegram user ID to share with, optionally followed by the number of days (e.g. @alice 7). Only that user will be able to open the link.")
+	case strings.HasPrefix(data, "mvfile:"), strings.HasPrefix(data, "cpfile:"):
+		action := "move_file"
+		if strings.HasPrefix(data, "cpfile:") {
+			action = "copy_file"
+		}
+		fileID := parseInt64(data[len("mvfile:"):])
+		_ = b.store.SetPendingAction(ctx, userID, action, fileID, "")
+		var rootID int64
+		if file, err := b.store.GetFileByID(ctx, userID, fileID); err == nil {
+			rootID = b.driveRootFor(ctx, userID, file.DirID)
+		} else {
+			rootID, _ = b.store.GetRootDirID(ctx, userID)
+		}
 		b.editDirectoryPicker(ctx, userID, chatID, msgID, rootID)
 	case strings.HasPrefix(data, "mvdir:"):
 		dirID := parseInt64(strings.TrimPrefix(data, "mvdir:"))
 		_ = b.store.SetPendingAction(ctx, userID, "move_dir", dirID, "")
-		rootID, _ := b.store.GetRootDirID(ctx, userID)
-		b.editDirectoryPicker(ctx, userID, chatID, msgID, rootID)
+		b.editDirectoryPicker(ctx, userID, chatID, msgID, b.driveRootFor(ctx, userID, dirID))
+	case strings.HasPrefix(data, "tags:"), strings.HasPrefix(data, "tagadd:"), strings.HasPrefix(data, "untag:"), strings.HasPrefix(data, "tagq:"):
+		b.handleTagCallback(ctx, userID, chatID, msgID, data)
+	case strings.HasPrefix(data, "ruleup:"), strings.HasPrefix(data, "rulewd:"), strings.HasPrefix(data, "ruledel:"):
+		b.handleRuleCallback(ctx, userID, chatID, msgID, data)
+	case data == "drives" || strings.HasPrefix(data, "drv"):
+		b.handleDriveCallback(ctx, userID, chatID, msgID, data)
+	case data == "ustore" || data == "ustoreclr":
+		b.handleStorageCallback(ctx, userID, chatID, msgID, data)
+	case data == "trash" || data == "trsearch" || strings.HasPrefix(data, "trempty") || strings.HasPrefix(data, "trit:") || strings.HasPrefix(data, "trdel:") ||
+		strings.HasPrefix(data, "trres:") || strings.HasPrefix(data, "trto:") || strings.HasPrefix(data, "trkeep:") || strings.HasPrefix(data, "trrepl:"):
+		b.handleTrashCallback(ctx, userID, chatID, msgID, data)
+	case strings.HasPrefix(data, "unshare:"):
+		b.revokeShare(ctx, userID, chatID, msgID, parseInt64(strings.TrimPrefix(data, "unshare:")))
+	case strings.HasPrefix(data, "unsharedir:"):
+		b.revokeFolderShare(ctx, userID, chatID, msgID, parseInt64(strings.TrimPrefix(data, "unsharedir:")))
+	case strings.HasPrefix(data, "sharepw:"):
+		b.askSharePassword(ctx, userID, chatID, parseInt64(strings.TrimPrefix(data, "sharepw:")))
+	case strings.HasPrefix(data, "preview:"):
+		b.sendPreview(ctx, userID, chatID, parseInt64(strings.TrimPrefix(data, "preview:")))
+	case strings.HasPrefix(data, "dlink:"):
+		b.sendDirectLink(ctx, userID, chatID, parseInt64(strings.TrimPrefix(data, "dlink:")))
+	case strings.HasPrefix(data, "untoken:"):
+		b.revokeAPIToken(ctx, userID, chatID, msgID, parseInt64(strings.TrimPrefix(data, "untoken:")))
+	case strings.HasPrefix(data, "unhook:"):
+		b.removeWebhook(ctx, userID, chatID, msgID, parseInt64(strings.TrimPrefix(data, "unhook:")))
+	case strings.HasPrefix(data, "sc:"):
+		b.openShortcut(ctx, cb.From, chatID, msgID, parseInt64(strings.TrimPrefix(data, "sc:")))
+	case strings.HasPrefix(data, "unsc:"):
+		b.removeShortcut(ctx, userID, chatID, msgID, parseInt64(strings.TrimPrefix(data, "unsc:")))
+	case strings.HasPrefix(data, "onb:"):
+		b.handleOnboardingCallback(ctx, cb.From, chatID, msgID, strings.TrimPrefix(data, "onb:"))
+	case strings.HasPrefix(data, "restore:"):
+		fileID := parseInt64(strings.TrimPrefix(data, "restore:"))
+		if _, err := b.store.GetFileByID(ctx, userID, fileID); err != nil {
+			b.sendText(ctx, chatID, "File not found.")
+			return
+		}
+		_ = b.store.SetPendingAction(ctx, userID, "restore_file", fileID, "")
+		b.sendText(ctx, chatID, "Forward or re-send the original file from your chat history. It is matched by content, so the name does not matter.")
+	case strings.HasPrefix(data, "marklost:"):
+		fileID := parseInt64(strings.TrimPrefix(data, "marklost:"))
+		if err := b.store.SetFileRefState(ctx, userID, fileID, db.RefStateLost); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Update file failed: %v", err))
+			return
+		}
+		file, err := b.store.GetFileByID(ctx, userID, fileID)
+		if err != nil {
+			b.sendText(ctx, chatID, "File not found.")
+			return
+		}
+		b.editFileDetail(ctx, userID, chatID, msgID, file, "")
+	case strings.HasPrefix(data, "mvto:"):
+		parts := strings.Split(data, ":")
+		if len(parts) != 3 {
+			return
+		}
+		fileID := parseInt64(parts[1])
+		dirID := parseInt64(parts[2])
+		if err := b.store.MoveFile(ctx, userID, fileID, dirID); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Move file failed: %v", err))
+			return
+		}
+		file, err := b.store.GetFileByID(ctx, userID, fileID)
+		if err != nil {
+			b.sendText(ctx, chatID, "File not found.")
+			return
+		}
+		b.editFileDetail(ctx, userID, chatID, msgID, file, "")
+	case strings.HasPrefix(data, "favadd:"):
+		dirID := parseInt64(strings.TrimPrefix(data, "favadd:"))
+		if err := b.addFavorite(ctx, userID, dirID, ""); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Add favorite failed: %v", err))
+			return
+		}
+		b.editFavoritesView(ctx, userID, chatID, msgID)
+	case strings.HasPrefix(data, "favdel:"):
+		favID := parseInt64(strings.TrimPrefix(data, "favdel:"))
+		if err := b.store.DeleteFavorite(ctx, userID, favID); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Remove favorite failed: %v", err))
+			return
+		}
+		b.editFavoritesView(ctx, userID, chatID, msgID)
 	case strings.HasPrefix(data, "pick:"):
 		dirID := parseInt64(strings.TrimPrefix(data, "pick:"))
 		b.editDirectoryPicker(ctx, userID, chatID, msgID, dirID)
+	case strings.HasPrefix(data, "pickdrv:"):
+		b.editDrivePicker(ctx, userID, chatID, msgID, parseInt64(strings.TrimPrefix(data, "pickdrv:")))
 	case strings.HasPrefix(data, "picksel:"):
 		dirID := parseInt64(strings.TrimPrefix(data, "picksel:"))
 		state, err := b.store.GetUserState(ctx, userID)
@@ -445,18 +846,30 @@ func (b *Bot) handleCallback(ctx context.Context, cb *telegram.CallbackQuery) {
 			return
 		}
 		switch state.PendingAction.String {
-		case "move_file":
-			fileID := state.PendingTarget.Int64
-			if err := b.store.MoveFile(ctx, userID, fileID, dirID); err != nil {
-				b.sendText(ctx, chatID, fmt.Sprintf("Move file failed: %v", err))
+		case "move_file", "copy_file":
+			move := state.PendingAction.String == "move_file"
+			if err := b.transferFile(ctx, userID, chatID, state.PendingTarget.Int64, dirID, move); err != nil {
+				verb := "Copy"
+				if move {
+					verb = "Move"
+				}
+				b.sendText(ctx, chatID, fmt.Sprintf("%s file failed: %v", verb, err))
 				return
 			}
 		case "move_dir":
 			dirToMove := state.PendingTarget.Int64
+			if err := b.checkDirTransfer(ctx, userID, dirToMove, dirID); err != nil {
+				b.sendText(ctx, chatID, fmt.Sprintf("Move folder failed: %v", err))
+				return
+			}
 			if err := b.store.MoveDir(ctx, userID, dirToMove, dirID); err != nil {
 				b.sendText(ctx, chatID, fmt.Sprintf("Move folder failed: %v", err))
 				return
 			}
+		case "restore_trash":
+			_ = b.store.ClearPendingAction(ctx, userID)
+			b.restoreTrashItem(ctx, userID, chatID, msgID, state.PendingTarget.Int64, dirID, "")
+			return
 		default:
 			b.sendText(ctx, chatID, "Unsupported action.")
 			return
@@ -474,6 +887,10 @@ func (b *Bot) handleCallback(ctx context.Context, cb *telegram.CallbackQuery) {
 			b.sendText(ctx, chatID, "Share expired.")
 			return
 		}
+		if !b.canOpenShare(ctx, share, cb.From) {
+			b.sendText(ctx, chatID, "This share is for another Telegram user.")
+			return
+		}
 		currentDir, _ := b.store.GetCurrentDirID(ctx, userID)
 		err = b.saveSharedFile(ctx, userID, currentDir, file)
 		if err != nil {
@@ -481,6 +898,9 @@ func (b *Bot) handleCallback(ctx context.Context, cb *telegram.CallbackQuery) {
 			return
 		}
 		_ = b.store.IncrementShareUses(ctx, share.ID)
+		if file.UserID != userID {
+			b.notifyUser(ctx, file.UserID, fmt.Sprintf("Your shared file %s was saved by %s.", file.Name, displayName(cb.From)))
+		}
 		b.editDirectoryView(ctx, userID, chatID, msgID, currentDir, 0)
 	default:
 		return
@@ -496,7 +916,7 @@ func (b *Bot) sendDirectoryView(ctx context.Context, userID, chatID int64, dirID
 		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load directory: %v", err))
 		return
 	}
-	_, _ = b.tg.SendMessage(ctx, chatID, text, markup)
+	_, _ = b.tg.SendFormattedMessage(ctx, chatID, text, telegram.ParseModeHTML, markup)
 }
 
 func (b *Bot) editDirectoryView(ctx context.Context, userID, chatID int64, msgID int, dirID int64, page int) {
@@ -505,19 +925,29 @@ func (b *Bot) editDirectoryView(ctx context.Context, userID, chatID int64, msgID
 		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load directory: %v", err))
 		return
 	}
-	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, markup)
+	_, _ = b.tg.EditFormattedMessageText(ctx, chatID, msgID, text, telegram.ParseModeHTML, markup)
 }
 
 func (b *Bot) sendFileDetail(ctx context.Context, userID, chatID int64, file db.File, link string) {
 	partCount := b.filePartCount(ctx, file.ID)
 	text, markup := b.fileDetailView(file, link, partCount)
-	_, _ = b.tg.SendMessage(ctx, chatID, text, markup)
+	text += b.fileMetadataLines(ctx, userID, file.ID)
+	text += b.fileTagsLine(ctx, userID, file.ID)
+	b.appendPreviewRow(ctx, userID, file, markup)
+	b.appendDirectLinkRow(file, markup)
+	b.appendQuickMoveRow(ctx, userID, file, markup)
+	_, _ = b.tg.SendFormattedMessage(ctx, chatID, text, telegram.ParseModeHTML, markup)
 }
 
 func (b *Bot) editFileDetail(ctx context.Context, userID, chatID int64, msgID int, file db.File, link string) {
 	partCount := b.filePartCount(ctx, file.ID)
 	text, markup := b.fileDetailView(file, link, partCount)
-	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, markup)
+	text += b.fileMetadataLines(ctx, userID, file.ID)
+	text += b.fileTagsLine(ctx, userID, file.ID)
+	b.appendPreviewRow(ctx, userID, file, markup)
+	b.appendDirectLinkRow(file, markup)
+	b.appendQuickMoveRow(ctx, userID, file, markup)
+	_, _ = b.tg.EditFormattedMessageText(ctx, chatID, msgID, text, telegram.ParseModeHTML, markup)
 }
 
 func (b *Bot) filePartCount(ctx context.Context, fileID int64) int {
@@ -528,44 +958,88 @@ func (b *Bot) filePartCount(ctx context.Context, fileID int64) int {
 	return len(parts)
 }
 
-func (b *Bot) sendFileParts(ctx context.Context, chatID int64, file db.File, parts []db.FilePart) {
+func (b *Bot) sendFileParts(ctx context.Context, chatID int64, file db.File, parts []db.FilePart) (string, error) {
 	total := len(parts)
 	for i, part := range parts {
 		caption := file.Name
 		if total > 1 {
 			caption = fmt.Sprintf("%s (part %d/%d)", file.Name, i+1, total)
 		}
-		_, _ = b.tg.SendDocument(ctx, chatID, part.TelegramFileID, caption, nil)
+		if _, err := b.tg.SendDocument(ctx, chatID, part.TelegramFileID, caption, nil); err != nil {
+			return part.FileUniqueID, err
+		}
 	}
+	return "", nil
 }
 
+// saveSharedFile records a copy of file in dirID. When the destination
+// drive has a storage chat, content not yet held there is copied into it
+// first, so the copy does not depend on the original message.
 func (b *Bot) saveSharedFile(ctx context.Context, userID, dirID int64, file db.File) error {
+	if file.IsEmpty() {
+		_, err := b.store.CreateFile(ctx, userID, dirID, file.Name, "", "", 0, file.MimeType)
+		return err
+	}
 	parts, err := b.store.ListFileParts(ctx, file.ID)
 	if err != nil {
 		return err
 	}
+	totalSize := file.Size
+	inputs := make([]db.FilePartInput, 0, len(parts))
 	if len(parts) == 0 {
-		_, err = b.store.CreateFile(ctx, userID, dirID, file.Name, file.FileID, file.FileUniqueID, file.Size, file.MimeType)
-		return err
+		inputs = append(inputs, db.FilePartInput{
+			TelegramFileID: file.FileID,
+			FileUniqueID:   file.FileUniqueID,
+			Size:           file.Size,
+			SHA256:         file.SHA256,
+		})
 	}
-	totalSize := file.Size
-	if totalSize == 0 {
-		for _, part := range parts {
+	for _, part := range parts {
+		if file.Size == 0 {
 			totalSize += part.Size
 		}
-	}
-	inputs := make([]db.FilePartInput, 0, len(parts))
-	for _, part := range parts {
 		inputs = append(inputs, db.FilePartInput{
 			PartIndex:      part.PartIndex,
 			TelegramFileID: part.TelegramFileID,
 			FileUniqueID:   part.FileUniqueID,
 			Size:           part.Size,
+			SHA256:         part.SHA256,
 		})
 	}
+	if err := b.store.CheckNameAvailable(ctx, userID, dirID, file.Name); err != nil {
+		return err
+	}
+	dst, err := b.store.DriveForDir(ctx, userID, dirID)
+	if err != nil {
+		return err
+	}
+	if storageChatID := b.storageChatFor(ctx, dst); storageChatID != 0 {
+		for i := range inputs {
+			if err := b.copyToStorage(ctx, storageChatID, &inputs[i]); err != nil {
+				b.dropStorageCopies(ctx, inputs[:i])
+				return err
+			}
+		}
+	}
 	first := inputs[0]
-	_, err = b.store.CreateFileWithParts(ctx, userID, dirID, file.Name, first.TelegramFileID, first.FileUniqueID, totalSize, file.MimeType, inputs)
-	return err
+	created, err := b.store.CreateFileWithParts(ctx, userID, dirID, file.Name, first.TelegramFileID, first.FileUniqueID, totalSize, file.MimeType, inputs)
+	if err != nil {
+		b.dropStorageCopies(ctx, inputs)
+		return err
+	}
+	b.copyChecksum(ctx, userID, created, file)
+	return nil
+}
+
+// copyChecksum carries the whole-file checksum of a split file over to its
+// copy; single blobs keep theirs on the blob.
+func (b *Bot) copyChecksum(ctx context.Context, userID int64, copied, src db.File) {
+	if src.SHA256 == "" || copied.SHA256 != "" {
+		return
+	}
+	if err := b.store.SetFileChecksum(ctx, userID, copied.ID, src.SHA256); err != nil {
+		log.Printf("copy checksum to file %d: %v", copied.ID, err)
+	}
 }
 
 func (b *Bot) editDirectoryPicker(ctx context.Context, userID, chatID int64, msgID int, dirID int64) {
@@ -578,14 +1052,32 @@ func (b *Bot) editDirectoryPicker(ctx context.Context, userID, chatID int64, msg
 }
 
 func (b *Bot) shareURL(token string) string {
+	return b.startLink("share_" + token)
+}
+
+// startLink builds a deep link that opens the bot with a /start payload.
+func (b *Bot) startLink(payload string) string {
 	base := b.cfg.ShareBaseURL
 	if base == "" && b.botUsername != "" {
 		base = fmt.Sprintf("https://t.me/%s", b.botUsername)
 	}
 	if base == "" {
-		return fmt.Sprintf("share_%s", token)
+		return payload
+	}
+	return fmt.Sprintf("%s?start=%s", base, payload)
+}
+
+func displayName(user *telegram.User) string {
+	if user == nil {
+		return "someone"
+	}
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	if user.FirstName != "" {
+		return user.FirstName
 	}
-	return fmt.Sprintf("%s?start=share_%s", base, token)
+	return fmt.Sprintf("user %d", user.ID)
 }
 
 func parseInt64(value string) int64 {
@@ -595,6 +1087,14 @@ func parseInt64(value string) int64 {
 }
 
 func extractFile(msg *telegram.Message) *incomingFile {
+	file := extractMedia(msg)
+	if file != nil {
+		file.ChatID, file.MessageID = msg.Chat.ID, msg.MessageID
+	}
+	return file
+}
+
+func extractMedia(msg *telegram.Message) *incomingFile {
 	if msg.Document != nil {
 		name := msg.Document.FileName
 		if name == "" {
@@ -606,6 +1106,7 @@ func extractFile(msg *telegram.Message) *incomingFile {
 			FileUniqueID: msg.Document.FileUniqueID,
 			Size:         msg.Document.FileSize,
 			MimeType:     msg.Document.MimeType,
+			ThumbFileID:  thumbnailFileID(msg.Document.Thumbnail),
 		}
 	}
 	if msg.Audio != nil {
@@ -619,6 +1120,11 @@ func extractFile(msg *telegram.Message) *incomingFile {
 			FileUniqueID: msg.Audio.FileUniqueID,
 			Size:         msg.Audio.FileSize,
 			MimeType:     msg.Audio.MimeType,
+			Meta: media.Metadata{
+				Duration: time.Duration(msg.Audio.Duration) * time.Second,
+				Artist:   msg.Audio.Performer,
+				Title:    msg.Audio.Title,
+			},
 		}
 	}
 	if msg.Video != nil {
@@ -632,6 +1138,12 @@ func extractFile(msg *telegram.Message) *incomingFile {
 			FileUniqueID: msg.Video.FileUniqueID,
 			Size:         msg.Video.FileSize,
 			MimeType:     msg.Video.MimeType,
+			ThumbFileID:  thumbnailFileID(msg.Video.Thumbnail),
+			Meta: media.Metadata{
+				Width:    msg.Video.Width,
+				Height:   msg.Video.Height,
+				Duration: time.Duration(msg.Video.Duration) * time.Second,
+			},
 		}
 	}
 	if len(msg.Photo) > 0 {
@@ -642,17 +1154,39 @@ func extractFile(msg *telegram.Message) *incomingFile {
 			FileUniqueID: photo.FileUniqueID,
 			Size:         photo.FileSize,
 			MimeType:     "image/jpeg",
+			ThumbFileID:  previewPhotoSize(msg.Photo).FileID,
+			Meta:         media.Metadata{Width: photo.Width, Height: photo.Height},
 		}
 	}
 	return nil
 }
 
+// recordFileMessage remembers the message a saved upload arrived in.
+func (b *Bot) recordFileMessage(ctx context.Context, userID, fileID int64, in *incomingFile) {
+	if in.MessageID == 0 {
+		return
+	}
+	if err := b.store.SetFileMessage(ctx, userID, fileID, in.ChatID, in.MessageID); err != nil {
+		log.Printf("record message of file %d: %v", fileID, err)
+	}
+}
+
 type incomingFile struct {
 	Name         string
 	FileID       string
 	FileUniqueID string
 	Size         int64
 	MimeType     string
+	// ChatID and MessageID locate the message the file arrived in.
+	ChatID    int64
+	MessageID int
+	// Meta is what Telegram reported about the content, completed by
+	// probeUpload.
+	Meta media.Metadata
+	// ThumbFileID is Telegram's preview of the file, if it made one.
+	ThumbFileID string
+	// Thumbnail is the preview image set by probeUpload.
+	Thumbnail []byte
 }
 
 func (b *Bot) directoryView(ctx context.Context, userID, dirID int64, page int) (string, *telegram.InlineKeyboardMarkup, error) {
@@ -664,35 +1198,66 @@ func (b *Bot) directoryView(ctx context.Context, userID, dirID int64, page int)
 	if err != nil {
 		return "", nil, err
 	}
-	dirs, err := b.store.ListDirs(ctx, userID, dirID)
+	dirCount, fileCount, err := b.store.CountChildren(ctx, userID, dirID)
 	if err != nil {
 		return "", nil, err
 	}
-	files, err := b.store.ListFiles(ctx, userID, dirID)
+	shortcutCount, err := b.store.CountShortcuts(ctx, userID, dirID)
 	if err != nil {
 		return "", nil, err
 	}
 
-	entries := buildEntries(dirs, files)
 	pageSize := b.cfg.PageSize
 	if pageSize <= 0 {
 		pageSize = 8
 	}
-	totalPages := (len(entries) + pageSize - 1) / pageSize
+	totalPages := (dirCount + fileCount + shortcutCount + pageSize - 1) / pageSize
 	if totalPages == 0 {
 		totalPages = 1
 	}
 	if page < 0 || page >= totalPages {
 		page = 0
 	}
+	// Folders come first, then files, then shortcuts, so a page holds the
+	// tail of one kind and then the head of the next.
 	start := page * pageSize
-	end := start + pageSize
-	if end > len(entries) {
-		end = len(entries)
+	var dirs []db.Directory
+	if start < dirCount {
+		dirs, err = b.store.ListDirsPage(ctx, userID, dirID, db.ListPage{Offset: start, Limit: pageSize})
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	var files []db.File
+	if left := pageSize - len(dirs); left > 0 {
+		files, err = b.store.ListFilesPage(ctx, userID, dirID, db.ListPage{Offset: max(start-dirCount, 0), Limit: left})
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	var shortcuts []db.Shortcut
+	if left := pageSize - len(dirs) - len(files); left > 0 && shortcutCount > 0 {
+		shortcuts, err = b.store.ListShortcutsPage(ctx, userID, dirID, db.ListPage{Offset: max(start-dirCount-fileCount, 0), Limit: left})
+		if err != nil {
+			return "", nil, err
+		}
 	}
 
-	text := fmt.Sprintf("Folder: %s\nFolders: %d | Files: %d\nSend files in this chat to upload.", pathText, len(dirs), len(files))
-	markup := buildDirectoryKeyboard(dir, entries[start:end], page, totalPages)
+	text := fmt.Sprintf("Folder: %s\nFolders: %s | Files: %s", telegram.Bold(pathText), telegram.Code(strconv.Itoa(dirCount)), telegram.Code(strconv.Itoa(fileCount)))
+	if shortcutCount > 0 {
+		text += " | Shortcuts: " + telegram.Code(strconv.Itoa(shortcutCount))
+	}
+	if totals, err := b.store.GetDirTotals(ctx, userID, dirID); err == nil && totals.Bytes > 0 {
+		text += " | Total: " + telegram.Code(formatBytes(totals.Bytes))
+	}
+	text += "\nSend files in this chat to upload."
+	if label := b.driveLabel(ctx, userID, dirID); label != "" {
+		text = "Drive: " + telegram.Bold(label) + "\n" + text
+	}
+	markup := buildDirectoryKeyboard(dir, append(buildEntries(dirs, files), buildShortcutEntries(shortcuts)...), page, totalPages)
+	if !dir.ParentID.Valid {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []telegram.InlineKeyboardButton{{Text: "Drives", CallbackData: "drives"}})
+	}
 	return text, markup, nil
 }
 
@@ -710,24 +1275,55 @@ func (b *Bot) directoryPicker(ctx context.Context, userID, dirID int64) (string,
 		return "", nil, err
 	}
 	text := fmt.Sprintf("Select destination folder.\nCurrent: %s", pathText)
+	if label := b.driveLabel(ctx, userID, dirID); label != "" {
+		text += " (" + label + ")"
+	}
 	markup := buildPickerKeyboard(dir, dirs)
+	if !dir.ParentID.Valid {
+		if drives, err := b.store.ListDrives(ctx, userID); err == nil && len(drives) > 1 {
+			markup.InlineKeyboard = append(markup.InlineKeyboard[:len(markup.InlineKeyboard)-1], []telegram.InlineKeyboardButton{{Text: "Other drives", CallbackData: fmt.Sprintf("pickdrv:%d", dir.ID)}}, markup.InlineKeyboard[len(markup.InlineKeyboard)-1])
+		}
+	}
 	return text, markup, nil
 }
 
 func (b *Bot) fileDetailView(file db.File, link string, partCount int) (string, *telegram.InlineKeyboardMarkup) {
-	text := fmt.Sprintf("File: %s\nSize: %s\nType: %s", file.Name, formatBytes(file.Size), file.MimeType)
-	if partCount > 0 {
-		text += fmt.Sprintf("\nParts: %d", partCount)
-	} else {
-		text += fmt.Sprintf("\nCache ID: %s", file.FileUniqueID)
+	if file.RefState != db.RefStateOK {
+		return lostFileView(file, partCount)
+	}
+	text := fileHeaderHTML(file, partCount)
+	if partCount == 0 {
+		text += "\nCache ID: " + telegram.Code(file.FileUniqueID)
+	}
+	if file.SHA256 != "" {
+		text += "\nSHA-256: " + telegram.Code(file.SHA256)
 	}
 	if link != "" {
-		text += fmt.Sprintf("\nShare link: %s", link)
+		text += "\nShare link: " + linkHTML(link)
 	}
 	markup := buildFileKeyboard(file, link)
 	return text, markup
 }
 
+// fileHeaderHTML renders the name, size, type and part count shared by the
+// file detail views.
+func fileHeaderHTML(file db.File, partCount int) string {
+	text := fmt.Sprintf("File: %s\nSize: %s\nType: %s", telegram.Bold(file.Name), telegram.Code(formatBytes(file.Size)), telegram.Code(file.MimeType))
+	if partCount > 0 {
+		text += "\nParts: " + telegram.Code(strconv.Itoa(partCount))
+	}
+	return text
+}
+
+// linkHTML renders link as clickable when it is a URL; bare /start payloads
+// are shown as code.
+func linkHTML(link string) string {
+	if strings.HasPrefix(link, "https://") || strings.HasPrefix(link, "http://") {
+		return telegram.Link(link, link)
+	}
+	return telegram.Code(link)
+}
+
 func buildEntries(dirs []db.Directory, files []db.File) []entry {
 	var entries []entry
 	for _, d := range dirs {
@@ -737,8 +1333,12 @@ func buildEntries(dirs []db.Directory, files []db.File) []entry {
 		})
 	}
 	for _, f := range files {
+		label := "[FILE] "
+		if f.RefState != db.RefStateOK {
+			label = "[LOST] "
+		}
 		entries = append(entries, entry{
-			Label: "[FILE] " + f.Name,
+			Label: label + f.Name,
 			Callback: fmt.Sprintf("file:%d", f.ID),
 		})
 	}
@@ -785,11 +1385,11 @@ func buildPickerKeyboard(dir db.Directory, dirs []db.Directory) *telegram.Inline
 
 func buildFileKeyboard(file db.File, link string) *telegram.InlineKeyboardMarkup {
 	rows := [][]telegram.InlineKeyboardButton{
-		{{Text: "Send", CallbackData: fmt.Sprintf("sendfile:%d", file.ID)}, {Text: "Delete", CallbackData: fmt.Sprintf("delfile:%d", file.ID)}},
-		{{Text: "Rename", CallbackData: fmt.Sprintf("rnfile:%d", file.ID)}, {Text: "Move", CallbackData: fmt.Sprintf("mvfile:%d", file.ID)}},
+		{{Text: "Send", CallbackData: fmt.Sprintf("sendfile:%d", file.ID)}, {Text: "Tags", CallbackData: fmt.Sprintf("tags:%d", file.ID)}, {Text: "Verify", CallbackData: fmt.Sprintf("verify:%d", file.ID)}, {Text: "Delete", CallbackData: fmt.Sprintf("delfile:%d", file.ID)}},
+		{{Text: "Rename", CallbackData: fmt.Sprintf("rnfile:%d", file.ID)}, {Text: "Move", CallbackData: fmt.Sprintf("mvfile:%d", file.ID)}, {Text: "Copy", CallbackData: fmt.Sprintf("cpfile:%d", file.ID)}},
 		{{Text: "Share 1d", CallbackData: fmt.Sprintf("share:%d:1", file.ID)}, {Text: "Share 3d", CallbackData: fmt.Sprintf("share:%d:3", file.ID)}},
 		{{Text: "Share 7d", CallbackData: fmt.Sprintf("share:%d:7", file.ID)}, {Text: "Share 30d", CallbackData: fmt.Sprintf("share:%d:30", file.ID)}},
-		{{Text: "Share forever", CallbackData: fmt.Sprintf("share:%d:0", file.ID)}},
+		{{Text: "Share forever", CallbackData: fmt.Sprintf("share:%d:0", file.ID)}, {Text: "Share privately", CallbackData: fmt.Sprintf("shareto:%d", file.ID)}},
 		{{Text: "Back", CallbackData: fmt.Sprintf("nav:%d:0", file.DirID)}},
 	}
 	if link != "" {
@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pigpak/internal/config"
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+	"pigpak/internal/telegram/telegramtest"
+)
+
+var testUser = telegram.User{ID: 42, FirstName: "Ada", Username: "ada"}
+
+// newTestBot returns a bot on a fresh database that talks to a fake Bot API.
+func newTestBot(t *testing.T) (*Bot, *telegramtest.Fake) {
+	t.Helper()
+	store, err := db.Open(filepath.Join(t.TempDir(), "pigpak.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	cfg := config.Config{
+		StorageChatID:    -100,
+		PollTimeout:      time.Second,
+		UpdatesLimit:     100,
+		MaxPartSizeBytes: 1 << 20,
+	}
+	fake := telegramtest.NewFake()
+	return New(cfg, store, fake), fake
+}
+
+// runBot runs b until the returned stop function is called.
+func runBot(t *testing.T, b *Bot) (stop func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+	return func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(DrainTimeout + 5*time.Second):
+			t.Fatal("Run did not return after cancel")
+		}
+	}
+}
+
+// eventually polls cond until it holds or a few seconds pass.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunStoresUploadedFiles(t *testing.T) {
+	b, fake := newTestBot(t)
+	fake.SendText(testUser, "/start")
+	fake.SendFile(testUser, "notes.txt", []byte("hello world"))
+
+	stop := runBot(t, b)
+	ctx := context.Background()
+	var files []db.File
+	eventually(t, "the upload to be stored", func() bool {
+		files, _ = b.store.ListAllFiles(ctx, testUser.ID)
+		return len(files) == 1
+	})
+	stop()
+
+	if files[0].Name != "notes.txt" || files[0].Size != int64(len("hello world")) {
+		t.Fatalf("stored file = %q, %d bytes", files[0].Name, files[0].Size)
+	}
+	if len(fake.Messages(testUser.ID)) < 2 {
+		t.Fatalf("bot sent %d messages, want a welcome and the file details", len(fake.Messages(testUser.ID)))
+	}
+	// Everything handled was confirmed before Run returned.
+	left, err := fake.GetUpdates(ctx, 0, 100, 0)
+	if err != nil || len(left) != 0 {
+		t.Fatalf("unconfirmed updates after Run = %d, %v", len(left), err)
+	}
+}
+
+func TestProcessBatchSkipsSupersededNavigation(t *testing.T) {
+	b, fake := newTestBot(t)
+	ctx := context.Background()
+	menu, err := fake.SendMessage(ctx, testUser.ID, "menu", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Press(testUser, *menu, "nav:1")
+	fake.Press(testUser, *menu, "nav:2")
+	batch, err := fake.GetUpdates(ctx, 0, 100, 0)
+	if err != nil || len(batch) != 2 {
+		t.Fatalf("getUpdates = %d, %v", len(batch), err)
+	}
+
+	var handled []int
+	b.updates = newDispatcher(1, func(_ context.Context, upd telegram.Update) {
+		handled = append(handled, upd.UpdateID)
+	})
+	b.processBatch(ctx, batch)
+	if !b.updates.wait(time.Second) {
+		t.Fatal("dispatcher did not drain")
+	}
+
+	if len(handled) != 1 || handled[0] != batch[1].UpdateID {
+		t.Fatalf("handled updates %v, want only %d", handled, batch[1].UpdateID)
+	}
+	if answered := fake.Answered(); len(answered) != 1 || answered[0] != batch[0].CallbackQuery.ID {
+		t.Fatalf("answered callbacks %v, want the superseded %q", answered, batch[0].CallbackQuery.ID)
+	}
+	if got, want := b.updates.offset(), batch[1].UpdateID+1; got != want {
+		t.Fatalf("offset = %d, want %d", got, want)
+	}
+}
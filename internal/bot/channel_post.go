@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// channelPostsDir is the folder, below a drive's root, that receives files
+// posted straight into the drive's storage channel.
+const channelPostsDir = "Channel posts"
+
+// handleChannelPost indexes a file posted into a storage channel by someone
+// other than the bot, so content added out of band shows up in the drive
+// that channel backs. Files the database already locates in the channel
+// are left alone.
+func (b *Bot) handleChannelPost(ctx context.Context, msg *telegram.Message) {
+	file := extractFile(msg)
+	if file == nil {
+		return
+	}
+	drive, ok := b.channelPostDrive(ctx, msg.Chat.ID)
+	if !ok {
+		return
+	}
+	if messageID, err := b.store.BlobMessageIn(ctx, file.FileUniqueID, msg.Chat.ID); err != nil || messageID != 0 {
+		return
+	}
+	dir, err := b.store.EnsureDirPath(ctx, drive.UserID, drive.RootDirID, []string{channelPostsDir})
+	if err != nil {
+		log.Printf("index channel post %d/%d: %v", msg.Chat.ID, msg.MessageID, err)
+		return
+	}
+	name, err := b.store.FreeName(ctx, drive.UserID, dir.ID, file.Name)
+	if err != nil {
+		log.Printf("index channel post %d/%d: %v", msg.Chat.ID, msg.MessageID, err)
+		return
+	}
+	part := db.FilePartInput{
+		TelegramFileID:   file.FileID,
+		FileUniqueID:     file.FileUniqueID,
+		Size:             file.Size,
+		StorageChatID:    msg.Chat.ID,
+		StorageMessageID: msg.MessageID,
+	}
+	if _, err := b.store.CreateFileWithParts(ctx, drive.UserID, dir.ID, name, file.FileID, file.FileUniqueID, file.Size, file.MimeType, []db.FilePartInput{part}); err != nil {
+		log.Printf("index channel post %d/%d: %v", msg.Chat.ID, msg.MessageID, err)
+	}
+}
+
+// channelPostDrive picks the drive a storage channel's posts belong to: the
+// oldest drive using the channel, the primary drive of the user whose
+// storage chat it is, or for the server's STORAGE_CHAT_ID the primary drive
+// of the first admin. Other channels are not indexed.
+func (b *Bot) channelPostDrive(ctx context.Context, chatID int64) (db.Drive, bool) {
+	drive, err := b.store.DriveForStorageChat(ctx, chatID)
+	if err == nil {
+		return drive, true
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("storage chat %d drive: %v", chatID, err)
+		return db.Drive{}, false
+	}
+	ownerID, err := b.store.UserForStorageChat(ctx, chatID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("storage chat %d owner: %v", chatID, err)
+		return db.Drive{}, false
+	}
+	if err != nil {
+		if chatID != b.cfg.StorageChatID || len(b.cfg.AdminUserIDs) == 0 {
+			return db.Drive{}, false
+		}
+		ownerID = b.cfg.AdminUserIDs[0]
+	}
+	if err := b.store.EnsureUserState(ctx, ownerID); err != nil {
+		log.Printf("ensure user state: %v", err)
+		return db.Drive{}, false
+	}
+	drives, err := b.store.ListDrives(ctx, ownerID)
+	if err != nil || len(drives) == 0 {
+		return db.Drive{}, false
+	}
+	return drives[0], true
+}
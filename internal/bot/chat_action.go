@@ -0,0 +1,34 @@
+package bot
+
+import (
+	"context"
+	"time"
+)
+
+// chatActionInterval repeats a chat action before Telegram's five second
+// display ends.
+const chatActionInterval = 4 * time.Second
+
+// showChatAction keeps action visible in chatID until the returned stop
+// function is called. Failures are ignored; the indicator is cosmetic.
+func (b *Bot) showChatAction(ctx context.Context, chatID int64, action string) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(chatActionInterval)
+		defer ticker.Stop()
+		for {
+			_ = b.tg.SendChatAction(ctx, chatID, action)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// debugProbeTimeout bounds each live getFile probe in /debug output.
+const debugProbeTimeout = 10 * time.Second
+
+// handleDebugCommand serves admin-only diagnostics:
+//
+//	/debug file <id|uuid>
+func (b *Bot) handleDebugCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/debug" {
+		return false
+	}
+	if !b.cfg.IsAdmin(userID) {
+		b.sendText(ctx, chatID, "This command is restricted to admins.")
+		return true
+	}
+	if len(fields) != 3 || fields[1] != "file" {
+		b.sendText(ctx, chatID, "Usage: /debug file <id|uuid>")
+		return true
+	}
+	report, err := b.debugFileReport(ctx, fields[2])
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Debug failed: %v", err))
+		return true
+	}
+	b.sendText(ctx, chatID, report)
+	return true
+}
+
+func (b *Bot) debugFileReport(ctx context.Context, ref string) (string, error) {
+	file, err := b.store.FindFile(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	parts, err := b.store.ListFileParts(ctx, file.ID)
+	if err != nil {
+		return "", err
+	}
+	dirPath, err := b.store.GetDirPath(ctx, file.UserID, file.DirID)
+	if err != nil {
+		dirPath = fmt.Sprintf("<dir %d: %v>", file.DirID, err)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "File #%d (%s)\n", file.ID, file.PublicID)
+	fmt.Fprintf(&sb, "Owner: %d\nPath: %s/%s\n", file.UserID, strings.TrimSuffix(dirPath, "/"), file.Name)
+	fmt.Fprintf(&sb, "Size: %d (%s)\nType: %s\nCreated: %s\n", file.Size, formatBytes(file.Size), file.MimeType, file.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(&sb, "file_unique_id: %s\nfile_id: %s\n", file.FileUniqueID, file.FileID)
+	if file.RefState != "" {
+		fmt.Fprintf(&sb, "Ref state: %s\n", file.RefState)
+	}
+	if blob, err := b.store.GetBlob(ctx, file.FileUniqueID); err == nil {
+		fmt.Fprintf(&sb, "Blob refs: %d, size %d\n", blob.RefCount, blob.Size)
+	} else {
+		fmt.Fprintf(&sb, "Blob: %v\n", err)
+	}
+	if len(parts) == 0 {
+		fmt.Fprintf(&sb, "Probe: %s\n", b.probeFile(ctx, file.FileID))
+		return sb.String(), nil
+	}
+	var partTotal int64
+	for _, part := range parts {
+		partTotal += part.Size
+		fmt.Fprintf(&sb, "\nPart %d: %d bytes\n  unique: %s\n  file_id: %s\n  probe: %s\n", part.PartIndex, part.Size, part.FileUniqueID, part.TelegramFileID, b.probeFile(ctx, part.TelegramFileID))
+	}
+	if partTotal != file.Size {
+		fmt.Fprintf(&sb, "\nWarning: parts total %d bytes, file row says %d\n", partTotal, file.Size)
+	}
+	return sb.String(), nil
+}
+
+// probeFile runs getFile against Telegram and summarizes the outcome.
+func (b *Bot) probeFile(ctx context.Context, fileID string) string {
+	if fileID == "" {
+		return "no file_id stored"
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, debugProbeTimeout)
+	defer cancel()
+	started := time.Now()
+	info, err := b.tg.GetFile(probeCtx, fileID)
+	elapsed := time.Since(started).Round(time.Millisecond)
+	if err != nil {
+		return fmt.Sprintf("error after %s: %v", elapsed, err)
+	}
+	return fmt.Sprintf("ok in %s, size %d, path %s", elapsed, info.FileSize, info.FilePath)
+}
@@ -0,0 +1,45 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"pigpak/internal/db"
+	"pigpak/internal/gateway"
+	"pigpak/internal/telegram"
+)
+
+// directLinksEnabled reports whether the HTTP gateway can serve signed
+// download links.
+func (b *Bot) directLinksEnabled() bool {
+	return b.cfg.WebDAVEnable && b.cfg.WebDAVPublicURL != "" && b.signer != nil && b.cfg.DirectLinkTTL > 0
+}
+
+// appendDirectLinkRow offers a signed download link for file when the
+// gateway is reachable.
+func (b *Bot) appendDirectLinkRow(file db.File, markup *telegram.InlineKeyboardMarkup) {
+	if markup == nil || !b.directLinksEnabled() || file.IsEmpty() {
+		return
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, []telegram.InlineKeyboardButton{{Text: "Get direct link", CallbackData: fmt.Sprintf("dlink:%d", file.ID)}})
+}
+
+// sendDirectLink sends a time-limited signed URL downloading file from the
+// gateway. Unlike a share it leaves nothing behind to revoke; the link stops
+// working when it expires or the file is deleted.
+func (b *Bot) sendDirectLink(ctx context.Context, userID, chatID, fileID int64) {
+	if !b.directLinksEnabled() {
+		b.sendText(ctx, chatID, "Direct links need WEB_DAV_ENABLE and WEB_DAV_PUBLIC_URL.")
+		return
+	}
+	file, err := b.store.GetFileByID(ctx, userID, fileID)
+	if err != nil {
+		b.sendText(ctx, chatID, "File not found.")
+		return
+	}
+	expires := time.Now().Add(b.cfg.DirectLinkTTL)
+	link := strings.TrimSuffix(b.cfg.WebDAVPublicURL, "/") + gateway.DownloadPath(b.signer, file, expires)
+	b.sendText(ctx, chatID, fmt.Sprintf("Direct link to %s, valid until %s:\n%s", file.Name, expires.UTC().Format("2006-01-02 15:04 UTC"), link))
+}
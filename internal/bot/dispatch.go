@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"pigpak/internal/telegram"
+)
+
+// DrainTimeout bounds how long Run waits for in-flight updates after its
+// context is cancelled.
+const DrainTimeout = 10 * time.Second
+
+// defaultUpdateWorkers is used when UPDATE_WORKERS is not set.
+const defaultUpdateWorkers = 8
+
+// maxWaitingUpdates caps how many dispatched updates may wait for a
+// handler. Once it is reached dispatch blocks, so Run stops polling until
+// a handler frees room.
+const maxWaitingUpdates = 50
+
+// dispatcher runs update handlers concurrently while keeping each user's
+// updates in arrival order. A user with queued work has one goroutine that
+// drains their queue; workers bounds how many handlers run at once.
+type dispatcher struct {
+	handle  func(context.Context, telegram.Update)
+	workers chan struct{}
+	wg      sync.WaitGroup
+	started chan struct{}
+
+	mu     sync.Mutex
+	queues map[int64][]telegram.Update
+	// waiting holds the ids of dispatched updates whose handler has not
+	// started yet, ascending. last is the highest id dispatched or settled.
+	waiting []int
+	last    int
+}
+
+func newDispatcher(workers int, handle func(context.Context, telegram.Update)) *dispatcher {
+	if workers <= 0 {
+		workers = defaultUpdateWorkers
+	}
+	return &dispatcher{
+		handle:  handle,
+		workers: make(chan struct{}, workers),
+		started: make(chan struct{}, 1),
+		queues:  make(map[int64][]telegram.Update),
+	}
+}
+
+// dispatch queues upd behind earlier updates from the same user, blocking
+// while maxWaitingUpdates are already waiting. It reports false, without
+// queueing upd, if ctx is cancelled first. Handlers run on a context
+// detached from ctx's cancellation so a shutdown lets them finish instead
+// of failing halfway.
+func (d *dispatcher) dispatch(ctx context.Context, upd telegram.Update) bool {
+	key := updateUserID(upd)
+	d.mu.Lock()
+	for len(d.waiting) >= maxWaitingUpdates {
+		d.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-d.started:
+		}
+		d.mu.Lock()
+	}
+	d.waiting = append(d.waiting, upd.UpdateID)
+	d.last = max(d.last, upd.UpdateID)
+	queue, active := d.queues[key]
+	d.queues[key] = append(queue, upd)
+	d.mu.Unlock()
+	if active {
+		return true
+	}
+	d.wg.Add(1)
+	go d.drainUser(context.WithoutCancel(ctx), key)
+	return true
+}
+
+// settle records an update that was handled without being dispatched.
+func (d *dispatcher) settle(id int) {
+	d.mu.Lock()
+	d.last = max(d.last, id)
+	d.mu.Unlock()
+}
+
+// begin marks a dispatched update as handed to its handler.
+func (d *dispatcher) begin(id int) {
+	d.mu.Lock()
+	if i := sort.SearchInts(d.waiting, id); i < len(d.waiting) && d.waiting[i] == id {
+		d.waiting = append(d.waiting[:i], d.waiting[i+1:]...)
+	}
+	d.mu.Unlock()
+	select {
+	case d.started <- struct{}{}:
+	default:
+	}
+}
+
+// offset returns the getUpdates offset that confirms every update handed
+// to a handler and none still waiting for one.
+func (d *dispatcher) offset() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case len(d.waiting) > 0:
+		return d.waiting[0]
+	case d.last > 0:
+		return d.last + 1
+	}
+	return 0
+}
+
+// pending returns how many dispatched updates wait for a handler.
+func (d *dispatcher) pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.waiting)
+}
+
+// unseen drops updates that were already dispatched or settled; Telegram
+// returns them again until the offset moves past them.
+func (d *dispatcher) unseen(updates []telegram.Update) []telegram.Update {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := updates[:0:0]
+	for _, upd := range updates {
+		if upd.UpdateID > d.last {
+			out = append(out, upd)
+		}
+	}
+	return out
+}
+
+func (d *dispatcher) drainUser(ctx context.Context, key int64) {
+	defer d.wg.Done()
+	for {
+		d.mu.Lock()
+		queue := d.queues[key]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+		upd := queue[0]
+		d.queues[key] = queue[1:]
+		d.mu.Unlock()
+
+		d.workers <- struct{}{}
+		d.begin(upd.UpdateID)
+		d.run(ctx, upd)
+		<-d.workers
+	}
+}
+
+func (d *dispatcher) run(ctx context.Context, upd telegram.Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("update %d panicked: %v", upd.UpdateID, r)
+		}
+	}()
+	d.handle(ctx, upd)
+}
+
+// wait blocks until every queued update is handled or timeout passes, and
+// reports whether the queues drained.
+func (d *dispatcher) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// updateUserID returns the Telegram user an update comes from, the channel
+// for channel posts, or 0 for updates without a sender.
+func updateUserID(upd telegram.Update) int64 {
+	switch {
+	case upd.Message != nil && upd.Message.From != nil:
+		return upd.Message.From.ID
+	case upd.CallbackQuery != nil && upd.CallbackQuery.From != nil:
+		return upd.CallbackQuery.From.ID
+	case upd.ChannelPost != nil:
+		// Channel ids are negative, so posts never share a user's queue.
+		return upd.ChannelPost.Chat.ID
+	}
+	return 0
+}
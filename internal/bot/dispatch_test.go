@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pigpak/internal/telegram"
+)
+
+func userUpdate(id int, userID int64) telegram.Update {
+	return telegram.Update{UpdateID: id, Message: &telegram.Message{From: &telegram.User{ID: userID}}}
+}
+
+func TestDispatchKeepsUserOrder(t *testing.T) {
+	var got []int
+	d := newDispatcher(4, func(_ context.Context, upd telegram.Update) {
+		got = append(got, upd.UpdateID)
+	})
+	for id := 1; id <= 20; id++ {
+		if !d.dispatch(context.Background(), userUpdate(id, 7)) {
+			t.Fatalf("dispatch %d refused", id)
+		}
+	}
+	if !d.wait(time.Second) {
+		t.Fatal("dispatcher did not drain")
+	}
+	for i, id := range got {
+		if id != i+1 {
+			t.Fatalf("handled %v, want arrival order", got)
+		}
+	}
+}
+
+func TestDispatchBlocksWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	d := newDispatcher(1, func(context.Context, telegram.Update) { <-release })
+	ctx := context.Background()
+	// Update 1 occupies the only worker; the rest wait behind it.
+	d.dispatch(ctx, userUpdate(1, 1))
+	eventually(t, "update 1 to start", func() bool { return d.pending() == 0 })
+	for id := 2; id <= maxWaitingUpdates+1; id++ {
+		d.dispatch(ctx, userUpdate(id, int64(id)))
+	}
+
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if d.dispatch(short, userUpdate(maxWaitingUpdates+2, 1)) {
+		t.Fatal("dispatch queued past maxWaitingUpdates")
+	}
+	if got := d.pending(); got != maxWaitingUpdates {
+		t.Fatalf("pending = %d, want %d", got, maxWaitingUpdates)
+	}
+	// Waiting updates stay unconfirmed.
+	if got := d.offset(); got != 2 {
+		t.Fatalf("offset = %d, want 2", got)
+	}
+
+	close(release)
+	if !d.wait(5 * time.Second) {
+		t.Fatal("dispatcher did not drain")
+	}
+	if got, want := d.offset(), maxWaitingUpdates+2; got != want {
+		t.Fatalf("offset after drain = %d, want %d", got, want)
+	}
+}
+
+func TestUnseenDropsDispatchedUpdates(t *testing.T) {
+	d := newDispatcher(1, func(context.Context, telegram.Update) {})
+	d.dispatch(context.Background(), userUpdate(5, 1))
+	d.settle(6)
+	batch := []telegram.Update{userUpdate(5, 1), userUpdate(6, 1), userUpdate(7, 1)}
+	fresh := d.unseen(batch)
+	if len(fresh) != 1 || fresh[0].UpdateID != 7 {
+		t.Fatalf("unseen = %v, want only update 7", fresh)
+	}
+	d.wait(time.Second)
+}
@@ -0,0 +1,233 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// driveLabel returns the label of the drive holding dirID, or an empty
+// string when it cannot be resolved.
+func (b *Bot) driveLabel(ctx context.Context, userID, dirID int64) string {
+	drive, err := b.store.DriveForDir(ctx, userID, dirID)
+	if err != nil {
+		return ""
+	}
+	return drive.Label
+}
+
+// driveRootFor returns the root of the drive holding dirID, falling back to
+// the primary drive.
+func (b *Bot) driveRootFor(ctx context.Context, userID, dirID int64) int64 {
+	if drive, err := b.store.DriveForDir(ctx, userID, dirID); err == nil {
+		return drive.RootDirID
+	}
+	rootID, _ := b.store.GetRootDirID(ctx, userID)
+	return rootID
+}
+
+func (b *Bot) drivesView(ctx context.Context, userID, currentDirID int64) (string, *telegram.InlineKeyboardMarkup, error) {
+	drives, err := b.store.ListDrives(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	current, _ := b.store.DriveForDir(ctx, userID, currentDirID)
+	text := fmt.Sprintf("Drives: %d\nEach drive has its own folders. The first drive is the one served over WebDAV.", len(drives))
+	if current.ID != 0 {
+		storage := "server default"
+		if current.StorageChatID != 0 {
+			storage = fmt.Sprintf("chat %d", current.StorageChatID)
+		}
+		text += fmt.Sprintf("\n\n%s storage: %s", current.Label, storage)
+	}
+	var rows [][]telegram.InlineKeyboardButton
+	for i, d := range drives {
+		label := "[DRIVE] " + d.Label
+		if i == 0 {
+			label += " (primary)"
+		}
+		if d.ID == current.ID {
+			label += " *"
+		}
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: label, CallbackData: fmt.Sprintf("nav:%d:0", d.RootDirID)}})
+	}
+	rows = append(rows, []telegram.InlineKeyboardButton{{Text: "New Drive", CallbackData: "drvnew"}})
+	if current.ID != 0 {
+		manage := []telegram.InlineKeyboardButton{{Text: "Rename " + current.Label, CallbackData: fmt.Sprintf("drvren:%d", current.ID)}}
+		if len(drives) > 0 && current.ID != drives[0].ID {
+			manage = append(manage, telegram.InlineKeyboardButton{Text: "Delete " + current.Label, CallbackData: fmt.Sprintf("drvdel:%d", current.ID)})
+		}
+		rows = append(rows, manage)
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: "Storage chat", CallbackData: fmt.Sprintf("drvstore:%d", current.ID)}})
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: "Back", CallbackData: fmt.Sprintf("nav:%d:0", current.RootDirID)}})
+	}
+	return text, &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+func (b *Bot) editDrivesView(ctx context.Context, userID, chatID int64, msgID int) {
+	currentDir, _ := b.store.GetCurrentDirID(ctx, userID)
+	text, markup, err := b.drivesView(ctx, userID, currentDir)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load drives: %v", err))
+		return
+	}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, markup)
+}
+
+// handleDriveCallback serves the drives menu:
+//
+//	drives        list drives
+//	drvnew        ask for a new drive label
+//	drvren:<id>   ask for a new label
+//	drvdel:<id>   delete an empty drive
+//	drvstore:<id> ask for the drive's storage chat
+func (b *Bot) handleDriveCallback(ctx context.Context, userID, chatID int64, msgID int, data string) {
+	switch {
+	case data == "drives":
+		b.editDrivesView(ctx, userID, chatID, msgID)
+	case data == "drvnew":
+		_ = b.store.SetPendingAction(ctx, userID, "new_drive", 0, "")
+		b.sendText(ctx, chatID, "Send a label for the new drive, e.g. Work.")
+	case strings.HasPrefix(data, "drvren:"):
+		driveID := parseInt64(strings.TrimPrefix(data, "drvren:"))
+		_ = b.store.SetPendingAction(ctx, userID, "rename_drive", driveID, "")
+		b.sendText(ctx, chatID, "Send the new drive label.")
+	case strings.HasPrefix(data, "drvstore:"):
+		driveID := parseInt64(strings.TrimPrefix(data, "drvstore:"))
+		_ = b.store.SetPendingAction(ctx, userID, "drive_storage", driveID, "")
+		b.sendText(ctx, chatID, "Send the ID of the channel or group that should store copies for this drive (the bot must be an admin there), or \"default\" to use the server storage chat. Files copied into the drive from another storage chat are re-uploaded there.")
+	case strings.HasPrefix(data, "drvdel:"):
+		driveID := parseInt64(strings.TrimPrefix(data, "drvdel:"))
+		if err := b.store.DeleteDrive(ctx, userID, driveID); err != nil {
+			if errors.Is(err, db.ErrDriveNotEmpty) {
+				b.sendText(ctx, chatID, "Drive is not empty. Move or delete its folders and files first.")
+				return
+			}
+			b.sendText(ctx, chatID, fmt.Sprintf("Delete drive failed: %v", err))
+			return
+		}
+		rootID, _ := b.store.GetRootDirID(ctx, userID)
+		_ = b.store.SetCurrentDir(ctx, userID, rootID)
+		b.editDrivesView(ctx, userID, chatID, msgID)
+	}
+}
+
+// handleDriveText handles the label sent after "New Drive" or "Rename" and
+// the chat ID sent after "Storage chat".
+func (b *Bot) handleDriveText(ctx context.Context, userID, chatID int64, state db.UserState, text string) {
+	if state.PendingAction.String == "drive_storage" {
+		b.handleDriveStorageText(ctx, userID, chatID, state.PendingTarget.Int64, text)
+		return
+	}
+	label := strings.TrimSpace(text)
+	if label == "" || len(label) > 64 {
+		b.sendText(ctx, chatID, "Drive label is invalid.")
+		return
+	}
+	var rootID int64
+	if state.PendingAction.String == "new_drive" {
+		drive, err := b.store.CreateDrive(ctx, userID, label)
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Create drive failed: %v", err))
+			return
+		}
+		rootID = drive.RootDirID
+	} else {
+		driveID := state.PendingTarget.Int64
+		if err := b.store.RenameDrive(ctx, userID, driveID, label); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Rename drive failed: %v", err))
+			return
+		}
+		drive, err := b.store.GetDrive(ctx, userID, driveID)
+		if err != nil {
+			b.sendText(ctx, chatID, "Drive not found.")
+			return
+		}
+		rootID = drive.RootDirID
+	}
+	_ = b.store.ClearPendingAction(ctx, userID)
+	_ = b.store.SetCurrentDir(ctx, userID, rootID)
+	b.sendDirectoryView(ctx, userID, chatID, rootID, 0)
+}
+
+func (b *Bot) handleDriveStorageText(ctx context.Context, userID, chatID, driveID int64, text string) {
+	value := strings.TrimSpace(text)
+	var storageChat int64
+	if !strings.EqualFold(value, "default") {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id == 0 {
+			b.sendText(ctx, chatID, "Send a numeric chat ID such as -1001234567890, or \"default\".")
+			return
+		}
+		storageChat = id
+	}
+	if err := b.store.SetDriveStorageChat(ctx, userID, driveID, storageChat); err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Set storage chat failed: %v", err))
+		return
+	}
+	_ = b.store.ClearPendingAction(ctx, userID)
+	drive, err := b.store.GetDrive(ctx, userID, driveID)
+	if err != nil {
+		b.sendText(ctx, chatID, "Drive not found.")
+		return
+	}
+	_ = b.store.SetCurrentDir(ctx, userID, drive.RootDirID)
+	text, markup, err := b.drivesView(ctx, userID, drive.RootDirID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load drives: %v", err))
+		return
+	}
+	_, _ = b.tg.SendMessage(ctx, chatID, text, markup)
+}
+
+// editDrivePicker lists drives as destinations while a move or copy is
+// pending.
+func (b *Bot) editDrivePicker(ctx context.Context, userID, chatID int64, msgID int, fromDirID int64) {
+	drives, err := b.store.ListDrives(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load drives: %v", err))
+		return
+	}
+	src, _ := b.store.DriveForDir(ctx, userID, fromDirID)
+	var rows [][]telegram.InlineKeyboardButton
+	for _, d := range drives {
+		label := "[DRIVE] " + d.Label
+		if d.ID != src.ID && b.needsReupload(ctx, src, d) {
+			label += " (re-upload)"
+		}
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: label, CallbackData: fmt.Sprintf("pick:%d", d.RootDirID)}})
+	}
+	rows = append(rows, []telegram.InlineKeyboardButton{{Text: "Cancel", CallbackData: fmt.Sprintf("nav:%d:0", fromDirID)}})
+	text := "Select destination drive.\nDrives marked re-upload use another storage chat, so files are copied there byte by byte."
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, &telegram.InlineKeyboardMarkup{InlineKeyboard: rows})
+}
+
+// handleUsageCommand reports per-drive usage on /usage.
+func (b *Bot) handleUsageCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/usage" {
+		return false
+	}
+	usage, err := b.store.ListDriveUsage(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Usage failed: %v", err))
+		return true
+	}
+	var sb strings.Builder
+	var files, bytes int64
+	for _, u := range usage {
+		fmt.Fprintf(&sb, "%s: %s in %d file(s), %d folder(s)\n", u.Drive.Label, formatBytes(u.Bytes), u.Files, u.Dirs)
+		files += u.Files
+		bytes += u.Bytes
+	}
+	if len(usage) > 1 {
+		fmt.Fprintf(&sb, "Total: %s in %d file(s)\n", formatBytes(bytes), files)
+	}
+	b.sendText(ctx, chatID, strings.TrimSuffix(sb.String(), "\n"))
+	return true
+}
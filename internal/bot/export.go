@@ -0,0 +1,223 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+type exportDir struct {
+	ID       string    `json:"id"`
+	Drive    string    `json:"drive"`
+	Path     string    `json:"path"`
+	Created  time.Time `json:"created_at"`
+	Modified time.Time `json:"updated_at"`
+}
+
+type exportFile struct {
+	ID           string        `json:"id"`
+	Drive        string        `json:"drive"`
+	Path         string        `json:"path"`
+	Name         string        `json:"name"`
+	Size         int64         `json:"size"`
+	MimeType     string        `json:"mime_type"`
+	FileUniqueID string        `json:"file_unique_id"`
+	Parts        int           `json:"parts,omitempty"`
+	Created      time.Time     `json:"created_at"`
+	Shares       []exportShare `json:"shares,omitempty"`
+}
+
+type exportShare struct {
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Uses      int64      `json:"uses"`
+	Recipient string     `json:"recipient,omitempty"`
+}
+
+type driveExport struct {
+	UserID      int64        `json:"user_id"`
+	GeneratedAt time.Time    `json:"generated_at"`
+	Directories []exportDir  `json:"directories"`
+	Files       []exportFile `json:"files"`
+}
+
+func (b *Bot) handleExportCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/export" {
+		return false
+	}
+	format := ""
+	if len(fields) > 1 {
+		format = strings.ToLower(fields[1])
+	}
+	if format != "" && format != "json" && format != "csv" {
+		b.sendText(ctx, chatID, "Usage: /export [json|csv]")
+		return true
+	}
+	stopAction := b.showChatAction(ctx, chatID, telegram.ChatActionTyping)
+	export, err := b.buildExport(ctx, userID)
+	stopAction()
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Export failed: %v", err))
+		return true
+	}
+	stopAction = b.showChatAction(ctx, chatID, telegram.ChatActionUploadDocument)
+	defer stopAction()
+	stamp := export.GeneratedAt.Format("20060102-150405")
+	if format == "" || format == "json" {
+		data, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Export failed: %v", err))
+			return true
+		}
+		if _, err := b.tg.UploadDocument(ctx, chatID, fmt.Sprintf("pigpak-export-%s.json", stamp), bytes.NewReader(data)); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Upload export failed: %v", err))
+			return true
+		}
+	}
+	if format == "" || format == "csv" {
+		data, err := exportCSV(export)
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Export failed: %v", err))
+			return true
+		}
+		if _, err := b.tg.UploadDocument(ctx, chatID, fmt.Sprintf("pigpak-export-%s.csv", stamp), bytes.NewReader(data)); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Upload export failed: %v", err))
+			return true
+		}
+	}
+	return true
+}
+
+func (b *Bot) buildExport(ctx context.Context, userID int64) (driveExport, error) {
+	dirs, err := b.store.ListAllDirs(ctx, userID)
+	if err != nil {
+		return driveExport{}, err
+	}
+	files, err := b.store.ListAllFiles(ctx, userID)
+	if err != nil {
+		return driveExport{}, err
+	}
+	shares, err := b.store.ListSharesByUser(ctx, userID)
+	if err != nil {
+		return driveExport{}, err
+	}
+	drives, err := b.store.ListDrives(ctx, userID)
+	if err != nil {
+		return driveExport{}, err
+	}
+	paths := dirPaths(dirs)
+	labels := dirDriveLabels(dirs, drives)
+	sharesByFile := make(map[int64][]exportShare)
+	for _, sh := range shares {
+		if db.ValidateShare(sh) != nil {
+			continue
+		}
+		item := exportShare{URL: b.shareURL(sh.Token), Uses: sh.Uses, Recipient: shareRecipientLabel(sh)}
+		if sh.ExpiresAt.Valid {
+			exp := sh.ExpiresAt.Time
+			item.ExpiresAt = &exp
+		}
+		sharesByFile[sh.FileID] = append(sharesByFile[sh.FileID], item)
+	}
+	out := driveExport{UserID: userID, GeneratedAt: time.Now().UTC()}
+	for _, d := range dirs {
+		out.Directories = append(out.Directories, exportDir{ID: d.PublicID, Drive: labels[d.ID], Path: paths[d.ID], Created: d.CreatedAt, Modified: d.UpdatedAt})
+	}
+	for _, f := range files {
+		out.Files = append(out.Files, exportFile{
+			ID:           f.PublicID,
+			Drive:        labels[f.DirID],
+			Path:         path.Join(paths[f.DirID], f.Name),
+			Name:         f.Name,
+			Size:         f.Size,
+			MimeType:     f.MimeType,
+			FileUniqueID: f.FileUniqueID,
+			Parts:        b.filePartCount(ctx, f.ID),
+			Created:      f.CreatedAt,
+			Shares:       sharesByFile[f.ID],
+		})
+	}
+	return out, nil
+}
+
+// dirPaths computes absolute paths for a user's directories without one query per level.
+func dirPaths(dirs []db.Directory) map[int64]string {
+	byID := make(map[int64]db.Directory, len(dirs))
+	for _, d := range dirs {
+		byID[d.ID] = d
+	}
+	paths := make(map[int64]string, len(dirs))
+	var resolve func(id int64, depth int) string
+	resolve = func(id int64, depth int) string {
+		if p, ok := paths[id]; ok {
+			return p
+		}
+		d, ok := byID[id]
+		if !ok || !d.ParentID.Valid || depth > len(dirs) {
+			paths[id] = "/"
+			return "/"
+		}
+		p := path.Join(resolve(d.ParentID.Int64, depth+1), d.Name)
+		paths[id] = p
+		return p
+	}
+	for _, d := range dirs {
+		resolve(d.ID, 0)
+	}
+	return paths
+}
+
+// dirDriveLabels maps each directory to the label of the drive it belongs to.
+func dirDriveLabels(dirs []db.Directory, drives []db.Drive) map[int64]string {
+	parents := make(map[int64]int64, len(dirs))
+	for _, d := range dirs {
+		if d.ParentID.Valid {
+			parents[d.ID] = d.ParentID.Int64
+		}
+	}
+	byRoot := make(map[int64]string, len(drives))
+	for _, d := range drives {
+		byRoot[d.RootDirID] = d.Label
+	}
+	labels := make(map[int64]string, len(dirs))
+	for _, d := range dirs {
+		id := d.ID
+		for depth := 0; depth <= len(dirs); depth++ {
+			parent, ok := parents[id]
+			if !ok {
+				break
+			}
+			id = parent
+		}
+		labels[d.ID] = byRoot[id]
+	}
+	return labels
+}
+
+func exportCSV(export driveExport) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"type", "id", "drive", "path", "size", "mime_type", "file_unique_id", "created_at", "share_links"})
+	for _, d := range export.Directories {
+		_ = w.Write([]string{"dir", d.ID, d.Drive, d.Path, "", "", "", d.Created.Format(time.RFC3339), ""})
+	}
+	for _, f := range export.Files {
+		links := make([]string, 0, len(f.Shares))
+		for _, sh := range f.Shares {
+			links = append(links, sh.URL)
+		}
+		_ = w.Write([]string{"file", f.ID, f.Drive, f.Path, strconv.FormatInt(f.Size, 10), f.MimeType, f.FileUniqueID, f.Created.Format(time.RFC3339), strings.Join(links, " ")})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// maxQuickMoveButtons caps the favorites shown in the "Move to" row.
+const maxQuickMoveButtons = 6
+
+func (b *Bot) handleFavoritesCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/fav" {
+		return false
+	}
+	if len(fields) >= 2 && strings.ToLower(fields[1]) == "add" {
+		dirID, err := b.store.GetCurrentDirID(ctx, userID)
+		if err != nil {
+			b.sendText(ctx, chatID, "Failed to locate current folder.")
+			return true
+		}
+		label := strings.TrimSpace(strings.Join(fields[2:], " "))
+		if err := b.addFavorite(ctx, userID, dirID, label); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Add favorite failed: %v", err))
+			return true
+		}
+	}
+	text, markup, err := b.favoritesView(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load favorites: %v", err))
+		return true
+	}
+	_, _ = b.tg.SendMessage(ctx, chatID, text, markup)
+	return true
+}
+
+func (b *Bot) addFavorite(ctx context.Context, userID, dirID int64, label string) error {
+	if label == "" {
+		dir, err := b.store.GetDirByID(ctx, userID, dirID)
+		if err != nil {
+			return err
+		}
+		label = dir.Name
+		if !dir.ParentID.Valid {
+			label = "Root"
+		}
+	}
+	_, err := b.store.AddFavorite(ctx, userID, dirID, label)
+	return err
+}
+
+func (b *Bot) favoritesView(ctx context.Context, userID int64) (string, *telegram.InlineKeyboardMarkup, error) {
+	favs, err := b.store.ListFavorites(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	currentDir, err := b.store.GetCurrentDirID(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	lines := []string{"Quick destinations"}
+	var rows [][]telegram.InlineKeyboardButton
+	for _, f := range favs {
+		dirPath, err := b.store.GetDirPath(ctx, userID, f.DirID)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s → %s", f.Label, dirPath))
+		rows = append(rows, []telegram.InlineKeyboardButton{
+			{Text: f.Label, CallbackData: fmt.Sprintf("nav:%d:0", f.DirID)},
+			{Text: "Remove", CallbackData: fmt.Sprintf("favdel:%d", f.ID)},
+		})
+	}
+	if len(favs) == 0 {
+		lines = append(lines, "None yet. Open a folder and use /fav add [label].")
+	}
+	rows = append(rows, []telegram.InlineKeyboardButton{{Text: "Add current folder", CallbackData: fmt.Sprintf("favadd:%d", currentDir)}})
+	return strings.Join(lines, "\n"), &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+func (b *Bot) editFavoritesView(ctx context.Context, userID, chatID int64, msgID int) {
+	text, markup, err := b.favoritesView(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load favorites: %v", err))
+		return
+	}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, markup)
+}
+
+// appendQuickMoveRow adds one-tap "Move to" buttons for the user's favorite
+// folders to a file detail keyboard.
+func (b *Bot) appendQuickMoveRow(ctx context.Context, userID int64, file db.File, markup *telegram.InlineKeyboardMarkup) {
+	if markup == nil {
+		return
+	}
+	favs, err := b.store.ListFavorites(ctx, userID)
+	if err != nil || len(favs) == 0 {
+		return
+	}
+	var buttons []telegram.InlineKeyboardButton
+	for _, f := range favs {
+		if f.DirID == file.DirID {
+			continue
+		}
+		buttons = append(buttons, telegram.InlineKeyboardButton{Text: "→ " + f.Label, CallbackData: fmt.Sprintf("mvto:%d:%d", file.ID, f.DirID)})
+		if len(buttons) == maxQuickMoveButtons {
+			break
+		}
+	}
+	for len(buttons) > 0 {
+		n := 3
+		if len(buttons) < n {
+			n = len(buttons)
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, buttons[:n])
+		buttons = buttons[n:]
+	}
+}
@@ -0,0 +1,172 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+const fileRequestUsage = "Usage: /request [days] to create an upload link for the current folder, /request off to revoke its links."
+
+// handleFileRequestCommand handles /request for link owners and /done for
+// uploaders finishing a file request session.
+func (b *Bot) handleFileRequestCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.Split(fields[0], "@")[0] {
+	case "/done":
+		b.endFileRequestSession(ctx, userID, chatID)
+		return true
+	case "/request":
+	default:
+		return false
+	}
+	dirID, err := b.store.GetCurrentDirID(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, "Failed to locate current folder.")
+		return true
+	}
+	if len(fields) > 2 {
+		b.sendText(ctx, chatID, fileRequestUsage)
+		return true
+	}
+	if len(fields) == 2 && strings.EqualFold(fields[1], "off") {
+		n, err := b.store.DeleteFileRequests(ctx, userID, dirID)
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Revoke failed: %v", err))
+			return true
+		}
+		if n == 0 {
+			b.sendText(ctx, chatID, "This folder has no file request links.")
+			return true
+		}
+		b.sendText(ctx, chatID, fmt.Sprintf("Revoked %d file request link(s) for %s.", n, b.dirDisplayPath(ctx, userID, dirID)))
+		return true
+	}
+	var expiresAt *time.Time
+	if len(fields) == 2 {
+		days, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || days < 0 {
+			b.sendText(ctx, chatID, fileRequestUsage)
+			return true
+		}
+		if days > 0 {
+			exp := time.Now().UTC().Add(time.Duration(days) * 24 * time.Hour)
+			expiresAt = &exp
+		}
+	}
+	req, err := b.store.CreateFileRequest(ctx, userID, dirID, randomToken(16), expiresAt)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Create file request failed: %v", err))
+		return true
+	}
+	msg := fmt.Sprintf("File request link for %s:\n%s\n\nAnyone with the link can upload files into this folder without seeing its contents. You get a message for every upload.", b.dirDisplayPath(ctx, userID, dirID), b.startLink("req_"+req.Token))
+	if req.ExpiresAt.Valid {
+		msg += fmt.Sprintf("\nExpires: %s", req.ExpiresAt.Time.Format("2006-01-02 15:04 MST"))
+	}
+	b.sendText(ctx, chatID, msg)
+	return true
+}
+
+// handleFileRequestStart opens an upload session for whoever followed a file
+// request link.
+func (b *Bot) handleFileRequestStart(ctx context.Context, user *telegram.User, chatID int64, token string) {
+	req, err := b.store.GetFileRequestByToken(ctx, token)
+	if err != nil {
+		b.sendText(ctx, chatID, "File request not found.")
+		return
+	}
+	if err := db.ValidateFileRequest(req); err != nil {
+		b.sendText(ctx, chatID, "File request link expired.")
+		return
+	}
+	if err := b.store.StartFileRequestSession(ctx, user.ID, req.ID); err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Open file request failed: %v", err))
+		return
+	}
+	text := fmt.Sprintf("Send the files you want to deliver. They go straight to the requester; you will not see the folder or other uploads.\n\nSend /done when you are finished. The upload session stays open for %d hours.", int(db.FileRequestSessionTTL.Hours()))
+	markup := &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{
+		{{Text: "Done", CallbackData: "reqdone"}},
+	}}
+	_, _ = b.tg.SendMessage(ctx, chatID, text, markup)
+}
+
+// handleFileRequestUpload files an upload into the request owner's folder
+// while the sender has a file request session open.
+func (b *Bot) handleFileRequestUpload(ctx context.Context, user *telegram.User, chatID int64, in *incomingFile) bool {
+	req, err := b.store.GetFileRequestSession(ctx, user.ID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			b.sendText(ctx, chatID, fmt.Sprintf("Failed to load file request: %v", err))
+			return true
+		}
+		return false
+	}
+	if err := db.ValidateFileRequest(req); err != nil {
+		_, _ = b.store.EndFileRequestSession(ctx, user.ID)
+		b.sendText(ctx, chatID, "File request link expired; the file was not delivered.")
+		return true
+	}
+	name, err := b.availableFileName(ctx, req.UserID, req.DirID, in.Name)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Delivery failed: %v", err))
+		return true
+	}
+	rec, err := b.store.CreateFile(ctx, req.UserID, req.DirID, name, in.FileID, in.FileUniqueID, in.Size, in.MimeType)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Delivery failed: %v", err))
+		return true
+	}
+	b.recordFileMessage(ctx, req.UserID, rec.ID, in)
+	b.recordFileMetadata(ctx, req.UserID, rec.ID, in)
+	b.recordThumbnail(ctx, req.UserID, rec.ID, in)
+	_ = b.store.IncrementFileRequestUploads(ctx, req.ID)
+	b.sendText(ctx, chatID, fmt.Sprintf("Delivered %s. Send more files or /done to finish.", in.Name))
+	if req.UserID != user.ID {
+		b.notifyUser(ctx, req.UserID, fmt.Sprintf("%s uploaded %s (%s) to %s via your file request.", displayName(user), name, formatBytes(in.Size), b.dirDisplayPath(ctx, req.UserID, req.DirID)))
+	}
+	return true
+}
+
+func (b *Bot) endFileRequestSession(ctx context.Context, userID, chatID int64) {
+	ended, err := b.store.EndFileRequestSession(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to close file request: %v", err))
+		return
+	}
+	if !ended {
+		b.sendText(ctx, chatID, "No file request is open.")
+		return
+	}
+	b.sendText(ctx, chatID, "Done. Your files were delivered.")
+}
+
+// availableFileName returns name, or name with a " (n)" suffix before the
+// extension when the folder already holds an entry called name.
+func (b *Bot) availableFileName(ctx context.Context, userID, dirID int64, name string) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; i < 1000; i++ {
+		err := b.store.CheckNameAvailable(ctx, userID, dirID, candidate)
+		if err == nil {
+			return candidate, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	return "", fmt.Errorf("too many files named %s", name)
+}
@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigpak/internal/db"
+	"pigpak/internal/gateway"
+)
+
+const shareFolderUsage = "Usage: /sharefolder [days] [password]\nShares the current folder as a web gallery anyone with the link can browse; 0 days never expires."
+
+// handleShareFolderCommand shares the current folder over HTTP:
+//
+//	/sharefolder [days] [password]
+func (b *Bot) handleShareFolderCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/sharefolder" {
+		return false
+	}
+	if !b.cfg.WebDAVEnable || b.cfg.WebDAVPublicURL == "" {
+		b.sendText(ctx, chatID, "Folder shares need the HTTP server with a public URL (WEB_DAV_ENABLE and WEB_DAV_PUBLIC_URL).")
+		return true
+	}
+	days := 7
+	if len(fields) > 1 {
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 0 {
+			b.sendText(ctx, chatID, shareFolderUsage)
+			return true
+		}
+		days = n
+	}
+	password := ""
+	if len(fields) > 2 {
+		password = strings.Join(fields[2:], " ")
+	}
+	dirID, err := b.store.GetCurrentDirID(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, "Failed to locate current folder.")
+		return true
+	}
+	var expiresAt *time.Time
+	if days > 0 {
+		exp := time.Now().UTC().Add(time.Duration(days) * 24 * time.Hour)
+		expiresAt = &exp
+	}
+	share, err := b.store.CreateFolderShare(ctx, userID, dirID, randomToken(16), expiresAt, password)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Share folder failed: %v", err))
+		return true
+	}
+	lines := []string{"Folder shared: " + b.folderShareURL(share)}
+	if expiresAt != nil {
+		lines = append(lines, "Expires "+expiresAt.Format("2006-01-02 15:04 MST")+".")
+	}
+	if password != "" {
+		lines = append(lines, "It asks for the password; you can delete your message containing it.")
+	}
+	lines = append(lines, "Use /shares to revoke it.")
+	b.sendText(ctx, chatID, strings.Join(lines, "\n"))
+	return true
+}
+
+func (b *Bot) folderShareURL(share db.FolderShare) string {
+	return strings.TrimSuffix(b.cfg.WebDAVPublicURL, "/") + gateway.FolderSharePath(share)
+}
+
+// revokeFolderShare deletes one of the user's folder shares and refreshes
+// the /shares list.
+func (b *Bot) revokeFolderShare(ctx context.Context, userID, chatID int64, msgID int, shareID int64) {
+	if err := b.store.RevokeFolderShare(ctx, userID, shareID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		b.sendText(ctx, chatID, fmt.Sprintf("Revoke failed: %v", err))
+		return
+	}
+	body, markup, err := b.sharesView(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load shares: %v", err))
+		return
+	}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, body, markup)
+}
@@ -0,0 +1,53 @@
+package bot
+
+import (
+	"fmt"
+
+	"pigpak/internal/telegram"
+)
+
+const (
+	// standardSendLimit is the largest file a regular account can send to a bot.
+	standardSendLimit int64 = 2000 * 1024 * 1024
+	// premiumSendLimit is the largest file a Telegram Premium account can send.
+	premiumSendLimit int64 = 4000 * 1024 * 1024
+	// cloudDownloadLimit is the getFile ceiling of the hosted Bot API.
+	cloudDownloadLimit int64 = 20 * 1024 * 1024
+)
+
+// sendLimit returns how large a file the user's account can send to the bot.
+func sendLimit(user *telegram.User) int64 {
+	if user != nil && user.IsPremium {
+		return premiumSendLimit
+	}
+	return standardSendLimit
+}
+
+// downloadLimit returns the largest file the server can fetch back from
+// Telegram, or 0 when a local Bot API server removes the limit.
+func (b *Bot) downloadLimit() int64 {
+	if b.cfg.TelegramLocalAPI {
+		return 0
+	}
+	return cloudDownloadLimit
+}
+
+func (b *Bot) sizeGuidance(user *telegram.User) string {
+	text := fmt.Sprintf("You can send files up to %s.", formatBytes(sendLimit(user)))
+	if user == nil || !user.IsPremium {
+		text += fmt.Sprintf(" Telegram Premium accounts can send up to %s.", formatBytes(premiumSendLimit))
+	}
+	if limit := b.downloadLimit(); limit > 0 && b.cfg.WebDAVEnable {
+		text += fmt.Sprintf(" Files over %s can be sent back through the bot but not read over WebDAV.", formatBytes(limit))
+	}
+	return text
+}
+
+// downloadNote explains why a freshly stored file cannot be streamed by the server.
+func (b *Bot) downloadNote(size int64) string {
+	limit := b.downloadLimit()
+	if limit == 0 || size <= limit || !b.cfg.WebDAVEnable {
+		return ""
+	}
+	return fmt.Sprintf("Note: this file is larger than %s, so it can be sent back through the bot but not read over WebDAV unless the server uses a local Bot API server.", formatBytes(limit))
+}
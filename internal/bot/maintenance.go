@@ -0,0 +1,160 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceStep is the outcome of one maintenance task.
+type maintenanceStep struct {
+	name   string
+	took   time.Duration
+	result string
+	err    error
+}
+
+// maintenanceState guards maintenance passes so only one runs at a time and
+// keeps the report of the last one for /maintenance.
+type maintenanceState struct {
+	running  atomic.Bool
+	mu       sync.Mutex
+	finished time.Time
+	steps    []maintenanceStep
+}
+
+// startMaintenance runs a maintenance pass in the background and calls done,
+// when set, with its report. It returns false when a pass is already
+// running.
+func (b *Bot) startMaintenance(ctx context.Context, done func(report string)) bool {
+	if !b.maintenance.running.CompareAndSwap(false, true) {
+		return false
+	}
+	go func() {
+		defer b.maintenance.running.Store(false)
+		steps := b.runMaintenance(ctx)
+		b.maintenance.mu.Lock()
+		b.maintenance.finished = time.Now()
+		b.maintenance.steps = steps
+		b.maintenance.mu.Unlock()
+		if done != nil {
+			done(b.maintenanceReport())
+		}
+	}()
+	return true
+}
+
+// RunMaintenance runs a maintenance pass and returns its report. It returns
+// ErrBusy when a pass is already running.
+func (b *Bot) RunMaintenance(ctx context.Context) (string, error) {
+	reports := make(chan string, 1)
+	if !b.startMaintenance(ctx, func(report string) { reports <- report }) {
+		return "", ErrBusy
+	}
+	select {
+	case report := <-reports:
+		return report, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (b *Bot) runMaintenance(ctx context.Context) []maintenanceStep {
+	tasks := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{"optimize", func() (string, error) {
+			return "ok", b.store.Optimize(ctx)
+		}},
+		{"incremental vacuum", func() (string, error) {
+			n, err := b.store.IncrementalVacuum(ctx)
+			return fmt.Sprintf("%d page(s) freed", n), err
+		}},
+		{"expired shares", func() (string, error) {
+			n, err := b.store.PurgeExpiredShares(ctx)
+			return fmt.Sprintf("%d removed", n), err
+		}},
+		{"expired WebDAV locks", func() (string, error) {
+			n, err := b.store.PurgeExpiredWebDAVLocks(ctx)
+			return fmt.Sprintf("%d removed", n), err
+		}},
+		{"trash retention", func() (string, error) {
+			if b.cfg.TrashRetention <= 0 {
+				return "disabled", nil
+			}
+			n, err := b.store.PurgeOldTrash(ctx, b.cfg.TrashRetention)
+			return fmt.Sprintf("%d item(s) older than %s purged", n, b.cfg.TrashRetention), err
+		}},
+	}
+	steps := make([]maintenanceStep, 0, len(tasks))
+	for _, task := range tasks {
+		start := time.Now()
+		result, err := task.run()
+		step := maintenanceStep{name: task.name, took: time.Since(start), result: result, err: err}
+		if err != nil {
+			log.Printf("maintenance %s failed after %s: %v", step.name, step.took, err)
+		} else {
+			log.Printf("maintenance %s: %s (%s)", step.name, step.result, step.took)
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+func (b *Bot) maintenanceReport() string {
+	b.maintenance.mu.Lock()
+	defer b.maintenance.mu.Unlock()
+	if b.maintenance.finished.IsZero() {
+		return "No maintenance pass has run yet."
+	}
+	lines := []string{"Maintenance finished " + b.maintenance.finished.UTC().Format(time.RFC3339) + ":"}
+	for _, s := range b.maintenance.steps {
+		took := s.took.Round(time.Millisecond)
+		if s.err != nil {
+			lines = append(lines, fmt.Sprintf("- %s: failed: %v (%s)", s.name, s.err, took))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %s (%s)", s.name, s.result, took))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// handleMaintenanceCommand serves the admin-only maintenance controls:
+//
+//	/maintenance      show the last pass
+//	/maintenance run  start a pass now
+func (b *Bot) handleMaintenanceCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/maintenance" {
+		return false
+	}
+	if !b.cfg.IsAdmin(userID) {
+		b.sendText(ctx, chatID, "This command is restricted to admins.")
+		return true
+	}
+	switch {
+	case len(fields) == 1:
+		report := b.maintenanceReport()
+		if b.maintenance.running.Load() {
+			report += "\nA pass is running now."
+		}
+		b.sendText(ctx, chatID, report)
+	case len(fields) == 2 && fields[1] == "run":
+		started := b.startMaintenance(ctx, func(report string) {
+			b.sendText(ctx, chatID, report)
+		})
+		if !started {
+			b.sendText(ctx, chatID, "A maintenance pass is already running.")
+			return true
+		}
+		b.sendText(ctx, chatID, "Maintenance started.")
+	default:
+		b.sendText(ctx, chatID, "Usage: /maintenance [run]")
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"pigpak/internal/media"
+	"pigpak/internal/telegram"
+)
+
+// metadataProbeTimeout bounds fetching the start of an upload to read it.
+const metadataProbeTimeout = 15 * time.Second
+
+// probeUpload reads the start of an upload to correct its MIME type, to
+// complete the metadata Telegram reported and to find a thumbnail. Files
+// the bot cannot download keep what Telegram said.
+func (b *Bot) probeUpload(ctx context.Context, in *incomingFile) {
+	ctx, cancel := context.WithTimeout(ctx, metadataProbeTimeout)
+	defer cancel()
+	var head []byte
+	if limit := b.downloadLimit(); limit <= 0 || in.Size <= limit {
+		var err error
+		if head, err = b.readFileHead(ctx, in.FileID); err != nil {
+			log.Printf("probe upload %s: %v", in.Name, err)
+		} else {
+			in.MimeType = media.SniffMIME(head, in.MimeType, in.Name)
+			in.Meta = in.Meta.Merge(media.Probe(head, in.Size))
+		}
+	}
+	b.probeThumbnail(ctx, in, head)
+}
+
+// readFileHead downloads up to media.HeadSize bytes from the start of a
+// Telegram file.
+func (b *Bot) readFileHead(ctx context.Context, fileID string) ([]byte, error) {
+	info, err := b.tg.GetFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := b.tg.DownloadFile(ctx, info.FilePath, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, media.HeadSize))
+}
+
+// recordFileMetadata stores what is known about a saved upload's content.
+func (b *Bot) recordFileMetadata(ctx context.Context, userID, fileID int64, in *incomingFile) {
+	if in.Meta.IsZero() {
+		return
+	}
+	if err := b.store.SetFileMetadata(ctx, userID, fileID, in.Meta); err != nil {
+		log.Printf("record metadata of file %d: %v", fileID, err)
+	}
+}
+
+// fileMetadataLines renders a file's content metadata for its detail view,
+// or "" when nothing is known.
+func (b *Bot) fileMetadataLines(ctx context.Context, userID, fileID int64) string {
+	m, err := b.store.GetFileMetadata(ctx, userID, fileID)
+	if err != nil {
+		return ""
+	}
+	text := ""
+	if m.Width > 0 && m.Height > 0 {
+		text += "\nDimensions: " + telegram.Code(fmt.Sprintf("%d×%d", m.Width, m.Height))
+	}
+	if m.Duration > 0 {
+		text += "\nDuration: " + telegram.Code(formatPlayTime(m.Duration))
+	}
+	if m.Pages > 0 {
+		text += "\nPages: " + telegram.Code(strconv.Itoa(m.Pages))
+	}
+	if !m.TakenAt.IsZero() {
+		text += "\nTaken: " + telegram.Code(m.TakenAt.Format("2006-01-02 15:04"))
+	}
+	if m.Title != "" {
+		text += "\nTitle: " + telegram.EscapeHTML(m.Title)
+	}
+	if m.Artist != "" {
+		text += "\nArtist: " + telegram.EscapeHTML(m.Artist)
+	}
+	if m.Album != "" {
+		text += "\nAlbum: " + telegram.EscapeHTML(m.Album)
+	}
+	return text
+}
+
+// formatPlayTime renders a duration as m:ss or h:mm:ss.
+func formatPlayTime(d time.Duration) string {
+	s := int64(d.Round(time.Second) / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
@@ -0,0 +1,96 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"pigpak/internal/telegram"
+)
+
+const (
+	// maxNotificationAttempts bounds how often a queued notification is
+	// retried.
+	maxNotificationAttempts = 20
+	// notifyFlushInterval spaces out attempts to deliver queued
+	// notifications.
+	notifyFlushInterval = 15 * time.Second
+)
+
+// notifyUser sends an event notification to a user's private chat. When
+// Telegram cannot be reached the notification is queued in the DB and
+// delivered by flushNotifications once Telegram answers again.
+func (b *Bot) notifyUser(ctx context.Context, userID int64, text string) {
+	_, err := b.tg.SendMessage(ctx, userID, text, nil)
+	if err == nil {
+		return
+	}
+	if !isUnreachable(err) {
+		log.Printf("notify user %d: %v", userID, err)
+		return
+	}
+	if err := b.store.EnqueueNotification(ctx, userID, userID, text, telegram.RedactError(err)); err != nil {
+		log.Printf("queue notification for %d: %v", userID, err)
+		return
+	}
+	b.notifyQueued.Store(true)
+}
+
+// runNotificationFlusher delivers queued notifications on its own ticker
+// until ctx is done, so a slow flush never holds up polling.
+func (b *Bot) runNotificationFlusher(ctx context.Context) {
+	ticker := time.NewTicker(notifyFlushInterval)
+	defer ticker.Stop()
+	for {
+		if b.notifyQueued.CompareAndSwap(true, false) {
+			b.flushNotifications(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushNotifications delivers queued notifications in order, stopping at the
+// first one that still cannot reach Telegram.
+func (b *Bot) flushNotifications(ctx context.Context) {
+	for {
+		pending, err := b.store.ListPendingNotifications(ctx, 50)
+		if err != nil {
+			log.Printf("list notifications: %v", err)
+			return
+		}
+		if len(pending) == 0 {
+			return
+		}
+		for _, n := range pending {
+			_, err := b.tg.SendMessage(ctx, n.ChatID, n.Text, nil)
+			if err != nil && isUnreachable(err) && n.Attempts+1 < maxNotificationAttempts {
+				_ = b.store.MarkNotificationAttempt(ctx, n.ID, telegram.RedactError(err))
+				b.notifyQueued.Store(true)
+				return
+			}
+			if err != nil {
+				log.Printf("drop notification %d for %d: %v", n.ID, n.UserID, err)
+			}
+			if err := b.store.DeleteNotification(ctx, n.ID); err != nil {
+				log.Printf("delete notification %d: %v", n.ID, err)
+				return
+			}
+		}
+	}
+}
+
+// isUnreachable reports whether err means the request never got an answer
+// from Telegram, as opposed to Telegram rejecting it.
+func isUnreachable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
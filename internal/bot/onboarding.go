@@ -0,0 +1,205 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// baseFolders are offered in the first onboarding step. The callback data
+// carries the selection as a bitmask over this slice, so order is stable.
+var baseFolders = []string{"Documents", "Photos", "Videos", "Music"}
+
+// allBaseFolders selects every entry of baseFolders.
+var allBaseFolders = 1<<len(baseFolders) - 1
+
+// maybeStartOnboarding sends the setup wizard to users that have not
+// finished or skipped it yet.
+func (b *Bot) maybeStartOnboarding(ctx context.Context, user *telegram.User, chatID int64) bool {
+	done, err := b.store.IsOnboarded(ctx, user.ID)
+	if err != nil {
+		log.Printf("load onboarding state: %v", err)
+		return false
+	}
+	if done {
+		return false
+	}
+	b.startOnboarding(ctx, user, chatID)
+	return true
+}
+
+func (b *Bot) startOnboarding(ctx context.Context, user *telegram.User, chatID int64) {
+	text := fmt.Sprintf("Welcome to PigPak, %s!\n\nPigPak turns this chat into a cloud drive: files you send are kept by Telegram and organized here in folders you can browse, share and mount over WebDAV.\n\nA quick setup takes three steps.", displayName(user))
+	markup := &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{
+		{{Text: "Start setup", CallbackData: fmt.Sprintf("onb:f:%d", allBaseFolders)}},
+		{{Text: "Skip", CallbackData: "onb:done"}},
+	}}
+	_, _ = b.tg.SendMessage(ctx, chatID, text, markup)
+}
+
+// handleSetupCommand reruns the onboarding wizard on /setup.
+func (b *Bot) handleSetupCommand(ctx context.Context, user *telegram.User, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/setup" {
+		return false
+	}
+	b.startOnboarding(ctx, user, chatID)
+	return true
+}
+
+// handleOnboardingCallback drives the wizard. Every step edits the same
+// message in place:
+//
+//	onb:f:<mask>   folder selection
+//	onb:mk:<mask>  create selected folders
+//	onb:webdav     WebDAV step
+//	onb:pw         ask for a WebDAV password
+//	onb:storage    storage chat explanation
+//	onb:done       finish
+func (b *Bot) handleOnboardingCallback(ctx context.Context, user *telegram.User, chatID int64, msgID int, data string) {
+	step, arg, _ := strings.Cut(data, ":")
+	switch step {
+	case "f":
+		mask, _ := strconv.Atoi(arg)
+		text, markup := foldersStepView(mask)
+		_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, markup)
+	case "mk":
+		mask, _ := strconv.Atoi(arg)
+		created, err := b.createBaseFolders(ctx, user.ID, mask)
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Create folder failed: %v", err))
+			return
+		}
+		note := "No folders created."
+		if len(created) > 0 {
+			note = "Created " + strings.Join(created, ", ") + "."
+		}
+		b.editWebDAVStep(ctx, user, chatID, msgID, note)
+	case "webdav":
+		b.editWebDAVStep(ctx, user, chatID, msgID, "")
+	case "pw":
+		_ = b.store.SetPendingAction(ctx, user.ID, "onboard_webdav", int64(msgID), "")
+		b.sendText(ctx, chatID, "Send the WebDAV password you want to use.")
+	case "storage":
+		b.editStorageStep(ctx, chatID, msgID, "")
+	case "done":
+		if err := b.store.MarkOnboarded(ctx, user.ID); err != nil {
+			log.Printf("mark onboarded: %v", err)
+		}
+		_, _ = b.tg.EditMessageText(ctx, chatID, msgID, "Setup complete.\n\n"+b.helpText(user), nil)
+		b.sendDirectoryView(ctx, user.ID, chatID, 0, 0)
+	}
+}
+
+func foldersStepView(mask int) (string, *telegram.InlineKeyboardMarkup) {
+	text := "Step 1/3: base folders\n\nPick the folders to create in your root folder. You can rename or delete them later."
+	var rows [][]telegram.InlineKeyboardButton
+	for i, name := range baseFolders {
+		bit := 1 << i
+		label := "[ ] " + name
+		if mask&bit != 0 {
+			label = "[x] " + name
+		}
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: label, CallbackData: fmt.Sprintf("onb:f:%d", mask^bit)}})
+	}
+	rows = append(rows, []telegram.InlineKeyboardButton{
+		{Text: "Create selected", CallbackData: fmt.Sprintf("onb:mk:%d", mask)},
+		{Text: "Skip", CallbackData: "onb:webdav"},
+	})
+	return text, &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// createBaseFolders creates the selected base folders in the user's root,
+// leaving existing folders with the same name untouched.
+func (b *Bot) createBaseFolders(ctx context.Context, userID int64, mask int) ([]string, error) {
+	rootID, err := b.store.GetRootDirID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	var created []string
+	for i, name := range baseFolders {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		if _, err := b.store.GetDirByName(ctx, userID, rootID, name); err == nil {
+			continue
+		}
+		if _, err := b.store.CreateDir(ctx, userID, rootID, name); err != nil {
+			return created, err
+		}
+		created = append(created, name)
+	}
+	return created, nil
+}
+
+func (b *Bot) editWebDAVStep(ctx context.Context, user *telegram.User, chatID int64, msgID int, note string) {
+	if !b.cfg.WebDAVEnable {
+		b.editStorageStep(ctx, chatID, msgID, note)
+		return
+	}
+	text := "Step 2/3: WebDAV\n\nWebDAV lets you mount your drive in a file manager or sync tool."
+	if note != "" {
+		text = note + "\n\n" + text
+	}
+	var rows [][]telegram.InlineKeyboardButton
+	if strings.TrimSpace(user.Username) == "" {
+		text += "\n\nWebDAV logins use your Telegram username, and you do not have one yet. Set a username in Telegram settings, then run /webdav set <password>."
+	} else {
+		hasPassword, err := b.store.WebDAVPasswordSet(ctx, user.ID)
+		if err != nil {
+			log.Printf("webdav password status: %v", err)
+		}
+		text += fmt.Sprintf("\n\nUsername: %s", user.Username)
+		if url := b.webdavURL(); url != "" {
+			text += "\nURL: " + url
+		}
+		label := "Set password"
+		if hasPassword {
+			text += "\nPassword: set"
+			label = "Change password"
+		} else {
+			text += "\nPassword: not set"
+		}
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: label, CallbackData: "onb:pw"}})
+	}
+	rows = append(rows, []telegram.InlineKeyboardButton{{Text: "Continue", CallbackData: "onb:storage"}})
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, &telegram.InlineKeyboardMarkup{InlineKeyboard: rows})
+}
+
+// handleOnboardingPassword handles the text sent after "Set password" in the
+// wizard and moves the wizard message on to the next step.
+func (b *Bot) handleOnboardingPassword(ctx context.Context, userID, chatID int64, state db.UserState, text string) {
+	pass := strings.TrimSpace(text)
+	if pass == "" {
+		b.sendText(ctx, chatID, "Password cannot be empty.")
+		return
+	}
+	if err := b.store.SetWebDAVPassword(ctx, userID, pass); err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Set password failed: %v", err))
+		return
+	}
+	_ = b.store.ClearPendingAction(ctx, userID)
+	b.editStorageStep(ctx, chatID, int(state.PendingTarget.Int64), "WebDAV password saved. You can delete your message containing it.")
+}
+
+func (b *Bot) editStorageStep(ctx context.Context, chatID int64, msgID int, note string) {
+	text := "Step 3/3: storage\n\nFiles you send to this chat stay on Telegram's servers; PigPak only keeps their references, so do not delete them from Telegram."
+	if note != "" {
+		text = note + "\n\n" + text
+	}
+	if b.cfg.StorageChatID != 0 {
+		text += "\n\nUploads from WebDAV are stored in the server's storage chat and work on this server. To keep them in a private channel or group of your own instead, set one up with /storage."
+	} else {
+		text += "\n\nUploads from WebDAV need a storage chat: a private channel or group where the bot is an admin. This server has none, so WebDAV stays read-only until you set up your own; sending files to this chat still works."
+	}
+	markup := &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{
+		{{Text: "Set up storage chat", CallbackData: "ustore"}},
+		{{Text: "Finish", CallbackData: "onb:done"}},
+	}}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, markup)
+}
@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"pigpak/internal/config"
+	"pigpak/internal/rules"
+)
+
+const partSizeUsage = `Usage:
+/partsize to see the part size your WebDAV uploads are split into
+/partsize <size> to choose your own, e.g. /partsize 200MB for a flaky connection
+/partsize default to go back to the server's`
+
+func (b *Bot) handlePartSizeCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/partsize" {
+		return false
+	}
+	switch len(fields) {
+	case 1:
+		b.sendPartSize(ctx, userID, chatID)
+	case 2:
+		b.setPartSize(ctx, userID, chatID, fields[1])
+	default:
+		b.sendText(ctx, chatID, partSizeUsage)
+	}
+	return true
+}
+
+func (b *Bot) sendPartSize(ctx context.Context, userID, chatID int64) {
+	size, err := b.store.GetUserPartSize(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Read part size failed: %v", err))
+		return
+	}
+	limits := fmt.Sprintf("You can choose between %s and %s with /partsize <size>.", formatBytes(config.MinPartSizeBytes), formatBytes(b.cfg.MaxUserPartSizeBytes))
+	if size <= 0 {
+		b.sendText(ctx, chatID, fmt.Sprintf("Part size: server default, %s\nWebDAV uploads larger than that are stored as several parts. Smaller parts lose less to a dropped connection. %s", formatBytes(b.cfg.MaxPartSizeBytes), limits))
+		return
+	}
+	size = min(size, b.cfg.MaxUserPartSizeBytes)
+	b.sendText(ctx, chatID, fmt.Sprintf("Part size: %s\nYour WebDAV uploads larger than that are stored as several parts. %s Use /partsize default to go back to the server's %s.", formatBytes(size), limits, formatBytes(b.cfg.MaxPartSizeBytes)))
+}
+
+// setPartSize saves the part size named by value, a size or "default".
+func (b *Bot) setPartSize(ctx context.Context, userID, chatID int64, value string) {
+	var size int64
+	if !strings.EqualFold(value, "default") {
+		parsed, err := rules.ParseSize(value)
+		if err != nil {
+			b.sendText(ctx, chatID, partSizeUsage)
+			return
+		}
+		if parsed < config.MinPartSizeBytes || parsed > b.cfg.MaxUserPartSizeBytes {
+			b.sendText(ctx, chatID, fmt.Sprintf("Choose a part size between %s and %s.", formatBytes(config.MinPartSizeBytes), formatBytes(b.cfg.MaxUserPartSizeBytes)))
+			return
+		}
+		size = parsed
+	}
+	if err := b.store.SetUserPartSize(ctx, userID, size); err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Set part size failed: %v", err))
+		return
+	}
+	b.sendPartSize(ctx, userID, chatID)
+}
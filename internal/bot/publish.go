@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"pigpak/internal/db"
+	"pigpak/internal/gateway"
+)
+
+// handlePublishCommand publishes the current folder as a static download page:
+//
+//	/publish      render (or refresh) the page and send it as index.html
+//	/publish off  remove the page
+func (b *Bot) handlePublishCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/publish" {
+		return false
+	}
+	if !b.cfg.WebDAVEnable || b.cfg.WebDAVPublicURL == "" || b.signer == nil {
+		b.sendText(ctx, chatID, "Publishing needs the HTTP server with a public URL (WEB_DAV_ENABLE and WEB_DAV_PUBLIC_URL).")
+		return true
+	}
+	dirID, err := b.store.GetCurrentDirID(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, "Failed to locate current folder.")
+		return true
+	}
+	if len(fields) > 1 {
+		if strings.ToLower(fields[1]) != "off" {
+			b.sendText(ctx, chatID, "Usage: /publish [off]")
+			return true
+		}
+		if err := b.store.DeletePage(ctx, userID, dirID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				b.sendText(ctx, chatID, "This folder is not published.")
+				return true
+			}
+			b.sendText(ctx, chatID, fmt.Sprintf("Unpublish failed: %v", err))
+			return true
+		}
+		note := "Page removed."
+		if b.cfg.PublishLinkTTL <= 0 {
+			note += " Download links copied from it keep working; set PUBLISH_LINK_TTL to make published links expire."
+		}
+		b.sendText(ctx, chatID, note)
+		return true
+	}
+	html, count, err := b.renderFolderIndex(ctx, userID, dirID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Publish failed: %v", err))
+		return true
+	}
+	page, err := b.store.SavePage(ctx, userID, dirID, randomToken(24), string(html))
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Publish failed: %v", err))
+		return true
+	}
+	pageURL := strings.TrimSuffix(b.cfg.WebDAVPublicURL, "/") + gateway.PagePath(page.Token)
+	b.sendText(ctx, chatID, fmt.Sprintf("Published %d file(s).\nPage: %s\nRun /publish again after changes to refresh it, or /publish off to remove it.", count, pageURL))
+	if _, err := b.tg.UploadDocument(ctx, chatID, "index.html", bytes.NewReader(html)); err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Upload index.html failed: %v", err))
+	}
+	return true
+}
+
+// renderFolderIndex renders a page listing every file below dirID with a
+// signed download link and, where there is one, its thumbnail.
+func (b *Bot) renderFolderIndex(ctx context.Context, userID, dirID int64) ([]byte, int, error) {
+	dir, err := b.store.GetDirByID(ctx, userID, dirID)
+	if err != nil {
+		return nil, 0, err
+	}
+	dirs, err := b.store.ListAllDirs(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	files, err := b.store.ListAllFiles(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	thumbs, err := b.store.ThumbnailFileIDs(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	paths := dirPaths(dirs)
+	inside := subtreeDirs(dirs, dirID)
+	base := paths[dirID]
+	var expires time.Time
+	if b.cfg.PublishLinkTTL > 0 {
+		expires = time.Now().Add(b.cfg.PublishLinkTTL)
+	}
+	baseURL := strings.TrimSuffix(b.cfg.WebDAVPublicURL, "/")
+	var entries []gateway.IndexEntry
+	for _, f := range files {
+		if !inside[f.DirID] {
+			continue
+		}
+		rel := strings.TrimPrefix(path.Join(strings.TrimPrefix(paths[f.DirID], base), f.Name), "/")
+		item := gateway.IndexEntry{
+			Path: rel,
+			Size: formatBytes(f.Size),
+			URL:  baseURL + gateway.DownloadPath(b.signer, f, expires),
+		}
+		if thumbs[f.ID] {
+			item.Thumb = baseURL + gateway.ThumbnailPath(b.signer, f, expires)
+		}
+		entries = append(entries, item)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	title := dir.Name
+	if !dir.ParentID.Valid {
+		title = b.driveLabel(ctx, userID, dirID)
+	}
+	html, err := gateway.RenderIndex(gateway.Index{Title: title, GeneratedAt: time.Now().UTC(), Entries: entries})
+	return html, len(entries), err
+}
+
+// subtreeDirs returns the set of directories at or below rootID.
+func subtreeDirs(dirs []db.Directory, rootID int64) map[int64]bool {
+	children := make(map[int64][]int64)
+	for _, d := range dirs {
+		if d.ParentID.Valid {
+			children[d.ParentID.Int64] = append(children[d.ParentID.Int64], d.ID)
+		}
+	}
+	inside := map[int64]bool{rootID: true}
+	queue := []int64{rootID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, child := range children[id] {
+			if !inside[child] {
+				inside[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return inside
+}
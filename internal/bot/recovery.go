@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// sendStoredFile sends a file (or its parts) back to the chat. When Telegram
+// no longer serves the stored file_id the file is flagged and the detail
+// message switches to the recovery view instead of failing silently.
+func (b *Bot) sendStoredFile(ctx context.Context, userID, chatID int64, msgID int, file db.File) {
+	if file.IsEmpty() {
+		// Telegram has no way to send an empty document.
+		b.sendText(ctx, chatID, fmt.Sprintf("%s is empty; there is nothing to send.", file.Name))
+		return
+	}
+	parts, err := b.store.ListFileParts(ctx, file.ID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Load parts failed: %v", err))
+		return
+	}
+	failedBlob := file.FileUniqueID
+	stopAction := b.showChatAction(ctx, chatID, telegram.ChatActionUploadDocument)
+	if len(parts) == 0 {
+		_, err = b.tg.SendDocument(ctx, chatID, file.FileID, file.Name, nil)
+	} else {
+		failedBlob, err = b.sendFileParts(ctx, chatID, file, parts)
+	}
+	stopAction()
+	if err == nil {
+		if file.RefState == db.RefStateUnreachable {
+			_ = b.store.SetFileRefState(ctx, userID, file.ID, db.RefStateOK)
+		}
+		return
+	}
+	if !telegram.IsFileReferenceError(err) {
+		b.sendText(ctx, chatID, fmt.Sprintf("Send failed: %v", err))
+		return
+	}
+	if err := b.store.MarkBlobUnreachable(ctx, failedBlob); err != nil {
+		log.Printf("mark blob %s unreachable: %v", failedBlob, err)
+	}
+	if refreshed, err := b.store.GetFileByID(ctx, userID, file.ID); err == nil {
+		file = refreshed
+	}
+	b.editFileDetail(ctx, userID, chatID, msgID, file, "")
+}
+
+func lostFileView(file db.File, partCount int) (string, *telegram.InlineKeyboardMarkup) {
+	text := fileHeaderHTML(file, partCount)
+	var rows [][]telegram.InlineKeyboardButton
+	switch file.RefState {
+	case db.RefStateLost:
+		text += "\nStatus: <b>reference lost</b> (marked by you)\nTelegram no longer serves this file. Re-send the original to restore it."
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: "Restore from re-upload", CallbackData: fmt.Sprintf("restore:%d", file.ID)}})
+	default:
+		text += "\nStatus: <b>reference lost</b>\nTelegram rejected the stored file ID. Retry, restore it by re-sending the original, or mark it as lost."
+		rows = append(rows,
+			[]telegram.InlineKeyboardButton{{Text: "Retry", CallbackData: fmt.Sprintf("sendfile:%d", file.ID)}, {Text: "Restore from re-upload", CallbackData: fmt.Sprintf("restore:%d", file.ID)}},
+			[]telegram.InlineKeyboardButton{{Text: "Mark lost", CallbackData: fmt.Sprintf("marklost:%d", file.ID)}},
+		)
+	}
+	rows = append(rows,
+		[]telegram.InlineKeyboardButton{{Text: "Delete", CallbackData: fmt.Sprintf("delfile:%d", file.ID)}},
+		[]telegram.InlineKeyboardButton{{Text: "Back", CallbackData: fmt.Sprintf("nav:%d:0", file.DirID)}},
+	)
+	return text, &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// handleRestoreUpload consumes an upload sent in reply to "Restore from
+// re-upload": when the content matches the lost file (or one of its parts),
+// the fresh file_id replaces the dead one for every file sharing the content.
+func (b *Bot) handleRestoreUpload(ctx context.Context, userID, chatID int64, in *incomingFile) bool {
+	state, err := b.store.GetUserState(ctx, userID)
+	if err != nil || !state.PendingAction.Valid || state.PendingAction.String != "restore_file" {
+		return false
+	}
+	file, err := b.store.GetFileByID(ctx, userID, state.PendingTarget.Int64)
+	if err != nil {
+		_ = b.store.ClearPendingAction(ctx, userID)
+		return false
+	}
+	matches := in.FileUniqueID == file.FileUniqueID
+	if !matches {
+		parts, err := b.store.ListFileParts(ctx, file.ID)
+		if err == nil {
+			for _, part := range parts {
+				if part.FileUniqueID == in.FileUniqueID {
+					matches = true
+					break
+				}
+			}
+		}
+	}
+	if !matches {
+		b.sendText(ctx, chatID, fmt.Sprintf("That file does not match %s. Send the original file, or use /start to cancel.", file.Name))
+		return true
+	}
+	if err := b.store.RefreshBlob(ctx, in.FileUniqueID, in.FileID); err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Restore failed: %v", err))
+		return true
+	}
+	if parts, err := b.store.ListFileParts(ctx, file.ID); err == nil && len(parts) == 0 {
+		b.recordFileMessage(ctx, userID, file.ID, in)
+	}
+	_ = b.store.ClearPendingAction(ctx, userID)
+	restored, err := b.store.GetFileByID(ctx, userID, file.ID)
+	if err != nil {
+		b.sendText(ctx, chatID, "File not found.")
+		return true
+	}
+	b.sendFileDetail(ctx, userID, chatID, restored, "")
+	return true
+}
+
+// fsckListLimit caps how many flagged files /fsck lists per state.
+const fsckListLimit = 20
+
+// handleFsckCommand reports files whose Telegram reference is broken:
+//
+//	/fsck        your files
+//	/fsck all    every user's files (admins only)
+func (b *Bot) handleFsckCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/fsck" {
+		return false
+	}
+	scope := userID
+	if len(fields) > 1 {
+		if fields[1] != "all" {
+			b.sendText(ctx, chatID, "Usage: /fsck [all]")
+			return true
+		}
+		if !b.cfg.IsAdmin(userID) {
+			b.sendText(ctx, chatID, "/fsck all is restricted to admins.")
+			return true
+		}
+		scope = 0
+	}
+	report, err := b.fsckReport(ctx, scope)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Fsck failed: %v", err))
+		return true
+	}
+	b.sendText(ctx, chatID, report)
+	return true
+}
+
+func (b *Bot) fsckReport(ctx context.Context, userID int64) (string, error) {
+	counts, err := b.store.CountFilesByRefState(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if len(counts) == 0 {
+		return "No broken file references recorded.", nil
+	}
+	var sb strings.Builder
+	for _, c := range counts {
+		fmt.Fprintf(&sb, "%s: %d file(s)\n", c.State, c.Files)
+		files, err := b.store.ListFilesByRefState(ctx, userID, c.State)
+		if err != nil {
+			return "", err
+		}
+		for i, f := range files {
+			if i == fsckListLimit {
+				fmt.Fprintf(&sb, "  ... and %d more\n", len(files)-fsckListLimit)
+				break
+			}
+			if userID == 0 {
+				fmt.Fprintf(&sb, "  #%d %s (user %d)\n", f.ID, f.Name, f.UserID)
+			} else {
+				fmt.Fprintf(&sb, "  #%d %s\n", f.ID, f.Name)
+			}
+		}
+	}
+	sb.WriteString("\nOpen a file to retry, restore it from a re-upload or mark it lost.")
+	return sb.String(), nil
+}
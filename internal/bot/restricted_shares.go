@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// canOpenShare reports whether user may use the share and, for shares
+// addressed by username only, pins it to the user ID that opened it first.
+func (b *Bot) canOpenShare(ctx context.Context, share db.Share, user *telegram.User) bool {
+	if user == nil {
+		return !share.Restricted()
+	}
+	if !share.AllowsUser(user.ID, user.Username) {
+		return false
+	}
+	if share.RecipientID == 0 && share.RecipientUsername != "" {
+		if err := b.store.BindShareRecipient(ctx, share.ID, user.ID); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// handleSharePrivateText creates a share for the recipient named in text,
+// given as "@username [days]" or "<user id> [days]".
+func (b *Bot) handleSharePrivateText(ctx context.Context, userID, chatID int64, state db.UserState, text string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields) > 2 {
+		b.sendText(ctx, chatID, "Send @username or a numeric Telegram user ID, optionally followed by the number of days.")
+		return
+	}
+	var days int64
+	if len(fields) == 2 {
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || n < 0 {
+			b.sendText(ctx, chatID, "Days must be a non-negative number.")
+			return
+		}
+		days = n
+	}
+	var recipientID int64
+	var recipientUsername string
+	target := strings.TrimPrefix(fields[0], "@")
+	if id, err := strconv.ParseInt(target, 10, 64); err == nil && !strings.HasPrefix(fields[0], "@") {
+		if id <= 0 {
+			b.sendText(ctx, chatID, "Telegram user ID is invalid.")
+			return
+		}
+		recipientID = id
+	} else {
+		if target == "" || strings.ContainsAny(target, " /:") {
+			b.sendText(ctx, chatID, "Username is invalid.")
+			return
+		}
+		recipientUsername = target
+		if id, err := b.store.GetUserIDByUsername(ctx, target); err == nil {
+			recipientID = id
+		}
+	}
+	if recipientID == userID {
+		b.sendText(ctx, chatID, "You cannot share a file privately with yourself.")
+		return
+	}
+	file, err := b.store.GetFileByID(ctx, userID, state.PendingTarget.Int64)
+	if err != nil {
+		_ = b.store.ClearPendingAction(ctx, userID)
+		b.sendText(ctx, chatID, "File not found.")
+		return
+	}
+	var expiresAt *time.Time
+	if days > 0 {
+		exp := time.Now().UTC().Add(time.Duration(days) * 24 * time.Hour)
+		expiresAt = &exp
+	}
+	share, err := b.store.CreateRestrictedShare(ctx, userID, file.ID, randomToken(16), expiresAt, recipientID, recipientUsername)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Share failed: %v", err))
+		return
+	}
+	_ = b.store.ClearPendingAction(ctx, userID)
+	link := b.shareURL(share.Token)
+	label := shareRecipientLabel(share)
+	if share.RecipientID != 0 {
+		b.notifyUser(ctx, share.RecipientID, fmt.Sprintf("A file was shared with you: %s\n%s", file.Name, link))
+		b.sendText(ctx, chatID, fmt.Sprintf("Shared %s with %s. They were sent the link; nobody else can open it.", file.Name, label))
+		return
+	}
+	b.sendText(ctx, chatID, fmt.Sprintf("Shared %s with %s. %s has not used this bot yet, so forward them this link; nobody else can open it:\n%s", file.Name, label, label, link))
+}
+
+// shareRecipientLabel describes who a restricted share is meant for.
+func shareRecipientLabel(share db.Share) string {
+	if share.RecipientUsername != "" {
+		return "@" + share.RecipientUsername
+	}
+	if share.RecipientID != 0 {
+		return fmt.Sprintf("user %d", share.RecipientID)
+	}
+	return ""
+}
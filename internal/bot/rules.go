@@ -0,0 +1,197 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"path"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/rules"
+	"pigpak/internal/telegram"
+)
+
+const rulesUsage = "Usage:\n/rules\n/rules add <condition> -> /Folder\n/rules test <file name> [size]\n\nConditions: *.jpg, name *report*, size > 1GB, type image/*, width >= 1920, height < 1080, duration > 10m, pages > 100, taken < 2020-01-01, artist *beatles*, album *live*; combine with \"and\"."
+
+// handleRulesCommand manages auto-organize rules:
+//
+//	/rules
+//	/rules add *.jpg -> /Photos
+//	/rules test holiday.jpg 3MB
+func (b *Bot) handleRulesCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/rules" {
+		return false
+	}
+	if len(fields) == 1 {
+		b.sendRulesView(ctx, userID, chatID)
+		return true
+	}
+	switch strings.ToLower(fields[1]) {
+	case "add":
+		b.addRule(ctx, userID, chatID, strings.Join(fields[2:], " "))
+	case "test":
+		if len(fields) < 3 {
+			b.sendText(ctx, chatID, rulesUsage)
+			return true
+		}
+		b.testRules(ctx, userID, chatID, fields[2:])
+	default:
+		b.sendText(ctx, chatID, rulesUsage)
+	}
+	return true
+}
+
+func (b *Bot) addRule(ctx context.Context, userID, chatID int64, def string) {
+	spec, err := rules.Parse(def)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Invalid rule: %v\n\n%s", err, rulesUsage))
+		return
+	}
+	currentDir, _ := b.store.GetCurrentDirID(ctx, userID)
+	rootID := b.driveRootFor(ctx, userID, currentDir)
+	target, err := b.store.EnsureDirPath(ctx, userID, rootID, strings.Split(spec.Target, "/"))
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Resolve %s failed: %v", spec.Target, err))
+		return
+	}
+	if _, err := b.store.AddRule(ctx, userID, spec.Match, target.ID); err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Add rule failed: %v", err))
+		return
+	}
+	b.sendRulesView(ctx, userID, chatID)
+}
+
+func (b *Bot) testRules(ctx context.Context, userID, chatID int64, args []string) {
+	item := rules.Item{Name: args[0]}
+	if len(args) > 1 {
+		size, err := rules.ParseSize(strings.Join(args[1:], ""))
+		if err != nil {
+			b.sendText(ctx, chatID, err.Error())
+			return
+		}
+		item.Size = size
+	}
+	item.MimeType, _, _ = strings.Cut(mime.TypeByExtension(path.Ext(item.Name)), ";")
+	rule, ok, err := b.store.MatchRule(ctx, userID, item, false)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Test failed: %v", err))
+		return
+	}
+	if !ok {
+		b.sendText(ctx, chatID, fmt.Sprintf("No rule matches %s; it would stay in the current folder.", item.Name))
+		return
+	}
+	b.sendText(ctx, chatID, fmt.Sprintf("%s matches \"%s\" and would be filed into %s.", item.Name, rule.Expr, b.ruleTargetPath(ctx, userID, rule)))
+}
+
+func (b *Bot) ruleTargetPath(ctx context.Context, userID int64, rule db.Rule) string {
+	return b.dirDisplayPath(ctx, userID, rule.TargetDirID)
+}
+
+// dirDisplayPath renders a folder path, prefixed with its drive label when
+// the user has more than one drive.
+func (b *Bot) dirDisplayPath(ctx context.Context, userID, dirID int64) string {
+	p, err := b.store.GetDirPath(ctx, userID, dirID)
+	if err != nil {
+		return fmt.Sprintf("<folder %d>", dirID)
+	}
+	if drives, err := b.store.ListDrives(ctx, userID); err == nil && len(drives) > 1 {
+		if label := b.driveLabel(ctx, userID, dirID); label != "" {
+			return label + ":" + p
+		}
+	}
+	return p
+}
+
+func (b *Bot) rulesView(ctx context.Context, userID int64) (string, *telegram.InlineKeyboardMarkup, error) {
+	list, err := b.store.ListRules(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(list) == 0 {
+		return "No rules yet. Uploads stay in the current folder.\n\n" + rulesUsage, nil, nil
+	}
+	var sb strings.Builder
+	sb.WriteString("Rules are checked top to bottom on every upload; the first match wins.\n")
+	var rows [][]telegram.InlineKeyboardButton
+	for i, r := range list {
+		webdav := ""
+		if r.ApplyWebDAV {
+			webdav = " (also WebDAV)"
+		}
+		fmt.Fprintf(&sb, "\n%d. %s -> %s%s", i+1, r.Expr, b.ruleTargetPath(ctx, userID, r), webdav)
+		toggle := "WebDAV on"
+		if r.ApplyWebDAV {
+			toggle = "WebDAV off"
+		}
+		row := []telegram.InlineKeyboardButton{}
+		if i > 0 {
+			row = append(row, telegram.InlineKeyboardButton{Text: fmt.Sprintf("%d Up", i+1), CallbackData: fmt.Sprintf("ruleup:%d", r.ID)})
+		}
+		row = append(row,
+			telegram.InlineKeyboardButton{Text: fmt.Sprintf("%d %s", i+1, toggle), CallbackData: fmt.Sprintf("rulewd:%d", r.ID)},
+			telegram.InlineKeyboardButton{Text: fmt.Sprintf("%d Delete", i+1), CallbackData: fmt.Sprintf("ruledel:%d", r.ID)},
+		)
+		rows = append(rows, row)
+	}
+	return sb.String(), &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+func (b *Bot) sendRulesView(ctx context.Context, userID, chatID int64) {
+	text, markup, err := b.rulesView(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Load rules failed: %v", err))
+		return
+	}
+	_, _ = b.tg.SendMessage(ctx, chatID, text, markup)
+}
+
+func (b *Bot) editRulesView(ctx context.Context, userID, chatID int64, msgID int) {
+	text, markup, err := b.rulesView(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Load rules failed: %v", err))
+		return
+	}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, markup)
+}
+
+// handleRuleCallback serves the buttons of the /rules view.
+func (b *Bot) handleRuleCallback(ctx context.Context, userID, chatID int64, msgID int, data string) {
+	action, arg, _ := strings.Cut(data, ":")
+	ruleID := parseInt64(arg)
+	var err error
+	switch action {
+	case "ruleup":
+		err = b.store.MoveRuleUp(ctx, userID, ruleID)
+	case "rulewd":
+		var rule db.Rule
+		rule, err = b.store.GetRule(ctx, userID, ruleID)
+		if err == nil {
+			err = b.store.SetRuleWebDAV(ctx, userID, ruleID, !rule.ApplyWebDAV)
+		}
+	case "ruledel":
+		err = b.store.DeleteRule(ctx, userID, ruleID)
+	}
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Update rule failed: %v", err))
+		return
+	}
+	b.editRulesView(ctx, userID, chatID, msgID)
+}
+
+// uploadTarget picks the folder for a bot upload: the destination of the
+// first matching rule, or dirID when no rule applies.
+func (b *Bot) uploadTarget(ctx context.Context, userID, dirID int64, file *incomingFile) (int64, *db.Rule) {
+	rule, ok, err := b.store.MatchRule(ctx, userID, rules.Item{Name: file.Name, Size: file.Size, MimeType: file.MimeType, Meta: file.Meta}, false)
+	if err != nil {
+		log.Printf("match rules: %v", err)
+		return dirID, nil
+	}
+	if !ok || rule.TargetDirID == dirID {
+		return dirID, nil
+	}
+	return rule.TargetDirID, &rule
+}
@@ -0,0 +1,92 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"pigpak/internal/search"
+	"pigpak/internal/telegram"
+)
+
+// maxSearchResults caps the files listed for one search.
+const maxSearchResults = 30
+
+const searchUsage = `Usage: /search <words and filters>
+
+Words must all appear in the file name. Filters:
+type:video (image, video, audio, document, archive or a MIME type such as image/png)
+size:>100MB (also >=, <, <= and =)
+before:2024-01-01 and after:2024-01-01
+in:/Photos (that folder of the current drive and everything below it)
+
+Filters on what was read from the content:
+width:>=1920 and height:>=1080 (pixels)
+duration:>10m (90s, 10m, 1h30m)
+pages:>100
+taken:2024-05-01 (photo date, also with >, >=, <, <=)
+artist:beatles and album:abbey
+
+Example: /search report type:document after:2024-01-01 in:"/My Work"`
+
+func (b *Bot) handleSearchCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/search" {
+		return false
+	}
+	raw := strings.TrimSpace(strings.TrimPrefix(text, fields[0]))
+	if raw == "" {
+		b.sendText(ctx, chatID, searchUsage)
+		return true
+	}
+	q, err := search.Parse(raw)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Invalid search: %v\n\n%s", err, searchUsage))
+		return true
+	}
+	view, markup, err := b.searchView(ctx, userID, raw, q)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Search failed: %v", err))
+		return true
+	}
+	_, _ = b.tg.SendFormattedMessage(ctx, chatID, view, telegram.ParseModeHTML, markup)
+	return true
+}
+
+func (b *Bot) searchView(ctx context.Context, userID int64, raw string, q search.Query) (string, *telegram.InlineKeyboardMarkup, error) {
+	var dirID int64
+	if q.In != "" {
+		currentDir, _ := b.store.GetCurrentDirID(ctx, userID)
+		rootID := b.driveRootFor(ctx, userID, currentDir)
+		dir, err := b.store.FindDirByPathFrom(ctx, userID, rootID, strings.Split(q.In, "/"))
+		if err != nil {
+			return "No folder " + telegram.Code(q.In) + " in this drive.", nil, nil
+		}
+		dirID = dir.ID
+	}
+	files, err := b.store.SearchFiles(ctx, userID, dirID, q, maxSearchResults+1)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(files) == 0 {
+		return "No files match " + telegram.Code(raw) + ".", nil, nil
+	}
+	more := len(files) > maxSearchResults
+	if more {
+		files = files[:maxSearchResults]
+	}
+	lines := []string{"Files matching " + telegram.Code(raw) + ":"}
+	var rows [][]telegram.InlineKeyboardButton
+	for _, f := range files {
+		dirPath, err := b.store.GetDirPath(ctx, userID, f.DirID)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s)", telegram.EscapeHTML(strings.TrimSuffix(dirPath, "/")+"/"+f.Name), formatBytes(f.Size)))
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: "[FILE] " + f.Name, CallbackData: fmt.Sprintf("file:%d", f.ID)}})
+	}
+	if more {
+		lines = append(lines, fmt.Sprintf("Showing the first %d; add words or filters to narrow it down.", maxSearchResults))
+	}
+	return strings.Join(lines, "\n"), &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
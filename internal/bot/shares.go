@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/gateway"
+	"pigpak/internal/telegram"
+)
+
+// maxShareListItems caps the links listed in one /shares view.
+const maxShareListItems = 20
+
+func (b *Bot) handleSharesCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/shares" {
+		return false
+	}
+	body, markup, err := b.sharesView(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load shares: %v", err))
+		return true
+	}
+	_, _ = b.tg.SendMessage(ctx, chatID, body, markup)
+	return true
+}
+
+func (b *Bot) sharesView(ctx context.Context, userID int64) (string, *telegram.InlineKeyboardMarkup, error) {
+	shares, err := b.store.ListSharesByUser(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	lines := []string{fmt.Sprintf("Your share links: %d", len(shares))}
+	var rows [][]telegram.InlineKeyboardButton
+	for i, share := range shares {
+		if i == maxShareListItems {
+			lines = append(lines, fmt.Sprintf("…and %d more.", len(shares)-i))
+			break
+		}
+		name := fmt.Sprintf("file %d", share.FileID)
+		file, err := b.store.GetFileByID(ctx, userID, share.FileID)
+		if err == nil {
+			name = file.Name
+		}
+		details := []string{fmt.Sprintf("%d use(s)", share.Uses)}
+		if share.ExpiresAt.Valid {
+			details = append(details, "expires "+share.ExpiresAt.Time.Format("2006-01-02 15:04 MST"))
+		}
+		if label := shareRecipientLabel(share); label != "" {
+			details = append(details, "only "+label)
+		}
+		if share.HasPassword() {
+			details = append(details, "password")
+		}
+		line := fmt.Sprintf("%d. %s — %s\n%s", i+1, name, strings.Join(details, ", "), b.shareURL(share.Token))
+		row := []telegram.InlineKeyboardButton{{Text: fmt.Sprintf("Revoke %d. %s", i+1, name), CallbackData: fmt.Sprintf("unshare:%d", share.ID)}}
+		// Passwords guard the web link only, so they are offered with it.
+		if web := b.shareWebURL(share, name); web != "" {
+			line += "\n" + web
+			if gateway.Streamable(file) {
+				line += "\nPlay: " + strings.TrimSuffix(b.cfg.WebDAVPublicURL, "/") + gateway.ShareStreamPath(share, file)
+			}
+			row = append(row, telegram.InlineKeyboardButton{Text: fmt.Sprintf("Password %d.", i+1), CallbackData: fmt.Sprintf("sharepw:%d", share.ID)})
+		}
+		lines = append(lines, line)
+		rows = append(rows, row)
+	}
+	if len(shares) == 0 {
+		lines = append(lines, "None. Open a file and use Share to create a link.")
+	}
+	folders, err := b.store.ListFolderSharesByUser(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(folders) > 0 {
+		lines = append(lines, "", fmt.Sprintf("Your folder shares: %d", len(folders)))
+	}
+	for i, share := range folders {
+		if i == maxShareListItems {
+			lines = append(lines, fmt.Sprintf("…and %d more.", len(folders)-i))
+			break
+		}
+		name := fmt.Sprintf("folder %d", share.DirID)
+		if dir, err := b.store.GetDirByID(ctx, userID, share.DirID); err == nil {
+			name = dir.Name
+		}
+		details := []string{fmt.Sprintf("%d download(s)", share.Uses)}
+		if share.ExpiresAt.Valid {
+			details = append(details, "expires "+share.ExpiresAt.Time.Format("2006-01-02 15:04 MST"))
+		}
+		if share.HasPassword() {
+			details = append(details, "password")
+		}
+		lines = append(lines, fmt.Sprintf("F%d. %s/ — %s\n%s", i+1, name, strings.Join(details, ", "), b.folderShareURL(share)))
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: fmt.Sprintf("Revoke F%d. %s/", i+1, name), CallbackData: fmt.Sprintf("unsharedir:%d", share.ID)}})
+	}
+	return strings.Join(lines, "\n"), &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+// shareWebURL returns the HTTP download link of a share, or "" when the
+// server has no public URL or the share is for one Telegram user, who
+// opens it in the bot.
+func (b *Bot) shareWebURL(share db.Share, name string) string {
+	if !b.cfg.WebDAVEnable || b.cfg.WebDAVPublicURL == "" || share.Restricted() {
+		return ""
+	}
+	return strings.TrimSuffix(b.cfg.WebDAVPublicURL, "/") + gateway.SharePath(share, db.File{Name: name})
+}
+
+// askSharePassword asks for the password of a share's HTTP download.
+func (b *Bot) askSharePassword(ctx context.Context, userID, chatID int64, shareID int64) {
+	_ = b.store.SetPendingAction(ctx, userID, "share_password", shareID, "")
+	b.sendText(ctx, chatID, "Send the password the web link should ask for, or - to remove it. Telegram links are not affected.")
+}
+
+// handleSharePasswordText sets or removes the pending share's password.
+func (b *Bot) handleSharePasswordText(ctx context.Context, userID, chatID int64, state db.UserState, text string) {
+	password := strings.TrimSpace(text)
+	if password == "" {
+		b.sendText(ctx, chatID, "Password cannot be empty; send - to remove it.")
+		return
+	}
+	if password == "-" {
+		password = ""
+	}
+	_ = b.store.ClearPendingAction(ctx, userID)
+	if err := b.store.SetSharePassword(ctx, userID, state.PendingTarget.Int64, password); err != nil {
+		if err == sql.ErrNoRows {
+			b.sendText(ctx, chatID, "Share not found.")
+			return
+		}
+		b.sendText(ctx, chatID, fmt.Sprintf("Set password failed: %v", err))
+		return
+	}
+	if password == "" {
+		b.sendText(ctx, chatID, "Password removed. Anyone with the web link can download the file.")
+		return
+	}
+	b.sendText(ctx, chatID, "Password set. You can delete your message containing it.")
+}
+
+// revokeShare deletes one of the user's share links and refreshes the list.
+// A link that is already gone just refreshes it.
+func (b *Bot) revokeShare(ctx context.Context, userID, chatID int64, msgID int, shareID int64) {
+	if err := b.store.RevokeShare(ctx, userID, shareID); err != nil && err != sql.ErrNoRows {
+		b.sendText(ctx, chatID, fmt.Sprintf("Revoke failed: %v", err))
+		return
+	}
+	body, markup, err := b.sharesView(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load shares: %v", err))
+		return
+	}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, body, markup)
+}
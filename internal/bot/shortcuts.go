@@ -0,0 +1,247 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/gateway"
+	"pigpak/internal/telegram"
+)
+
+const shortcutsUsage = `Usage:
+/shortcut <path> [as <name>] to add a shortcut here to a file or folder of the current drive, e.g. /shortcut /Work/Reports
+/shortcut <share link or page link> [as <name>] to add one to a file or published folder someone shared with you
+/shortcuts to list and remove the shortcuts in the current folder`
+
+func (b *Bot) handleShortcutCommand(ctx context.Context, user *telegram.User, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.Split(fields[0], "@")[0] {
+	case "/shortcuts":
+		body, markup, err := b.shortcutsView(ctx, user.ID)
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Failed to load shortcuts: %v", err))
+			return true
+		}
+		_, _ = b.tg.SendMessage(ctx, chatID, body, markup)
+	case "/shortcut":
+		arg := strings.TrimSpace(strings.TrimPrefix(text, fields[0]))
+		if arg == "" {
+			b.sendText(ctx, chatID, shortcutsUsage)
+			return true
+		}
+		b.addShortcut(ctx, user, chatID, arg)
+	default:
+		return false
+	}
+	return true
+}
+
+// addShortcut adds a shortcut to the current folder for arg, a path in the
+// current drive or a share or page link, optionally ending in "as <name>".
+func (b *Bot) addShortcut(ctx context.Context, user *telegram.User, chatID int64, arg string) {
+	userID := user.ID
+	name := ""
+	if i := strings.LastIndex(arg, " as "); i >= 0 {
+		arg, name = strings.TrimSpace(arg[:i]), strings.TrimSpace(arg[i+len(" as "):])
+	}
+	currentDir, err := b.store.GetCurrentDirID(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Add shortcut failed: %v", err))
+		return
+	}
+	var target db.ShortcutTarget
+	if token, ok := shareTokenFromLink(arg); ok {
+		share, file, err := b.store.GetShareByToken(ctx, token)
+		if err != nil {
+			b.sendText(ctx, chatID, "Share not found.")
+			return
+		}
+		if db.ValidateShare(share) != nil {
+			b.sendText(ctx, chatID, "Share link expired.")
+			return
+		}
+		if !b.canOpenShare(ctx, share, user) {
+			b.sendText(ctx, chatID, "This share is for another Telegram user.")
+			return
+		}
+		target.ShareToken = token
+		if name == "" {
+			name = file.Name
+		}
+	} else if token, ok := pageTokenFromLink(arg); ok {
+		page, err := b.store.GetPageByToken(ctx, token)
+		if err != nil {
+			b.sendText(ctx, chatID, "Published folder not found.")
+			return
+		}
+		target.PageToken = token
+		if name == "" {
+			if dir, err := b.store.GetDirByID(ctx, page.UserID, page.DirID); err == nil {
+				name = dir.Name
+			}
+		}
+	} else {
+		drive, err := b.store.DriveForDir(ctx, userID, currentDir)
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Add shortcut failed: %v", err))
+			return
+		}
+		clean := path.Clean("/" + arg)
+		parts := strings.Split(strings.TrimPrefix(clean, "/"), "/")
+		if clean == "/" {
+			b.sendText(ctx, chatID, "A shortcut needs a file or folder below the drive root.")
+			return
+		}
+		if dir, err := b.store.FindDirByPathFrom(ctx, userID, drive.RootDirID, parts); err == nil {
+			target.DirID = dir.ID
+		} else if file, err := b.store.FindFileByPathFrom(ctx, userID, drive.RootDirID, parts); err == nil {
+			target.FileID = file.ID
+		} else {
+			b.sendText(ctx, chatID, fmt.Sprintf("Nothing at %s.", clean))
+			return
+		}
+		if name == "" {
+			name = path.Base(clean)
+		}
+	}
+	sc, err := b.store.CreateShortcut(ctx, userID, currentDir, name, target)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Add shortcut failed: %v", err))
+		return
+	}
+	b.sendText(ctx, chatID, fmt.Sprintf("Added shortcut %s.", sc.Name))
+	b.sendDirectoryView(ctx, userID, chatID, currentDir, 0)
+}
+
+// shareTokenFromLink reads the token of a share deep link, or of a bare
+// share_<token> payload.
+func shareTokenFromLink(link string) (string, bool) {
+	if u, err := url.Parse(link); err == nil && u.Scheme != "" {
+		link = u.Query().Get("start")
+	}
+	token, ok := strings.CutPrefix(link, "share_")
+	return token, ok && token != ""
+}
+
+// pageTokenFromLink reads the token of a published page URL.
+func pageTokenFromLink(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme == "" {
+		return "", false
+	}
+	token, ok := strings.CutPrefix(u.Path, gateway.PagePath(""))
+	token = strings.Trim(token, "/")
+	return token, ok && token != "" && !strings.Contains(token, "/")
+}
+
+// openShortcut follows a shortcut tapped in a folder listing.
+func (b *Bot) openShortcut(ctx context.Context, user *telegram.User, chatID int64, msgID int, shortcutID int64) {
+	sc, err := b.store.GetShortcut(ctx, user.ID, shortcutID)
+	if err != nil {
+		b.sendText(ctx, chatID, "Shortcut not found.")
+		return
+	}
+	res, err := b.store.ResolveShortcut(ctx, sc)
+	if err != nil {
+		if errors.Is(err, db.ErrShortcutBroken) {
+			b.sendText(ctx, chatID, fmt.Sprintf("The share behind %s expired. Remove it with /shortcuts.", sc.Name))
+			return
+		}
+		b.sendText(ctx, chatID, fmt.Sprintf("Open shortcut failed: %v", err))
+		return
+	}
+	switch {
+	case res.Share != nil:
+		b.handleSharePreview(ctx, user, chatID, res.Share.Token)
+	case res.Page != nil:
+		if b.cfg.WebDAVPublicURL == "" {
+			b.sendText(ctx, chatID, fmt.Sprintf("%s is a published folder, but WEB_DAV_PUBLIC_URL is not set to link to it.", sc.Name))
+			return
+		}
+		link := strings.TrimSuffix(b.cfg.WebDAVPublicURL, "/") + gateway.PagePath(res.Page.Token)
+		_, _ = b.tg.SendFormattedMessage(ctx, chatID, fmt.Sprintf("Shared folder: %s\n%s", telegram.Bold(res.Dir.Name), linkHTML(link)), telegram.ParseModeHTML, nil)
+	case res.Dir != nil:
+		_ = b.store.SetCurrentDir(ctx, user.ID, res.Dir.ID)
+		b.editDirectoryView(ctx, user.ID, chatID, msgID, res.Dir.ID, 0)
+	default:
+		b.editFileDetail(ctx, user.ID, chatID, msgID, *res.File, "")
+	}
+}
+
+func (b *Bot) shortcutsView(ctx context.Context, userID int64) (string, *telegram.InlineKeyboardMarkup, error) {
+	currentDir, err := b.store.GetCurrentDirID(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	shortcuts, err := b.store.ListShortcuts(ctx, userID, currentDir)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(shortcuts) == 0 {
+		return "No shortcuts in this folder.\n\n" + shortcutsUsage, nil, nil
+	}
+	lines := []string{"Shortcuts in this folder:"}
+	var rows [][]telegram.InlineKeyboardButton
+	for _, sc := range shortcuts {
+		lines = append(lines, fmt.Sprintf("%s → %s", sc.Name, b.shortcutTargetText(ctx, sc)))
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: "Remove " + sc.Name, CallbackData: fmt.Sprintf("unsc:%d", sc.ID)}})
+	}
+	return strings.Join(lines, "\n"), &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+// shortcutTargetText describes where a shortcut leads.
+func (b *Bot) shortcutTargetText(ctx context.Context, sc db.Shortcut) string {
+	res, err := b.store.ResolveShortcut(ctx, sc)
+	switch {
+	case err != nil:
+		return "unavailable"
+	case res.Share != nil:
+		return fmt.Sprintf("shared file %s", res.File.Name)
+	case res.Page != nil:
+		return fmt.Sprintf("published folder %s", res.Dir.Name)
+	case res.Dir != nil:
+		if p, err := b.store.GetDirPath(ctx, sc.UserID, res.Dir.ID); err == nil {
+			return p
+		}
+		return res.Dir.Name
+	default:
+		if p, err := b.store.GetDirPath(ctx, sc.UserID, res.File.DirID); err == nil {
+			return path.Join(p, res.File.Name)
+		}
+		return res.File.Name
+	}
+}
+
+// removeShortcut removes a shortcut from the list view and refreshes it.
+func (b *Bot) removeShortcut(ctx context.Context, userID, chatID int64, msgID int, shortcutID int64) {
+	if err := b.store.DeleteShortcut(ctx, userID, shortcutID); err != nil && err != sql.ErrNoRows {
+		b.sendText(ctx, chatID, fmt.Sprintf("Remove failed: %v", err))
+		return
+	}
+	body, markup, err := b.shortcutsView(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load shortcuts: %v", err))
+		return
+	}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, body, markup)
+}
+
+func buildShortcutEntries(shortcuts []db.Shortcut) []entry {
+	var entries []entry
+	for _, sc := range shortcuts {
+		entries = append(entries, entry{
+			Label:    "[LINK] " + sc.Name,
+			Callback: fmt.Sprintf("sc:%d", sc.ID),
+		})
+	}
+	return entries
+}
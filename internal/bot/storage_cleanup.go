@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"pigpak/internal/telegram"
+)
+
+const (
+	// storageSweepInterval spaces out passes over queued storage deletions.
+	storageSweepInterval = time.Minute
+	// maxStorageDeletionAttempts bounds how often a rejected deletion is retried.
+	maxStorageDeletionAttempts = 5
+)
+
+// runStorageSweeper sweeps queued storage deletions every
+// storageSweepInterval until ctx is done. It runs beside polling, as a pass
+// makes up to a hundred Telegram calls back to back.
+func (b *Bot) runStorageSweeper(ctx context.Context) {
+	ticker := time.NewTicker(storageSweepInterval)
+	defer ticker.Stop()
+	for {
+		b.sweepStorageDeletions(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepStorageDeletions deletes storage chat messages whose content is no
+// longer referenced, stopping early while Telegram is unreachable or
+// rate limiting.
+func (b *Bot) sweepStorageDeletions(ctx context.Context) {
+	pending, err := b.store.ListStorageDeletions(ctx, 100)
+	if err != nil {
+		log.Printf("list storage deletions: %v", err)
+		return
+	}
+	for _, d := range pending {
+		err := b.tg.DeleteMessage(ctx, d.ChatID, d.MessageID)
+		if err != nil && telegram.IsTransient(err) {
+			_ = b.store.MarkStorageDeletionAttempt(ctx, d.ID, telegram.RedactError(err))
+			return
+		}
+		if err != nil && !telegram.IsMessageGone(err) && d.Attempts+1 < maxStorageDeletionAttempts {
+			_ = b.store.MarkStorageDeletionAttempt(ctx, d.ID, telegram.RedactError(err))
+			continue
+		}
+		if err != nil && !telegram.IsMessageGone(err) {
+			log.Printf("drop storage deletion of message %d in %d: %v", d.MessageID, d.ChatID, err)
+		}
+		if err := b.store.DeleteStorageDeletion(ctx, d.ID); err != nil {
+			log.Printf("delete storage deletion %d: %v", d.ID, err)
+			return
+		}
+	}
+}
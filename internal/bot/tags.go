@@ -0,0 +1,192 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// maxTagResults caps the files listed for one tag.
+const maxTagResults = 30
+
+func (b *Bot) handleTagCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/tag" {
+		return false
+	}
+	var (
+		view   string
+		markup *telegram.InlineKeyboardMarkup
+		err    error
+	)
+	if len(fields) > 1 {
+		view, markup, err = b.tagSearchView(ctx, userID, fields[1])
+	} else {
+		view, markup, err = b.tagListView(ctx, userID)
+	}
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Tag search failed: %v", err))
+		return true
+	}
+	_, _ = b.tg.SendFormattedMessage(ctx, chatID, view, telegram.ParseModeHTML, markup)
+	return true
+}
+
+func (b *Bot) handleTagCallback(ctx context.Context, userID, chatID int64, msgID int, data string) {
+	switch {
+	case strings.HasPrefix(data, "tags:"):
+		b.editFileTagsView(ctx, userID, chatID, msgID, parseInt64(strings.TrimPrefix(data, "tags:")))
+	case strings.HasPrefix(data, "tagadd:"):
+		fileID := parseInt64(strings.TrimPrefix(data, "tagadd:"))
+		if _, err := b.store.GetFileByID(ctx, userID, fileID); err != nil {
+			b.sendText(ctx, chatID, "File not found.")
+			return
+		}
+		_ = b.store.SetPendingAction(ctx, userID, "tag_file", fileID, "")
+		b.sendText(ctx, chatID, "Send one or more tags separated by spaces, e.g. #invoices 2024.")
+	case strings.HasPrefix(data, "untag:"):
+		parts := strings.Split(data, ":")
+		if len(parts) != 3 {
+			return
+		}
+		fileID := parseInt64(parts[1])
+		if err := b.store.UntagFile(ctx, userID, fileID, parseInt64(parts[2])); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Remove tag failed: %v", err))
+			return
+		}
+		b.editFileTagsView(ctx, userID, chatID, msgID, fileID)
+	case strings.HasPrefix(data, "tagq:"):
+		text, markup, err := b.tagSearchView(ctx, userID, strings.TrimPrefix(data, "tagq:"))
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Tag search failed: %v", err))
+			return
+		}
+		_, _ = b.tg.EditFormattedMessageText(ctx, chatID, msgID, text, telegram.ParseModeHTML, markup)
+	}
+}
+
+// handleTagText applies the tags sent for a pending "tag_file" action.
+func (b *Bot) handleTagText(ctx context.Context, userID, chatID int64, state db.UserState, text string) {
+	fileID := state.PendingTarget.Int64
+	var failed []string
+	for _, name := range strings.FieldsFunc(text, func(r rune) bool { return r == ' ' || r == ',' || r == '\n' }) {
+		if _, err := b.store.TagFile(ctx, userID, fileID, name); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	_ = b.store.ClearPendingAction(ctx, userID)
+	if len(failed) > 0 {
+		b.sendText(ctx, chatID, "Some tags were not added:\n"+strings.Join(failed, "\n"))
+	}
+	text, markup, err := b.fileTagsView(ctx, userID, fileID)
+	if err != nil {
+		b.sendText(ctx, chatID, "File not found.")
+		return
+	}
+	_, _ = b.tg.SendFormattedMessage(ctx, chatID, text, telegram.ParseModeHTML, markup)
+}
+
+func (b *Bot) fileTagsView(ctx context.Context, userID, fileID int64) (string, *telegram.InlineKeyboardMarkup, error) {
+	file, err := b.store.GetFileByID(ctx, userID, fileID)
+	if err != nil {
+		return "", nil, err
+	}
+	tags, err := b.store.FileTags(ctx, userID, fileID)
+	if err != nil {
+		return "", nil, err
+	}
+	text := "Tags for " + telegram.Bold(file.Name)
+	if len(tags) == 0 {
+		text += "\nNo tags yet."
+	} else {
+		text += "\n" + tagsHTML(tags)
+	}
+	var rows [][]telegram.InlineKeyboardButton
+	var row []telegram.InlineKeyboardButton
+	for _, t := range tags {
+		row = append(row, telegram.InlineKeyboardButton{Text: "✕ #" + t.Name, CallbackData: fmt.Sprintf("untag:%d:%d", file.ID, t.ID)})
+		if len(row) == 3 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, []telegram.InlineKeyboardButton{
+		{Text: "Add tags", CallbackData: fmt.Sprintf("tagadd:%d", file.ID)},
+		{Text: "Back", CallbackData: fmt.Sprintf("file:%d", file.ID)},
+	})
+	return text, &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+func (b *Bot) editFileTagsView(ctx context.Context, userID, chatID int64, msgID int, fileID int64) {
+	text, markup, err := b.fileTagsView(ctx, userID, fileID)
+	if err != nil {
+		b.sendText(ctx, chatID, "File not found.")
+		return
+	}
+	_, _ = b.tg.EditFormattedMessageText(ctx, chatID, msgID, text, telegram.ParseModeHTML, markup)
+}
+
+func (b *Bot) tagListView(ctx context.Context, userID int64) (string, *telegram.InlineKeyboardMarkup, error) {
+	tags, err := b.store.ListTags(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(tags) == 0 {
+		return "No tags yet. Open a file and use its Tags button.", nil, nil
+	}
+	var rows [][]telegram.InlineKeyboardButton
+	for _, t := range tags {
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: fmt.Sprintf("#%s (%d)", t.Name, t.Files), CallbackData: "tagq:" + t.Name}})
+	}
+	return telegram.Bold("Tags"), &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+func (b *Bot) tagSearchView(ctx context.Context, userID int64, name string) (string, *telegram.InlineKeyboardMarkup, error) {
+	files, err := b.store.FilesWithTag(ctx, userID, name)
+	if err != nil {
+		return "", nil, err
+	}
+	name, _ = db.NormalizeTag(name)
+	if len(files) == 0 {
+		return "No files tagged " + telegram.Code("#"+name) + ".", nil, nil
+	}
+	lines := []string{fmt.Sprintf("Files tagged %s: %d", telegram.Code("#"+name), len(files))}
+	var rows [][]telegram.InlineKeyboardButton
+	for i, f := range files {
+		if i == maxTagResults {
+			lines = append(lines, fmt.Sprintf("… and %d more", len(files)-maxTagResults))
+			break
+		}
+		dirPath, err := b.store.GetDirPath(ctx, userID, f.DirID)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, telegram.EscapeHTML(strings.TrimSuffix(dirPath, "/")+"/"+f.Name))
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: "[FILE] " + f.Name, CallbackData: fmt.Sprintf("file:%d", f.ID)}})
+	}
+	return strings.Join(lines, "\n"), &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+// fileTagsLine renders a file's tags for its detail view, or "" when it has
+// none.
+func (b *Bot) fileTagsLine(ctx context.Context, userID, fileID int64) string {
+	tags, err := b.store.FileTags(ctx, userID, fileID)
+	if err != nil || len(tags) == 0 {
+		return ""
+	}
+	return "\nTags: " + tagsHTML(tags)
+}
+
+func tagsHTML(tags []db.Tag) string {
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = telegram.Code("#" + t.Name)
+	}
+	return strings.Join(names, " ")
+}
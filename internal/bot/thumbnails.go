@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/media"
+	"pigpak/internal/telegram"
+)
+
+// thumbnailFileID returns the file id of a Telegram thumbnail, or "".
+func thumbnailFileID(thumb *telegram.PhotoSize) string {
+	if thumb == nil {
+		return ""
+	}
+	return thumb.FileID
+}
+
+// previewPhotoSize picks the largest size of a photo that still fits a
+// thumbnail, or the smallest one when none does.
+func previewPhotoSize(sizes []telegram.PhotoSize) telegram.PhotoSize {
+	best := sizes[0]
+	for _, s := range sizes {
+		fits := max(s.Width, s.Height) <= media.ThumbnailEdge
+		bestFits := max(best.Width, best.Height) <= media.ThumbnailEdge
+		switch {
+		case fits && (!bestFits || s.Width*s.Height > best.Width*best.Height):
+			best = s
+		case !fits && !bestFits && s.Width*s.Height < best.Width*best.Height:
+			best = s
+		}
+	}
+	return best
+}
+
+// probeThumbnail sets the preview of an upload: the one Telegram made when
+// there is one, else a scaled-down copy of a picture head holds whole.
+func (b *Bot) probeThumbnail(ctx context.Context, in *incomingFile, head []byte) {
+	if in.ThumbFileID != "" {
+		thumb, err := b.readFileHead(ctx, in.ThumbFileID)
+		if err == nil && len(thumb) > 0 && len(thumb) <= db.MaxThumbnailBytes {
+			in.Thumbnail = thumb
+			return
+		}
+		if err != nil {
+			log.Printf("thumbnail of %s: %v", in.Name, err)
+		}
+	}
+	if head == nil || int64(len(head)) != in.Size || !strings.HasPrefix(in.MimeType, "image/") {
+		return
+	}
+	if thumb, err := media.ImageThumbnail(head); err == nil {
+		in.Thumbnail = thumb
+	}
+}
+
+// recordThumbnail stores the preview found for a saved upload.
+func (b *Bot) recordThumbnail(ctx context.Context, userID, fileID int64, in *incomingFile) {
+	if len(in.Thumbnail) == 0 {
+		return
+	}
+	if err := b.store.SetThumbnail(ctx, userID, fileID, in.Thumbnail); err != nil {
+		log.Printf("record thumbnail of file %d: %v", fileID, err)
+	}
+}
+
+// appendPreviewRow offers the thumbnail of a file that has one.
+func (b *Bot) appendPreviewRow(ctx context.Context, userID int64, file db.File, markup *telegram.InlineKeyboardMarkup) {
+	if markup == nil {
+		return
+	}
+	if ok, err := b.store.HasThumbnail(ctx, userID, file.ID); err != nil || !ok {
+		return
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, []telegram.InlineKeyboardButton{{Text: "Preview", CallbackData: fmt.Sprintf("preview:%d", file.ID)}})
+}
+
+// sendWithPreview sends text as the caption of file's thumbnail, or as a
+// plain message when the file has none.
+func (b *Bot) sendWithPreview(ctx context.Context, chatID int64, file db.File, text string, markup *telegram.InlineKeyboardMarkup) {
+	if thumb, err := b.store.GetThumbnail(ctx, file.UserID, file.ID); err == nil {
+		if _, err := b.tg.UploadPhoto(ctx, chatID, "preview.jpg", bytes.NewReader(thumb), text, markup); err == nil {
+			return
+		}
+	}
+	_, _ = b.tg.SendMessage(ctx, chatID, text, markup)
+}
+
+// sendPreview posts a file's thumbnail as a photo captioned with its name.
+func (b *Bot) sendPreview(ctx context.Context, userID, chatID, fileID int64) {
+	file, err := b.store.GetFileByID(ctx, userID, fileID)
+	if err != nil {
+		b.sendText(ctx, chatID, "File not found.")
+		return
+	}
+	thumb, err := b.store.GetThumbnail(ctx, userID, file.ID)
+	if err != nil {
+		b.sendText(ctx, chatID, "This file has no preview.")
+		return
+	}
+	if _, err := b.tg.UploadPhoto(ctx, chatID, "preview.jpg", bytes.NewReader(thumb), file.Name, nil); err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Send preview failed: %v", err))
+	}
+}
@@ -0,0 +1,321 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// transferProgressInterval throttles progress edits during re-uploads.
+const transferProgressInterval = 3 * time.Second
+
+// storageChatFor returns the chat that backs content stored in a drive: the
+// drive's own, then its owner's from /storage, then the server's.
+func (b *Bot) storageChatFor(ctx context.Context, drive db.Drive) int64 {
+	if drive.StorageChatID != 0 {
+		return drive.StorageChatID
+	}
+	if us, err := b.store.GetUserStorage(ctx, drive.UserID); err == nil {
+		return us.ChatID
+	}
+	return b.cfg.StorageChatID
+}
+
+// needsReupload reports whether moving content from src to dst has to copy
+// the bytes into dst's storage chat. Drives without a storage chat of their
+// own accept any file_id, so only a distinct destination chat forces a copy.
+func (b *Bot) needsReupload(ctx context.Context, src, dst db.Drive) bool {
+	dstChat := b.storageChatFor(ctx, dst)
+	return dstChat != 0 && dstChat != b.storageChatFor(ctx, src)
+}
+
+// transferFile moves or copies a file into dirID. Within one storage chat
+// only metadata changes; across storage chats the content is re-uploaded in
+// the background and progress is reported in a status message.
+func (b *Bot) transferFile(ctx context.Context, userID, chatID int64, fileID, dirID int64, move bool) error {
+	file, err := b.store.GetFileByID(ctx, userID, fileID)
+	if err != nil {
+		return err
+	}
+	src, err := b.store.DriveForDir(ctx, userID, file.DirID)
+	if err != nil {
+		return err
+	}
+	dst, err := b.store.DriveForDir(ctx, userID, dirID)
+	if err != nil {
+		return err
+	}
+	if !b.needsReupload(ctx, src, dst) {
+		if move {
+			return b.store.MoveFile(ctx, userID, fileID, dirID)
+		}
+		return b.saveSharedFile(ctx, userID, dirID, file)
+	}
+	if err := b.store.CheckNameAvailable(ctx, userID, dirID, file.Name); err != nil {
+		return err
+	}
+	go func() {
+		if err := b.reuploadFile(ctx, userID, chatID, file, dirID, b.storageChatFor(ctx, dst), dst.Label); err != nil {
+			log.Printf("copy file %d to drive %d: %v", file.ID, dst.ID, err)
+			return
+		}
+		if move {
+			if err := b.store.DeleteFile(ctx, userID, fileID); err != nil {
+				b.sendText(ctx, chatID, fmt.Sprintf("Copied %s but removing the original failed: %v", file.Name, err))
+			}
+		}
+	}()
+	return nil
+}
+
+// checkDirTransfer rejects folder moves that would need a re-upload; only
+// single files are copied between storage chats.
+func (b *Bot) checkDirTransfer(ctx context.Context, userID, dirID, newParentID int64) error {
+	src, err := b.store.DriveForDir(ctx, userID, dirID)
+	if err != nil {
+		return err
+	}
+	dst, err := b.store.DriveForDir(ctx, userID, newParentID)
+	if err != nil {
+		return err
+	}
+	if b.needsReupload(ctx, src, dst) {
+		return fmt.Errorf("%s uses a different storage chat; move the files individually", dst.Label)
+	}
+	return nil
+}
+
+// reuploadFile copies every blob of file into storageChatID and records the
+// copy in dirID.
+func (b *Bot) reuploadFile(ctx context.Context, userID, chatID int64, file db.File, dirID, storageChatID int64, driveLabel string) error {
+	if file.IsEmpty() {
+		// There is no content to copy.
+		_, err := b.store.CreateFile(ctx, userID, dirID, file.Name, "", "", 0, file.MimeType)
+		return err
+	}
+	parts, err := b.store.ListFileParts(ctx, file.ID)
+	if err != nil {
+		return err
+	}
+	type blob struct {
+		fileID string
+		size   int64
+		name   string
+		sha256 string
+	}
+	var blobs []blob
+	if len(parts) == 0 {
+		blobs = append(blobs, blob{fileID: file.FileID, size: file.Size, name: file.Name, sha256: file.SHA256})
+	} else {
+		for _, part := range parts {
+			blobs = append(blobs, blob{fileID: part.TelegramFileID, size: part.Size, name: fmt.Sprintf("%s.part%03d", file.Name, part.PartIndex+1), sha256: part.SHA256})
+		}
+	}
+	var total int64
+	for _, bl := range blobs {
+		if limit := b.downloadLimit(); limit > 0 && bl.size > limit {
+			return fmt.Errorf("%s has pieces over %s; copying between storage chats needs a local Bot API server", file.Name, formatBytes(limit))
+		}
+		total += bl.size
+	}
+
+	progress := &transferProgress{bot: b, chatID: chatID, name: file.Name, drive: driveLabel, total: total, parts: len(blobs)}
+	progress.start(ctx)
+	defer progress.stop()
+	stopAction := b.showChatAction(ctx, chatID, telegram.ChatActionUploadDocument)
+	defer stopAction()
+
+	inputs := make([]db.FilePartInput, 0, len(blobs))
+	for i, bl := range blobs {
+		progress.part.Store(int64(i + 1))
+		info, err := b.tg.GetFile(ctx, bl.fileID)
+		if err != nil {
+			progress.fail(ctx, err)
+			return err
+		}
+		body, err := b.tg.DownloadFile(ctx, info.FilePath, 0)
+		if err != nil {
+			progress.fail(ctx, err)
+			return err
+		}
+		msg, err := b.tg.UploadDocument(ctx, storageChatID, bl.name, &countingReader{r: body, n: &progress.done})
+		body.Close()
+		if err != nil {
+			progress.fail(ctx, err)
+			return err
+		}
+		if msg == nil || msg.Document == nil {
+			err := errors.New("storage chat upload returned no document")
+			progress.fail(ctx, err)
+			return err
+		}
+		inputs = append(inputs, db.FilePartInput{
+			PartIndex:        i,
+			TelegramFileID:   msg.Document.FileID,
+			FileUniqueID:     msg.Document.FileUniqueID,
+			Size:             bl.size,
+			StorageChatID:    msg.Chat.ID,
+			StorageMessageID: msg.MessageID,
+			SHA256:           bl.sha256,
+		})
+	}
+	first := inputs[0]
+	var created db.File
+	if len(parts) == 0 {
+		created, err = b.store.CreateFileWithParts(ctx, userID, dirID, file.Name, first.TelegramFileID, first.FileUniqueID, file.Size, file.MimeType, inputs)
+	} else {
+		created, err = b.store.CreateFileWithParts(ctx, userID, dirID, file.Name, first.TelegramFileID, first.FileUniqueID, total, file.MimeType, inputs)
+	}
+	if err != nil {
+		progress.fail(ctx, err)
+		return err
+	}
+	b.copyChecksum(ctx, userID, created, file)
+	progress.finish(ctx)
+	return nil
+}
+
+// copyToStorage makes sure the content of part is held in storageChatID.
+// The message pigpak stored it in is copied when known. Otherwise the
+// file_id is sent again, which also yields a fresh file_id for this bot.
+// The new message is recorded in part and claimed when the file is created.
+func (b *Bot) copyToStorage(ctx context.Context, storageChatID int64, part *db.FilePartInput) error {
+	held, err := b.store.BlobMessageIn(ctx, part.FileUniqueID, storageChatID)
+	if err != nil || held != 0 {
+		return err
+	}
+	blob, err := b.store.GetBlob(ctx, part.FileUniqueID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if blob.StorageMessageID != 0 {
+		msgID, err := b.tg.CopyMessage(ctx, storageChatID, blob.StorageChatID, blob.StorageMessageID)
+		if err == nil {
+			part.StorageChatID, part.StorageMessageID = storageChatID, msgID
+			return nil
+		}
+		log.Printf("copy storage message %d/%d: %v", blob.StorageChatID, blob.StorageMessageID, err)
+	}
+	msg, err := b.tg.SendDocument(ctx, storageChatID, part.TelegramFileID, "", nil)
+	if err != nil {
+		return err
+	}
+	if msg.Document == nil {
+		_ = b.tg.DeleteMessage(ctx, storageChatID, msg.MessageID)
+		return errors.New("storage chat copy returned no document")
+	}
+	part.TelegramFileID = msg.Document.FileID
+	part.StorageChatID, part.StorageMessageID = storageChatID, msg.MessageID
+	return nil
+}
+
+// dropStorageCopies deletes messages made by copyToStorage for a file that
+// was not created after all.
+func (b *Bot) dropStorageCopies(ctx context.Context, parts []db.FilePartInput) {
+	for _, part := range parts {
+		if part.StorageMessageID == 0 {
+			continue
+		}
+		if err := b.tg.DeleteMessage(ctx, part.StorageChatID, part.StorageMessageID); err != nil {
+			log.Printf("delete storage copy %d/%d: %v", part.StorageChatID, part.StorageMessageID, err)
+		}
+	}
+}
+
+// transferProgress keeps a status message up to date while bytes move
+// between storage chats.
+type transferProgress struct {
+	bot    *Bot
+	chatID int64
+	msgID  int
+	name   string
+	drive  string
+	total  int64
+	parts  int
+	done   atomic.Int64
+	part   atomic.Int64
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func (p *transferProgress) start(ctx context.Context) {
+	msg, err := p.bot.tg.SendMessage(ctx, p.chatID, p.text("Preparing"), nil)
+	if err == nil && msg != nil {
+		p.msgID = msg.MessageID
+	}
+	p.stopCh = make(chan struct{})
+	p.doneCh = make(chan struct{})
+	go func() {
+		defer close(p.doneCh)
+		ticker := time.NewTicker(transferProgressInterval)
+		defer ticker.Stop()
+		last := int64(-1)
+		for {
+			select {
+			case <-p.stopCh:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if done := p.done.Load(); done != last {
+					last = done
+					p.edit(ctx, p.text("Copying"))
+				}
+			}
+		}
+	}()
+}
+
+func (p *transferProgress) stop() {
+	select {
+	case <-p.stopCh:
+	default:
+		close(p.stopCh)
+	}
+	<-p.doneCh
+}
+
+func (p *transferProgress) finish(ctx context.Context) {
+	p.stop()
+	p.edit(ctx, fmt.Sprintf("Copied %s to %s (%s).", p.name, p.drive, formatBytes(p.total)))
+}
+
+func (p *transferProgress) fail(ctx context.Context, err error) {
+	p.stop()
+	p.edit(ctx, fmt.Sprintf("Copy of %s to %s failed: %v", p.name, p.drive, err))
+}
+
+func (p *transferProgress) edit(ctx context.Context, text string) {
+	if p.msgID == 0 {
+		return
+	}
+	_, _ = p.bot.tg.EditMessageText(ctx, p.chatID, p.msgID, text, nil)
+}
+
+func (p *transferProgress) text(verb string) string {
+	text := fmt.Sprintf("%s %s to %s: %s / %s", verb, p.name, p.drive, formatBytes(p.done.Load()), formatBytes(p.total))
+	if p.parts > 1 {
+		text += fmt.Sprintf(" (part %d/%d)", p.part.Load(), p.parts)
+	}
+	return text
+}
+
+// countingReader adds every byte read to n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,272 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// maxTrashListItems caps the entries listed in one trash view.
+const maxTrashListItems = 20
+
+const emptyTrashPrompt = "Delete everything in the trash for good? Content pigpak uploaded to a storage chat is removed from it too."
+
+func (b *Bot) handleTrashCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/trash" {
+		return false
+	}
+	if len(fields) == 2 && strings.ToLower(fields[1]) == "empty" {
+		_, _ = b.tg.SendMessage(ctx, chatID, emptyTrashPrompt, emptyTrashConfirmKeyboard())
+		return true
+	}
+	b.sendTrashView(ctx, userID, chatID, strings.Join(fields[1:], " "))
+	return true
+}
+
+func (b *Bot) sendTrashView(ctx context.Context, userID, chatID int64, query string) {
+	text, markup, err := b.trashView(ctx, userID, query)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load trash: %v", err))
+		return
+	}
+	_, _ = b.tg.SendMessage(ctx, chatID, text, markup)
+}
+
+func (b *Bot) editTrashView(ctx context.Context, userID, chatID int64, msgID int) {
+	text, markup, err := b.trashView(ctx, userID, "")
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load trash: %v", err))
+		return
+	}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, markup)
+}
+
+func (b *Bot) trashView(ctx context.Context, userID int64, query string) (string, *telegram.InlineKeyboardMarkup, error) {
+	query = strings.TrimSpace(query)
+	items, err := b.store.ListTrash(ctx, userID, query)
+	if err != nil {
+		return "", nil, err
+	}
+	header := fmt.Sprintf("Trash: %d item(s), newest first", len(items))
+	if query != "" {
+		header = fmt.Sprintf("Trash matching %q: %d item(s), newest first", query, len(items))
+	}
+	lines := []string{header}
+	var rows [][]telegram.InlineKeyboardButton
+	for i, item := range items {
+		if i == maxTrashListItems {
+			lines = append(lines, fmt.Sprintf("…and %d more. Narrow it down with /trash <name>.", len(items)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s — from %s, %s", i+1, trashItemLabel(item), item.OriginalPath, item.DeletedAt.Format("2006-01-02 15:04 MST")))
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: fmt.Sprintf("%d. %s", i+1, trashItemLabel(item)), CallbackData: fmt.Sprintf("trit:%d", item.ID)}})
+	}
+	if len(items) == 0 {
+		if query != "" {
+			lines = append(lines, "Nothing matches.")
+		} else {
+			lines = append(lines, "Empty. Deleted files and folders land here until you purge them.")
+		}
+	}
+	controls := []telegram.InlineKeyboardButton{{Text: "Search", CallbackData: "trsearch"}}
+	if query != "" {
+		controls = append(controls, telegram.InlineKeyboardButton{Text: "Show all", CallbackData: "trash"})
+	}
+	if len(items) > 0 {
+		controls = append(controls, telegram.InlineKeyboardButton{Text: "Empty trash", CallbackData: "trempty"})
+	}
+	rows = append(rows, controls)
+	return strings.Join(lines, "\n"), &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+func trashItemLabel(item db.TrashItem) string {
+	if item.IsDir() {
+		return "[DIR] " + item.Name
+	}
+	return item.Name
+}
+
+func emptyTrashConfirmKeyboard() *telegram.InlineKeyboardMarkup {
+	return &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{{
+		{Text: "Yes, empty trash", CallbackData: "trempty:yes"},
+		{Text: "Cancel", CallbackData: "trash"},
+	}}}
+}
+
+func (b *Bot) editTrashItemView(ctx context.Context, userID, chatID int64, msgID int, itemID int64) {
+	item, err := b.store.GetTrashItem(ctx, userID, itemID)
+	if err != nil {
+		b.sendText(ctx, chatID, "Trash item not found.")
+		return
+	}
+	lines := []string{
+		trashItemLabel(item),
+		"Deleted: " + item.DeletedAt.Format("2006-01-02 15:04 MST"),
+		"Original location: " + item.OriginalPath,
+	}
+	if item.IsDir() {
+		lines = append(lines, fmt.Sprintf("Contents: %d file(s), %s", item.Files, formatBytes(item.Size)))
+	} else {
+		lines = append(lines, "Size: "+formatBytes(item.Size))
+	}
+	if _, err := b.store.GetDirByID(ctx, userID, item.OriginalParentID); err != nil {
+		lines = append(lines, "The original folder is gone; restoring there recreates it.")
+	}
+	markup := &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{
+		{{Text: "Restore to original path", CallbackData: fmt.Sprintf("trres:%d", item.ID)}},
+		{{Text: "Restore to…", CallbackData: fmt.Sprintf("trto:%d", item.ID)}},
+		{{Text: "Delete forever", CallbackData: fmt.Sprintf("trdel:%d", item.ID)}, {Text: "Back", CallbackData: "trash"}},
+	}}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, strings.Join(lines, "\n"), markup)
+}
+
+func (b *Bot) handleTrashCallback(ctx context.Context, userID, chatID int64, msgID int, data string) {
+	switch {
+	case data == "trash":
+		b.editTrashView(ctx, userID, chatID, msgID)
+	case data == "trsearch":
+		_ = b.store.SetPendingAction(ctx, userID, "trash_search", 0, "")
+		b.sendText(ctx, chatID, "Send part of the name to search the trash for.")
+	case data == "trempty":
+		_, _ = b.tg.EditMessageText(ctx, chatID, msgID, emptyTrashPrompt, emptyTrashConfirmKeyboard())
+	case data == "trempty:yes":
+		count, err := b.store.EmptyTrash(ctx, userID)
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Empty trash failed: %v", err))
+			return
+		}
+		_, _ = b.tg.EditMessageText(ctx, chatID, msgID, fmt.Sprintf("Trash emptied: %d item(s) deleted for good.", count), nil)
+	case strings.HasPrefix(data, "trit:"):
+		b.editTrashItemView(ctx, userID, chatID, msgID, parseInt64(strings.TrimPrefix(data, "trit:")))
+	case strings.HasPrefix(data, "trdel:"):
+		if err := b.store.PurgeTrashItem(ctx, userID, parseInt64(strings.TrimPrefix(data, "trdel:"))); err != nil && err != sql.ErrNoRows {
+			b.sendText(ctx, chatID, fmt.Sprintf("Delete failed: %v", err))
+			return
+		}
+		b.editTrashView(ctx, userID, chatID, msgID)
+	case strings.HasPrefix(data, "trres:"):
+		itemID := parseInt64(strings.TrimPrefix(data, "trres:"))
+		item, err := b.store.GetTrashItem(ctx, userID, itemID)
+		if err != nil {
+			b.sendText(ctx, chatID, "Trash item not found.")
+			return
+		}
+		dirID, err := b.originalTrashParent(ctx, userID, item)
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Restore failed: %v", err))
+			return
+		}
+		b.restoreTrashItem(ctx, userID, chatID, msgID, itemID, dirID, "")
+	case strings.HasPrefix(data, "trto:"):
+		itemID := parseInt64(strings.TrimPrefix(data, "trto:"))
+		item, err := b.store.GetTrashItem(ctx, userID, itemID)
+		if err != nil {
+			b.sendText(ctx, chatID, "Trash item not found.")
+			return
+		}
+		_ = b.store.SetPendingAction(ctx, userID, "restore_trash", itemID, "")
+		b.editDirectoryPicker(ctx, userID, chatID, msgID, b.trashRootFor(ctx, userID, item))
+	case strings.HasPrefix(data, "trkeep:"), strings.HasPrefix(data, "trrepl:"):
+		parts := strings.Split(data, ":")
+		if len(parts) != 3 {
+			return
+		}
+		b.restoreTrashItem(ctx, userID, chatID, msgID, parseInt64(parts[1]), parseInt64(parts[2]), parts[0])
+	}
+}
+
+// trashRootFor returns the drive root an item was deleted from, falling back
+// to the primary drive when that drive no longer exists.
+func (b *Bot) trashRootFor(ctx context.Context, userID int64, item db.TrashItem) int64 {
+	if item.OriginalRootID != 0 {
+		if _, err := b.store.GetDirByID(ctx, userID, item.OriginalRootID); err == nil {
+			return item.OriginalRootID
+		}
+	}
+	rootID, _ := b.store.GetRootDirID(ctx, userID)
+	return rootID
+}
+
+// originalTrashParent resolves the folder an item was deleted from,
+// recreating its path when the folder was deleted too.
+func (b *Bot) originalTrashParent(ctx context.Context, userID int64, item db.TrashItem) (int64, error) {
+	if _, err := b.store.GetDirByID(ctx, userID, item.OriginalParentID); err == nil {
+		return item.OriginalParentID, nil
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+	dir, err := b.store.EnsureDirPath(ctx, userID, b.trashRootFor(ctx, userID, item), strings.Split(item.OriginalPath, "/"))
+	if err != nil {
+		return 0, err
+	}
+	return dir.ID, nil
+}
+
+// restoreTrashItem puts an item back into dirID. mode is empty for a first
+// attempt, "trkeep" to restore under a free " (n)" name, or "trrepl" to move
+// the entry currently holding the name into the trash first.
+func (b *Bot) restoreTrashItem(ctx context.Context, userID, chatID int64, msgID int, itemID, dirID int64, mode string) {
+	item, err := b.store.GetTrashItem(ctx, userID, itemID)
+	if err != nil {
+		b.sendText(ctx, chatID, "Trash item not found.")
+		return
+	}
+	name := item.Name
+	switch mode {
+	case "trkeep":
+		if name, err = b.availableFileName(ctx, userID, dirID, item.Name); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Restore failed: %v", err))
+			return
+		}
+	case "trrepl":
+		if err := b.trashByName(ctx, userID, dirID, item.Name); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Restore failed: %v", err))
+			return
+		}
+	}
+	dirPath := b.dirDisplayPath(ctx, userID, dirID)
+	err = b.store.RestoreTrashItem(ctx, userID, itemID, dirID, name)
+	if errors.Is(err, os.ErrExist) {
+		text := fmt.Sprintf("%s already holds something called %s.\nKeep both restores it under a new name. Replace moves the current one to the trash.", dirPath, item.Name)
+		markup := &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{
+			{{Text: "Keep both", CallbackData: fmt.Sprintf("trkeep:%d:%d", itemID, dirID)}, {Text: "Replace", CallbackData: fmt.Sprintf("trrepl:%d:%d", itemID, dirID)}},
+			{{Text: "Cancel", CallbackData: fmt.Sprintf("trit:%d", itemID)}},
+		}}
+		_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, markup)
+		return
+	}
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Restore failed: %v", err))
+		return
+	}
+	markup := &telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{
+		{{Text: "Open folder", CallbackData: fmt.Sprintf("nav:%d:0", dirID)}, {Text: "Trash", CallbackData: "trash"}},
+	}}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, fmt.Sprintf("Restored %s to %s.", name, dirPath), markup)
+}
+
+// trashByName moves whatever entry is called name in dirID into the trash.
+func (b *Bot) trashByName(ctx context.Context, userID, dirID int64, name string) error {
+	if dir, err := b.store.GetDirByName(ctx, userID, dirID, name); err == nil {
+		_, err = b.store.TrashDir(ctx, userID, dir.ID)
+		return err
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	file, err := b.store.GetFileByName(ctx, userID, dirID, name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = b.store.TrashFile(ctx, userID, file.ID)
+	return err
+}
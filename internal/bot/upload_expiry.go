@@ -0,0 +1,24 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// uploadExpiryInterval spaces out passes dropping stale WebDAV uploads.
+const uploadExpiryInterval = time.Hour
+
+// expireUploads drops WebDAV upload sessions idle longer than
+// WEB_DAV_UPLOAD_TTL, and with WEB_DAV_UPLOAD_PURGE queues their storage
+// messages for the deletion sweep.
+func (b *Bot) expireUploads(ctx context.Context) {
+	out, err := b.store.ExpireWebDAVUploads(ctx, b.cfg.WebDAVUploadTTL, b.cfg.WebDAVUploadPurge)
+	if err != nil {
+		log.Printf("expire webdav uploads: %v", err)
+		return
+	}
+	if out.Sessions > 0 {
+		log.Printf("expired %d stale webdav upload(s) with %d part(s), %d storage message(s) queued for deletion", out.Sessions, out.Parts, out.Messages)
+	}
+}
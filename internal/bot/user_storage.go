@@ -0,0 +1,188 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"pigpak/internal/telegram"
+)
+
+const storageUsage = `Usage:
+/storage to see and set up where your WebDAV uploads are stored
+/storage <chat id> to use a private channel or group where the bot is an admin, e.g. /storage -1001234567890
+/storage default to go back to the server's storage chat`
+
+func (b *Bot) handleStorageCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/storage" {
+		return false
+	}
+	switch len(fields) {
+	case 1:
+		b.sendStorageView(ctx, userID, chatID)
+	case 2:
+		b.setUserStorage(ctx, userID, chatID, fields[1])
+	default:
+		b.sendText(ctx, chatID, storageUsage)
+	}
+	return true
+}
+
+func (b *Bot) storageView(ctx context.Context, userID int64) (string, *telegram.InlineKeyboardMarkup) {
+	var text string
+	setLabel := "Set up storage chat"
+	var rows [][]telegram.InlineKeyboardButton
+	us, err := b.store.GetUserStorage(ctx, userID)
+	switch {
+	case err == nil:
+		title := us.Title
+		if title == "" {
+			title = "untitled chat"
+		}
+		text = fmt.Sprintf("Storage chat: %s (%d)\nYour WebDAV uploads, and copies into drives without a storage chat of their own, are stored there.", title, us.ChatID)
+		setLabel = "Change storage chat"
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: "Use server default", CallbackData: "ustoreclr"}})
+	case b.cfg.StorageChatID != 0:
+		text = "Storage chat: server default\nYour WebDAV uploads are stored in the server's storage chat. Set up your own private channel or group to keep them in a chat you control."
+	default:
+		text = "Storage chat: none\nThis server has no storage chat, so WebDAV uploads are disabled until you set up your own private channel or group."
+	}
+	rows = append([][]telegram.InlineKeyboardButton{{{Text: setLabel, CallbackData: "ustore"}}}, rows...)
+	return text, &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+func (b *Bot) sendStorageView(ctx context.Context, userID, chatID int64) {
+	text, markup := b.storageView(ctx, userID)
+	_, _ = b.tg.SendMessage(ctx, chatID, text, markup)
+}
+
+// handleStorageCallback serves the /storage menu:
+//
+//	ustore    start the guided setup, asking for the chat ID
+//	ustoreclr go back to the server's storage chat
+func (b *Bot) handleStorageCallback(ctx context.Context, userID, chatID int64, msgID int, data string) {
+	switch data {
+	case "ustore":
+		_ = b.store.SetPendingAction(ctx, userID, "user_storage", 0, "")
+		bot := "the bot"
+		if b.botUsername != "" {
+			bot = "@" + b.botUsername
+		}
+		b.sendText(ctx, chatID, fmt.Sprintf("Set up your storage chat:\n1. Create a private channel or group in Telegram.\n2. Add %s to it as an admin allowed to post and delete messages.\n3. Send the chat's ID here, e.g. -1001234567890, or \"cancel\" to stop.\n\nThe bot checks its rights there with a test message it deletes right away.", bot))
+	case "ustoreclr":
+		if err := b.store.ClearUserStorage(ctx, userID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			b.sendText(ctx, chatID, fmt.Sprintf("Reset storage chat failed: %v", err))
+			return
+		}
+		text, markup := b.storageView(ctx, userID)
+		_, _ = b.tg.EditMessageText(ctx, chatID, msgID, text, markup)
+	}
+}
+
+// handleStorageText handles the chat ID sent during the guided setup.
+func (b *Bot) handleStorageText(ctx context.Context, userID, chatID int64, text string) {
+	value := strings.TrimSpace(text)
+	if strings.EqualFold(value, "cancel") {
+		_ = b.store.ClearPendingAction(ctx, userID)
+		b.sendText(ctx, chatID, "Storage chat unchanged.")
+		return
+	}
+	if b.setUserStorage(ctx, userID, chatID, value) {
+		_ = b.store.ClearPendingAction(ctx, userID)
+	}
+}
+
+// setUserStorage verifies and saves the storage chat named by value, a chat
+// ID or "default", and reports whether it was saved.
+func (b *Bot) setUserStorage(ctx context.Context, userID, chatID int64, value string) bool {
+	if strings.EqualFold(value, "default") {
+		if err := b.store.ClearUserStorage(ctx, userID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			b.sendText(ctx, chatID, fmt.Sprintf("Reset storage chat failed: %v", err))
+			return false
+		}
+		b.sendStorageView(ctx, userID, chatID)
+		return true
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id == 0 {
+		b.sendText(ctx, chatID, "Send a numeric chat ID such as -1001234567890, \"default\" or \"cancel\".")
+		return false
+	}
+	chat, err := b.verifyStorageChat(ctx, userID, id)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Cannot use chat %d: %v", id, err))
+		return false
+	}
+	if err := b.store.SetUserStorage(ctx, userID, chat.ID, chat.Title); err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Set storage chat failed: %v", err))
+		return false
+	}
+	b.sendStorageView(ctx, userID, chatID)
+	return true
+}
+
+// ErrStorageChat wraps the reasons SetStorageChat refuses a chat.
+var ErrStorageChat = errors.New("cannot use chat")
+
+// SetStorageChat verifies chatID as /storage does and makes it userID's
+// storage chat, returning its title.
+func (b *Bot) SetStorageChat(ctx context.Context, userID, chatID int64) (string, error) {
+	chat, err := b.verifyStorageChat(ctx, userID, chatID)
+	if err != nil {
+		return "", fmt.Errorf("%w %d: %v", ErrStorageChat, chatID, err)
+	}
+	if err := b.store.SetUserStorage(ctx, userID, chat.ID, chat.Title); err != nil {
+		return "", err
+	}
+	return chat.Title, nil
+}
+
+// verifyStorageChat checks that chatID is a channel or group the user is in
+// and where the bot is an admin that can post, by posting and deleting a
+// test message. It returns the chat under its current ID.
+func (b *Bot) verifyStorageChat(ctx context.Context, userID, chatID int64) (*telegram.Chat, error) {
+	if chatID == b.cfg.StorageChatID {
+		return nil, errors.New("it is already the server's storage chat")
+	}
+	chat, err := b.tg.GetChat(ctx, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("the bot cannot see it, add the bot to the chat first (%v)", err)
+	}
+	if chat.Type == "private" {
+		return nil, errors.New("it is a private chat; use a channel or group")
+	}
+	me, err := b.tg.GetMe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	botMember, err := b.tg.GetChatMember(ctx, chat.ID, me.ID)
+	if err != nil {
+		return nil, err
+	}
+	if botMember.Status != "administrator" {
+		return nil, errors.New("the bot is not an admin there")
+	}
+	if chat.Type == "channel" && !botMember.CanPostMessages {
+		return nil, errors.New("the bot is not allowed to post messages there")
+	}
+	member, err := b.tg.GetChatMember(ctx, chat.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !member.IsMember() {
+		return nil, errors.New("you are not a member of it")
+	}
+	msg, err := b.tg.SendMessage(ctx, chat.ID, "PigPak storage check", nil)
+	if err != nil {
+		return nil, fmt.Errorf("the bot could not post there (%v)", err)
+	}
+	if err := b.tg.DeleteMessage(ctx, chat.ID, msg.MessageID); err != nil {
+		log.Printf("delete storage check %d/%d: %v", chat.ID, msg.MessageID, err)
+	}
+	return chat, nil
+}
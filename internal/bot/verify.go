@@ -0,0 +1,178 @@
+package bot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// verifyPiece is one blob of a file being verified. label names the part
+// in reports and is empty for a file stored as one blob.
+type verifyPiece struct {
+	label        string
+	fileID       string
+	fileUniqueID string
+	size         int64
+	sha256       string
+}
+
+// verifyResult is what re-downloading a file found.
+type verifyResult struct {
+	sum        string
+	recorded   bool
+	mismatched []string
+}
+
+// startVerify re-downloads a file in the background and reports whether its
+// content still matches the recorded SHA-256. Content without a checksum on
+// record gets one.
+func (b *Bot) startVerify(ctx context.Context, userID, chatID int64, msgID int, fileID int64) {
+	file, err := b.store.GetFileByID(ctx, userID, fileID)
+	if err != nil {
+		b.sendText(ctx, chatID, "File not found.")
+		return
+	}
+	if file.IsEmpty() {
+		b.sendText(ctx, chatID, fmt.Sprintf("%s is empty; there is no content on Telegram to verify.", file.Name))
+		return
+	}
+	pieces, err := b.verifyPieces(ctx, file)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Verify failed: %v", err))
+		return
+	}
+	if limit := b.downloadLimit(); limit > 0 {
+		for _, p := range pieces {
+			if p.size > limit {
+				b.sendText(ctx, chatID, fmt.Sprintf("%s has pieces over %s; verifying it needs a local Bot API server.", file.Name, formatBytes(limit)))
+				return
+			}
+		}
+	}
+	b.sendText(ctx, chatID, fmt.Sprintf("Verifying %s…", file.Name))
+	go func() {
+		stopAction := b.showChatAction(ctx, chatID, telegram.ChatActionTyping)
+		res, failed, err := b.verifyFile(ctx, file, pieces)
+		stopAction()
+		if err != nil {
+			if failed != "" && telegram.IsFileReferenceError(err) {
+				if err := b.store.MarkBlobUnreachable(ctx, failed); err != nil {
+					log.Printf("mark blob %s unreachable: %v", failed, err)
+				}
+				b.sendText(ctx, chatID, fmt.Sprintf("%s: Telegram no longer serves this file. Open it again to see the recovery options.", file.Name))
+				return
+			}
+			b.sendText(ctx, chatID, fmt.Sprintf("Verify %s failed: %v", file.Name, err))
+			return
+		}
+		if len(res.mismatched) == 0 && file.RefState == db.RefStateUnreachable {
+			_ = b.store.SetFileRefState(ctx, userID, file.ID, db.RefStateOK)
+		}
+		_, _ = b.tg.SendFormattedMessage(ctx, chatID, verifyReportHTML(file, res), telegram.ParseModeHTML, nil)
+		if refreshed, err := b.store.GetFileByID(ctx, userID, file.ID); err == nil {
+			b.editFileDetail(ctx, userID, chatID, msgID, refreshed, "")
+		}
+	}()
+}
+
+func (b *Bot) verifyPieces(ctx context.Context, file db.File) ([]verifyPiece, error) {
+	parts, err := b.store.ListFileParts(ctx, file.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return []verifyPiece{{fileID: file.FileID, fileUniqueID: file.FileUniqueID, size: file.Size, sha256: file.SHA256}}, nil
+	}
+	pieces := make([]verifyPiece, 0, len(parts))
+	for _, p := range parts {
+		pieces = append(pieces, verifyPiece{
+			label:        fmt.Sprintf("part %d", p.PartIndex+1),
+			fileID:       p.TelegramFileID,
+			fileUniqueID: p.FileUniqueID,
+			size:         p.Size,
+			sha256:       p.SHA256,
+		})
+	}
+	return pieces, nil
+}
+
+// verifyFile downloads every piece, compares it with its recorded
+// checksum and records the missing ones. On a download error it also
+// returns the blob that failed.
+func (b *Bot) verifyFile(ctx context.Context, file db.File, pieces []verifyPiece) (verifyResult, string, error) {
+	var res verifyResult
+	whole := sha256.New()
+	for _, p := range pieces {
+		sum, n, err := b.downloadSum(ctx, p.fileID, whole)
+		if err != nil {
+			return res, p.fileUniqueID, err
+		}
+		switch {
+		case p.size > 0 && n != p.size:
+			res.mismatched = append(res.mismatched, strings.TrimSpace(fmt.Sprintf("%s (%s instead of %s)", p.label, formatBytes(n), formatBytes(p.size))))
+		case p.sha256 == "":
+			if err := b.store.SetBlobChecksum(ctx, p.fileUniqueID, sum); err != nil {
+				return res, "", err
+			}
+			res.recorded = true
+		case p.sha256 != sum:
+			res.mismatched = append(res.mismatched, p.label)
+		}
+	}
+	res.sum = hex.EncodeToString(whole.Sum(nil))
+	if len(pieces) > 1 && len(res.mismatched) == 0 {
+		switch {
+		case file.SHA256 == "":
+			if err := b.store.SetFileChecksum(ctx, file.UserID, file.ID, res.sum); err != nil {
+				return res, "", err
+			}
+			res.recorded = true
+		case file.SHA256 != res.sum:
+			res.mismatched = append(res.mismatched, "the whole file")
+		}
+	}
+	return res, "", nil
+}
+
+// downloadSum streams one blob from Telegram into its own hash and whole,
+// returning its SHA-256 and length.
+func (b *Bot) downloadSum(ctx context.Context, fileID string, whole hash.Hash) (string, int64, error) {
+	info, err := b.tg.GetFile(ctx, fileID)
+	if err != nil {
+		return "", 0, err
+	}
+	body, err := b.tg.DownloadFile(ctx, info.FilePath, 0)
+	if err != nil {
+		return "", 0, err
+	}
+	defer body.Close()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(h, whole), body)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+func verifyReportHTML(file db.File, res verifyResult) string {
+	name := telegram.Bold(file.Name)
+	if len(res.mismatched) > 0 {
+		text := name + " does not match its recorded checksum"
+		if detail := strings.Join(res.mismatched, ", "); detail != "" {
+			text += ": " + telegram.EscapeHTML(detail)
+		}
+		return text + ". The content Telegram serves differs from what was stored."
+	}
+	if res.recorded {
+		return fmt.Sprintf("%s downloaded fine. No checksum was on record; recorded SHA-256 %s.", name, telegram.Code(res.sum))
+	}
+	return fmt.Sprintf("%s verified: SHA-256 %s matches.", name, telegram.Code(res.sum))
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// maxTempWebDAVHours caps how long a temporary WebDAV login can live.
+const maxTempWebDAVHours = 7 * 24
+
+const webdavTempUsage = "Usage: /webdav temp <hours> [ro] [/folder] to create a temporary login, /webdav temp to list active ones, /webdav revoke <username> to end one early."
+
+// handleWebDAVTempCommand handles "/webdav temp ..." and "/webdav revoke ...";
+// args starts at the subcommand.
+func (b *Bot) handleWebDAVTempCommand(ctx context.Context, chatID int64, user *telegram.User, args []string) {
+	userID := user.ID
+	if strings.ToLower(args[0]) == "revoke" {
+		if len(args) != 2 {
+			b.sendText(ctx, chatID, webdavTempUsage)
+			return
+		}
+		if err := b.store.DeleteWebDAVTempCredential(ctx, userID, args[1]); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				b.sendText(ctx, chatID, "No such temporary login.")
+				return
+			}
+			b.sendText(ctx, chatID, fmt.Sprintf("Revoke failed: %v", err))
+			return
+		}
+		b.sendText(ctx, chatID, fmt.Sprintf("Revoked %s.", args[1]))
+		return
+	}
+	if len(args) == 1 {
+		b.sendWebDAVTempList(ctx, userID, chatID)
+		return
+	}
+	hours, err := strconv.Atoi(args[1])
+	if err != nil || hours <= 0 || hours > maxTempWebDAVHours {
+		b.sendText(ctx, chatID, fmt.Sprintf("Hours must be between 1 and %d.\n%s", maxTempWebDAVHours, webdavTempUsage))
+		return
+	}
+	rest := args[2:]
+	readOnly := false
+	if len(rest) > 0 && (strings.EqualFold(rest[0], "ro") || strings.EqualFold(rest[0], "readonly")) {
+		readOnly = true
+		rest = rest[1:]
+	}
+	var scopeDirID int64
+	if folder := strings.Trim(strings.Join(rest, " "), "/ "); folder != "" {
+		dir, err := b.store.FindDirByPath(ctx, userID, strings.Split(folder, "/"))
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Folder /%s not found.", folder))
+			return
+		}
+		scopeDirID = dir.ID
+	}
+	username := db.WebDAVTempUsernamePrefix + randomToken(8)
+	password := randomToken(20)
+	expiresAt := time.Now().UTC().Add(time.Duration(hours) * time.Hour)
+	cred, err := b.store.CreateWebDAVTempCredential(ctx, userID, username, password, expiresAt, readOnly, scopeDirID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Create temporary login failed: %v", err))
+		return
+	}
+	url := b.webdavURL()
+	if url == "" {
+		url = "http://<server-host>" + b.cfg.WebDAVAddr
+	}
+	text := fmt.Sprintf("Temporary WebDAV login\nURL: %s\nUsername: %s\nPassword: %s\n%s\nExpires: %s\n\nThe password is shown only once. Revoke early with /webdav revoke %s",
+		url, cred.Username, password, b.webdavTempAccess(ctx, cred), cred.ExpiresAt.Format("2006-01-02 15:04 MST"), cred.Username)
+	b.sendText(ctx, chatID, text)
+}
+
+func (b *Bot) sendWebDAVTempList(ctx context.Context, userID, chatID int64) {
+	creds, err := b.store.ListWebDAVTempCredentials(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("List temporary logins failed: %v", err))
+		return
+	}
+	if len(creds) == 0 {
+		b.sendText(ctx, chatID, "No active temporary logins.\n"+webdavTempUsage)
+		return
+	}
+	lines := []string{"Active temporary logins:"}
+	for _, c := range creds {
+		lines = append(lines, fmt.Sprintf("%s - %s, until %s", c.Username, b.webdavTempAccess(ctx, c), c.ExpiresAt.Format("2006-01-02 15:04 MST")))
+	}
+	b.sendText(ctx, chatID, strings.Join(lines, "\n"))
+}
+
+// webdavTempAccess describes what a temporary login can reach.
+func (b *Bot) webdavTempAccess(ctx context.Context, c db.WebDAVTempCredential) string {
+	mode := "read-write"
+	if c.ReadOnly {
+		mode = "read-only"
+	}
+	folder := "whole drive"
+	if c.ScopeDirID.Valid {
+		folder = b.dirDisplayPath(ctx, c.UserID, c.ScopeDirID.Int64)
+	}
+	return fmt.Sprintf("Access: %s, %s", mode, folder)
+}
@@ -0,0 +1,187 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"pigpak/internal/db"
+	"pigpak/internal/telegram"
+)
+
+// webhookLogLimit is how many deliveries /webhooks log shows.
+const webhookLogLimit = 10
+
+var webhooksUsage = `Usage:
+/webhooks to list your webhooks
+/webhooks add <url> [event ...] to post events to a URL
+/webhooks log <n> to see its last deliveries
+/webhooks remove <n> to delete it
+
+Events: ` + strings.Join(db.WebhookEvents, ", ") + `; without any, every event is sent. Each POST carries X-Pigpak-Signature: sha256=<HMAC-SHA256 of the body keyed with the webhook's secret>. Failed deliveries are retried with backoff. Admins can add all to receive every user's events and list every webhook with /webhooks all.`
+
+func (b *Bot) handleWebhooksCommand(ctx context.Context, userID, chatID int64, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || strings.Split(fields[0], "@")[0] != "/webhooks" {
+		return false
+	}
+	switch {
+	case len(fields) == 1:
+		body, markup, err := b.webhooksView(ctx, userID)
+		if err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Failed to load webhooks: %v", err))
+			return true
+		}
+		_, _ = b.tg.SendMessage(ctx, chatID, body, markup)
+	case strings.EqualFold(fields[1], "add") && len(fields) >= 3:
+		b.addWebhook(ctx, userID, chatID, fields[2], fields[3:])
+	case strings.EqualFold(fields[1], "remove") && len(fields) == 3:
+		hook, ok := b.webhookByNumber(ctx, userID, chatID, fields[2])
+		if !ok {
+			return true
+		}
+		if err := b.store.DeleteWebhook(ctx, userID, hook.ID); err != nil {
+			b.sendText(ctx, chatID, fmt.Sprintf("Remove failed: %v", err))
+			return true
+		}
+		b.sendText(ctx, chatID, "Removed the webhook to "+hook.URL+".")
+	case strings.EqualFold(fields[1], "log") && len(fields) == 3:
+		hook, ok := b.webhookByNumber(ctx, userID, chatID, fields[2])
+		if !ok {
+			return true
+		}
+		b.sendText(ctx, chatID, b.webhookLog(ctx, userID, hook))
+	case strings.EqualFold(fields[1], "all") && len(fields) == 2 && b.cfg.IsAdmin(userID):
+		b.sendText(ctx, chatID, b.allWebhooksView(ctx))
+	default:
+		b.sendText(ctx, chatID, webhooksUsage)
+	}
+	return true
+}
+
+func (b *Bot) addWebhook(ctx context.Context, userID, chatID int64, rawURL string, opts []string) {
+	var events []string
+	allUsers := false
+	for _, opt := range opts {
+		opt = strings.ToLower(opt)
+		if opt == "all" {
+			if !b.cfg.IsAdmin(userID) {
+				b.sendText(ctx, chatID, "Only admins can receive every user's events.")
+				return
+			}
+			allUsers = true
+			continue
+		}
+		events = append(events, opt)
+	}
+	secret := randomToken(32)
+	hook, err := b.store.CreateWebhook(ctx, userID, rawURL, secret, events, allUsers)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Add webhook failed: %v", err))
+		return
+	}
+	text := fmt.Sprintf("Webhook to %s\n%s\n\nSigning secret:\n%s\n\nThe secret is shown only once. See deliveries with /webhooks log and remove it with /webhooks remove.", hook.URL, webhookScope(hook), secret)
+	b.sendText(ctx, chatID, text)
+}
+
+// webhookByNumber finds a webhook by its number in the /webhooks list,
+// answering the user when there is none.
+func (b *Bot) webhookByNumber(ctx context.Context, userID, chatID int64, arg string) (db.Webhook, bool) {
+	hooks, err := b.store.ListWebhooks(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load webhooks: %v", err))
+		return db.Webhook{}, false
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(hooks) {
+		b.sendText(ctx, chatID, "No such webhook. Use the numbers from /webhooks.")
+		return db.Webhook{}, false
+	}
+	return hooks[n-1], true
+}
+
+func (b *Bot) webhooksView(ctx context.Context, userID int64) (string, *telegram.InlineKeyboardMarkup, error) {
+	hooks, err := b.store.ListWebhooks(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(hooks) == 0 {
+		return "No webhooks.\n\n" + webhooksUsage, nil, nil
+	}
+	lines := []string{"Webhooks:"}
+	var rows [][]telegram.InlineKeyboardButton
+	for i, h := range hooks {
+		lines = append(lines, fmt.Sprintf("%d. %s - %s, added %s", i+1, h.URL, webhookScope(h), h.CreatedAt.Format("2006-01-02")))
+		rows = append(rows, []telegram.InlineKeyboardButton{{Text: fmt.Sprintf("Remove %d", i+1), CallbackData: fmt.Sprintf("unhook:%d", h.ID)}})
+	}
+	return strings.Join(lines, "\n"), &telegram.InlineKeyboardMarkup{InlineKeyboard: rows}, nil
+}
+
+// removeWebhook removes a webhook from the list view and refreshes it.
+func (b *Bot) removeWebhook(ctx context.Context, userID, chatID int64, msgID int, webhookID int64) {
+	if err := b.store.DeleteWebhook(ctx, userID, webhookID); err != nil && err != sql.ErrNoRows {
+		b.sendText(ctx, chatID, fmt.Sprintf("Remove failed: %v", err))
+		return
+	}
+	body, markup, err := b.webhooksView(ctx, userID)
+	if err != nil {
+		b.sendText(ctx, chatID, fmt.Sprintf("Failed to load webhooks: %v", err))
+		return
+	}
+	_, _ = b.tg.EditMessageText(ctx, chatID, msgID, body, markup)
+}
+
+func (b *Bot) webhookLog(ctx context.Context, userID int64, hook db.Webhook) string {
+	deliveries, err := b.store.ListWebhookDeliveries(ctx, userID, hook.ID, webhookLogLimit)
+	if err != nil {
+		return fmt.Sprintf("Failed to load deliveries: %v", err)
+	}
+	if len(deliveries) == 0 {
+		return "No deliveries to " + hook.URL + " yet."
+	}
+	lines := []string{"Last deliveries to " + hook.URL + ":"}
+	for _, d := range deliveries {
+		line := fmt.Sprintf("#%d %s %s - %s", d.ID, d.CreatedAt.Format("2006-01-02 15:04 MST"), d.Event, d.Status)
+		if d.StatusCode != 0 {
+			line += fmt.Sprintf(" (HTTP %d)", d.StatusCode)
+		}
+		line += fmt.Sprintf(", %d attempt(s)", d.Attempts)
+		if d.Status == db.DeliveryPending && d.Attempts > 0 {
+			line += ", next " + d.NextAttemptAt.Format("15:04 MST")
+		}
+		if d.Error != "" && d.Status != db.DeliveryDelivered {
+			line += "\n   " + d.Error
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (b *Bot) allWebhooksView(ctx context.Context) string {
+	hooks, err := b.store.ListAllWebhooks(ctx)
+	if err != nil {
+		return fmt.Sprintf("Failed to load webhooks: %v", err)
+	}
+	if len(hooks) == 0 {
+		return "No webhooks."
+	}
+	lines := []string{"All webhooks:"}
+	for _, h := range hooks {
+		lines = append(lines, fmt.Sprintf("user %d: %s - %s", h.UserID, h.URL, webhookScope(h)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// webhookScope describes which events a webhook receives.
+func webhookScope(h db.Webhook) string {
+	events := "all events"
+	if len(h.Events) > 0 {
+		events = strings.Join(h.Events, ", ")
+	}
+	if h.AllUsers {
+		events += " of every user"
+	}
+	return events
+}
@@ -3,29 +3,153 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// LocalUploadLimit is the largest upload a local Bot API server accepts.
+const LocalUploadLimit int64 = 2000 * 1000 * 1000
+
+// MinPartSizeBytes is the smallest part size a user can choose; smaller
+// parts would turn every file into thousands of messages.
+const MinPartSizeBytes int64 = 1 << 20
+
+// What WebDAV does with the files WEB_DAV_IGNORE matches, set with
+// WEB_DAV_IGNORE_MODE.
+const (
+	// IgnoreHide keeps them in the database, out of the storage chat and
+	// of folder listings, but readable by the client that wrote them.
+	IgnoreHide = "hide"
+	// IgnoreReject refuses to store them.
+	IgnoreReject = "reject"
+	// IgnoreOff stores them like any other file.
+	IgnoreOff = "off"
+)
+
+// DefaultWebDAVIgnore matches the metadata files macOS and Windows write
+// next to everything they browse, and Office's lock files.
+var DefaultWebDAVIgnore = []string{".DS_Store", "._*", "Thumbs.db", "~$*"}
+
 // Config holds runtime configuration loaded from env vars.
 type Config struct {
-	BotToken        string
-	BotUsername     string
-	TelegramAPIURL  string
-	DataDir         string
-	DBPath          string
-	PollTimeout     time.Duration
-	PageSize        int
-	MaxPartSizeBytes int64
-	TelegramHTTPTimeout time.Duration
+	BotToken                string
+	BotUsername             string
+	AdminUserIDs            []int64
+	TelegramAPIURL          string
+	TelegramLocalAPI        bool
+	DataDir                 string
+	DBPath                  string
+	PollTimeout             time.Duration
+	UpdatesLimit            int
+	UpdateWorkers           int
+	PageSize                int
+	MaxPartSizeBytes        int64
+	TelegramHTTPTimeout     time.Duration
+	TelegramDownloadResumes int
+	// TelegramProxy is a proxy URL for all Bot API traffic. Empty falls back
+	// to HTTPS_PROXY and friends.
+	TelegramProxy string
+	// AllowedUpdates overrides the update types requested from Telegram.
+	// Empty keeps the client default.
+	AllowedUpdates  []string
 	WebDAVEnable    bool
 	WebDAVAddr      string
 	WebDAVPublicURL string
-	StorageChatID   int64
-	ShareBaseURL    string
+	// WebDAVUploadTTL is how long an interrupted WebDAV upload can be
+	// resumed before its session is dropped; 0 keeps sessions forever.
+	// WebDAVUploadPurge also deletes the storage chat messages they hold.
+	WebDAVUploadTTL   time.Duration
+	WebDAVUploadPurge bool
+	// WebDAVMaxUploads bounds the PUTs uploading to Telegram at once, and
+	// WebDAVMaxUserUploads those of one user; PUTs past either get 503 with
+	// Retry-After. 0 lifts the limit.
+	WebDAVMaxUploads     int
+	WebDAVMaxUserUploads int
+	// WebDAVIgnore lists name patterns, as in path.Match, of files WebDAV
+	// clients write for themselves; WebDAVIgnoreMode says what is done
+	// with them.
+	WebDAVIgnore     []string
+	WebDAVIgnoreMode string
+	// WebDAVShutdownTimeout is how long a shutdown waits for WebDAV
+	// requests, uploads above all, to finish before cancelling them.
+	WebDAVShutdownTimeout time.Duration
+	// WebDAVPropfindMaxItems and WebDAVPropfindMaxDepth bound the trees a
+	// Depth: infinity PROPFIND lists; larger ones are refused so clients
+	// fall back to Depth: 1. A zero item limit refuses them all and a zero
+	// depth limit leaves depth unbounded.
+	WebDAVPropfindMaxItems int
+	WebDAVPropfindMaxDepth int
+	// WebDAVTLSCert and WebDAVTLSKey serve WebDAV over HTTPS with the
+	// certificate in those files. WebDAVACMEDomains has one issued by
+	// Let's Encrypt instead, cached in WebDAVACMECacheDir; the challenge is
+	// answered on the WebDAV port, which must then be reachable as 443, or
+	// on WebDAVACMEHTTPAddr, which also redirects plain HTTP to HTTPS.
+	WebDAVTLSCert      string
+	WebDAVTLSKey       string
+	WebDAVACMEDomains  []string
+	WebDAVACMEEmail    string
+	WebDAVACMECacheDir string
+	WebDAVACMEHTTPAddr string
+	StorageChatID      int64
+	// VideoUploads sends single-part MP4 and QuickTime uploads as streamable
+	// videos, with a thumbnail when FFmpegPath resolves to an ffmpeg binary.
+	VideoUploads   bool
+	FFmpegPath     string
+	ShareBaseURL   string
+	SessionSecret  string
+	SessionTTL     time.Duration
+	PublishLinkTTL time.Duration
+	// CORSOrigins are the origins browser clients may call the API and
+	// the public gateway from; "*" allows any. Empty disables CORS.
+	CORSOrigins []string
+	// CORSHeaders are request headers allowed across origins on top of
+	// the ones the API takes.
+	CORSHeaders []string
+	CORSMaxAge  time.Duration
+	// DirectLinkTTL is how long the signed links of "Get direct link"
+	// stay valid; 0 hides the button.
+	DirectLinkTTL time.Duration
+	// AuditRetention is how long audit log entries are kept; 0 keeps them
+	// forever.
+	AuditRetention time.Duration
+	// OutboxRetention is how long processed outbox events are kept for
+	// consumers and activity feeds; 0 keeps them forever.
+	OutboxRetention time.Duration
+	// WebhookTimeout bounds one webhook delivery, and WebhookMaxAttempts
+	// is how often a failing delivery is tried before it is given up.
+	// Webhooks cannot reach loopback, private or link-local addresses
+	// unless WebhookAllowPrivate is set.
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	WebhookAllowPrivate bool
+	// DebugAddr is where the pprof and expvar endpoints listen, each
+	// request carrying DebugToken; empty disables them.
+	DebugAddr  string
+	DebugToken string
+	// AdminSocket is the Unix socket the admin gRPC service listens on;
+	// empty disables it. Anyone who can open the socket is an admin.
+	AdminSocket string
+	// BackupInterval schedules an encrypted snapshot of the database,
+	// uploaded to the storage chat; 0 disables it.
+	BackupInterval   time.Duration
+	BackupPassphrase string
+	// MaintenanceInterval spaces out database maintenance passes; 0
+	// disables them. TrashRetention is how long trash items are kept
+	// before maintenance purges them; 0 keeps them until emptied.
+	MaintenanceInterval time.Duration
+	TrashRetention      time.Duration
+	// PartCacheSizeBytes bounds the on-disk cache of downloaded file parts
+	// in PartCacheDir, shared by WebDAV and download links; 0 disables it.
+	PartCacheSizeBytes int64
+	PartCacheDir       string
+	// MaxUserPartSizeBytes caps the part size users can choose for their
+	// own WebDAV uploads with /partsize, in place of MaxPartSizeBytes.
+	MaxUserPartSizeBytes int64
 }
 
 // Load reads environment variables and applies defaults.
@@ -36,23 +160,46 @@ func Load() (Config, error) {
 		return cfg, errors.New("BOT_TOKEN is required")
 	}
 	cfg.BotUsername = strings.TrimSpace(os.Getenv("BOT_USERNAME"))
+	cfg.AdminUserIDs = parseInt64List("ADMIN_USER_IDS")
 	cfg.TelegramAPIURL = strings.TrimSpace(os.Getenv("TELEGRAM_API_URL"))
 	if cfg.TelegramAPIURL == "" {
 		cfg.TelegramAPIURL = "https://api.telegram.org"
 	}
-	cfg.DataDir = strings.TrimSpace(os.Getenv("DATA_DIR"))
-	if cfg.DataDir == "" {
-		cfg.DataDir = "./data"
-	}
-	cfg.DBPath = strings.TrimSpace(os.Getenv("DB_PATH"))
-	if cfg.DBPath == "" {
-		cfg.DBPath = filepath.Join(cfg.DataDir, "bot.db")
-	}
+	cfg.TelegramLocalAPI = parseBool("TELEGRAM_LOCAL_API", false)
+	cfg.TelegramProxy = strings.TrimSpace(os.Getenv("TELEGRAM_PROXY"))
+	cfg.AllowedUpdates = parseStringList("ALLOWED_UPDATES")
+	cfg.DataDir, cfg.DBPath = StoragePaths()
 	cfg.PollTimeout = parseDuration("POLL_TIMEOUT", 30*time.Second)
+	cfg.UpdatesLimit = parseInt("UPDATES_LIMIT", 100)
+	if cfg.UpdatesLimit <= 0 || cfg.UpdatesLimit > 100 {
+		cfg.UpdatesLimit = 100
+	}
+	cfg.UpdateWorkers = parseInt("UPDATE_WORKERS", 8)
 	cfg.PageSize = parseInt("PAGE_SIZE", 8)
-	cfg.MaxPartSizeBytes = parseInt64("MAX_PART_SIZE_BYTES", 1900*1024*1024)
+	// A local Bot API server accepts uploads up to 2000 MB, so parts can use
+	// the whole limit instead of keeping a safety margin.
+	defaultPartSize := int64(1900 * 1024 * 1024)
+	if cfg.TelegramLocalAPI {
+		defaultPartSize = LocalUploadLimit
+	}
+	cfg.MaxPartSizeBytes = parseInt64("MAX_PART_SIZE_BYTES", defaultPartSize)
 	if cfg.MaxPartSizeBytes <= 0 {
-		cfg.MaxPartSizeBytes = 1900 * 1024 * 1024
+		cfg.MaxPartSizeBytes = defaultPartSize
+	}
+	if cfg.MaxPartSizeBytes > LocalUploadLimit {
+		return cfg, fmt.Errorf("MAX_PART_SIZE_BYTES is over the %d bytes Telegram accepts in one upload", LocalUploadLimit)
+	}
+	cfg.MaxUserPartSizeBytes = parseInt64("MAX_USER_PART_SIZE_BYTES", cfg.MaxPartSizeBytes)
+	if cfg.MaxUserPartSizeBytes <= 0 {
+		cfg.MaxUserPartSizeBytes = cfg.MaxPartSizeBytes
+	}
+	if cfg.MaxUserPartSizeBytes > LocalUploadLimit {
+		return cfg, fmt.Errorf("MAX_USER_PART_SIZE_BYTES is over the %d bytes Telegram accepts in one upload", LocalUploadLimit)
+	}
+	cfg.PartCacheSizeBytes = parseInt64("PART_CACHE_SIZE_BYTES", 0)
+	cfg.PartCacheDir = strings.TrimSpace(os.Getenv("PART_CACHE_DIR"))
+	if cfg.PartCacheDir == "" {
+		cfg.PartCacheDir = filepath.Join(cfg.DataDir, "cache")
 	}
 	cfg.TelegramHTTPTimeout = parseDuration("TELEGRAM_HTTP_TIMEOUT", 0)
 	if cfg.TelegramHTTPTimeout <= 0 {
@@ -62,22 +209,126 @@ func Load() (Config, error) {
 		}
 	}
 
+	cfg.TelegramDownloadResumes = parseInt("TELEGRAM_DOWNLOAD_RESUMES", 5)
+	if cfg.TelegramDownloadResumes < 0 {
+		cfg.TelegramDownloadResumes = 0
+	}
+
 	cfg.WebDAVEnable = parseBool("WEB_DAV_ENABLE", false)
 	cfg.WebDAVAddr = strings.TrimSpace(os.Getenv("WEB_DAV_ADDR"))
 	if cfg.WebDAVAddr == "" {
 		cfg.WebDAVAddr = ":8081"
 	}
 	cfg.WebDAVPublicURL = strings.TrimSpace(os.Getenv("WEB_DAV_PUBLIC_URL"))
+	cfg.WebDAVUploadTTL = parseDuration("WEB_DAV_UPLOAD_TTL", 7*24*time.Hour)
+	cfg.WebDAVUploadPurge = parseBool("WEB_DAV_UPLOAD_PURGE", false)
+	cfg.WebDAVMaxUploads = parseInt("WEB_DAV_MAX_UPLOADS", 8)
+	cfg.WebDAVMaxUserUploads = parseInt("WEB_DAV_MAX_USER_UPLOADS", 4)
+	cfg.WebDAVShutdownTimeout = parseDuration("WEB_DAV_SHUTDOWN_TIMEOUT", 30*time.Second)
+	cfg.WebDAVPropfindMaxItems = parseInt("WEB_DAV_PROPFIND_MAX_ITEMS", 10000)
+	cfg.WebDAVPropfindMaxDepth = parseInt("WEB_DAV_PROPFIND_MAX_DEPTH", 64)
+	cfg.WebDAVIgnore = parseStringList("WEB_DAV_IGNORE")
+	if len(cfg.WebDAVIgnore) == 0 {
+		cfg.WebDAVIgnore = DefaultWebDAVIgnore
+	}
+	for _, pattern := range cfg.WebDAVIgnore {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return cfg, fmt.Errorf("WEB_DAV_IGNORE pattern %q: %w", pattern, err)
+		}
+	}
+	cfg.WebDAVIgnoreMode = strings.ToLower(strings.TrimSpace(os.Getenv("WEB_DAV_IGNORE_MODE")))
+	switch cfg.WebDAVIgnoreMode {
+	case "":
+		cfg.WebDAVIgnoreMode = IgnoreHide
+	case IgnoreHide, IgnoreReject, IgnoreOff:
+	default:
+		return cfg, fmt.Errorf("unknown WEB_DAV_IGNORE_MODE %q; use hide, reject or off", cfg.WebDAVIgnoreMode)
+	}
+	cfg.WebDAVTLSCert = strings.TrimSpace(os.Getenv("WEB_DAV_TLS_CERT"))
+	cfg.WebDAVTLSKey = strings.TrimSpace(os.Getenv("WEB_DAV_TLS_KEY"))
+	if (cfg.WebDAVTLSCert == "") != (cfg.WebDAVTLSKey == "") {
+		return cfg, errors.New("WEB_DAV_TLS_CERT and WEB_DAV_TLS_KEY must be set together")
+	}
+	cfg.WebDAVACMEDomains = parseStringList("WEB_DAV_ACME_DOMAINS")
+	if len(cfg.WebDAVACMEDomains) > 0 && cfg.WebDAVTLSCert != "" {
+		return cfg, errors.New("WEB_DAV_ACME_DOMAINS cannot be combined with WEB_DAV_TLS_CERT; use one or the other")
+	}
+	cfg.WebDAVACMEEmail = strings.TrimSpace(os.Getenv("WEB_DAV_ACME_EMAIL"))
+	cfg.WebDAVACMECacheDir = strings.TrimSpace(os.Getenv("WEB_DAV_ACME_CACHE"))
+	if cfg.WebDAVACMECacheDir == "" {
+		cfg.WebDAVACMECacheDir = filepath.Join(cfg.DataDir, "autocert")
+	}
+	cfg.WebDAVACMEHTTPAddr = strings.TrimSpace(os.Getenv("WEB_DAV_ACME_HTTP_ADDR"))
 	cfg.StorageChatID = parseInt64("STORAGE_CHAT_ID", 0)
+	cfg.VideoUploads = parseBool("VIDEO_UPLOADS", true)
+	cfg.FFmpegPath = strings.TrimSpace(os.Getenv("FFMPEG_PATH"))
+	if cfg.FFmpegPath == "" {
+		cfg.FFmpegPath = "ffmpeg"
+	}
 
 	cfg.ShareBaseURL = strings.TrimSpace(os.Getenv("SHARE_BASE_URL"))
 	if cfg.ShareBaseURL == "" && cfg.BotUsername != "" {
 		cfg.ShareBaseURL = fmt.Sprintf("https://t.me/%s", cfg.BotUsername)
 	}
 
+	cfg.SessionSecret = strings.TrimSpace(os.Getenv("SESSION_SECRET"))
+	cfg.SessionTTL = parseDuration("SESSION_TTL", 30*24*time.Hour)
+	cfg.PublishLinkTTL = parseDuration("PUBLISH_LINK_TTL", 0)
+	cfg.DirectLinkTTL = parseDuration("DIRECT_LINK_TTL", 24*time.Hour)
+	cfg.CORSOrigins = parseStringList("CORS_ORIGINS")
+	for i, origin := range cfg.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" {
+			return cfg, fmt.Errorf("CORS_ORIGINS: %q is not an origin like https://app.example.com", origin)
+		}
+		cfg.CORSOrigins[i] = u.Scheme + "://" + u.Host
+	}
+	cfg.CORSHeaders = parseStringList("CORS_HEADERS")
+	cfg.CORSMaxAge = parseDuration("CORS_MAX_AGE", 10*time.Minute)
+	cfg.AuditRetention = parseDuration("AUDIT_RETENTION", 90*24*time.Hour)
+	cfg.OutboxRetention = parseDuration("OUTBOX_RETENTION", 30*24*time.Hour)
+	cfg.WebhookTimeout = parseDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	cfg.WebhookMaxAttempts = parseInt("WEBHOOK_MAX_ATTEMPTS", 8)
+	cfg.WebhookAllowPrivate = parseBool("WEBHOOK_ALLOW_PRIVATE", false)
+	cfg.DebugAddr = strings.TrimSpace(os.Getenv("DEBUG_ADDR"))
+	cfg.DebugToken = strings.TrimSpace(os.Getenv("DEBUG_TOKEN"))
+	if cfg.DebugAddr != "" && len(cfg.DebugToken) < 16 {
+		return cfg, errors.New("DEBUG_ADDR needs DEBUG_TOKEN of at least 16 characters; profiles expose memory contents")
+	}
+	cfg.AdminSocket = strings.TrimSpace(os.Getenv("ADMIN_SOCKET"))
+	cfg.MaintenanceInterval = parseDuration("MAINTENANCE_INTERVAL", 24*time.Hour)
+	cfg.TrashRetention = parseDuration("TRASH_RETENTION", 0)
+	cfg.BackupInterval = parseDuration("BACKUP_INTERVAL", 0)
+	cfg.BackupPassphrase = os.Getenv("BACKUP_PASSPHRASE")
+	if cfg.BackupInterval > 0 {
+		if cfg.StorageChatID == 0 {
+			return cfg, errors.New("BACKUP_INTERVAL needs STORAGE_CHAT_ID to upload snapshots to")
+		}
+		if cfg.BackupPassphrase == "" {
+			return cfg, errors.New("BACKUP_INTERVAL needs BACKUP_PASSPHRASE; snapshots are encrypted before they leave the host")
+		}
+	}
+
 	return cfg, nil
 }
 
+// StoragePaths returns the data directory and database path from DATA_DIR
+// and DB_PATH. Unlike Load it needs no bot token, so offline tools can use it.
+func StoragePaths() (dataDir, dbPath string) {
+	dataDir = strings.TrimSpace(os.Getenv("DATA_DIR"))
+	if dataDir == "" {
+		dataDir = "./data"
+	}
+	dbPath = strings.TrimSpace(os.Getenv("DB_PATH"))
+	if dbPath == "" {
+		dbPath = filepath.Join(dataDir, "bot.db")
+	}
+	return dataDir, dbPath
+}
+
 func parseBool(key string, def bool) bool {
 	val := strings.TrimSpace(os.Getenv(key))
 	if val == "" {
@@ -111,6 +362,47 @@ func parseInt64(key string, def int64) int64 {
 	return parsed
 }
 
+func parseInt64List(key string) []int64 {
+	var out []int64
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, parsed)
+	}
+	return out
+}
+
+// WebDAVTLS reports whether the WebDAV server serves HTTPS itself.
+func (c Config) WebDAVTLS() bool {
+	return c.WebDAVTLSCert != "" || len(c.WebDAVACMEDomains) > 0
+}
+
+// IsAdmin reports whether a Telegram user ID is listed in ADMIN_USER_IDS.
+func (c Config) IsAdmin(userID int64) bool {
+	for _, id := range c.AdminUserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func parseStringList(key string) []string {
+	var out []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
 func parseDuration(key string, def time.Duration) time.Duration {
 	val := strings.TrimSpace(os.Getenv(key))
 	if val == "" {
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// settingName is what a file's keys must flatten to: an environment
+// variable name.
+var settingName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// ApplyFile reads a YAML (.yaml, .yml) or TOML (.toml) config file and sets
+// the environment variables it names that are not set already, so the
+// environment overrides the file and Load, StoragePaths and the offline
+// tools all see the same settings.
+//
+// Keys are the names of the environment variables, in any case. Tables
+// join their key to those within with an underscore, so
+//
+//	web_dav:
+//	  enable: true
+//	  addr: ":8081"
+//
+// sets WEB_DAV_ENABLE and WEB_DAV_ADDR. Lists become comma-separated
+// values, as ADMIN_USER_IDS and the other list settings take them.
+func ApplyFile(path string) error {
+	values, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFile parses a config file into the environment variables it sets.
+func ReadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tree := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s: unknown format %q; use .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	values := map[string]string{}
+	if err := flattenSettings(values, "", tree); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+func flattenSettings(values map[string]string, prefix string, tree map[string]any) error {
+	keys := make([]string, 0, len(tree))
+	for key := range tree {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		if !settingName.MatchString(name) {
+			return fmt.Errorf("%q is not a setting name", key)
+		}
+		if table, ok := tree[key].(map[string]any); ok {
+			if err := flattenSettings(values, name, table); err != nil {
+				return err
+			}
+			continue
+		}
+		value, err := settingValue(tree[key])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if _, dup := values[name]; dup {
+			return fmt.Errorf("%s is set twice", name)
+		}
+		values[name] = value
+	}
+	return nil
+}
+
+// settingValue renders a scalar, or a list of them, the way the
+// environment variable takes it.
+func settingValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := settingValue(item)
+			if err != nil {
+				return "", err
+			}
+			if strings.Contains(s, ",") {
+				return "", errors.New("list items cannot contain commas")
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
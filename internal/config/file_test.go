@@ -0,0 +1,124 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFile writes a config file named name in a temporary directory and
+// returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadFile(t *testing.T) {
+	want := map[string]string{
+		"BOT_TOKEN":              "123:abc",
+		"WEB_DAV_ENABLE":         "true",
+		"WEB_DAV_ADDR":           ":8081",
+		"WEB_DAV_ACME_HTTP_ADDR": ":80",
+		"ADMIN_USER_IDS":         "1,2,3",
+		"ALLOWED_UPDATES":        "message,callback_query",
+		"PART_CACHE_SIZE_BYTES":  "1.5",
+		"SHARE_BASE_URL":         "",
+		// Keys pigpak does not read are passed on like the others.
+		"SOMETHING_ELSE": "x",
+	}
+	tests := []struct {
+		name, content string
+	}{
+		{"config.yaml", `
+bot_token: "123:abc"
+web-dav:
+  enable: true
+  addr: ":8081"
+  acme:
+    http_addr: ":80"
+admin_user_ids: [1, 2, 3]
+allowed_updates:
+  - message
+  - callback_query
+part_cache_size_bytes: 1.5
+share_base_url:
+something_else: x
+`},
+		{"config.toml", `
+bot_token = "123:abc"
+admin_user_ids = [1, 2, 3]
+allowed_updates = ["message", "callback_query"]
+part_cache_size_bytes = 1.5
+share_base_url = ""
+something_else = "x"
+
+[web-dav]
+enable = true
+addr = ":8081"
+
+[web-dav.acme]
+http_addr = ":80"
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadFile(writeFile(t, tt.name, tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("ReadFile = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFlattenSettingsRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		tree map[string]any
+	}{
+		{"key with a space", map[string]any{"web dav": true}},
+		{"key starting with a digit", map[string]any{"1st": "x"}},
+		{"nested bad key", map[string]any{"web_dav": map[string]any{"addr.port": "80"}}},
+		{"set twice", map[string]any{"web_dav": map[string]any{"addr": ":1"}, "web_dav_addr": ":2"}},
+		{"list item with a comma", map[string]any{"allowed_updates": []any{"a,b"}}},
+		{"list of tables", map[string]any{"admin_user_ids": []any{map[string]any{"id": 1}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := map[string]string{}
+			if err := flattenSettings(values, "", tt.tree); err == nil {
+				t.Fatalf("flattenSettings(%v) = %v, want an error", tt.tree, values)
+			}
+		})
+	}
+}
+
+func TestReadFileUnknownFormat(t *testing.T) {
+	if _, err := ReadFile(writeFile(t, "config.json", `{}`)); err == nil {
+		t.Fatal("ReadFile of a .json file succeeded")
+	}
+}
+
+func TestApplyFileKeepsEnvironment(t *testing.T) {
+	path := writeFile(t, "config.yaml", "web_dav:\n  addr: \":9000\"\n  public_url: https://file.example\n")
+	t.Setenv("WEB_DAV_ADDR", ":7000")
+	t.Setenv("WEB_DAV_PUBLIC_URL", "")
+	os.Unsetenv("WEB_DAV_PUBLIC_URL")
+
+	if err := ApplyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("WEB_DAV_ADDR"); got != ":7000" {
+		t.Fatalf("WEB_DAV_ADDR = %q, want the environment's :7000", got)
+	}
+	if got := os.Getenv("WEB_DAV_PUBLIC_URL"); got != "https://file.example" {
+		t.Fatalf("WEB_DAV_PUBLIC_URL = %q, want the file's https://file.example", got)
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// APITokenPrefix starts every API token, so tokens are easy to recognize in
+// a password field or a leaked config file.
+const APITokenPrefix = "pgp_"
+
+// Token scopes say where a token is accepted.
+const (
+	// TokenScopeAPI allows the JSON API with Authorization: Bearer.
+	TokenScopeAPI = "api"
+	// TokenScopeWebDAV allows the token as a WebDAV password, next to the
+	// owner's Telegram username.
+	TokenScopeWebDAV = "webdav"
+)
+
+// apiTokenTouchInterval limits how often a token's last use is written.
+const apiTokenTouchInterval = time.Minute
+
+// APIToken is a revocable bearer token. The token itself is only known when
+// it is created; its SHA-256 is stored.
+type APIToken struct {
+	ID     int64
+	UserID int64
+	Name   string
+	// Prefix is the start of the token, for telling tokens apart.
+	Prefix     string
+	Scopes     []string
+	ReadOnly   bool
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	CreatedAt  time.Time
+}
+
+// HasScope reports whether the token is accepted for scope.
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+const apiTokenColumns = `id, user_id, name, prefix, scopes, read_only, expires_at, last_used_at, created_at`
+
+func scanAPIToken(row rowScanner, t *APIToken) error {
+	var scopes string
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &t.ReadOnly, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt); err != nil {
+		return err
+	}
+	t.Scopes = strings.Split(scopes, ",")
+	return nil
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken stores token, which must start with APITokenPrefix, for
+// userID. A nil expiresAt never expires.
+func (s *Store) CreateAPIToken(ctx context.Context, userID int64, name, token string, scopes []string, readOnly bool, expiresAt *time.Time) (APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return APIToken{}, fmt.Errorf("token name cannot be empty: %w", os.ErrInvalid)
+	}
+	if !strings.HasPrefix(token, APITokenPrefix) || len(token) < len(APITokenPrefix)+16 {
+		return APIToken{}, fmt.Errorf("token must start with %s and carry at least 16 random characters: %w", APITokenPrefix, os.ErrInvalid)
+	}
+	if len(scopes) == 0 {
+		return APIToken{}, fmt.Errorf("token needs a scope: %w", os.ErrInvalid)
+	}
+	for _, scope := range scopes {
+		if scope != TokenScopeAPI && scope != TokenScopeWebDAV {
+			return APIToken{}, fmt.Errorf("unknown token scope %q: %w", scope, os.ErrInvalid)
+		}
+	}
+	var exp any
+	if expiresAt != nil {
+		exp = expiresAt.UTC()
+	}
+	prefix := token[:len(APITokenPrefix)+4]
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO api_tokens(user_id, name, token_hash, prefix, scopes, read_only, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, name, hashAPIToken(token), prefix, strings.Join(scopes, ","), readOnly, exp, now())
+	if err != nil {
+		return APIToken{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return APIToken{}, err
+	}
+	var t APIToken
+	row := s.DB.QueryRowContext(ctx, `SELECT `+apiTokenColumns+` FROM api_tokens WHERE id = ?`, id)
+	if err := scanAPIToken(row, &t); err != nil {
+		return APIToken{}, err
+	}
+	detail := strings.Join(scopes, ", ")
+	if readOnly {
+		detail += ", read-only"
+	}
+	if expiresAt != nil {
+		detail += ", expires " + expiresAt.UTC().Format(time.RFC3339)
+	}
+	s.audit(ctx, userID, AuditAuthTokenAdd, id, name, detail)
+	return t, nil
+}
+
+// VerifyAPIToken looks up a token accepted for scope. It reports false for
+// unknown, expired or out-of-scope tokens, and records when a valid one was
+// last used.
+func (s *Store) VerifyAPIToken(ctx context.Context, token, scope string) (APIToken, bool, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return APIToken{}, false, nil
+	}
+	var t APIToken
+	row := s.DB.QueryRowContext(ctx, `SELECT `+apiTokenColumns+` FROM api_tokens WHERE token_hash = ?`, hashAPIToken(token))
+	if err := scanAPIToken(row, &t); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIToken{}, false, nil
+		}
+		return APIToken{}, false, err
+	}
+	current := time.Now().UTC()
+	if t.ExpiresAt.Valid && !current.Before(t.ExpiresAt.Time) {
+		return APIToken{}, false, nil
+	}
+	if !t.HasScope(scope) {
+		return APIToken{}, false, nil
+	}
+	if !t.LastUsedAt.Valid || current.Sub(t.LastUsedAt.Time) >= apiTokenTouchInterval {
+		if _, err := s.DB.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, current, t.ID); err != nil {
+			return APIToken{}, false, err
+		}
+		t.LastUsedAt = sql.NullTime{Time: current, Valid: true}
+	}
+	return t, true, nil
+}
+
+// ListAPITokens lists a user's tokens, expired ones included, oldest first.
+func (s *Store) ListAPITokens(ctx context.Context, userID int64) ([]APIToken, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []APIToken
+	for rows.Next() {
+		var t APIToken
+		if err := scanAPIToken(rows, &t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// RevokeAPIToken deletes one of a user's tokens.
+func (s *Store) RevokeAPIToken(ctx context.Context, userID, tokenID int64) error {
+	var name string
+	if err := s.DB.QueryRowContext(ctx, `SELECT name FROM api_tokens WHERE id = ? AND user_id = ?`, tokenID, userID).Scan(&name); err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	s.audit(ctx, userID, AuditAuthTokenDrop, tokenID, name, "")
+	return nil
+}
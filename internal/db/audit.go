@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// Audit sources name the frontend a change came through.
+const (
+	AuditSourceBot    = "bot"
+	AuditSourceWebDAV = "webdav"
+	AuditSourceAPI    = "api"
+	// AuditSourceCLI marks changes made with "pigpak db".
+	AuditSourceCLI = "cli"
+	// AuditSourceSystem marks changes no user asked for, and is the
+	// source of contexts without an actor.
+	AuditSourceSystem = "system"
+)
+
+// Audit actions.
+const (
+	AuditDirCreate      = "dir.create"
+	AuditDirRename      = "dir.rename"
+	AuditDirMove        = "dir.move"
+	AuditDirDelete      = "dir.delete"
+	AuditFileCreate     = "file.create"
+	AuditFileReplace    = "file.replace"
+	AuditFileRename     = "file.rename"
+	AuditFileMove       = "file.move"
+	AuditFileDelete     = "file.delete"
+	AuditTrash          = "trash.add"
+	AuditTrashRestore   = "trash.restore"
+	AuditTrashPurge     = "trash.purge"
+	AuditShareCreate    = "share.create"
+	AuditShareRevoke    = "share.revoke"
+	AuditSharePassword  = "share.password"
+	AuditDriveCreate    = "drive.create"
+	AuditDriveRename    = "drive.rename"
+	AuditDriveDelete    = "drive.delete"
+	AuditAuthLogin      = "auth.login"
+	AuditAuthFailed     = "auth.failed"
+	AuditAuthPassword   = "auth.password"
+	AuditAuthTempLogin  = "auth.temp_login"
+	AuditAuthTempDrop   = "auth.temp_revoke"
+	AuditAuthTokenAdd   = "auth.token_create"
+	AuditAuthTokenDrop  = "auth.token_revoke"
+	AuditShortcutCreate = "shortcut.create"
+	AuditShortcutDelete = "shortcut.delete"
+	AuditStorageSet     = "storage.set"
+	AuditStorageClear   = "storage.clear"
+	AuditWebhookCreate  = "webhook.create"
+	AuditWebhookDelete  = "webhook.delete"
+)
+
+// AuditEvent is one recorded change. UserID owns the data that changed and
+// ActorID made the change, which differs for uploads through file requests
+// and is 0 for the system.
+type AuditEvent struct {
+	ID        int64
+	UserID    int64
+	ActorID   int64
+	Source    string
+	Action    string
+	TargetID  int64
+	Target    string
+	Detail    string
+	CreatedAt time.Time
+}
+
+// AuditQuery filters ListAudit. Zero fields match everything.
+type AuditQuery struct {
+	UserID int64
+	// Action matches an action exactly, or a group of them when it ends
+	// in "." (for example "file.").
+	Action string
+	Since  time.Time
+	// BeforeID pages backwards from an earlier result.
+	BeforeID int64
+	// Limit defaults to 50 and is capped at 500.
+	Limit int
+}
+
+type auditActorKey struct{}
+
+type auditActor struct {
+	id     int64
+	source string
+}
+
+// WithActor tags ctx with who is acting and through which frontend, so the
+// changes made with it are attributed in the audit log.
+func WithActor(ctx context.Context, source string, actorID int64) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, auditActor{id: actorID, source: source})
+}
+
+func actorFrom(ctx context.Context) auditActor {
+	if actor, ok := ctx.Value(auditActorKey{}).(auditActor); ok {
+		return actor
+	}
+	return auditActor{source: AuditSourceSystem}
+}
+
+// RecordAudit stores ev. An empty Source takes the actor and source ctx
+// carries.
+func (s *Store) RecordAudit(ctx context.Context, ev AuditEvent) error {
+	if ev.Source == "" {
+		actor := actorFrom(ctx)
+		ev.Source, ev.ActorID = actor.source, actor.id
+	}
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO audit_log(user_id, actor_id, source, action, target_id, target, detail, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		ev.UserID, ev.ActorID, ev.Source, ev.Action, ev.TargetID, ev.Target, ev.Detail, now())
+	return err
+}
+
+// audit records a change that already happened. A failure to record it
+// must not undo or fail the change, so it is only logged.
+func (s *Store) audit(ctx context.Context, userID int64, action string, targetID int64, target, detail string) {
+	err := s.RecordAudit(ctx, AuditEvent{UserID: userID, Action: action, TargetID: targetID, Target: target, Detail: detail})
+	if err != nil {
+		log.Printf("audit %s on %d: %v", action, targetID, err)
+	}
+}
+
+// auditFile records a change to fileID for whoever owns it.
+func (s *Store) auditFile(ctx context.Context, fileID int64, action, detail string) {
+	actor := actorFrom(ctx)
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO audit_log(user_id, actor_id, source, action, target_id, target, detail, created_at)
+		SELECT user_id, ?, ?, ?, id, name, ?, ? FROM files WHERE id = ?`,
+		actor.id, actor.source, action, detail, now(), fileID)
+	if err != nil {
+		log.Printf("audit %s on %d: %v", action, fileID, err)
+	}
+}
+
+// ListAudit returns matching events, newest first.
+func (s *Store) ListAudit(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	var where []string
+	var args []any
+	if q.UserID != 0 {
+		where = append(where, `user_id = ?`)
+		args = append(args, q.UserID)
+	}
+	if strings.HasSuffix(q.Action, ".") {
+		where = append(where, `substr(action, 1, ?) = ?`)
+		args = append(args, len(q.Action), q.Action)
+	} else if q.Action != "" {
+		where = append(where, `action = ?`)
+		args = append(args, q.Action)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, `created_at >= ?`)
+		args = append(args, q.Since.UTC())
+	}
+	if q.BeforeID > 0 {
+		where = append(where, `id < ?`)
+		args = append(args, q.BeforeID)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > 500 {
+		limit = 500
+	}
+	query := `SELECT id, user_id, actor_id, source, action, target_id, target, detail, created_at FROM audit_log`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AuditEvent
+	for rows.Next() {
+		var ev AuditEvent
+		if err := rows.Scan(&ev.ID, &ev.UserID, &ev.ActorID, &ev.Source, &ev.Action, &ev.TargetID, &ev.Target, &ev.Detail, &ev.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, ev)
+	}
+	return out, rows.Err()
+}
+
+// PruneAudit drops events older than olderThan and returns how many went.
+func (s *Store) PruneAudit(ctx context.Context, olderThan time.Duration) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM audit_log WHERE created_at < ?`, now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func shareAuditDetail(expiresAt *time.Time, recipient string) string {
+	detail := "no expiry"
+	if expiresAt != nil {
+		detail = "expires " + expiresAt.UTC().Format(time.RFC3339)
+	}
+	if recipient != "" {
+		detail += ", for " + recipient
+	}
+	return detail
+}
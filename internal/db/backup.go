@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	sqlite "modernc.org/sqlite"
+)
+
+// Backup writes a consistent copy of the database to path with SQLite's
+// online backup, so it can run while the bot and WebDAV keep writing. An
+// existing file at path is replaced.
+func (s *Store) Backup(ctx context.Context, path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		src, ok := driverConn.(interface {
+			NewBackup(string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return errors.New("sqlite driver does not support backups")
+		}
+		bk, err := src.NewBackup(path)
+		if err != nil {
+			return err
+		}
+		for more := true; more; {
+			if more, err = bk.Step(-1); err != nil {
+				_ = bk.Finish()
+				return err
+			}
+		}
+		return bk.Finish()
+	})
+}
+
+// IntegrityCheck runs SQLite's integrity check and returns its first
+// complaint, or nil when the database is sound.
+func (s *Store) IntegrityCheck(ctx context.Context) error {
+	var result string
+	if err := s.DB.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New("integrity check: " + result)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Blob is a piece of Telegram-hosted content shared by every file or part
+// that references its file_unique_id.
+type Blob struct {
+	FileUniqueID string
+	FileID       string
+	Size         int64
+	RefCount     int64
+	// StorageChatID and StorageMessageID locate the storage chat message
+	// holding the content, when pigpak uploaded it there itself.
+	StorageChatID    int64
+	StorageMessageID int
+	CreatedAt        sql.NullTime
+	UpdatedAt        sql.NullTime
+}
+
+// blobTriggers keep blobs.ref_count equal to the number of files,
+// file_parts and trash_blobs rows pointing at each blob, and drop blobs
+// nobody references. Triggers also cover rows removed through ON DELETE
+// CASCADE. A dropped blob with a known storage message queues that message
+// for deletion.
+var blobTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS trg_files_blob_insert AFTER INSERT ON files BEGIN
+		UPDATE blobs SET ref_count = ref_count + 1 WHERE file_unique_id = NEW.file_unique_id;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_files_blob_delete AFTER DELETE ON files BEGIN
+		UPDATE blobs SET ref_count = ref_count - 1 WHERE file_unique_id = OLD.file_unique_id;
+		DELETE FROM blobs WHERE file_unique_id = OLD.file_unique_id AND ref_count <= 0;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_files_blob_update AFTER UPDATE OF file_unique_id ON files
+	WHEN OLD.file_unique_id != NEW.file_unique_id BEGIN
+		UPDATE blobs SET ref_count = ref_count + 1 WHERE file_unique_id = NEW.file_unique_id;
+		UPDATE blobs SET ref_count = ref_count - 1 WHERE file_unique_id = OLD.file_unique_id;
+		DELETE FROM blobs WHERE file_unique_id = OLD.file_unique_id AND ref_count <= 0;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_parts_blob_insert AFTER INSERT ON file_parts BEGIN
+		UPDATE blobs SET ref_count = ref_count + 1 WHERE file_unique_id = NEW.file_unique_id;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_parts_blob_delete AFTER DELETE ON file_parts BEGIN
+		UPDATE blobs SET ref_count = ref_count - 1 WHERE file_unique_id = OLD.file_unique_id;
+		DELETE FROM blobs WHERE file_unique_id = OLD.file_unique_id AND ref_count <= 0;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_trash_blob_insert AFTER INSERT ON trash_blobs BEGIN
+		UPDATE blobs SET ref_count = ref_count + 1 WHERE file_unique_id = NEW.file_unique_id;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_trash_blob_delete AFTER DELETE ON trash_blobs BEGIN
+		UPDATE blobs SET ref_count = ref_count - 1 WHERE file_unique_id = OLD.file_unique_id;
+		DELETE FROM blobs WHERE file_unique_id = OLD.file_unique_id AND ref_count <= 0;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_blobs_storage_delete AFTER DELETE ON blobs
+	WHEN OLD.storage_message_id != 0 BEGIN
+		INSERT INTO storage_deletions(chat_id, message_id) VALUES (OLD.storage_chat_id, OLD.storage_message_id);
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_blobs_copies_delete AFTER DELETE ON blobs BEGIN
+		INSERT INTO storage_deletions(chat_id, message_id)
+			SELECT chat_id, message_id FROM blob_copies WHERE file_unique_id = OLD.file_unique_id;
+		DELETE FROM blob_copies WHERE file_unique_id = OLD.file_unique_id;
+	END;`,
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// putBlobTx registers content before a row referencing it is inserted. The
+// reference itself is counted by the insert trigger. A newer file_id for the
+// same content replaces the stored one. Empty files have no content to
+// register.
+func putBlobTx(ctx context.Context, tx execer, fileUniqueID, fileID string, size int64) error {
+	if fileUniqueID == "" {
+		return nil
+	}
+	createdAt := now()
+	_, err := tx.ExecContext(ctx, `INSERT INTO blobs(file_unique_id, file_id, size, ref_count, created_at, updated_at)
+		VALUES (?, ?, ?, 0, ?, ?)
+		ON CONFLICT(file_unique_id) DO UPDATE SET
+			file_id = CASE WHEN excluded.file_id != '' THEN excluded.file_id ELSE blobs.file_id END,
+			size = CASE WHEN blobs.size = 0 THEN excluded.size ELSE blobs.size END,
+			updated_at = excluded.updated_at`,
+		fileUniqueID, fileID, size, createdAt, createdAt)
+	return err
+}
+
+func putPartBlobsTx(ctx context.Context, tx execer, parts []FilePartInput) error {
+	for _, part := range parts {
+		if err := putBlobTx(ctx, tx, part.FileUniqueID, part.TelegramFileID, part.Size); err != nil {
+			return err
+		}
+		if err := setBlobStorageTx(ctx, tx, part.FileUniqueID, part.StorageChatID, part.StorageMessageID); err != nil {
+			return err
+		}
+		if err := setBlobChecksumTx(ctx, tx, part.FileUniqueID, part.SHA256); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBlobStorageTx records the storage chat message behind a blob. The first
+// known message stays the blob's location and the first message in every
+// other chat is kept as a copy; a second message in a chat that already
+// holds the content is redundant and queued for deletion.
+func setBlobStorageTx(ctx context.Context, tx execer, fileUniqueID string, chatID int64, messageID int) error {
+	if messageID == 0 {
+		return nil
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE blobs SET storage_chat_id = ?, storage_message_id = ? WHERE file_unique_id = ? AND storage_message_id = 0`, chatID, messageID, fileUniqueID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	res, err = tx.ExecContext(ctx, `INSERT INTO blob_copies(file_unique_id, chat_id, message_id)
+		SELECT file_unique_id, ?, ? FROM blobs WHERE file_unique_id = ? AND storage_chat_id != ?
+		ON CONFLICT(file_unique_id, chat_id) DO NOTHING`, chatID, messageID, fileUniqueID, chatID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO storage_deletions(chat_id, message_id)
+		SELECT ?, ? WHERE NOT EXISTS (
+			SELECT 1 FROM blobs WHERE file_unique_id = ? AND storage_chat_id = ? AND storage_message_id = ?
+			UNION ALL
+			SELECT 1 FROM blob_copies WHERE file_unique_id = ? AND chat_id = ? AND message_id = ?
+		)`, chatID, messageID, fileUniqueID, chatID, messageID, fileUniqueID, chatID, messageID)
+	return err
+}
+
+// storedMessageTx returns where content posted as messageID in chatID ended
+// up: the message itself, or the one already holding the content there when
+// setBlobStorageTx queued it as redundant. Zero ids stay zero.
+func storedMessageTx(ctx context.Context, tx *sql.Tx, fileUniqueID string, chatID int64, messageID int) (int64, int, error) {
+	if messageID == 0 {
+		return 0, 0, nil
+	}
+	var kept int
+	err := tx.QueryRowContext(ctx, `SELECT storage_message_id FROM blobs WHERE file_unique_id = ? AND storage_chat_id = ? AND storage_message_id != 0
+		UNION ALL
+		SELECT message_id FROM blob_copies WHERE file_unique_id = ? AND chat_id = ?
+		LIMIT 1`, fileUniqueID, chatID, fileUniqueID, chatID).Scan(&kept)
+	if errors.Is(err, sql.ErrNoRows) {
+		return chatID, messageID, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return chatID, kept, nil
+}
+
+// BlobMessageIn returns the storage chat message holding content in chatID,
+// or 0 when pigpak has not stored it there.
+func (s *Store) BlobMessageIn(ctx context.Context, fileUniqueID string, chatID int64) (int, error) {
+	var messageID int
+	err := s.DB.QueryRowContext(ctx, `SELECT storage_message_id FROM blobs WHERE file_unique_id = ? AND storage_chat_id = ? AND storage_message_id != 0
+		UNION ALL
+		SELECT message_id FROM blob_copies WHERE file_unique_id = ? AND chat_id = ?
+		LIMIT 1`, fileUniqueID, chatID, fileUniqueID, chatID).Scan(&messageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return messageID, err
+}
+
+// GetBlob loads a blob by its Telegram file_unique_id.
+func (s *Store) GetBlob(ctx context.Context, fileUniqueID string) (Blob, error) {
+	var b Blob
+	row := s.DB.QueryRowContext(ctx, `SELECT file_unique_id, file_id, size, ref_count, storage_chat_id, storage_message_id, created_at, updated_at FROM blobs WHERE file_unique_id = ?`, fileUniqueID)
+	if err := row.Scan(&b.FileUniqueID, &b.FileID, &b.Size, &b.RefCount, &b.StorageChatID, &b.StorageMessageID, &b.CreatedAt, &b.UpdatedAt); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// migrateBlobs moves Telegram file_ids out of files and file_parts into the
+// blobs table for databases created before content deduplication.
+func (s *Store) migrateBlobs(ctx context.Context) error {
+	filesLegacy, err := s.hasColumn(ctx, "files", "file_id")
+	if err != nil {
+		return err
+	}
+	partsLegacy, err := s.hasColumn(ctx, "file_parts", "telegram_file_id")
+	if err != nil {
+		return err
+	}
+	if !filesLegacy && !partsLegacy {
+		return nil
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+	createdAt := now()
+	statements := []string{}
+	if partsLegacy {
+		statements = append(statements,
+			`INSERT OR IGNORE INTO blobs(file_unique_id, file_id, size, ref_count, created_at, updated_at)
+				SELECT file_unique_id, telegram_file_id, size, 0, ?, ? FROM file_parts`,
+		)
+	}
+	if filesLegacy {
+		statements = append(statements,
+			`INSERT OR IGNORE INTO blobs(file_unique_id, file_id, size, ref_count, created_at, updated_at)
+				SELECT file_unique_id, file_id, size, 0, ?, ? FROM files`,
+		)
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt, createdAt, createdAt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE blobs SET ref_count =
+		(SELECT COUNT(*) FROM files WHERE files.file_unique_id = blobs.file_unique_id) +
+		(SELECT COUNT(*) FROM file_parts WHERE file_parts.file_unique_id = blobs.file_unique_id) +
+		(SELECT COUNT(*) FROM trash_blobs WHERE trash_blobs.file_unique_id = blobs.file_unique_id)`); err != nil {
+		return err
+	}
+	if filesLegacy {
+		if _, err := tx.ExecContext(ctx, `ALTER TABLE files DROP COLUMN file_id`); err != nil {
+			return err
+		}
+	}
+	if partsLegacy {
+		if _, err := tx.ExecContext(ctx, `ALTER TABLE file_parts DROP COLUMN telegram_file_id`); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
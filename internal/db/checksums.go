@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// setBlobChecksumTx records the SHA-256 of a blob's content. The first
+// recorded checksum stays; a later, different one is left to Verify to
+// report rather than silently replaced.
+func setBlobChecksumTx(ctx context.Context, tx execer, fileUniqueID, sum string) error {
+	if sum == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE blobs SET sha256 = ? WHERE file_unique_id = ? AND sha256 = ''`, sum, fileUniqueID)
+	return err
+}
+
+// setSingleBlobChecksumTx records the checksum of a file stored as one blob,
+// which callers pass as its only part.
+func setSingleBlobChecksumTx(ctx context.Context, tx execer, fileUniqueID string, parts []FilePartInput) error {
+	if len(parts) != 1 || parts[0].FileUniqueID != fileUniqueID {
+		return nil
+	}
+	return setBlobChecksumTx(ctx, tx, fileUniqueID, parts[0].SHA256)
+}
+
+// SetBlobChecksum records the SHA-256 of content that has none yet.
+func (s *Store) SetBlobChecksum(ctx context.Context, fileUniqueID, sum string) error {
+	if err := checkSHA256(sum); err != nil {
+		return err
+	}
+	return setBlobChecksumTx(ctx, s.DB, fileUniqueID, sum)
+}
+
+// SetFileChecksum records the SHA-256 of a file's whole content.
+func (s *Store) SetFileChecksum(ctx context.Context, userID, fileID int64, sum string) error {
+	if err := checkSHA256(sum); err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `UPDATE files SET sha256 = ? WHERE id = ? AND user_id = ?`, sum, fileID, userID)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func checkSHA256(sum string) error {
+	if raw, err := hex.DecodeString(sum); err != nil || len(raw) != sha256.Size {
+		return fmt.Errorf("checksum %q is not a hex SHA-256: %w", sum, os.ErrInvalid)
+	}
+	return nil
+}
+
+// sumFromState finishes a marshaled SHA-256 state, or returns "" when there
+// is none.
+func sumFromState(state []byte) string {
+	if len(state) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Kinds of entity a DAVProperty is attached to.
+const (
+	DAVPropertyFile = "file"
+	DAVPropertyDir  = "dir"
+)
+
+// DAVProperty is a WebDAV dead property a client set with PROPPATCH. Value
+// is the property element's inner XML as the client sent it.
+type DAVProperty struct {
+	Namespace string
+	Name      string
+	Lang      string
+	Value     string
+}
+
+// davPropertyTriggers drop the properties of deleted files and folders;
+// properties point at either table, so no foreign key can.
+var davPropertyTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS trg_dav_properties_file AFTER DELETE ON files BEGIN
+		DELETE FROM dav_properties WHERE entity = '` + DAVPropertyFile + `' AND entity_id = OLD.id;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_dav_properties_dir AFTER DELETE ON directories BEGIN
+		DELETE FROM dav_properties WHERE entity = '` + DAVPropertyDir + `' AND entity_id = OLD.id;
+	END;`,
+}
+
+// ListDAVProperties returns the dead properties of a file or folder.
+func (s *Store) ListDAVProperties(ctx context.Context, entity string, id int64) ([]DAVProperty, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT namespace, name, lang, value FROM dav_properties
+		WHERE entity = ? AND entity_id = ? ORDER BY namespace, name`, entity, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []DAVProperty
+	for rows.Next() {
+		var p DAVProperty
+		if err := rows.Scan(&p.Namespace, &p.Name, &p.Lang, &p.Value); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// PatchDAVProperties sets and removes dead properties of a file or folder
+// in one transaction, as PROPPATCH requires: all of the changes are made or
+// none. Removing a property that is not set is not an error.
+func (s *Store) PatchDAVProperties(ctx context.Context, entity string, id int64, set, remove []DAVProperty) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, p := range remove {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM dav_properties WHERE entity = ? AND entity_id = ? AND namespace = ? AND name = ?`,
+			entity, id, p.Namespace, p.Name); err != nil {
+			return err
+		}
+	}
+	for _, p := range set {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO dav_properties(entity, entity_id, namespace, name, lang, value, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(entity, entity_id, namespace, name) DO UPDATE SET lang = excluded.lang, value = excluded.value, updated_at = excluded.updated_at`,
+			entity, id, p.Namespace, p.Name, p.Lang, p.Value, now()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetFileModifiedAt records when a client says the file was last modified,
+// for sync tools that carry modification times over. Replacing the file's
+// content later stamps the time of the replacement over it.
+func (s *Store) SetFileModifiedAt(ctx context.Context, userID, fileID int64, modifiedAt time.Time) error {
+	res, err := s.DB.ExecContext(ctx, `UPDATE files SET modified_at = ? WHERE id = ? AND user_id = ?`, modifiedAt.UTC(), fileID, userID)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
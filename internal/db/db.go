@@ -14,12 +14,26 @@ type Store struct {
 	DB *sql.DB
 }
 
+// maxOpenConns bounds the connection pool. WAL lets readers run beside the
+// one writer; more connections would only queue on the write lock.
+const maxOpenConns = 8
+
+// dsnParams apply to every pooled connection. WAL with synchronous=NORMAL
+// keeps readers off the writer's back and stays durable across process
+// crashes. Transactions start IMMEDIATE so one that reads before writing
+// waits out busy_timeout for the write lock instead of failing with
+// SQLITE_BUSY when another writer got there first.
+const dsnParams = "_pragma=foreign_keys(1)&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_txlock=immediate"
+
 // Open opens the SQLite database and runs migrations.
 func Open(path string) (*Store, error) {
-	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)", path))
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?%s", path, dsnParams))
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxOpenConns)
+	db.SetConnMaxIdleTime(5 * time.Minute)
 	if err := db.Ping(); err != nil {
 		_ = db.Close()
 		return nil, err
@@ -40,33 +54,90 @@ func (s *Store) Close() error {
 	return s.DB.Close()
 }
 
-// Migrate applies schema changes.
-func (s *Store) Migrate(ctx context.Context) error {
+// migrateBaseline creates the schema as it stood before versioned
+// migrations, upgrading databases from any earlier release in place. Every
+// step is idempotent, so it is safe on databases that predate
+// schema_version.
+func (s *Store) migrateBaseline(ctx context.Context) error {
 	statements := []string{
 		`PRAGMA foreign_keys = ON;`,
 		`CREATE TABLE IF NOT EXISTS users (
 			user_id INTEGER PRIMARY KEY,
-			created_at TIMESTAMP NOT NULL
+			created_at TIMESTAMP NOT NULL,
+			onboarded_at TIMESTAMP
 		);`,
 		`CREATE TABLE IF NOT EXISTS directories (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id INTEGER NOT NULL,
 			parent_id INTEGER,
 			name TEXT NOT NULL,
+			public_id TEXT NOT NULL DEFAULT '',
 			created_at TIMESTAMP NOT NULL,
 			updated_at TIMESTAMP NOT NULL,
 			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
 			FOREIGN KEY(parent_id) REFERENCES directories(id) ON DELETE CASCADE
 		);`,
+		`CREATE TABLE IF NOT EXISTS drives (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			root_dir_id INTEGER NOT NULL UNIQUE,
+			label TEXT NOT NULL,
+			storage_chat_id INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+			FOREIGN KEY(root_dir_id) REFERENCES directories(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			expr TEXT NOT NULL,
+			target_dir_id INTEGER NOT NULL,
+			apply_webdav INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+			FOREIGN KEY(target_dir_id) REFERENCES directories(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS pages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			dir_id INTEGER NOT NULL,
+			token TEXT NOT NULL UNIQUE,
+			html TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+			FOREIGN KEY(dir_id) REFERENCES directories(id) ON DELETE CASCADE,
+			UNIQUE(user_id, dir_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS file_requests (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			dir_id INTEGER NOT NULL,
+			token TEXT NOT NULL UNIQUE,
+			expires_at TIMESTAMP,
+			uploads INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+			FOREIGN KEY(dir_id) REFERENCES directories(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS file_request_sessions (
+			uploader_id INTEGER PRIMARY KEY,
+			request_id INTEGER NOT NULL,
+			started_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(request_id) REFERENCES file_requests(id) ON DELETE CASCADE
+		);`,
 		`CREATE TABLE IF NOT EXISTS files (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id INTEGER NOT NULL,
 			dir_id INTEGER NOT NULL,
 			name TEXT NOT NULL,
-			file_id TEXT NOT NULL,
 			file_unique_id TEXT NOT NULL,
 			size INTEGER NOT NULL,
 			mime_type TEXT NOT NULL,
+			public_id TEXT NOT NULL DEFAULT '',
+			ref_state TEXT NOT NULL DEFAULT '',
+			ref_checked_at TIMESTAMP,
 			created_at TIMESTAMP NOT NULL,
 			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
 			FOREIGN KEY(dir_id) REFERENCES directories(id) ON DELETE CASCADE
@@ -75,13 +146,22 @@ func (s *Store) Migrate(ctx context.Context) error {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			file_id INTEGER NOT NULL,
 			part_index INTEGER NOT NULL,
-			telegram_file_id TEXT NOT NULL,
 			file_unique_id TEXT NOT NULL,
 			size INTEGER NOT NULL,
 			created_at TIMESTAMP NOT NULL,
 			FOREIGN KEY(file_id) REFERENCES files(id) ON DELETE CASCADE,
 			UNIQUE(file_id, part_index)
 		);`,
+		`CREATE TABLE IF NOT EXISTS blobs (
+			file_unique_id TEXT PRIMARY KEY,
+			file_id TEXT NOT NULL,
+			size INTEGER NOT NULL DEFAULT 0,
+			ref_count INTEGER NOT NULL DEFAULT 0,
+			storage_chat_id INTEGER NOT NULL DEFAULT 0,
+			storage_message_id INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);`,
 		`CREATE TABLE IF NOT EXISTS webdav_uploads (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_id INTEGER NOT NULL,
@@ -103,6 +183,8 @@ func (s *Store) Migrate(ctx context.Context) error {
 			telegram_file_id TEXT NOT NULL,
 			file_unique_id TEXT NOT NULL,
 			size INTEGER NOT NULL,
+			storage_chat_id INTEGER NOT NULL DEFAULT 0,
+			storage_message_id INTEGER NOT NULL DEFAULT 0,
 			created_at TIMESTAMP NOT NULL,
 			FOREIGN KEY(upload_id) REFERENCES webdav_uploads(id) ON DELETE CASCADE,
 			UNIQUE(upload_id, part_index)
@@ -113,6 +195,8 @@ func (s *Store) Migrate(ctx context.Context) error {
 			token TEXT NOT NULL UNIQUE,
 			expires_at TIMESTAMP,
 			uses INTEGER NOT NULL DEFAULT 0,
+			recipient_id INTEGER NOT NULL DEFAULT 0,
+			recipient_username TEXT NOT NULL DEFAULT '',
 			created_at TIMESTAMP NOT NULL,
 			FOREIGN KEY(file_id) REFERENCES files(id) ON DELETE CASCADE
 		);`,
@@ -140,6 +224,73 @@ func (s *Store) Migrate(ctx context.Context) error {
 			updated_at TIMESTAMP NOT NULL,
 			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE
 		);`,
+		`CREATE TABLE IF NOT EXISTS webdav_temp_credentials (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			username TEXT NOT NULL UNIQUE,
+			password_salt TEXT NOT NULL,
+			password_hash TEXT NOT NULL,
+			read_only INTEGER NOT NULL DEFAULT 0,
+			scope_dir_id INTEGER,
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+			FOREIGN KEY(scope_dir_id) REFERENCES directories(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			chat_id INTEGER NOT NULL,
+			text TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS favorites (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			dir_id INTEGER NOT NULL,
+			label TEXT NOT NULL,
+			position INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+			FOREIGN KEY(dir_id) REFERENCES directories(id) ON DELETE CASCADE,
+			UNIQUE(user_id, dir_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS trash_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			name TEXT NOT NULL,
+			original_root_id INTEGER NOT NULL DEFAULT 0,
+			original_parent_id INTEGER NOT NULL DEFAULT 0,
+			original_path TEXT NOT NULL DEFAULT '/',
+			size INTEGER NOT NULL DEFAULT 0,
+			files INTEGER NOT NULL DEFAULT 0,
+			payload TEXT NOT NULL,
+			deleted_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS trash_blobs (
+			trash_id INTEGER NOT NULL,
+			file_unique_id TEXT NOT NULL,
+			FOREIGN KEY(trash_id) REFERENCES trash_items(id) ON DELETE CASCADE,
+			UNIQUE(trash_id, file_unique_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS blob_copies (
+			file_unique_id TEXT NOT NULL,
+			chat_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			UNIQUE(file_unique_id, chat_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS storage_deletions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT ''
+		);`,
 		`CREATE INDEX IF NOT EXISTS idx_dirs_parent ON directories(user_id, parent_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_files_dir ON files(user_id, dir_id);`,
 		`CREATE UNIQUE INDEX IF NOT EXISTS idx_user_profiles_username_lower ON user_profiles(username_lower);`,
@@ -154,6 +305,132 @@ func (s *Store) Migrate(ctx context.Context) error {
 			return err
 		}
 	}
+	if err := s.addColumnIfMissing(ctx, "directories", "public_id", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing(ctx, "files", "public_id", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing(ctx, "files", "ref_state", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing(ctx, "files", "ref_checked_at", `TIMESTAMP`); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing(ctx, "shares", "recipient_id", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing(ctx, "shares", "recipient_username", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing(ctx, "drives", "storage_chat_id", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	for _, table := range []string{"blobs", "webdav_upload_parts"} {
+		if err := s.addColumnIfMissing(ctx, table, "storage_chat_id", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+			return err
+		}
+		if err := s.addColumnIfMissing(ctx, table, "storage_message_id", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+			return err
+		}
+	}
+	if err := s.migrateOnboarding(ctx); err != nil {
+		return err
+	}
+	if err := s.backfillDrives(ctx); err != nil {
+		return err
+	}
+	if err := s.backfillPublicIDs(ctx, "directories"); err != nil {
+		return err
+	}
+	if err := s.backfillPublicIDs(ctx, "files"); err != nil {
+		return err
+	}
+	if err := s.migrateBlobs(ctx); err != nil {
+		return err
+	}
+	indexes := []string{
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_dirs_public_id ON directories(public_id);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_files_public_id ON files(public_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_files_blob ON files(file_unique_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_parts_blob ON file_parts(file_unique_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_drives_user ON drives(user_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_rules_user ON rules(user_id, position);`,
+		`CREATE INDEX IF NOT EXISTS idx_file_requests_dir ON file_requests(user_id, dir_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_webdav_temp_credentials_user ON webdav_temp_credentials(user_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_trash_items_user ON trash_items(user_id, deleted_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_trash_blobs_blob ON trash_blobs(file_unique_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_blob_copies_chat ON blob_copies(chat_id);`,
+	}
+	indexes = append(indexes, blobTriggers...)
+	for _, stmt := range indexes {
+		if _, err := s.DB.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to an existing table created by an older schema.
+func (s *Store) addColumnIfMissing(ctx context.Context, table, column, definition string) error {
+	exists, err := s.hasColumn(ctx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+// hasColumn reports whether a table currently has the named column.
+func (s *Store) hasColumn(ctx context.Context, table, column string) (bool, error) {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// backfillPublicIDs assigns UUIDs to rows created before public IDs existed.
+func (s *Store) backfillPublicIDs(ctx context.Context, table string) error {
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`SELECT id FROM %s WHERE public_id = ''`, table))
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := s.DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET public_id = ? WHERE id = ?`, table), newPublicID(), id); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// DirTotals counts everything below a folder, at any depth.
+type DirTotals struct {
+	Dirs  int64
+	Files int64
+	Bytes int64
+}
+
+// ancestorsOf selects the folder dirExpr and every folder above it.
+func ancestorsOf(dirExpr string) string {
+	return `(WITH RECURSIVE up(id) AS (
+			SELECT ` + dirExpr + `
+			UNION ALL
+			SELECT d.parent_id FROM directories d JOIN up ON d.id = up.id WHERE d.parent_id IS NOT NULL
+		) SELECT id FROM up)`
+}
+
+// dirTotalsTriggers keep directories.size_bytes, file_count and dir_count
+// equal to the totals of each folder's subtree. A change is applied to the
+// folder it happens in and all of its ancestors; rows removed by ON DELETE
+// CASCADE below a deleted folder no longer find their ancestors, so only
+// the deleted folder's own totals are taken off the folders above it.
+var dirTotalsTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS trg_files_totals_insert AFTER INSERT ON files BEGIN
+		UPDATE directories SET size_bytes = size_bytes + NEW.size, file_count = file_count + 1
+		WHERE id IN ` + ancestorsOf("NEW.dir_id") + `;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_files_totals_delete AFTER DELETE ON files BEGIN
+		UPDATE directories SET size_bytes = size_bytes - OLD.size, file_count = file_count - 1
+		WHERE id IN ` + ancestorsOf("OLD.dir_id") + `;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_files_totals_update AFTER UPDATE OF dir_id, size ON files
+	WHEN OLD.dir_id != NEW.dir_id OR OLD.size != NEW.size BEGIN
+		UPDATE directories SET size_bytes = size_bytes - OLD.size, file_count = file_count - 1
+		WHERE id IN ` + ancestorsOf("OLD.dir_id") + `;
+		UPDATE directories SET size_bytes = size_bytes + NEW.size, file_count = file_count + 1
+		WHERE id IN ` + ancestorsOf("NEW.dir_id") + `;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_dirs_totals_insert AFTER INSERT ON directories
+	WHEN NEW.parent_id IS NOT NULL BEGIN
+		UPDATE directories SET size_bytes = size_bytes + NEW.size_bytes, file_count = file_count + NEW.file_count, dir_count = dir_count + NEW.dir_count + 1
+		WHERE id IN ` + ancestorsOf("NEW.parent_id") + `;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_dirs_totals_delete AFTER DELETE ON directories
+	WHEN OLD.parent_id IS NOT NULL BEGIN
+		UPDATE directories SET size_bytes = size_bytes - OLD.size_bytes, file_count = file_count - OLD.file_count, dir_count = dir_count - OLD.dir_count - 1
+		WHERE id IN ` + ancestorsOf("OLD.parent_id") + `;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_dirs_totals_move AFTER UPDATE OF parent_id ON directories
+	WHEN OLD.parent_id IS NOT NEW.parent_id BEGIN
+		UPDATE directories SET size_bytes = size_bytes - OLD.size_bytes, file_count = file_count - OLD.file_count, dir_count = dir_count - OLD.dir_count - 1
+		WHERE OLD.parent_id IS NOT NULL AND id IN ` + ancestorsOf("OLD.parent_id") + `;
+		UPDATE directories SET size_bytes = size_bytes + NEW.size_bytes, file_count = file_count + NEW.file_count, dir_count = dir_count + NEW.dir_count + 1
+		WHERE NEW.parent_id IS NOT NULL AND id IN ` + ancestorsOf("NEW.parent_id") + `;
+	END;`,
+}
+
+// backfillDirTotals recomputes every folder's totals from scratch.
+var backfillDirTotals = []string{
+	`UPDATE directories SET size_bytes = 0, file_count = 0, dir_count = 0;`,
+	`WITH RECURSIVE anc(dir_id, ancestor) AS (
+		SELECT id, id FROM directories
+		UNION ALL
+		SELECT anc.dir_id, d.parent_id FROM anc JOIN directories d ON d.id = anc.ancestor WHERE d.parent_id IS NOT NULL
+	), below AS (
+		SELECT ancestor AS id, COUNT(*) - 1 AS dirs FROM anc GROUP BY ancestor
+	) UPDATE directories SET dir_count = below.dirs FROM below WHERE below.id = directories.id;`,
+	`WITH RECURSIVE anc(dir_id, ancestor) AS (
+		SELECT id, id FROM directories
+		UNION ALL
+		SELECT anc.dir_id, d.parent_id FROM anc JOIN directories d ON d.id = anc.ancestor WHERE d.parent_id IS NOT NULL
+	), inside AS (
+		SELECT anc.ancestor AS id, COUNT(*) AS files, SUM(f.size) AS bytes FROM files f JOIN anc ON anc.dir_id = f.dir_id GROUP BY anc.ancestor
+	) UPDATE directories SET file_count = inside.files, size_bytes = inside.bytes FROM inside WHERE inside.id = directories.id;`,
+}
+
+// GetDirTotals returns the cached totals of dirID's subtree, not counting
+// dirID itself.
+func (s *Store) GetDirTotals(ctx context.Context, userID, dirID int64) (DirTotals, error) {
+	var t DirTotals
+	err := s.DB.QueryRowContext(ctx, `SELECT dir_count, file_count, size_bytes FROM directories WHERE id = ? AND user_id = ?`, dirID, userID).Scan(&t.Dirs, &t.Files, &t.Bytes)
+	return t, err
+}
+
+// subtreeUsage counts the folders below dirID (excluding dirID itself) and
+// the files and bytes anywhere inside it; an unknown folder counts nothing.
+func (s *Store) subtreeUsage(ctx context.Context, userID, dirID int64, dirs, files, bytes *int64) error {
+	t, err := s.GetDirTotals(ctx, userID, dirID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	*dirs, *files, *bytes = t.Dirs, t.Files, t.Bytes
+	return nil
+}
@@ -0,0 +1,289 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// LostAndFoundDir is the root folder RepairIntegrity moves files and folders
+// into when the folder they were in is gone or belongs to someone else.
+const LostAndFoundDir = "Lost and found"
+
+// doctorExamples caps the row ids an IntegrityProblem lists.
+const doctorExamples = 5
+
+// IntegrityProblem is one kind of inconsistency CheckIntegrity found, with
+// how many rows have it and the ids of the first few.
+type IntegrityProblem struct {
+	Check       string
+	Description string
+	Count       int64
+	IDs         []int64
+	// Repaired counts the rows RepairIntegrity fixed.
+	Repaired int64
+}
+
+// integrityCheck finds rows that break an invariant the schema cannot
+// enforce, or that an older database written without foreign keys may
+// break. find selects the ids of the affected rows; repair fixes exactly
+// those rows and returns how many it changed.
+type integrityCheck struct {
+	name        string
+	description string
+	find        string
+	repair      func(ctx context.Context, tx *sql.Tx, ids []int64) (int64, error)
+}
+
+// integrityChecks run in order; drives and roots come first so the later
+// repairs have a root folder to file things under, and blob counts come
+// last because the other repairs change them.
+var integrityChecks = []integrityCheck{
+	{
+		name:        "drive-root",
+		description: "drives whose root folder is missing, not a root or another user's",
+		find: `SELECT d.id FROM drives d LEFT JOIN directories r ON r.id = d.root_dir_id
+			WHERE r.id IS NULL OR r.parent_id IS NOT NULL OR r.user_id != d.user_id ORDER BY d.id`,
+		repair: func(ctx context.Context, tx *sql.Tx, ids []int64) (int64, error) {
+			return execEachTx(ctx, tx, `DELETE FROM drives WHERE id = ?`, ids)
+		},
+	},
+	{
+		name:        "root-drive",
+		description: "root folders without a drive",
+		find:        `SELECT r.id FROM directories r WHERE r.parent_id IS NULL AND r.id NOT IN (SELECT root_dir_id FROM drives) ORDER BY r.id`,
+		repair: func(ctx context.Context, tx *sql.Tx, ids []int64) (int64, error) {
+			return execEachTx(ctx, tx, `INSERT INTO drives(user_id, root_dir_id, label, created_at)
+				SELECT user_id, id, 'Recovered drive ' || id, created_at FROM directories WHERE id = ?`, ids)
+		},
+	},
+	{
+		name:        "dir-parent",
+		description: "folders whose parent folder is missing or another user's",
+		find: `SELECT d.id FROM directories d LEFT JOIN directories p ON p.id = d.parent_id
+			WHERE d.parent_id IS NOT NULL AND (p.id IS NULL OR p.user_id != d.user_id) ORDER BY d.id`,
+		repair: func(ctx context.Context, tx *sql.Tx, ids []int64) (int64, error) {
+			return relocateTx(ctx, tx, "directories", "parent_id", ids)
+		},
+	},
+	{
+		name:        "file-dir",
+		description: "files whose folder is missing or another user's",
+		find: `SELECT f.id FROM files f LEFT JOIN directories d ON d.id = f.dir_id
+			WHERE d.id IS NULL OR d.user_id != f.user_id ORDER BY f.id`,
+		repair: func(ctx context.Context, tx *sql.Tx, ids []int64) (int64, error) {
+			return relocateTx(ctx, tx, "files", "dir_id", ids)
+		},
+	},
+	{
+		name:        "file-part",
+		description: "file parts whose file is missing",
+		find:        `SELECT id FROM file_parts WHERE file_id NOT IN (SELECT id FROM files) ORDER BY id`,
+		repair: func(ctx context.Context, tx *sql.Tx, ids []int64) (int64, error) {
+			return execEachTx(ctx, tx, `DELETE FROM file_parts WHERE id = ?`, ids)
+		},
+	},
+	{
+		name:        "upload-part",
+		description: "WebDAV upload parts whose upload is missing",
+		find:        `SELECT id FROM webdav_upload_parts WHERE upload_id NOT IN (SELECT id FROM webdav_uploads) ORDER BY id`,
+		repair: func(ctx context.Context, tx *sql.Tx, ids []int64) (int64, error) {
+			return execEachTx(ctx, tx, `DELETE FROM webdav_upload_parts WHERE id = ?`, ids)
+		},
+	},
+	{
+		name:        "current-dir",
+		description: "users whose current folder is missing or another user's",
+		find: `SELECT s.user_id FROM user_state s LEFT JOIN directories d ON d.id = s.current_dir_id
+			WHERE s.current_dir_id IS NOT NULL AND (d.id IS NULL OR d.user_id != s.user_id) ORDER BY s.user_id`,
+		// The bot puts users without a current folder back at their root.
+		repair: func(ctx context.Context, tx *sql.Tx, ids []int64) (int64, error) {
+			return execEachTx(ctx, tx, `UPDATE user_state SET current_dir_id = NULL WHERE user_id = ?`, ids)
+		},
+	},
+	{
+		name:        "blob-refs",
+		description: "blobs whose reference count does not match the files, parts and trash pointing at them",
+		find:        `SELECT rowid FROM blobs WHERE ref_count != ` + blobRefsExpr + ` ORDER BY rowid`,
+		// A blob nobody references goes, as the triggers would have done.
+		repair: func(ctx context.Context, tx *sql.Tx, ids []int64) (int64, error) {
+			n, err := execEachTx(ctx, tx, `UPDATE blobs SET ref_count = `+blobRefsExpr+` WHERE rowid = ?`, ids)
+			if err != nil {
+				return n, err
+			}
+			_, err = tx.ExecContext(ctx, `DELETE FROM blobs WHERE ref_count <= 0`)
+			return n, err
+		},
+	},
+}
+
+// blobRefsExpr counts the rows referencing the blob in the current row.
+const blobRefsExpr = `((SELECT COUNT(*) FROM files f WHERE f.file_unique_id = blobs.file_unique_id)
+	+ (SELECT COUNT(*) FROM file_parts p WHERE p.file_unique_id = blobs.file_unique_id)
+	+ (SELECT COUNT(*) FROM trash_blobs t WHERE t.file_unique_id = blobs.file_unique_id))`
+
+// CheckIntegrity runs every integrity check without changing anything and
+// returns the problems found.
+func (s *Store) CheckIntegrity(ctx context.Context) ([]IntegrityProblem, error) {
+	var out []IntegrityProblem
+	for _, check := range integrityChecks {
+		ids, err := queryIDs(ctx, s.DB, check.find)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", check.name, err)
+		}
+		if len(ids) > 0 {
+			out = append(out, check.problem(ids))
+		}
+	}
+	return out, nil
+}
+
+// RepairIntegrity runs every integrity check and repairs what it finds, in
+// one transaction. Misplaced files and folders are moved into their owner's
+// LostAndFoundDir; dangling rows are dropped.
+func (s *Store) RepairIntegrity(ctx context.Context) ([]IntegrityProblem, error) {
+	var out []IntegrityProblem
+	err := s.runSurgery(ctx, true, func(tx *sql.Tx) error {
+		for _, check := range integrityChecks {
+			ids, err := queryIDs(ctx, tx, check.find)
+			if err != nil {
+				return fmt.Errorf("%s: %w", check.name, err)
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			p := check.problem(ids)
+			if p.Repaired, err = check.repair(ctx, tx, ids); err != nil {
+				return fmt.Errorf("%s: %w", check.name, err)
+			}
+			out = append(out, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c integrityCheck) problem(ids []int64) IntegrityProblem {
+	p := IntegrityProblem{Check: c.name, Description: c.description, Count: int64(len(ids))}
+	p.IDs = ids[:min(len(ids), doctorExamples)]
+	return p
+}
+
+// String describes the problem on one line, e.g. for the startup log.
+func (p IntegrityProblem) String() string {
+	ids := make([]string, len(p.IDs))
+	for i, id := range p.IDs {
+		ids[i] = fmt.Sprint(id)
+	}
+	more := ""
+	if p.Count > int64(len(p.IDs)) {
+		more = ", ..."
+	}
+	return fmt.Sprintf("%s: %s: %d (ids %s%s)", p.Check, p.Description, p.Count, strings.Join(ids, ", "), more)
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func queryIDs(ctx context.Context, q queryer, query string) ([]int64, error) {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func execEachTx(ctx context.Context, tx *sql.Tx, stmt string, ids []int64) (int64, error) {
+	var n int64
+	for _, id := range ids {
+		res, err := tx.ExecContext(ctx, stmt, id)
+		if err != nil {
+			return n, err
+		}
+		count, _ := res.RowsAffected()
+		n += count
+	}
+	return n, nil
+}
+
+// relocateTx moves the rows ids of table, files or directories, into their
+// owner's LostAndFoundDir by setting column, renaming them when the name is
+// taken there.
+func relocateTx(ctx context.Context, tx *sql.Tx, table, column string, ids []int64) (int64, error) {
+	var n int64
+	for _, id := range ids {
+		var userID int64
+		var name string
+		if err := tx.QueryRowContext(ctx, `SELECT user_id, name FROM `+table+` WHERE id = ?`, id).Scan(&userID, &name); err != nil {
+			return n, err
+		}
+		dirID, err := lostAndFoundDirTx(ctx, tx, userID)
+		if err != nil {
+			return n, err
+		}
+		free, err := freeNameTx(ctx, tx, userID, dirID, name)
+		if err != nil {
+			return n, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET `+column+` = ?, name = ? WHERE id = ?`, dirID, free, id); err != nil {
+			return n, nameConflict(err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// lostAndFoundDirTx returns the LostAndFoundDir in the user's primary drive,
+// creating it, and the drive when the user has none left, as needed.
+func lostAndFoundDirTx(ctx context.Context, tx *sql.Tx, userID int64) (int64, error) {
+	var rootID int64
+	err := tx.QueryRowContext(ctx, `SELECT root_dir_id FROM drives WHERE user_id = ? ORDER BY root_dir_id LIMIT 1`, userID).Scan(&rootID)
+	if err == sql.ErrNoRows {
+		ts := now()
+		res, err := tx.ExecContext(ctx, `INSERT INTO directories(user_id, parent_id, name, public_id, created_at, updated_at) VALUES (?, NULL, ?, ?, ?, ?)`, userID, "/", newPublicID(), ts, ts)
+		if err != nil {
+			return 0, err
+		}
+		if rootID, err = res.LastInsertId(); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO drives(user_id, root_dir_id, label, created_at) VALUES (?, ?, ?, ?)`, userID, rootID, DefaultDriveLabel, ts); err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, err
+	}
+	return rootFolderTx(ctx, tx, userID, rootID, LostAndFoundDir)
+}
+
+// rootFolderTx returns the folder called name directly below rootID,
+// creating it if needed.
+func rootFolderTx(ctx context.Context, tx *sql.Tx, userID, rootID int64, name string) (int64, error) {
+	var dirID int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM directories WHERE user_id = ? AND parent_id = ? AND name = ?`, userID, rootID, name).Scan(&dirID)
+	if err == nil {
+		return dirID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO directories(user_id, parent_id, name, public_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`, userID, rootID, name, newPublicID(), now(), now())
+	if err != nil {
+		return 0, nameConflict(err)
+	}
+	return res.LastInsertId()
+}
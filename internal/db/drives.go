@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// DefaultDriveLabel names the drive every user starts with.
+const DefaultDriveLabel = "My Drive"
+
+// ErrDriveNotEmpty is returned when deleting a drive that still has content.
+var ErrDriveNotEmpty = errors.New("drive is not empty")
+
+// Drive is a named top-level tree owned by a user. Each drive has its own
+// root directory; the oldest drive is the primary one served over WebDAV.
+// StorageChatID overrides the server's storage chat for content copied into
+// the drive; zero uses the server default.
+type Drive struct {
+	ID            int64
+	UserID        int64
+	RootDirID     int64
+	Label         string
+	StorageChatID int64
+	CreatedAt     time.Time
+}
+
+// DriveUsage summarizes the content stored in a drive.
+type DriveUsage struct {
+	Drive Drive
+	Dirs  int64
+	Files int64
+	Bytes int64
+}
+
+const driveColumns = `id, user_id, root_dir_id, label, storage_chat_id, created_at`
+
+func scanDrive(row rowScanner, d *Drive) error {
+	return row.Scan(&d.ID, &d.UserID, &d.RootDirID, &d.Label, &d.StorageChatID, &d.CreatedAt)
+}
+
+// backfillDrives creates a drive row for root directories created before
+// drives existed.
+func (s *Store) backfillDrives(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO drives(user_id, root_dir_id, label, created_at)
+		SELECT user_id, id, ?, created_at FROM directories
+		WHERE parent_id IS NULL AND id NOT IN (SELECT root_dir_id FROM drives)
+		ORDER BY id`, DefaultDriveLabel)
+	return err
+}
+
+// ListDrives lists a user's drives, primary drive first.
+func (s *Store) ListDrives(ctx context.Context, userID int64) ([]Drive, error) {
+	if _, err := s.GetRootDirID(ctx, userID); err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+driveColumns+` FROM drives WHERE user_id = ? ORDER BY root_dir_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var drives []Drive
+	for rows.Next() {
+		var d Drive
+		if err := scanDrive(rows, &d); err != nil {
+			return nil, err
+		}
+		drives = append(drives, d)
+	}
+	return drives, rows.Err()
+}
+
+// GetDrive fetches a drive by ID.
+func (s *Store) GetDrive(ctx context.Context, userID, driveID int64) (Drive, error) {
+	var d Drive
+	row := s.DB.QueryRowContext(ctx, `SELECT `+driveColumns+` FROM drives WHERE id = ? AND user_id = ?`, driveID, userID)
+	err := scanDrive(row, &d)
+	return d, err
+}
+
+// DriveForDir returns the drive containing a directory.
+func (s *Store) DriveForDir(ctx context.Context, userID, dirID int64) (Drive, error) {
+	var d Drive
+	row := s.DB.QueryRowContext(ctx, `WITH RECURSIVE up(id, parent_id) AS (
+		SELECT id, parent_id FROM directories WHERE id = ? AND user_id = ?
+		UNION ALL
+		SELECT d.id, d.parent_id FROM directories d JOIN up ON d.id = up.parent_id
+	) SELECT `+driveColumns+` FROM drives WHERE user_id = ? AND root_dir_id = (SELECT id FROM up WHERE parent_id IS NULL)`, dirID, userID, userID)
+	err := scanDrive(row, &d)
+	return d, err
+}
+
+// CreateDrive adds a new drive with an empty root directory.
+func (s *Store) CreateDrive(ctx context.Context, userID int64, label string) (Drive, error) {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return Drive{}, errors.New("drive label is empty")
+	}
+	if _, err := s.GetRootDirID(ctx, userID); err != nil {
+		return Drive{}, err
+	}
+	if err := s.ensureDriveLabelAvailable(ctx, userID, label, 0); err != nil {
+		return Drive{}, err
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Drive{}, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+	ts := now()
+	res, err := tx.ExecContext(ctx, `INSERT INTO directories(user_id, parent_id, name, public_id, created_at, updated_at) VALUES (?, NULL, ?, ?, ?, ?)`, userID, "/", newPublicID(), ts, ts)
+	if err != nil {
+		return Drive{}, err
+	}
+	rootID, err := res.LastInsertId()
+	if err != nil {
+		return Drive{}, err
+	}
+	res, err = tx.ExecContext(ctx, `INSERT INTO drives(user_id, root_dir_id, label, created_at) VALUES (?, ?, ?, ?)`, userID, rootID, label, ts)
+	if err != nil {
+		return Drive{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Drive{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Drive{}, err
+	}
+	committed = true
+	s.audit(ctx, userID, AuditDriveCreate, id, label, "")
+	return Drive{ID: id, UserID: userID, RootDirID: rootID, Label: label, CreatedAt: ts}, nil
+}
+
+// RenameDrive changes a drive's label.
+func (s *Store) RenameDrive(ctx context.Context, userID, driveID int64, label string) error {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return errors.New("drive label is empty")
+	}
+	if err := s.ensureDriveLabelAvailable(ctx, userID, label, driveID); err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `UPDATE drives SET label = ? WHERE id = ? AND user_id = ?`, label, driveID, userID)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	s.audit(ctx, userID, AuditDriveRename, driveID, label, "")
+	return nil
+}
+
+// SetDriveStorageChat sets the storage chat backing a drive; zero restores
+// the server default.
+func (s *Store) SetDriveStorageChat(ctx context.Context, userID, driveID, chatID int64) error {
+	res, err := s.DB.ExecContext(ctx, `UPDATE drives SET storage_chat_id = ? WHERE id = ? AND user_id = ?`, chatID, driveID, userID)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DriveForStorageChat returns the oldest drive backed by chatID, or
+// sql.ErrNoRows when no drive names that chat.
+func (s *Store) DriveForStorageChat(ctx context.Context, chatID int64) (Drive, error) {
+	var d Drive
+	row := s.DB.QueryRowContext(ctx, `SELECT `+driveColumns+` FROM drives WHERE storage_chat_id = ? ORDER BY id LIMIT 1`, chatID)
+	err := scanDrive(row, &d)
+	return d, err
+}
+
+// DeleteDrive removes an empty, non-primary drive.
+func (s *Store) DeleteDrive(ctx context.Context, userID, driveID int64) error {
+	drive, err := s.GetDrive(ctx, userID, driveID)
+	if err != nil {
+		return err
+	}
+	rootID, err := s.GetRootDirID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if drive.RootDirID == rootID {
+		return errors.New("cannot delete primary drive")
+	}
+	var children int64
+	row := s.DB.QueryRowContext(ctx, `SELECT (SELECT COUNT(*) FROM directories WHERE parent_id = ?) + (SELECT COUNT(*) FROM files WHERE dir_id = ?)`, drive.RootDirID, drive.RootDirID)
+	if err := row.Scan(&children); err != nil {
+		return err
+	}
+	if children > 0 {
+		return ErrDriveNotEmpty
+	}
+	if _, err := s.DB.ExecContext(ctx, `DELETE FROM directories WHERE id = ? AND user_id = ?`, drive.RootDirID, userID); err != nil {
+		return err
+	}
+	s.audit(ctx, userID, AuditDriveDelete, driveID, drive.Label, "")
+	return nil
+}
+
+// ListDriveUsage reports folder, file and byte counts for each drive.
+func (s *Store) ListDriveUsage(ctx context.Context, userID int64) ([]DriveUsage, error) {
+	drives, err := s.ListDrives(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]DriveUsage, 0, len(drives))
+	for _, d := range drives {
+		u := DriveUsage{Drive: d}
+		if err := s.subtreeUsage(ctx, userID, d.RootDirID, &u.Dirs, &u.Files, &u.Bytes); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+func (s *Store) ensureDriveLabelAvailable(ctx context.Context, userID int64, label string, exceptID int64) error {
+	var one int
+	err := s.DB.QueryRowContext(ctx, `SELECT 1 FROM drives WHERE user_id = ? AND label = ? AND id != ? LIMIT 1`, userID, label, exceptID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return errors.New("a drive with that label already exists")
+}
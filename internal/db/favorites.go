@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Favorite is a quick upload destination pinned by a user.
+type Favorite struct {
+	ID        int64
+	UserID    int64
+	DirID     int64
+	Label     string
+	Position  int
+	CreatedAt time.Time
+}
+
+// AddFavorite pins a directory as a quick destination, updating the label if already pinned.
+func (s *Store) AddFavorite(ctx context.Context, userID, dirID int64, label string) (Favorite, error) {
+	if _, err := s.GetDirByID(ctx, userID, dirID); err != nil {
+		return Favorite{}, err
+	}
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO favorites(user_id, dir_id, label, position, created_at)
+		VALUES (?, ?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM favorites WHERE user_id = ?), ?)
+		ON CONFLICT(user_id, dir_id) DO UPDATE SET label = excluded.label`,
+		userID, dirID, label, userID, now())
+	if err != nil {
+		return Favorite{}, err
+	}
+	var f Favorite
+	row := s.DB.QueryRowContext(ctx, `SELECT id, user_id, dir_id, label, position, created_at FROM favorites WHERE user_id = ? AND dir_id = ?`, userID, dirID)
+	if err := row.Scan(&f.ID, &f.UserID, &f.DirID, &f.Label, &f.Position, &f.CreatedAt); err != nil {
+		return Favorite{}, err
+	}
+	return f, nil
+}
+
+// ListFavorites returns a user's quick destinations in display order.
+func (s *Store) ListFavorites(ctx context.Context, userID int64) ([]Favorite, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, user_id, dir_id, label, position, created_at FROM favorites WHERE user_id = ? ORDER BY position, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Favorite
+	for rows.Next() {
+		var f Favorite
+		if err := rows.Scan(&f.ID, &f.UserID, &f.DirID, &f.Label, &f.Position, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// DeleteFavorite unpins a quick destination.
+func (s *Store) DeleteFavorite(ctx context.Context, userID, favoriteID int64) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM favorites WHERE id = ? AND user_id = ?`, favoriteID, userID)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// FileRequestSessionTTL bounds how long an opened file request link keeps
+// routing the uploader's files to the link owner.
+const FileRequestSessionTTL = 24 * time.Hour
+
+// FileRequest is an upload-only link into one of a user's folders.
+type FileRequest struct {
+	ID        int64
+	UserID    int64
+	DirID     int64
+	Token     string
+	ExpiresAt sql.NullTime
+	Uploads   int64
+	CreatedAt time.Time
+}
+
+const fileRequestColumns = `r.id, r.user_id, r.dir_id, r.token, r.expires_at, r.uploads, r.created_at`
+
+func scanFileRequest(row rowScanner, r *FileRequest) error {
+	return row.Scan(&r.ID, &r.UserID, &r.DirID, &r.Token, &r.ExpiresAt, &r.Uploads, &r.CreatedAt)
+}
+
+// CreateFileRequest creates a file request link for a folder.
+func (s *Store) CreateFileRequest(ctx context.Context, userID, dirID int64, token string, expiresAt *time.Time) (FileRequest, error) {
+	if _, err := s.GetDirByID(ctx, userID, dirID); err != nil {
+		return FileRequest{}, err
+	}
+	var exp any
+	if expiresAt != nil {
+		exp = expiresAt.UTC()
+	}
+	if _, err := s.DB.ExecContext(ctx, `INSERT INTO file_requests(user_id, dir_id, token, expires_at, uploads, created_at) VALUES (?, ?, ?, ?, 0, ?)`,
+		userID, dirID, token, exp, now()); err != nil {
+		return FileRequest{}, err
+	}
+	return s.GetFileRequestByToken(ctx, token)
+}
+
+// GetFileRequestByToken fetches a file request by its link token.
+func (s *Store) GetFileRequestByToken(ctx context.Context, token string) (FileRequest, error) {
+	var r FileRequest
+	row := s.DB.QueryRowContext(ctx, `SELECT `+fileRequestColumns+` FROM file_requests r WHERE r.token = ?`, token)
+	err := scanFileRequest(row, &r)
+	return r, err
+}
+
+// DeleteFileRequests revokes every file request link of a folder and returns
+// how many were removed.
+func (s *Store) DeleteFileRequests(ctx context.Context, userID, dirID int64) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM file_requests WHERE user_id = ? AND dir_id = ?`, userID, dirID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// IncrementFileRequestUploads counts a file received through a request link.
+func (s *Store) IncrementFileRequestUploads(ctx context.Context, requestID int64) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE file_requests SET uploads = uploads + 1 WHERE id = ?`, requestID)
+	return err
+}
+
+// StartFileRequestSession routes the uploader's next files to the request,
+// replacing any session they had open.
+func (s *Store) StartFileRequestSession(ctx context.Context, uploaderID, requestID int64) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO file_request_sessions(uploader_id, request_id, started_at) VALUES (?, ?, ?)
+		ON CONFLICT(uploader_id) DO UPDATE SET request_id = excluded.request_id, started_at = excluded.started_at`,
+		uploaderID, requestID, now())
+	return err
+}
+
+// GetFileRequestSession returns the request an uploader is currently sending
+// files to. It reports sql.ErrNoRows when no session is open or it has
+// outlived FileRequestSessionTTL.
+func (s *Store) GetFileRequestSession(ctx context.Context, uploaderID int64) (FileRequest, error) {
+	var r FileRequest
+	var startedAt time.Time
+	row := s.DB.QueryRowContext(ctx, `SELECT `+fileRequestColumns+`, fs.started_at FROM file_request_sessions fs
+		JOIN file_requests r ON r.id = fs.request_id
+		WHERE fs.uploader_id = ?`, uploaderID)
+	if err := row.Scan(&r.ID, &r.UserID, &r.DirID, &r.Token, &r.ExpiresAt, &r.Uploads, &r.CreatedAt, &startedAt); err != nil {
+		return FileRequest{}, err
+	}
+	if now().Sub(startedAt) > FileRequestSessionTTL {
+		return FileRequest{}, sql.ErrNoRows
+	}
+	return r, nil
+}
+
+// EndFileRequestSession closes the uploader's session, if any.
+func (s *Store) EndFileRequestSession(ctx context.Context, uploaderID int64) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM file_request_sessions WHERE uploader_id = ?`, uploaderID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ValidateFileRequest checks if a file request still accepts uploads.
+func ValidateFileRequest(r FileRequest) error {
+	if r.ExpiresAt.Valid && time.Now().UTC().After(r.ExpiresAt.Time) {
+		return fmt.Errorf("file request expired")
+	}
+	return nil
+}
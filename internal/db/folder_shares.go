@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// FolderShare is a link to a folder that anyone holding it can browse and
+// download from over HTTP, the folder's subfolders included.
+type FolderShare struct {
+	ID          int64
+	DirID       int64
+	OwnerUserID int64
+	Token       string
+	ExpiresAt   sql.NullTime
+	// Uses counts the files downloaded through the share.
+	Uses      int64
+	CreatedAt time.Time
+	// passwordSalt and passwordHash protect the share; see CheckPassword.
+	passwordSalt string
+	passwordHash string
+}
+
+const folderShareColumns = `id, dir_id, owner_user_id, token, expires_at, uses, created_at, password_salt, password_hash`
+
+func scanFolderShare(row rowScanner, sh *FolderShare) error {
+	return row.Scan(&sh.ID, &sh.DirID, &sh.OwnerUserID, &sh.Token, &sh.ExpiresAt, &sh.Uses, &sh.CreatedAt, &sh.passwordSalt, &sh.passwordHash)
+}
+
+// Expired reports whether the share is past its expiry.
+func (sh FolderShare) Expired() bool {
+	return sh.ExpiresAt.Valid && time.Now().UTC().After(sh.ExpiresAt.Time)
+}
+
+// HasPassword reports whether browsing the share asks for a password.
+func (sh FolderShare) HasPassword() bool {
+	return sh.passwordHash != ""
+}
+
+// CheckPassword reports whether password opens the share. A share without
+// a password accepts any.
+func (sh FolderShare) CheckPassword(password string) bool {
+	return checkSharePassword(sh.passwordSalt, sh.passwordHash, password)
+}
+
+// CreateFolderShare shares userID's folder dirID, protected by password
+// unless it is empty. A folder the user does not own is reported as
+// sql.ErrNoRows.
+func (s *Store) CreateFolderShare(ctx context.Context, userID, dirID int64, token string, expiresAt *time.Time, password string) (FolderShare, error) {
+	dir, err := s.GetDirByID(ctx, userID, dirID)
+	if err != nil {
+		return FolderShare{}, err
+	}
+	saltHex, hash, err := hashSharePassword(password)
+	if err != nil {
+		return FolderShare{}, err
+	}
+	var exp any
+	if expiresAt != nil {
+		exp = expiresAt.UTC()
+	}
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO folder_shares(dir_id, owner_user_id, token, expires_at, uses, password_salt, password_hash, created_at)
+		VALUES (?, ?, ?, ?, 0, ?, ?, ?)`, dirID, userID, token, exp, saltHex, hash, now())
+	if err != nil {
+		return FolderShare{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return FolderShare{}, err
+	}
+	detail := "folder, " + shareAuditDetail(expiresAt, "")
+	if password != "" {
+		detail += ", password"
+	}
+	s.audit(ctx, userID, AuditShareCreate, dirID, dir.Name, detail)
+	return s.getFolderShare(ctx, `id = ?`, id)
+}
+
+func (s *Store) getFolderShare(ctx context.Context, where string, arg any) (FolderShare, error) {
+	var sh FolderShare
+	row := s.DB.QueryRowContext(ctx, `SELECT `+folderShareColumns+` FROM folder_shares WHERE `+where, arg)
+	if err := scanFolderShare(row, &sh); err != nil {
+		return FolderShare{}, err
+	}
+	return sh, nil
+}
+
+// GetFolderShareByToken fetches a folder share and its folder.
+func (s *Store) GetFolderShareByToken(ctx context.Context, token string) (FolderShare, Directory, error) {
+	sh, err := s.getFolderShare(ctx, `token = ?`, token)
+	if err != nil {
+		return sh, Directory{}, err
+	}
+	dir, err := s.GetDirByID(ctx, sh.OwnerUserID, sh.DirID)
+	if err != nil {
+		return sh, Directory{}, err
+	}
+	return sh, dir, nil
+}
+
+// ListFolderSharesByUser lists the folder shares a user owns.
+func (s *Store) ListFolderSharesByUser(ctx context.Context, userID int64) ([]FolderShare, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+folderShareColumns+` FROM folder_shares WHERE owner_user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var shares []FolderShare
+	for rows.Next() {
+		var sh FolderShare
+		if err := scanFolderShare(rows, &sh); err != nil {
+			return nil, err
+		}
+		shares = append(shares, sh)
+	}
+	return shares, rows.Err()
+}
+
+// RevokeFolderShare deletes userID's folder share shareID. A share owned by
+// someone else is reported as sql.ErrNoRows.
+func (s *Store) RevokeFolderShare(ctx context.Context, userID, shareID int64) error {
+	sh, err := s.getFolderShare(ctx, `id = ?`, shareID)
+	if err != nil {
+		return err
+	}
+	if sh.OwnerUserID != userID {
+		return sql.ErrNoRows
+	}
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM folder_shares WHERE id = ? AND owner_user_id = ?`, shareID, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	s.audit(ctx, userID, AuditShareRevoke, sh.DirID, "", fmt.Sprintf("folder share %d", shareID))
+	return nil
+}
+
+// IncrementFolderShareUses counts a download through a folder share.
+func (s *Store) IncrementFolderShareUses(ctx context.Context, shareID int64) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE folder_shares SET uses = uses + 1 WHERE id = ?`, shareID)
+	return err
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Optimize lets SQLite refresh the query planner statistics of every table
+// whose statistics are missing or stale.
+func (s *Store) Optimize(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, `PRAGMA optimize=0x10002`)
+	return err
+}
+
+// IncrementalVacuum returns free pages to the file system and reports how
+// many it released. Databases created before incremental auto-vacuum was
+// enabled have no pages to return until they are fully vacuumed once.
+func (s *Store) IncrementalVacuum(ctx context.Context) (int64, error) {
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	var before, after int64
+	if err := conn.QueryRowContext(ctx, `PRAGMA freelist_count`).Scan(&before); err != nil {
+		return 0, err
+	}
+	// The pragma frees one page per step, so it is read to the end rather
+	// than executed once.
+	rows, err := conn.QueryContext(ctx, `PRAGMA incremental_vacuum`)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := conn.QueryRowContext(ctx, `PRAGMA freelist_count`).Scan(&after); err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// PurgeExpiredShares drops file and folder share links past their expiry
+// and returns how many went.
+func (s *Store) PurgeExpiredShares(ctx context.Context) (int64, error) {
+	var total int64
+	for _, table := range []string{"shares", "folder_shares"} {
+		res, err := s.DB.ExecContext(ctx, `DELETE FROM `+table+` WHERE expires_at IS NOT NULL AND expires_at < ?`, now())
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
+
+// PurgeOldTrash permanently deletes trash items older than olderThan, for
+// every user, and returns how many went.
+func (s *Store) PurgeOldTrash(ctx context.Context, olderThan time.Duration) (int64, error) {
+	cutoff := now().Add(-olderThan)
+	rows, err := s.DB.QueryContext(ctx, `SELECT user_id, COUNT(*) FROM trash_items WHERE deleted_at < ? GROUP BY user_id`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	perUser := map[int64]int64{}
+	for rows.Next() {
+		var userID, n int64
+		if err := rows.Scan(&userID, &n); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		perUser[userID] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(perUser) == 0 {
+		return 0, nil
+	}
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM trash_items WHERE deleted_at < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	for userID, n := range perUser {
+		s.audit(ctx, userID, AuditTrashPurge, 0, "", fmt.Sprintf("expired %d item(s) after %s", n, olderThan))
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"pigpak/internal/media"
+)
+
+// SetFileMetadata records what was read from a file's content, replacing
+// anything recorded before. Zero metadata clears it.
+func (s *Store) SetFileMetadata(ctx context.Context, userID, fileID int64, m media.Metadata) error {
+	if m.IsZero() {
+		_, err := s.DB.ExecContext(ctx, `DELETE FROM file_metadata WHERE file_id IN (SELECT id FROM files WHERE id = ? AND user_id = ?)`, fileID, userID)
+		return err
+	}
+	var taken any
+	if !m.TakenAt.IsZero() {
+		taken = m.TakenAt.UTC()
+	}
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO file_metadata(file_id, width, height, duration_ms, taken_at, artist, album, title, pages)
+		SELECT id, ?, ?, ?, ?, ?, ?, ?, ? FROM files WHERE id = ? AND user_id = ?
+		ON CONFLICT(file_id) DO UPDATE SET width = excluded.width, height = excluded.height, duration_ms = excluded.duration_ms,
+			taken_at = excluded.taken_at, artist = excluded.artist, album = excluded.album, title = excluded.title, pages = excluded.pages`,
+		m.Width, m.Height, m.Duration.Milliseconds(), taken, m.Artist, m.Album, m.Title, m.Pages, fileID, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetFileMetadata returns what is known about a file's content. A file
+// with nothing recorded is reported as sql.ErrNoRows.
+func (s *Store) GetFileMetadata(ctx context.Context, userID, fileID int64) (media.Metadata, error) {
+	var m media.Metadata
+	var durationMS int64
+	var taken sql.NullTime
+	err := s.DB.QueryRowContext(ctx, `SELECT m.width, m.height, m.duration_ms, m.taken_at, m.artist, m.album, m.title, m.pages
+		FROM file_metadata m JOIN files f ON f.id = m.file_id WHERE m.file_id = ? AND f.user_id = ?`, fileID, userID).
+		Scan(&m.Width, &m.Height, &durationMS, &taken, &m.Artist, &m.Album, &m.Title, &m.Pages)
+	if err != nil {
+		return media.Metadata{}, err
+	}
+	m.Duration = time.Duration(durationMS) * time.Millisecond
+	if taken.Valid {
+		m.TakenAt = taken.Time
+	}
+	return m, nil
+}
@@ -0,0 +1,633 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migration is one schema change. Migrations run in version order and each
+// applied one is recorded in schema_version. down undoes up; a nil down
+// means the migration cannot be reverted. noTx migrations manage their own
+// transactions and get the database itself instead of a transaction.
+type migration struct {
+	version int
+	name    string
+	noTx    bool
+	up      func(ctx context.Context, s *Store, ex execer) error
+	down    func(ctx context.Context, s *Store, ex execer) error
+}
+
+// migrations lists every schema change in order. Append new ones with the
+// next version; never renumber or edit one that has shipped.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		noTx:    true,
+		up: func(ctx context.Context, s *Store, _ execer) error {
+			return s.migrateBaseline(ctx)
+		},
+	},
+	{
+		version: 2,
+		name:    "chat migrations",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS chat_migrations (
+			from_chat_id INTEGER PRIMARY KEY,
+			to_chat_id INTEGER NOT NULL,
+			migrated_at TIMESTAMP NOT NULL
+		);`),
+		down: execStatements(`DROP TABLE IF EXISTS chat_migrations;`),
+	},
+	{
+		version: 3,
+		name:    "tags",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+			UNIQUE(user_id, name)
+		);`,
+			`CREATE TABLE IF NOT EXISTS file_tags (
+			file_id INTEGER NOT NULL,
+			tag_id INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(file_id) REFERENCES files(id) ON DELETE CASCADE,
+			FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE,
+			PRIMARY KEY(file_id, tag_id)
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_file_tags_tag ON file_tags(tag_id);`),
+		down: execStatements(`DROP TABLE IF EXISTS file_tags;`, `DROP TABLE IF EXISTS tags;`),
+	},
+	{
+		version: 4,
+		name:    "checksums",
+		up: execStatements(`ALTER TABLE blobs ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE files ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE webdav_upload_parts ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE webdav_uploads ADD COLUMN sha256_state BLOB;`),
+		down: execStatements(`ALTER TABLE webdav_uploads DROP COLUMN sha256_state;`,
+			`ALTER TABLE webdav_upload_parts DROP COLUMN sha256;`,
+			`ALTER TABLE files DROP COLUMN sha256;`,
+			`ALTER TABLE blobs DROP COLUMN sha256;`),
+	},
+	{
+		version: 5,
+		name:    "audit log",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			actor_id INTEGER NOT NULL DEFAULT 0,
+			source TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target_id INTEGER NOT NULL DEFAULT 0,
+			target TEXT NOT NULL DEFAULT '',
+			detail TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log(user_id, id);`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at);`),
+		down: execStatements(`DROP TABLE IF EXISTS audit_log;`),
+	},
+	{
+		version: 6,
+		name:    "file message ids",
+		// Only storage chat messages are known for existing rows; messages
+		// received from users were never recorded.
+		up: execStatements(`ALTER TABLE files ADD COLUMN chat_id INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE files ADD COLUMN message_id INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE file_parts ADD COLUMN chat_id INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE file_parts ADD COLUMN message_id INTEGER NOT NULL DEFAULT 0;`,
+			`UPDATE files SET
+				chat_id = (SELECT b.storage_chat_id FROM blobs b WHERE b.file_unique_id = files.file_unique_id),
+				message_id = (SELECT b.storage_message_id FROM blobs b WHERE b.file_unique_id = files.file_unique_id)
+			WHERE NOT EXISTS (SELECT 1 FROM file_parts p WHERE p.file_id = files.id)
+				AND EXISTS (SELECT 1 FROM blobs b WHERE b.file_unique_id = files.file_unique_id AND b.storage_message_id != 0);`,
+			`UPDATE file_parts SET
+				chat_id = (SELECT b.storage_chat_id FROM blobs b WHERE b.file_unique_id = file_parts.file_unique_id),
+				message_id = (SELECT b.storage_message_id FROM blobs b WHERE b.file_unique_id = file_parts.file_unique_id)
+			WHERE EXISTS (SELECT 1 FROM blobs b WHERE b.file_unique_id = file_parts.file_unique_id AND b.storage_message_id != 0);`),
+		down: execStatements(`ALTER TABLE file_parts DROP COLUMN message_id;`,
+			`ALTER TABLE file_parts DROP COLUMN chat_id;`,
+			`ALTER TABLE files DROP COLUMN message_id;`,
+			`ALTER TABLE files DROP COLUMN chat_id;`),
+	},
+	{
+		version: 7,
+		name:    "listing indexes",
+		up: execStatements(`CREATE INDEX IF NOT EXISTS idx_dirs_parent_name ON directories(user_id, parent_id, name);`,
+			`CREATE INDEX IF NOT EXISTS idx_files_dir_name ON files(user_id, dir_id, name);`),
+		down: execStatements(`DROP INDEX IF EXISTS idx_files_dir_name;`, `DROP INDEX IF EXISTS idx_dirs_parent_name;`),
+	},
+	{
+		version: 8,
+		name:    "folder totals",
+		up: execStatements(append(append([]string{
+			`ALTER TABLE directories ADD COLUMN size_bytes INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE directories ADD COLUMN file_count INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE directories ADD COLUMN dir_count INTEGER NOT NULL DEFAULT 0;`,
+		}, backfillDirTotals...), dirTotalsTriggers...)...),
+		down: execStatements(`DROP TRIGGER IF EXISTS trg_files_totals_insert;`,
+			`DROP TRIGGER IF EXISTS trg_files_totals_delete;`,
+			`DROP TRIGGER IF EXISTS trg_files_totals_update;`,
+			`DROP TRIGGER IF EXISTS trg_dirs_totals_insert;`,
+			`DROP TRIGGER IF EXISTS trg_dirs_totals_delete;`,
+			`DROP TRIGGER IF EXISTS trg_dirs_totals_move;`,
+			`ALTER TABLE directories DROP COLUMN dir_count;`,
+			`ALTER TABLE directories DROP COLUMN file_count;`,
+			`ALTER TABLE directories DROP COLUMN size_bytes;`),
+	},
+	{
+		version: 9,
+		name:    "search indexes",
+		up: execStatements(`CREATE INDEX IF NOT EXISTS idx_files_user_size ON files(user_id, size);`,
+			`CREATE INDEX IF NOT EXISTS idx_files_user_mime ON files(user_id, mime_type);`,
+			`CREATE INDEX IF NOT EXISTS idx_files_user_created ON files(user_id, created_at);`),
+		down: execStatements(`DROP INDEX IF EXISTS idx_files_user_created;`,
+			`DROP INDEX IF EXISTS idx_files_user_mime;`,
+			`DROP INDEX IF EXISTS idx_files_user_size;`),
+	},
+	{
+		version: 10,
+		name:    "incremental vacuum",
+		noTx:    true,
+		up: func(ctx context.Context, s *Store, _ execer) error {
+			return s.setAutoVacuum(ctx, "INCREMENTAL")
+		},
+		down: func(ctx context.Context, s *Store, _ execer) error {
+			return s.setAutoVacuum(ctx, "NONE")
+		},
+	},
+	{
+		version: 11,
+		name:    "share owners",
+		up: execStatements(`ALTER TABLE shares ADD COLUMN owner_user_id INTEGER NOT NULL DEFAULT 0;`,
+			`UPDATE shares SET owner_user_id = COALESCE((SELECT f.user_id FROM files f WHERE f.id = shares.file_id), 0);`,
+			`DELETE FROM shares WHERE owner_user_id = 0;`,
+			`CREATE INDEX IF NOT EXISTS idx_shares_owner ON shares(owner_user_id);`),
+		down: execStatements(`DROP INDEX IF EXISTS idx_shares_owner;`,
+			`ALTER TABLE shares DROP COLUMN owner_user_id;`),
+	},
+	{
+		version: 12,
+		name:    "file metadata",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS file_metadata (
+			file_id INTEGER PRIMARY KEY,
+			width INTEGER NOT NULL DEFAULT 0,
+			height INTEGER NOT NULL DEFAULT 0,
+			duration_ms INTEGER NOT NULL DEFAULT 0,
+			taken_at TIMESTAMP,
+			artist TEXT NOT NULL DEFAULT '',
+			album TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			pages INTEGER NOT NULL DEFAULT 0,
+			FOREIGN KEY(file_id) REFERENCES files(id) ON DELETE CASCADE
+		);`),
+		down: execStatements(`DROP TABLE IF EXISTS file_metadata;`),
+	},
+	{
+		version: 13,
+		name:    "api tokens",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			prefix TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			read_only INTEGER NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id);`),
+		down: execStatements(`DROP TABLE IF EXISTS api_tokens;`),
+	},
+	{
+		version: 14,
+		name:    "thumbnails",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS thumbnails (
+			file_id INTEGER PRIMARY KEY,
+			data BLOB NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(file_id) REFERENCES files(id) ON DELETE CASCADE
+		);`),
+		down: execStatements(`DROP TABLE IF EXISTS thumbnails;`),
+	},
+	{
+		version: 15,
+		name:    "unique names",
+		// Duplicates left by earlier races keep their content under a name
+		// suffixed with their id.
+		up: execStatements(`UPDATE files SET name = name || ' (' || id || ')' WHERE EXISTS (
+			SELECT 1 FROM files g WHERE g.user_id = files.user_id AND g.dir_id = files.dir_id AND g.name = files.name AND g.id < files.id
+		);`,
+			`UPDATE directories SET name = name || ' (' || id || ')' WHERE EXISTS (
+			SELECT 1 FROM directories g WHERE g.user_id = directories.user_id AND g.parent_id = directories.parent_id AND g.name = directories.name AND g.id < directories.id
+		);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_files_name ON files(user_id, dir_id, name);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_dirs_name ON directories(user_id, parent_id, name);`),
+		down: execStatements(`DROP INDEX IF EXISTS idx_dirs_name;`, `DROP INDEX IF EXISTS idx_files_name;`),
+	},
+	{
+		version: 16,
+		name:    "revisions",
+		up: execStatements(append([]string{
+			`ALTER TABLE files ADD COLUMN revision INTEGER NOT NULL DEFAULT 1;`,
+			`ALTER TABLE directories ADD COLUMN revision INTEGER NOT NULL DEFAULT 1;`,
+		}, revisionTriggers...)...),
+		down: execStatements(`DROP TRIGGER IF EXISTS trg_dirs_revision;`,
+			`DROP TRIGGER IF EXISTS trg_files_revision;`,
+			`ALTER TABLE directories DROP COLUMN revision;`,
+			`ALTER TABLE files DROP COLUMN revision;`),
+	},
+	{
+		version: 17,
+		name:    "shortcuts",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS shortcuts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			dir_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			target_dir_id INTEGER,
+			target_file_id INTEGER,
+			page_id INTEGER,
+			share_id INTEGER,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+			FOREIGN KEY(dir_id) REFERENCES directories(id) ON DELETE CASCADE,
+			FOREIGN KEY(target_dir_id) REFERENCES directories(id) ON DELETE CASCADE,
+			FOREIGN KEY(target_file_id) REFERENCES files(id) ON DELETE CASCADE,
+			FOREIGN KEY(page_id) REFERENCES pages(id) ON DELETE CASCADE,
+			FOREIGN KEY(share_id) REFERENCES shares(id) ON DELETE CASCADE,
+			CHECK ((target_dir_id IS NOT NULL) + (target_file_id IS NOT NULL) + (page_id IS NOT NULL) + (share_id IS NOT NULL) = 1),
+			UNIQUE(user_id, dir_id, name)
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_shortcuts_target_dir ON shortcuts(target_dir_id);`,
+			`CREATE INDEX IF NOT EXISTS idx_shortcuts_target_file ON shortcuts(target_file_id);`,
+			`CREATE INDEX IF NOT EXISTS idx_shortcuts_page ON shortcuts(page_id);`,
+			`CREATE INDEX IF NOT EXISTS idx_shortcuts_share ON shortcuts(share_id);`),
+		down: execStatements(`DROP TABLE IF EXISTS shortcuts;`),
+	},
+	{
+		version: 18,
+		name:    "user storage",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS user_storage (
+			user_id INTEGER PRIMARY KEY,
+			chat_id INTEGER NOT NULL UNIQUE,
+			title TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE
+		);`),
+		down: execStatements(`DROP TABLE IF EXISTS user_storage;`),
+	},
+	{
+		version: 19,
+		name:    "outbox",
+		up: execStatements(append([]string{
+			`CREATE TABLE IF NOT EXISTS outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			subject_id INTEGER NOT NULL,
+			payload TEXT NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP NOT NULL
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_outbox_user ON outbox(user_id, id);`,
+			`CREATE INDEX IF NOT EXISTS idx_outbox_created ON outbox(created_at);`,
+			`CREATE TABLE IF NOT EXISTS outbox_cursors (
+			consumer TEXT PRIMARY KEY,
+			last_id INTEGER NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);`,
+		}, outboxTriggers...)...),
+		down: execStatements(`DROP TRIGGER IF EXISTS trg_outbox_share_accessed;`,
+			`DROP TRIGGER IF EXISTS trg_outbox_file_deleted;`,
+			`DROP TRIGGER IF EXISTS trg_outbox_file_created;`,
+			`DROP TABLE IF EXISTS outbox_cursors;`,
+			`DROP TABLE IF EXISTS outbox;`),
+	},
+	{
+		version: 20,
+		name:    "webdav locks",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS webdav_locks (
+			token TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			drive_id INTEGER NOT NULL,
+			root TEXT NOT NULL,
+			zero_depth INTEGER NOT NULL DEFAULT 0,
+			owner_xml TEXT NOT NULL DEFAULT '',
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+			FOREIGN KEY(drive_id) REFERENCES drives(id) ON DELETE CASCADE
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_webdav_locks_drive ON webdav_locks(user_id, drive_id, root);`),
+		down: execStatements(`DROP TABLE IF EXISTS webdav_locks;`),
+	},
+	{
+		version: 21,
+		name:    "file modified times",
+		up: execStatements(append([]string{
+			`ALTER TABLE files ADD COLUMN modified_at TIMESTAMP;`,
+		}, modifiedTriggers...)...),
+		down: execStatements(`DROP TRIGGER IF EXISTS trg_files_modified;`,
+			`ALTER TABLE files DROP COLUMN modified_at;`),
+	},
+	{
+		version: 22,
+		name:    "dav properties",
+		up: execStatements(append([]string{
+			`CREATE TABLE IF NOT EXISTS dav_properties (
+			entity TEXT NOT NULL,
+			entity_id INTEGER NOT NULL,
+			namespace TEXT NOT NULL,
+			name TEXT NOT NULL,
+			lang TEXT NOT NULL DEFAULT '',
+			value TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY(entity, entity_id, namespace, name)
+		);`,
+		}, davPropertyTriggers...)...),
+		down: execStatements(`DROP TRIGGER IF EXISTS trg_dav_properties_dir;`,
+			`DROP TRIGGER IF EXISTS trg_dav_properties_file;`,
+			`DROP TABLE IF EXISTS dav_properties;`),
+	},
+	{
+		version: 23,
+		name:    "webdav junk files",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS webdav_junk (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			dir_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			content BLOB NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			UNIQUE(dir_id, name),
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+			FOREIGN KEY(dir_id) REFERENCES directories(id) ON DELETE CASCADE
+		);`),
+		down: execStatements(`DROP TABLE IF EXISTS webdav_junk;`),
+	},
+	{
+		version: 24,
+		name:    "user part sizes",
+		up:      execStatements(`ALTER TABLE users ADD COLUMN part_size INTEGER NOT NULL DEFAULT 0;`),
+		down:    execStatements(`ALTER TABLE users DROP COLUMN part_size;`),
+	},
+	{
+		version: 25,
+		name:    "share passwords",
+		up: execStatements(`ALTER TABLE shares ADD COLUMN password_salt TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE shares ADD COLUMN password_hash TEXT NOT NULL DEFAULT '';`),
+		down: execStatements(`ALTER TABLE shares DROP COLUMN password_hash;`,
+			`ALTER TABLE shares DROP COLUMN password_salt;`),
+	},
+	{
+		version: 26,
+		name:    "folder shares",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS folder_shares (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			dir_id INTEGER NOT NULL,
+			owner_user_id INTEGER NOT NULL,
+			token TEXT NOT NULL UNIQUE,
+			expires_at TIMESTAMP,
+			uses INTEGER NOT NULL DEFAULT 0,
+			password_salt TEXT NOT NULL DEFAULT '',
+			password_hash TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(owner_user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+			FOREIGN KEY(dir_id) REFERENCES directories(id) ON DELETE CASCADE
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_folder_shares_owner ON folder_shares(owner_user_id);`),
+		down: execStatements(`DROP TABLE IF EXISTS folder_shares;`),
+	},
+	{
+		version: 27,
+		name:    "webhooks",
+		up: execStatements(`CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL DEFAULT '',
+			all_users INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(user_id) ON DELETE CASCADE
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_webhooks_user ON webhooks(user_id);`,
+			`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			body TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			status_code INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			finished_at TIMESTAMP,
+			FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+		);`,
+			`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);`,
+			`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_hook ON webhook_deliveries(webhook_id, id);`,
+			outboxRenameTrigger),
+		down: execStatements(`DROP TRIGGER IF EXISTS trg_outbox_file_renamed;`,
+			`DROP TABLE IF EXISTS webhook_deliveries;`,
+			`DROP TABLE IF EXISTS webhooks;`),
+	},
+	{
+		version: 28,
+		name:    "outbox public ids",
+		up: execStatements(append([]string{
+			`DROP TRIGGER IF EXISTS trg_outbox_file_created;`,
+			`DROP TRIGGER IF EXISTS trg_outbox_file_deleted;`,
+			`DROP TRIGGER IF EXISTS trg_outbox_file_renamed;`,
+		}, outboxPublicIDTriggers...)...),
+		down: execStatements(`DROP TRIGGER IF EXISTS trg_outbox_file_created;`,
+			`DROP TRIGGER IF EXISTS trg_outbox_file_deleted;`,
+			`DROP TRIGGER IF EXISTS trg_outbox_file_renamed;`,
+			outboxTriggers[0], outboxTriggers[1], outboxRenameTrigger),
+	},
+	{
+		version: 29,
+		name:    "quotas",
+		up:      execStatements(`ALTER TABLE users ADD COLUMN quota_bytes INTEGER NOT NULL DEFAULT 0;`),
+		down:    execStatements(`ALTER TABLE users DROP COLUMN quota_bytes;`),
+	},
+}
+
+// setAutoVacuum switches the auto_vacuum mode, which only takes effect
+// after a full VACUUM on the same connection.
+func (s *Store) setAutoVacuum(ctx context.Context, mode string) error {
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `PRAGMA auto_vacuum = `+mode); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `VACUUM`)
+	return err
+}
+
+// execStatements builds a migration step that runs stmts in order.
+func execStatements(stmts ...string) func(ctx context.Context, s *Store, ex execer) error {
+	return func(ctx context.Context, s *Store, ex execer) error {
+		for _, stmt := range stmts {
+			if _, err := ex.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// LatestSchemaVersion is the schema version this build migrates to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// MigrationStatus describes one known migration.
+type MigrationStatus struct {
+	Version    int
+	Name       string
+	Reversible bool
+	AppliedAt  sql.NullTime
+}
+
+// Migrate brings the schema to the latest version.
+func (s *Store) Migrate(ctx context.Context) error {
+	return s.MigrateTo(ctx, LatestSchemaVersion())
+}
+
+// SchemaVersion returns the newest applied migration, or 0 for a database
+// that has none recorded.
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	if err := s.ensureSchemaVersion(ctx); err != nil {
+		return 0, err
+	}
+	var version int
+	err := s.DB.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// Migrations lists the migrations this build knows and when each was
+// applied.
+func (s *Store) Migrations(ctx context.Context) ([]MigrationStatus, error) {
+	if err := s.ensureSchemaVersion(ctx); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]sql.NullTime)
+	rows, err := s.DB.QueryContext(ctx, `SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at sql.NullTime
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	out := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		out = append(out, MigrationStatus{Version: m.version, Name: m.name, Reversible: m.down != nil, AppliedAt: applied[m.version]})
+	}
+	return out, nil
+}
+
+// MigrateTo applies or reverts migrations until the schema is at version.
+// Reverting stops with an error at the first migration that has no down
+// step, leaving the schema at that migration's version.
+func (s *Store) MigrateTo(ctx context.Context, version int) error {
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	latest := LatestSchemaVersion()
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, latest)
+	}
+	if version < 0 || version > latest {
+		return fmt.Errorf("schema version %d out of range 0-%d", version, latest)
+	}
+	for _, m := range migrations {
+		if m.version > current && m.version <= version {
+			if err := s.applyMigration(ctx, m); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version <= current && m.version > version {
+			if err := s.revertMigration(ctx, m); err != nil {
+				return fmt.Errorf("revert migration %d (%s): %w", m.version, m.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) ensureSchemaVersion(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	);`)
+	return err
+}
+
+func (s *Store) applyMigration(ctx context.Context, m migration) error {
+	record := func(ex execer) error {
+		_, err := ex.ExecContext(ctx, `INSERT INTO schema_version(version, name, applied_at) VALUES (?, ?, ?)`, m.version, m.name, now())
+		return err
+	}
+	if m.noTx {
+		if err := m.up(ctx, s, s.DB); err != nil {
+			return err
+		}
+		return record(s.DB)
+	}
+	return s.runSurgery(ctx, true, func(tx *sql.Tx) error {
+		if err := m.up(ctx, s, tx); err != nil {
+			return err
+		}
+		return record(tx)
+	})
+}
+
+func (s *Store) revertMigration(ctx context.Context, m migration) error {
+	if m.down == nil {
+		return fmt.Errorf("migration cannot be reverted")
+	}
+	remove := func(ex execer) error {
+		_, err := ex.ExecContext(ctx, `DELETE FROM schema_version WHERE version = ?`, m.version)
+		return err
+	}
+	if m.noTx {
+		if err := m.down(ctx, s, s.DB); err != nil {
+			return err
+		}
+		return remove(s.DB)
+	}
+	return s.runSurgery(ctx, true, func(tx *sql.Tx) error {
+		if err := m.down(ctx, s, tx); err != nil {
+			return err
+		}
+		return remove(tx)
+	})
+}
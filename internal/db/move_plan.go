@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// MovePlan reports what moving a folder or file would change. Plans are
+// computed without touching any rows so callers can review a large move
+// before running it.
+type MovePlan struct {
+	IsDir bool
+	// Dirs counts the folders that move, including the source folder.
+	Dirs  int64
+	Files int64
+	Bytes int64
+	// TargetExists is set when the target name is already taken by another
+	// entry. With overwrite the Replaced counts describe what gets deleted.
+	TargetExists  bool
+	ReplacedDirs  int64
+	ReplacedFiles int64
+	ReplacedBytes int64
+	// SourceDriveID and TargetDriveID differ for a move between drives.
+	SourceDriveID int64
+	TargetDriveID int64
+	// Conflicts lists the reasons the move cannot run. A plan without
+	// conflicts still needs TargetExists checked against overwrite.
+	Conflicts []string
+	// Quota is the user's quota before the move.
+	Quota Quota
+}
+
+// OK reports whether the move can run as planned.
+func (p MovePlan) OK(overwrite bool) bool {
+	return len(p.Conflicts) == 0 && (!p.TargetExists || overwrite)
+}
+
+// MoveReport is the JSON form of a plan, which WebDAV answers a MOVE
+// carrying "X-Dry-Run: T" with and the API a PATCH with dry_run set.
+type MoveReport struct {
+	IsDir     bool      `json:"is_dir"`
+	Dirs      int64     `json:"dirs"`
+	Files     int64     `json:"files"`
+	Bytes     int64     `json:"bytes"`
+	Overwrite bool      `json:"overwrite"`
+	Exists    bool      `json:"destination_exists"`
+	Replaced  *MoveSize `json:"replaced,omitempty"`
+	// UsageDelta is how the user's stored bytes change; only an overwrite
+	// that deletes the existing destination frees space, and only a restore
+	// from the trash takes more.
+	UsageDelta int64 `json:"usage_delta"`
+	// QuotaBytes is the user's quota, 0 for none, and UsedBytes what they
+	// store before the move.
+	QuotaBytes int64    `json:"quota_bytes"`
+	UsedBytes  int64    `json:"used_bytes"`
+	Conflicts  []string `json:"conflicts"`
+	OK         bool     `json:"ok"`
+}
+
+// MoveSize counts what a move replaces.
+type MoveSize struct {
+	Dirs  int64 `json:"dirs"`
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Report describes the plan run with or without overwrite. A target that
+// exists without overwrite leaves OK false without a conflict of its own,
+// for callers to explain in their protocol's terms.
+func (p MovePlan) Report(overwrite bool) MoveReport {
+	r := MoveReport{
+		IsDir:      p.IsDir,
+		Dirs:       p.Dirs,
+		Files:      p.Files,
+		Bytes:      p.Bytes,
+		Overwrite:  overwrite,
+		Exists:     p.TargetExists,
+		QuotaBytes: p.Quota.Limit,
+		UsedBytes:  p.Quota.Used,
+		Conflicts:  append([]string{}, p.Conflicts...),
+		OK:         p.OK(overwrite),
+	}
+	if p.TargetExists && overwrite {
+		r.Replaced = &MoveSize{Dirs: p.ReplacedDirs, Files: p.ReplacedFiles, Bytes: p.ReplacedBytes}
+		r.UsageDelta = -p.ReplacedBytes
+	}
+	return r
+}
+
+// PlanDirMove plans moving dirID to parentID under name.
+func (s *Store) PlanDirMove(ctx context.Context, userID, dirID, parentID int64, name string) (MovePlan, error) {
+	dir, err := s.GetDirByID(ctx, userID, dirID)
+	if err != nil {
+		return MovePlan{}, err
+	}
+	plan := MovePlan{IsDir: true}
+	if err := s.subtreeUsage(ctx, userID, dirID, &plan.Dirs, &plan.Files, &plan.Bytes); err != nil {
+		return MovePlan{}, err
+	}
+	plan.Dirs++
+	if !dir.ParentID.Valid {
+		plan.Conflicts = append(plan.Conflicts, "cannot move root directory")
+	}
+	if dirID == parentID {
+		plan.Conflicts = append(plan.Conflicts, "cannot move directory into itself")
+	} else if isDesc, err := s.isDescendant(ctx, userID, dirID, parentID); err != nil {
+		return MovePlan{}, err
+	} else if isDesc {
+		plan.Conflicts = append(plan.Conflicts, "cannot move directory into its descendant")
+	}
+	if err := s.planTarget(ctx, userID, dirID, parentID, name, dirID, 0, &plan); err != nil {
+		return MovePlan{}, err
+	}
+	return plan, nil
+}
+
+// PlanFileMove plans moving fileID to parentID under name.
+func (s *Store) PlanFileMove(ctx context.Context, userID, fileID, parentID int64, name string) (MovePlan, error) {
+	file, err := s.GetFileByID(ctx, userID, fileID)
+	if err != nil {
+		return MovePlan{}, err
+	}
+	plan := MovePlan{Files: 1, Bytes: file.Size}
+	if err := s.planTarget(ctx, userID, file.DirID, parentID, name, 0, fileID, &plan); err != nil {
+		return MovePlan{}, err
+	}
+	return plan, nil
+}
+
+// planTarget fills in the drive, quota and target-side details of a plan.
+// sourceDirID is the moved folder itself, or the folder holding the moved
+// file.
+func (s *Store) planTarget(ctx context.Context, userID, sourceDirID, parentID int64, name string, excludeDirID, excludeFileID int64, plan *MovePlan) error {
+	if name == "" || strings.Contains(name, "/") {
+		plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("invalid target name %q", name))
+	}
+	quota, err := s.GetQuota(ctx, userID)
+	if err != nil {
+		return err
+	}
+	plan.Quota = quota
+	if src, err := s.DriveForDir(ctx, userID, sourceDirID); err == nil {
+		plan.SourceDriveID = src.ID
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	if dst, err := s.DriveForDir(ctx, userID, parentID); err == nil {
+		plan.TargetDriveID = dst.ID
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	if dir, err := s.GetDirByName(ctx, userID, parentID, name); err == nil {
+		if dir.ID == excludeDirID {
+			return nil
+		}
+		plan.TargetExists = true
+		if err := s.subtreeUsage(ctx, userID, dir.ID, &plan.ReplacedDirs, &plan.ReplacedFiles, &plan.ReplacedBytes); err != nil {
+			return err
+		}
+		plan.ReplacedDirs++
+		contains, err := s.isDescendant(ctx, userID, dir.ID, sourceDirID)
+		if err != nil {
+			return err
+		}
+		if contains {
+			plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("target %s contains the source", name))
+		}
+		return nil
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	if file, err := s.GetFileByName(ctx, userID, parentID, name); err == nil {
+		if file.ID == excludeFileID {
+			return nil
+		}
+		plan.TargetExists = true
+		plan.ReplacedFiles = 1
+		plan.ReplacedBytes = file.Size
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Notification is an owner notification waiting for Telegram to become reachable.
+type Notification struct {
+	ID        int64
+	UserID    int64
+	ChatID    int64
+	Text      string
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+}
+
+// EnqueueNotification stores a notification for later delivery.
+func (s *Store) EnqueueNotification(ctx context.Context, userID, chatID int64, text, lastError string) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO notifications(user_id, chat_id, text, attempts, last_error, created_at) VALUES (?, ?, ?, 1, ?, ?)`, userID, chatID, text, lastError, now())
+	return err
+}
+
+// ListPendingNotifications returns the oldest queued notifications.
+func (s *Store) ListPendingNotifications(ctx context.Context, limit int) ([]Notification, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, user_id, chat_id, text, attempts, last_error, created_at FROM notifications ORDER BY id LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Notification
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.ChatID, &n.Text, &n.Attempts, &n.LastError, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// MarkNotificationAttempt records a failed delivery attempt.
+func (s *Store) MarkNotificationAttempt(ctx context.Context, id int64, lastError string) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE notifications SET attempts = attempts + 1, last_error = ? WHERE id = ?`, lastError, id)
+	return err
+}
+
+// DeleteNotification removes a delivered or abandoned notification.
+func (s *Store) DeleteNotification(ctx context.Context, id int64) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM notifications WHERE id = ?`, id)
+	return err
+}
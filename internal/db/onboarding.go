@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// migrateOnboarding adds users.onboarded_at. Users that existed before the
+// column was introduced already know the bot, so they are marked onboarded.
+func (s *Store) migrateOnboarding(ctx context.Context) error {
+	exists, err := s.hasColumn(ctx, "users", "onboarded_at")
+	if err != nil || exists {
+		return err
+	}
+	if _, err := s.DB.ExecContext(ctx, `ALTER TABLE users ADD COLUMN onboarded_at TIMESTAMP`); err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, `UPDATE users SET onboarded_at = created_at`)
+	return err
+}
+
+// IsOnboarded reports whether the user finished or skipped the setup wizard.
+func (s *Store) IsOnboarded(ctx context.Context, userID int64) (bool, error) {
+	var at sql.NullTime
+	err := s.DB.QueryRowContext(ctx, `SELECT onboarded_at FROM users WHERE user_id = ?`, userID).Scan(&at)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return at.Valid, nil
+}
+
+// MarkOnboarded records that the user finished or skipped the setup wizard.
+func (s *Store) MarkOnboarded(ctx context.Context, userID int64) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE users SET onboarded_at = ? WHERE user_id = ? AND onboarded_at IS NULL`, now(), userID)
+	return err
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Outbox event names.
+const (
+	EventFileCreated   = "file.created"
+	EventFileDeleted   = "file.deleted"
+	EventFileRenamed   = "file.renamed"
+	EventShareAccessed = "share.accessed"
+	// EventQuotaExceeded is a write refused for the user's quota.
+	EventQuotaExceeded = "quota.exceeded"
+)
+
+// OutboxEvent is a change recorded in the outbox by the statement that made
+// it, so a consumer reading the outbox never misses a committed change or
+// sees one that was rolled back. Payload is a JSON object describing the
+// row as it was at the time.
+type OutboxEvent struct {
+	ID        int64
+	Event     string
+	UserID    int64
+	SubjectID int64
+	Payload   string
+	CreatedAt time.Time
+}
+
+// outboxTriggers write the outbox rows. Being triggers, they run inside the
+// mutation's own transaction whichever code path makes it: uploads, copies,
+// restores from trash, deletes, trashing and cascades. Moving a file to the
+// trash counts as deleting it and restoring it as creating it.
+var outboxTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS trg_outbox_file_created AFTER INSERT ON files BEGIN
+		INSERT INTO outbox(event, user_id, subject_id, payload, created_at) VALUES ('` + EventFileCreated + `', NEW.user_id, NEW.id,
+			json_object('file_id', NEW.id, 'public_id', NEW.public_id, 'dir_id', NEW.dir_id, 'name', NEW.name, 'size', NEW.size, 'mime_type', NEW.mime_type),
+			strftime('%Y-%m-%d %H:%M:%f', 'now'));
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_outbox_file_deleted AFTER DELETE ON files BEGIN
+		INSERT INTO outbox(event, user_id, subject_id, payload, created_at) VALUES ('` + EventFileDeleted + `', OLD.user_id, OLD.id,
+			json_object('file_id', OLD.id, 'public_id', OLD.public_id, 'dir_id', OLD.dir_id, 'name', OLD.name, 'size', OLD.size, 'mime_type', OLD.mime_type),
+			strftime('%Y-%m-%d %H:%M:%f', 'now'));
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_outbox_share_accessed AFTER UPDATE OF uses ON shares
+	WHEN NEW.uses > OLD.uses BEGIN
+		INSERT INTO outbox(event, user_id, subject_id, payload, created_at)
+			SELECT '` + EventShareAccessed + `', f.user_id, NEW.id,
+				json_object('share_id', NEW.id, 'file_id', NEW.file_id, 'name', f.name, 'uses', NEW.uses, 'recipient_id', NEW.recipient_id),
+				strftime('%Y-%m-%d %H:%M:%f', 'now')
+			FROM files f WHERE f.id = NEW.file_id;
+	END;`,
+}
+
+// outboxRenameTrigger records renames and moves of files, with the name and
+// folder before and after. It came after outboxTriggers, with webhooks.
+const outboxRenameTrigger = `CREATE TRIGGER IF NOT EXISTS trg_outbox_file_renamed AFTER UPDATE OF name, dir_id ON files
+	WHEN NEW.name IS NOT OLD.name OR NEW.dir_id IS NOT OLD.dir_id BEGIN
+		INSERT INTO outbox(event, user_id, subject_id, payload, created_at) VALUES ('` + EventFileRenamed + `', NEW.user_id, NEW.id,
+			json_object('file_id', NEW.id, 'public_id', NEW.public_id, 'dir_id', NEW.dir_id, 'name', NEW.name, 'old_dir_id', OLD.dir_id, 'old_name', OLD.name, 'size', NEW.size, 'mime_type', NEW.mime_type),
+			strftime('%Y-%m-%d %H:%M:%f', 'now'));
+	END;`
+
+// outboxPublicIDTriggers replace the file triggers above so payloads name
+// folders by public id, like the v1 API, instead of by row id.
+var outboxPublicIDTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS trg_outbox_file_created AFTER INSERT ON files BEGIN
+		INSERT INTO outbox(event, user_id, subject_id, payload, created_at) VALUES ('` + EventFileCreated + `', NEW.user_id, NEW.id,
+			json_object('file_id', NEW.id, 'public_id', NEW.public_id, 'dir_id', (SELECT public_id FROM directories WHERE id = NEW.dir_id),
+				'name', NEW.name, 'size', NEW.size, 'mime_type', NEW.mime_type),
+			strftime('%Y-%m-%d %H:%M:%f', 'now'));
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_outbox_file_deleted AFTER DELETE ON files BEGIN
+		INSERT INTO outbox(event, user_id, subject_id, payload, created_at) VALUES ('` + EventFileDeleted + `', OLD.user_id, OLD.id,
+			json_object('file_id', OLD.id, 'public_id', OLD.public_id, 'dir_id', (SELECT public_id FROM directories WHERE id = OLD.dir_id),
+				'name', OLD.name, 'size', OLD.size, 'mime_type', OLD.mime_type),
+			strftime('%Y-%m-%d %H:%M:%f', 'now'));
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_outbox_file_renamed AFTER UPDATE OF name, dir_id ON files
+	WHEN NEW.name IS NOT OLD.name OR NEW.dir_id IS NOT OLD.dir_id BEGIN
+		INSERT INTO outbox(event, user_id, subject_id, payload, created_at) VALUES ('` + EventFileRenamed + `', NEW.user_id, NEW.id,
+			json_object('file_id', NEW.id, 'public_id', NEW.public_id, 'dir_id', (SELECT public_id FROM directories WHERE id = NEW.dir_id),
+				'name', NEW.name, 'old_dir_id', (SELECT public_id FROM directories WHERE id = OLD.dir_id), 'old_name', OLD.name,
+				'size', NEW.size, 'mime_type', NEW.mime_type),
+			strftime('%Y-%m-%d %H:%M:%f', 'now'));
+	END;`,
+}
+
+const outboxColumns = `id, event, user_id, subject_id, payload, created_at`
+
+func scanOutboxEvents(rows *sql.Rows) ([]OutboxEvent, error) {
+	defer rows.Close()
+	var out []OutboxEvent
+	for rows.Next() {
+		var e OutboxEvent
+		if err := rows.Scan(&e.ID, &e.Event, &e.UserID, &e.SubjectID, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// ListOutbox returns up to limit events after afterID, oldest first.
+func (s *Store) ListOutbox(ctx context.Context, afterID int64, limit int) ([]OutboxEvent, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+outboxColumns+` FROM outbox WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxEvents(rows)
+}
+
+// ListUserOutbox returns up to limit of a user's events before beforeID,
+// newest first, for an activity feed. A beforeID of zero starts at the
+// newest event.
+func (s *Store) ListUserOutbox(ctx context.Context, userID, beforeID int64, limit int) ([]OutboxEvent, error) {
+	query := `SELECT ` + outboxColumns + ` FROM outbox WHERE user_id = ?`
+	args := []any{userID}
+	if beforeID > 0 {
+		query += ` AND id < ?`
+		args = append(args, beforeID)
+	}
+	rows, err := s.DB.QueryContext(ctx, query+` ORDER BY id DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxEvents(rows)
+}
+
+// OutboxCursor returns the last event consumer has processed, or zero when
+// it has not started.
+func (s *Store) OutboxCursor(ctx context.Context, consumer string) (int64, error) {
+	var id int64
+	err := s.DB.QueryRowContext(ctx, `SELECT last_id FROM outbox_cursors WHERE consumer = ?`, consumer).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// AdvanceOutboxCursor records that consumer has processed every event up to
+// id. Consumers advance only after delivering, so a crash replays events
+// rather than dropping them.
+func (s *Store) AdvanceOutboxCursor(ctx context.Context, consumer string, id int64) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO outbox_cursors(consumer, last_id, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(consumer) DO UPDATE SET last_id = MAX(last_id, excluded.last_id), updated_at = excluded.updated_at`, consumer, id, now())
+	return err
+}
+
+// PruneOutbox drops events older than olderThan that every consumer has
+// processed.
+func (s *Store) PruneOutbox(ctx context.Context, olderThan time.Duration) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM outbox WHERE created_at < ?
+		AND id <= COALESCE((SELECT MIN(last_id) FROM outbox_cursors), id)`, now().Add(-olderThan).Format("2006-01-02 15:04:05.000"))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Page is a published static index of a folder.
+type Page struct {
+	ID        int64
+	UserID    int64
+	DirID     int64
+	Token     string
+	HTML      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+const pageColumns = `id, user_id, dir_id, token, html, created_at, updated_at`
+
+func scanPage(row rowScanner, p *Page) error {
+	return row.Scan(&p.ID, &p.UserID, &p.DirID, &p.Token, &p.HTML, &p.CreatedAt, &p.UpdatedAt)
+}
+
+// SavePage stores the rendered index of a folder. Republishing a folder
+// replaces its HTML and keeps the original token, so the public URL stays.
+func (s *Store) SavePage(ctx context.Context, userID, dirID int64, token, html string) (Page, error) {
+	ts := now()
+	if _, err := s.DB.ExecContext(ctx, `INSERT INTO pages(user_id, dir_id, token, html, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, dir_id) DO UPDATE SET html = excluded.html, updated_at = excluded.updated_at`,
+		userID, dirID, token, html, ts, ts); err != nil {
+		return Page{}, err
+	}
+	return s.GetPageByDir(ctx, userID, dirID)
+}
+
+// GetPageByDir fetches the page published for a folder.
+func (s *Store) GetPageByDir(ctx context.Context, userID, dirID int64) (Page, error) {
+	var p Page
+	row := s.DB.QueryRowContext(ctx, `SELECT `+pageColumns+` FROM pages WHERE user_id = ? AND dir_id = ?`, userID, dirID)
+	err := scanPage(row, &p)
+	return p, err
+}
+
+// GetPageByToken fetches a published page by its public token.
+func (s *Store) GetPageByToken(ctx context.Context, token string) (Page, error) {
+	var p Page
+	row := s.DB.QueryRowContext(ctx, `SELECT `+pageColumns+` FROM pages WHERE token = ?`, token)
+	err := scanPage(row, &p)
+	return p, err
+}
+
+// DeletePage unpublishes a folder.
+func (s *Store) DeletePage(ctx context.Context, userID, dirID int64) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM pages WHERE user_id = ? AND dir_id = ?`, userID, dirID)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// GetUserPartSize returns the part size the user chose for their WebDAV
+// uploads, or 0 when they use the server's MAX_PART_SIZE_BYTES.
+func (s *Store) GetUserPartSize(ctx context.Context, userID int64) (int64, error) {
+	var size int64
+	err := s.DB.QueryRowContext(ctx, `SELECT part_size FROM users WHERE user_id = ?`, userID).Scan(&size)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return size, err
+}
+
+// SetUserPartSize sets the part size of the user's WebDAV uploads; 0 goes
+// back to the server's. Checking size against the server's limits is the
+// caller's job.
+func (s *Store) SetUserPartSize(ctx context.Context, userID, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("part size is negative: %w", os.ErrInvalid)
+	}
+	res, err := s.DB.ExecContext(ctx, `UPDATE users SET part_size = ? WHERE user_id = ?`, size, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
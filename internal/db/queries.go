@@ -7,19 +7,26 @@ import (
 	"crypto/subtle"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"time"
+
+	sqlite "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 // Directory represents a folder.
 type Directory struct {
-	ID        int64
-	UserID    int64
-	ParentID  sql.NullInt64
-	Name      string
+	ID       int64
+	UserID   int64
+	ParentID sql.NullInt64
+	Name     string
+	PublicID string
+	// Revision goes up by one with every rename or move.
+	Revision  int64
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -34,26 +41,56 @@ type File struct {
 	FileUniqueID string
 	Size         int64
 	MimeType     string
-	CreatedAt    time.Time
+	PublicID     string
+	RefState     string
+	// SHA256 is the hex checksum of the whole content, or "" when it was
+	// never computed.
+	SHA256 string
+	// ChatID and MessageID name the message carrying a single-blob file's
+	// content: the upload in the user's chat or the storage chat post. They
+	// are 0 when unknown and for split files, whose parts carry their own.
+	ChatID    int64
+	MessageID int
+	// Revision goes up by one with every rename, move or content change.
+	Revision  int64
+	CreatedAt time.Time
+	// ModifiedAt is when the content last changed: CreatedAt until the file
+	// is replaced.
+	ModifiedAt time.Time
+}
+
+// IsEmpty reports whether the file is a zero-byte file. Telegram cannot
+// hold empty content, so such files are kept as metadata only: no
+// file_unique_id, blob or parts.
+func (f File) IsEmpty() bool {
+	return f.FileUniqueID == ""
 }
 
 // FilePart represents a chunk of a large file.
 type FilePart struct {
-	ID             int64
-	FileID         int64
-	PartIndex      int
-	TelegramFileID string
-	FileUniqueID   string
-	Size           int64
-	CreatedAt      time.Time
+	ID               int64
+	FileID           int64
+	PartIndex        int
+	TelegramFileID   string
+	FileUniqueID     string
+	Size             int64
+	StorageChatID    int64
+	StorageMessageID int
+	SHA256           string
+	CreatedAt        time.Time
 }
 
-// FilePartInput is used to insert file parts.
+// FilePartInput is used to insert file parts. StorageChatID and
+// StorageMessageID are set when the part was uploaded to a storage chat.
 type FilePartInput struct {
-	PartIndex      int
-	TelegramFileID string
-	FileUniqueID   string
-	Size           int64
+	PartIndex        int
+	TelegramFileID   string
+	FileUniqueID     string
+	Size             int64
+	StorageChatID    int64
+	StorageMessageID int
+	// SHA256 is recorded on the part's blob when it has none yet.
+	SHA256 string `json:",omitempty"`
 }
 
 // WebDAVUpload tracks an in-progress WebDAV upload.
@@ -65,37 +102,58 @@ type WebDAVUpload struct {
 	TotalSize    int64
 	UploadedSize int64
 	MimeType     string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	// HashState is the marshaled SHA-256 state over the parts stored so
+	// far, so a resumed upload can still checksum the whole file.
+	HashState []byte
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 // WebDAVUploadPart represents a persisted upload part.
 type WebDAVUploadPart struct {
-	ID             int64
-	UploadID       int64
-	PartIndex      int
-	TelegramFileID string
-	FileUniqueID   string
-	Size           int64
-	CreatedAt      time.Time
+	ID               int64
+	UploadID         int64
+	PartIndex        int
+	TelegramFileID   string
+	FileUniqueID     string
+	Size             int64
+	StorageChatID    int64
+	StorageMessageID int
+	SHA256           string
+	CreatedAt        time.Time
 }
 
 // WebDAVUploadPartInput is used to insert upload parts.
 type WebDAVUploadPartInput struct {
-	PartIndex      int
-	TelegramFileID string
-	FileUniqueID   string
-	Size           int64
+	PartIndex        int
+	TelegramFileID   string
+	FileUniqueID     string
+	Size             int64
+	StorageChatID    int64
+	StorageMessageID int
+	SHA256           string
+	// HashState replaces the upload's HashState when set.
+	HashState []byte
 }
 
 // Share represents a share link.
 type Share struct {
-	ID        int64
-	FileID    int64
-	Token     string
-	ExpiresAt sql.NullTime
-	Uses      int64
-	CreatedAt time.Time
+	ID     int64
+	FileID int64
+	// OwnerUserID owns the shared file and alone may revoke the share.
+	OwnerUserID int64
+	Token       string
+	ExpiresAt   sql.NullTime
+	Uses        int64
+	// RecipientID and RecipientUsername restrict a share to one Telegram
+	// user. Both are empty for a public share.
+	RecipientID       int64
+	RecipientUsername string
+	CreatedAt         time.Time
+	// passwordSalt and passwordHash protect the share's HTTP download; see
+	// CheckPassword.
+	passwordSalt string
+	passwordHash string
 }
 
 // UserState keeps UI state for a user.
@@ -108,10 +166,63 @@ type UserState struct {
 	UpdatedAt      time.Time
 }
 
+const dirColumns = `id, user_id, parent_id, name, public_id, revision, created_at, updated_at`
+
+// fileSelect reads files joined with the blob that carries their Telegram
+// file_id. A file stored as one blob falls back to the blob's checksum.
+const fileSelect = `SELECT f.id, f.user_id, f.dir_id, f.name, COALESCE(b.file_id, ''), f.file_unique_id, f.size, f.mime_type, f.public_id, f.ref_state,
+		CASE WHEN f.sha256 != '' THEN f.sha256 WHEN NOT EXISTS (SELECT 1 FROM file_parts p WHERE p.file_id = f.id) THEN COALESCE(b.sha256, '') ELSE '' END, f.chat_id, f.message_id, f.revision, f.created_at, f.modified_at
+	FROM files f LEFT JOIN blobs b ON b.file_unique_id = f.file_unique_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDir(row rowScanner, d *Directory) error {
+	return row.Scan(&d.ID, &d.UserID, &d.ParentID, &d.Name, &d.PublicID, &d.Revision, &d.CreatedAt, &d.UpdatedAt)
+}
+
+const shareColumns = `id, file_id, owner_user_id, token, expires_at, uses, recipient_id, recipient_username, created_at, password_salt, password_hash`
+
+func scanShare(row rowScanner, sh *Share) error {
+	return row.Scan(&sh.ID, &sh.FileID, &sh.OwnerUserID, &sh.Token, &sh.ExpiresAt, &sh.Uses, &sh.RecipientID, &sh.RecipientUsername, &sh.CreatedAt, &sh.passwordSalt, &sh.passwordHash)
+}
+
+func scanFile(row rowScanner, f *File) error {
+	var modified sql.NullTime
+	if err := row.Scan(&f.ID, &f.UserID, &f.DirID, &f.Name, &f.FileID, &f.FileUniqueID, &f.Size, &f.MimeType, &f.PublicID, &f.RefState, &f.SHA256, &f.ChatID, &f.MessageID, &f.Revision, &f.CreatedAt, &modified); err != nil {
+		return err
+	}
+	f.ModifiedAt = f.CreatedAt
+	if modified.Valid {
+		f.ModifiedAt = modified.Time
+	}
+	return nil
+}
+
 func nameConflictError() error {
 	return fmt.Errorf("name already exists: %w", os.ErrExist)
 }
 
+// nameConflict turns SQLite rejecting a duplicate name in a folder into
+// nameConflictError. The unique name indexes catch writers that race past
+// ensureNameAvailable; a file, a folder and a shortcut sharing a name is only
+// caught by the check.
+func nameConflict(err error) error {
+	var se *sqlite.Error
+	if errors.As(err, &se) && se.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE &&
+		(strings.Contains(se.Error(), "files.name") || strings.Contains(se.Error(), "directories.name") || strings.Contains(se.Error(), "shortcuts.name")) {
+		return nameConflictError()
+	}
+	return err
+}
+
+// CheckNameAvailable reports a conflict error when parentID already holds a
+// folder or file called name.
+func (s *Store) CheckNameAvailable(ctx context.Context, userID, parentID int64, name string) error {
+	return s.ensureNameAvailable(ctx, userID, parentID, name, 0, 0)
+}
+
 func (s *Store) ensureNameAvailable(ctx context.Context, userID, parentID int64, name string, excludeDirID, excludeFileID int64) error {
 	dir, err := s.GetDirByName(ctx, userID, parentID, name)
 	if err == nil {
@@ -129,6 +240,11 @@ func (s *Store) ensureNameAvailable(ctx context.Context, userID, parentID int64,
 	} else if err != sql.ErrNoRows {
 		return err
 	}
+	if _, err := s.GetShortcutByName(ctx, userID, parentID, name); err == nil {
+		return nameConflictError()
+	} else if err != sql.ErrNoRows {
+		return err
+	}
 	return nil
 }
 
@@ -152,10 +268,10 @@ func (s *Store) EnsureUser(ctx context.Context, userID int64) (int64, error) {
 	}
 
 	var rootID int64
-	row := tx.QueryRowContext(ctx, `SELECT id FROM directories WHERE user_id = ? AND parent_id IS NULL LIMIT 1`, userID)
+	row := tx.QueryRowContext(ctx, `SELECT id FROM directories WHERE user_id = ? AND parent_id IS NULL ORDER BY id LIMIT 1`, userID)
 	scanErr := row.Scan(&rootID)
 	if scanErr == sql.ErrNoRows {
-		res, err := tx.ExecContext(ctx, `INSERT INTO directories(user_id, parent_id, name, created_at, updated_at) VALUES (?, NULL, ?, ?, ?)`, userID, "/", now(), now())
+		res, err := tx.ExecContext(ctx, `INSERT INTO directories(user_id, parent_id, name, public_id, created_at, updated_at) VALUES (?, NULL, ?, ?, ?, ?)`, userID, "/", newPublicID(), now(), now())
 		if err != nil {
 			return 0, err
 		}
@@ -163,6 +279,9 @@ func (s *Store) EnsureUser(ctx context.Context, userID int64) (int64, error) {
 		if err != nil {
 			return 0, err
 		}
+		if _, err = tx.ExecContext(ctx, `INSERT INTO drives(user_id, root_dir_id, label, created_at) VALUES (?, ?, ?, ?)`, userID, rootID, DefaultDriveLabel, now()); err != nil {
+			return 0, err
+		}
 	} else if scanErr != nil {
 		return 0, scanErr
 	}
@@ -217,7 +336,11 @@ func (s *Store) SetWebDAVPassword(ctx context.Context, userID int64, password st
 		VALUES (?, ?, ?, ?)
 		ON CONFLICT(user_id) DO UPDATE SET password_salt = excluded.password_salt, password_hash = excluded.password_hash, updated_at = excluded.updated_at`,
 		userID, hex.EncodeToString(salt), hash, now())
-	return err
+	if err != nil {
+		return err
+	}
+	s.audit(ctx, userID, AuditAuthPassword, userID, "", "WebDAV password set")
+	return nil
 }
 
 // WebDAVPasswordSet checks if a password is configured.
@@ -255,10 +378,10 @@ func (s *Store) VerifyWebDAVPassword(ctx context.Context, userID int64, password
 	return ok, nil
 }
 
-// GetRootDirID returns the root dir ID for a user.
+// GetRootDirID returns the root dir ID of the user's primary drive.
 func (s *Store) GetRootDirID(ctx context.Context, userID int64) (int64, error) {
 	var rootID int64
-	row := s.DB.QueryRowContext(ctx, `SELECT id FROM directories WHERE user_id = ? AND parent_id IS NULL LIMIT 1`, userID)
+	row := s.DB.QueryRowContext(ctx, `SELECT id FROM directories WHERE user_id = ? AND parent_id IS NULL ORDER BY id LIMIT 1`, userID)
 	if err := row.Scan(&rootID); err != nil {
 		if err == sql.ErrNoRows {
 			return s.EnsureUser(ctx, userID)
@@ -271,8 +394,29 @@ func (s *Store) GetRootDirID(ctx context.Context, userID int64) (int64, error) {
 // GetDirByID fetches a directory by ID.
 func (s *Store) GetDirByID(ctx context.Context, userID, dirID int64) (Directory, error) {
 	var d Directory
-	row := s.DB.QueryRowContext(ctx, `SELECT id, user_id, parent_id, name, created_at, updated_at FROM directories WHERE id = ? AND user_id = ?`, dirID, userID)
-	if err := row.Scan(&d.ID, &d.UserID, &d.ParentID, &d.Name, &d.CreatedAt, &d.UpdatedAt); err != nil {
+	row := s.DB.QueryRowContext(ctx, `SELECT `+dirColumns+` FROM directories WHERE id = ? AND user_id = ?`, dirID, userID)
+	if err := scanDir(row, &d); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// GetDirByPublicID fetches a directory by its public UUID.
+func (s *Store) GetDirByPublicID(ctx context.Context, userID int64, publicID string) (Directory, error) {
+	var d Directory
+	row := s.DB.QueryRowContext(ctx, `SELECT `+dirColumns+` FROM directories WHERE public_id = ? AND user_id = ?`, publicID, userID)
+	if err := scanDir(row, &d); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// FindDirByPublicID fetches a directory by its public UUID regardless of
+// owner, for signed links, which name no user.
+func (s *Store) FindDirByPublicID(ctx context.Context, publicID string) (Directory, error) {
+	var d Directory
+	row := s.DB.QueryRowContext(ctx, `SELECT `+dirColumns+` FROM directories WHERE public_id = ?`, publicID)
+	if err := scanDir(row, &d); err != nil {
 		return d, err
 	}
 	return d, nil
@@ -281,16 +425,48 @@ func (s *Store) GetDirByID(ctx context.Context, userID, dirID int64) (Directory,
 // GetDirByName finds a child directory by name.
 func (s *Store) GetDirByName(ctx context.Context, userID, parentID int64, name string) (Directory, error) {
 	var d Directory
-	row := s.DB.QueryRowContext(ctx, `SELECT id, user_id, parent_id, name, created_at, updated_at FROM directories WHERE user_id = ? AND parent_id = ? AND name = ?`, userID, parentID, name)
-	if err := row.Scan(&d.ID, &d.UserID, &d.ParentID, &d.Name, &d.CreatedAt, &d.UpdatedAt); err != nil {
+	row := s.DB.QueryRowContext(ctx, `SELECT `+dirColumns+` FROM directories WHERE user_id = ? AND parent_id = ? AND name = ?`, userID, parentID, name)
+	if err := scanDir(row, &d); err != nil {
 		return d, err
 	}
 	return d, nil
 }
 
+// ListPage selects a slice of a listing ordered by name. After resumes past the
+// last name already seen and Offset skips rows; a Limit of 0 or less
+// returns everything that is left.
+type ListPage struct {
+	After  string
+	Offset int
+	Limit  int
+}
+
+func (p ListPage) where(column string) (string, []any) {
+	if p.After == "" {
+		return "", nil
+	}
+	return ` AND ` + column + ` > ?`, []any{p.After}
+}
+
+func (p ListPage) limit() (string, []any) {
+	limit := p.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	return ` LIMIT ? OFFSET ?`, []any{limit, max(p.Offset, 0)}
+}
+
 // ListDirs lists directories under a parent.
 func (s *Store) ListDirs(ctx context.Context, userID, parentID int64) ([]Directory, error) {
-	rows, err := s.DB.QueryContext(ctx, `SELECT id, user_id, parent_id, name, created_at, updated_at FROM directories WHERE user_id = ? AND parent_id = ? ORDER BY name`, userID, parentID)
+	return s.ListDirsPage(ctx, userID, parentID, ListPage{})
+}
+
+// ListDirsPage lists one page of the directories under a parent.
+func (s *Store) ListDirsPage(ctx context.Context, userID, parentID int64, page ListPage) ([]Directory, error) {
+	after, afterArgs := page.where("name")
+	limit, limitArgs := page.limit()
+	args := append(append([]any{userID, parentID}, afterArgs...), limitArgs...)
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+dirColumns+` FROM directories WHERE user_id = ? AND parent_id = ?`+after+` ORDER BY name`+limit, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -298,7 +474,7 @@ func (s *Store) ListDirs(ctx context.Context, userID, parentID int64) ([]Directo
 	var dirs []Directory
 	for rows.Next() {
 		var d Directory
-		if err := rows.Scan(&d.ID, &d.UserID, &d.ParentID, &d.Name, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		if err := scanDir(rows, &d); err != nil {
 			return nil, err
 		}
 		dirs = append(dirs, d)
@@ -308,7 +484,59 @@ func (s *Store) ListDirs(ctx context.Context, userID, parentID int64) ([]Directo
 
 // ListFiles lists files under a directory.
 func (s *Store) ListFiles(ctx context.Context, userID, dirID int64) ([]File, error) {
-	rows, err := s.DB.QueryContext(ctx, `SELECT id, user_id, dir_id, name, file_id, file_unique_id, size, mime_type, created_at FROM files WHERE user_id = ? AND dir_id = ? ORDER BY name`, userID, dirID)
+	return s.ListFilesPage(ctx, userID, dirID, ListPage{})
+}
+
+// ListFilesPage lists one page of the files under a directory.
+func (s *Store) ListFilesPage(ctx context.Context, userID, dirID int64, page ListPage) ([]File, error) {
+	after, afterArgs := page.where("f.name")
+	limit, limitArgs := page.limit()
+	args := append(append([]any{userID, dirID}, afterArgs...), limitArgs...)
+	rows, err := s.DB.QueryContext(ctx, fileSelect+` WHERE f.user_id = ? AND f.dir_id = ?`+after+` ORDER BY f.name`+limit, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// CountChildren counts the directories and files directly under dirID.
+func (s *Store) CountChildren(ctx context.Context, userID, dirID int64) (dirs, files int, err error) {
+	err = s.DB.QueryRowContext(ctx, `SELECT
+		(SELECT COUNT(*) FROM directories WHERE user_id = ? AND parent_id = ?),
+		(SELECT COUNT(*) FROM files WHERE user_id = ? AND dir_id = ?)`, userID, dirID, userID, dirID).Scan(&dirs, &files)
+	return dirs, files, err
+}
+
+// ListAllDirs lists every directory owned by a user.
+func (s *Store) ListAllDirs(ctx context.Context, userID int64) ([]Directory, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+dirColumns+` FROM directories WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var dirs []Directory
+	for rows.Next() {
+		var d Directory
+		if err := scanDir(rows, &d); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, rows.Err()
+}
+
+// ListAllFiles lists every file owned by a user.
+func (s *Store) ListAllFiles(ctx context.Context, userID int64) ([]File, error) {
+	rows, err := s.DB.QueryContext(ctx, fileSelect+` WHERE f.user_id = ? ORDER BY f.dir_id, f.name`, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -316,7 +544,7 @@ func (s *Store) ListFiles(ctx context.Context, userID, dirID int64) ([]File, err
 	var files []File
 	for rows.Next() {
 		var f File
-		if err := rows.Scan(&f.ID, &f.UserID, &f.DirID, &f.Name, &f.FileID, &f.FileUniqueID, &f.Size, &f.MimeType, &f.CreatedAt); err != nil {
+		if err := scanFile(rows, &f); err != nil {
 			return nil, err
 		}
 		files = append(files, f)
@@ -329,14 +557,15 @@ func (s *Store) CreateDir(ctx context.Context, userID, parentID int64, name stri
 	if err := s.ensureNameAvailable(ctx, userID, parentID, name, 0, 0); err != nil {
 		return Directory{}, err
 	}
-	res, err := s.DB.ExecContext(ctx, `INSERT INTO directories(user_id, parent_id, name, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`, userID, parentID, name, now(), now())
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO directories(user_id, parent_id, name, public_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`, userID, parentID, name, newPublicID(), now(), now())
 	if err != nil {
-		return Directory{}, err
+		return Directory{}, nameConflict(err)
 	}
 	id, err := res.LastInsertId()
 	if err != nil {
 		return Directory{}, err
 	}
+	s.audit(ctx, userID, AuditDirCreate, id, name, fmt.Sprintf("in folder %d", parentID))
 	return s.GetDirByID(ctx, userID, id)
 }
 
@@ -353,24 +582,26 @@ func (s *Store) RenameDir(ctx context.Context, userID, dirID int64, name string)
 	if err := s.ensureNameAvailable(ctx, userID, parentID, name, dirID, 0); err != nil {
 		return err
 	}
-	res, err := s.DB.ExecContext(ctx, `UPDATE directories SET name = ?, updated_at = ? WHERE id = ? AND user_id = ?`, name, now(), dirID, userID)
+	cond, args := revisionClause(ctx, []any{name, now(), dirID, userID})
+	res, err := s.DB.ExecContext(ctx, `UPDATE directories SET name = ?, updated_at = ? WHERE id = ? AND user_id = ?`+cond, args...)
 	if err != nil {
-		return err
+		return nameConflict(err)
 	}
 	count, _ := res.RowsAffected()
 	if count == 0 {
-		return sql.ErrNoRows
+		return noRowsError(ctx)
 	}
+	s.audit(ctx, userID, AuditDirRename, dirID, name, "from "+dir.Name)
 	return nil
 }
 
 // MoveDir moves a directory under a new parent.
 func (s *Store) MoveDir(ctx context.Context, userID, dirID, newParentID int64) error {
-	rootID, err := s.GetRootDirID(ctx, userID)
+	dir, err := s.GetDirByID(ctx, userID, dirID)
 	if err != nil {
 		return err
 	}
-	if dirID == rootID {
+	if !dir.ParentID.Valid {
 		return errors.New("cannot move root directory")
 	}
 	if dirID == newParentID {
@@ -383,34 +614,43 @@ func (s *Store) MoveDir(ctx context.Context, userID, dirID, newParentID int64) e
 	if isDesc {
 		return errors.New("cannot move directory into its descendant")
 	}
-	dir, err := s.GetDirByID(ctx, userID, dirID)
-	if err != nil {
-		return err
-	}
 	if err := s.ensureNameAvailable(ctx, userID, newParentID, dir.Name, dirID, 0); err != nil {
 		return err
 	}
-	res, err := s.DB.ExecContext(ctx, `UPDATE directories SET parent_id = ?, updated_at = ? WHERE id = ? AND user_id = ?`, newParentID, now(), dirID, userID)
+	cond, args := revisionClause(ctx, []any{newParentID, now(), dirID, userID})
+	res, err := s.DB.ExecContext(ctx, `UPDATE directories SET parent_id = ?, updated_at = ? WHERE id = ? AND user_id = ?`+cond, args...)
 	if err != nil {
-		return err
+		return nameConflict(err)
 	}
 	count, _ := res.RowsAffected()
 	if count == 0 {
-		return sql.ErrNoRows
+		return noRowsError(ctx)
 	}
+	s.audit(ctx, userID, AuditDirMove, dirID, dir.Name, fmt.Sprintf("from folder %d to folder %d", dir.ParentID.Int64, newParentID))
 	return nil
 }
 
 // DeleteDirRecursive deletes a directory and its contents.
 func (s *Store) DeleteDirRecursive(ctx context.Context, userID, dirID int64) error {
-	rootID, err := s.GetRootDirID(ctx, userID)
+	dir, err := s.GetDirByID(ctx, userID, dirID)
 	if err != nil {
 		return err
 	}
-	if dirID == rootID {
+	if !dir.ParentID.Valid {
 		return errors.New("cannot delete root directory")
 	}
-	_, err = s.DB.ExecContext(ctx, `WITH RECURSIVE subtree(id) AS (
+	if err := checkRevision(ctx, dir.Revision); err != nil {
+		return err
+	}
+	if err := deleteSubtree(ctx, s.DB, userID, dirID); err != nil {
+		return err
+	}
+	s.audit(ctx, userID, AuditDirDelete, dirID, dir.Name, "")
+	return nil
+}
+
+func deleteSubtree(ctx context.Context, tx execer, userID, dirID int64) error {
+	_, err := tx.ExecContext(ctx, `WITH RECURSIVE subtree(id) AS (
 		SELECT id FROM directories WHERE id = ? AND user_id = ?
 		UNION ALL
 		SELECT d.id FROM directories d JOIN subtree s ON d.parent_id = s.id
@@ -418,7 +658,7 @@ func (s *Store) DeleteDirRecursive(ctx context.Context, userID, dirID int64) err
 	if err != nil {
 		return err
 	}
-	_, err = s.DB.ExecContext(ctx, `WITH RECURSIVE subtree(id) AS (
+	_, err = tx.ExecContext(ctx, `WITH RECURSIVE subtree(id) AS (
 		SELECT id FROM directories WHERE id = ? AND user_id = ?
 		UNION ALL
 		SELECT d.id FROM directories d JOIN subtree s ON d.parent_id = s.id
@@ -428,21 +668,7 @@ func (s *Store) DeleteDirRecursive(ctx context.Context, userID, dirID int64) err
 
 // CreateFile inserts a file record.
 func (s *Store) CreateFile(ctx context.Context, userID, dirID int64, name, fileID, fileUniqueID string, size int64, mimeType string) (File, error) {
-	if err := s.ensureNameAvailable(ctx, userID, dirID, name, 0, 0); err != nil {
-		return File{}, err
-	}
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
-	}
-	res, err := s.DB.ExecContext(ctx, `INSERT INTO files(user_id, dir_id, name, file_id, file_unique_id, size, mime_type, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, userID, dirID, name, fileID, fileUniqueID, size, mimeType, now())
-	if err != nil {
-		return File{}, err
-	}
-	id, err := res.LastInsertId()
-	if err != nil {
-		return File{}, err
-	}
-	return s.GetFileByID(ctx, userID, id)
+	return s.CreateFileWithParts(ctx, userID, dirID, name, fileID, fileUniqueID, size, mimeType, nil)
 }
 
 // CreateFileWithParts inserts a file and its parts.
@@ -450,6 +676,9 @@ func (s *Store) CreateFileWithParts(ctx context.Context, userID, dirID int64, na
 	if err := s.ensureNameAvailable(ctx, userID, dirID, name, 0, 0); err != nil {
 		return File{}, err
 	}
+	if err := s.CheckQuota(ctx, userID, name, size); err != nil {
+		return File{}, err
+	}
 	if mimeType == "" {
 		mimeType = "application/octet-stream"
 	}
@@ -464,10 +693,27 @@ func (s *Store) CreateFileWithParts(ctx context.Context, userID, dirID int64, na
 		}
 	}()
 
-	res, err := tx.ExecContext(ctx, `INSERT INTO files(user_id, dir_id, name, file_id, file_unique_id, size, mime_type, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, userID, dirID, name, fileID, fileUniqueID, size, mimeType, now())
+	if len(parts) <= 1 {
+		if err := putBlobTx(ctx, tx, fileUniqueID, fileID, size); err != nil {
+			return File{}, err
+		}
+		if err := setSingleBlobStorageTx(ctx, tx, fileUniqueID, parts); err != nil {
+			return File{}, err
+		}
+		if err := setSingleBlobChecksumTx(ctx, tx, fileUniqueID, parts); err != nil {
+			return File{}, err
+		}
+	} else if err := putPartBlobsTx(ctx, tx, parts); err != nil {
+		return File{}, err
+	}
+	chatID, messageID, err := singleBlobMessageTx(ctx, tx, fileUniqueID, parts)
 	if err != nil {
 		return File{}, err
 	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO files(user_id, dir_id, name, file_unique_id, size, mime_type, public_id, chat_id, message_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, userID, dirID, name, fileUniqueID, size, mimeType, newPublicID(), chatID, messageID, now())
+	if err != nil {
+		return File{}, nameConflict(err)
+	}
 	fileRowID, err := res.LastInsertId()
 	if err != nil {
 		return File{}, err
@@ -481,6 +727,7 @@ func (s *Store) CreateFileWithParts(ctx context.Context, userID, dirID int64, na
 		return File{}, err
 	}
 	committed = true
+	s.audit(ctx, userID, AuditFileCreate, fileRowID, name, fmt.Sprintf("%d bytes in folder %d", size, dirID))
 	return s.GetFileByID(ctx, userID, fileRowID)
 }
 
@@ -493,6 +740,9 @@ func (s *Store) ReplaceFileWithParts(ctx context.Context, userID, fileID int64,
 	if err := s.ensureNameAvailable(ctx, userID, file.DirID, name, 0, fileID); err != nil {
 		return err
 	}
+	if err := s.CheckQuota(ctx, userID, name, size-file.Size); err != nil {
+		return err
+	}
 	if mimeType == "" {
 		mimeType = "application/octet-stream"
 	}
@@ -507,13 +757,31 @@ func (s *Store) ReplaceFileWithParts(ctx context.Context, userID, fileID int64,
 		}
 	}()
 
-	res, err := tx.ExecContext(ctx, `UPDATE files SET name = ?, file_id = ?, file_unique_id = ?, size = ?, mime_type = ? WHERE id = ? AND user_id = ?`, name, telegramFileID, fileUniqueID, size, mimeType, fileID, userID)
+	if len(parts) <= 1 {
+		if err := putBlobTx(ctx, tx, fileUniqueID, telegramFileID, size); err != nil {
+			return err
+		}
+		if err := setSingleBlobStorageTx(ctx, tx, fileUniqueID, parts); err != nil {
+			return err
+		}
+		if err := setSingleBlobChecksumTx(ctx, tx, fileUniqueID, parts); err != nil {
+			return err
+		}
+	} else if err := putPartBlobsTx(ctx, tx, parts); err != nil {
+		return err
+	}
+	chatID, messageID, err := singleBlobMessageTx(ctx, tx, fileUniqueID, parts)
 	if err != nil {
 		return err
 	}
+	cond, args := revisionClause(ctx, []any{name, fileUniqueID, size, mimeType, chatID, messageID, fileID, userID})
+	res, err := tx.ExecContext(ctx, `UPDATE files SET name = ?, file_unique_id = ?, size = ?, mime_type = ?, sha256 = '', chat_id = ?, message_id = ? WHERE id = ? AND user_id = ?`+cond, args...)
+	if err != nil {
+		return nameConflict(err)
+	}
 	affected, _ := res.RowsAffected()
 	if affected == 0 {
-		return sql.ErrNoRows
+		return noRowsError(ctx)
 	}
 	if _, err := tx.ExecContext(ctx, `DELETE FROM file_parts WHERE file_id = ?`, fileID); err != nil {
 		return err
@@ -527,6 +795,7 @@ func (s *Store) ReplaceFileWithParts(ctx context.Context, userID, fileID int64,
 		return err
 	}
 	committed = true
+	s.audit(ctx, userID, AuditFileReplace, fileID, name, fmt.Sprintf("%d bytes", size))
 	return nil
 }
 
@@ -535,7 +804,20 @@ func (s *Store) UpdateFileTelegram(ctx context.Context, userID, fileID int64, te
 	if mimeType == "" {
 		mimeType = "application/octet-stream"
 	}
-	res, err := s.DB.ExecContext(ctx, `UPDATE files SET file_id = ?, file_unique_id = ?, size = ?, mime_type = ? WHERE id = ? AND user_id = ?`, telegramFileID, fileUniqueID, size, mimeType, fileID, userID)
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+	if err := putBlobTx(ctx, tx, fileUniqueID, telegramFileID, size); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE files SET file_unique_id = ?, size = ?, mime_type = ?, sha256 = '', chat_id = 0, message_id = 0 WHERE id = ? AND user_id = ?`, fileUniqueID, size, mimeType, fileID, userID)
 	if err != nil {
 		return err
 	}
@@ -543,14 +825,39 @@ func (s *Store) UpdateFileTelegram(ctx context.Context, userID, fileID int64, te
 	if count == 0 {
 		return sql.ErrNoRows
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
 	return nil
 }
 
 // GetFileByID fetches a file by ID.
 func (s *Store) GetFileByID(ctx context.Context, userID, fileID int64) (File, error) {
 	var f File
-	row := s.DB.QueryRowContext(ctx, `SELECT id, user_id, dir_id, name, file_id, file_unique_id, size, mime_type, created_at FROM files WHERE id = ? AND user_id = ?`, fileID, userID)
-	if err := row.Scan(&f.ID, &f.UserID, &f.DirID, &f.Name, &f.FileID, &f.FileUniqueID, &f.Size, &f.MimeType, &f.CreatedAt); err != nil {
+	row := s.DB.QueryRowContext(ctx, fileSelect+` WHERE f.id = ? AND f.user_id = ?`, fileID, userID)
+	if err := scanFile(row, &f); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// GetFileByPublicID fetches a file by its public UUID.
+func (s *Store) GetFileByPublicID(ctx context.Context, userID int64, publicID string) (File, error) {
+	var f File
+	row := s.DB.QueryRowContext(ctx, fileSelect+` WHERE f.public_id = ? AND f.user_id = ?`, publicID, userID)
+	if err := scanFile(row, &f); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// FindFile looks up a file by numeric ID or public UUID regardless of owner.
+// It is meant for admin tooling only.
+func (s *Store) FindFile(ctx context.Context, ref string) (File, error) {
+	var f File
+	row := s.DB.QueryRowContext(ctx, fileSelect+` WHERE f.public_id = ? OR CAST(f.id AS TEXT) = ?`, ref, ref)
+	if err := scanFile(row, &f); err != nil {
 		return f, err
 	}
 	return f, nil
@@ -559,8 +866,8 @@ func (s *Store) GetFileByID(ctx context.Context, userID, fileID int64) (File, er
 // GetFileByName fetches a file by name within a directory.
 func (s *Store) GetFileByName(ctx context.Context, userID, dirID int64, name string) (File, error) {
 	var f File
-	row := s.DB.QueryRowContext(ctx, `SELECT id, user_id, dir_id, name, file_id, file_unique_id, size, mime_type, created_at FROM files WHERE user_id = ? AND dir_id = ? AND name = ?`, userID, dirID, name)
-	if err := row.Scan(&f.ID, &f.UserID, &f.DirID, &f.Name, &f.FileID, &f.FileUniqueID, &f.Size, &f.MimeType, &f.CreatedAt); err != nil {
+	row := s.DB.QueryRowContext(ctx, fileSelect+` WHERE f.user_id = ? AND f.dir_id = ? AND f.name = ?`, userID, dirID, name)
+	if err := scanFile(row, &f); err != nil {
 		return f, err
 	}
 	return f, nil
@@ -575,14 +882,16 @@ func (s *Store) RenameFile(ctx context.Context, userID, fileID int64, name strin
 	if err := s.ensureNameAvailable(ctx, userID, file.DirID, name, 0, fileID); err != nil {
 		return err
 	}
-	res, err := s.DB.ExecContext(ctx, `UPDATE files SET name = ? WHERE id = ? AND user_id = ?`, name, fileID, userID)
+	cond, args := revisionClause(ctx, []any{name, fileID, userID})
+	res, err := s.DB.ExecContext(ctx, `UPDATE files SET name = ? WHERE id = ? AND user_id = ?`+cond, args...)
 	if err != nil {
-		return err
+		return nameConflict(err)
 	}
 	count, _ := res.RowsAffected()
 	if count == 0 {
-		return sql.ErrNoRows
+		return noRowsError(ctx)
 	}
+	s.audit(ctx, userID, AuditFileRename, fileID, name, "from "+file.Name)
 	return nil
 }
 
@@ -595,33 +904,136 @@ func (s *Store) MoveFile(ctx context.Context, userID, fileID, newDirID int64) er
 	if err := s.ensureNameAvailable(ctx, userID, newDirID, file.Name, 0, fileID); err != nil {
 		return err
 	}
-	res, err := s.DB.ExecContext(ctx, `UPDATE files SET dir_id = ? WHERE id = ? AND user_id = ?`, newDirID, fileID, userID)
+	cond, args := revisionClause(ctx, []any{newDirID, fileID, userID})
+	res, err := s.DB.ExecContext(ctx, `UPDATE files SET dir_id = ? WHERE id = ? AND user_id = ?`+cond, args...)
 	if err != nil {
-		return err
+		return nameConflict(err)
 	}
 	count, _ := res.RowsAffected()
 	if count == 0 {
-		return sql.ErrNoRows
+		return noRowsError(ctx)
+	}
+	s.audit(ctx, userID, AuditFileMove, fileID, file.Name, fmt.Sprintf("from folder %d to folder %d", file.DirID, newDirID))
+	return nil
+}
+
+// MoveAndRename moves the folder dirID, or else the file fileID, under
+// newParentID and renames it to name in one transaction, so a failure
+// leaves it untouched. Only the target name has to be free in newParentID.
+func (s *Store) MoveAndRename(ctx context.Context, userID, dirID, fileID, newParentID int64, name string) error {
+	if (dirID == 0) == (fileID == 0) {
+		return fmt.Errorf("move needs exactly one of a folder or a file: %w", os.ErrInvalid)
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var oldName string
+	var oldParent sql.NullInt64
+	if dirID != 0 {
+		err = tx.QueryRowContext(ctx, `SELECT name, parent_id FROM directories WHERE id = ? AND user_id = ?`, dirID, userID).Scan(&oldName, &oldParent)
+	} else {
+		err = tx.QueryRowContext(ctx, `SELECT name, dir_id FROM files WHERE id = ? AND user_id = ?`, fileID, userID).Scan(&oldName, &oldParent)
+	}
+	if err != nil {
+		return err
+	}
+	if dirID != 0 {
+		if !oldParent.Valid {
+			return errors.New("cannot move root directory")
+		}
+		if dirID == newParentID {
+			return errors.New("cannot move directory into itself")
+		}
+		var inside bool
+		if err := tx.QueryRowContext(ctx, `WITH RECURSIVE subtree(id) AS (
+			SELECT id FROM directories WHERE id = ? AND user_id = ?
+			UNION ALL
+			SELECT d.id FROM directories d JOIN subtree s ON d.parent_id = s.id
+		) SELECT EXISTS (SELECT 1 FROM subtree WHERE id = ?)`, dirID, userID, newParentID).Scan(&inside); err != nil {
+			return err
+		}
+		if inside {
+			return errors.New("cannot move directory into its descendant")
+		}
+	}
+	var taken bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM directories WHERE user_id = ? AND parent_id = ? AND name = ? AND id != ?)
+		OR EXISTS (SELECT 1 FROM files WHERE user_id = ? AND dir_id = ? AND name = ? AND id != ?)
+		OR EXISTS (SELECT 1 FROM shortcuts WHERE user_id = ? AND dir_id = ? AND name = ?)`,
+		userID, newParentID, name, dirID, userID, newParentID, name, fileID, userID, newParentID, name).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return nameConflictError()
+	}
+
+	var res sql.Result
+	if dirID != 0 {
+		cond, args := revisionClause(ctx, []any{newParentID, name, now(), dirID, userID})
+		res, err = tx.ExecContext(ctx, `UPDATE directories SET parent_id = ?, name = ?, updated_at = ? WHERE id = ? AND user_id = ?`+cond, args...)
+	} else {
+		cond, args := revisionClause(ctx, []any{newParentID, name, fileID, userID})
+		res, err = tx.ExecContext(ctx, `UPDATE files SET dir_id = ?, name = ? WHERE id = ? AND user_id = ?`+cond, args...)
+	}
+	if err != nil {
+		return nameConflict(err)
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return noRowsError(ctx)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+
+	moveAction, renameAction, targetID := AuditFileMove, AuditFileRename, fileID
+	if dirID != 0 {
+		moveAction, renameAction, targetID = AuditDirMove, AuditDirRename, dirID
+	}
+	if oldParent.Int64 != newParentID {
+		s.audit(ctx, userID, moveAction, targetID, name, fmt.Sprintf("from folder %d to folder %d", oldParent.Int64, newParentID))
+	}
+	if oldName != name {
+		s.audit(ctx, userID, renameAction, targetID, name, "from "+oldName)
 	}
 	return nil
 }
 
 // DeleteFile removes a file record.
 func (s *Store) DeleteFile(ctx context.Context, userID, fileID int64) error {
-	res, err := s.DB.ExecContext(ctx, `DELETE FROM files WHERE id = ? AND user_id = ?`, fileID, userID)
+	var name string
+	if err := s.DB.QueryRowContext(ctx, `SELECT name FROM files WHERE id = ? AND user_id = ?`, fileID, userID).Scan(&name); err != nil {
+		return err
+	}
+	cond, args := revisionClause(ctx, []any{fileID, userID})
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM files WHERE id = ? AND user_id = ?`+cond, args...)
 	if err != nil {
 		return err
 	}
 	count, _ := res.RowsAffected()
 	if count == 0 {
-		return sql.ErrNoRows
+		return noRowsError(ctx)
 	}
+	s.audit(ctx, userID, AuditFileDelete, fileID, name, "")
 	return nil
 }
 
 // ListFileParts returns the parts for a file ordered by index.
 func (s *Store) ListFileParts(ctx context.Context, fileID int64) ([]FilePart, error) {
-	rows, err := s.DB.QueryContext(ctx, `SELECT id, file_id, part_index, telegram_file_id, file_unique_id, size, created_at FROM file_parts WHERE file_id = ? ORDER BY part_index`, fileID)
+	rows, err := s.DB.QueryContext(ctx, `SELECT p.id, p.file_id, p.part_index, COALESCE(b.file_id, ''), p.file_unique_id, p.size,
+			CASE WHEN p.message_id != 0 THEN p.chat_id ELSE COALESCE(b.storage_chat_id, 0) END,
+			CASE WHEN p.message_id != 0 THEN p.message_id ELSE COALESCE(b.storage_message_id, 0) END,
+			COALESCE(b.sha256, ''), p.created_at
+		FROM file_parts p LEFT JOIN blobs b ON b.file_unique_id = p.file_unique_id
+		WHERE p.file_id = ? ORDER BY p.part_index`, fileID)
 	if err != nil {
 		return nil, err
 	}
@@ -629,7 +1041,7 @@ func (s *Store) ListFileParts(ctx context.Context, fileID int64) ([]FilePart, er
 	var parts []FilePart
 	for rows.Next() {
 		var p FilePart
-		if err := rows.Scan(&p.ID, &p.FileID, &p.PartIndex, &p.TelegramFileID, &p.FileUniqueID, &p.Size, &p.CreatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.FileID, &p.PartIndex, &p.TelegramFileID, &p.FileUniqueID, &p.Size, &p.StorageChatID, &p.StorageMessageID, &p.SHA256, &p.CreatedAt); err != nil {
 			return nil, err
 		}
 		parts = append(parts, p)
@@ -637,20 +1049,69 @@ func (s *Store) ListFileParts(ctx context.Context, fileID int64) ([]FilePart, er
 	return parts, rows.Err()
 }
 
+// setSingleBlobStorageTx records the storage message of a file stored as
+// one blob, which callers pass as its only part.
+func setSingleBlobStorageTx(ctx context.Context, tx execer, fileUniqueID string, parts []FilePartInput) error {
+	if len(parts) != 1 || parts[0].FileUniqueID != fileUniqueID {
+		return nil
+	}
+	return setBlobStorageTx(ctx, tx, fileUniqueID, parts[0].StorageChatID, parts[0].StorageMessageID)
+}
+
 func insertFilePartsTx(ctx context.Context, tx *sql.Tx, fileID int64, parts []FilePartInput) error {
+	if err := putPartBlobsTx(ctx, tx, parts); err != nil {
+		return err
+	}
 	for _, part := range parts {
-		if _, err := tx.ExecContext(ctx, `INSERT INTO file_parts(file_id, part_index, telegram_file_id, file_unique_id, size, created_at) VALUES (?, ?, ?, ?, ?, ?)`, fileID, part.PartIndex, part.TelegramFileID, part.FileUniqueID, part.Size, now()); err != nil {
+		chatID, messageID, err := storedMessageTx(ctx, tx, part.FileUniqueID, part.StorageChatID, part.StorageMessageID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO file_parts(file_id, part_index, file_unique_id, size, chat_id, message_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`, fileID, part.PartIndex, part.FileUniqueID, part.Size, chatID, messageID, now()); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// singleBlobMessageTx returns the storage message behind a file stored as
+// one blob, which callers pass as its only part.
+func singleBlobMessageTx(ctx context.Context, tx *sql.Tx, fileUniqueID string, parts []FilePartInput) (int64, int, error) {
+	if len(parts) != 1 || parts[0].FileUniqueID != fileUniqueID {
+		return 0, 0, nil
+	}
+	return storedMessageTx(ctx, tx, fileUniqueID, parts[0].StorageChatID, parts[0].StorageMessageID)
+}
+
+// SetFileMessage records the chat message a single-blob file was received
+// as, so it can later be copied or fetched again.
+func (s *Store) SetFileMessage(ctx context.Context, userID, fileID, chatID int64, messageID int) error {
+	res, err := s.DB.ExecContext(ctx, `UPDATE files SET chat_id = ?, message_id = ? WHERE id = ? AND user_id = ?`, chatID, messageID, fileID, userID)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // GetWebDAVUpload loads a WebDAV upload by name within a directory.
 func (s *Store) GetWebDAVUpload(ctx context.Context, userID, dirID int64, name string) (WebDAVUpload, error) {
 	var u WebDAVUpload
-	row := s.DB.QueryRowContext(ctx, `SELECT id, user_id, dir_id, name, total_size, uploaded_size, mime_type, created_at, updated_at FROM webdav_uploads WHERE user_id = ? AND dir_id = ? AND name = ?`, userID, dirID, name)
-	if err := row.Scan(&u.ID, &u.UserID, &u.DirID, &u.Name, &u.TotalSize, &u.UploadedSize, &u.MimeType, &u.CreatedAt, &u.UpdatedAt); err != nil {
+	row := s.DB.QueryRowContext(ctx, `SELECT id, user_id, dir_id, name, total_size, uploaded_size, mime_type, sha256_state, created_at, updated_at FROM webdav_uploads WHERE user_id = ? AND dir_id = ? AND name = ?`, userID, dirID, name)
+	if err := row.Scan(&u.ID, &u.UserID, &u.DirID, &u.Name, &u.TotalSize, &u.UploadedSize, &u.MimeType, &u.HashState, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+// GetWebDAVUploadByID loads one of userID's WebDAV upload sessions.
+func (s *Store) GetWebDAVUploadByID(ctx context.Context, userID, uploadID int64) (WebDAVUpload, error) {
+	var u WebDAVUpload
+	row := s.DB.QueryRowContext(ctx, `SELECT id, user_id, dir_id, name, total_size, uploaded_size, mime_type, sha256_state, created_at, updated_at FROM webdav_uploads WHERE id = ? AND user_id = ?`, uploadID, userID)
+	if err := row.Scan(&u.ID, &u.UserID, &u.DirID, &u.Name, &u.TotalSize, &u.UploadedSize, &u.MimeType, &u.HashState, &u.CreatedAt, &u.UpdatedAt); err != nil {
 		return u, err
 	}
 	return u, nil
@@ -692,15 +1153,44 @@ func (s *Store) UpdateWebDAVUploadTotal(ctx context.Context, uploadID int64, tot
 	return err
 }
 
-// DeleteWebDAVUpload removes an upload session and its parts.
+// DeleteWebDAVUpload removes an upload session and its parts, queueing the
+// storage chat messages of the parts for deletion except those some file,
+// part, blob or other session still points at.
 func (s *Store) DeleteWebDAVUpload(ctx context.Context, uploadID int64) error {
-	_, err := s.DB.ExecContext(ctx, `DELETE FROM webdav_uploads WHERE id = ?`, uploadID)
-	return err
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+	if _, err := tx.ExecContext(ctx, `INSERT INTO storage_deletions(chat_id, message_id)
+		SELECT DISTINCT p.storage_chat_id, p.storage_message_id FROM webdav_upload_parts p
+		WHERE p.upload_id = ? AND p.storage_message_id != 0
+			AND NOT EXISTS (SELECT 1 FROM blobs b WHERE b.storage_chat_id = p.storage_chat_id AND b.storage_message_id = p.storage_message_id)
+			AND NOT EXISTS (SELECT 1 FROM blob_copies c WHERE c.chat_id = p.storage_chat_id AND c.message_id = p.storage_message_id)
+			AND NOT EXISTS (SELECT 1 FROM file_parts fp WHERE fp.chat_id = p.storage_chat_id AND fp.message_id = p.storage_message_id)
+			AND NOT EXISTS (SELECT 1 FROM files f WHERE f.chat_id = p.storage_chat_id AND f.message_id = p.storage_message_id)
+			AND NOT EXISTS (SELECT 1 FROM webdav_upload_parts o
+				WHERE o.upload_id != p.upload_id AND o.storage_chat_id = p.storage_chat_id AND o.storage_message_id = p.storage_message_id)`, uploadID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webdav_uploads WHERE id = ?`, uploadID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
 }
 
 // ListWebDAVUploadParts returns the parts for a WebDAV upload ordered by index.
 func (s *Store) ListWebDAVUploadParts(ctx context.Context, uploadID int64) ([]WebDAVUploadPart, error) {
-	rows, err := s.DB.QueryContext(ctx, `SELECT id, upload_id, part_index, telegram_file_id, file_unique_id, size, created_at FROM webdav_upload_parts WHERE upload_id = ? ORDER BY part_index`, uploadID)
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, upload_id, part_index, telegram_file_id, file_unique_id, size, storage_chat_id, storage_message_id, sha256, created_at FROM webdav_upload_parts WHERE upload_id = ? ORDER BY part_index`, uploadID)
 	if err != nil {
 		return nil, err
 	}
@@ -708,7 +1198,7 @@ func (s *Store) ListWebDAVUploadParts(ctx context.Context, uploadID int64) ([]We
 	var parts []WebDAVUploadPart
 	for rows.Next() {
 		var p WebDAVUploadPart
-		if err := rows.Scan(&p.ID, &p.UploadID, &p.PartIndex, &p.TelegramFileID, &p.FileUniqueID, &p.Size, &p.CreatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.UploadID, &p.PartIndex, &p.TelegramFileID, &p.FileUniqueID, &p.Size, &p.StorageChatID, &p.StorageMessageID, &p.SHA256, &p.CreatedAt); err != nil {
 			return nil, err
 		}
 		parts = append(parts, p)
@@ -730,7 +1220,7 @@ func (s *Store) AddWebDAVUploadPart(ctx context.Context, uploadID int64, part We
 	}()
 
 	createdAt := now()
-	res, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO webdav_upload_parts(upload_id, part_index, telegram_file_id, file_unique_id, size, created_at) VALUES (?, ?, ?, ?, ?, ?)`, uploadID, part.PartIndex, part.TelegramFileID, part.FileUniqueID, part.Size, createdAt)
+	res, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO webdav_upload_parts(upload_id, part_index, telegram_file_id, file_unique_id, size, storage_chat_id, storage_message_id, sha256, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, uploadID, part.PartIndex, part.TelegramFileID, part.FileUniqueID, part.Size, part.StorageChatID, part.StorageMessageID, part.SHA256, createdAt)
 	if err != nil {
 		return err
 	}
@@ -739,6 +1229,11 @@ func (s *Store) AddWebDAVUploadPart(ctx context.Context, uploadID int64, part We
 		if _, err := tx.ExecContext(ctx, `UPDATE webdav_uploads SET uploaded_size = uploaded_size + ?, updated_at = ? WHERE id = ?`, part.Size, createdAt, uploadID); err != nil {
 			return err
 		}
+		if part.HashState != nil {
+			if _, err := tx.ExecContext(ctx, `UPDATE webdav_uploads SET sha256_state = ? WHERE id = ?`, part.HashState, uploadID); err != nil {
+				return err
+			}
+		}
 	} else if _, err := tx.ExecContext(ctx, `UPDATE webdav_uploads SET updated_at = ? WHERE id = ?`, createdAt, uploadID); err != nil {
 		return err
 	}
@@ -754,6 +1249,17 @@ func (s *Store) AddWebDAVUploadPart(ctx context.Context, uploadID int64, part We
 	return nil
 }
 
+// newPublicID returns a random RFC 4122 version 4 UUID.
+func newPublicID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("public id: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
 func hashWebDAVPassword(password string, salt []byte) string {
 	h := sha256.New()
 	_, _ = h.Write(salt)
@@ -761,47 +1267,115 @@ func hashWebDAVPassword(password string, salt []byte) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-// CreateShare creates a share record.
-func (s *Store) CreateShare(ctx context.Context, fileID int64, token string, expiresAt *time.Time) (Share, error) {
+// CreateShare creates a share of userID's file fileID. A file the user does
+// not own is reported as sql.ErrNoRows.
+func (s *Store) CreateShare(ctx context.Context, userID, fileID int64, token string, expiresAt *time.Time) (Share, error) {
+	id, err := s.insertShare(ctx, userID, fileID, token, expiresAt, 0, "")
+	if err != nil {
+		return Share{}, err
+	}
+	s.auditFile(ctx, fileID, AuditShareCreate, shareAuditDetail(expiresAt, ""))
+	return s.getShareByID(ctx, id)
+}
+
+// insertShare adds a share owned by userID, provided userID owns fileID.
+func (s *Store) insertShare(ctx context.Context, userID, fileID int64, token string, expiresAt *time.Time, recipientID int64, recipientUsername string) (int64, error) {
 	var exp any
 	if expiresAt != nil {
 		exp = expiresAt.UTC()
 	}
-	res, err := s.DB.ExecContext(ctx, `INSERT INTO shares(file_id, token, expires_at, uses, created_at) VALUES (?, ?, ?, 0, ?)`, fileID, token, exp, now())
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO shares(file_id, owner_user_id, token, expires_at, uses, recipient_id, recipient_username, created_at)
+		SELECT id, user_id, ?, ?, 0, ?, ?, ? FROM files WHERE id = ? AND user_id = ?`,
+		token, exp, recipientID, recipientUsername, now(), fileID, userID)
 	if err != nil {
-		return Share{}, err
+		return 0, err
 	}
-	id, err := res.LastInsertId()
+	if count, _ := res.RowsAffected(); count == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return res.LastInsertId()
+}
+
+// RevokeShare deletes userID's share shareID. A share owned by someone else
+// is reported as sql.ErrNoRows.
+func (s *Store) RevokeShare(ctx context.Context, userID, shareID int64) error {
+	sh, err := s.getShareByID(ctx, shareID)
 	if err != nil {
-		return Share{}, err
+		return err
 	}
-	return s.getShareByID(ctx, id)
+	if sh.OwnerUserID != userID {
+		return sql.ErrNoRows
+	}
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM shares WHERE id = ? AND owner_user_id = ?`, shareID, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	s.auditFile(ctx, sh.FileID, AuditShareRevoke, fmt.Sprintf("share %d", shareID))
+	return nil
 }
 
 func (s *Store) getShareByID(ctx context.Context, shareID int64) (Share, error) {
 	var sh Share
-	row := s.DB.QueryRowContext(ctx, `SELECT id, file_id, token, expires_at, uses, created_at FROM shares WHERE id = ?`, shareID)
-	if err := row.Scan(&sh.ID, &sh.FileID, &sh.Token, &sh.ExpiresAt, &sh.Uses, &sh.CreatedAt); err != nil {
+	row := s.DB.QueryRowContext(ctx, `SELECT `+shareColumns+` FROM shares WHERE id = ?`, shareID)
+	if err := scanShare(row, &sh); err != nil {
 		return sh, err
 	}
 	return sh, nil
 }
 
+// GetShare fetches userID's share shareID and its file. A share owned by
+// someone else is reported as sql.ErrNoRows.
+func (s *Store) GetShare(ctx context.Context, userID, shareID int64) (Share, File, error) {
+	sh, err := s.getShareByID(ctx, shareID)
+	if err != nil {
+		return sh, File{}, err
+	}
+	if sh.OwnerUserID != userID {
+		return Share{}, File{}, sql.ErrNoRows
+	}
+	f, err := s.GetFileByID(ctx, userID, sh.FileID)
+	if err != nil {
+		return sh, File{}, err
+	}
+	return sh, f, nil
+}
+
 // GetShareByToken fetches a share and its file.
 func (s *Store) GetShareByToken(ctx context.Context, token string) (Share, File, error) {
 	var sh Share
-	row := s.DB.QueryRowContext(ctx, `SELECT id, file_id, token, expires_at, uses, created_at FROM shares WHERE token = ?`, token)
-	if err := row.Scan(&sh.ID, &sh.FileID, &sh.Token, &sh.ExpiresAt, &sh.Uses, &sh.CreatedAt); err != nil {
+	row := s.DB.QueryRowContext(ctx, `SELECT `+shareColumns+` FROM shares WHERE token = ?`, token)
+	if err := scanShare(row, &sh); err != nil {
 		return sh, File{}, err
 	}
 	var f File
-	row = s.DB.QueryRowContext(ctx, `SELECT id, user_id, dir_id, name, file_id, file_unique_id, size, mime_type, created_at FROM files WHERE id = ?`, sh.FileID)
-	if err := row.Scan(&f.ID, &f.UserID, &f.DirID, &f.Name, &f.FileID, &f.FileUniqueID, &f.Size, &f.MimeType, &f.CreatedAt); err != nil {
+	row = s.DB.QueryRowContext(ctx, fileSelect+` WHERE f.id = ? AND f.user_id = ?`, sh.FileID, sh.OwnerUserID)
+	if err := scanFile(row, &f); err != nil {
 		return sh, File{}, err
 	}
 	return sh, f, nil
 }
 
+// ListSharesByUser lists the shares a user owns.
+func (s *Store) ListSharesByUser(ctx context.Context, userID int64) ([]Share, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+shareColumns+` FROM shares WHERE owner_user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var shares []Share
+	for rows.Next() {
+		var sh Share
+		if err := scanShare(rows, &sh); err != nil {
+			return nil, err
+		}
+		shares = append(shares, sh)
+	}
+	return shares, rows.Err()
+}
+
 // IncrementShareUses increments the share use count.
 func (s *Store) IncrementShareUses(ctx context.Context, shareID int64) error {
 	_, err := s.DB.ExecContext(ctx, `UPDATE shares SET uses = uses + 1 WHERE id = ?`, shareID)
@@ -888,47 +1462,118 @@ func (s *Store) GetCurrentDirID(ctx context.Context, userID int64) (int64, error
 
 // GetDirPath returns the full path of a directory.
 func (s *Store) GetDirPath(ctx context.Context, userID, dirID int64) (string, error) {
+	rows, err := s.DB.QueryContext(ctx, `WITH RECURSIVE up(id, parent_id, name, depth) AS (
+		SELECT id, parent_id, name, 0 FROM directories WHERE id = ? AND user_id = ?
+		UNION ALL
+		SELECT d.id, d.parent_id, d.name, up.depth + 1 FROM directories d JOIN up ON d.id = up.parent_id
+	) SELECT parent_id IS NOT NULL, name FROM up ORDER BY depth DESC`, dirID, userID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
 	var parts []string
-	current := dirID
-	for {
-		dir, err := s.GetDirByID(ctx, userID, current)
-		if err != nil {
+	found := false
+	for rows.Next() {
+		var named bool
+		var name string
+		if err := rows.Scan(&named, &name); err != nil {
 			return "", err
 		}
-		if dir.ParentID.Valid {
-			parts = append([]string{dir.Name}, parts...)
-			current = dir.ParentID.Int64
-			continue
+		found = true
+		if named {
+			parts = append(parts, name)
 		}
-		break
 	}
-	if len(parts) == 0 {
-		return "/", nil
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if !found {
+		return "", sql.ErrNoRows
 	}
 	return "/" + strings.Join(parts, "/"), nil
 }
 
+// walkPath is a CTE following folder names down from a starting folder:
+// walk holds the folder reached after each segment, by depth. Its
+// arguments come from walkPathArgs.
+const walkPath = `WITH RECURSIVE seg(idx, name) AS (
+		SELECT key, value FROM json_each(?)
+	), walk(id, depth) AS (
+		SELECT id, 0 FROM directories WHERE id = ? AND user_id = ?
+		UNION ALL
+		SELECT d.id, walk.depth + 1 FROM walk
+		JOIN seg ON seg.idx = walk.depth
+		JOIN directories d ON d.parent_id = walk.id AND d.user_id = ? AND d.name = seg.name
+	) `
+
+// walkPathArgs returns the arguments of walkPath and the depth of its last
+// folder, skipping empty segments.
+func walkPathArgs(userID, rootID int64, parts []string) ([]any, int, error) {
+	names := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part != "" {
+			names = append(names, part)
+		}
+	}
+	segs, err := json.Marshal(names)
+	if err != nil {
+		return nil, 0, err
+	}
+	return []any{string(segs), rootID, userID, userID}, len(names), nil
+}
+
 // FindDirByPath resolves a directory path for a user.
 func (s *Store) FindDirByPath(ctx context.Context, userID int64, parts []string) (Directory, error) {
 	rootID, err := s.GetRootDirID(ctx, userID)
 	if err != nil {
 		return Directory{}, err
 	}
-	current, err := s.GetDirByID(ctx, userID, rootID)
+	return s.FindDirByPathFrom(ctx, userID, rootID, parts)
+}
+
+// FindDirByPathFrom resolves a path of folder names below rootID.
+func (s *Store) FindDirByPathFrom(ctx context.Context, userID, rootID int64, parts []string) (Directory, error) {
+	args, depth, err := walkPathArgs(userID, rootID, parts)
 	if err != nil {
 		return Directory{}, err
 	}
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
-		child, err := s.GetDirByName(ctx, userID, current.ID, part)
-		if err != nil {
-			return Directory{}, err
-		}
-		current = child
+	var d Directory
+	row := s.DB.QueryRowContext(ctx, walkPath+`SELECT `+dirColumns+` FROM directories WHERE id = (SELECT id FROM walk WHERE depth = ?)`, append(args, depth)...)
+	if err := scanDir(row, &d); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// FindFileByPath resolves a file path for a user; the last element of parts
+// is the file name.
+func (s *Store) FindFileByPath(ctx context.Context, userID int64, parts []string) (File, error) {
+	rootID, err := s.GetRootDirID(ctx, userID)
+	if err != nil {
+		return File{}, err
+	}
+	return s.FindFileByPathFrom(ctx, userID, rootID, parts)
+}
+
+// FindFileByPathFrom resolves a file path below rootID.
+func (s *Store) FindFileByPathFrom(ctx context.Context, userID, rootID int64, parts []string) (File, error) {
+	for len(parts) > 0 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) == 0 {
+		return File{}, sql.ErrNoRows
 	}
-	return current, nil
+	name := parts[len(parts)-1]
+	args, depth, err := walkPathArgs(userID, rootID, parts[:len(parts)-1])
+	if err != nil {
+		return File{}, err
+	}
+	var f File
+	row := s.DB.QueryRowContext(ctx, walkPath+fileSelect+` WHERE f.user_id = ? AND f.dir_id = (SELECT id FROM walk WHERE depth = ?) AND f.name = ?`, append(args, userID, depth, name)...)
+	if err := scanFile(row, &f); err != nil {
+		return f, err
+	}
+	return f, nil
 }
 
 // isDescendant checks if targetID is a descendant of dirID.
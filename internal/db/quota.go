@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// ErrQuotaExceeded is returned for writes that would take a user past
+// their storage quota.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Quota is a user's storage quota and what counts against it: the size of
+// their files in every drive, not counting the trash.
+type Quota struct {
+	// Limit is the most bytes the user may store; 0 is no limit.
+	Limit int64
+	Used  int64
+}
+
+// Allows reports whether n more bytes fit in the quota.
+func (q Quota) Allows(n int64) bool {
+	return q.Limit <= 0 || n <= 0 || q.Used+n <= q.Limit
+}
+
+// GetQuota returns a user's quota, or sql.ErrNoRows for an unknown user.
+func (s *Store) GetQuota(ctx context.Context, userID int64) (Quota, error) {
+	var quota Quota
+	err := s.DB.QueryRowContext(ctx, `SELECT u.quota_bytes, (SELECT COALESCE(SUM(f.size), 0) FROM files f WHERE f.user_id = u.user_id)
+		FROM users u WHERE u.user_id = ?`, userID).Scan(&quota.Limit, &quota.Used)
+	return quota, err
+}
+
+// SetQuota sets the most bytes a user may store; 0 removes the limit. A
+// limit below what the user already stores refuses new writes without
+// removing anything.
+func (s *Store) SetQuota(ctx context.Context, userID, limit int64) error {
+	if limit < 0 {
+		return fmt.Errorf("negative quota: %w", os.ErrInvalid)
+	}
+	res, err := s.DB.ExecContext(ctx, `UPDATE users SET quota_bytes = ? WHERE user_id = ?`, limit, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CheckQuota fails with ErrQuotaExceeded when n more bytes, for the file
+// name, do not fit in the quota of userID, and records a quota.exceeded
+// event. Uploads check before they send anything to Telegram, and writes
+// again when they add the file.
+func (s *Store) CheckQuota(ctx context.Context, userID int64, name string, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	quota, err := s.GetQuota(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if quota.Allows(n) {
+		return nil
+	}
+	// Unlike the other events this one has no row to fire a trigger: the
+	// write it records never happens.
+	_, err = s.DB.ExecContext(ctx, `INSERT INTO outbox(event, user_id, subject_id, payload, created_at) VALUES (?, ?, ?,
+		json_object('name', ?, 'size', ?, 'limit_bytes', ?, 'used_bytes', ?), strftime('%Y-%m-%d %H:%M:%f', 'now'))`,
+		EventQuotaExceeded, userID, userID, name, n, quota.Limit, quota.Used)
+	if err != nil {
+		log.Printf("outbox %s for %d: %v", EventQuotaExceeded, userID, err)
+	}
+	return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, quota.Used, quota.Limit)
+}
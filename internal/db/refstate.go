@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// File reference states. An empty state means the Telegram file_id worked
+// the last time it was used.
+const (
+	// RefStateOK means the file has no known download problem.
+	RefStateOK = ""
+	// RefStateUnreachable means a download failed because Telegram rejected the file_id.
+	RefStateUnreachable = "unreachable"
+	// RefStateLost means the owner confirmed the content cannot be recovered.
+	RefStateLost = "lost"
+)
+
+// RefStateCount is the number of files in a reference state.
+type RefStateCount struct {
+	State string
+	Files int64
+}
+
+// SetFileRefState records the reference state of a single file.
+func (s *Store) SetFileRefState(ctx context.Context, userID, fileID int64, state string) error {
+	res, err := s.DB.ExecContext(ctx, `UPDATE files SET ref_state = ?, ref_checked_at = ? WHERE id = ? AND user_id = ?`, state, now(), fileID, userID)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MarkBlobUnreachable flags every file built from the given content as
+// unreachable. Files already confirmed lost keep their state.
+func (s *Store) MarkBlobUnreachable(ctx context.Context, fileUniqueID string) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE files SET ref_state = ?, ref_checked_at = ?
+		WHERE ref_state != ? AND (file_unique_id = ? OR id IN (SELECT file_id FROM file_parts WHERE file_unique_id = ?))`,
+		RefStateUnreachable, now(), RefStateLost, fileUniqueID, fileUniqueID)
+	return err
+}
+
+// RefreshBlob stores a working file_id for content whose old file_id stopped
+// resolving and clears the problem state of files that no longer have any
+// other unreachable part.
+func (s *Store) RefreshBlob(ctx context.Context, fileUniqueID, fileID string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+	res, err := tx.ExecContext(ctx, `UPDATE blobs SET file_id = ?, updated_at = ? WHERE file_unique_id = ?`, fileID, now(), fileUniqueID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE files SET ref_state = ?, ref_checked_at = ?
+		WHERE ref_state != '' AND (file_unique_id = ? OR id IN (SELECT file_id FROM file_parts WHERE file_unique_id = ?))`,
+		RefStateOK, now(), fileUniqueID, fileUniqueID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// ListFilesByRefState lists a user's files in the given reference state.
+// A userID of zero lists files of every user.
+func (s *Store) ListFilesByRefState(ctx context.Context, userID int64, state string) ([]File, error) {
+	rows, err := s.DB.QueryContext(ctx, fileSelect+` WHERE f.ref_state = ? AND (? = 0 OR f.user_id = ?) ORDER BY f.user_id, f.id`, state, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// CountFilesByRefState summarizes files with a reference problem.
+// A userID of zero counts files of every user.
+func (s *Store) CountFilesByRefState(ctx context.Context, userID int64) ([]RefStateCount, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT ref_state, COUNT(*) FROM files WHERE ref_state != '' AND (? = 0 OR user_id = ?) GROUP BY ref_state ORDER BY ref_state`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []RefStateCount
+	for rows.Next() {
+		var c RefStateCount
+		if err := rows.Scan(&c.State, &c.Files); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Restricted reports whether the share is limited to a single recipient.
+func (sh Share) Restricted() bool {
+	return sh.RecipientID != 0 || sh.RecipientUsername != ""
+}
+
+// AllowsUser reports whether the Telegram user may open the share. A share
+// addressed by username alone accepts whoever currently holds the username.
+func (sh Share) AllowsUser(userID int64, username string) bool {
+	if !sh.Restricted() {
+		return true
+	}
+	if sh.RecipientID != 0 {
+		return sh.RecipientID == userID
+	}
+	return username != "" && strings.EqualFold(sh.RecipientUsername, username)
+}
+
+// CreateRestrictedShare creates a share of userID's file that only the
+// given recipient can open. Pass recipientID when the user is known; a
+// username-only share is bound to the first matching user that opens it.
+func (s *Store) CreateRestrictedShare(ctx context.Context, userID, fileID int64, token string, expiresAt *time.Time, recipientID int64, recipientUsername string) (Share, error) {
+	username := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(recipientUsername), "@"))
+	id, err := s.insertShare(ctx, userID, fileID, token, expiresAt, recipientID, username)
+	if err != nil {
+		return Share{}, err
+	}
+	recipient := "@" + username
+	if recipientID != 0 {
+		recipient = fmt.Sprintf("user %d", recipientID)
+	}
+	s.auditFile(ctx, fileID, AuditShareCreate, shareAuditDetail(expiresAt, recipient))
+	return s.getShareByID(ctx, id)
+}
+
+// BindShareRecipient pins a username-addressed share to the user ID that
+// opened it, so a later owner of the username cannot use it.
+func (s *Store) BindShareRecipient(ctx context.Context, shareID, userID int64) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE shares SET recipient_id = ? WHERE id = ? AND recipient_id = 0 AND recipient_username != ''`, userID, shareID)
+	return err
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+)
+
+// ErrRevisionMismatch reports a conditional change whose target was changed
+// by someone else since the caller read it.
+var ErrRevisionMismatch = errors.New("changed since it was read")
+
+// revisionTriggers bump files.revision and directories.revision whenever a
+// row changes in a way a client can see, whichever code path writes it. An
+// update that sets the revision itself is left alone.
+var revisionTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS trg_files_revision AFTER UPDATE OF user_id, dir_id, name, file_unique_id, size, mime_type ON files
+	WHEN NEW.revision = OLD.revision BEGIN
+		UPDATE files SET revision = OLD.revision + 1 WHERE id = NEW.id;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS trg_dirs_revision AFTER UPDATE OF user_id, parent_id, name ON directories
+	WHEN NEW.revision = OLD.revision BEGIN
+		UPDATE directories SET revision = OLD.revision + 1 WHERE id = NEW.id;
+	END;`,
+}
+
+// modifiedTriggers stamp files.modified_at when a file's content is
+// replaced. New files leave it NULL, which reads as their creation time.
+var modifiedTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS trg_files_modified AFTER UPDATE OF file_unique_id, size ON files
+	WHEN NEW.file_unique_id != OLD.file_unique_id OR NEW.size != OLD.size BEGIN
+		UPDATE files SET modified_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id;
+	END;`,
+}
+
+// ETag is a strong entity tag for the file's current revision.
+func (f File) ETag() string {
+	return entityTag(f.PublicID, f.Revision)
+}
+
+// ETag is a strong entity tag for the folder's current revision. A folder's
+// revision follows its own name and place, not its contents.
+func (d Directory) ETag() string {
+	return entityTag(d.PublicID, d.Revision)
+}
+
+func entityTag(publicID string, revision int64) string {
+	return `"` + publicID + "-" + strconv.FormatInt(revision, 10) + `"`
+}
+
+type revisionKey struct{}
+
+// IfRevision makes the renames, moves, replacements and deletes done with
+// ctx conditional: they fail with ErrRevisionMismatch unless their target is
+// still at revision.
+func IfRevision(ctx context.Context, revision int64) context.Context {
+	return context.WithValue(ctx, revisionKey{}, revision)
+}
+
+func expectedRevision(ctx context.Context) (int64, bool) {
+	revision, ok := ctx.Value(revisionKey{}).(int64)
+	return revision, ok
+}
+
+// revisionClause extends an UPDATE or DELETE of one row with the revision
+// ctx expects, if any.
+func revisionClause(ctx context.Context, args []any) (string, []any) {
+	if revision, ok := expectedRevision(ctx); ok {
+		return ` AND revision = ?`, append(args, revision)
+	}
+	return "", args
+}
+
+// checkRevision compares a loaded revision with the one ctx expects.
+func checkRevision(ctx context.Context, revision int64) error {
+	if expected, ok := expectedRevision(ctx); ok && expected != revision {
+		return ErrRevisionMismatch
+	}
+	return nil
+}
+
+// noRowsError is what a conditional change that touched nothing returns:
+// the row is there, so its revision moved on.
+func noRowsError(ctx context.Context) error {
+	if _, ok := expectedRevision(ctx); ok {
+		return ErrRevisionMismatch
+	}
+	return sql.ErrNoRows
+}
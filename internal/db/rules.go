@@ -0,0 +1,174 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"pigpak/internal/rules"
+)
+
+// Rule files uploads matching Expr into TargetDirID.
+type Rule struct {
+	ID          int64
+	UserID      int64
+	Position    int64
+	Expr        string
+	TargetDirID int64
+	ApplyWebDAV bool
+	CreatedAt   time.Time
+}
+
+const ruleColumns = `id, user_id, position, expr, target_dir_id, apply_webdav, created_at`
+
+func scanRule(row rowScanner, r *Rule) error {
+	return row.Scan(&r.ID, &r.UserID, &r.Position, &r.Expr, &r.TargetDirID, &r.ApplyWebDAV, &r.CreatedAt)
+}
+
+// AddRule appends a rule after the user's existing rules.
+func (s *Store) AddRule(ctx context.Context, userID int64, expr string, targetDirID int64) (Rule, error) {
+	if _, err := s.GetDirByID(ctx, userID, targetDirID); err != nil {
+		return Rule{}, err
+	}
+	ts := now()
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO rules(user_id, position, expr, target_dir_id, apply_webdav, created_at)
+		VALUES (?, (SELECT COALESCE(MAX(position), 0) + 1 FROM rules WHERE user_id = ?), ?, ?, 0, ?)`,
+		userID, userID, expr, targetDirID, ts)
+	if err != nil {
+		return Rule{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Rule{}, err
+	}
+	return s.GetRule(ctx, userID, id)
+}
+
+// GetRule fetches a single rule.
+func (s *Store) GetRule(ctx context.Context, userID, ruleID int64) (Rule, error) {
+	var r Rule
+	row := s.DB.QueryRowContext(ctx, `SELECT `+ruleColumns+` FROM rules WHERE id = ? AND user_id = ?`, ruleID, userID)
+	err := scanRule(row, &r)
+	return r, err
+}
+
+// ListRules lists a user's rules in evaluation order.
+func (s *Store) ListRules(ctx context.Context, userID int64) ([]Rule, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+ruleColumns+` FROM rules WHERE user_id = ? ORDER BY position, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Rule
+	for rows.Next() {
+		var r Rule
+		if err := scanRule(rows, &r); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// DeleteRule removes a rule.
+func (s *Store) DeleteRule(ctx context.Context, userID, ruleID int64) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM rules WHERE id = ? AND user_id = ?`, ruleID, userID)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetRuleWebDAV toggles whether a rule also applies to WebDAV uploads.
+func (s *Store) SetRuleWebDAV(ctx context.Context, userID, ruleID int64, enabled bool) error {
+	res, err := s.DB.ExecContext(ctx, `UPDATE rules SET apply_webdav = ? WHERE id = ? AND user_id = ?`, enabled, ruleID, userID)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MoveRuleUp swaps a rule with the one evaluated before it.
+func (s *Store) MoveRuleUp(ctx context.Context, userID, ruleID int64) error {
+	list, err := s.ListRules(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for i, r := range list {
+		if r.ID != ruleID {
+			continue
+		}
+		if i == 0 {
+			return nil
+		}
+		prev := list[i-1]
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE rules SET position = ? WHERE id = ?`, prev.Position, r.ID); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE rules SET position = ? WHERE id = ?`, r.Position, prev.ID); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+	return sql.ErrNoRows
+}
+
+// MatchRule returns the first rule matching item. With webdav set only
+// rules that opted into WebDAV uploads are considered. Rules whose stored
+// expression no longer compiles are skipped.
+func (s *Store) MatchRule(ctx context.Context, userID int64, item rules.Item, webdav bool) (Rule, bool, error) {
+	list, err := s.ListRules(ctx, userID)
+	if err != nil {
+		return Rule{}, false, err
+	}
+	for _, r := range list {
+		if webdav && !r.ApplyWebDAV {
+			continue
+		}
+		m, err := rules.Compile(r.Expr)
+		if err != nil {
+			continue
+		}
+		if m.Match(item) {
+			return r, true, nil
+		}
+	}
+	return Rule{}, false, nil
+}
+
+// EnsureDirPath resolves a slash-separated path below rootID, creating
+// missing folders along the way.
+func (s *Store) EnsureDirPath(ctx context.Context, userID, rootID int64, parts []string) (Directory, error) {
+	current, err := s.GetDirByID(ctx, userID, rootID)
+	if err != nil {
+		return Directory{}, err
+	}
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		child, err := s.GetDirByName(ctx, userID, current.ID, part)
+		if err == sql.ErrNoRows {
+			child, err = s.CreateDir(ctx, userID, current.ID, part)
+		}
+		if err != nil {
+			return Directory{}, err
+		}
+		current = child
+	}
+	return current, nil
+}
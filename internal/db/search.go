@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"pigpak/internal/search"
+)
+
+// SearchFiles returns up to limit of userID's files matching q, by name.
+// dirID limits the search to that folder and everything below it; 0
+// searches every drive. q.In is left for the caller to resolve into dirID.
+func (s *Store) SearchFiles(ctx context.Context, userID, dirID int64, q search.Query, limit int) ([]File, error) {
+	var where []string
+	args := []any{}
+	query := fileSelect
+	if dirID != 0 {
+		query = `WITH RECURSIVE subtree(id) AS (
+			SELECT id FROM directories WHERE id = ? AND user_id = ?
+			UNION ALL
+			SELECT d.id FROM directories d JOIN subtree s ON d.parent_id = s.id
+		) ` + fileSelect
+		args = append(args, dirID, userID)
+		where = append(where, `f.dir_id IN (SELECT id FROM subtree)`)
+	}
+	if q.HasMetadata() {
+		query += ` JOIN file_metadata m ON m.file_id = f.id`
+	}
+	where = append(where, `f.user_id = ?`)
+	args = append(args, userID)
+	for _, term := range q.Terms {
+		where = append(where, `f.name LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(term)+"%")
+	}
+	if len(q.Types) > 0 {
+		alts := make([]string, len(q.Types))
+		for i, t := range q.Types {
+			alts[i] = `f.mime_type LIKE ? ESCAPE '\'`
+			args = append(args, t)
+		}
+		where = append(where, "("+strings.Join(alts, " OR ")+")")
+	}
+	if q.MinSize >= 0 {
+		where = append(where, `f.size >= ?`)
+		args = append(args, q.MinSize)
+	}
+	if q.MaxSize >= 0 {
+		where = append(where, `f.size <= ?`)
+		args = append(args, q.MaxSize)
+	}
+	if !q.After.IsZero() {
+		where = append(where, `f.created_at >= ?`)
+		args = append(args, q.After.UTC())
+	}
+	if !q.Before.IsZero() {
+		where = append(where, `f.created_at < ?`)
+		args = append(args, q.Before.UTC())
+	}
+	for _, r := range []struct {
+		column string
+		scale  int64
+		bounds search.Range
+	}{
+		{"m.width", 1, q.Width},
+		{"m.height", 1, q.Height},
+		{"m.pages", 1, q.Pages},
+		{"m.duration_ms", 1000, q.Duration},
+	} {
+		if r.bounds.Open() {
+			continue
+		}
+		// Zero means unknown, which no bound matches.
+		where = append(where, r.column+` > 0`)
+		if r.bounds.Min >= 0 {
+			where = append(where, r.column+` >= ?`)
+			args = append(args, r.bounds.Min*r.scale)
+		}
+		if r.bounds.Max >= 0 {
+			where = append(where, r.column+` < ?`)
+			args = append(args, (r.bounds.Max+1)*r.scale)
+		}
+	}
+	if !q.TakenAfter.IsZero() {
+		where = append(where, `m.taken_at >= ?`)
+		args = append(args, q.TakenAfter.UTC())
+	}
+	if !q.TakenBefore.IsZero() {
+		where = append(where, `m.taken_at < ?`)
+		args = append(args, q.TakenBefore.UTC())
+	}
+	if q.Artist != "" {
+		where = append(where, `m.artist LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(q.Artist)+"%")
+	}
+	if q.Album != "" {
+		where = append(where, `m.album LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(q.Album)+"%")
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	query += ` WHERE ` + strings.Join(where, " AND ") + ` ORDER BY f.name, f.id LIMIT ?`
+	args = append(args, limit)
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in s for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
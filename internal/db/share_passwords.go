@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+)
+
+// HasPassword reports whether the share's HTTP download asks for a
+// password.
+func (sh Share) HasPassword() bool {
+	return sh.passwordHash != ""
+}
+
+// CheckPassword reports whether password opens the share. A share without
+// a password accepts any.
+func (sh Share) CheckPassword(password string) bool {
+	return checkSharePassword(sh.passwordSalt, sh.passwordHash, password)
+}
+
+// checkSharePassword compares password with a stored salt and hash; an
+// empty hash accepts any password.
+func checkSharePassword(saltHex, hash, password string) bool {
+	if hash == "" {
+		return true
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return false
+	}
+	expect := hashWebDAVPassword(password, salt)
+	return subtle.ConstantTimeCompare([]byte(expect), []byte(hash)) == 1
+}
+
+// hashSharePassword returns the salt and hash stored for password, both
+// empty for no password.
+func hashSharePassword(password string) (string, string, error) {
+	if password == "" {
+		return "", "", nil
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(salt), hashWebDAVPassword(password, salt), nil
+}
+
+// SetSharePassword sets the password of userID's share shareID, or removes
+// it when password is empty. A share owned by someone else is reported as
+// sql.ErrNoRows.
+func (s *Store) SetSharePassword(ctx context.Context, userID, shareID int64, password string) error {
+	saltHex, hash, err := hashSharePassword(password)
+	if err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `UPDATE shares SET password_salt = ?, password_hash = ? WHERE id = ? AND owner_user_id = ?`, saltHex, hash, shareID, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	detail := fmt.Sprintf("share %d password set", shareID)
+	if password == "" {
+		detail = fmt.Sprintf("share %d password removed", shareID)
+	}
+	sh, err := s.getShareByID(ctx, shareID)
+	if err != nil {
+		return err
+	}
+	s.auditFile(ctx, sh.FileID, AuditSharePassword, detail)
+	return nil
+}
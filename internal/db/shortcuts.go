@@ -0,0 +1,254 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrShortcutBroken reports a shortcut whose target can no longer be opened
+// by its owner, such as an expired share.
+var ErrShortcutBroken = errors.New("shortcut target is no longer available")
+
+// Shortcut is an entry in a folder that points at a file or folder
+// elsewhere. Exactly one target is set: one of the owner's own folders or
+// files, another user's folder through its published page, or another
+// user's file through a share link. Deleting the target, unpublishing the
+// page or revoking the share deletes the shortcut with it.
+type Shortcut struct {
+	ID           int64
+	UserID       int64
+	DirID        int64
+	Name         string
+	TargetDirID  int64
+	TargetFileID int64
+	PageID       int64
+	ShareID      int64
+	CreatedAt    time.Time
+}
+
+// ShortcutTarget says what a new shortcut points at. Set exactly one field.
+type ShortcutTarget struct {
+	DirID      int64
+	FileID     int64
+	PageToken  string
+	ShareToken string
+}
+
+// ShortcutResolution is what a shortcut points at right now. Dir or File is
+// set; Page or Share is set when the target belongs to another user.
+type ShortcutResolution struct {
+	Dir   *Directory
+	File  *File
+	Page  *Page
+	Share *Share
+}
+
+const shortcutColumns = `id, user_id, dir_id, name, COALESCE(target_dir_id, 0), COALESCE(target_file_id, 0), COALESCE(page_id, 0), COALESCE(share_id, 0), created_at`
+
+func scanShortcut(row rowScanner, sc *Shortcut) error {
+	return row.Scan(&sc.ID, &sc.UserID, &sc.DirID, &sc.Name, &sc.TargetDirID, &sc.TargetFileID, &sc.PageID, &sc.ShareID, &sc.CreatedAt)
+}
+
+// nullID stores a zero ID as NULL.
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// CreateShortcut adds a shortcut called name to dirID. A share target must
+// be open to userID; restricted shares should be checked, and bound, by the
+// caller first.
+func (s *Store) CreateShortcut(ctx context.Context, userID, dirID int64, name string, target ShortcutTarget) (Shortcut, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.Contains(name, "/") {
+		return Shortcut{}, fmt.Errorf("invalid shortcut name %q: %w", name, os.ErrInvalid)
+	}
+	set := 0
+	for _, ok := range []bool{target.DirID != 0, target.FileID != 0, target.PageToken != "", target.ShareToken != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return Shortcut{}, fmt.Errorf("shortcut needs exactly one target: %w", os.ErrInvalid)
+	}
+	if _, err := s.GetDirByID(ctx, userID, dirID); err != nil {
+		return Shortcut{}, err
+	}
+	sc := Shortcut{UserID: userID, DirID: dirID, Name: name}
+	var detail string
+	switch {
+	case target.DirID != 0:
+		dir, err := s.GetDirByID(ctx, userID, target.DirID)
+		if err != nil {
+			return Shortcut{}, err
+		}
+		sc.TargetDirID = dir.ID
+		detail = fmt.Sprintf("to folder %d", dir.ID)
+	case target.FileID != 0:
+		file, err := s.GetFileByID(ctx, userID, target.FileID)
+		if err != nil {
+			return Shortcut{}, err
+		}
+		sc.TargetFileID = file.ID
+		detail = fmt.Sprintf("to file %d", file.ID)
+	case target.PageToken != "":
+		page, err := s.GetPageByToken(ctx, target.PageToken)
+		if err != nil {
+			return Shortcut{}, err
+		}
+		if page.UserID == userID {
+			sc.TargetDirID = page.DirID
+			detail = fmt.Sprintf("to folder %d", page.DirID)
+		} else {
+			sc.PageID = page.ID
+			detail = fmt.Sprintf("to page %d of user %d", page.ID, page.UserID)
+		}
+	default:
+		share, file, err := s.GetShareByToken(ctx, target.ShareToken)
+		if err != nil {
+			return Shortcut{}, err
+		}
+		if err := ValidateShare(share); err != nil || !share.AllowsUser(userID, "") {
+			return Shortcut{}, ErrShortcutBroken
+		}
+		if file.UserID == userID {
+			sc.TargetFileID = file.ID
+			detail = fmt.Sprintf("to file %d", file.ID)
+		} else {
+			sc.ShareID = share.ID
+			detail = fmt.Sprintf("to share %d of user %d", share.ID, file.UserID)
+		}
+	}
+	if sc.TargetDirID == dirID {
+		return Shortcut{}, fmt.Errorf("a shortcut cannot point at its own folder: %w", os.ErrInvalid)
+	}
+	if err := s.ensureNameAvailable(ctx, userID, dirID, name, 0, 0); err != nil {
+		return Shortcut{}, err
+	}
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO shortcuts(user_id, dir_id, name, target_dir_id, target_file_id, page_id, share_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, dirID, name, nullID(sc.TargetDirID), nullID(sc.TargetFileID), nullID(sc.PageID), nullID(sc.ShareID), now())
+	if err != nil {
+		return Shortcut{}, nameConflict(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Shortcut{}, err
+	}
+	s.audit(ctx, userID, AuditShortcutCreate, id, name, detail)
+	return s.GetShortcut(ctx, userID, id)
+}
+
+// GetShortcut fetches one of a user's shortcuts.
+func (s *Store) GetShortcut(ctx context.Context, userID, shortcutID int64) (Shortcut, error) {
+	var sc Shortcut
+	row := s.DB.QueryRowContext(ctx, `SELECT `+shortcutColumns+` FROM shortcuts WHERE id = ? AND user_id = ?`, shortcutID, userID)
+	err := scanShortcut(row, &sc)
+	return sc, err
+}
+
+// GetShortcutByName fetches a shortcut by name within a folder.
+func (s *Store) GetShortcutByName(ctx context.Context, userID, dirID int64, name string) (Shortcut, error) {
+	var sc Shortcut
+	row := s.DB.QueryRowContext(ctx, `SELECT `+shortcutColumns+` FROM shortcuts WHERE user_id = ? AND dir_id = ? AND name = ?`, userID, dirID, name)
+	err := scanShortcut(row, &sc)
+	return sc, err
+}
+
+// ListShortcuts lists the shortcuts in a folder.
+func (s *Store) ListShortcuts(ctx context.Context, userID, dirID int64) ([]Shortcut, error) {
+	return s.ListShortcutsPage(ctx, userID, dirID, ListPage{})
+}
+
+// ListShortcutsPage lists one page of the shortcuts in a folder, by name.
+func (s *Store) ListShortcutsPage(ctx context.Context, userID, dirID int64, page ListPage) ([]Shortcut, error) {
+	after, afterArgs := page.where("name")
+	limit, limitArgs := page.limit()
+	args := append(append([]any{userID, dirID}, afterArgs...), limitArgs...)
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+shortcutColumns+` FROM shortcuts WHERE user_id = ? AND dir_id = ?`+after+` ORDER BY name`+limit, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Shortcut
+	for rows.Next() {
+		var sc Shortcut
+		if err := scanShortcut(rows, &sc); err != nil {
+			return nil, err
+		}
+		out = append(out, sc)
+	}
+	return out, rows.Err()
+}
+
+// CountShortcuts counts the shortcuts in a folder.
+func (s *Store) CountShortcuts(ctx context.Context, userID, dirID int64) (int, error) {
+	var n int
+	err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM shortcuts WHERE user_id = ? AND dir_id = ?`, userID, dirID).Scan(&n)
+	return n, err
+}
+
+// DeleteShortcut removes a shortcut; its target is untouched.
+func (s *Store) DeleteShortcut(ctx context.Context, userID, shortcutID int64) error {
+	sc, err := s.GetShortcut(ctx, userID, shortcutID)
+	if err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM shortcuts WHERE id = ? AND user_id = ?`, shortcutID, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	s.audit(ctx, userID, AuditShortcutDelete, shortcutID, sc.Name, "")
+	return nil
+}
+
+// ResolveShortcut loads what sc points at. A share that expired, or that
+// no longer admits the shortcut's owner, gives ErrShortcutBroken.
+func (s *Store) ResolveShortcut(ctx context.Context, sc Shortcut) (ShortcutResolution, error) {
+	switch {
+	case sc.TargetDirID != 0:
+		dir, err := s.GetDirByID(ctx, sc.UserID, sc.TargetDirID)
+		if err != nil {
+			return ShortcutResolution{}, err
+		}
+		return ShortcutResolution{Dir: &dir}, nil
+	case sc.TargetFileID != 0:
+		file, err := s.GetFileByID(ctx, sc.UserID, sc.TargetFileID)
+		if err != nil {
+			return ShortcutResolution{}, err
+		}
+		return ShortcutResolution{File: &file}, nil
+	case sc.PageID != 0:
+		var page Page
+		row := s.DB.QueryRowContext(ctx, `SELECT `+pageColumns+` FROM pages WHERE id = ?`, sc.PageID)
+		if err := scanPage(row, &page); err != nil {
+			return ShortcutResolution{}, err
+		}
+		dir, err := s.GetDirByID(ctx, page.UserID, page.DirID)
+		if err != nil {
+			return ShortcutResolution{}, err
+		}
+		return ShortcutResolution{Dir: &dir, Page: &page}, nil
+	default:
+		var share Share
+		row := s.DB.QueryRowContext(ctx, `SELECT `+shareColumns+` FROM shares WHERE id = ?`, sc.ShareID)
+		if err := scanShare(row, &share); err != nil {
+			return ShortcutResolution{}, err
+		}
+		if err := ValidateShare(share); err != nil || !share.AllowsUser(sc.UserID, "") {
+			return ShortcutResolution{}, ErrShortcutBroken
+		}
+		file, err := s.GetFileByID(ctx, share.OwnerUserID, share.FileID)
+		if err != nil {
+			return ShortcutResolution{}, err
+		}
+		return ShortcutResolution{File: &file, Share: &share}, nil
+	}
+}
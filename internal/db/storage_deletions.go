@@ -0,0 +1,46 @@
+package db
+
+import "context"
+
+// StorageDeletion is a storage chat message whose content nothing references
+// any more, waiting to be deleted from Telegram.
+type StorageDeletion struct {
+	ID        int64
+	ChatID    int64
+	MessageID int
+	Attempts  int
+	LastError string
+}
+
+// ListStorageDeletions returns the oldest queued storage message deletions.
+func (s *Store) ListStorageDeletions(ctx context.Context, limit int) ([]StorageDeletion, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, chat_id, message_id, attempts, last_error FROM storage_deletions ORDER BY id LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []StorageDeletion
+	for rows.Next() {
+		var d StorageDeletion
+		if err := rows.Scan(&d.ID, &d.ChatID, &d.MessageID, &d.Attempts, &d.LastError); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// MarkStorageDeletionAttempt records a failed deletion attempt.
+func (s *Store) MarkStorageDeletionAttempt(ctx context.Context, id int64, lastError string) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE storage_deletions SET attempts = attempts + 1, last_error = ? WHERE id = ?`, lastError, id)
+	return err
+}
+
+// DeleteStorageDeletion removes a finished or abandoned deletion.
+func (s *Store) DeleteStorageDeletion(ctx context.Context, id int64) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM storage_deletions WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,529 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Offline repairs for the operator CLI. Each one runs in a single
+// transaction that is committed only when apply is set, so a dry run reports
+// exactly what the real run would change.
+
+// RecoveredUploadsDir is the root folder abandoned WebDAV uploads are filed
+// into by RecoverAbandonedUploads.
+const RecoveredUploadsDir = "Recovered uploads"
+
+// UserSummary describes a user for the operator CLI.
+type UserSummary struct {
+	UserID    int64
+	Username  string
+	Drives    int64
+	Dirs      int64
+	Files     int64
+	Bytes     int64
+	CreatedAt time.Time
+}
+
+// SubtreeMove reports a folder handed over to another user.
+type SubtreeMove struct {
+	DirID      int64
+	FromUserID int64
+	ToUserID   int64
+	ParentID   int64
+	Dirs       int64
+	Files      int64
+	Bytes      int64
+	// Dropped counts rules, favorites, pages, file requests, shortcuts and
+	// temporary WebDAV logins of the old owner that pointed into the subtree.
+	Dropped int64
+}
+
+// ChatRewrite reports rows moved from one storage chat id to another.
+type ChatRewrite struct {
+	Drives      int64
+	UserStorage int64
+	Blobs       int64
+	Copies      int64
+	UploadParts int64
+	// FileMessages counts file and part rows naming a message in the chat.
+	FileMessages int64
+	Deletions    int64
+}
+
+// RecoveredUpload is an abandoned WebDAV upload turned into a file.
+type RecoveredUpload struct {
+	UploadID int64
+	UserID   int64
+	Path     string
+	Parts    int
+	Bytes    int64
+	// Skipped is set when the upload had no usable leading parts.
+	Skipped string
+}
+
+// SizeFix is a file whose recorded size disagreed with its parts.
+type SizeFix struct {
+	FileID   int64
+	UserID   int64
+	Name     string
+	Recorded int64
+	Parts    int64
+}
+
+func (s *Store) runSurgery(ctx context.Context, apply bool, fn func(tx *sql.Tx) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if !apply {
+		return nil
+	}
+	return tx.Commit()
+}
+
+const userSummarySelect = `SELECT u.user_id, COALESCE(p.username, ''),
+		(SELECT COUNT(*) FROM drives d WHERE d.user_id = u.user_id),
+		(SELECT COUNT(*) FROM directories d WHERE d.user_id = u.user_id AND d.parent_id IS NOT NULL),
+		(SELECT COUNT(*) FROM files f WHERE f.user_id = u.user_id),
+		(SELECT COALESCE(SUM(f.size), 0) FROM files f WHERE f.user_id = u.user_id),
+		u.created_at
+		FROM users u LEFT JOIN user_profiles p ON p.user_id = u.user_id`
+
+func scanUserSummary(row rowScanner, u *UserSummary) error {
+	return row.Scan(&u.UserID, &u.Username, &u.Drives, &u.Dirs, &u.Files, &u.Bytes, &u.CreatedAt)
+}
+
+// ListUserSummaries lists every user with their storage totals.
+func (s *Store) ListUserSummaries(ctx context.Context) ([]UserSummary, error) {
+	rows, err := s.DB.QueryContext(ctx, userSummarySelect+` ORDER BY u.user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []UserSummary
+	for rows.Next() {
+		var u UserSummary
+		if err := scanUserSummary(rows, &u); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// GetUserSummary returns one user's storage totals, or sql.ErrNoRows.
+func (s *Store) GetUserSummary(ctx context.Context, userID int64) (UserSummary, error) {
+	var u UserSummary
+	err := scanUserSummary(s.DB.QueryRowContext(ctx, userSummarySelect+` WHERE u.user_id = ?`, userID), &u)
+	return u, err
+}
+
+// MoveSubtreeToUser hands the folder dirID, with everything below it, over
+// to toUserID under parentID, or under their primary root when parentID is
+// zero. Shares move with their files; the old owner's rules, favorites,
+// shortcuts and other per-folder settings inside the subtree are dropped, as
+// are the old owner's shortcuts elsewhere that point into it.
+func (s *Store) MoveSubtreeToUser(ctx context.Context, dirID, toUserID, parentID int64, apply bool) (SubtreeMove, error) {
+	move := SubtreeMove{DirID: dirID, ToUserID: toUserID, ParentID: parentID}
+	var name string
+	err := s.runSurgery(ctx, apply, func(tx *sql.Tx) error {
+		var oldParent sql.NullInt64
+		if err := tx.QueryRowContext(ctx, `SELECT user_id, parent_id, name FROM directories WHERE id = ?`, dirID).Scan(&move.FromUserID, &oldParent, &name); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("folder %d not found", dirID)
+			}
+			return err
+		}
+		if !oldParent.Valid {
+			return errors.New("cannot move a drive root; move its folders instead")
+		}
+		if move.FromUserID == toUserID {
+			return errors.New("folder already belongs to that user")
+		}
+		var one int
+		if err := tx.QueryRowContext(ctx, `SELECT 1 FROM users WHERE user_id = ?`, toUserID).Scan(&one); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("user %d not found", toUserID)
+			}
+			return err
+		}
+		if move.ParentID == 0 {
+			if err := tx.QueryRowContext(ctx, `SELECT id FROM directories WHERE user_id = ? AND parent_id IS NULL ORDER BY id LIMIT 1`, toUserID).Scan(&move.ParentID); err != nil {
+				return fmt.Errorf("user %d has no root folder: %w", toUserID, err)
+			}
+		} else {
+			var owner int64
+			if err := tx.QueryRowContext(ctx, `SELECT user_id FROM directories WHERE id = ?`, move.ParentID).Scan(&owner); err != nil {
+				return fmt.Errorf("target folder %d not found", move.ParentID)
+			}
+			if owner != toUserID {
+				return fmt.Errorf("target folder %d belongs to user %d", move.ParentID, owner)
+			}
+		}
+		taken, err := nameTakenTx(ctx, tx, toUserID, move.ParentID, name)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return fmt.Errorf("target folder already holds %q", name)
+		}
+
+		subtree := `WITH RECURSIVE subtree(id) AS (
+			SELECT id FROM directories WHERE id = ?
+			UNION ALL
+			SELECT d.id FROM directories d JOIN subtree s ON d.parent_id = s.id
+		) `
+		if err := tx.QueryRowContext(ctx, subtree+`SELECT COUNT(*) FROM subtree`, dirID).Scan(&move.Dirs); err != nil {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx, subtree+`SELECT COUNT(*), COALESCE(SUM(size), 0) FROM files WHERE dir_id IN (SELECT id FROM subtree)`, dirID).Scan(&move.Files, &move.Bytes); err != nil {
+			return err
+		}
+		for _, table := range []string{"rules WHERE target_dir_id", "favorites WHERE dir_id", "pages WHERE dir_id", "file_requests WHERE dir_id", "webdav_temp_credentials WHERE scope_dir_id", "shortcuts WHERE dir_id", "shortcuts WHERE target_dir_id"} {
+			res, err := tx.ExecContext(ctx, subtree+`DELETE FROM `+table+` IN (SELECT id FROM subtree)`, dirID)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			move.Dropped += n
+		}
+		res, err := tx.ExecContext(ctx, subtree+`DELETE FROM shortcuts WHERE target_file_id IN (SELECT f.id FROM files f WHERE f.dir_id IN (SELECT id FROM subtree))`, dirID)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		move.Dropped += n
+		stmts := []string{
+			`UPDATE user_state SET current_dir_id = NULL WHERE current_dir_id IN (SELECT id FROM subtree)`,
+			`UPDATE files SET user_id = ? WHERE dir_id IN (SELECT id FROM subtree)`,
+			`UPDATE shares SET owner_user_id = ? WHERE file_id IN (SELECT f.id FROM files f WHERE f.dir_id IN (SELECT id FROM subtree))`,
+			`UPDATE webdav_uploads SET user_id = ? WHERE dir_id IN (SELECT id FROM subtree)`,
+			`UPDATE directories SET user_id = ? WHERE id IN (SELECT id FROM subtree)`,
+		}
+		if _, err := tx.ExecContext(ctx, subtree+stmts[0], dirID); err != nil {
+			return err
+		}
+		for _, stmt := range stmts[1:] {
+			if _, err := tx.ExecContext(ctx, subtree+stmt, dirID, toUserID); err != nil {
+				return err
+			}
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE directories SET parent_id = ?, updated_at = ? WHERE id = ?`, move.ParentID, now(), dirID)
+		return nameConflict(err)
+	})
+	if err == nil && apply {
+		s.audit(ctx, move.FromUserID, AuditDirMove, dirID, name, fmt.Sprintf("handed over to user %d", toUserID))
+		s.audit(ctx, toUserID, AuditDirMove, dirID, name, fmt.Sprintf("received from user %d", move.FromUserID))
+	}
+	return move, err
+}
+
+// RewriteStorageChat points everything recorded against storage chat from
+// at chat to, for example after a group was upgraded to a supergroup.
+func (s *Store) RewriteStorageChat(ctx context.Context, from, to int64, apply bool) (ChatRewrite, error) {
+	var out ChatRewrite
+	err := s.runSurgery(ctx, apply, func(tx *sql.Tx) error {
+		return rewriteStorageChatTx(ctx, tx, from, to, &out)
+	})
+	return out, err
+}
+
+// MigrateStorageChat applies RewriteStorageChat for a group Telegram
+// reported as upgraded to supergroup to, and remembers the migration so
+// ChatMigrations can redirect the old id after a restart.
+func (s *Store) MigrateStorageChat(ctx context.Context, from, to int64) (ChatRewrite, error) {
+	var out ChatRewrite
+	err := s.runSurgery(ctx, true, func(tx *sql.Tx) error {
+		if err := rewriteStorageChatTx(ctx, tx, from, to, &out); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO chat_migrations(from_chat_id, to_chat_id, migrated_at) VALUES (?, ?, ?)
+			ON CONFLICT(from_chat_id) DO UPDATE SET to_chat_id = excluded.to_chat_id, migrated_at = excluded.migrated_at`, from, to, now())
+		return err
+	})
+	return out, err
+}
+
+// ChatMigrations returns every recorded chat migration, old id to new id.
+func (s *Store) ChatMigrations(ctx context.Context) (map[int64]int64, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT from_chat_id, to_chat_id FROM chat_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[int64]int64)
+	for rows.Next() {
+		var from, to int64
+		if err := rows.Scan(&from, &to); err != nil {
+			return nil, err
+		}
+		out[from] = to
+	}
+	return out, rows.Err()
+}
+
+func rewriteStorageChatTx(ctx context.Context, tx *sql.Tx, from, to int64, out *ChatRewrite) error {
+	targets := []struct {
+		stmt  string
+		count *int64
+	}{
+		{`UPDATE drives SET storage_chat_id = ? WHERE storage_chat_id = ?`, &out.Drives},
+		{`UPDATE OR IGNORE user_storage SET chat_id = ? WHERE chat_id = ?`, &out.UserStorage},
+		{`UPDATE blobs SET storage_chat_id = ? WHERE storage_chat_id = ?`, &out.Blobs},
+		{`UPDATE OR IGNORE blob_copies SET chat_id = ? WHERE chat_id = ?`, &out.Copies},
+		{`UPDATE webdav_upload_parts SET storage_chat_id = ? WHERE storage_chat_id = ?`, &out.UploadParts},
+		{`UPDATE storage_deletions SET chat_id = ? WHERE chat_id = ?`, &out.Deletions},
+	}
+	for _, t := range targets {
+		res, err := tx.ExecContext(ctx, t.stmt, to, from)
+		if err != nil {
+			return err
+		}
+		*t.count, _ = res.RowsAffected()
+	}
+	for _, table := range []string{"files", "file_parts"} {
+		res, err := tx.ExecContext(ctx, `UPDATE `+table+` SET chat_id = ? WHERE chat_id = ?`, to, from)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		out.FileMessages += n
+	}
+	return nil
+}
+
+// RecoverAbandonedUploads turns WebDAV uploads untouched for olderThan into
+// files under each owner's RecoveredUploadsDir, keeping the parts that were
+// stored in order from the start. It also drops file and upload parts whose
+// parent row no longer exists and returns how many went.
+func (s *Store) RecoverAbandonedUploads(ctx context.Context, olderThan time.Duration, apply bool) ([]RecoveredUpload, int64, error) {
+	var out []RecoveredUpload
+	var dropped int64
+	err := s.runSurgery(ctx, apply, func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`DELETE FROM file_parts WHERE file_id NOT IN (SELECT id FROM files)`,
+			`DELETE FROM webdav_upload_parts WHERE upload_id NOT IN (SELECT id FROM webdav_uploads)`,
+		} {
+			res, err := tx.ExecContext(ctx, stmt)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			dropped += n
+		}
+
+		uploads, err := abandonedUploadsTx(ctx, tx, olderThan)
+		if err != nil {
+			return err
+		}
+		for _, up := range uploads {
+			rec, err := recoverUploadTx(ctx, tx, up)
+			if err != nil {
+				return fmt.Errorf("upload %d: %w", up.ID, err)
+			}
+			out = append(out, rec)
+		}
+		return nil
+	})
+	return out, dropped, err
+}
+
+func abandonedUploadsTx(ctx context.Context, tx *sql.Tx, olderThan time.Duration) ([]WebDAVUpload, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, user_id, dir_id, name, total_size, uploaded_size, mime_type, sha256_state, created_at, updated_at FROM webdav_uploads ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cutoff := now().Add(-olderThan)
+	var out []WebDAVUpload
+	for rows.Next() {
+		var u WebDAVUpload
+		if err := rows.Scan(&u.ID, &u.UserID, &u.DirID, &u.Name, &u.TotalSize, &u.UploadedSize, &u.MimeType, &u.HashState, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if u.UpdatedAt.After(cutoff) {
+			continue
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+func recoverUploadTx(ctx context.Context, tx *sql.Tx, up WebDAVUpload) (RecoveredUpload, error) {
+	rec := RecoveredUpload{UploadID: up.ID, UserID: up.UserID}
+	rows, err := tx.QueryContext(ctx, `SELECT part_index, telegram_file_id, file_unique_id, size, storage_chat_id, storage_message_id, sha256 FROM webdav_upload_parts WHERE upload_id = ? ORDER BY part_index`, up.ID)
+	if err != nil {
+		return rec, err
+	}
+	var parts []FilePartInput
+	// The upload's hash state covers every stored part, so it only
+	// describes the recovered file when no part was left out.
+	checksum := sumFromState(up.HashState)
+	for rows.Next() {
+		var p FilePartInput
+		if err := rows.Scan(&p.PartIndex, &p.TelegramFileID, &p.FileUniqueID, &p.Size, &p.StorageChatID, &p.StorageMessageID, &p.SHA256); err != nil {
+			rows.Close()
+			return rec, err
+		}
+		if p.PartIndex != len(parts) {
+			checksum = ""
+			break
+		}
+		parts = append(parts, p)
+		rec.Bytes += p.Size
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return rec, err
+	}
+	rec.Parts = len(parts)
+	if len(parts) > 0 {
+		dirID, err := recoveredUploadsDirTx(ctx, tx, up.UserID)
+		if err != nil {
+			return rec, err
+		}
+		name, err := freeNameTx(ctx, tx, up.UserID, dirID, up.Name)
+		if err != nil {
+			return rec, err
+		}
+		if err := insertRecoveredFileTx(ctx, tx, up, dirID, name, parts, rec.Bytes, checksum); err != nil {
+			return rec, err
+		}
+		rec.Path = "/" + RecoveredUploadsDir + "/" + name
+	} else {
+		rec.Skipped = "no parts stored"
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM webdav_uploads WHERE id = ?`, up.ID)
+	return rec, err
+}
+
+func insertRecoveredFileTx(ctx context.Context, tx *sql.Tx, up WebDAVUpload, dirID int64, name string, parts []FilePartInput, size int64, checksum string) error {
+	mimeType := up.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	first := parts[0]
+	// Blobs must exist before the file row so the insert trigger counts it.
+	if len(parts) == 1 {
+		if err := putBlobTx(ctx, tx, first.FileUniqueID, first.TelegramFileID, size); err != nil {
+			return err
+		}
+		if err := setBlobStorageTx(ctx, tx, first.FileUniqueID, first.StorageChatID, first.StorageMessageID); err != nil {
+			return err
+		}
+		if err := setBlobChecksumTx(ctx, tx, first.FileUniqueID, first.SHA256); err != nil {
+			return err
+		}
+	} else if err := putPartBlobsTx(ctx, tx, parts); err != nil {
+		return err
+	}
+	chatID, messageID, err := singleBlobMessageTx(ctx, tx, first.FileUniqueID, parts)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO files(user_id, dir_id, name, file_unique_id, size, mime_type, public_id, sha256, chat_id, message_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, up.UserID, dirID, name, first.FileUniqueID, size, mimeType, newPublicID(), checksum, chatID, messageID, now())
+	if err != nil {
+		return nameConflict(err)
+	}
+	if len(parts) == 1 {
+		return nil
+	}
+	fileID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return insertFilePartsTx(ctx, tx, fileID, parts)
+}
+
+func recoveredUploadsDirTx(ctx context.Context, tx *sql.Tx, userID int64) (int64, error) {
+	var rootID int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM directories WHERE user_id = ? AND parent_id IS NULL ORDER BY id LIMIT 1`, userID).Scan(&rootID); err != nil {
+		return 0, err
+	}
+	return rootFolderTx(ctx, tx, userID, rootID, RecoveredUploadsDir)
+}
+
+func nameTakenTx(ctx context.Context, tx *sql.Tx, userID, dirID int64, name string) (bool, error) {
+	var one int
+	err := tx.QueryRowContext(ctx, `SELECT 1 FROM directories WHERE user_id = ? AND parent_id = ? AND name = ?
+		UNION ALL SELECT 1 FROM files WHERE user_id = ? AND dir_id = ? AND name = ?
+		UNION ALL SELECT 1 FROM shortcuts WHERE user_id = ? AND dir_id = ? AND name = ? LIMIT 1`,
+		userID, dirID, name, userID, dirID, name, userID, dirID, name).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// freeNameTx returns name, or name with a " (n)" suffix before the extension
+// when dirID already holds it.
+func freeNameTx(ctx context.Context, tx *sql.Tx, userID, dirID int64, name string) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; i < 1000; i++ {
+		taken, err := nameTakenTx(ctx, tx, userID, dirID, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	return "", fmt.Errorf("too many files named %s", name)
+}
+
+// FreeName returns name, or name with a " (n)" suffix before the extension
+// when dirID already holds it.
+func (s *Store) FreeName(ctx context.Context, userID, dirID int64, name string) (string, error) {
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	return freeNameTx(ctx, tx, userID, dirID, name)
+}
+
+// RecomputeFileSizes sets the size of every multi-part file to the sum of
+// its parts where the two disagree. Folder, drive and user totals are
+// summed from file sizes when read, so they follow.
+func (s *Store) RecomputeFileSizes(ctx context.Context, apply bool) ([]SizeFix, error) {
+	var out []SizeFix
+	err := s.runSurgery(ctx, apply, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `SELECT f.id, f.user_id, f.name, f.size, SUM(p.size)
+			FROM files f JOIN file_parts p ON p.file_id = f.id
+			GROUP BY f.id HAVING f.size != SUM(p.size) ORDER BY f.id`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var fix SizeFix
+			if err := rows.Scan(&fix.FileID, &fix.UserID, &fix.Name, &fix.Recorded, &fix.Parts); err != nil {
+				rows.Close()
+				return err
+			}
+			out = append(out, fix)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, fix := range out {
+			if _, err := tx.ExecContext(ctx, `UPDATE files SET size = ? WHERE id = ?`, fix.Parts, fix.FileID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return out, err
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// MaxTagLength bounds a tag name so it fits in callback data.
+const MaxTagLength = 32
+
+// Tag is a user's label attachable to any number of files.
+type Tag struct {
+	ID    int64
+	Name  string
+	Files int64
+}
+
+// NormalizeTag lowercases name and strips a leading "#". Tags are single
+// words of letters, digits, "-" and "_".
+func NormalizeTag(name string) (string, error) {
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+	if name == "" {
+		return "", fmt.Errorf("empty tag: %w", os.ErrInvalid)
+	}
+	if len(name) > MaxTagLength {
+		return "", fmt.Errorf("tag %q is longer than %d bytes: %w", name, MaxTagLength, os.ErrInvalid)
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return "", fmt.Errorf("tag %q may only hold letters, digits, - and _: %w", name, os.ErrInvalid)
+		}
+	}
+	return name, nil
+}
+
+// TagFile attaches tag name to a file, creating the tag on first use.
+func (s *Store) TagFile(ctx context.Context, userID, fileID int64, name string) (Tag, error) {
+	name, err := NormalizeTag(name)
+	if err != nil {
+		return Tag{}, err
+	}
+	if _, err := s.GetFileByID(ctx, userID, fileID); err != nil {
+		return Tag{}, err
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Tag{}, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+	if _, err := tx.ExecContext(ctx, `INSERT INTO tags(user_id, name, created_at) VALUES (?, ?, ?) ON CONFLICT(user_id, name) DO NOTHING`, userID, name, now()); err != nil {
+		return Tag{}, err
+	}
+	tag := Tag{Name: name}
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM tags WHERE user_id = ? AND name = ?`, userID, name).Scan(&tag.ID); err != nil {
+		return Tag{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO file_tags(file_id, tag_id, created_at) VALUES (?, ?, ?) ON CONFLICT(file_id, tag_id) DO NOTHING`, fileID, tag.ID, now()); err != nil {
+		return Tag{}, err
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM file_tags WHERE tag_id = ?`, tag.ID).Scan(&tag.Files); err != nil {
+		return Tag{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Tag{}, err
+	}
+	committed = true
+	return tag, nil
+}
+
+// UntagFile detaches a tag from a file. A tag left on no file is deleted.
+func (s *Store) UntagFile(ctx context.Context, userID, fileID, tagID int64) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+	res, err := tx.ExecContext(ctx, `DELETE FROM file_tags WHERE file_id = ? AND tag_id = ?
+		AND tag_id IN (SELECT id FROM tags WHERE user_id = ?)`, fileID, tagID, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE id = ? AND NOT EXISTS (SELECT 1 FROM file_tags WHERE tag_id = ?)`, tagID, tagID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// FileTags lists the tags on a file by name.
+func (s *Store) FileTags(ctx context.Context, userID, fileID int64) ([]Tag, error) {
+	return s.queryTags(ctx, `SELECT t.id, t.name, (SELECT COUNT(*) FROM file_tags c WHERE c.tag_id = t.id)
+		FROM tags t JOIN file_tags ft ON ft.tag_id = t.id
+		WHERE t.user_id = ? AND ft.file_id = ? ORDER BY t.name`, userID, fileID)
+}
+
+// ListTags lists a user's tags in use by name, with how many files carry
+// each. Tags whose files were all deleted are left out.
+func (s *Store) ListTags(ctx context.Context, userID int64) ([]Tag, error) {
+	return s.queryTags(ctx, `SELECT t.id, t.name, COUNT(ft.file_id)
+		FROM tags t JOIN file_tags ft ON ft.tag_id = t.id
+		WHERE t.user_id = ? GROUP BY t.id ORDER BY t.name`, userID)
+}
+
+// FilesWithTag lists a user's files carrying tag name, by name.
+func (s *Store) FilesWithTag(ctx context.Context, userID int64, name string) ([]File, error) {
+	name, err := NormalizeTag(name)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, fileSelect+`
+		JOIN file_tags ft ON ft.file_id = f.id
+		JOIN tags t ON t.id = ft.tag_id
+		WHERE f.user_id = ? AND t.user_id = ? AND t.name = ? ORDER BY f.name, f.id`, userID, userID, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+func (s *Store) queryTags(ctx context.Context, query string, args ...any) ([]Tag, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Files); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// MaxThumbnailBytes bounds a stored thumbnail; they are kept in the
+// database, so only small previews belong there.
+const MaxThumbnailBytes = 200 * 1024
+
+// SetThumbnail stores the preview image of a file, replacing any earlier
+// one. Empty data removes it.
+func (s *Store) SetThumbnail(ctx context.Context, userID, fileID int64, data []byte) error {
+	if len(data) == 0 {
+		_, err := s.DB.ExecContext(ctx, `DELETE FROM thumbnails WHERE file_id IN (SELECT id FROM files WHERE id = ? AND user_id = ?)`, fileID, userID)
+		return err
+	}
+	if len(data) > MaxThumbnailBytes {
+		return fmt.Errorf("thumbnail of %d bytes exceeds %d: %w", len(data), MaxThumbnailBytes, os.ErrInvalid)
+	}
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO thumbnails(file_id, data, created_at)
+		SELECT id, ?, ? FROM files WHERE id = ? AND user_id = ?
+		ON CONFLICT(file_id) DO UPDATE SET data = excluded.data, created_at = excluded.created_at`,
+		data, now(), fileID, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetThumbnail returns the preview image of a file, or sql.ErrNoRows when
+// it has none.
+func (s *Store) GetThumbnail(ctx context.Context, userID, fileID int64) ([]byte, error) {
+	var data []byte
+	err := s.DB.QueryRowContext(ctx, `SELECT t.data FROM thumbnails t JOIN files f ON f.id = t.file_id
+		WHERE t.file_id = ? AND f.user_id = ?`, fileID, userID).Scan(&data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// HasThumbnail reports whether a file has a thumbnail.
+func (s *Store) HasThumbnail(ctx context.Context, userID, fileID int64) (bool, error) {
+	var found int
+	err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM thumbnails t JOIN files f ON f.id = t.file_id
+		WHERE t.file_id = ? AND f.user_id = ?`, fileID, userID).Scan(&found)
+	return found > 0, err
+}
+
+// ThumbnailFileIDs returns the ids of a user's files that have a thumbnail.
+func (s *Store) ThumbnailFileIDs(ctx context.Context, userID int64) (map[int64]bool, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT t.file_id FROM thumbnails t JOIN files f ON f.id = t.file_id WHERE f.user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out[id] = true
+	}
+	return out, rows.Err()
+}
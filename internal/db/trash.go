@@ -0,0 +1,535 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Trash item kinds.
+const (
+	TrashKindFile = "file"
+	TrashKindDir  = "dir"
+)
+
+// TrashItem is a deleted file or folder kept for restore. The rows it was
+// built from are gone; payload holds everything needed to recreate them and
+// trash_blobs keeps the referenced blobs, and their storage messages, alive
+// until the item is purged.
+type TrashItem struct {
+	ID               int64
+	UserID           int64
+	Kind             string
+	Name             string
+	OriginalRootID   int64
+	OriginalParentID int64
+	OriginalPath     string
+	Size             int64
+	Files            int64
+	DeletedAt        time.Time
+	payload          string
+}
+
+// IsDir reports whether the item is a trashed folder.
+func (t TrashItem) IsDir() bool {
+	return t.Kind == TrashKindDir
+}
+
+// trashFile is the snapshot of a file row. It carries the blob file_ids so
+// a restore does not depend on the blob rows surviving.
+type trashFile struct {
+	Name         string          `json:"name"`
+	PublicID     string          `json:"public_id"`
+	FileID       string          `json:"file_id"`
+	FileUniqueID string          `json:"file_unique_id"`
+	Size         int64           `json:"size"`
+	MimeType     string          `json:"mime_type"`
+	SHA256       string          `json:"sha256,omitempty"`
+	ChatID       int64           `json:"chat_id,omitempty"`
+	MessageID    int             `json:"message_id,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Parts        []FilePartInput `json:"parts,omitempty"`
+}
+
+type trashDir struct {
+	Name     string      `json:"name"`
+	PublicID string      `json:"public_id"`
+	Dirs     []trashDir  `json:"dirs,omitempty"`
+	Files    []trashFile `json:"files,omitempty"`
+}
+
+func (f trashFile) blobIDs(out []string) []string {
+	if f.FileUniqueID == "" && len(f.Parts) == 0 {
+		return out
+	}
+	if len(f.Parts) <= 1 {
+		return append(out, f.FileUniqueID)
+	}
+	for _, p := range f.Parts {
+		out = append(out, p.FileUniqueID)
+	}
+	return out
+}
+
+func (d trashDir) blobIDs(out []string) []string {
+	for _, f := range d.Files {
+		out = f.blobIDs(out)
+	}
+	for _, child := range d.Dirs {
+		out = child.blobIDs(out)
+	}
+	return out
+}
+
+const trashColumns = `id, user_id, kind, name, original_root_id, original_parent_id, original_path, size, files, payload, deleted_at`
+
+func scanTrashItem(row rowScanner, t *TrashItem) error {
+	return row.Scan(&t.ID, &t.UserID, &t.Kind, &t.Name, &t.OriginalRootID, &t.OriginalParentID, &t.OriginalPath, &t.Size, &t.Files, &t.payload, &t.DeletedAt)
+}
+
+// TrashFile moves a file into the trash.
+func (s *Store) TrashFile(ctx context.Context, userID, fileID int64) (TrashItem, error) {
+	file, err := s.GetFileByID(ctx, userID, fileID)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	if err := checkRevision(ctx, file.Revision); err != nil {
+		return TrashItem{}, err
+	}
+	snap, err := s.snapshotFile(ctx, file)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	item := TrashItem{UserID: userID, Kind: TrashKindFile, Name: file.Name, Size: file.Size, Files: 1}
+	if err := s.fillTrashOrigin(ctx, &item, file.DirID); err != nil {
+		return TrashItem{}, err
+	}
+	return s.insertTrashItem(ctx, item, snap, snap.blobIDs(nil), func(tx *sql.Tx) error {
+		cond, args := revisionClause(ctx, []any{fileID, userID})
+		res, err := tx.ExecContext(ctx, `DELETE FROM files WHERE id = ? AND user_id = ?`+cond, args...)
+		if err != nil {
+			return err
+		}
+		if count, _ := res.RowsAffected(); count == 0 {
+			return noRowsError(ctx)
+		}
+		return nil
+	})
+}
+
+// TrashDir moves a folder and everything below it into the trash.
+func (s *Store) TrashDir(ctx context.Context, userID, dirID int64) (TrashItem, error) {
+	dir, err := s.GetDirByID(ctx, userID, dirID)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	if !dir.ParentID.Valid {
+		return TrashItem{}, errors.New("cannot delete root directory")
+	}
+	if err := checkRevision(ctx, dir.Revision); err != nil {
+		return TrashItem{}, err
+	}
+	item := TrashItem{UserID: userID, Kind: TrashKindDir, Name: dir.Name}
+	snap, err := s.snapshotDir(ctx, dir, &item)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	if err := s.fillTrashOrigin(ctx, &item, dir.ParentID.Int64); err != nil {
+		return TrashItem{}, err
+	}
+	return s.insertTrashItem(ctx, item, snap, snap.blobIDs(nil), func(tx *sql.Tx) error {
+		return deleteSubtree(ctx, tx, userID, dirID)
+	})
+}
+
+func (s *Store) snapshotFile(ctx context.Context, file File) (trashFile, error) {
+	snap := trashFile{
+		Name:         file.Name,
+		PublicID:     file.PublicID,
+		FileID:       file.FileID,
+		FileUniqueID: file.FileUniqueID,
+		Size:         file.Size,
+		MimeType:     file.MimeType,
+		SHA256:       file.SHA256,
+		ChatID:       file.ChatID,
+		MessageID:    file.MessageID,
+		CreatedAt:    file.CreatedAt,
+	}
+	parts, err := s.ListFileParts(ctx, file.ID)
+	if err != nil {
+		return trashFile{}, err
+	}
+	for _, p := range parts {
+		snap.Parts = append(snap.Parts, FilePartInput{PartIndex: p.PartIndex, TelegramFileID: p.TelegramFileID, FileUniqueID: p.FileUniqueID, Size: p.Size, StorageChatID: p.StorageChatID, StorageMessageID: p.StorageMessageID, SHA256: p.SHA256})
+	}
+	return snap, nil
+}
+
+func (s *Store) snapshotDir(ctx context.Context, dir Directory, item *TrashItem) (trashDir, error) {
+	snap := trashDir{Name: dir.Name, PublicID: dir.PublicID}
+	files, err := s.ListFiles(ctx, dir.UserID, dir.ID)
+	if err != nil {
+		return trashDir{}, err
+	}
+	for _, f := range files {
+		fs, err := s.snapshotFile(ctx, f)
+		if err != nil {
+			return trashDir{}, err
+		}
+		snap.Files = append(snap.Files, fs)
+		item.Files++
+		item.Size += f.Size
+	}
+	dirs, err := s.ListDirs(ctx, dir.UserID, dir.ID)
+	if err != nil {
+		return trashDir{}, err
+	}
+	for _, d := range dirs {
+		child, err := s.snapshotDir(ctx, d, item)
+		if err != nil {
+			return trashDir{}, err
+		}
+		snap.Dirs = append(snap.Dirs, child)
+	}
+	return snap, nil
+}
+
+// fillTrashOrigin records where an item lived so it can be put back, even
+// after the parent folder itself has been deleted.
+func (s *Store) fillTrashOrigin(ctx context.Context, item *TrashItem, parentID int64) error {
+	path, err := s.GetDirPath(ctx, item.UserID, parentID)
+	if err != nil {
+		return err
+	}
+	item.OriginalParentID = parentID
+	item.OriginalPath = path
+	if drive, err := s.DriveForDir(ctx, item.UserID, parentID); err == nil {
+		item.OriginalRootID = drive.RootDirID
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) insertTrashItem(ctx context.Context, item TrashItem, snap any, blobIDs []string, remove func(tx *sql.Tx) error) (TrashItem, error) {
+	payload, err := json.Marshal(snap)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+	item.DeletedAt = now()
+	item.payload = string(payload)
+	res, err := tx.ExecContext(ctx, `INSERT INTO trash_items(user_id, kind, name, original_root_id, original_parent_id, original_path, size, files, payload, deleted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		item.UserID, item.Kind, item.Name, item.OriginalRootID, item.OriginalParentID, item.OriginalPath, item.Size, item.Files, item.payload, item.DeletedAt)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	if item.ID, err = res.LastInsertId(); err != nil {
+		return TrashItem{}, err
+	}
+	for _, id := range blobIDs {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO trash_blobs(trash_id, file_unique_id) VALUES (?, ?)`, item.ID, id); err != nil {
+			return TrashItem{}, err
+		}
+	}
+	if err := remove(tx); err != nil {
+		return TrashItem{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return TrashItem{}, err
+	}
+	committed = true
+	s.audit(ctx, item.UserID, AuditTrash, item.ID, item.Name, item.Kind+" from "+item.OriginalPath)
+	return item, nil
+}
+
+// ListTrash returns a user's trash, most recently deleted first. A non-empty
+// query keeps only items whose name contains it, ignoring case.
+func (s *Store) ListTrash(ctx context.Context, userID int64, query string) ([]TrashItem, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+trashColumns+` FROM trash_items WHERE user_id = ? ORDER BY deleted_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	query = strings.ToLower(strings.TrimSpace(query))
+	var out []TrashItem
+	for rows.Next() {
+		var t TrashItem
+		if err := scanTrashItem(rows, &t); err != nil {
+			return nil, err
+		}
+		if query != "" && !strings.Contains(strings.ToLower(t.Name), query) {
+			continue
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// GetTrashItem loads one trash item.
+func (s *Store) GetTrashItem(ctx context.Context, userID, itemID int64) (TrashItem, error) {
+	var t TrashItem
+	row := s.DB.QueryRowContext(ctx, `SELECT `+trashColumns+` FROM trash_items WHERE id = ? AND user_id = ?`, itemID, userID)
+	if err := scanTrashItem(row, &t); err != nil {
+		return TrashItem{}, err
+	}
+	return t, nil
+}
+
+// TrashedFile returns a trashed file as it was, with its parts, so its
+// content can still be read. The file has no id or folder; it exists only
+// in the trash.
+func (s *Store) TrashedFile(ctx context.Context, userID, itemID int64) (File, []FilePart, error) {
+	item, err := s.GetTrashItem(ctx, userID, itemID)
+	if err != nil {
+		return File{}, nil, err
+	}
+	if item.IsDir() {
+		return File{}, nil, fmt.Errorf("trash item %d is a folder: %w", itemID, os.ErrInvalid)
+	}
+	var snap trashFile
+	if err := json.Unmarshal([]byte(item.payload), &snap); err != nil {
+		return File{}, nil, err
+	}
+	file := File{
+		UserID:       userID,
+		Name:         snap.Name,
+		FileID:       snap.FileID,
+		FileUniqueID: snap.FileUniqueID,
+		Size:         snap.Size,
+		MimeType:     snap.MimeType,
+		PublicID:     snap.PublicID,
+		SHA256:       snap.SHA256,
+		ChatID:       snap.ChatID,
+		MessageID:    snap.MessageID,
+		CreatedAt:    snap.CreatedAt,
+		ModifiedAt:   snap.CreatedAt,
+	}
+	var parts []FilePart
+	if len(snap.Parts) > 1 {
+		for _, p := range snap.Parts {
+			parts = append(parts, FilePart{PartIndex: p.PartIndex, TelegramFileID: p.TelegramFileID, FileUniqueID: p.FileUniqueID, Size: p.Size, StorageChatID: p.StorageChatID, StorageMessageID: p.StorageMessageID, SHA256: p.SHA256})
+		}
+	}
+	return file, parts, nil
+}
+
+// RestoreTrashItem recreates a trashed item under parentID as name and
+// removes it from the trash. Files keep their public IDs, so existing /p/
+// and /dl/ links work again. A taken name is reported as a conflict error,
+// and an item too big for the user's quota with ErrQuotaExceeded.
+func (s *Store) RestoreTrashItem(ctx context.Context, userID, itemID, parentID int64, name string) error {
+	item, err := s.GetTrashItem(ctx, userID, itemID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.GetDirByID(ctx, userID, parentID); err != nil {
+		return err
+	}
+	if err := s.CheckNameAvailable(ctx, userID, parentID, name); err != nil {
+		return err
+	}
+	if err := s.CheckQuota(ctx, userID, name, item.Size); err != nil {
+		return err
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+	if item.IsDir() {
+		var snap trashDir
+		if err := json.Unmarshal([]byte(item.payload), &snap); err != nil {
+			return err
+		}
+		snap.Name = name
+		if err := restoreDirTx(ctx, tx, userID, parentID, snap); err != nil {
+			return err
+		}
+	} else {
+		var snap trashFile
+		if err := json.Unmarshal([]byte(item.payload), &snap); err != nil {
+			return err
+		}
+		snap.Name = name
+		if err := restoreFileTx(ctx, tx, userID, parentID, snap); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM trash_items WHERE id = ? AND user_id = ?`, itemID, userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	s.audit(ctx, userID, AuditTrashRestore, itemID, name, "")
+	return nil
+}
+
+func restoreDirTx(ctx context.Context, tx *sql.Tx, userID, parentID int64, snap trashDir) error {
+	publicID, err := freePublicID(ctx, tx, "directories", snap.PublicID)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO directories(user_id, parent_id, name, public_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`, userID, parentID, snap.Name, publicID, now(), now())
+	if err != nil {
+		return nameConflict(err)
+	}
+	dirID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, f := range snap.Files {
+		if err := restoreFileTx(ctx, tx, userID, dirID, f); err != nil {
+			return err
+		}
+	}
+	for _, d := range snap.Dirs {
+		if err := restoreDirTx(ctx, tx, userID, dirID, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restoreFileTx(ctx context.Context, tx *sql.Tx, userID, dirID int64, snap trashFile) error {
+	publicID, err := freePublicID(ctx, tx, "files", snap.PublicID)
+	if err != nil {
+		return err
+	}
+	if len(snap.Parts) <= 1 {
+		if err := putBlobTx(ctx, tx, snap.FileUniqueID, snap.FileID, snap.Size); err != nil {
+			return err
+		}
+	} else if err := putPartBlobsTx(ctx, tx, snap.Parts); err != nil {
+		return err
+	}
+	createdAt := snap.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now()
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO files(user_id, dir_id, name, file_unique_id, size, mime_type, public_id, sha256, chat_id, message_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, userID, dirID, snap.Name, snap.FileUniqueID, snap.Size, snap.MimeType, publicID, snap.SHA256, snap.ChatID, snap.MessageID, createdAt)
+	if err != nil {
+		return nameConflict(err)
+	}
+	fileID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if len(snap.Parts) > 1 {
+		return insertFilePartsTx(ctx, tx, fileID, snap.Parts)
+	}
+	return nil
+}
+
+// freePublicID returns publicID unless it is empty or already taken in table.
+func freePublicID(ctx context.Context, tx *sql.Tx, table, publicID string) (string, error) {
+	if publicID == "" {
+		return newPublicID(), nil
+	}
+	var one int
+	err := tx.QueryRowContext(ctx, `SELECT 1 FROM `+table+` WHERE public_id = ?`, publicID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return publicID, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return newPublicID(), nil
+}
+
+// PurgeTrashItem drops a trash item for good. Blobs nothing else references
+// go with it and their storage messages are queued for deletion.
+func (s *Store) PurgeTrashItem(ctx context.Context, userID, itemID int64) error {
+	item, err := s.GetTrashItem(ctx, userID, itemID)
+	if err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM trash_items WHERE id = ? AND user_id = ?`, itemID, userID)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	s.audit(ctx, userID, AuditTrashPurge, itemID, item.Name, "")
+	return nil
+}
+
+// PurgeTrash drops the trash items of userID, or of every user when it is
+// 0, deleted more than olderThan ago, or all of them when olderThan is 0.
+// It returns how many items it dropped per user, or without apply how
+// many it would drop.
+func (s *Store) PurgeTrash(ctx context.Context, userID int64, olderThan time.Duration, apply bool) (map[int64]int64, error) {
+	where, args := ` WHERE 1 = 1`, []any{}
+	if userID != 0 {
+		where += ` AND user_id = ?`
+		args = append(args, userID)
+	}
+	if olderThan > 0 {
+		where += ` AND deleted_at < ?`
+		args = append(args, now().Add(-olderThan))
+	}
+	rows, err := s.DB.QueryContext(ctx, `SELECT user_id, COUNT(*) FROM trash_items`+where+` GROUP BY user_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	perUser := map[int64]int64{}
+	for rows.Next() {
+		var id, n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		perUser[id] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !apply || len(perUser) == 0 {
+		return perUser, nil
+	}
+	if _, err := s.DB.ExecContext(ctx, `DELETE FROM trash_items`+where, args...); err != nil {
+		return nil, err
+	}
+	for id, n := range perUser {
+		s.audit(ctx, id, AuditTrashPurge, 0, "", fmt.Sprintf("purged %d item(s)", n))
+	}
+	return perUser, nil
+}
+
+// EmptyTrash drops every trash item a user has and returns how many went.
+func (s *Store) EmptyTrash(ctx context.Context, userID int64) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM trash_items WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	if err == nil && count > 0 {
+		s.audit(ctx, userID, AuditTrashPurge, 0, "", fmt.Sprintf("emptied %d item(s)", count))
+	}
+	return count, err
+}
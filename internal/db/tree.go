@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math"
+)
+
+// ErrTreeTooLarge is returned by ListTree for a subtree over its limits.
+var ErrTreeTooLarge = errors.New("folder tree too large")
+
+// Tree is everything below a folder, loaded at once.
+type Tree struct {
+	// Dirs are the folders below the root, shallowest first and by name
+	// within a level.
+	Dirs []Directory
+	// Files are the files in the root and in Dirs, by folder and name.
+	Files []File
+}
+
+// treeWalk selects the ids of a folder and of the folders below it down to
+// a depth limit, with how deep each one is.
+const treeWalk = `WITH RECURSIVE tree(id, depth) AS (
+		SELECT id, 0 FROM directories WHERE id = ? AND user_id = ?
+		UNION ALL
+		SELECT d.id, tree.depth + 1 FROM directories d JOIN tree ON d.parent_id = tree.id
+		WHERE d.user_id = ? AND tree.depth < ?
+	) `
+
+// ListTree loads every folder and file below rootID in two queries, for
+// listings of a whole tree. It returns ErrTreeTooLarge rather than a partial
+// tree when there are more than maxItems folders and files together, or
+// folders nested more than maxDepth levels deep; zero disables a limit.
+func (s *Store) ListTree(ctx context.Context, userID, rootID int64, maxItems, maxDepth int) (Tree, error) {
+	// One level past the limit shows whether the tree goes deeper, and one
+	// item past it whether there are more.
+	depthLimit := maxDepth + 1
+	if maxDepth <= 0 {
+		depthLimit = math.MaxInt32
+	}
+	itemLimit := maxItems + 1
+	if maxItems <= 0 {
+		itemLimit = -1
+	}
+	walkArgs := []any{rootID, userID, userID, depthLimit}
+	rows, err := s.DB.QueryContext(ctx, treeWalk+`SELECT `+dirColumns+`, tree.depth FROM directories JOIN tree USING (id)
+		WHERE tree.depth > 0 ORDER BY tree.depth, name LIMIT ?`, append(walkArgs, itemLimit)...)
+	if err != nil {
+		return Tree{}, err
+	}
+	var tree Tree
+	for rows.Next() {
+		var d Directory
+		var depth int
+		if err := rows.Scan(&d.ID, &d.UserID, &d.ParentID, &d.Name, &d.PublicID, &d.Revision, &d.CreatedAt, &d.UpdatedAt, &depth); err != nil {
+			rows.Close()
+			return Tree{}, err
+		}
+		if maxDepth > 0 && depth > maxDepth {
+			rows.Close()
+			return Tree{}, ErrTreeTooLarge
+		}
+		tree.Dirs = append(tree.Dirs, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Tree{}, err
+	}
+	if maxItems > 0 {
+		if len(tree.Dirs) > maxItems {
+			return Tree{}, ErrTreeTooLarge
+		}
+		itemLimit = maxItems - len(tree.Dirs) + 1
+	}
+	rows, err = s.DB.QueryContext(ctx, treeWalk+fileSelect+` WHERE f.user_id = ? AND f.dir_id IN (SELECT id FROM tree)
+		ORDER BY f.dir_id, f.name LIMIT ?`, append(walkArgs, userID, itemLimit)...)
+	if err != nil {
+		return Tree{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f File
+		if err := scanFile(rows, &f); err != nil {
+			return Tree{}, err
+		}
+		tree.Files = append(tree.Files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return Tree{}, err
+	}
+	if maxItems > 0 && len(tree.Dirs)+len(tree.Files) > maxItems {
+		return Tree{}, ErrTreeTooLarge
+	}
+	return tree, nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// ExpiredUploads counts what ExpireWebDAVUploads removed.
+type ExpiredUploads struct {
+	Sessions int64
+	Parts    int64
+	// Messages is the number of storage chat messages queued for deletion.
+	Messages int64
+}
+
+// ExpireWebDAVUploads drops WebDAV upload sessions untouched for idle,
+// which clients can no longer resume. With deleteMessages the storage chat
+// messages of their parts are queued for deletion, except those some file,
+// part or blob still points at.
+func (s *Store) ExpireWebDAVUploads(ctx context.Context, idle time.Duration, deleteMessages bool) (ExpiredUploads, error) {
+	var out ExpiredUploads
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return out, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	cutoff := now().Add(-idle)
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM webdav_upload_parts WHERE upload_id IN (SELECT id FROM webdav_uploads WHERE updated_at < ?)`, cutoff).Scan(&out.Parts); err != nil {
+		return out, err
+	}
+	if deleteMessages {
+		res, err := tx.ExecContext(ctx, `INSERT INTO storage_deletions(chat_id, message_id)
+			SELECT DISTINCT p.storage_chat_id, p.storage_message_id FROM webdav_upload_parts p
+			JOIN webdav_uploads u ON u.id = p.upload_id
+			WHERE u.updated_at < ? AND p.storage_message_id != 0
+				AND NOT EXISTS (SELECT 1 FROM blobs b WHERE b.storage_chat_id = p.storage_chat_id AND b.storage_message_id = p.storage_message_id)
+				AND NOT EXISTS (SELECT 1 FROM blob_copies c WHERE c.chat_id = p.storage_chat_id AND c.message_id = p.storage_message_id)
+				AND NOT EXISTS (SELECT 1 FROM file_parts fp WHERE fp.chat_id = p.storage_chat_id AND fp.message_id = p.storage_message_id)
+				AND NOT EXISTS (SELECT 1 FROM files f WHERE f.chat_id = p.storage_chat_id AND f.message_id = p.storage_message_id)
+				AND NOT EXISTS (SELECT 1 FROM webdav_upload_parts o JOIN webdav_uploads ou ON ou.id = o.upload_id
+					WHERE ou.updated_at >= ? AND o.storage_chat_id = p.storage_chat_id AND o.storage_message_id = p.storage_message_id)`, cutoff, cutoff)
+		if err != nil {
+			return out, err
+		}
+		out.Messages, _ = res.RowsAffected()
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM webdav_uploads WHERE updated_at < ?`, cutoff)
+	if err != nil {
+		return out, err
+	}
+	out.Sessions, _ = res.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return out, err
+	}
+	committed = true
+	return out, nil
+}
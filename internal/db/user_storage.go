@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// UserStorage is a user's own storage chat: a private channel or group
+// where the bot is an admin. It backs the user's WebDAV uploads and copies
+// into drives without a storage chat of their own, in place of the server's
+// STORAGE_CHAT_ID.
+type UserStorage struct {
+	UserID    int64
+	ChatID    int64
+	Title     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// GetUserStorage returns a user's storage chat, or sql.ErrNoRows when they
+// use the server's.
+func (s *Store) GetUserStorage(ctx context.Context, userID int64) (UserStorage, error) {
+	var us UserStorage
+	err := s.DB.QueryRowContext(ctx, `SELECT user_id, chat_id, title, created_at, updated_at FROM user_storage WHERE user_id = ?`, userID).
+		Scan(&us.UserID, &us.ChatID, &us.Title, &us.CreatedAt, &us.UpdatedAt)
+	return us, err
+}
+
+// UserForStorageChat returns the user whose storage chat is chatID, or
+// sql.ErrNoRows when it is nobody's.
+func (s *Store) UserForStorageChat(ctx context.Context, chatID int64) (int64, error) {
+	var userID int64
+	err := s.DB.QueryRowContext(ctx, `SELECT user_id FROM user_storage WHERE chat_id = ?`, chatID).Scan(&userID)
+	return userID, err
+}
+
+// SetUserStorage makes chatID the user's storage chat. Checking that the bot
+// can post there is the caller's job. A chat is one user's storage at most.
+func (s *Store) SetUserStorage(ctx context.Context, userID, chatID int64, title string) error {
+	if chatID == 0 {
+		return fmt.Errorf("storage chat id is zero: %w", os.ErrInvalid)
+	}
+	ts := now()
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO user_storage(user_id, chat_id, title, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET chat_id = excluded.chat_id, title = excluded.title, updated_at = excluded.updated_at`,
+		userID, chatID, strings.TrimSpace(title), ts, ts)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: user_storage.chat_id") {
+			return fmt.Errorf("chat %d is another user's storage chat: %w", chatID, os.ErrExist)
+		}
+		return err
+	}
+	s.audit(ctx, userID, AuditStorageSet, chatID, title, "")
+	return nil
+}
+
+// ClearUserStorage returns a user to the server's storage chat. Content
+// already stored in their chat stays there and keeps working.
+func (s *Store) ClearUserStorage(ctx context.Context, userID int64) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM user_storage WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	s.audit(ctx, userID, AuditStorageClear, 0, "", "")
+	return nil
+}
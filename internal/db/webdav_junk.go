@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// MaxWebDAVJunkSize caps the content of a WebDAVJunkFile. The files kept
+// this way are a few kilobytes at most.
+const MaxWebDAVJunkSize = 4 << 20
+
+// WebDAVJunkFile is a file a WebDAV client wrote for itself, such as a
+// .DS_Store, kept in the database instead of the storage chat. Junk files
+// are not listed and go with their folder.
+type WebDAVJunkFile struct {
+	ID        int64
+	UserID    int64
+	DirID     int64
+	Name      string
+	Content   []byte
+	UpdatedAt time.Time
+}
+
+// GetWebDAVJunk returns the junk file called name in dirID.
+func (s *Store) GetWebDAVJunk(ctx context.Context, dirID int64, name string) (*WebDAVJunkFile, error) {
+	var j WebDAVJunkFile
+	err := s.DB.QueryRowContext(ctx, `SELECT id, user_id, dir_id, name, content, updated_at FROM webdav_junk WHERE dir_id = ? AND name = ?`, dirID, name).
+		Scan(&j.ID, &j.UserID, &j.DirID, &j.Name, &j.Content, &j.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// PutWebDAVJunk stores content as the junk file called name in dirID,
+// replacing the one already there.
+func (s *Store) PutWebDAVJunk(ctx context.Context, userID, dirID int64, name string, content []byte) error {
+	if content == nil {
+		content = []byte{}
+	}
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO webdav_junk(user_id, dir_id, name, content, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(dir_id, name) DO UPDATE SET content = excluded.content, updated_at = excluded.updated_at`, userID, dirID, name, content, now())
+	return err
+}
+
+// DeleteWebDAVJunk deletes a junk file.
+func (s *Store) DeleteWebDAVJunk(ctx context.Context, id int64) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM webdav_junk WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MoveWebDAVJunk moves and renames a junk file, replacing the junk file
+// already at the destination.
+func (s *Store) MoveWebDAVJunk(ctx context.Context, id, dirID int64, name string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webdav_junk WHERE dir_id = ? AND name = ? AND id != ?`, dirID, name, id); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE webdav_junk SET dir_id = ?, name = ?, updated_at = ? WHERE id = ?`, dirID, name, now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
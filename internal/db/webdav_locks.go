@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrLocked reports a WebDAV lock that conflicts with one already held.
+var ErrLocked = errors.New("locked")
+
+// WebDAVLock is an exclusive write lock a WebDAV client took with LOCK on a
+// path in one of a user's drives. Root is the path from the drive's root
+// folder; a lock without ZeroDepth covers everything below it too.
+type WebDAVLock struct {
+	Token     string
+	UserID    int64
+	DriveID   int64
+	Root      string
+	ZeroDepth bool
+	OwnerXML  string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+const webdavLockColumns = `token, user_id, drive_id, root, zero_depth, owner_xml, expires_at, created_at`
+
+func scanWebDAVLock(row rowScanner, l *WebDAVLock) error {
+	return row.Scan(&l.Token, &l.UserID, &l.DriveID, &l.Root, &l.ZeroDepth, &l.OwnerXML, &l.ExpiresAt, &l.CreatedAt)
+}
+
+// webdavLockConflict selects a live lock in a drive that a new lock on a
+// root would conflict with: one on the root itself, one covering it from
+// above and, for a new lock that is not zero-depth, one below it.
+const webdavLockConflict = `SELECT ` + webdavLockColumns + ` FROM webdav_locks
+	WHERE user_id = ? AND drive_id = ? AND expires_at > ? AND (root = ?
+		OR (zero_depth = 0 AND (root = '/' OR substr(?, 1, length(root) + 1) = root || '/'))
+		OR (? = 0 AND (? = '/' OR substr(root, 1, length(?) + 1) = ? || '/')))
+	LIMIT 1`
+
+func webdavLockConflictArgs(userID, driveID int64, root string, zeroDepth bool) []any {
+	return []any{userID, driveID, now(), root, root, zeroDepth, root, root, root}
+}
+
+// CreateWebDAVLock stores lock under a new token and returns it. It fails
+// with ErrLocked when a live lock in the same drive conflicts with it.
+func (s *Store) CreateWebDAVLock(ctx context.Context, lock WebDAVLock) (WebDAVLock, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return WebDAVLock{}, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webdav_locks WHERE expires_at <= ?`, now()); err != nil {
+		return WebDAVLock{}, err
+	}
+	var held WebDAVLock
+	err = scanWebDAVLock(tx.QueryRowContext(ctx, webdavLockConflict, webdavLockConflictArgs(lock.UserID, lock.DriveID, lock.Root, lock.ZeroDepth)...), &held)
+	if err == nil {
+		return WebDAVLock{}, ErrLocked
+	}
+	if err != sql.ErrNoRows {
+		return WebDAVLock{}, err
+	}
+	lock.Token = "opaquelocktoken:" + newPublicID()
+	lock.CreatedAt = now()
+	if _, err := tx.ExecContext(ctx, `INSERT INTO webdav_locks(`+webdavLockColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		lock.Token, lock.UserID, lock.DriveID, lock.Root, lock.ZeroDepth, lock.OwnerXML, lock.ExpiresAt.UTC(), lock.CreatedAt); err != nil {
+		return WebDAVLock{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return WebDAVLock{}, err
+	}
+	committed = true
+	return lock, nil
+}
+
+// ConflictingWebDAVLock returns a live lock that a new lock on root would
+// conflict with, or sql.ErrNoRows when there is none.
+func (s *Store) ConflictingWebDAVLock(ctx context.Context, userID, driveID int64, root string, zeroDepth bool) (WebDAVLock, error) {
+	var l WebDAVLock
+	err := scanWebDAVLock(s.DB.QueryRowContext(ctx, webdavLockConflict, webdavLockConflictArgs(userID, driveID, root, zeroDepth)...), &l)
+	return l, err
+}
+
+// GetWebDAVLock returns the user's live lock with token.
+func (s *Store) GetWebDAVLock(ctx context.Context, userID int64, token string) (WebDAVLock, error) {
+	var l WebDAVLock
+	err := scanWebDAVLock(s.DB.QueryRowContext(ctx, `SELECT `+webdavLockColumns+` FROM webdav_locks WHERE token = ? AND user_id = ? AND expires_at > ?`, token, userID, now()), &l)
+	return l, err
+}
+
+// RefreshWebDAVLock moves the expiry of the user's live lock with token.
+func (s *Store) RefreshWebDAVLock(ctx context.Context, userID int64, token string, expiresAt time.Time) (WebDAVLock, error) {
+	res, err := s.DB.ExecContext(ctx, `UPDATE webdav_locks SET expires_at = ? WHERE token = ? AND user_id = ? AND expires_at > ?`, expiresAt.UTC(), token, userID, now())
+	if err != nil {
+		return WebDAVLock{}, err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return WebDAVLock{}, sql.ErrNoRows
+	}
+	return s.GetWebDAVLock(ctx, userID, token)
+}
+
+// DeleteWebDAVLock releases the user's live lock with token.
+func (s *Store) DeleteWebDAVLock(ctx context.Context, userID int64, token string) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM webdav_locks WHERE token = ? AND user_id = ? AND expires_at > ?`, token, userID, now())
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PurgeExpiredWebDAVLocks drops locks past their expiry and returns how many
+// went. Expired locks are ignored everywhere, so this only reclaims space.
+func (s *Store) PurgeExpiredWebDAVLocks(ctx context.Context) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM webdav_locks WHERE expires_at <= ?`, now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// WebDAVTempUsernamePrefix starts every temporary WebDAV username. Telegram
+// usernames cannot contain '-', so the two never collide.
+const WebDAVTempUsernamePrefix = "tmp-"
+
+// WebDAVTempCredential is a short-lived WebDAV login, optionally read-only
+// and limited to one folder.
+type WebDAVTempCredential struct {
+	ID         int64
+	UserID     int64
+	Username   string
+	ReadOnly   bool
+	ScopeDirID sql.NullInt64
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
+const webdavTempColumns = `id, user_id, username, read_only, scope_dir_id, expires_at, created_at`
+
+func scanWebDAVTemp(row rowScanner, c *WebDAVTempCredential) error {
+	return row.Scan(&c.ID, &c.UserID, &c.Username, &c.ReadOnly, &c.ScopeDirID, &c.ExpiresAt, &c.CreatedAt)
+}
+
+// CreateWebDAVTempCredential stores a temporary login. scopeDirID 0 grants
+// the whole primary drive.
+func (s *Store) CreateWebDAVTempCredential(ctx context.Context, userID int64, username, password string, expiresAt time.Time, readOnly bool, scopeDirID int64) (WebDAVTempCredential, error) {
+	if !strings.HasPrefix(username, WebDAVTempUsernamePrefix) {
+		return WebDAVTempCredential{}, errors.New("temporary username must start with " + WebDAVTempUsernamePrefix)
+	}
+	if password == "" {
+		return WebDAVTempCredential{}, errors.New("password cannot be empty")
+	}
+	var scope any
+	if scopeDirID != 0 {
+		if _, err := s.GetDirByID(ctx, userID, scopeDirID); err != nil {
+			return WebDAVTempCredential{}, err
+		}
+		scope = scopeDirID
+	}
+	if err := s.DeleteExpiredWebDAVTempCredentials(ctx); err != nil {
+		return WebDAVTempCredential{}, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return WebDAVTempCredential{}, err
+	}
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO webdav_temp_credentials(user_id, username, password_salt, password_hash, read_only, scope_dir_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, username, hex.EncodeToString(salt), hashWebDAVPassword(password, salt), readOnly, scope, expiresAt.UTC(), now())
+	if err != nil {
+		return WebDAVTempCredential{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return WebDAVTempCredential{}, err
+	}
+	var c WebDAVTempCredential
+	row := s.DB.QueryRowContext(ctx, `SELECT `+webdavTempColumns+` FROM webdav_temp_credentials WHERE id = ?`, id)
+	if err := scanWebDAVTemp(row, &c); err != nil {
+		return WebDAVTempCredential{}, err
+	}
+	detail := "expires " + c.ExpiresAt.UTC().Format(time.RFC3339)
+	if readOnly {
+		detail += ", read-only"
+	}
+	s.audit(ctx, userID, AuditAuthTempLogin, id, username, detail)
+	return c, nil
+}
+
+// VerifyWebDAVTempCredential checks a temporary login. It reports false for
+// unknown, wrong or expired credentials.
+func (s *Store) VerifyWebDAVTempCredential(ctx context.Context, username, password string) (WebDAVTempCredential, bool, error) {
+	if password == "" {
+		return WebDAVTempCredential{}, false, nil
+	}
+	var c WebDAVTempCredential
+	var saltHex, hash string
+	row := s.DB.QueryRowContext(ctx, `SELECT `+webdavTempColumns+`, password_salt, password_hash FROM webdav_temp_credentials WHERE username = ?`, username)
+	if err := row.Scan(&c.ID, &c.UserID, &c.Username, &c.ReadOnly, &c.ScopeDirID, &c.ExpiresAt, &c.CreatedAt, &saltHex, &hash); err != nil {
+		if err == sql.ErrNoRows {
+			return WebDAVTempCredential{}, false, nil
+		}
+		return WebDAVTempCredential{}, false, err
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return WebDAVTempCredential{}, false, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashWebDAVPassword(password, salt)), []byte(hash)) != 1 {
+		return WebDAVTempCredential{}, false, nil
+	}
+	if !time.Now().UTC().Before(c.ExpiresAt) {
+		return WebDAVTempCredential{}, false, nil
+	}
+	return c, true, nil
+}
+
+// ListWebDAVTempCredentials lists a user's unexpired temporary logins.
+func (s *Store) ListWebDAVTempCredentials(ctx context.Context, userID int64) ([]WebDAVTempCredential, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+webdavTempColumns+` FROM webdav_temp_credentials WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []WebDAVTempCredential
+	current := time.Now().UTC()
+	for rows.Next() {
+		var c WebDAVTempCredential
+		if err := scanWebDAVTemp(rows, &c); err != nil {
+			return nil, err
+		}
+		if current.Before(c.ExpiresAt) {
+			out = append(out, c)
+		}
+	}
+	return out, rows.Err()
+}
+
+// DeleteWebDAVTempCredential revokes one of a user's temporary logins.
+func (s *Store) DeleteWebDAVTempCredential(ctx context.Context, userID int64, username string) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM webdav_temp_credentials WHERE user_id = ? AND username = ?`, userID, username)
+	if err != nil {
+		return err
+	}
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	s.audit(ctx, userID, AuditAuthTempDrop, 0, username, "")
+	return nil
+}
+
+// DeleteExpiredWebDAVTempCredentials drops temporary logins past their expiry.
+func (s *Store) DeleteExpiredWebDAVTempCredentials(ctx context.Context) error {
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, expires_at FROM webdav_temp_credentials`)
+	if err != nil {
+		return err
+	}
+	current := time.Now().UTC()
+	var expired []int64
+	for rows.Next() {
+		var id int64
+		var exp time.Time
+		if err := rows.Scan(&id, &exp); err != nil {
+			rows.Close()
+			return err
+		}
+		if !current.Before(exp) {
+			expired = append(expired, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range expired {
+		if _, err := s.DB.ExecContext(ctx, `DELETE FROM webdav_temp_credentials WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
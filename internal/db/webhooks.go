@@ -0,0 +1,258 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// WebhookEvents are the outbox events a webhook can ask for.
+var WebhookEvents = []string{EventFileCreated, EventFileDeleted, EventFileRenamed, EventShareAccessed, EventQuotaExceeded}
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL that outbox events are posted to, signed with Secret.
+type Webhook struct {
+	ID     int64
+	UserID int64
+	URL    string
+	Secret string
+	// Events lists the events delivered; empty delivers every event.
+	Events []string
+	// AllUsers delivers every user's events rather than the owner's. Only
+	// admins create such webhooks.
+	AllUsers  bool
+	CreatedAt time.Time
+}
+
+// Wants reports whether the webhook takes event.
+func (w Webhook) Wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event bound for one webhook, and the outcome of its
+// last attempt. Body is sent as is on every attempt, so retries carry the
+// same signature.
+type WebhookDelivery struct {
+	ID            int64
+	WebhookID     int64
+	EventID       int64
+	Event         string
+	Body          string
+	Status        string
+	Attempts      int
+	StatusCode    int
+	Error         string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	FinishedAt    sql.NullTime
+	// URL and Secret are the webhook's, filled by DueWebhookDeliveries.
+	URL    string
+	Secret string
+}
+
+const webhookColumns = `id, user_id, url, secret, events, all_users, created_at`
+
+func scanWebhook(row rowScanner, w *Webhook) error {
+	var events string
+	if err := row.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.AllUsers, &w.CreatedAt); err != nil {
+		return err
+	}
+	w.Events = nil
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return nil
+}
+
+func (s *Store) listWebhooks(ctx context.Context, query string, args ...any) ([]Webhook, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks`+query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Webhook
+	for rows.Next() {
+		var w Webhook
+		if err := scanWebhook(rows, &w); err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// CreateWebhook registers rawURL, an http or https URL, for userID's events,
+// or with allUsers everyone's. Empty events delivers every event.
+func (s *Store) CreateWebhook(ctx context.Context, userID int64, rawURL, secret string, events []string, allUsers bool) (Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("webhook URL must be an http or https URL: %w", os.ErrInvalid)
+	}
+	if len(secret) < 16 {
+		return Webhook{}, fmt.Errorf("webhook secret must be at least 16 characters: %w", os.ErrInvalid)
+	}
+	for _, e := range events {
+		known := false
+		for _, k := range WebhookEvents {
+			known = known || e == k
+		}
+		if !known {
+			return Webhook{}, fmt.Errorf("unknown webhook event %q: %w", e, os.ErrInvalid)
+		}
+	}
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO webhooks(user_id, url, secret, events, all_users, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, u.String(), secret, strings.Join(events, ","), allUsers, now())
+	if err != nil {
+		return Webhook{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Webhook{}, err
+	}
+	var w Webhook
+	if err := scanWebhook(s.DB.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id), &w); err != nil {
+		return Webhook{}, err
+	}
+	detail := "all events"
+	if len(events) > 0 {
+		detail = strings.Join(events, ", ")
+	}
+	if allUsers {
+		detail += ", all users"
+	}
+	s.audit(ctx, userID, AuditWebhookCreate, id, w.URL, detail)
+	return w, nil
+}
+
+// ListWebhooks lists a user's webhooks, oldest first.
+func (s *Store) ListWebhooks(ctx context.Context, userID int64) ([]Webhook, error) {
+	return s.listWebhooks(ctx, ` WHERE user_id = ?`, userID)
+}
+
+// ListAllWebhooks lists every user's webhooks, oldest first.
+func (s *Store) ListAllWebhooks(ctx context.Context) ([]Webhook, error) {
+	return s.listWebhooks(ctx, ``)
+}
+
+// DeleteWebhook deletes one of a user's webhooks and its delivery log.
+func (s *Store) DeleteWebhook(ctx context.Context, userID, webhookID int64) error {
+	var rawURL string
+	if err := s.DB.QueryRowContext(ctx, `SELECT url FROM webhooks WHERE id = ? AND user_id = ?`, webhookID, userID).Scan(&rawURL); err != nil {
+		return err
+	}
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ? AND user_id = ?`, webhookID, userID)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	s.audit(ctx, userID, AuditWebhookDelete, webhookID, rawURL, "")
+	return nil
+}
+
+// EnqueueWebhookDeliveries queues deliveries, due at once, and advances
+// consumer's outbox cursor to lastID in the same transaction, so an event
+// is queued exactly once.
+func (s *Store) EnqueueWebhookDeliveries(ctx context.Context, consumer string, lastID int64, deliveries []WebhookDelivery) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	current := now()
+	for _, d := range deliveries {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO webhook_deliveries(webhook_id, event_id, event, body, status, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			d.WebhookID, d.EventID, d.Event, d.Body, DeliveryPending, current, current); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO outbox_cursors(consumer, last_id, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(consumer) DO UPDATE SET last_id = MAX(last_id, excluded.last_id), updated_at = excluded.updated_at`, consumer, lastID, current); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const webhookDeliveryColumns = `d.id, d.webhook_id, d.event_id, d.event, d.body, d.status, d.attempts, d.status_code, d.error, d.next_attempt_at, d.created_at, d.finished_at`
+
+func scanWebhookDeliveries(rows *sql.Rows, withHook bool) ([]WebhookDelivery, error) {
+	defer rows.Close()
+	var out []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		dest := []any{&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Body, &d.Status, &d.Attempts, &d.StatusCode, &d.Error, &d.NextAttemptAt, &d.CreatedAt, &d.FinishedAt}
+		if withHook {
+			dest = append(dest, &d.URL, &d.Secret)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries whose next
+// attempt is due, with their webhook's URL and secret.
+func (s *Store) DueWebhookDeliveries(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+webhookDeliveryColumns+`, w.url, w.secret
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = ? AND d.next_attempt_at <= ? ORDER BY d.next_attempt_at, d.id LIMIT ?`, DeliveryPending, now(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows, true)
+}
+
+// RecordWebhookAttempt records an attempt at a delivery: status is
+// DeliveryDelivered or DeliveryFailed when it is done, or DeliveryPending
+// with the time of the next attempt.
+func (s *Store) RecordWebhookAttempt(ctx context.Context, deliveryID int64, status string, statusCode int, errText string, nextAttemptAt time.Time) error {
+	var finished any
+	if status != DeliveryPending {
+		finished = now()
+	}
+	_, err := s.DB.ExecContext(ctx, `UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, status_code = ?, error = ?, next_attempt_at = ?, finished_at = ? WHERE id = ?`,
+		status, statusCode, errText, nextAttemptAt.UTC(), finished, deliveryID)
+	return err
+}
+
+// ListWebhookDeliveries returns the last limit deliveries to one of a
+// user's webhooks, newest first.
+func (s *Store) ListWebhookDeliveries(ctx context.Context, userID, webhookID int64, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.webhook_id = ? AND w.user_id = ? ORDER BY d.id DESC LIMIT ?`, webhookID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows, false)
+}
+
+// PruneWebhookDeliveries drops finished deliveries older than olderThan.
+func (s *Store) PruneWebhookDeliveries(ctx context.Context, olderThan time.Duration) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE status != ? AND created_at < ?`, DeliveryPending, now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,182 @@
+package gateway
+
+import (
+	"archive/zip"
+	"bufio"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigpak/internal/apierror"
+	"pigpak/internal/auth"
+	"pigpak/internal/db"
+	"pigpak/internal/webdav"
+)
+
+// maxArchiveItems bounds the folders and files of one archive, which are
+// listed up front.
+const maxArchiveItems = 20000
+
+// Archives announce what they will hold before the body, and report what
+// they did hold in trailers, so clients can show progress and tell a
+// complete archive from one that skipped files Telegram would not serve.
+const (
+	headerArchiveFiles   = "X-Pigpak-Archive-Files"
+	headerArchiveBytes   = "X-Pigpak-Archive-Bytes"
+	trailerWrittenFiles  = "X-Pigpak-Archive-Written-Files"
+	trailerWrittenBytes  = "X-Pigpak-Archive-Written-Bytes"
+	trailerSkippedFiles  = "X-Pigpak-Archive-Skipped-Files"
+	archiveTrailerFields = trailerWrittenFiles + ", " + trailerWrittenBytes + ", " + trailerSkippedFiles
+)
+
+// ArchivePath returns the signed path for downloading dir as a ZIP
+// archive. The name segment is cosmetic and not covered by the signature.
+func ArchivePath(signer *auth.Signer, dir db.Directory, expires time.Time) string {
+	resource := "/zip/" + dir.PublicID
+	return resource + "/" + url.PathEscape(archiveName(dir)+".zip") + "?" + signer.Sign(resource, expires).Encode()
+}
+
+// archiveName is the name of dir's archive, and of the folder everything
+// in it is unpacked to.
+func archiveName(dir db.Directory) string {
+	if !dir.ParentID.Valid {
+		return "pigpak"
+	}
+	return dir.Name
+}
+
+func (h *Handler) serveSignedArchive(w http.ResponseWriter, r *http.Request) {
+	publicID, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/zip/"), "/")
+	if publicID == "" {
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "not found", nil)
+		return
+	}
+	if err := h.Signer.Verify("/zip/"+publicID, r.URL.Query(), time.Now()); err != nil {
+		if errors.Is(err, auth.ErrExpired) {
+			apierror.Write(w, r, http.StatusGone, apierror.CodeLinkExpired, "link expired", nil)
+			return
+		}
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeInvalidLink, "invalid link", nil)
+		return
+	}
+	dir, err := h.Store.FindDirByPublicID(r.Context(), publicID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("gateway find folder: %v", err)
+		}
+		apierror.WriteError(w, r, err)
+		return
+	}
+	h.serveArchive(w, r, dir, nil)
+}
+
+// serveArchive streams dir and everything below it as a ZIP archive,
+// reading one file at a time from Telegram; nothing is buffered beyond
+// the part being copied. Files are stored rather than deflated: most of
+// what people keep in Telegram is compressed already. count, when not
+// nil, is called once the archive starts.
+//
+// A file whose first part cannot be downloaded is left out and counted in
+// the skipped trailer. An error later on has already sent part of the
+// file, so the connection is aborted and the client sees a truncated
+// archive rather than a corrupt one that looks complete.
+func (h *Handler) serveArchive(w http.ResponseWriter, r *http.Request, dir db.Directory, count func() error) {
+	ctx := r.Context()
+	tree, err := h.Store.ListTree(ctx, dir.UserID, dir.ID, maxArchiveItems, 0)
+	if errors.Is(err, db.ErrTreeTooLarge) {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.CodeBadRequest, "folder has too many items for an archive", map[string]int{"max_items": maxArchiveItems})
+		return
+	}
+	if err != nil {
+		log.Printf("gateway list folder %d for archive: %v", dir.ID, err)
+		apierror.WriteError(w, r, err)
+		return
+	}
+	root := archiveName(dir)
+	dirPaths := map[int64]string{dir.ID: root + "/"}
+	for _, d := range tree.Dirs {
+		dirPaths[d.ID] = dirPaths[d.ParentID.Int64] + d.Name + "/"
+	}
+	var total int64
+	for _, f := range tree.Files {
+		total += f.Size
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": root + ".zip"}))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set(headerArchiveFiles, strconv.Itoa(len(tree.Files)))
+	w.Header().Set(headerArchiveBytes, strconv.FormatInt(total, 10))
+	w.Header().Set("Trailer", archiveTrailerFields)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if count != nil {
+		if err := count(); err != nil {
+			log.Printf("gateway count archive of folder %d: %v", dir.ID, err)
+		}
+	}
+
+	zw := zip.NewWriter(w)
+	// Folders get entries of their own so that empty ones survive.
+	for _, d := range tree.Dirs {
+		if _, err := zw.CreateHeader(&zip.FileHeader{Name: dirPaths[d.ID], Modified: d.UpdatedAt}); err != nil {
+			return
+		}
+	}
+	var written, skipped int
+	var writtenBytes int64
+	for _, f := range tree.Files {
+		n, ok, err := h.archiveFile(r, zw, dirPaths[f.DirID]+f.Name, f)
+		if err != nil {
+			log.Printf("gateway archive folder %d: file %d: %v", dir.ID, f.ID, err)
+			panic(http.ErrAbortHandler)
+		}
+		if !ok {
+			skipped++
+			continue
+		}
+		written++
+		writtenBytes += n
+	}
+	if err := zw.Close(); err != nil {
+		return
+	}
+	w.Header().Set(trailerWrittenFiles, strconv.Itoa(written))
+	w.Header().Set(trailerWrittenBytes, strconv.FormatInt(writtenBytes, 10))
+	w.Header().Set(trailerSkippedFiles, strconv.Itoa(skipped))
+}
+
+// archiveFile adds file to zw as name. It reports false, without an
+// entry, when the file's download fails before any of it is read.
+func (h *Handler) archiveFile(r *http.Request, zw *zip.Writer, name string, file db.File) (int64, bool, error) {
+	header := &zip.FileHeader{Name: name, Method: zip.Store, Modified: file.ModifiedAt}
+	if file.IsEmpty() {
+		_, err := zw.CreateHeader(header)
+		return 0, true, err
+	}
+	reader, err := webdav.OpenFileReader(r.Context(), h.Telegram, h.Store, h.Cache, file)
+	if err != nil {
+		log.Printf("gateway archive: open file %d: %v", file.ID, err)
+		return 0, false, nil
+	}
+	defer reader.Close()
+	buffered := bufio.NewReaderSize(reader, 64<<10)
+	if _, err := buffered.Peek(1); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("gateway archive: read file %d: %v", file.ID, err)
+		return 0, false, nil
+	}
+	entry, err := zw.CreateHeader(header)
+	if err != nil {
+		return 0, false, err
+	}
+	n, err := io.Copy(entry, buffered)
+	return n, true, err
+}
@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"pigpak/internal/apierror"
+	"pigpak/internal/db"
+)
+
+// FolderSharePath returns the public path of a folder share's gallery.
+func FolderSharePath(share db.FolderShare) string {
+	return "/s/" + share.Token + "/"
+}
+
+// gallery is a folder of a folder share. Links are relative to the page,
+// whose path ends in a slash, and start with "./" so a colon in a name is
+// not read as a URL scheme.
+type gallery struct {
+	Title   string
+	Parent  bool
+	Folders []galleryEntry
+	Files   []galleryEntry
+}
+
+type galleryEntry struct {
+	Name string
+	Href string
+	Size string
+	// Thumb is the thumbnail's link, or "" when the file has none.
+	Thumb string
+}
+
+var galleryTemplate = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:64rem;margin:2rem auto;padding:0 1rem;color:#222}
+a{color:inherit}
+ul.folders{list-style:none;padding:0;display:flex;flex-wrap:wrap;gap:.5rem}
+ul.folders a{display:block;padding:.4rem .8rem;border:1px solid #ddd;border-radius:.4rem;text-decoration:none}
+ul.files{list-style:none;padding:0;display:grid;grid-template-columns:repeat(auto-fill,minmax(10rem,1fr));gap:1rem}
+ul.files a{display:block;text-decoration:none}
+ul.files .thumb{height:8rem;display:flex;align-items:center;justify-content:center;background:#f4f4f4;border-radius:.4rem;overflow:hidden;color:#999;font-size:.85rem}
+ul.files img{max-width:100%;max-height:100%}
+ul.files .name{margin-top:.3rem;font-size:.9rem;word-break:break-word}
+ul.files .size{color:#777;font-size:.8rem}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if or .Folders .Files}}<p><a href="./?zip">Download all as ZIP</a></p>
+{{end}}{{if or .Parent .Folders}}<ul class="folders">
+{{if .Parent}}<li><a href="../">&larr; Up</a></li>
+{{end}}{{range .Folders}}<li><a href="{{.Href}}">{{.Name}}/</a></li>
+{{end}}</ul>{{end}}
+{{if .Files}}<ul class="files">
+{{range .Files}}<li><a href="{{.Href}}"><div class="thumb">{{if .Thumb}}<img src="{{.Thumb}}" alt="" loading="lazy">{{else}}file{{end}}</div><div class="name">{{.Name}}</div><div class="size">{{.Size}}</div></a></li>
+{{end}}</ul>{{else if not .Folders}}<p>This folder is empty.</p>{{end}}
+</body>
+</html>
+`))
+
+// serveFolderShare serves a folder share: rest names a folder below the
+// shared one, listed as a gallery or with ?zip downloaded as an archive, or
+// a file, downloaded, or with ?thumb its thumbnail.
+func (h *Handler) serveFolderShare(w http.ResponseWriter, r *http.Request, token, rest string) {
+	ctx := r.Context()
+	share, root, err := h.Store.GetFolderShareByToken(ctx, token)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("gateway load folder share: %v", err)
+		}
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if share.Expired() {
+		apierror.Write(w, r, http.StatusGone, apierror.CodeLinkExpired, "share expired", nil)
+		return
+	}
+	if share.HasPassword() && !checkSharePassword(w, r, share.CheckPassword) {
+		return
+	}
+	dir, file, err := h.resolveShared(r, root, rest)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("gateway resolve folder share %d: %v", share.ID, err)
+		}
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if file != nil {
+		if _, ok := r.URL.Query()["thumb"]; ok {
+			h.serveSharedThumbnail(w, r, *file)
+			return
+		}
+		h.serveSharedFile(w, r, *file, func() error { return h.Store.IncrementFolderShareUses(ctx, share.ID) })
+		return
+	}
+	if _, ok := r.URL.Query()["zip"]; ok {
+		h.serveArchive(w, r, dir, func() error { return h.Store.IncrementFolderShareUses(ctx, share.ID) })
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	page, err := h.renderGallery(r, root, dir)
+	if err != nil {
+		log.Printf("gateway render folder share %d: %v", share.ID, err)
+		apierror.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src 'self'")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(page))
+}
+
+// resolveShared walks rest, a path below root, by name. It returns the
+// folder it names, or the file.
+func (h *Handler) resolveShared(r *http.Request, root db.Directory, rest string) (db.Directory, *db.File, error) {
+	dir := root
+	clean := strings.Trim(path.Clean("/"+rest), "/")
+	if clean == "" {
+		return dir, nil, nil
+	}
+	parts := strings.Split(clean, "/")
+	for i, name := range parts {
+		child, err := h.Store.GetDirByName(r.Context(), root.UserID, dir.ID, name)
+		if err == nil {
+			dir = child
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) || i != len(parts)-1 {
+			return db.Directory{}, nil, err
+		}
+		file, err := h.Store.GetFileByName(r.Context(), root.UserID, dir.ID, name)
+		if err != nil {
+			return db.Directory{}, nil, err
+		}
+		return dir, &file, nil
+	}
+	return dir, nil, nil
+}
+
+func (h *Handler) renderGallery(r *http.Request, root, dir db.Directory) ([]byte, error) {
+	ctx := r.Context()
+	dirs, err := h.Store.ListDirs(ctx, root.UserID, dir.ID)
+	if err != nil {
+		return nil, err
+	}
+	files, err := h.Store.ListFiles(ctx, root.UserID, dir.ID)
+	if err != nil {
+		return nil, err
+	}
+	thumbs, err := h.Store.ThumbnailFileIDs(ctx, root.UserID)
+	if err != nil {
+		return nil, err
+	}
+	page := gallery{Title: dir.Name, Parent: dir.ID != root.ID}
+	if !dir.ParentID.Valid {
+		page.Title = "Shared folder"
+	}
+	for _, d := range dirs {
+		page.Folders = append(page.Folders, galleryEntry{Name: d.Name, Href: "./" + url.PathEscape(d.Name) + "/"})
+	}
+	for _, f := range files {
+		entry := galleryEntry{Name: f.Name, Href: "./" + url.PathEscape(f.Name), Size: formatSize(f.Size)}
+		if thumbs[f.ID] {
+			entry.Thumb = entry.Href + "?thumb"
+		}
+		page.Files = append(page.Files, entry)
+	}
+	var buf bytes.Buffer
+	if err := galleryTemplate.Execute(&buf, page); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (h *Handler) serveSharedThumbnail(w http.ResponseWriter, r *http.Request, file db.File) {
+	data, err := h.Store.GetThumbnail(r.Context(), file.UserID, file.ID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("gateway load thumbnail of file %d: %v", file.ID, err)
+		}
+		apierror.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", file.ModifiedAt, bytes.NewReader(data))
+}
+
+// formatSize renders a byte count the way the bot does.
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, unit := float64(size), "B"
+	for _, u := range []string{"KB", "MB", "GB", "TB"} {
+		value, unit = value/1024, u
+		if value < 1024 {
+			break
+		}
+	}
+	return fmt.Sprintf("%.1f %s", value, unit)
+}
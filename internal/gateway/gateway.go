@@ -0,0 +1,166 @@
+// Package gateway serves public, unauthenticated HTTP routes next to WebDAV:
+// published folder pages, signed download links, thumbnails, share
+// downloads, media streams and folder archives.
+package gateway
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"pigpak/internal/apierror"
+	"pigpak/internal/auth"
+	"pigpak/internal/db"
+	"pigpak/internal/partcache"
+	"pigpak/internal/telegram"
+	"pigpak/internal/webdav"
+)
+
+// Handler serves:
+//
+//	GET /dl/<file public id>/<name>?exp=&sig=  signed file download
+//	GET /thumb/<file public id>?exp=&sig=      signed file thumbnail
+//	GET /p/<token>                            published folder page
+//	GET /s/<token>/<name>                     share download
+//	GET /stream/<file public id>/<name>?exp=&sig=
+//	GET /stream/<token>/<name>                video and audio playback
+//	GET /zip/<folder public id>/<name>.zip?exp=&sig=
+//	GET /s/<token>/<folder>/?zip              folder downloads as ZIP archives
+type Handler struct {
+	Store    *db.Store
+	Telegram telegram.BotAPI
+	Signer   *auth.Signer
+	// Cache is the part cache downloads read through; nil reads straight
+	// from Telegram.
+	Cache *partcache.Cache
+}
+
+// DownloadPath returns the signed path for downloading file. The file name
+// segment is cosmetic and not covered by the signature.
+func DownloadPath(signer *auth.Signer, file db.File, expires time.Time) string {
+	resource := "/dl/" + file.PublicID
+	return resource + "/" + url.PathEscape(file.Name) + "?" + signer.Sign(resource, expires).Encode()
+}
+
+// ThumbnailPath returns the signed path of file's thumbnail.
+func ThumbnailPath(signer *auth.Signer, file db.File, expires time.Time) string {
+	resource := "/thumb/" + file.PublicID
+	return resource + "?" + signer.Sign(resource, expires).Encode()
+}
+
+// PagePath returns the public path of a published page.
+func PagePath(token string) string {
+	return "/p/" + token
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		apierror.Write(w, r, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/dl/"):
+		h.serveDownload(w, r)
+	case strings.HasPrefix(r.URL.Path, "/thumb/"):
+		h.serveThumbnail(w, r)
+	case strings.HasPrefix(r.URL.Path, "/p/"):
+		h.servePage(w, r)
+	case strings.HasPrefix(r.URL.Path, "/s/"):
+		h.serveShare(w, r)
+	case strings.HasPrefix(r.URL.Path, "/stream/"):
+		h.serveStream(w, r)
+	case strings.HasPrefix(r.URL.Path, "/zip/"):
+		h.serveSignedArchive(w, r)
+	default:
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "not found", nil)
+	}
+}
+
+func (h *Handler) serveDownload(w http.ResponseWriter, r *http.Request) {
+	publicID, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/dl/"), "/")
+	file, ok := h.signedFile(w, r, "/dl/", publicID)
+	if !ok {
+		return
+	}
+	reader, err := webdav.OpenFileReader(r.Context(), h.Telegram, h.Store, h.Cache, file)
+	if err != nil {
+		log.Printf("gateway open file %d: %v", file.ID, err)
+		apierror.WriteError(w, r, err)
+		return
+	}
+	defer reader.Close()
+	if file.MimeType != "" {
+		w.Header().Set("Content-Type", file.MimeType)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	http.ServeContent(w, r, path.Base(file.Name), file.CreatedAt, reader)
+}
+
+func (h *Handler) serveThumbnail(w http.ResponseWriter, r *http.Request) {
+	file, ok := h.signedFile(w, r, "/thumb/", strings.TrimPrefix(r.URL.Path, "/thumb/"))
+	if !ok {
+		return
+	}
+	data, err := h.Store.GetThumbnail(r.Context(), file.UserID, file.ID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("gateway load thumbnail of file %d: %v", file.ID, err)
+		}
+		apierror.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", file.CreatedAt, bytes.NewReader(data))
+}
+
+// signedFile checks the signature of a link below prefix and loads the file
+// it names, writing the error response when either fails.
+func (h *Handler) signedFile(w http.ResponseWriter, r *http.Request, prefix, publicID string) (db.File, bool) {
+	if publicID == "" {
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "not found", nil)
+		return db.File{}, false
+	}
+	if err := h.Signer.Verify(prefix+publicID, r.URL.Query(), time.Now()); err != nil {
+		if errors.Is(err, auth.ErrExpired) {
+			apierror.Write(w, r, http.StatusGone, apierror.CodeLinkExpired, "link expired", nil)
+			return db.File{}, false
+		}
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeInvalidLink, "invalid link", nil)
+		return db.File{}, false
+	}
+	file, err := h.Store.FindFile(r.Context(), publicID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("gateway find file: %v", err)
+		}
+		apierror.WriteError(w, r, err)
+		return db.File{}, false
+	}
+	return file, true
+}
+
+func (h *Handler) servePage(w http.ResponseWriter, r *http.Request) {
+	token := strings.Trim(strings.TrimPrefix(r.URL.Path, "/p/"), "/")
+	page, err := h.Store.GetPageByToken(r.Context(), token)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("gateway load page: %v", err)
+		}
+		apierror.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src 'self'")
+	w.Header().Set("Last-Modified", page.UpdatedAt.UTC().Format(http.TimeFormat))
+	_, _ = fmt.Fprint(w, page.HTML)
+}
@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"bytes"
+	"html/template"
+	"time"
+)
+
+// Index is the content of a published folder page.
+type Index struct {
+	Title       string
+	GeneratedAt time.Time
+	Entries     []IndexEntry
+}
+
+// IndexEntry is one downloadable file on a published page.
+type IndexEntry struct {
+	Path string
+	Size string
+	URL  string
+	// Thumb is the path of the file's thumbnail, or "" when it has none.
+	Thumb string
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:48rem;margin:2rem auto;padding:0 1rem;color:#222}
+table{width:100%;border-collapse:collapse}
+td{padding:.4rem .2rem;border-bottom:1px solid #eee}
+td.size{text-align:right;white-space:nowrap;color:#666}
+td.thumb{width:4rem}
+td.thumb img{display:block;max-width:4rem;max-height:4rem}
+footer{margin-top:2rem;color:#888;font-size:.85rem}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Entries}}<table>
+{{range .Entries}}<tr><td class="thumb">{{if .Thumb}}<img src="{{.Thumb}}" alt="" loading="lazy">{{end}}</td><td><a href="{{.URL}}">{{.Path}}</a></td><td class="size">{{.Size}}</td></tr>
+{{end}}</table>{{else}}<p>This folder is empty.</p>{{end}}
+<footer>Generated {{.GeneratedAt.Format "2006-01-02 15:04 MST"}} by pigpak</footer>
+</body>
+</html>
+`))
+
+// RenderIndex renders a standalone HTML page listing the entries.
+func RenderIndex(index Index) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := indexTemplate.Execute(&buf, index); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"pigpak/internal/apierror"
+	"pigpak/internal/db"
+	"pigpak/internal/webdav"
+)
+
+// SharePath returns the public path of a share's HTTP download. The file
+// name segment is cosmetic.
+func SharePath(share db.Share, file db.File) string {
+	return "/s/" + share.Token + "/" + url.PathEscape(file.Name)
+}
+
+// serveShare streams a shared file, or serves a folder share's gallery.
+// Shares with a password take it as the password of HTTP Basic
+// authentication, with any user name, so browsers prompt for it and
+// command-line clients can pass it along. Shares meant for one Telegram
+// user are opened in the bot only.
+func (h *Handler) serveShare(w http.ResponseWriter, r *http.Request) {
+	token, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/s/"), "/")
+	if token == "" {
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "not found", nil)
+		return
+	}
+	share, file, err := h.Store.GetShareByToken(r.Context(), token)
+	if errors.Is(err, sql.ErrNoRows) {
+		h.serveFolderShare(w, r, token, rest)
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("gateway load share: %v", err)
+		}
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if err := db.ValidateShare(share); err != nil {
+		apierror.Write(w, r, http.StatusGone, apierror.CodeLinkExpired, "share expired", nil)
+		return
+	}
+	if share.Restricted() {
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden, "this share can only be opened in Telegram", nil)
+		return
+	}
+	if share.HasPassword() && !checkSharePassword(w, r, share.CheckPassword) {
+		return
+	}
+	h.serveSharedFile(w, r, file, func() error { return h.Store.IncrementShareUses(r.Context(), share.ID) })
+}
+
+// checkSharePassword answers 401 unless the request carries the share's
+// password.
+func checkSharePassword(w http.ResponseWriter, r *http.Request, check func(string) bool) bool {
+	_, password, _ := r.BasicAuth()
+	if check(password) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="share", charset="UTF-8"`)
+	apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "password required", nil)
+	return false
+}
+
+// serveSharedFile streams file as an attachment, calling count for every
+// download it starts.
+func (h *Handler) serveSharedFile(w http.ResponseWriter, r *http.Request, file db.File, count func() error) {
+	reader, err := webdav.OpenFileReader(r.Context(), h.Telegram, h.Store, h.Cache, file)
+	if err != nil {
+		log.Printf("gateway open shared file %d: %v", file.ID, err)
+		apierror.WriteError(w, r, err)
+		return
+	}
+	defer reader.Close()
+	// Count downloads, not the range requests that resume or seek them.
+	if rng := r.Header.Get("Range"); r.Method == http.MethodGet && (rng == "" || strings.HasPrefix(rng, "bytes=0-")) {
+		if err := count(); err != nil {
+			log.Printf("gateway count share download of file %d: %v", file.ID, err)
+		}
+	}
+	if file.MimeType != "" {
+		w.Header().Set("Content-Type", file.MimeType)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	w.Header().Set("ETag", file.ETag())
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, path.Base(file.Name), file.ModifiedAt, reader)
+}
@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"pigpak/internal/apierror"
+	"pigpak/internal/auth"
+	"pigpak/internal/db"
+	"pigpak/internal/webdav"
+)
+
+// StreamPath returns the signed path for playing file in a media player.
+// Players keep requesting ranges for as long as they play, so expires
+// should leave room for the whole film.
+func StreamPath(signer *auth.Signer, file db.File, expires time.Time) string {
+	resource := "/stream/" + file.PublicID
+	return resource + "/" + url.PathEscape(file.Name) + "?" + signer.Sign(resource, expires).Encode()
+}
+
+// ShareStreamPath returns the public path for playing a shared file. Like
+// the share's download, it asks for the share's password, if it has one.
+func ShareStreamPath(share db.Share, file db.File) string {
+	return "/stream/" + share.Token + "/" + url.PathEscape(file.Name)
+}
+
+// Streamable reports whether file is worth offering a stream link for.
+func Streamable(file db.File) bool {
+	kind, _, _ := strings.Cut(streamType(file), "/")
+	return kind == "video" || kind == "audio"
+}
+
+// serveStream plays a file inline: /stream/<public id> with a signature,
+// as from StreamPath, or /stream/<share token>, as from ShareStreamPath.
+// The name segment after either is cosmetic.
+func (h *Handler) serveStream(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/stream/"), "/")
+	if r.URL.Query().Has("sig") {
+		file, ok := h.signedFile(w, r, "/stream/", id)
+		if !ok {
+			return
+		}
+		h.streamFile(w, r, file, nil)
+		return
+	}
+	if id == "" {
+		apierror.Write(w, r, http.StatusNotFound, apierror.CodeNotFound, "not found", nil)
+		return
+	}
+	share, file, err := h.Store.GetShareByToken(r.Context(), id)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("gateway load share: %v", err)
+		}
+		apierror.WriteError(w, r, err)
+		return
+	}
+	if err := db.ValidateShare(share); err != nil {
+		apierror.Write(w, r, http.StatusGone, apierror.CodeLinkExpired, "share expired", nil)
+		return
+	}
+	if share.Restricted() {
+		apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden, "this share can only be opened in Telegram", nil)
+		return
+	}
+	if share.HasPassword() && !checkSharePassword(w, r, share.CheckPassword) {
+		return
+	}
+	h.streamFile(w, r, file, func() error { return h.Store.IncrementShareUses(r.Context(), share.ID) })
+}
+
+// streamFile serves file inline with its media type, so browsers play it
+// instead of saving it, and answers the range requests players seek with
+// by reading only the parts they cover. count, when not nil, is called
+// once per playback: for the request from the first byte.
+func (h *Handler) streamFile(w http.ResponseWriter, r *http.Request, file db.File, count func() error) {
+	reader, err := webdav.OpenFileReader(r.Context(), h.Telegram, h.Store, h.Cache, file)
+	if err != nil {
+		log.Printf("gateway open stream of file %d: %v", file.ID, err)
+		apierror.WriteError(w, r, err)
+		return
+	}
+	defer reader.Close()
+	if rng := r.Header.Get("Range"); count != nil && r.Method == http.MethodGet && (rng == "" || strings.HasPrefix(rng, "bytes=0-")) {
+		if err := count(); err != nil {
+			log.Printf("gateway count stream of file %d: %v", file.ID, err)
+		}
+	}
+	if typ := streamType(file); typ != "" {
+		w.Header().Set("Content-Type", typ)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": file.Name}))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", file.ETag())
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, path.Base(file.Name), file.ModifiedAt, reader)
+}
+
+// streamType is file's media type: the stored one, or else the one its
+// extension names. Files are often stored as application/octet-stream,
+// which browsers will not play.
+func streamType(file db.File) string {
+	if file.MimeType != "" && file.MimeType != "application/octet-stream" {
+		return file.MimeType
+	}
+	if typ := mime.TypeByExtension(path.Ext(file.Name)); typ != "" {
+		return typ
+	}
+	return file.MimeType
+}
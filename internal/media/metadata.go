@@ -0,0 +1,569 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// HeadSize is how much of the start of a file Probe and SniffMIME look at.
+const HeadSize = 1 << 20
+
+// Metadata is what can be read from the start of a file without keeping it.
+// Zero fields are unknown.
+type Metadata struct {
+	Width    int
+	Height   int
+	Duration time.Duration
+	// TakenAt is when a photo was taken, from its EXIF data.
+	TakenAt time.Time
+	Artist  string
+	Album   string
+	Title   string
+	Pages   int
+}
+
+// IsZero reports whether nothing is known.
+func (m Metadata) IsZero() bool {
+	return m == Metadata{}
+}
+
+// Merge fills the fields m does not know from other.
+func (m Metadata) Merge(other Metadata) Metadata {
+	if m.Width == 0 && m.Height == 0 {
+		m.Width, m.Height = other.Width, other.Height
+	}
+	if m.Duration == 0 {
+		m.Duration = other.Duration
+	}
+	if m.TakenAt.IsZero() {
+		m.TakenAt = other.TakenAt
+	}
+	if m.Artist == "" {
+		m.Artist = other.Artist
+	}
+	if m.Album == "" {
+		m.Album = other.Album
+	}
+	if m.Title == "" {
+		m.Title = other.Title
+	}
+	if m.Pages == 0 {
+		m.Pages = other.Pages
+	}
+	return m
+}
+
+// genericTypes are MIME types that say little about the content; a sniffed
+// type never replaces a more specific declared one with these.
+var genericTypes = map[string]bool{
+	"":                         true,
+	"application/octet-stream": true,
+	"application/zip":          true,
+	"text/plain":               true,
+	"text/xml":                 true,
+	"text/html":                true,
+	"application/xml":          true,
+}
+
+// SniffMIME returns the MIME type of the content in head. The declared type,
+// from the client or the file extension, wins unless it is missing or
+// generic, or the content is clearly something else such as a PNG named
+// .jpg. name supplies the extension when nothing else is known.
+func SniffMIME(head []byte, declared, name string) string {
+	declared = baseType(declared)
+	if declared == "" || declared == "application/octet-stream" {
+		if byExt := baseType(mime.TypeByExtension(strings.ToLower(path.Ext(name)))); byExt != "" {
+			declared = byExt
+		}
+	}
+	sniffed := sniff(head)
+	switch {
+	case genericTypes[sniffed]:
+		if declared == "" {
+			return sniffed
+		}
+		return declared
+	case genericTypes[declared]:
+		return sniffed
+	case sniffed == declared:
+		return declared
+	}
+	// Both are specific: trust the content, apart from containers that
+	// several audio and video formats share.
+	if sharedContainers[sniffed] && (strings.HasPrefix(declared, "audio/") || strings.HasPrefix(declared, "video/")) {
+		return declared
+	}
+	return sniffed
+}
+
+// sharedContainers hold more than one audio or video format, so a declared
+// type such as video/x-matroska is more precise than the sniffed one.
+var sharedContainers = map[string]bool{
+	"video/mp4":       true,
+	"video/webm":      true,
+	"audio/mp4":       true,
+	"application/ogg": true,
+	"video/avi":       true,
+	"audio/wave":      true,
+}
+
+func sniff(head []byte) string {
+	switch {
+	case len(head) == 0:
+		return ""
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return "audio/mpeg"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "audio/flac"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		switch string(head[8:12]) {
+		case "M4A ", "M4B ":
+			return "audio/mp4"
+		case "qt  ":
+			return "video/quicktime"
+		case "heic", "heix", "mif1":
+			return "image/heic"
+		}
+		return "video/mp4"
+	}
+	return baseType(http.DetectContentType(head))
+}
+
+func baseType(t string) string {
+	t, _, _ = strings.Cut(t, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// Probe reads what it can from head, the first bytes of a file of size
+// bytes. The format is told from the content itself; unknown formats yield
+// zero Metadata.
+func Probe(head []byte, size int64) Metadata {
+	kind := sniff(head)
+	if genericTypes[kind] && isMPEGFrame(head) {
+		kind = "audio/mpeg"
+	}
+	switch kind {
+	case "image/jpeg":
+		return probeJPEG(head)
+	case "image/png":
+		if len(head) >= 24 && string(head[12:16]) == "IHDR" {
+			return Metadata{Width: int(binary.BigEndian.Uint32(head[16:20])), Height: int(binary.BigEndian.Uint32(head[20:24]))}
+		}
+	case "image/gif":
+		if len(head) >= 10 {
+			return Metadata{Width: int(binary.LittleEndian.Uint16(head[6:8])), Height: int(binary.LittleEndian.Uint16(head[8:10]))}
+		}
+	case "image/webp":
+		return probeWebP(head)
+	case "audio/mpeg":
+		return probeMP3(head, size)
+	case "audio/flac":
+		return probeFLAC(head)
+	case "audio/mp4", "video/mp4", "video/quicktime":
+		if info, ok := ProbeMP4(head); ok {
+			return Metadata{Width: info.Width, Height: info.Height, Duration: info.Duration}
+		}
+	case "application/pdf":
+		return Metadata{Pages: pdfPages(head)}
+	}
+	return Metadata{}
+}
+
+// probeJPEG walks the JPEG markers for the frame size and the EXIF date.
+func probeJPEG(data []byte) Metadata {
+	var m Metadata
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return m
+	}
+	data = data[2:]
+	for len(data) >= 4 && data[0] == 0xFF {
+		marker := data[1]
+		if marker == 0xFF {
+			data = data[1:]
+			continue
+		}
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			data = data[2:]
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if length < 2 || len(data) < 2+length {
+			break
+		}
+		segment := data[4 : 2+length]
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			m.TakenAt = exifDate(segment[6:])
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			if len(segment) >= 5 {
+				m.Height = int(binary.BigEndian.Uint16(segment[1:3]))
+				m.Width = int(binary.BigEndian.Uint16(segment[3:5]))
+			}
+			return m
+		case marker == 0xDA:
+			return m
+		}
+		data = data[2+length:]
+	}
+	return m
+}
+
+// exifDate reads DateTimeOriginal, or DateTime, from a TIFF structure.
+func exifDate(tiff []byte) time.Time {
+	if len(tiff) < 8 {
+		return time.Time{}
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}
+	}
+	entries := func(offset uint32) map[uint16][]byte {
+		out := map[uint16][]byte{}
+		if uint64(offset)+2 > uint64(len(tiff)) {
+			return out
+		}
+		count := int(order.Uint16(tiff[offset:]))
+		for i := 0; i < count; i++ {
+			at := int(offset) + 2 + i*12
+			if at+12 > len(tiff) {
+				break
+			}
+			out[order.Uint16(tiff[at:])] = tiff[at : at+12]
+		}
+		return out
+	}
+	ascii := func(entry []byte) string {
+		if entry == nil || order.Uint16(entry[2:]) != 2 {
+			return ""
+		}
+		n := order.Uint32(entry[4:])
+		value := entry[8:12]
+		if n > 4 {
+			offset := order.Uint32(entry[8:])
+			if uint64(offset)+uint64(n) > uint64(len(tiff)) {
+				return ""
+			}
+			value = tiff[offset : offset+n]
+		}
+		return strings.TrimRight(string(value), "\x00 ")
+	}
+	ifd0 := entries(order.Uint32(tiff[4:8]))
+	text := ""
+	if ptr := ifd0[0x8769]; ptr != nil {
+		text = ascii(entries(order.Uint32(ptr[8:]))[0x9003])
+	}
+	if text == "" {
+		text = ascii(ifd0[0x0132])
+	}
+	// EXIF dates carry no zone; they are local time where the photo was
+	// taken and are kept as written.
+	t, err := time.Parse("2006:01:02 15:04:05", text)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func probeWebP(data []byte) Metadata {
+	if len(data) < 30 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return Metadata{}
+	}
+	chunk := data[12:]
+	switch string(chunk[:4]) {
+	case "VP8X":
+		w := int(chunk[12]) | int(chunk[13])<<8 | int(chunk[14])<<16
+		h := int(chunk[15]) | int(chunk[16])<<8 | int(chunk[17])<<16
+		return Metadata{Width: w + 1, Height: h + 1}
+	case "VP8 ":
+		if chunk[11] == 0x9D && chunk[12] == 0x01 && chunk[13] == 0x2A {
+			return Metadata{Width: int(binary.LittleEndian.Uint16(chunk[14:16]) & 0x3FFF), Height: int(binary.LittleEndian.Uint16(chunk[16:18]) & 0x3FFF)}
+		}
+	case "VP8L":
+		if chunk[8] == 0x2F {
+			bits := binary.LittleEndian.Uint32(chunk[9:13])
+			return Metadata{Width: int(bits&0x3FFF) + 1, Height: int(bits>>14&0x3FFF) + 1}
+		}
+	}
+	return Metadata{}
+}
+
+// id3Size returns the length of a leading ID3v2 tag, header included.
+func id3Size(data []byte) int {
+	if len(data) < 10 || !bytes.HasPrefix(data, []byte("ID3")) {
+		return 0
+	}
+	size := synchsafe(data[6:10]) + 10
+	if data[5]&0x10 != 0 {
+		size += 10
+	}
+	return size
+}
+
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+func probeMP3(data []byte, size int64) Metadata {
+	var m Metadata
+	tagSize := id3Size(data)
+	if tagSize > 0 {
+		m = id3Frames(data[10:min(tagSize, len(data))], data[3])
+	}
+	if tagSize < len(data) {
+		m.Duration = mp3Duration(data[tagSize:], size-int64(tagSize))
+	}
+	return m
+}
+
+// id3Frames reads the title, artist and album text frames of an ID3v2.3
+// or v2.4 tag body.
+func id3Frames(body []byte, version byte) Metadata {
+	var m Metadata
+	if version != 3 && version != 4 {
+		return m
+	}
+	for len(body) >= 10 && body[0] != 0 {
+		id := string(body[:4])
+		size := int(binary.BigEndian.Uint32(body[4:8]))
+		if version == 4 {
+			size = synchsafe(body[4:8])
+		}
+		if size <= 0 || 10+size > len(body) {
+			break
+		}
+		value := body[10 : 10+size]
+		switch id {
+		case "TIT2":
+			m.Title = id3Text(value)
+		case "TPE1":
+			m.Artist = id3Text(value)
+		case "TALB":
+			m.Album = id3Text(value)
+		}
+		body = body[10+size:]
+	}
+	return m
+}
+
+func id3Text(value []byte) string {
+	if len(value) < 2 {
+		return ""
+	}
+	text := value[1:]
+	var s string
+	switch value[0] {
+	case 1, 2:
+		order := binary.ByteOrder(binary.BigEndian)
+		if value[0] == 1 && len(text) >= 2 {
+			if text[0] == 0xFF && text[1] == 0xFE {
+				order = binary.LittleEndian
+			}
+			if (text[0] == 0xFF && text[1] == 0xFE) || (text[0] == 0xFE && text[1] == 0xFF) {
+				text = text[2:]
+			}
+		}
+		units := make([]uint16, 0, len(text)/2)
+		for i := 0; i+1 < len(text); i += 2 {
+			units = append(units, order.Uint16(text[i:]))
+		}
+		s = string(utf16.Decode(units))
+	case 3:
+		s = string(text)
+	default:
+		runes := make([]rune, len(text))
+		for i, c := range text {
+			runes[i] = rune(c)
+		}
+		s = string(runes)
+	}
+	s, _, _ = strings.Cut(s, "\x00")
+	return strings.TrimSpace(s)
+}
+
+var (
+	mp3Bitrates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	}
+	mp3SampleRates = [3][3]int{{44100, 48000, 32000}, {22050, 24000, 16000}, {11025, 12000, 8000}}
+)
+
+func isMPEGFrame(data []byte) bool {
+	return len(data) >= 4 && data[0] == 0xFF && data[1]&0xE6 == 0xE2 && (data[1]>>3)&0x03 != 1 && data[2]&0xF0 != 0xF0 && data[2]&0x0C != 0x0C
+}
+
+// mp3Duration reads the first Layer III frame. A Xing or Info header gives
+// the frame count; otherwise the stream is taken to be constant bitrate.
+func mp3Duration(data []byte, streamSize int64) time.Duration {
+	if !isMPEGFrame(data) {
+		return 0
+	}
+	version := (data[1] >> 3) & 0x03 // 3 = MPEG 1, 2 = MPEG 2, 0 = MPEG 2.5
+	rateIndex := int(data[2]>>2) & 0x03
+	row, samples := 0, 1152
+	switch version {
+	case 2:
+		row, samples = 1, 576
+	case 0:
+		row, samples = 2, 576
+	}
+	sampleRate := mp3SampleRates[row][rateIndex]
+	bitrate := mp3Bitrates[min(row, 1)][data[2]>>4] * 1000
+	if bitrate == 0 {
+		return 0
+	}
+	mono := data[3]>>6 == 3
+	side := 32
+	switch {
+	case version == 3 && mono:
+		side = 17
+	case version != 3 && !mono:
+		side = 17
+	case version != 3:
+		side = 9
+	}
+	if at := 4 + side; len(data) >= at+12 {
+		tag := string(data[at : at+4])
+		if (tag == "Xing" || tag == "Info") && data[at+7]&0x01 != 0 {
+			frames := float64(binary.BigEndian.Uint32(data[at+8:]))
+			return seconds(frames * float64(samples) / float64(sampleRate))
+		}
+	}
+	if streamSize <= 0 {
+		return 0
+	}
+	return seconds(float64(streamSize) * 8 / float64(bitrate))
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+}
+
+func probeFLAC(data []byte) Metadata {
+	var m Metadata
+	if !bytes.HasPrefix(data, []byte("fLaC")) {
+		return m
+	}
+	data = data[4:]
+	for len(data) >= 4 {
+		last := data[0]&0x80 != 0
+		kind := data[0] & 0x7F
+		length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		if 4+length > len(data) {
+			break
+		}
+		block := data[4 : 4+length]
+		switch kind {
+		case 0:
+			if len(block) >= 18 {
+				rate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+				total := int64(block[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+				if rate > 0 {
+					m.Duration = seconds(float64(total) / float64(rate))
+				}
+			}
+		case 4:
+			vorbisComments(block, &m)
+		}
+		if last {
+			break
+		}
+		data = data[4+length:]
+	}
+	return m
+}
+
+// vorbisComments reads TITLE, ARTIST and ALBUM from a Vorbis comment block.
+func vorbisComments(block []byte, m *Metadata) {
+	if len(block) < 8 {
+		return
+	}
+	vendor := int(binary.LittleEndian.Uint32(block))
+	if 8+vendor > len(block) {
+		return
+	}
+	block = block[4+vendor:]
+	count := int(binary.LittleEndian.Uint32(block))
+	block = block[4:]
+	for i := 0; i < count && len(block) >= 4; i++ {
+		n := int(binary.LittleEndian.Uint32(block))
+		if 4+n > len(block) {
+			return
+		}
+		key, value, _ := strings.Cut(string(block[4:4+n]), "=")
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			m.Title = value
+		case "ARTIST":
+			m.Artist = value
+		case "ALBUM":
+			m.Album = value
+		}
+		block = block[4+n:]
+	}
+}
+
+// pdfPages finds the page count in the start of a PDF: the linearization
+// dictionary of a web-optimized file, or else the largest /Count of a
+// /Pages node that happens to be near the start.
+func pdfPages(data []byte) int {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return 0
+	}
+	if at := bytes.Index(data[:min(len(data), 1024)], []byte("/Linearized")); at >= 0 {
+		dict := data[at:min(len(data), at+512)]
+		if end := bytes.Index(dict, []byte(">>")); end >= 0 {
+			dict = dict[:end]
+		}
+		if n := pdfNumberAfter(dict, "/N "); n > 0 {
+			return n
+		}
+	}
+	pages := 0
+	for rest := data; ; {
+		at := bytes.Index(rest, []byte("/Type"))
+		if at < 0 {
+			break
+		}
+		rest = rest[at+len("/Type"):]
+		if !bytes.HasPrefix(bytes.TrimLeft(rest, " \r\n\t"), []byte("/Pages")) {
+			continue
+		}
+		start := max(0, bytes.LastIndex(data[:len(data)-len(rest)], []byte("<<")))
+		end := bytes.Index(rest, []byte(">>"))
+		if end < 0 {
+			break
+		}
+		dict := data[start : len(data)-len(rest)+end]
+		if n := pdfNumberAfter(dict, "/Count"); n > pages {
+			pages = n
+		}
+	}
+	return pages
+}
+
+func pdfNumberAfter(dict []byte, key string) int {
+	at := bytes.Index(dict, []byte(key))
+	if at < 0 {
+		return 0
+	}
+	fields := strings.Fields(string(dict[at+len(key):]))
+	if len(fields) == 0 {
+		return 0
+	}
+	digits := strings.TrimRightFunc(fields[0], func(r rune) bool { return r < '0' || r > '9' })
+	n, _ := strconv.Atoi(digits)
+	return n
+}
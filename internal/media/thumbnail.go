@@ -0,0 +1,81 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+// ThumbnailEdge is the longest side of a thumbnail, the Bot API's limit for
+// video thumbnails.
+const ThumbnailEdge = 320
+
+// maxThumbnailPixels keeps a huge picture from being decoded for a preview.
+const maxThumbnailPixels = 40 << 20
+
+// ImageThumbnail scales a JPEG, PNG or GIF image held whole in data down to
+// fit ThumbnailEdge and encodes the result as a JPEG.
+func ImageThumbnail(data []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxThumbnailPixels {
+		return nil, errors.New("image too large for a thumbnail")
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, downscale(img, ThumbnailEdge), &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	if out.Len() > maxThumbnailSize {
+		return nil, errors.New("thumbnail too large")
+	}
+	return out.Bytes(), nil
+}
+
+// downscale shrinks img to fit within edge×edge by averaging the source
+// pixels that land on each target pixel. Transparency is flattened onto
+// white, as JPEG has none.
+func downscale(img image.Image, edge int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	switch {
+	case sw > edge && sw >= sh:
+		dw, dh = edge, max(1, sh*edge/sw)
+	case sh > edge:
+		dw, dh = max(1, sw*edge/sh), edge
+	}
+	// Four sums per target pixel: red, green, blue and the pixel count.
+	sums := make([]uint64, dw*dh*4)
+	for y := 0; y < sh; y++ {
+		row := y * dh / sh * dw
+		for x := 0; x < sw; x++ {
+			r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			i := (row + x*dw/sw) * 4
+			sums[i] += uint64(r + 0xffff - a)
+			sums[i+1] += uint64(g + 0xffff - a)
+			sums[i+2] += uint64(bl + 0xffff - a)
+			sums[i+3]++
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for i := 0; i < dw*dh; i++ {
+		n := sums[i*4+3]
+		if n == 0 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			dst.Pix[i*4+c] = uint8(sums[i*4+c] / n >> 8)
+		}
+		dst.Pix[i*4+3] = 0xff
+	}
+	return dst
+}
@@ -0,0 +1,158 @@
+// Package media inspects uploaded content so it can be stored in a form
+// Telegram clients preview, such as streamable videos with thumbnails.
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxThumbnailSize is the Bot API limit for video thumbnails.
+const maxThumbnailSize = 200 * 1024
+
+// thumbnailTimeout bounds one ffmpeg run.
+const thumbnailTimeout = 15 * time.Second
+
+// VideoInfo is what ProbeMP4 reads from a video's header.
+type VideoInfo struct {
+	Duration time.Duration
+	Width    int
+	Height   int
+}
+
+// IsStreamableVideo reports whether name is a video Telegram plays inline.
+func IsStreamableVideo(name, mimeType string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".mp4", ".m4v", ".mov":
+		return true
+	}
+	return mimeType == "video/mp4" || mimeType == "video/quicktime"
+}
+
+// ProbeMP4 reads duration and picture size from the moov box of an MP4 or
+// QuickTime file. It only succeeds when moov lies entirely within head,
+// which is the case for files written for streaming ("faststart").
+func ProbeMP4(head []byte) (VideoInfo, bool) {
+	moov, ok := findBox(head, "moov")
+	if !ok {
+		return VideoInfo{}, false
+	}
+	var info VideoInfo
+	found := false
+	if mvhd, ok := findBox(moov, "mvhd"); ok && len(mvhd) >= 20 {
+		var timescale, duration uint64
+		if mvhd[0] == 1 && len(mvhd) >= 32 {
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+			duration = binary.BigEndian.Uint64(mvhd[24:32])
+		} else {
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+		}
+		if timescale > 0 {
+			info.Duration = time.Duration(duration) * time.Second / time.Duration(timescale)
+			found = true
+		}
+	}
+	eachBox(moov, func(kind string, trak []byte) bool {
+		if kind != "trak" || !isVideoTrack(trak) {
+			return true
+		}
+		tkhd, ok := findBox(trak, "tkhd")
+		if !ok || len(tkhd) < 84 {
+			return true
+		}
+		// Width and height are 16.16 fixed point at the end of tkhd.
+		info.Width = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+		info.Height = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+		found = true
+		return false
+	})
+	return info, found
+}
+
+func isVideoTrack(trak []byte) bool {
+	mdia, ok := findBox(trak, "mdia")
+	if !ok {
+		return false
+	}
+	hdlr, ok := findBox(mdia, "hdlr")
+	return ok && len(hdlr) >= 12 && string(hdlr[8:12]) == "vide"
+}
+
+// findBox returns the payload of the first box of kind directly in data.
+func findBox(data []byte, kind string) ([]byte, bool) {
+	var out []byte
+	found := false
+	eachBox(data, func(k string, payload []byte) bool {
+		if k == kind {
+			out, found = payload, true
+			return false
+		}
+		return true
+	})
+	return out, found
+}
+
+// eachBox calls fn for every complete box in data until fn returns false.
+func eachBox(data []byte, fn func(kind string, payload []byte) bool) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		kind := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return
+		}
+		if !fn(kind, data[header:size]) {
+			return
+		}
+		data = data[size:]
+	}
+}
+
+// Thumbnail renders the first frame found in head as a JPEG that fits the
+// Bot API thumbnail limits, using the ffmpeg binary at ffmpegPath.
+func Thumbnail(ctx context.Context, ffmpegPath string, head []byte) ([]byte, error) {
+	bin, err := exec.LookPath(ffmpegPath)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, thumbnailTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, bin,
+		"-hide_banner", "-loglevel", "error",
+		"-i", "pipe:0",
+		"-frames:v", "1",
+		"-vf", "scale=320:320:force_original_aspect_ratio=decrease",
+		"-q:v", "5",
+		"-f", "image2", "-c:v", "mjpeg", "pipe:1")
+	cmd.Stdin = bytes.NewReader(head)
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New("ffmpeg: " + msg)
+		}
+		return nil, err
+	}
+	if out.Len() == 0 || out.Len() > maxThumbnailSize {
+		return nil, errors.New("ffmpeg produced no usable thumbnail")
+	}
+	return out.Bytes(), nil
+}
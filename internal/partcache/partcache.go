@@ -0,0 +1,259 @@
+// Package partcache keeps downloaded Telegram files on disk so files read
+// again and again are served locally instead of being fetched through the
+// Bot API every time.
+package partcache
+
+import (
+	"container/list"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tempSuffix marks downloads still being written; they are not served and
+// leftovers are removed on Open.
+const tempSuffix = ".tmp"
+
+// Cache stores whole files and parts under their file_unique_id, which is
+// stable for the same content across bots and file ids. Once the total size
+// passes the limit the least recently read entries are deleted. A nil Cache
+// caches nothing.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // of *entry, most recently read first
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+// Open returns a cache in dir holding up to maxBytes, picking up the entries
+// a previous run left there in the order they were last read.
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type found struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var existing []found
+	for _, de := range des {
+		if de.IsDir() {
+			continue
+		}
+		name := de.Name()
+		if strings.HasSuffix(name, tempSuffix) || !validKey(name) {
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, found{key: name, size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].modTime.After(existing[j].modTime) })
+	c := &Cache{dir: dir, maxBytes: maxBytes, order: list.New(), entries: make(map[string]*list.Element)}
+	for _, f := range existing {
+		c.entries[f.key] = c.order.PushBack(&entry{key: f.key, size: f.size})
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evictLocked()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// validKey reports whether key is safe to use as a file name. Telegram's
+// file_unique_id values are URL-safe base64.
+func validKey(key string) bool {
+	if key == "" || len(key) > 128 {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// Size returns the bytes the cache holds.
+func (c *Cache) Size() int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Get opens the cached content of key at offset, and reports false when it
+// is not cached.
+func (c *Cache) Get(key string, offset int64) (io.ReadCloser, bool) {
+	if c == nil || !validKey(key) {
+		return nil, false
+	}
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if ok {
+		c.order.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		c.drop(key)
+		return nil, false
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, false
+	}
+	// The modification time keeps the read order across restarts.
+	now := time.Now()
+	_ = os.Chtimes(c.path(key), now, now)
+	return f, true
+}
+
+// Fill wraps r, a download of key from its first byte, so that the content
+// is cached once size bytes have been read from it. Readers that know the
+// size stop there without waiting for EOF. A download that ends or is
+// closed early, or one too big to ever fit, is not cached.
+func (c *Cache) Fill(key string, size int64, r io.ReadCloser) io.ReadCloser {
+	if c == nil || size <= 0 || size > c.maxBytes || !validKey(key) {
+		return r
+	}
+	tmp, err := os.CreateTemp(c.dir, key+"-*"+tempSuffix)
+	if err != nil {
+		log.Printf("part cache: %v", err)
+		return r
+	}
+	return &filling{ReadCloser: r, cache: c, key: key, size: size, tmp: tmp}
+}
+
+// commit moves a finished download into place.
+func (c *Cache) commit(key, tmp string, size int64) {
+	if err := os.Rename(tmp, c.path(key)); err != nil {
+		log.Printf("part cache: %v", err)
+		_ = os.Remove(tmp)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		// Another reader cached the same content first.
+		c.size -= el.Value.(*entry).size
+		c.order.Remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, size: size})
+	c.size += size
+	c.evictLocked()
+}
+
+// drop forgets an entry whose file went missing.
+func (c *Cache) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*entry).size
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// evictLocked deletes the least recently read entries until the cache fits
+// its limit. Readers holding an evicted file open keep reading it.
+func (c *Cache) evictLocked() {
+	for c.size > c.maxBytes {
+		el := c.order.Back()
+		if el == nil {
+			return
+		}
+		e := el.Value.(*entry)
+		c.order.Remove(el)
+		delete(c.entries, e.key)
+		c.size -= e.size
+		if err := os.Remove(c.path(e.key)); err != nil && !os.IsNotExist(err) {
+			log.Printf("part cache: %v", err)
+		}
+	}
+}
+
+// filling copies a download into a temporary file as it is read.
+type filling struct {
+	io.ReadCloser
+	cache   *Cache
+	key     string
+	size    int64
+	tmp     *os.File
+	written int64
+}
+
+func (f *filling) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+	if n > 0 && f.tmp != nil {
+		if _, werr := f.tmp.Write(p[:n]); werr != nil {
+			log.Printf("part cache: %v", werr)
+			f.abandon()
+		} else {
+			f.written += int64(n)
+		}
+	}
+	switch {
+	case f.tmp == nil:
+	case f.written == f.size:
+		name := f.tmp.Name()
+		closeErr := f.tmp.Close()
+		f.tmp = nil
+		if closeErr != nil {
+			_ = os.Remove(name)
+		} else {
+			f.cache.commit(f.key, name, f.size)
+		}
+	case f.written > f.size || err != nil:
+		f.abandon()
+	}
+	return n, err
+}
+
+func (f *filling) Close() error {
+	f.abandon()
+	return f.ReadCloser.Close()
+}
+
+// abandon drops a download that will not be cached.
+func (f *filling) abandon() {
+	if f.tmp == nil {
+		return
+	}
+	name := f.tmp.Name()
+	_ = f.tmp.Close()
+	_ = os.Remove(name)
+	f.tmp = nil
+}
@@ -0,0 +1,320 @@
+// Package rules parses and evaluates auto-organize rules such as
+// "*.jpg -> /Photos" or "size > 1GB -> /Big".
+//
+// A rule is one or more conditions joined by "and", an arrow ("->" or "→")
+// and a destination folder path. Supported conditions:
+//
+//	*.jpg             glob on the file name (case-insensitive)
+//	name *report*     same, spelled out
+//	size > 1GB        size comparison with >, >=, <, <= or =
+//	type image/*      MIME type glob
+//
+// and, on what was read from the content:
+//
+//	width >= 1920       picture width in pixels; likewise height
+//	duration > 10m      play time, as 90s, 10m or 1h30m
+//	pages > 100         document page count
+//	taken < 2020-01-01  photo date from EXIF, compared by day
+//	artist *beatles*    audio artist glob; likewise album
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigpak/internal/media"
+)
+
+// Item describes the file a rule is evaluated against. Conditions on
+// metadata that is unknown do not hold.
+type Item struct {
+	Name     string
+	Size     int64
+	MimeType string
+	Meta     media.Metadata
+}
+
+// Spec is a parsed rule definition.
+type Spec struct {
+	// Match is the normalized condition text, stored as-is and recompiled
+	// with Compile.
+	Match string
+	// Target is the cleaned absolute destination path.
+	Target string
+}
+
+// Matcher is a compiled condition list.
+type Matcher struct {
+	conds []condition
+}
+
+type condition struct {
+	field string
+	op    string
+	glob  string
+	// value is the number compared against: bytes, pixels, pages, seconds
+	// or, for taken, the Unix time of the day.
+	value int64
+}
+
+// numericFields are compared with op and value; the function reads the
+// item's number, which is 0 when unknown.
+var numericFields = map[string]func(Item) int64{
+	"size":     func(it Item) int64 { return it.Size },
+	"width":    func(it Item) int64 { return int64(it.Meta.Width) },
+	"height":   func(it Item) int64 { return int64(it.Meta.Height) },
+	"pages":    func(it Item) int64 { return int64(it.Meta.Pages) },
+	"duration": func(it Item) int64 { return int64(it.Meta.Duration / time.Second) },
+	"taken":    takenDay,
+}
+
+// takenDay is the Unix time of the day a photo was taken, or 0.
+func takenDay(it Item) int64 {
+	if it.Meta.TakenAt.IsZero() {
+		return 0
+	}
+	y, m, d := it.Meta.TakenAt.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix()
+}
+
+// Parse splits a rule definition into its conditions and destination.
+func Parse(text string) (Spec, error) {
+	text = strings.ReplaceAll(text, "→", "->")
+	match, target, ok := strings.Cut(text, "->")
+	if !ok {
+		return Spec{}, errors.New("rule needs a destination, e.g. *.jpg -> /Photos")
+	}
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return Spec{}, errors.New("rule destination is empty")
+	}
+	target = path.Clean("/" + target)
+	m, err := Compile(match)
+	if err != nil {
+		return Spec{}, err
+	}
+	return Spec{Match: m.String(), Target: target}, nil
+}
+
+// Compile parses condition text into a Matcher.
+func Compile(text string) (Matcher, error) {
+	var m Matcher
+	for _, raw := range splitAnd(text) {
+		c, err := parseCondition(raw)
+		if err != nil {
+			return Matcher{}, err
+		}
+		m.conds = append(m.conds, c)
+	}
+	if len(m.conds) == 0 {
+		return Matcher{}, errors.New("rule has no conditions")
+	}
+	return m, nil
+}
+
+// Match reports whether every condition holds for the item.
+func (m Matcher) Match(item Item) bool {
+	if len(m.conds) == 0 {
+		return false
+	}
+	for _, c := range m.conds {
+		if !c.match(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the normalized condition text.
+func (m Matcher) String() string {
+	parts := make([]string, 0, len(m.conds))
+	for _, c := range m.conds {
+		switch c.field {
+		case "size":
+			parts = append(parts, fmt.Sprintf("size %s %s", c.op, FormatSize(c.value)))
+		case "duration":
+			parts = append(parts, fmt.Sprintf("duration %s %s", c.op, time.Duration(c.value)*time.Second))
+		case "taken":
+			parts = append(parts, fmt.Sprintf("taken %s %s", c.op, time.Unix(c.value, 0).UTC().Format("2006-01-02")))
+		case "width", "height", "pages":
+			parts = append(parts, fmt.Sprintf("%s %s %d", c.field, c.op, c.value))
+		case "type", "artist", "album":
+			parts = append(parts, c.field+" "+c.glob)
+		default:
+			parts = append(parts, c.glob)
+		}
+	}
+	return strings.Join(parts, " and ")
+}
+
+func splitAnd(text string) []string {
+	fields := strings.Fields(text)
+	var out []string
+	var cur []string
+	for _, f := range fields {
+		if strings.EqualFold(f, "and") || f == "&&" {
+			if len(cur) > 0 {
+				out = append(out, strings.Join(cur, " "))
+			}
+			cur = nil
+			continue
+		}
+		cur = append(cur, f)
+	}
+	if len(cur) > 0 {
+		out = append(out, strings.Join(cur, " "))
+	}
+	return out
+}
+
+func parseCondition(raw string) (condition, error) {
+	fields := strings.Fields(raw)
+	key := strings.ToLower(fields[0])
+	switch key {
+	case "size", "width", "height", "pages", "duration", "taken":
+		rest := strings.Join(fields[1:], "")
+		op := ""
+		for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(rest, candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return condition{}, fmt.Errorf("%s condition needs >, >=, <, <= or =: %q", key, raw)
+		}
+		value, err := parseValue(key, strings.TrimPrefix(rest, op))
+		if err != nil {
+			return condition{}, err
+		}
+		return condition{field: key, op: op, value: value}, nil
+	case "artist", "album":
+		if len(fields) < 2 {
+			return condition{}, fmt.Errorf("%s condition needs a pattern: %q", key, raw)
+		}
+		return newGlob(key, strings.ToLower(strings.Join(fields[1:], " ")))
+	case "type", "mime":
+		if len(fields) != 2 {
+			return condition{}, fmt.Errorf("type condition needs one pattern: %q", raw)
+		}
+		return newGlob("type", strings.ToLower(fields[1]))
+	case "name":
+		if len(fields) < 2 {
+			return condition{}, fmt.Errorf("name condition needs a pattern: %q", raw)
+		}
+		return newGlob("name", strings.Join(fields[1:], " "))
+	default:
+		return newGlob("name", raw)
+	}
+}
+
+func newGlob(field, pattern string) (condition, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return condition{}, fmt.Errorf("invalid pattern %q", pattern)
+	}
+	return condition{field: field, glob: pattern}, nil
+}
+
+// parseValue reads the number a numeric condition compares against.
+func parseValue(field, text string) (int64, error) {
+	switch field {
+	case "size":
+		return ParseSize(text)
+	case "duration":
+		d, err := time.ParseDuration(text)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid duration %q, use e.g. 90s, 10m or 1h30m", text)
+		}
+		return int64(d / time.Second), nil
+	case "taken":
+		day, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return 0, fmt.Errorf("invalid date %q, use YYYY-MM-DD", text)
+		}
+		return day.Unix(), nil
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", field, text)
+	}
+	return n, nil
+}
+
+func (c condition) match(item Item) bool {
+	if get, ok := numericFields[c.field]; ok {
+		n := get(item)
+		if n == 0 && c.field != "size" {
+			return false
+		}
+		switch c.op {
+		case ">":
+			return n > c.value
+		case ">=":
+			return n >= c.value
+		case "<":
+			return n < c.value
+		case "<=":
+			return n <= c.value
+		default:
+			return n == c.value
+		}
+	}
+	switch c.field {
+	case "type":
+		ok, _ := path.Match(c.glob, strings.ToLower(item.MimeType))
+		return ok
+	case "artist", "album":
+		text := item.Meta.Artist
+		if c.field == "album" {
+			text = item.Meta.Album
+		}
+		ok, _ := path.Match(c.glob, strings.ToLower(text))
+		return ok && text != ""
+	default:
+		ok, _ := path.Match(strings.ToLower(c.glob), strings.ToLower(item.Name))
+		return ok
+	}
+}
+
+var sizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses sizes such as "1GB", "500 MB" or "1024".
+func ParseSize(text string) (int64, error) {
+	s := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(text), " ", ""))
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			mult = u.mult
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", text)
+	}
+	return int64(value * float64(mult)), nil
+}
+
+// FormatSize renders a size with the largest unit that divides it evenly.
+func FormatSize(size int64) string {
+	for _, u := range sizeUnits {
+		if size >= u.mult && size%u.mult == 0 {
+			return fmt.Sprintf("%d%s", size/u.mult, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", size)
+}
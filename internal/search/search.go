@@ -0,0 +1,315 @@
+// Package search parses file search queries such as
+// "report type:document size:>1MB after:2024-01-01 in:/Work".
+//
+// Words without a filter must all appear in the file name. Filters:
+//
+//	type:video        a kind (image, video, audio, document, archive) or a
+//	                  MIME type, optionally ending in "*", e.g. image/*
+//	size:>100MB       size comparison with >, >=, <, <= or =
+//	before:2024-01-01 added before that day
+//	after:2024-01-01  added on or after that day
+//	in:/Photos        inside that folder, at any depth
+//
+// Filters on what was read from the content when it was uploaded:
+//
+//	width:>=1920      picture width in pixels, with the size: operators;
+//	height:<1080      likewise the height
+//	duration:>10m     play time, as 90s, 10m, 1h30m or plain seconds
+//	pages:>100        document page count
+//	taken:2024-05-01  photo date from EXIF: that day, or >, >=, <, <= a day
+//	artist:beatles    audio artist containing that text, likewise album:
+//
+// Several type filters match any of them; other filters must all hold.
+// Double quotes keep spaces in a word or filter value: in:"/My Photos".
+package search
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigpak/internal/rules"
+)
+
+// Query is a parsed search.
+type Query struct {
+	// Terms must each appear in the file name, ignoring case.
+	Terms []string
+	// Types are MIME type patterns for SQL LIKE; a file matches any.
+	Types []string
+	// MinSize and MaxSize bound the size inclusively; -1 leaves a side open.
+	MinSize int64
+	MaxSize int64
+	// After and Before bound the creation time: After <= created < Before.
+	// Zero values leave a side open.
+	After  time.Time
+	Before time.Time
+	// In is the cleaned absolute folder path to search below, or "".
+	In string
+
+	// Width, Height, Pages and Duration (in seconds) bound the content
+	// metadata inclusively.
+	Width    Range
+	Height   Range
+	Pages    Range
+	Duration Range
+	// TakenAfter and TakenBefore bound the photo date like After and Before.
+	TakenAfter  time.Time
+	TakenBefore time.Time
+	// Artist and Album must appear in the audio tags, ignoring case.
+	Artist string
+	Album  string
+}
+
+// Range bounds a number inclusively; -1 leaves a side open.
+type Range struct {
+	Min int64
+	Max int64
+}
+
+// Open reports whether the range does not restrict anything.
+func (r Range) Open() bool {
+	return r.Min < 0 && r.Max < 0
+}
+
+// HasMetadata reports whether q filters on content metadata.
+func (q Query) HasMetadata() bool {
+	return !q.Width.Open() || !q.Height.Open() || !q.Pages.Open() || !q.Duration.Open() ||
+		!q.TakenAfter.IsZero() || !q.TakenBefore.IsZero() || q.Artist != "" || q.Album != ""
+}
+
+// kinds maps type:<kind> to the MIME patterns it covers.
+var kinds = map[string][]string{
+	"image":    {"image/%"},
+	"video":    {"video/%"},
+	"audio":    {"audio/%"},
+	"document": {"text/%", "application/pdf", "application/msword", "application/rtf", "application/vnd.openxmlformats-officedocument.%", "application/vnd.oasis.opendocument.%", "application/vnd.ms-%"},
+	"archive":  {"application/zip", "application/gzip", "application/x-tar", "application/x-7z-compressed", "application/x-rar%", "application/vnd.rar", "application/x-bzip2", "application/x-xz", "application/zstd"},
+}
+
+// Parse parses a search query. An empty query, or one with no terms and no
+// filters, is an error.
+func Parse(text string) (Query, error) {
+	open := Range{Min: -1, Max: -1}
+	q := Query{MinSize: -1, MaxSize: -1, Width: open, Height: open, Pages: open, Duration: open}
+	words, err := split(text)
+	if err != nil {
+		return Query{}, err
+	}
+	for _, word := range words {
+		key, value, ok := strings.Cut(word, ":")
+		if !ok || value == "" {
+			q.Terms = append(q.Terms, word)
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "type", "mime":
+			if err := q.addType(value); err != nil {
+				return Query{}, err
+			}
+		case "size":
+			if err := q.addSize(value); err != nil {
+				return Query{}, err
+			}
+		case "before":
+			day, err := parseDay(value)
+			if err != nil {
+				return Query{}, err
+			}
+			if q.Before.IsZero() || day.Before(q.Before) {
+				q.Before = day
+			}
+		case "after":
+			day, err := parseDay(value)
+			if err != nil {
+				return Query{}, err
+			}
+			if day.After(q.After) {
+				q.After = day
+			}
+		case "in":
+			q.In = path.Clean("/" + value)
+		case "width", "height", "pages":
+			r := map[string]*Range{"width": &q.Width, "height": &q.Height, "pages": &q.Pages}[strings.ToLower(key)]
+			if err := r.add(value, parseCount); err != nil {
+				return Query{}, err
+			}
+		case "duration":
+			if err := q.Duration.add(value, parseSeconds); err != nil {
+				return Query{}, err
+			}
+		case "taken":
+			if err := q.addTaken(value); err != nil {
+				return Query{}, err
+			}
+		case "artist":
+			q.Artist = value
+		case "album":
+			q.Album = value
+		default:
+			// Not a filter: a name containing a colon, such as "12:30".
+			q.Terms = append(q.Terms, word)
+		}
+	}
+	if len(q.Terms) == 0 && len(q.Types) == 0 && q.MinSize < 0 && q.MaxSize < 0 && q.After.IsZero() && q.Before.IsZero() && q.In == "" && !q.HasMetadata() {
+		return Query{}, errors.New("search needs a name or a filter")
+	}
+	return q, nil
+}
+
+func (q *Query) addType(value string) error {
+	value = strings.ToLower(value)
+	if patterns, ok := kinds[value]; ok {
+		q.Types = append(q.Types, patterns...)
+		return nil
+	}
+	if !strings.Contains(value, "/") {
+		return fmt.Errorf("unknown type %q; use image, video, audio, document, archive or a MIME type", value)
+	}
+	value = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+	q.Types = append(q.Types, strings.ReplaceAll(value, "*", "%"))
+	return nil
+}
+
+func (q *Query) addSize(value string) error {
+	r := Range{Min: q.MinSize, Max: q.MaxSize}
+	if err := r.add(value, rules.ParseSize); err != nil {
+		return err
+	}
+	q.MinSize, q.MaxSize = r.Min, r.Max
+	return nil
+}
+
+// add narrows r by a comparison such as ">100" or "=5", reading the number
+// with parse.
+func (r *Range) add(value string, parse func(string) (int64, error)) error {
+	op, value := cutOperator(value)
+	n, err := parse(value)
+	if err != nil {
+		return err
+	}
+	lower, upper := int64(-1), int64(-1)
+	switch op {
+	case ">":
+		lower = n + 1
+	case ">=":
+		lower = n
+	case "<":
+		if n == 0 {
+			return errors.New("nothing is smaller than 0")
+		}
+		upper = n - 1
+	case "<=":
+		upper = n
+	default:
+		lower, upper = n, n
+	}
+	if lower >= 0 && lower > r.Min {
+		r.Min = lower
+	}
+	if upper >= 0 && (r.Max < 0 || upper < r.Max) {
+		r.Max = upper
+	}
+	return nil
+}
+
+// cutOperator splits a leading comparison operator off value; "=" is the
+// default.
+func cutOperator(value string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			return op, value[len(op):]
+		}
+	}
+	return "=", value
+}
+
+func (q *Query) addTaken(value string) error {
+	op, value := cutOperator(value)
+	day, err := parseDay(value)
+	if err != nil {
+		return err
+	}
+	var after, before time.Time
+	next := day.AddDate(0, 0, 1)
+	switch op {
+	case ">":
+		after = next
+	case ">=":
+		after = day
+	case "<":
+		before = day
+	case "<=":
+		before = next
+	default:
+		after, before = day, next
+	}
+	if after.After(q.TakenAfter) {
+		q.TakenAfter = after
+	}
+	if !before.IsZero() && (q.TakenBefore.IsZero() || before.Before(q.TakenBefore)) {
+		q.TakenBefore = before
+	}
+	return nil
+}
+
+func parseCount(value string) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number %q", value)
+	}
+	return n, nil
+}
+
+// parseSeconds reads a duration such as 90s, 10m or 1h30m, or a plain
+// number of seconds.
+func parseSeconds(value string) (int64, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+		return n, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q, use e.g. 90s, 10m or 1h30m", value)
+	}
+	return int64(d / time.Second), nil
+}
+
+// parseDay reads a YYYY-MM-DD date as midnight UTC.
+func parseDay(value string) (time.Time, error) {
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD", value)
+	}
+	return day, nil
+}
+
+// split breaks text into words on spaces outside double quotes and drops
+// the quotes.
+func split(text string) ([]string, error) {
+	var words []string
+	var cur strings.Builder
+	quoted := false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if cur.Len() > 0 {
+				words = append(words, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if cur.Len() > 0 {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
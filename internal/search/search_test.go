@@ -0,0 +1,91 @@
+package search_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"pigpak/internal/search"
+)
+
+// query returns a parsed query with nothing set, changed by edit.
+func query(edit func(q *search.Query)) search.Query {
+	open := search.Range{Min: -1, Max: -1}
+	q := search.Query{MinSize: -1, MaxSize: -1, Width: open, Height: open, Pages: open, Duration: open}
+	edit(&q)
+	return q
+}
+
+func day(text string) time.Time {
+	d, err := time.Parse("2006-01-02", text)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		want search.Query
+	}{
+		{"report", query(func(q *search.Query) { q.Terms = []string{"report"} })},
+		{"type:video", query(func(q *search.Query) { q.Types = []string{"video/%"} })},
+		{"TYPE:Image type:audio", query(func(q *search.Query) { q.Types = []string{"image/%", "audio/%"} })},
+		{"type:image/*", query(func(q *search.Query) { q.Types = []string{"image/%"} })},
+		{"type:application/x_y", query(func(q *search.Query) { q.Types = []string{`application/x\_y`} })},
+		{"size:>100MB", query(func(q *search.Query) { q.MinSize = 100<<20 + 1 })},
+		{"size:>=1KB size:<2KB", query(func(q *search.Query) { q.MinSize, q.MaxSize = 1<<10, 2<<10-1 })},
+		{"size:<=5", query(func(q *search.Query) { q.MaxSize = 5 })},
+		{"size:10B", query(func(q *search.Query) { q.MinSize, q.MaxSize = 10, 10 })},
+		{"before:2024-01-01", query(func(q *search.Query) { q.Before = day("2024-01-01") })},
+		{"before:2024-03-01 before:2024-02-01", query(func(q *search.Query) { q.Before = day("2024-02-01") })},
+		{"after:2024-01-01 after:2023-01-01", query(func(q *search.Query) { q.After = day("2024-01-01") })},
+		{"in:/Photos", query(func(q *search.Query) { q.In = "/Photos" })},
+		{"in:Work/../Photos/", query(func(q *search.Query) { q.In = "/Photos" })},
+		{`in:"/a b"`, query(func(q *search.Query) { q.In = "/a b" })},
+		{`"annual report" in:/Work`, query(func(q *search.Query) { q.Terms, q.In = []string{"annual report"}, "/Work" })},
+		{"12:30 notes", query(func(q *search.Query) { q.Terms = []string{"12:30", "notes"} })},
+		{"in: x", query(func(q *search.Query) { q.Terms = []string{"in:", "x"} })},
+		{"width:>=1920 duration:1h30m", query(func(q *search.Query) {
+			q.Width.Min = 1920
+			q.Duration = search.Range{Min: 5400, Max: 5400}
+		})},
+		{"taken:2024-05-01", query(func(q *search.Query) { q.TakenAfter, q.TakenBefore = day("2024-05-01"), day("2024-05-02") })},
+		{"artist:beatles", query(func(q *search.Query) { q.Artist = "beatles" })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := search.Parse(tt.text)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.text, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Parse(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejectsMalformedQueries(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"   ",
+		"size:>",
+		"size:>=",
+		"size:big",
+		"size:-1MB",
+		"size:<0",
+		"before:notadate",
+		"after:2024-13-01",
+		"type:spreadsheet",
+		`in:"/a b`,
+		"width:wide",
+		"duration:forever",
+		"taken:>yesterday",
+	} {
+		if q, err := search.Parse(text); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", text, q)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package telegram
+
+import (
+	"context"
+	"io"
+)
+
+// BotAPI is the part of the Bot API that pigpak uses. Client implements it
+// over HTTP; telegramtest.Fake implements it in memory.
+type BotAPI interface {
+	GetUpdates(ctx context.Context, offset, limit, timeoutSec int) ([]Update, error)
+	GetMe(ctx context.Context) (*User, error)
+	GetChat(ctx context.Context, chatID int64) (*Chat, error)
+	GetChatMember(ctx context.Context, chatID, userID int64) (*ChatMember, error)
+	SendMessage(ctx context.Context, chatID int64, text string, markup *InlineKeyboardMarkup) (*Message, error)
+	SendFormattedMessage(ctx context.Context, chatID int64, text, parseMode string, markup *InlineKeyboardMarkup) (*Message, error)
+	EditMessageText(ctx context.Context, chatID int64, messageID int, text string, markup *InlineKeyboardMarkup) (*Message, error)
+	EditFormattedMessageText(ctx context.Context, chatID int64, messageID int, text, parseMode string, markup *InlineKeyboardMarkup) (*Message, error)
+	AnswerCallbackQuery(ctx context.Context, callbackID, text string) error
+	DeleteMessage(ctx context.Context, chatID int64, messageID int) error
+	SendChatAction(ctx context.Context, chatID int64, action string) error
+	SendDocument(ctx context.Context, chatID int64, fileID, caption string, markup *InlineKeyboardMarkup) (*Message, error)
+	CopyMessage(ctx context.Context, chatID, fromChatID int64, messageID int) (int, error)
+	UploadDocument(ctx context.Context, chatID int64, filename string, reader io.Reader) (*Message, error)
+	UploadVideo(ctx context.Context, chatID int64, filename string, reader io.Reader, meta VideoMeta) (*Message, error)
+	UploadPhoto(ctx context.Context, chatID int64, filename string, reader io.Reader, caption string, markup *InlineKeyboardMarkup) (*Message, error)
+	GetFile(ctx context.Context, fileID string) (*File, error)
+	DownloadFile(ctx context.Context, filePath string, offset int64) (io.ReadCloser, error)
+}
+
+var _ BotAPI = (*Client)(nil)
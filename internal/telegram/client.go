@@ -9,6 +9,8 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 )
@@ -18,8 +20,33 @@ type Client struct {
 	Token  string
 	APIURL string
 	HTTP   *http.Client
+	// MaxRetries bounds how often a request is repeated after a flood wait,
+	// a 5xx answer or a network error. Zero disables retries.
+	MaxRetries int
+	// Limiter spaces out message-sending calls. Nil disables client-side
+	// rate limiting.
+	Limiter *RateLimiter
+	// MaxResumes bounds how often a download stream that fails midway is
+	// reopened at its current offset without making progress in between.
+	// Zero disables resuming.
+	MaxResumes int
+	// LocalMode is set when APIURL is a Bot API server started with --local.
+	// Such servers return absolute file paths, which are read from disk.
+	LocalMode bool
+	// AllowedUpdates lists the update types GetUpdates asks for. Telegram
+	// keeps the last list it was sent, so an empty list is never sent.
+	AllowedUpdates []string
+	// OnChatMigrated is called when Telegram reports that a group was
+	// upgraded to a supergroup. The failed request is repeated on the new
+	// chat and later requests to the old id are redirected.
+	OnChatMigrated func(from, to int64)
+
+	migrations chatMigrations
 }
 
+// DefaultAllowedUpdates are the update types the bot handles.
+var DefaultAllowedUpdates = []string{"message", "callback_query", "channel_post"}
+
 // NewClient creates a Telegram client.
 func NewClient(token, apiURL string, timeout time.Duration) *Client {
 	if timeout <= 0 {
@@ -31,6 +58,10 @@ func NewClient(token, apiURL string, timeout time.Duration) *Client {
 		HTTP: &http.Client{
 			Timeout: timeout,
 		},
+		MaxRetries:     DefaultMaxRetries,
+		MaxResumes:     DefaultMaxResumes,
+		Limiter:        NewRateLimiter(DefaultRateLimits),
+		AllowedUpdates: DefaultAllowedUpdates,
 	}
 }
 
@@ -39,6 +70,8 @@ type Update struct {
 	UpdateID      int            `json:"update_id"`
 	Message       *Message       `json:"message,omitempty"`
 	CallbackQuery *CallbackQuery `json:"callback_query,omitempty"`
+	// ChannelPost is a message posted in a channel the bot administers.
+	ChannelPost *Message `json:"channel_post,omitempty"`
 }
 
 // Message is a Telegram message payload.
@@ -60,21 +93,43 @@ type User struct {
 	ID        int64  `json:"id"`
 	Username  string `json:"username,omitempty"`
 	FirstName string `json:"first_name,omitempty"`
+	IsPremium bool   `json:"is_premium,omitempty"`
 }
 
 // Chat represents a chat.
 type Chat struct {
-	ID   int64  `json:"id"`
-	Type string `json:"type"`
+	ID    int64  `json:"id"`
+	Type  string `json:"type"`
+	Title string `json:"title,omitempty"`
+}
+
+// ChatMember is a user's membership in a chat. Status is one of "creator",
+// "administrator", "member", "restricted", "left" or "kicked"; the Can
+// fields are only reported for administrators.
+type ChatMember struct {
+	User              User   `json:"user"`
+	Status            string `json:"status"`
+	CanPostMessages   bool   `json:"can_post_messages,omitempty"`
+	CanDeleteMessages bool   `json:"can_delete_messages,omitempty"`
+}
+
+// IsMember reports whether the member is in the chat.
+func (m ChatMember) IsMember() bool {
+	switch m.Status {
+	case "creator", "administrator", "member", "restricted":
+		return true
+	}
+	return false
 }
 
 // Document represents a document file.
 type Document struct {
-	FileID       string `json:"file_id"`
-	FileUniqueID string `json:"file_unique_id"`
-	FileName     string `json:"file_name"`
-	MimeType     string `json:"mime_type"`
-	FileSize     int64  `json:"file_size"`
+	FileID       string     `json:"file_id"`
+	FileUniqueID string     `json:"file_unique_id"`
+	FileName     string     `json:"file_name"`
+	MimeType     string     `json:"mime_type"`
+	FileSize     int64      `json:"file_size"`
+	Thumbnail    *PhotoSize `json:"thumbnail,omitempty"`
 }
 
 // PhotoSize represents a photo size.
@@ -94,6 +149,8 @@ type Audio struct {
 	MimeType     string `json:"mime_type"`
 	FileSize     int64  `json:"file_size"`
 	Duration     int    `json:"duration"`
+	Performer    string `json:"performer,omitempty"`
+	Title        string `json:"title,omitempty"`
 }
 
 // Video represents a video file.
@@ -106,6 +163,28 @@ type Video struct {
 	Duration     int    `json:"duration"`
 	Width        int    `json:"width"`
 	Height       int    `json:"height"`
+	Thumbnail    *PhotoSize `json:"thumbnail,omitempty"`
+}
+
+// StoredDocument returns the uploaded file a message carries as a
+// Document, whether it was sent as a document or as a video.
+func (m *Message) StoredDocument() *Document {
+	switch {
+	case m == nil:
+		return nil
+	case m.Document != nil:
+		return m.Document
+	case m.Video != nil:
+		return &Document{
+			FileID:       m.Video.FileID,
+			FileUniqueID: m.Video.FileUniqueID,
+			FileName:     m.Video.FileName,
+			MimeType:     m.Video.MimeType,
+			FileSize:     m.Video.FileSize,
+			Thumbnail:    m.Video.Thumbnail,
+		}
+	}
+	return nil
 }
 
 // CallbackQuery is an inline callback payload.
@@ -140,10 +219,38 @@ func (c *Client) fileURL(filePath string) string {
 }
 
 func (c *Client) doJSON(ctx context.Context, method string, payload any, out any) error {
+	payload = c.migratePayload(payload)
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
+	chatID := payloadChatID(payload)
+	migrated := false
+	for attempt := 1; ; attempt++ {
+		if err := c.Limiter.Wait(ctx, method, chatID); err != nil {
+			return err
+		}
+		err := c.doJSONOnce(ctx, method, body, out)
+		if to, ok := c.noteMigration(chatID, err); ok && !migrated {
+			migrated = true
+			payload = c.migratePayload(payload)
+			if body, err = json.Marshal(payload); err != nil {
+				return err
+			}
+			chatID = to
+			continue
+		}
+		delay, retry := c.retryDelay(attempt, err)
+		if !retry {
+			return err
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) doJSONOnce(ctx context.Context, method string, body []byte, out any) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL(method), bytes.NewReader(body))
 	if err != nil {
 		return err
@@ -154,29 +261,29 @@ func (c *Client) doJSON(ctx context.Context, method string, payload any, out any
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("telegram api status: %s", resp.Status)
-	}
-	if out == nil {
-		return nil
-	}
-	return json.NewDecoder(resp.Body).Decode(out)
+	// Telegram answers API errors with a JSON body and a 4xx status; decode it
+	// so callers can report the description instead of a bare status.
+	return decodeResponse(method, resp, out)
 }
 
-// GetUpdates polls for updates.
-func (c *Client) GetUpdates(ctx context.Context, offset int, timeoutSec int) ([]Update, error) {
+// GetUpdates polls for updates. A limit of zero uses the Telegram default of 100.
+func (c *Client) GetUpdates(ctx context.Context, offset, limit, timeoutSec int) ([]Update, error) {
+	allowed := c.AllowedUpdates
+	if len(allowed) == 0 {
+		allowed = DefaultAllowedUpdates
+	}
 	payload := map[string]any{
-		"offset":  offset,
-		"timeout": timeoutSec,
-		"allowed_updates": []string{"message", "callback_query"},
+		"offset":          offset,
+		"timeout":         timeoutSec,
+		"allowed_updates": allowed,
+	}
+	if limit > 0 {
+		payload["limit"] = limit
 	}
 	var resp apiResponse[[]Update]
 	if err := c.doJSON(ctx, "getUpdates", payload, &resp); err != nil {
 		return nil, err
 	}
-	if !resp.OK {
-		return nil, fmt.Errorf("telegram getUpdates failed: %s", resp.Description)
-	}
 	return resp.Result, nil
 }
 
@@ -186,18 +293,47 @@ func (c *Client) GetMe(ctx context.Context) (*User, error) {
 	if err := c.doJSON(ctx, "getMe", map[string]any{}, &resp); err != nil {
 		return nil, err
 	}
-	if !resp.OK {
-		return nil, fmt.Errorf("telegram getMe failed: %s", resp.Description)
+	return &resp.Result, nil
+}
+
+// GetChat returns the chat chatID.
+func (c *Client) GetChat(ctx context.Context, chatID int64) (*Chat, error) {
+	var resp apiResponse[Chat]
+	if err := c.doJSON(ctx, "getChat", map[string]any{"chat_id": chatID}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Result, nil
+}
+
+// GetChatMember returns userID's membership in chatID.
+func (c *Client) GetChatMember(ctx context.Context, chatID, userID int64) (*ChatMember, error) {
+	payload := map[string]any{
+		"chat_id": chatID,
+		"user_id": userID,
+	}
+	var resp apiResponse[ChatMember]
+	if err := c.doJSON(ctx, "getChatMember", payload, &resp); err != nil {
+		return nil, err
 	}
 	return &resp.Result, nil
 }
 
 // SendMessage sends a text message.
 func (c *Client) SendMessage(ctx context.Context, chatID int64, text string, markup *InlineKeyboardMarkup) (*Message, error) {
+	return c.SendFormattedMessage(ctx, chatID, text, "", markup)
+}
+
+// SendFormattedMessage sends a text message rendered with parseMode, one of
+// the ParseMode constants. An empty parseMode sends plain text.
+func (c *Client) SendFormattedMessage(ctx context.Context, chatID int64, text, parseMode string, markup *InlineKeyboardMarkup) (*Message, error) {
 	payload := map[string]any{
 		"chat_id": chatID,
 		"text":    text,
 	}
+	if parseMode != "" {
+		payload["parse_mode"] = parseMode
+		payload["disable_web_page_preview"] = true
+	}
 	if markup != nil {
 		payload["reply_markup"] = markup
 	}
@@ -205,19 +341,25 @@ func (c *Client) SendMessage(ctx context.Context, chatID int64, text string, mar
 	if err := c.doJSON(ctx, "sendMessage", payload, &resp); err != nil {
 		return nil, err
 	}
-	if !resp.OK {
-		return nil, fmt.Errorf("telegram sendMessage failed: %s", resp.Description)
-	}
 	return &resp.Result, nil
 }
 
 // EditMessageText edits a message.
 func (c *Client) EditMessageText(ctx context.Context, chatID int64, messageID int, text string, markup *InlineKeyboardMarkup) (*Message, error) {
+	return c.EditFormattedMessageText(ctx, chatID, messageID, text, "", markup)
+}
+
+// EditFormattedMessageText edits a message, rendering text with parseMode.
+func (c *Client) EditFormattedMessageText(ctx context.Context, chatID int64, messageID int, text, parseMode string, markup *InlineKeyboardMarkup) (*Message, error) {
 	payload := map[string]any{
 		"chat_id":    chatID,
 		"message_id": messageID,
 		"text":       text,
 	}
+	if parseMode != "" {
+		payload["parse_mode"] = parseMode
+		payload["disable_web_page_preview"] = true
+	}
 	if markup != nil {
 		payload["reply_markup"] = markup
 	}
@@ -225,9 +367,6 @@ func (c *Client) EditMessageText(ctx context.Context, chatID int64, messageID in
 	if err := c.doJSON(ctx, "editMessageText", payload, &resp); err != nil {
 		return nil, err
 	}
-	if !resp.OK {
-		return nil, fmt.Errorf("telegram editMessageText failed: %s", resp.Description)
-	}
 	return &resp.Result, nil
 }
 
@@ -243,12 +382,37 @@ func (c *Client) AnswerCallbackQuery(ctx context.Context, callbackID, text strin
 	if err := c.doJSON(ctx, "answerCallbackQuery", payload, &resp); err != nil {
 		return err
 	}
-	if !resp.OK {
-		return fmt.Errorf("telegram answerCallbackQuery failed: %s", resp.Description)
-	}
 	return nil
 }
 
+// DeleteMessage deletes a message. Bots can delete any message in channels
+// and groups where they may delete messages.
+func (c *Client) DeleteMessage(ctx context.Context, chatID int64, messageID int) error {
+	payload := map[string]any{
+		"chat_id":    chatID,
+		"message_id": messageID,
+	}
+	var resp apiResponse[bool]
+	return c.doJSON(ctx, "deleteMessage", payload, &resp)
+}
+
+// Chat actions shown by SendChatAction.
+const (
+	ChatActionTyping         = "typing"
+	ChatActionUploadDocument = "upload_document"
+)
+
+// SendChatAction shows action in the chat for about five seconds, or until
+// the bot sends a message.
+func (c *Client) SendChatAction(ctx context.Context, chatID int64, action string) error {
+	payload := map[string]any{
+		"chat_id": chatID,
+		"action":  action,
+	}
+	var resp apiResponse[bool]
+	return c.doJSON(ctx, "sendChatAction", payload, &resp)
+}
+
 // SendDocument sends a document by file_id.
 func (c *Client) SendDocument(ctx context.Context, chatID int64, fileID, caption string, markup *InlineKeyboardMarkup) (*Message, error) {
 	payload := map[string]any{
@@ -265,55 +429,179 @@ func (c *Client) SendDocument(ctx context.Context, chatID int64, fileID, caption
 	if err := c.doJSON(ctx, "sendDocument", payload, &resp); err != nil {
 		return nil, err
 	}
-	if !resp.OK {
-		return nil, fmt.Errorf("telegram sendDocument failed: %s", resp.Description)
-	}
 	return &resp.Result, nil
 }
 
-// UploadDocument uploads a document to a chat.
+// CopyMessage copies a message into another chat without a link to the
+// original and returns the new message id. Telegram does not return the
+// copied message itself.
+func (c *Client) CopyMessage(ctx context.Context, chatID, fromChatID int64, messageID int) (int, error) {
+	payload := map[string]any{
+		"chat_id":      chatID,
+		"from_chat_id": fromChatID,
+		"message_id":   messageID,
+	}
+	var resp apiResponse[struct {
+		MessageID int `json:"message_id"`
+	}]
+	if err := c.doJSON(ctx, "copyMessage", payload, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Result.MessageID, nil
+}
+
+// UploadDocument uploads a document to a chat. Readers that can seek are
+// rewound and uploaded again after a transient failure.
 func (c *Client) UploadDocument(ctx context.Context, chatID int64, filename string, reader io.Reader) (*Message, error) {
-	pr, pw := io.Pipe()
-	mw := multipart.NewWriter(pw)
-	resultCh := make(chan error, 1)
-	closeReader := func(err error) {
-		type closeWithError interface {
-			CloseWithError(error) error
+	return c.uploadWithRetry(ctx, chatID, mediaUpload{method: "sendDocument", field: "document"}, filename, reader)
+}
+
+// VideoMeta describes a video for UploadVideo. Zero fields are left for
+// Telegram to detect.
+type VideoMeta struct {
+	Duration int
+	Width    int
+	Height   int
+	// Thumbnail is a JPEG of at most 320x320 pixels and 200 kB.
+	Thumbnail []byte
+}
+
+// UploadVideo uploads a streamable video to a chat so clients can play it
+// and show a preview. The returned message carries Video, not Document.
+func (c *Client) UploadVideo(ctx context.Context, chatID int64, filename string, reader io.Reader, meta VideoMeta) (*Message, error) {
+	up := mediaUpload{
+		method:    "sendVideo",
+		field:     "video",
+		fields:    map[string]string{"supports_streaming": "true"},
+		thumbnail: meta.Thumbnail,
+	}
+	if meta.Duration > 0 {
+		up.fields["duration"] = strconv.Itoa(meta.Duration)
+	}
+	if meta.Width > 0 && meta.Height > 0 {
+		up.fields["width"] = strconv.Itoa(meta.Width)
+		up.fields["height"] = strconv.Itoa(meta.Height)
+	}
+	return c.uploadWithRetry(ctx, chatID, up, filename, reader)
+}
+
+// UploadPhoto sends an image, such as a preview, to a chat as a photo with
+// an optional caption and keyboard.
+func (c *Client) UploadPhoto(ctx context.Context, chatID int64, filename string, reader io.Reader, caption string, markup *InlineKeyboardMarkup) (*Message, error) {
+	up := mediaUpload{method: "sendPhoto", field: "photo", fields: map[string]string{}}
+	if caption != "" {
+		up.fields["caption"] = caption
+	}
+	if markup != nil {
+		encoded, err := json.Marshal(markup)
+		if err != nil {
+			return nil, err
 		}
-		if reader == nil {
-			return
+		up.fields["reply_markup"] = string(encoded)
+	}
+	return c.uploadWithRetry(ctx, chatID, up, filename, reader)
+}
+
+// mediaUpload names the method and form field of a multipart upload.
+type mediaUpload struct {
+	method    string
+	field     string
+	fields    map[string]string
+	thumbnail []byte
+}
+
+func (c *Client) uploadWithRetry(ctx context.Context, chatID int64, up mediaUpload, filename string, reader io.Reader) (*Message, error) {
+	seeker, canRewind := reader.(io.Seeker)
+	var start int64
+	if canRewind {
+		pos, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			canRewind = false
 		}
-		if closer, ok := reader.(closeWithError); ok {
-			_ = closer.CloseWithError(err)
-			return
+		start = pos
+	}
+	chatID = c.ResolveChat(chatID)
+	migrated := false
+	for attempt := 1; ; attempt++ {
+		msg, err := c.upload(ctx, chatID, up, filename, reader, !canRewind)
+		if err == nil {
+			return msg, nil
 		}
-		if closer, ok := reader.(io.Closer); ok {
-			_ = closer.Close()
+		to, moved := c.noteMigration(chatID, err)
+		if !canRewind {
+			return nil, err
 		}
-	}
-	go func() {
-		defer pw.Close()
-		if err := mw.WriteField("chat_id", strconv.FormatInt(chatID, 10)); err != nil {
-			resultCh <- err
-			return
+		if moved && !migrated {
+			migrated = true
+			chatID = to
+			if _, seekErr := seeker.Seek(start, io.SeekStart); seekErr == nil {
+				continue
+			}
 		}
-		part, err := mw.CreateFormFile("document", filename)
-		if err != nil {
-			resultCh <- err
-			return
+		delay, retry := c.retryDelay(attempt, err)
+		if retry {
+			if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+				err = sleepErr
+				retry = false
+			}
+		}
+		if retry {
+			if _, seekErr := seeker.Seek(start, io.SeekStart); seekErr == nil {
+				continue
+			}
+		}
+		closeUploadReader(reader, err)
+		return nil, err
+	}
+}
+
+// closeUploadReader unblocks whoever feeds reader once an upload has failed.
+func closeUploadReader(reader io.Reader, err error) {
+	type closeWithError interface {
+		CloseWithError(error) error
+	}
+	if reader == nil {
+		return
+	}
+	if closer, ok := reader.(closeWithError); ok {
+		_ = closer.CloseWithError(err)
+		return
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// upload makes one upload attempt. With closeOnError the reader is closed
+// on failure; otherwise it is left for the caller to rewind.
+func (c *Client) upload(ctx context.Context, chatID int64, up mediaUpload, filename string, reader io.Reader, closeOnError bool) (*Message, error) {
+	if err := c.Limiter.Wait(ctx, up.method, chatID); err != nil {
+		if closeOnError {
+			closeUploadReader(reader, err)
 		}
-		if _, err := io.Copy(part, reader); err != nil {
-			resultCh <- err
-			return
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	resultCh := make(chan error, 1)
+	fail := func(err error) error {
+		_ = pw.CloseWithError(err)
+		if closeOnError {
+			closeUploadReader(reader, err)
+		} else {
+			// Wait for the writer to stop reading before the caller rewinds.
+			<-resultCh
 		}
-		resultCh <- mw.Close()
+		return err
+	}
+	go func() {
+		defer pw.Close()
+		resultCh <- writeUploadForm(mw, chatID, up, filename, reader)
 	}()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL("sendDocument"), pr)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL(up.method), pr)
 	if err != nil {
-		_ = pw.CloseWithError(err)
-		closeReader(err)
-		return nil, err
+		return nil, fail(err)
 	}
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 	client := c.HTTP
@@ -327,28 +615,12 @@ func (c *Client) UploadDocument(ctx context.Context, chatID int64, filename stri
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		_ = pw.CloseWithError(err)
-		closeReader(err)
-		return nil, err
+		return nil, fail(err)
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		err := fmt.Errorf("telegram upload status: %s", resp.Status)
-		_ = pw.CloseWithError(err)
-		closeReader(err)
-		return nil, err
-	}
 	var apiResp apiResponse[Message]
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		_ = pw.CloseWithError(err)
-		closeReader(err)
-		return nil, err
-	}
-	if !apiResp.OK {
-		err := fmt.Errorf("telegram upload failed: %s", apiResp.Description)
-		_ = pw.CloseWithError(err)
-		closeReader(err)
-		return nil, err
+	if err := decodeResponse(up.method, resp, &apiResp); err != nil {
+		return nil, fail(err)
 	}
 	select {
 	case err := <-resultCh:
@@ -356,14 +628,44 @@ func (c *Client) UploadDocument(ctx context.Context, chatID int64, filename stri
 			return nil, err
 		}
 	case <-ctx.Done():
-		err := ctx.Err()
-		_ = pw.CloseWithError(err)
-		closeReader(err)
-		return nil, err
+		return nil, fail(ctx.Err())
 	}
 	return &apiResp.Result, nil
 }
 
+// writeUploadForm writes the form fields and any thumbnail first, then
+// streams the file itself.
+func writeUploadForm(mw *multipart.Writer, chatID int64, up mediaUpload, filename string, reader io.Reader) error {
+	if err := mw.WriteField("chat_id", strconv.FormatInt(chatID, 10)); err != nil {
+		return err
+	}
+	for key, value := range up.fields {
+		if err := mw.WriteField(key, value); err != nil {
+			return err
+		}
+	}
+	if len(up.thumbnail) > 0 {
+		if err := mw.WriteField("thumbnail", "attach://thumbnail_file"); err != nil {
+			return err
+		}
+		part, err := mw.CreateFormFile("thumbnail_file", "thumbnail.jpg")
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(up.thumbnail); err != nil {
+			return err
+		}
+	}
+	part, err := mw.CreateFormFile(up.field, filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, reader); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
 // GetFile retrieves file metadata.
 func (c *Client) GetFile(ctx context.Context, fileID string) (*File, error) {
 	payload := map[string]any{"file_id": fileID}
@@ -371,22 +673,47 @@ func (c *Client) GetFile(ctx context.Context, fileID string) (*File, error) {
 	if err := c.doJSON(ctx, "getFile", payload, &resp); err != nil {
 		return nil, err
 	}
-	if !resp.OK {
-		return nil, fmt.Errorf("telegram getFile failed: %s", resp.Description)
-	}
 	return &resp.Result, nil
 }
 
-// DownloadFile opens a file stream from Telegram.
+// DownloadFile opens a file stream from Telegram, retrying transient
+// failures to connect. A stream that breaks off midway is reopened at the
+// current offset up to MaxResumes times in a row.
 func (c *Client) DownloadFile(ctx context.Context, filePath string, offset int64) (io.ReadCloser, error) {
+	if c.LocalMode && filepath.IsAbs(filePath) {
+		return openLocalFile(filePath, offset)
+	}
+	body, remaining, err := c.openDownload(ctx, filePath, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &resumingReader{c: c, ctx: ctx, filePath: filePath, offset: offset, remaining: remaining, body: body}, nil
+}
+
+// openDownload opens the stream at offset, retrying transient failures, and
+// returns how many bytes it should yield, or -1 when unknown.
+func (c *Client) openDownload(ctx context.Context, filePath string, offset int64) (io.ReadCloser, int64, error) {
+	for attempt := 1; ; attempt++ {
+		body, remaining, err := c.downloadFile(ctx, filePath, offset)
+		delay, retry := c.retryDelay(attempt, err)
+		if !retry {
+			return body, remaining, err
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+func (c *Client) downloadFile(ctx context.Context, filePath string, offset int64) (io.ReadCloser, int64, error) {
 	fileURL := c.fileURL(filePath)
 	reqURL, err := url.Parse(fileURL)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if offset > 0 {
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
@@ -402,17 +729,37 @@ func (c *Client) DownloadFile(ctx context.Context, filePath string, offset int64
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if resp.StatusCode >= 300 {
 		resp.Body.Close()
-		return nil, fmt.Errorf("telegram file download status: %s", resp.Status)
+		return nil, 0, &TelegramError{Method: "file download", StatusCode: resp.StatusCode, Description: resp.Status}
 	}
+	remaining := resp.ContentLength
 	if offset > 0 && resp.StatusCode == http.StatusOK {
 		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
 			resp.Body.Close()
+			return nil, 0, err
+		}
+		if remaining >= 0 {
+			remaining -= offset
+		}
+	}
+	return resp.Body, remaining, nil
+}
+
+// openLocalFile opens a file stored by a local Bot API server. The server's
+// working directory must be mounted at the same path for pigpak.
+func openLocalFile(filePath string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("telegram local file: %w", err)
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
 			return nil, err
 		}
 	}
-	return resp.Body, nil
+	return f, nil
 }
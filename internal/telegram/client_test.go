@@ -0,0 +1,165 @@
+package telegram_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"pigpak/internal/telegram"
+	"pigpak/internal/telegram/telegramtest"
+)
+
+const storageChatID = -100
+
+// testData returns n bytes that differ from offset to offset, so a resume
+// at the wrong position shows up as a mismatch.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+// upload stores data on s and returns the file path to download it from.
+func upload(t *testing.T, s *telegramtest.Server, data []byte) string {
+	t.Helper()
+	ctx := context.Background()
+	c := s.Client()
+	msg, err := c.UploadDocument(ctx, storageChatID, "part.bin", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	file, err := c.GetFile(ctx, msg.Document.FileID)
+	if err != nil {
+		t.Fatalf("getFile: %v", err)
+	}
+	return file.FilePath
+}
+
+func TestUploadDocumentRetriesTransientFailures(t *testing.T) {
+	s := telegramtest.NewServer()
+	defer s.Close()
+	s.FailNext("sendDocument", 2, http.StatusBadGateway)
+	data := testData(64 << 10)
+
+	msg, err := s.Client().UploadDocument(context.Background(), storageChatID, "part.bin", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if msg.Document == nil || msg.Document.FileSize != int64(len(data)) {
+		t.Fatalf("uploaded document = %+v, want %d bytes", msg.Document, len(data))
+	}
+	if got := s.StoredBytes(); got != int64(len(data)) {
+		t.Fatalf("server holds %d bytes, want %d", got, len(data))
+	}
+}
+
+func TestUploadDocumentRewindsToReaderPosition(t *testing.T) {
+	s := telegramtest.NewServer()
+	defer s.Close()
+	s.FailNext("sendDocument", 1, http.StatusInternalServerError)
+	data := testData(4096)
+	reader := bytes.NewReader(data)
+	if _, err := reader.Seek(1000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := s.Client().UploadDocument(context.Background(), storageChatID, "part.bin", reader)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if want := int64(len(data) - 1000); msg.Document.FileSize != want {
+		t.Fatalf("uploaded %d bytes, want %d", msg.Document.FileSize, want)
+	}
+}
+
+func TestUploadDocumentDoesNotRetryStreams(t *testing.T) {
+	s := telegramtest.NewServer()
+	defer s.Close()
+	s.FailNext("sendDocument", 1, http.StatusBadGateway)
+
+	// A reader that cannot seek cannot be sent again.
+	stream := io.MultiReader(bytes.NewReader(testData(4096)))
+	_, err := s.Client().UploadDocument(context.Background(), storageChatID, "part.bin", stream)
+	if !telegram.IsTransient(err) {
+		t.Fatalf("upload error = %v, want the transient failure", err)
+	}
+	if got := s.StoredBytes(); got != 0 {
+		t.Fatalf("server holds %d bytes, want none", got)
+	}
+}
+
+func TestUploadDocumentGivesUpOnPermanentErrors(t *testing.T) {
+	s := telegramtest.NewServer()
+	defer s.Close()
+	s.FailNext("sendDocument", 1, http.StatusBadRequest)
+
+	_, err := s.Client().UploadDocument(context.Background(), storageChatID, "part.bin", bytes.NewReader(testData(4096)))
+	var tgErr *telegram.TelegramError
+	if !errors.As(err, &tgErr) || tgErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("upload error = %v, want a 400 TelegramError", err)
+	}
+}
+
+func TestDownloadFileResumesBrokenStreams(t *testing.T) {
+	s := telegramtest.NewServer()
+	defer s.Close()
+	data := testData(256 << 10)
+	path := upload(t, s, data)
+
+	for _, offset := range []int64{0, 70000} {
+		s.BreakDownloads(2, 50000)
+		body, err := s.Client().DownloadFile(context.Background(), path, offset)
+		if err != nil {
+			t.Fatalf("download from %d: %v", offset, err)
+		}
+		got, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatalf("read from %d: %v", offset, err)
+		}
+		if !bytes.Equal(got, data[offset:]) {
+			t.Fatalf("download from %d returned %d bytes that differ from the %d stored", offset, len(got), len(data[offset:]))
+		}
+	}
+}
+
+func TestDownloadFileStopsAfterMaxResumes(t *testing.T) {
+	s := telegramtest.NewServer()
+	defer s.Close()
+	path := upload(t, s, testData(4096))
+	c := s.Client()
+	c.MaxResumes = 1
+	// The first resume makes no progress, so the second break is final.
+	s.BreakDownloads(3, 0)
+
+	body, err := c.DownloadFile(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	defer body.Close()
+	if _, err := io.ReadAll(body); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("read error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDownloadFileReadAfterClose(t *testing.T) {
+	s := telegramtest.NewServer()
+	defer s.Close()
+	path := upload(t, s, testData(4096))
+
+	body, err := s.Client().DownloadFile(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := body.Read(make([]byte, 16)); err == nil {
+		t.Fatal("read after close succeeded")
+	}
+}
@@ -0,0 +1,86 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+)
+
+// DefaultMaxResumes is how often NewClient clients reopen a broken download
+// stream in a row.
+const DefaultMaxResumes = 5
+
+var errClosedDownload = errors.New("telegram download: read after close")
+
+// resumingReader reads a Telegram file download and, when the connection
+// drops or ends early, requests the rest with a Range header from the
+// offset reached so far.
+type resumingReader struct {
+	c         *Client
+	ctx       context.Context
+	filePath  string
+	offset    int64
+	remaining int64
+	body      io.ReadCloser
+	// failures counts resumes since the stream last yielded data.
+	failures int
+	// err is set once the stream cannot continue; body is closed by then.
+	err error
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		if r.err != nil {
+			return 0, r.err
+		}
+		n, err := r.body.Read(p)
+		if n > 0 {
+			r.offset += int64(n)
+			if r.remaining >= 0 {
+				r.remaining -= int64(n)
+			}
+			r.failures = 0
+		}
+		if err == io.EOF && r.remaining > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			// Hand over what arrived; the next Read hits the error again.
+			return n, nil
+		}
+		r.resume(err)
+	}
+}
+
+// resume reopens the stream after err, or records why it cannot be.
+func (r *resumingReader) resume(err error) {
+	r.body.Close()
+	if r.ctx.Err() != nil || r.failures >= r.c.MaxResumes {
+		r.err = err
+		return
+	}
+	r.failures++
+	if sleepErr := sleepContext(r.ctx, backoff(r.failures)); sleepErr != nil {
+		r.err = err
+		return
+	}
+	log.Printf("telegram download %s broke at byte %d (%v), resuming", r.filePath, r.offset, err)
+	body, remaining, openErr := r.c.openDownload(r.ctx, r.filePath, r.offset)
+	if openErr != nil {
+		r.err = openErr
+		return
+	}
+	r.body, r.remaining = body, remaining
+}
+
+func (r *resumingReader) Close() error {
+	if r.err != nil {
+		return nil
+	}
+	r.err = errClosedDownload
+	return r.body.Close()
+}
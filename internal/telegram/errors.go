@@ -0,0 +1,123 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// botTokenInURL matches the bot token in Bot API request URLs, which the
+// messages of *url.Error carry.
+var botTokenInURL = regexp.MustCompile(`bot[0-9]+:[A-Za-z0-9_-]+`)
+
+// RedactError returns err's message with bot tokens masked, for errors
+// that are stored or shown rather than only logged.
+func RedactError(err error) string {
+	if err == nil {
+		return ""
+	}
+	return botTokenInURL.ReplaceAllString(err.Error(), "bot<redacted>")
+}
+
+// fileReferenceErrors are description fragments Telegram returns when a
+// file_id no longer resolves to downloadable content.
+var fileReferenceErrors = []string{
+	"wrong file_id",
+	"invalid file_id",
+	"wrong remote file identifier",
+	"file reference",
+	"file_id_invalid",
+}
+
+// IsFileReferenceError reports whether err means Telegram no longer serves the
+// requested file_id, as opposed to a transient or size-related failure.
+func IsFileReferenceError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, frag := range fileReferenceErrors {
+		if strings.Contains(msg, frag) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMessageGone reports whether err means the message a request targeted no
+// longer exists, so deleting it again is pointless.
+func IsMessageGone(err error) bool {
+	var tgErr *TelegramError
+	if !errors.As(err, &tgErr) {
+		return false
+	}
+	return strings.Contains(strings.ToLower(tgErr.Description), "message to delete not found")
+}
+
+// TelegramError is an error answer from the Bot API.
+type TelegramError struct {
+	Method      string
+	StatusCode  int
+	ErrorCode   int
+	Description string
+	// RetryAfter is the flood wait Telegram asked for on error_code 429.
+	RetryAfter time.Duration
+	// MigrateToChatID is the supergroup a group chat was upgraded to, when
+	// the request went to the old group id.
+	MigrateToChatID int64
+}
+
+func (e *TelegramError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("telegram %s failed: status %d", e.Method, e.StatusCode)
+	}
+	return fmt.Sprintf("telegram %s failed: %s", e.Method, e.Description)
+}
+
+// Temporary reports whether repeating the request later may succeed: flood
+// waits and server-side failures are temporary, bad requests are not.
+func (e *TelegramError) Temporary() bool {
+	code := e.ErrorCode
+	if code == 0 {
+		code = e.StatusCode
+	}
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// IsTransient reports whether err is worth retrying: a temporary
+// TelegramError or a request that never got an answer.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var tgErr *TelegramError
+	if errors.As(err, &tgErr) {
+		return tgErr.Temporary()
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// MigrateToChatID returns the chat id err says the target group moved to,
+// or zero.
+func MigrateToChatID(err error) int64 {
+	var tgErr *TelegramError
+	if errors.As(err, &tgErr) {
+		return tgErr.MigrateToChatID
+	}
+	return 0
+}
+
+// RetryAfter returns the flood wait carried by err, or zero.
+func RetryAfter(err error) time.Duration {
+	var tgErr *TelegramError
+	if errors.As(err, &tgErr) {
+		return tgErr.RetryAfter
+	}
+	return 0
+}
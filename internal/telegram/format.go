@@ -0,0 +1,44 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Parse modes accepted by SendFormattedMessage and EditFormattedMessageText.
+const (
+	ParseModeHTML       = "HTML"
+	ParseModeMarkdownV2 = "MarkdownV2"
+)
+
+// EscapeHTML escapes text for use in an HTML formatted message.
+func EscapeHTML(text string) string {
+	return html.EscapeString(text)
+}
+
+// Bold wraps text in HTML bold tags, escaping it.
+func Bold(text string) string {
+	return "<b>" + EscapeHTML(text) + "</b>"
+}
+
+// Code wraps text in HTML monospace tags, escaping it.
+func Code(text string) string {
+	return "<code>" + EscapeHTML(text) + "</code>"
+}
+
+// Link builds an HTML link to url labelled text.
+func Link(url, text string) string {
+	return fmt.Sprintf(`<a href="%s">%s</a>`, EscapeHTML(url), EscapeHTML(text))
+}
+
+var markdownV2Escaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`,
+	"|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// EscapeMarkdownV2 escapes text for use in a MarkdownV2 formatted message.
+func EscapeMarkdownV2(text string) string {
+	return markdownV2Escaper.Replace(text)
+}
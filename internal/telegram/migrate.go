@@ -0,0 +1,89 @@
+package telegram
+
+import "sync"
+
+// chatMigrations remembers which group chats were upgraded to supergroups,
+// so requests addressed to the old id go to the new one.
+type chatMigrations struct {
+	mu sync.Mutex
+	to map[int64]int64
+}
+
+// SetChatMigration records that chat from now lives at chat to, for example
+// from migrations seen before a restart. Requests to from are sent to to.
+func (c *Client) SetChatMigration(from, to int64) {
+	c.migrations.mu.Lock()
+	defer c.migrations.mu.Unlock()
+	if c.migrations.to == nil {
+		c.migrations.to = make(map[int64]int64)
+	}
+	c.migrations.to[from] = to
+}
+
+// ResolveChat returns the chat id requests to chatID are sent to.
+func (c *Client) ResolveChat(chatID int64) int64 {
+	c.migrations.mu.Lock()
+	defer c.migrations.mu.Unlock()
+	// Follow chains, but stop on a cycle a bad seed could create.
+	for i := 0; i < 8; i++ {
+		to, ok := c.migrations.to[chatID]
+		if !ok {
+			break
+		}
+		chatID = to
+	}
+	return chatID
+}
+
+// noteMigration records the migration err reports for a request to chatID
+// and returns the chat to repeat it on. OnChatMigrated hears about each new
+// migration once.
+func (c *Client) noteMigration(chatID int64, err error) (int64, bool) {
+	to := MigrateToChatID(err)
+	if to == 0 || chatID == 0 || to == chatID {
+		return 0, false
+	}
+	c.migrations.mu.Lock()
+	known := c.migrations.to[chatID] == to
+	if !known {
+		if c.migrations.to == nil {
+			c.migrations.to = make(map[int64]int64)
+		}
+		c.migrations.to[chatID] = to
+	}
+	c.migrations.mu.Unlock()
+	if !known && c.OnChatMigrated != nil {
+		c.OnChatMigrated(chatID, to)
+	}
+	return to, true
+}
+
+// migratePayload returns payload with chat ids of migrated chats replaced.
+func (c *Client) migratePayload(payload any) any {
+	m, ok := payload.(map[string]any)
+	if !ok {
+		return payload
+	}
+	var out map[string]any
+	for _, key := range []string{"chat_id", "from_chat_id"} {
+		id := payloadInt64(m[key])
+		if id == 0 {
+			continue
+		}
+		to := c.ResolveChat(id)
+		if to == id {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]any, len(m))
+			for k, v := range m {
+				out[k] = v
+			}
+		}
+		out[key] = to
+	}
+	if out == nil {
+		return payload
+	}
+	return out
+}
@@ -0,0 +1,28 @@
+package telegram
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ProxyTransport returns an HTTP transport that sends every request,
+// including file downloads, through proxyURL. http, https, socks5 and
+// socks5h URLs are supported; credentials go in the user info part.
+func ProxyTransport(proxyURL string) (*http.Transport, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("proxy url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy url %q: scheme must be http, https, socks5 or socks5h", u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy url %q has no host", u.Redacted())
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(u)
+	return transport, nil
+}
@@ -0,0 +1,154 @@
+package telegram
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate allows one event every Every, with up to Burst events at once.
+type Rate struct {
+	Every time.Duration
+	Burst int
+}
+
+// RateLimits are the client-side budgets for calls that post or edit
+// messages. Groups and channels have negative chat ids.
+type RateLimits struct {
+	Global  Rate
+	PerChat Rate
+	Group   Rate
+}
+
+// DefaultRateLimits follow the Bot API FAQ: about 30 messages a second
+// overall, one a second per chat and 20 a minute per group.
+var DefaultRateLimits = RateLimits{
+	Global:  Rate{Every: time.Second / 30, Burst: 30},
+	PerChat: Rate{Every: time.Second, Burst: 3},
+	Group:   Rate{Every: 3 * time.Second, Burst: 20},
+}
+
+// idleChatBuckets is how long an unused per-chat budget is kept.
+const idleChatBuckets = 10 * time.Minute
+
+// rateLimited reports whether Telegram counts method against flood limits:
+// every call that posts or edits a message. Chat actions only show a
+// status and are left alone.
+func rateLimited(method string) bool {
+	switch method {
+	case "sendChatAction":
+		return false
+	case "copyMessage", "forwardMessage":
+		return true
+	}
+	return strings.HasPrefix(method, "send") || strings.HasPrefix(method, "edit")
+}
+
+// RateLimiter delays message-sending calls so bursts stay inside Telegram's
+// limits instead of running into flood waits.
+type RateLimiter struct {
+	limits RateLimits
+
+	mu     sync.Mutex
+	global bucket
+	chats  map[int64]*bucket
+	swept  time.Time
+}
+
+// NewRateLimiter creates a limiter enforcing limits.
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	return &RateLimiter{limits: limits, chats: make(map[int64]*bucket)}
+}
+
+// Wait blocks until a call for method to chatID fits the budget. Methods
+// Telegram does not rate limit return at once.
+func (l *RateLimiter) Wait(ctx context.Context, method string, chatID int64) error {
+	if l == nil || !rateLimited(method) {
+		return nil
+	}
+	if d := l.reserveChat(chatID); d > 0 {
+		if err := sleepContext(ctx, d); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	d := l.global.reserve(time.Now(), l.limits.Global)
+	l.mu.Unlock()
+	if d > 0 {
+		return sleepContext(ctx, d)
+	}
+	return nil
+}
+
+func (l *RateLimiter) reserveChat(chatID int64) time.Duration {
+	if chatID == 0 {
+		return 0
+	}
+	rate := l.limits.PerChat
+	if chatID < 0 {
+		rate = l.limits.Group
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > idleChatBuckets {
+		l.swept = now
+		for id, b := range l.chats {
+			if now.Sub(b.tat) > idleChatBuckets {
+				delete(l.chats, id)
+			}
+		}
+	}
+	b := l.chats[chatID]
+	if b == nil {
+		b = &bucket{}
+		l.chats[chatID] = b
+	}
+	return b.reserve(now, rate)
+}
+
+// bucket is a generic cell rate limiter: tat is the theoretical arrival
+// time of the next event when events come at exactly the permitted rate.
+type bucket struct {
+	tat time.Time
+}
+
+// reserve books the next event and returns how long to wait before it.
+func (b *bucket) reserve(now time.Time, rate Rate) time.Duration {
+	if rate.Every <= 0 {
+		return 0
+	}
+	burst := rate.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	if b.tat.Before(now) {
+		b.tat = now
+	}
+	allowAt := b.tat.Add(-rate.Every * time.Duration(burst-1))
+	b.tat = b.tat.Add(rate.Every)
+	if allowAt.After(now) {
+		return allowAt.Sub(now)
+	}
+	return 0
+}
+
+// payloadChatID extracts chat_id from a JSON payload map.
+func payloadChatID(payload any) int64 {
+	m, ok := payload.(map[string]any)
+	if !ok {
+		return 0
+	}
+	return payloadInt64(m["chat_id"])
+}
+
+func payloadInt64(v any) int64 {
+	switch id := v.(type) {
+	case int64:
+		return id
+	case int:
+		return int64(id)
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is how often NewClient clients repeat a request that
+	// failed transiently.
+	DefaultMaxRetries = 4
+	retryBaseDelay    = 500 * time.Millisecond
+	retryMaxDelay     = 30 * time.Second
+	// maxFloodWait is the longest retry_after honoured inline. Longer flood
+	// waits are returned to the caller as a TelegramError instead.
+	maxFloodWait = time.Minute
+)
+
+// apiStatus is the part of every Bot API answer that describes failures.
+type apiStatus struct {
+	OK          bool                `json:"ok"`
+	Description string              `json:"description"`
+	ErrorCode   int                 `json:"error_code"`
+	Parameters  *responseParameters `json:"parameters,omitempty"`
+}
+
+type responseParameters struct {
+	RetryAfter      int   `json:"retry_after,omitempty"`
+	MigrateToChatID int64 `json:"migrate_to_chat_id,omitempty"`
+}
+
+// retryDelay reports how long to wait before repeating a request after its
+// attempt-th failure, or false when err should be returned as is.
+func (c *Client) retryDelay(attempt int, err error) (time.Duration, bool) {
+	if attempt > c.MaxRetries || !IsTransient(err) {
+		return 0, false
+	}
+	if wait := RetryAfter(err); wait > 0 {
+		if wait > maxFloodWait {
+			return 0, false
+		}
+		return wait, true
+	}
+	return backoff(attempt), true
+}
+
+// backoff doubles the delay per attempt and picks a random point in its
+// upper half, so clients that failed together do not retry together.
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << (attempt - 1)
+	if d <= 0 || d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// decodeResponse reads a Bot API answer into out, turning error answers into
+// a TelegramError. out may be nil when the result is not needed.
+func decodeResponse(method string, resp *http.Response, out any) error {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var status apiStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		if resp.StatusCode >= 300 {
+			return &TelegramError{Method: method, StatusCode: resp.StatusCode, Description: resp.Status}
+		}
+		if out == nil {
+			return nil
+		}
+		return err
+	}
+	if !status.OK {
+		tgErr := &TelegramError{Method: method, StatusCode: resp.StatusCode, ErrorCode: status.ErrorCode, Description: status.Description}
+		if p := status.Parameters; p != nil {
+			if p.RetryAfter > 0 {
+				tgErr.RetryAfter = time.Duration(p.RetryAfter) * time.Second
+			}
+			tgErr.MigrateToChatID = p.MigrateToChatID
+		}
+		return tgErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,377 @@
+package telegramtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"pigpak/internal/telegram"
+)
+
+// Fake is an in-memory telegram.BotAPI. It keeps every chat's messages and
+// stored documents so tests can drive the bot and the upload pipeline and
+// inspect what they sent, without HTTP.
+type Fake struct {
+	// Me is returned by GetMe.
+	Me telegram.User
+
+	mu       sync.Mutex
+	pending  []telegram.Update
+	wake     chan struct{}
+	nextUpd  int
+	nextMsg  int
+	chats    map[int64][]telegram.Message
+	files    map[string][]byte
+	actions  map[int64][]string
+	answered []string
+	groups   map[int64]telegram.Chat
+	members  map[int64]map[int64]telegram.ChatMember
+}
+
+// NewFake creates an empty fake.
+func NewFake() *Fake {
+	return &Fake{
+		Me:      telegram.User{ID: 1, Username: "pigpak_test_bot", FirstName: "pigpak"},
+		wake:    make(chan struct{}),
+		chats:   make(map[int64][]telegram.Message),
+		files:   make(map[string][]byte),
+		actions: make(map[int64][]string),
+		groups:  make(map[int64]telegram.Chat),
+		members: make(map[int64]map[int64]telegram.ChatMember),
+	}
+}
+
+var _ telegram.BotAPI = (*Fake)(nil)
+
+// PushUpdate queues an update for GetUpdates and gives it the next id.
+func (f *Fake) PushUpdate(upd telegram.Update) {
+	f.mu.Lock()
+	f.nextUpd++
+	upd.UpdateID = f.nextUpd
+	if upd.CallbackQuery != nil && upd.CallbackQuery.ID == "" {
+		upd.CallbackQuery.ID = "cb" + strconv.Itoa(upd.UpdateID)
+	}
+	f.pending = append(f.pending, upd)
+	close(f.wake)
+	f.wake = make(chan struct{})
+	f.mu.Unlock()
+}
+
+// AddChat makes chat known to GetChat with the given members, for tests of
+// channels and groups the bot is asked to use.
+func (f *Fake) AddChat(chat telegram.Chat, members ...telegram.ChatMember) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.groups[chat.ID] = chat
+	if f.members[chat.ID] == nil {
+		f.members[chat.ID] = make(map[int64]telegram.ChatMember)
+	}
+	for _, m := range members {
+		f.members[chat.ID][m.User.ID] = m
+	}
+}
+
+// SendText queues a text message from user in their private chat.
+func (f *Fake) SendText(user telegram.User, text string) {
+	f.PushUpdate(telegram.Update{Message: &telegram.Message{
+		MessageID: f.messageID(),
+		From:      &user,
+		Chat:      telegram.Chat{ID: user.ID, Type: "private"},
+		Date:      time.Now().Unix(),
+		Text:      text,
+	}})
+}
+
+// SendFile queues a document upload from user and stores its content.
+func (f *Fake) SendFile(user telegram.User, name string, data []byte) {
+	doc := f.store(name, data)
+	f.PushUpdate(telegram.Update{Message: &telegram.Message{
+		MessageID: f.messageID(),
+		From:      &user,
+		Chat:      telegram.Chat{ID: user.ID, Type: "private"},
+		Date:      time.Now().Unix(),
+		Document:  &doc,
+	}})
+}
+
+// Press queues a callback for an inline button on message msg.
+func (f *Fake) Press(user telegram.User, msg telegram.Message, data string) {
+	f.PushUpdate(telegram.Update{CallbackQuery: &telegram.CallbackQuery{
+		From:    &user,
+		Message: &msg,
+		Data:    data,
+	}})
+}
+
+// Messages returns the messages currently in chatID, oldest first.
+func (f *Fake) Messages(chatID int64) []telegram.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]telegram.Message(nil), f.chats[chatID]...)
+}
+
+// Actions returns the chat actions sent to chatID.
+func (f *Fake) Actions(chatID int64) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.actions[chatID]...)
+}
+
+// Answered returns the ids of the callback queries answered so far.
+func (f *Fake) Answered() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.answered...)
+}
+
+// Content returns the stored bytes behind fileID.
+func (f *Fake) Content(fileID string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.files[fileID]
+	return data, ok
+}
+
+// Forget drops stored content, as if Telegram stopped serving fileID.
+func (f *Fake) Forget(fileID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.files, fileID)
+}
+
+func (f *Fake) GetUpdates(ctx context.Context, offset, limit, timeoutSec int) ([]telegram.Update, error) {
+	deadline := time.NewTimer(time.Duration(timeoutSec) * time.Second)
+	defer deadline.Stop()
+	for {
+		f.mu.Lock()
+		kept := f.pending[:0]
+		for _, upd := range f.pending {
+			if upd.UpdateID >= offset {
+				kept = append(kept, upd)
+			}
+		}
+		f.pending = kept
+		wake := f.wake
+		if len(kept) > 0 || timeoutSec <= 0 {
+			if limit <= 0 || limit > 100 {
+				limit = 100
+			}
+			if len(kept) > limit {
+				kept = kept[:limit]
+			}
+			out := append([]telegram.Update(nil), kept...)
+			f.mu.Unlock()
+			return out, nil
+		}
+		f.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return nil, nil
+		case <-wake:
+		}
+	}
+}
+
+func (f *Fake) GetMe(ctx context.Context) (*telegram.User, error) {
+	me := f.Me
+	return &me, nil
+}
+
+func (f *Fake) GetChat(ctx context.Context, chatID int64) (*telegram.Chat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	chat, ok := f.groups[chatID]
+	if !ok {
+		return nil, notFound("getChat", "Bad Request: chat not found")
+	}
+	return &chat, nil
+}
+
+func (f *Fake) GetChatMember(ctx context.Context, chatID, userID int64) (*telegram.ChatMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.groups[chatID]; !ok {
+		return nil, notFound("getChatMember", "Bad Request: chat not found")
+	}
+	m, ok := f.members[chatID][userID]
+	if !ok {
+		m = telegram.ChatMember{User: telegram.User{ID: userID}, Status: "left"}
+	}
+	return &m, nil
+}
+
+func (f *Fake) SendMessage(ctx context.Context, chatID int64, text string, markup *telegram.InlineKeyboardMarkup) (*telegram.Message, error) {
+	return f.post(telegram.Message{Chat: telegram.Chat{ID: chatID}, Text: text}), nil
+}
+
+func (f *Fake) SendFormattedMessage(ctx context.Context, chatID int64, text, parseMode string, markup *telegram.InlineKeyboardMarkup) (*telegram.Message, error) {
+	return f.SendMessage(ctx, chatID, text, markup)
+}
+
+func (f *Fake) EditMessageText(ctx context.Context, chatID int64, messageID int, text string, markup *telegram.InlineKeyboardMarkup) (*telegram.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, msg := range f.chats[chatID] {
+		if msg.MessageID == messageID {
+			f.chats[chatID][i].Text = text
+			edited := f.chats[chatID][i]
+			return &edited, nil
+		}
+	}
+	return nil, notFound("editMessageText", "Bad Request: message to edit not found")
+}
+
+func (f *Fake) EditFormattedMessageText(ctx context.Context, chatID int64, messageID int, text, parseMode string, markup *telegram.InlineKeyboardMarkup) (*telegram.Message, error) {
+	return f.EditMessageText(ctx, chatID, messageID, text, markup)
+}
+
+func (f *Fake) AnswerCallbackQuery(ctx context.Context, callbackID, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answered = append(f.answered, callbackID)
+	return nil
+}
+
+func (f *Fake) DeleteMessage(ctx context.Context, chatID int64, messageID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	msgs := f.chats[chatID]
+	for i, msg := range msgs {
+		if msg.MessageID == messageID {
+			f.chats[chatID] = append(msgs[:i:i], msgs[i+1:]...)
+			return nil
+		}
+	}
+	return notFound("deleteMessage", "Bad Request: message to delete not found")
+}
+
+func (f *Fake) SendChatAction(ctx context.Context, chatID int64, action string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.actions[chatID] = append(f.actions[chatID], action)
+	return nil
+}
+
+func (f *Fake) SendDocument(ctx context.Context, chatID int64, fileID, caption string, markup *telegram.InlineKeyboardMarkup) (*telegram.Message, error) {
+	data, ok := f.Content(fileID)
+	if !ok {
+		return nil, notFound("sendDocument", "Bad Request: wrong file identifier/HTTP URL specified")
+	}
+	doc := telegram.Document{FileID: fileID, FileUniqueID: fileID, MimeType: "application/octet-stream", FileSize: int64(len(data))}
+	return f.post(telegram.Message{Chat: telegram.Chat{ID: chatID}, Caption: caption, Document: &doc}), nil
+}
+
+func (f *Fake) CopyMessage(ctx context.Context, chatID, fromChatID int64, messageID int) (int, error) {
+	f.mu.Lock()
+	var src *telegram.Message
+	for _, msg := range f.chats[fromChatID] {
+		if msg.MessageID == messageID {
+			msg := msg
+			src = &msg
+			break
+		}
+	}
+	f.mu.Unlock()
+	if src == nil {
+		return 0, notFound("copyMessage", "Bad Request: message to copy not found")
+	}
+	src.Chat = telegram.Chat{ID: chatID}
+	return f.post(*src).MessageID, nil
+}
+
+func (f *Fake) UploadDocument(ctx context.Context, chatID int64, filename string, reader io.Reader) (*telegram.Message, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	doc := f.store(filename, data)
+	return f.post(telegram.Message{Chat: telegram.Chat{ID: chatID}, Document: &doc}), nil
+}
+
+func (f *Fake) UploadVideo(ctx context.Context, chatID int64, filename string, reader io.Reader, meta telegram.VideoMeta) (*telegram.Message, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	doc := f.store(filename, data)
+	video := telegram.Video{
+		FileID:       doc.FileID,
+		FileUniqueID: doc.FileUniqueID,
+		FileName:     filename,
+		MimeType:     "video/mp4",
+		FileSize:     doc.FileSize,
+		Duration:     meta.Duration,
+		Width:        meta.Width,
+		Height:       meta.Height,
+	}
+	return f.post(telegram.Message{Chat: telegram.Chat{ID: chatID}, Video: &video}), nil
+}
+
+func (f *Fake) UploadPhoto(ctx context.Context, chatID int64, filename string, reader io.Reader, caption string, markup *telegram.InlineKeyboardMarkup) (*telegram.Message, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	doc := f.store(filename, data)
+	photo := telegram.PhotoSize{FileID: doc.FileID, FileUniqueID: doc.FileUniqueID, FileSize: doc.FileSize}
+	return f.post(telegram.Message{Chat: telegram.Chat{ID: chatID}, Photo: []telegram.PhotoSize{photo}, Caption: caption}), nil
+}
+
+func (f *Fake) GetFile(ctx context.Context, fileID string) (*telegram.File, error) {
+	data, ok := f.Content(fileID)
+	if !ok {
+		return nil, notFound("getFile", "Bad Request: invalid file_id")
+	}
+	return &telegram.File{FileID: fileID, FileUniqueID: fileID, FileSize: int64(len(data)), FilePath: fileID}, nil
+}
+
+func (f *Fake) DownloadFile(ctx context.Context, filePath string, offset int64) (io.ReadCloser, error) {
+	data, ok := f.Content(filePath)
+	if !ok {
+		return nil, &telegram.TelegramError{Method: "file download", StatusCode: http.StatusNotFound, Description: "404 Not Found"}
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return io.NopCloser(bytes.NewReader(data[offset:])), nil
+}
+
+// store keeps data under a content-derived id, like Telegram's
+// file_unique_id, and describes it as a document.
+func (f *Fake) store(name string, data []byte) telegram.Document {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:12])
+	f.mu.Lock()
+	f.files[id] = append([]byte(nil), data...)
+	f.mu.Unlock()
+	return telegram.Document{FileID: id, FileUniqueID: id, FileName: name, MimeType: "application/octet-stream", FileSize: int64(len(data))}
+}
+
+func (f *Fake) messageID() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextMsg++
+	return f.nextMsg
+}
+
+// post appends msg to its chat with the next message id.
+func (f *Fake) post(msg telegram.Message) *telegram.Message {
+	msg.MessageID = f.messageID()
+	msg.Date = time.Now().Unix()
+	f.mu.Lock()
+	f.chats[msg.Chat.ID] = append(f.chats[msg.Chat.ID], msg)
+	f.mu.Unlock()
+	return &msg
+}
+
+func notFound(method, description string) error {
+	return &telegram.TelegramError{Method: method, StatusCode: http.StatusBadRequest, ErrorCode: http.StatusBadRequest, Description: description}
+}
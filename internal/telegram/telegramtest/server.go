@@ -0,0 +1,309 @@
+// Package telegramtest provides an in-memory Bot API server for exercising
+// the Telegram client without talking to Telegram.
+package telegramtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pigpak/internal/telegram"
+)
+
+// Token is the bot token the fake server accepts.
+const Token = "test:token"
+
+// Server is a fake Bot API. It implements the methods pigpak uses for
+// storage: sendDocument, sendVideo, copyMessage, getFile, file downloads and
+// deleteMessage, plus getMe and no-op answers for message sending and
+// editing.
+type Server struct {
+	// URL is the base URL to pass to telegram.NewClient.
+	URL string
+	// Latency is added to every request before it is answered.
+	Latency time.Duration
+
+	srv    *httptest.Server
+	mu     sync.Mutex
+	nextID int
+	files  map[string][]byte
+	// failures counts the calls per method still to be failed, with the
+	// status to fail them with.
+	failures map[string]failure
+	// breaks counts downloads still to be cut off after breakAt bytes.
+	breaks  int
+	breakAt int64
+}
+
+type failure struct {
+	n      int
+	status int
+}
+
+// NewServer starts a fake Bot API server. Close it when done.
+func NewServer() *Server {
+	s := &Server{files: make(map[string][]byte), failures: make(map[string]failure)}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Client returns a client talking to the server.
+func (s *Server) Client() *telegram.Client {
+	return telegram.NewClient(Token, s.URL, 0)
+}
+
+// FailNext makes the next n calls to method fail with status, as Telegram
+// does when it is overloaded.
+func (s *Server) FailNext(method string, n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = failure{n: n, status: status}
+}
+
+// BreakDownloads makes the next n file downloads drop the connection after
+// at most limit bytes of the body.
+func (s *Server) BreakDownloads(n int, limit int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breaks, s.breakAt = n, limit
+}
+
+// StoredBytes reports how much content the server currently holds.
+func (s *Server) StoredBytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, data := range s.files {
+		n += int64(len(data))
+	}
+	return n
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if s.Latency > 0 {
+		time.Sleep(s.Latency)
+	}
+	if path, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+Token+"/"); ok {
+		s.serveDownload(w, r, path)
+		return
+	}
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+Token+"/")
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if status := s.takeFailure(method); status != 0 {
+		_, _ = io.Copy(io.Discard, r.Body)
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+	switch method {
+	case "getMe":
+		writeResult(w, telegram.User{ID: 1, Username: "pigpak_test_bot", FirstName: "pigpak"})
+	case "sendDocument":
+		s.serveSendDocument(w, r, "document")
+	case "sendVideo":
+		s.serveSendDocument(w, r, "video")
+	case "getFile":
+		var req struct {
+			FileID string `json:"file_id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		s.mu.Lock()
+		data, ok := s.files[req.FileID]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Bad Request: invalid file_id")
+			return
+		}
+		writeResult(w, telegram.File{FileID: req.FileID, FileUniqueID: req.FileID, FileSize: int64(len(data)), FilePath: "documents/" + req.FileID})
+	case "sendMessage", "editMessageText":
+		var req struct {
+			ChatID int64  `json:"chat_id"`
+			Text   string `json:"text"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		writeResult(w, telegram.Message{MessageID: s.messageID(), Chat: telegram.Chat{ID: req.ChatID}, Text: req.Text, Date: time.Now().Unix()})
+	case "copyMessage":
+		writeResult(w, map[string]int{"message_id": s.messageID()})
+	case "answerCallbackQuery", "deleteMessage", "sendChatAction":
+		writeResult(w, true)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found: method not found")
+	}
+}
+
+// takeFailure returns the status to fail this call to method with, or 0.
+func (s *Server) takeFailure(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.failures[method]
+	if f.n == 0 {
+		return 0
+	}
+	f.n--
+	s.failures[method] = f
+	return f.status
+}
+
+func (s *Server) messageID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	return s.nextID
+}
+
+// serveSendDocument stores the file uploaded in form field and answers
+// with a document or, for "video", a video message.
+func (s *Server) serveSendDocument(w http.ResponseWriter, r *http.Request, field string) {
+	if field == "document" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		s.serveResendDocument(w, r)
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+	var chatID int64
+	var name string
+	var data []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+			return
+		}
+		switch part.FormName() {
+		case "chat_id":
+			raw, _ := io.ReadAll(part)
+			chatID, _ = strconv.ParseInt(string(raw), 10, 64)
+		case field:
+			name = part.FileName()
+			if data, err = io.ReadAll(part); err != nil {
+				writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+				return
+			}
+		}
+		part.Close()
+	}
+	if data == nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: there is no "+field+" in the request")
+		return
+	}
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:12])
+	s.mu.Lock()
+	s.files[id] = data
+	s.mu.Unlock()
+	if field == "video" {
+		writeResult(w, telegram.Message{
+			MessageID: s.messageID(),
+			Chat:      telegram.Chat{ID: chatID},
+			Date:      time.Now().Unix(),
+			Video: &telegram.Video{
+				FileID:       id,
+				FileUniqueID: id,
+				FileName:     name,
+				MimeType:     "video/mp4",
+				FileSize:     int64(len(data)),
+			},
+		})
+		return
+	}
+	s.writeDocument(w, chatID, id, name, int64(len(data)))
+}
+
+// serveResendDocument answers sendDocument calls that pass a known file_id.
+func (s *Server) serveResendDocument(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChatID   int64  `json:"chat_id"`
+		Document string `json:"document"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	s.mu.Lock()
+	data, ok := s.files[req.Document]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Bad Request: wrong file identifier/HTTP URL specified")
+		return
+	}
+	s.writeDocument(w, req.ChatID, req.Document, "", int64(len(data)))
+}
+
+func (s *Server) writeDocument(w http.ResponseWriter, chatID int64, id, name string, size int64) {
+	writeResult(w, telegram.Message{
+		MessageID: s.messageID(),
+		Chat:      telegram.Chat{ID: chatID},
+		Date:      time.Now().Unix(),
+		Document: &telegram.Document{
+			FileID:       id,
+			FileUniqueID: id,
+			FileName:     name,
+			MimeType:     "application/octet-stream",
+			FileSize:     size,
+		},
+	})
+}
+
+func (s *Server) serveDownload(w http.ResponseWriter, r *http.Request, path string) {
+	id := strings.TrimPrefix(path, "documents/")
+	s.mu.Lock()
+	data, ok := s.files[id]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var start int64
+	if spec, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes="); ok {
+		start, _ = strconv.ParseInt(strings.TrimSuffix(spec, "-"), 10, 64)
+	}
+	if start < 0 || start > int64(len(data)) {
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)-int(start)))
+	if start > 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	body := data[start:]
+	s.mu.Lock()
+	if s.breaks > 0 && int64(len(body)) > s.breakAt {
+		s.breaks--
+		body = body[:s.breakAt]
+	}
+	s.mu.Unlock()
+	// A body shorter than Content-Length makes the server drop the
+	// connection, which the client sees as an unexpected EOF.
+	_, _ = w.Write(body)
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func writeError(w http.ResponseWriter, status int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": status, "description": description})
+}
@@ -0,0 +1,33 @@
+package webdav
+
+import (
+	"context"
+	"net/http"
+
+	"pigpak/internal/db"
+)
+
+// serveToken authenticates an API token used as an app password for
+// userID and runs next, read-only when the token is.
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request, next http.Handler, userID int64, token string) {
+	t, ok, err := s.store.VerifyAPIToken(r.Context(), token, db.TokenScopeWebDAV)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok || t.UserID != userID {
+		s.recordFailedLogin(r, userID)
+		w.Header().Set("WWW-Authenticate", `Basic realm="webdav"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	ctx := r.Context()
+	if t.ReadOnly {
+		if isWriteMethod(r.Method) {
+			http.Error(w, "read-only credentials", http.StatusForbidden)
+			return
+		}
+		ctx = context.WithValue(ctx, webdavScopeKey{}, davScope{readOnly: true})
+	}
+	s.serveUser(ctx, w, r, next, userID)
+}
@@ -0,0 +1,176 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"pigpak/internal/db"
+)
+
+// Chunked uploads are resumable uploads sent over HTTP outside WebDAV, as
+// the API's tus endpoint does: CreateUpload starts one of a known size and
+// WriteUpload appends the chunks. They are WebDAV upload sessions, so they
+// go through the same part pipeline, are resumed from the parts stored so
+// far, and expire with WEB_DAV_UPLOAD_TTL.
+var (
+	// ErrUploadOffset is returned for a chunk that does not start where the
+	// upload stands.
+	ErrUploadOffset = errors.New("chunk does not start at the upload's offset")
+	// ErrUploadTooLarge is returned for a chunk that runs past the size the
+	// upload was created with.
+	ErrUploadTooLarge = errors.New("chunk runs past the upload's size")
+	// ErrUploadsBusy is returned when the user or the server runs as many
+	// uploads as WEB_DAV_MAX_USER_UPLOADS or WEB_DAV_MAX_UPLOADS allow.
+	ErrUploadsBusy = errors.New("too many uploads in progress, retry later")
+)
+
+// UploadRetryAfter is how many seconds a client turned away with
+// ErrUploadsBusy should wait.
+const UploadRetryAfter = uploadRetryAfter
+
+// CreateUpload starts a chunked upload of size bytes into userID's folder
+// dirID under name, replacing the file there, if any, once it completes. An
+// unfinished upload to the same name is dropped. An empty upload is stored
+// at once and returned with the ID 0. Uploads that would not fit in the
+// user's quota fail with db.ErrQuotaExceeded before any byte is sent.
+func (s *Server) CreateUpload(ctx context.Context, userID, dirID int64, name string, size int64) (db.WebDAVUpload, error) {
+	if size < 0 {
+		return db.WebDAVUpload{}, fmt.Errorf("negative upload size: %w", os.ErrInvalid)
+	}
+	if _, err := s.store.GetDirByID(ctx, userID, dirID); err != nil {
+		return db.WebDAVUpload{}, err
+	}
+	if _, err := s.store.GetDirByName(ctx, userID, dirID, name); err == nil {
+		return db.WebDAVUpload{}, fmt.Errorf("a folder is named %s: %w", name, os.ErrExist)
+	}
+	if _, err := s.uploadTarget(ctx, userID, dirID); err != nil {
+		return db.WebDAVUpload{}, err
+	}
+	if err := s.store.CheckQuota(ctx, userID, name, size-replacedSize(ctx, s.store, userID, dirID, name)); err != nil {
+		return db.WebDAVUpload{}, err
+	}
+	if old, err := s.store.GetWebDAVUpload(ctx, userID, dirID, name); err == nil {
+		if err := s.store.DeleteWebDAVUpload(ctx, old.ID); err != nil {
+			return db.WebDAVUpload{}, err
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return db.WebDAVUpload{}, err
+	}
+	up, err := s.store.CreateWebDAVUpload(ctx, userID, dirID, name, size)
+	if err != nil {
+		return db.WebDAVUpload{}, err
+	}
+	if size == 0 {
+		if _, _, err := s.WriteUpload(ctx, userID, up.ID, 0, 0, bytes.NewReader(nil)); err != nil {
+			return db.WebDAVUpload{}, err
+		}
+		up.ID = 0
+	}
+	return up, nil
+}
+
+// WriteUpload appends body, contentLength bytes long or -1 when unknown, to
+// userID's chunked upload uploadID at offset. It returns the upload's new
+// offset and, once the last byte has arrived, the ID of the file stored.
+//
+// Parts go to Telegram as the chunk streams in, and a chunk's end ends its
+// last part: chunks should be large, or the whole rest of the file. When a
+// chunk is cut short, the part it was sending is lost and the upload stays
+// at the end of the last stored one, where the client resumes.
+func (s *Server) WriteUpload(ctx context.Context, userID, uploadID, offset, contentLength int64, body io.Reader) (int64, int64, error) {
+	if !s.uploads.acquire(userID) {
+		return 0, 0, ErrUploadsBusy
+	}
+	defer s.uploads.release(userID)
+	up, err := s.store.GetWebDAVUploadByID(ctx, userID, uploadID)
+	if err != nil {
+		return 0, 0, err
+	}
+	session, err := loadUploadSession(ctx, s.store, userID, up.DirID, up.Name)
+	if err != nil {
+		return 0, 0, err
+	}
+	if session == nil || session.id != up.ID {
+		return 0, 0, sql.ErrNoRows
+	}
+	if offset != session.uploadedSize {
+		return session.uploadedSize, 0, ErrUploadOffset
+	}
+	remaining := up.TotalSize - offset
+	if contentLength > remaining {
+		return offset, 0, ErrUploadTooLarge
+	}
+	target, err := s.uploadTarget(ctx, userID, up.DirID)
+	if err != nil {
+		return offset, 0, err
+	}
+	var existing *db.File
+	if file, err := s.store.GetFileByName(ctx, userID, up.DirID, up.Name); err == nil {
+		existing = &file
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return offset, 0, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	f := openSessionFile(ctx, s.tg, s.store, userID, target.storageChatID, up.DirID, up.Name, existing, target.partSize, up.TotalSize > target.partSize, contentLength, session, nil)
+	f.expectSize = up.TotalSize
+	// One byte past the upload's size is read to tell a chunk that runs
+	// over from one that ends on it.
+	n, err := io.Copy(f, io.LimitReader(body, remaining+1))
+	if err == nil && n > remaining {
+		err = ErrUploadTooLarge
+	}
+	if err != nil {
+		f.mu.Lock()
+		f.abortLocked(err)
+		f.mu.Unlock()
+		_ = f.Close()
+		return s.uploadOffset(ctx, userID, uploadID), 0, err
+	}
+	if err := f.Close(); err != nil {
+		return s.uploadOffset(ctx, userID, uploadID), 0, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.totalSize, f.fileID, nil
+}
+
+// uploadOffset returns how much of an upload is stored, after a chunk that
+// failed.
+func (s *Server) uploadOffset(ctx context.Context, userID, uploadID int64) int64 {
+	up, err := s.store.GetWebDAVUploadByID(context.WithoutCancel(ctx), userID, uploadID)
+	if err != nil {
+		return 0
+	}
+	return up.UploadedSize
+}
+
+// uploadTarget is where the uploads into a folder are stored.
+type uploadTarget struct {
+	storageChatID int64
+	partSize      int64
+}
+
+func (s *Server) uploadTarget(ctx context.Context, userID, dirID int64) (uploadTarget, error) {
+	fs := s.fileSystem()
+	chatID, err := fs.storageChatFor(ctx, userID, dirID)
+	if err != nil {
+		return uploadTarget{}, err
+	}
+	if chatID == 0 {
+		return uploadTarget{}, errors.New("uploads need a storage chat: set one with /storage in the bot, or STORAGE_CHAT_ID on the server")
+	}
+	partSize, err := fs.partSizeFor(ctx, userID)
+	if err != nil {
+		return uploadTarget{}, err
+	}
+	if partSize <= 0 {
+		partSize = 1900 * 1024 * 1024
+	}
+	return uploadTarget{storageChatID: chatID, partSize: partSize}, nil
+}
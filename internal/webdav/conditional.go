@@ -0,0 +1,120 @@
+package webdav
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+
+	"pigpak/internal/db"
+)
+
+type ifMatchKey struct{}
+
+// ifMatch is the revision an If-Match request checked its target at.
+type ifMatch struct {
+	name     string
+	revision int64
+}
+
+// guardIfMatch answers 412 to a PUT, DELETE, MOVE or PROPPATCH whose
+// If-Match does not name the target's current ETag, or that asks for "*"
+// when there is no target. A request that passes has the change itself made
+// conditional on the same revision, so a client that read, edited and wrote
+// back a file cannot overwrite a change that landed in between.
+//
+// If-None-Match fails such a request the other way round: when it names the
+// current ETag, or asks for "*" and the target exists, so a client that
+// uploads with If-None-Match: * never replaces a file it has not seen. GET
+// and HEAD are left to the WebDAV handler, which answers them with 304 Not
+// Modified or 412 against the same ETags.
+func (fs *davFS) guardIfMatch(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match, noneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		switch r.Method {
+		case http.MethodPut, http.MethodDelete, "MOVE", "PROPPATCH":
+		default:
+			match, noneMatch = "", ""
+		}
+		if match == "" && noneMatch == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		userID, err := fs.userID(ctx)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		name := path.Clean("/" + r.URL.Path)
+		etag, revision, exists := fs.currentETag(ctx, userID, name)
+		if noneMatch != "" && exists && matchesWeakETag(noneMatch, etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if match == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !exists || !matchesETag(match, etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		ctx = context.WithValue(ctx, ifMatchKey{}, ifMatch{name: name, revision: revision})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// currentETag returns the ETag and revision of what name resolves to, and
+// false when it resolves to nothing.
+func (fs *davFS) currentETag(ctx context.Context, userID int64, name string) (string, int64, bool) {
+	entry, err := fs.resolve(ctx, userID, name)
+	if err != nil {
+		return "", 0, false
+	}
+	switch {
+	case entry.isDir:
+		return entry.dir.ETag(), entry.dir.Revision, true
+	case entry.shortcut != nil:
+		return entry.shortcut.info().etag, 0, true
+	case entry.junk != nil:
+		return junkInfo(*entry.junk).etag, 0, true
+	case entry.trash != nil:
+		return entry.trash.info().etag, 0, true
+	}
+	return entry.file.ETag(), entry.file.Revision, true
+}
+
+// conditional makes the store calls for name conditional when the request
+// checked name with If-Match. Other names the request touches, such as a
+// MOVE's destination, are changed as usual.
+func conditional(ctx context.Context, name string) context.Context {
+	if m, ok := ctx.Value(ifMatchKey{}).(ifMatch); ok && m.name == path.Clean("/"+name) {
+		return db.IfRevision(ctx, m.revision)
+	}
+	return ctx
+}
+
+// matchesETag reports whether an If-Match header lists etag, comparing
+// strongly: weak tags never match.
+func matchesETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWeakETag reports whether an If-None-Match header lists etag,
+// comparing weakly as that header does.
+func matchesWeakETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
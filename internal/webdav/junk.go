@@ -0,0 +1,175 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"pigpak/internal/config"
+	"pigpak/internal/db"
+)
+
+// errJunkTooLarge refuses junk files bigger than db.MaxWebDAVJunkSize.
+var errJunkTooLarge = errors.New("junk file too large")
+
+// isJunk reports whether a file called name is one WEB_DAV_IGNORE matches.
+func (fs *davFS) isJunk(name string) bool {
+	if fs.junkMode == config.IgnoreOff || fs.junkMode == "" {
+		return false
+	}
+	base := path.Base(name)
+	for _, pattern := range fs.junkPatterns {
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hidesJunk reports whether name is a junk file kept in the database.
+func (fs *davFS) hidesJunk(name string) bool {
+	return fs.junkMode == config.IgnoreHide && fs.isJunk(name)
+}
+
+// rejectJunk answers 403 to requests that would create a junk file when
+// WEB_DAV_IGNORE_MODE is reject, before any of the body is uploaded. Junk
+// files stored before are still read and deleted as usual.
+func (fs *davFS) rejectJunk(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fs.junkMode != config.IgnoreReject {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target := ""
+		switch r.Method {
+		case http.MethodPut, "LOCK":
+			target = r.URL.Path
+		case "COPY", "MOVE":
+			if dest, err := url.Parse(r.Header.Get("Destination")); err == nil {
+				target = dest.Path
+			}
+		}
+		if target != "" && fs.isJunk(path.Clean("/"+target)) {
+			http.Error(w, "file name is ignored by this server", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// findJunk looks up the junk file the path parts name.
+func (fs *davFS) findJunk(ctx context.Context, userID, rootID int64, parts []string) (*db.WebDAVJunkFile, error) {
+	dirID := rootID
+	if len(parts) > 1 {
+		dir, err := fs.store.FindDirByPathFrom(ctx, userID, rootID, parts[:len(parts)-1])
+		if err != nil {
+			return nil, os.ErrNotExist
+		}
+		dirID = dir.ID
+	}
+	junk, err := fs.store.GetWebDAVJunk(ctx, dirID, parts[len(parts)-1])
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return junk, nil
+}
+
+// createJunkFile opens name for writing into the database.
+func (fs *davFS) createJunkFile(ctx context.Context, userID int64, name string) (*junkFile, error) {
+	parentParts, base := splitPath(name)
+	if base == "" {
+		return nil, errors.New("invalid file name")
+	}
+	contentLength, _ := ctx.Value(webdavContentLengthKey{}).(int64)
+	if contentLength > db.MaxWebDAVJunkSize {
+		return nil, errJunkTooLarge
+	}
+	parentDir, err := fs.findDir(ctx, userID, parentParts)
+	if err != nil {
+		return nil, err
+	}
+	junk := db.WebDAVJunkFile{UserID: parentDir.UserID, DirID: parentDir.ID, Name: base, UpdatedAt: time.Now().UTC()}
+	return &junkFile{ctx: ctx, store: fs.store, junk: junk, writing: true}, nil
+}
+
+func junkInfo(junk db.WebDAVJunkFile) davFileInfo {
+	return davFileInfo{
+		name:    junk.Name,
+		size:    int64(len(junk.Content)),
+		mode:    0o644,
+		modTime: junk.UpdatedAt,
+		etag:    fmt.Sprintf(`"jk-%08x-%d"`, crc32.ChecksumIEEE(junk.Content), len(junk.Content)),
+	}
+}
+
+// junkFile reads a junk file from, or writes one to, the database. A write
+// is buffered and stored on Close.
+type junkFile struct {
+	ctx     context.Context
+	store   *db.Store
+	junk    db.WebDAVJunkFile
+	reader  *bytes.Reader
+	writing bool
+	buf     bytes.Buffer
+}
+
+func newJunkFile(ctx context.Context, store *db.Store, junk db.WebDAVJunkFile) *junkFile {
+	return &junkFile{ctx: ctx, store: store, junk: junk, reader: bytes.NewReader(junk.Content)}
+}
+
+func (f *junkFile) Stat() (os.FileInfo, error) {
+	if f.writing {
+		junk := f.junk
+		junk.Content = f.buf.Bytes()
+		return junkInfo(junk), nil
+	}
+	return junkInfo(f.junk), nil
+}
+
+func (f *junkFile) Read(p []byte) (int, error) {
+	if f.writing {
+		return 0, errors.New("file is open for writing")
+	}
+	return f.reader.Read(p)
+}
+
+func (f *junkFile) Seek(offset int64, whence int) (int64, error) {
+	if f.writing {
+		return 0, errors.New("seek not supported on upload")
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *junkFile) Write(p []byte) (int, error) {
+	if !f.writing {
+		return 0, errors.New("read-only")
+	}
+	if f.buf.Len()+len(p) > db.MaxWebDAVJunkSize {
+		return 0, errJunkTooLarge
+	}
+	return f.buf.Write(p)
+}
+
+func (f *junkFile) Close() error {
+	if !f.writing {
+		return nil
+	}
+	f.writing = false
+	f.junk.Content = bytes.Clone(f.buf.Bytes())
+	return f.store.PutWebDAVJunk(f.ctx, f.junk.UserID, f.junk.DirID, f.junk.Name, f.junk.Content)
+}
+
+func (f *junkFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
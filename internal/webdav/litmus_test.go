@@ -0,0 +1,789 @@
+package webdav_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"pigpak/internal/config"
+	"pigpak/internal/db"
+	"pigpak/internal/telegram/telegramtest"
+	"pigpak/internal/webdav"
+)
+
+const (
+	litmusUserID   = 1
+	litmusUsername = "litmus"
+	litmusPassword = "litmus"
+	// litmusPartSize is small so that files of a few kilobytes are split
+	// and ranged reads cross part boundaries.
+	litmusPartSize = 4 << 10
+)
+
+// litmusCheck is one check of the suite. It runs in a collection of its
+// own, which base names.
+type litmusCheck struct {
+	name string
+	run  func(l *litmus, base string) error
+}
+
+// TestLitmus is a WebDAV compliance suite in the style of litmus, run
+// against a server backed by a scratch database and a fake Telegram. It
+// covers basic file and collection operations, PROPFIND and PROPPATCH,
+// COPY and MOVE, locks, and ranged and conditional GETs.
+func TestLitmus(t *testing.T) {
+	l := newLitmus(t)
+	for i, check := range litmusChecks {
+		t.Run(check.name, func(t *testing.T) {
+			base := fmt.Sprintf("/litmus-%d/", i)
+			if err := l.mkcol(base); err != nil {
+				t.Fatal(err)
+			}
+			if err := check.run(l, base); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+var litmusChecks = []litmusCheck{
+	{"basic/options", checkOptions},
+	{"basic/put_get", checkPutGet},
+	{"basic/put_get_split", checkPutGetSplit},
+	{"basic/put_overwrite", checkPutOverwrite},
+	{"basic/put_no_parent", checkPutNoParent},
+	{"basic/mkcol", checkMkcol},
+	{"basic/mkcol_again", checkMkcolAgain},
+	{"basic/mkcol_no_parent", checkMkcolNoParent},
+	{"basic/delete", checkDelete},
+	{"basic/delete_coll", checkDeleteColl},
+	{"basic/delete_missing", checkDeleteMissing},
+	{"props/propfind_depth0", checkPropfindDepth0},
+	{"props/propfind_depth1", checkPropfindDepth1},
+	{"props/propfind_infinity", checkPropfindInfinity},
+	{"props/propfind_missing", checkPropfindMissing},
+	{"props/getcontentlength", checkContentLength},
+	{"props/proppatch", checkProppatch},
+	{"props/proppatch_remove", checkProppatchRemove},
+	{"copymove/copy", checkCopy},
+	{"copymove/copy_overwrite_f", checkCopyOverwriteF},
+	{"copymove/copy_overwrite_t", checkCopyOverwriteT},
+	{"copymove/copy_coll", checkCopyColl},
+	{"copymove/move", checkMove},
+	{"copymove/move_overwrite_f", checkMoveOverwriteF},
+	{"copymove/move_overwrite_t", checkMoveOverwriteT},
+	{"copymove/move_coll", checkMoveColl},
+	{"copymove/move_no_parent", checkMoveNoParent},
+	{"locks/lock_unlock", checkLockUnlock},
+	{"locks/lock_blocks_put", checkLockBlocksPut},
+	{"locks/lock_token_put", checkLockTokenPut},
+	{"locks/lock_conflict", checkLockConflict},
+	{"locks/lock_blocks_delete", checkLockBlocksDelete},
+	{"locks/lock_coll_depth", checkLockCollDepth},
+	{"http/range", checkRange},
+	{"http/range_split", checkRangeSplit},
+	{"http/range_suffix", checkRangeSuffix},
+	{"http/range_unsatisfiable", checkRangeUnsatisfiable},
+	{"http/if_none_match_get", checkIfNoneMatchGet},
+	{"http/if_match_put", checkIfMatchPut},
+}
+
+type litmus struct {
+	store *db.Store
+	srv   *httptest.Server
+}
+
+func newLitmus(t *testing.T) *litmus {
+	t.Helper()
+	store, err := db.Open(filepath.Join(t.TempDir(), "litmus.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	ctx := context.Background()
+	if _, err := store.EnsureUser(ctx, litmusUserID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertUserProfile(ctx, litmusUserID, litmusUsername); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetWebDAVPassword(ctx, litmusUserID, litmusPassword); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Config{StorageChatID: -100, MaxPartSizeBytes: litmusPartSize, WebDAVPropfindMaxItems: 1000}
+	srv, err := webdav.NewServer(cfg, store, telegramtest.NewFake())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &litmus{store: store, srv: httptest.NewServer(srv.Handler())}
+	t.Cleanup(l.srv.Close)
+	return l
+}
+
+// litmusResponse is a response read in full, or the error that prevented
+// it.
+type litmusResponse struct {
+	method string
+	path   string
+	status int
+	header http.Header
+	body   []byte
+	err    error
+}
+
+// do sends a request for path, relative to the server, with the given
+// headers as name and value pairs.
+func (l *litmus) do(method, path string, body []byte, headers []string) litmusResponse {
+	res := litmusResponse{method: method, path: path}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, l.srv.URL+path, r)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	req.SetBasicAuth(litmusUsername, litmusPassword)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := l.srv.Client().Do(req)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer resp.Body.Close()
+	res.status, res.header = resp.StatusCode, resp.Header
+	res.body, res.err = io.ReadAll(resp.Body)
+	return res
+}
+
+// expect fails unless res has one of the statuses.
+func (l *litmus) expect(res litmusResponse, statuses ...int) error {
+	if res.err != nil {
+		return fmt.Errorf("%s %s: %w", res.method, res.path, res.err)
+	}
+	for _, status := range statuses {
+		if res.status == status {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s: got %d, want %v", res.method, res.path, res.status, statuses)
+}
+
+// put uploads content to path, creating or replacing it.
+func (l *litmus) put(path string, content []byte) error {
+	return l.expect(l.do(http.MethodPut, path, content, nil), http.StatusCreated, http.StatusNoContent)
+}
+
+// mkcol creates the collection path.
+func (l *litmus) mkcol(path string) error {
+	return l.expect(l.do("MKCOL", path, nil, nil), http.StatusCreated)
+}
+
+// get fails unless path reads back as want.
+func (l *litmus) get(path string, want []byte) error {
+	res := l.do(http.MethodGet, path, nil, nil)
+	if err := l.expect(res, http.StatusOK); err != nil {
+		return err
+	}
+	if !bytes.Equal(res.body, want) {
+		return fmt.Errorf("GET %s: content differs: got %d bytes, want %d", path, len(res.body), len(want))
+	}
+	return nil
+}
+
+// missing fails unless path does not exist.
+func (l *litmus) missing(path string) error {
+	return l.expect(l.do(http.MethodGet, path, nil, nil), http.StatusNotFound)
+}
+
+// propfind returns the hrefs and body of a PROPFIND of path at depth.
+func (l *litmus) propfind(path, depth, body string) ([]string, string, error) {
+	var b []byte
+	if body != "" {
+		b = []byte(body)
+	}
+	res := l.do("PROPFIND", path, b, []string{"Depth", depth, "Content-Type", "application/xml"})
+	if err := l.expect(res, http.StatusMultiStatus); err != nil {
+		return nil, "", err
+	}
+	var hrefs []string
+	for _, m := range hrefPattern.FindAllStringSubmatch(string(res.body), -1) {
+		hrefs = append(hrefs, m[1])
+	}
+	return hrefs, string(res.body), nil
+}
+
+var hrefPattern = regexp.MustCompile(`<D:href>([^<]*)</D:href>`)
+
+// hasHrefs fails unless hrefs are exactly want, in any order.
+func hasHrefs(hrefs []string, want ...string) error {
+	got := make(map[string]int)
+	for _, h := range hrefs {
+		got[h]++
+	}
+	for _, w := range want {
+		if got[w] == 0 {
+			return fmt.Errorf("PROPFIND lists %v, missing %s", hrefs, w)
+		}
+		got[w]--
+	}
+	for h, n := range got {
+		if n > 0 {
+			return fmt.Errorf("PROPFIND lists %v, unexpected %s", hrefs, h)
+		}
+	}
+	return nil
+}
+
+// lock takes an exclusive write lock on path and returns its token.
+func (l *litmus) lock(path, depth string, statuses ...int) (string, error) {
+	body := `<?xml version="1.0" encoding="utf-8"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>litmus</D:owner></D:lockinfo>`
+	res := l.do("LOCK", path, []byte(body), []string{"Depth", depth, "Timeout", "Second-60", "Content-Type", "application/xml"})
+	if err := l.expect(res, statuses...); err != nil {
+		return "", err
+	}
+	token := strings.Trim(res.header.Get("Lock-Token"), "<>")
+	if token == "" {
+		return "", fmt.Errorf("LOCK %s: no Lock-Token", path)
+	}
+	return token, nil
+}
+
+// litmusContent returns n bytes that differ at every offset within a part,
+// so misplaced ranges do not compare equal by chance.
+func litmusContent(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*7 + i/251)
+	}
+	return b
+}
+
+func checkOptions(l *litmus, base string) error {
+	res := l.do(http.MethodOptions, base, nil, nil)
+	if err := l.expect(res, http.StatusOK); err != nil {
+		return err
+	}
+	dav := res.header.Get("DAV")
+	if !strings.Contains(dav, "1") || !strings.Contains(dav, "2") {
+		return fmt.Errorf("OPTIONS: DAV header %q does not claim classes 1 and 2", dav)
+	}
+	return nil
+}
+
+func checkPutGet(l *litmus, base string) error {
+	content := []byte("litmus test file\n")
+	if err := l.put(base+"res", content); err != nil {
+		return err
+	}
+	return l.get(base+"res", content)
+}
+
+func checkPutGetSplit(l *litmus, base string) error {
+	content := litmusContent(3*litmusPartSize + 123)
+	if err := l.put(base+"big", content); err != nil {
+		return err
+	}
+	return l.get(base+"big", content)
+}
+
+func checkPutOverwrite(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("first")); err != nil {
+		return err
+	}
+	if err := l.put(base+"res", []byte("second version")); err != nil {
+		return err
+	}
+	return l.get(base+"res", []byte("second version"))
+}
+
+func checkPutNoParent(l *litmus, base string) error {
+	return l.expect(l.do(http.MethodPut, base+"missing/res", []byte("x"), nil), http.StatusConflict)
+}
+
+func checkMkcol(l *litmus, base string) error {
+	if err := l.mkcol(base + "coll/"); err != nil {
+		return err
+	}
+	hrefs, _, err := l.propfind(base+"coll/", "0", "")
+	if err != nil {
+		return err
+	}
+	return hasHrefs(hrefs, base+"coll/")
+}
+
+func checkMkcolAgain(l *litmus, base string) error {
+	if err := l.mkcol(base + "coll/"); err != nil {
+		return err
+	}
+	return l.expect(l.do("MKCOL", base+"coll/", nil, nil), http.StatusMethodNotAllowed)
+}
+
+func checkMkcolNoParent(l *litmus, base string) error {
+	return l.expect(l.do("MKCOL", base+"a/b/", nil, nil), http.StatusConflict)
+}
+
+func checkDelete(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("x")); err != nil {
+		return err
+	}
+	if err := l.expect(l.do(http.MethodDelete, base+"res", nil, nil), http.StatusNoContent); err != nil {
+		return err
+	}
+	return l.missing(base + "res")
+}
+
+func checkDeleteColl(l *litmus, base string) error {
+	if err := l.mkcol(base + "coll/"); err != nil {
+		return err
+	}
+	if err := l.put(base+"coll/res", []byte("x")); err != nil {
+		return err
+	}
+	if err := l.expect(l.do(http.MethodDelete, base+"coll/", nil, nil), http.StatusNoContent); err != nil {
+		return err
+	}
+	if err := l.missing(base + "coll/res"); err != nil {
+		return err
+	}
+	return l.expect(l.do("PROPFIND", base+"coll/", nil, []string{"Depth", "0"}), http.StatusNotFound)
+}
+
+func checkDeleteMissing(l *litmus, base string) error {
+	return l.expect(l.do(http.MethodDelete, base+"missing", nil, nil), http.StatusNotFound)
+}
+
+func checkPropfindDepth0(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("x")); err != nil {
+		return err
+	}
+	hrefs, _, err := l.propfind(base, "0", "")
+	if err != nil {
+		return err
+	}
+	return hasHrefs(hrefs, base)
+}
+
+func checkPropfindDepth1(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("x")); err != nil {
+		return err
+	}
+	if err := l.mkcol(base + "coll/"); err != nil {
+		return err
+	}
+	if err := l.put(base+"coll/inner", []byte("x")); err != nil {
+		return err
+	}
+	hrefs, _, err := l.propfind(base, "1", "")
+	if err != nil {
+		return err
+	}
+	return hasHrefs(hrefs, base, base+"res", base+"coll/")
+}
+
+func checkPropfindInfinity(l *litmus, base string) error {
+	if err := l.mkcol(base + "coll/"); err != nil {
+		return err
+	}
+	if err := l.mkcol(base + "coll/sub/"); err != nil {
+		return err
+	}
+	if err := l.put(base+"coll/sub/inner", []byte("x")); err != nil {
+		return err
+	}
+	hrefs, _, err := l.propfind(base, "infinity", "")
+	if err != nil {
+		return err
+	}
+	return hasHrefs(hrefs, base, base+"coll/", base+"coll/sub/", base+"coll/sub/inner")
+}
+
+func checkPropfindMissing(l *litmus, base string) error {
+	return l.expect(l.do("PROPFIND", base+"missing", nil, []string{"Depth", "0"}), http.StatusNotFound)
+}
+
+func checkContentLength(l *litmus, base string) error {
+	content := litmusContent(2*litmusPartSize + 5)
+	if err := l.put(base+"res", content); err != nil {
+		return err
+	}
+	_, body, err := l.propfind(base+"res", "0", `<?xml version="1.0" encoding="utf-8"?><D:propfind xmlns:D="DAV:"><D:prop><D:getcontentlength/></D:prop></D:propfind>`)
+	if err != nil {
+		return err
+	}
+	want := fmt.Sprintf("<D:getcontentlength>%d</D:getcontentlength>", len(content))
+	if !strings.Contains(body, want) {
+		return fmt.Errorf("PROPFIND %s: no %s in %s", base+"res", want, body)
+	}
+	return nil
+}
+
+const litmusPropName = `<L:color xmlns:L="http://example.com/litmus/">`
+
+func (l *litmus) proppatch(path, body string) error {
+	res := l.do("PROPPATCH", path, []byte(`<?xml version="1.0" encoding="utf-8"?><D:propertyupdate xmlns:D="DAV:">`+body+`</D:propertyupdate>`), []string{"Content-Type", "application/xml"})
+	if err := l.expect(res, http.StatusMultiStatus); err != nil {
+		return err
+	}
+	if !strings.Contains(string(res.body), "200 OK") {
+		return fmt.Errorf("PROPPATCH %s: %s", path, res.body)
+	}
+	return nil
+}
+
+// color returns the litmus property of path as PROPFIND reports it.
+func (l *litmus) color(path string) (string, error) {
+	_, body, err := l.propfind(path, "0", `<?xml version="1.0" encoding="utf-8"?><D:propfind xmlns:D="DAV:"><D:prop>`+litmusPropName+`</L:color></D:prop></D:propfind>`)
+	return body, err
+}
+
+func checkProppatch(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("x")); err != nil {
+		return err
+	}
+	if err := l.proppatch(base+"res", `<D:set><D:prop>`+litmusPropName+`blue</L:color></D:prop></D:set>`); err != nil {
+		return err
+	}
+	body, err := l.color(base + "res")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(body, ">blue<") {
+		return fmt.Errorf("PROPFIND %s: property not set: %s", base+"res", body)
+	}
+	return nil
+}
+
+func checkProppatchRemove(l *litmus, base string) error {
+	if err := l.mkcol(base + "coll/"); err != nil {
+		return err
+	}
+	if err := l.proppatch(base+"coll/", `<D:set><D:prop>`+litmusPropName+`red</L:color></D:prop></D:set>`); err != nil {
+		return err
+	}
+	if err := l.proppatch(base+"coll/", `<D:remove><D:prop>`+litmusPropName+`</L:color></D:prop></D:remove>`); err != nil {
+		return err
+	}
+	body, err := l.color(base + "coll/")
+	if err != nil {
+		return err
+	}
+	if strings.Contains(body, ">red<") || !strings.Contains(body, "404 Not Found") {
+		return fmt.Errorf("PROPFIND %s: property not removed: %s", base+"coll/", body)
+	}
+	return nil
+}
+
+func (l *litmus) copyOrMove(method, from, to, overwrite string) litmusResponse {
+	headers := []string{"Destination", l.srv.URL + to}
+	if overwrite != "" {
+		headers = append(headers, "Overwrite", overwrite)
+	}
+	return l.do(method, from, nil, headers)
+}
+
+func checkCopy(l *litmus, base string) error {
+	content := litmusContent(litmusPartSize + 10)
+	if err := l.put(base+"src", content); err != nil {
+		return err
+	}
+	if err := l.expect(l.copyOrMove("COPY", base+"src", base+"dest", ""), http.StatusCreated); err != nil {
+		return err
+	}
+	if err := l.get(base+"src", content); err != nil {
+		return err
+	}
+	return l.get(base+"dest", content)
+}
+
+func checkCopyOverwriteF(l *litmus, base string) error {
+	if err := l.put(base+"src", []byte("src")); err != nil {
+		return err
+	}
+	if err := l.put(base+"dest", []byte("dest")); err != nil {
+		return err
+	}
+	if err := l.expect(l.copyOrMove("COPY", base+"src", base+"dest", "F"), http.StatusPreconditionFailed); err != nil {
+		return err
+	}
+	return l.get(base+"dest", []byte("dest"))
+}
+
+func checkCopyOverwriteT(l *litmus, base string) error {
+	if err := l.put(base+"src", []byte("src")); err != nil {
+		return err
+	}
+	if err := l.put(base+"dest", []byte("dest")); err != nil {
+		return err
+	}
+	if err := l.expect(l.copyOrMove("COPY", base+"src", base+"dest", "T"), http.StatusNoContent); err != nil {
+		return err
+	}
+	return l.get(base+"dest", []byte("src"))
+}
+
+func checkCopyColl(l *litmus, base string) error {
+	if err := l.mkcol(base + "coll/"); err != nil {
+		return err
+	}
+	if err := l.mkcol(base + "coll/sub/"); err != nil {
+		return err
+	}
+	if err := l.put(base+"coll/sub/res", []byte("inner")); err != nil {
+		return err
+	}
+	if err := l.expect(l.copyOrMove("COPY", base+"coll/", base+"copy/", ""), http.StatusCreated); err != nil {
+		return err
+	}
+	if err := l.get(base+"coll/sub/res", []byte("inner")); err != nil {
+		return err
+	}
+	return l.get(base+"copy/sub/res", []byte("inner"))
+}
+
+func checkMove(l *litmus, base string) error {
+	if err := l.put(base+"src", []byte("moved")); err != nil {
+		return err
+	}
+	if err := l.expect(l.copyOrMove("MOVE", base+"src", base+"dest", ""), http.StatusCreated); err != nil {
+		return err
+	}
+	if err := l.missing(base + "src"); err != nil {
+		return err
+	}
+	return l.get(base+"dest", []byte("moved"))
+}
+
+func checkMoveOverwriteF(l *litmus, base string) error {
+	if err := l.put(base+"src", []byte("src")); err != nil {
+		return err
+	}
+	if err := l.put(base+"dest", []byte("dest")); err != nil {
+		return err
+	}
+	if err := l.expect(l.copyOrMove("MOVE", base+"src", base+"dest", "F"), http.StatusPreconditionFailed); err != nil {
+		return err
+	}
+	if err := l.get(base+"src", []byte("src")); err != nil {
+		return err
+	}
+	return l.get(base+"dest", []byte("dest"))
+}
+
+func checkMoveOverwriteT(l *litmus, base string) error {
+	if err := l.put(base+"src", []byte("src")); err != nil {
+		return err
+	}
+	if err := l.put(base+"dest", []byte("dest")); err != nil {
+		return err
+	}
+	if err := l.expect(l.copyOrMove("MOVE", base+"src", base+"dest", "T"), http.StatusNoContent); err != nil {
+		return err
+	}
+	if err := l.missing(base + "src"); err != nil {
+		return err
+	}
+	return l.get(base+"dest", []byte("src"))
+}
+
+func checkMoveColl(l *litmus, base string) error {
+	if err := l.mkcol(base + "coll/"); err != nil {
+		return err
+	}
+	if err := l.put(base+"coll/res", []byte("inner")); err != nil {
+		return err
+	}
+	if err := l.expect(l.copyOrMove("MOVE", base+"coll/", base+"moved/", ""), http.StatusCreated); err != nil {
+		return err
+	}
+	if err := l.missing(base + "coll/res"); err != nil {
+		return err
+	}
+	return l.get(base+"moved/res", []byte("inner"))
+}
+
+func checkMoveNoParent(l *litmus, base string) error {
+	if err := l.put(base+"src", []byte("src")); err != nil {
+		return err
+	}
+	if err := l.expect(l.copyOrMove("MOVE", base+"src", base+"missing/dest", ""), http.StatusConflict); err != nil {
+		return err
+	}
+	return l.get(base+"src", []byte("src"))
+}
+
+func checkLockUnlock(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("x")); err != nil {
+		return err
+	}
+	token, err := l.lock(base+"res", "0", http.StatusOK)
+	if err != nil {
+		return err
+	}
+	if err := l.expect(l.do("UNLOCK", base+"res", nil, []string{"Lock-Token", "<" + token + ">"}), http.StatusNoContent); err != nil {
+		return err
+	}
+	return l.put(base+"res", []byte("unlocked"))
+}
+
+func checkLockBlocksPut(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("x")); err != nil {
+		return err
+	}
+	if _, err := l.lock(base+"res", "0", http.StatusOK); err != nil {
+		return err
+	}
+	if err := l.expect(l.do(http.MethodPut, base+"res", []byte("y"), nil), http.StatusLocked); err != nil {
+		return err
+	}
+	return l.get(base+"res", []byte("x"))
+}
+
+func checkLockTokenPut(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("x")); err != nil {
+		return err
+	}
+	token, err := l.lock(base+"res", "0", http.StatusOK)
+	if err != nil {
+		return err
+	}
+	res := l.do(http.MethodPut, base+"res", []byte("with token"), []string{"If", "(<" + token + ">)"})
+	if err := l.expect(res, http.StatusCreated, http.StatusNoContent); err != nil {
+		return err
+	}
+	return l.get(base+"res", []byte("with token"))
+}
+
+func checkLockConflict(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("x")); err != nil {
+		return err
+	}
+	if _, err := l.lock(base+"res", "0", http.StatusOK); err != nil {
+		return err
+	}
+	body := `<?xml version="1.0" encoding="utf-8"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	res := l.do("LOCK", base+"res", []byte(body), []string{"Depth", "0", "Content-Type", "application/xml"})
+	return l.expect(res, http.StatusLocked)
+}
+
+func checkLockBlocksDelete(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("x")); err != nil {
+		return err
+	}
+	token, err := l.lock(base+"res", "0", http.StatusOK)
+	if err != nil {
+		return err
+	}
+	if err := l.expect(l.do(http.MethodDelete, base+"res", nil, nil), http.StatusLocked); err != nil {
+		return err
+	}
+	if err := l.expect(l.do(http.MethodDelete, base+"res", nil, []string{"If", "(<" + token + ">)"}), http.StatusNoContent); err != nil {
+		return err
+	}
+	return l.missing(base + "res")
+}
+
+func checkLockCollDepth(l *litmus, base string) error {
+	if err := l.mkcol(base + "coll/"); err != nil {
+		return err
+	}
+	token, err := l.lock(base+"coll/", "infinity", http.StatusOK)
+	if err != nil {
+		return err
+	}
+	if err := l.expect(l.do(http.MethodPut, base+"coll/res", []byte("x"), nil), http.StatusLocked); err != nil {
+		return err
+	}
+	res := l.do(http.MethodPut, base+"coll/res", []byte("x"), []string{"If", "<" + l.srv.URL + base + "coll/> (<" + token + ">)"})
+	return l.expect(res, http.StatusCreated)
+}
+
+// getRange fails unless a GET of path with Range: header returns want.
+func (l *litmus) getRange(path, header string, want []byte, total int) error {
+	res := l.do(http.MethodGet, path, nil, []string{"Range", header})
+	if err := l.expect(res, http.StatusPartialContent); err != nil {
+		return err
+	}
+	if !bytes.Equal(res.body, want) {
+		return fmt.Errorf("GET %s Range %s: content differs: got %d bytes, want %d", path, header, len(res.body), len(want))
+	}
+	if cr := res.header.Get("Content-Range"); !strings.HasSuffix(cr, fmt.Sprintf("/%d", total)) {
+		return fmt.Errorf("GET %s Range %s: Content-Range %q", path, header, cr)
+	}
+	return nil
+}
+
+func checkRange(l *litmus, base string) error {
+	content := []byte("0123456789abcdef")
+	if err := l.put(base+"res", content); err != nil {
+		return err
+	}
+	return l.getRange(base+"res", "bytes=2-5", content[2:6], len(content))
+}
+
+func checkRangeSplit(l *litmus, base string) error {
+	content := litmusContent(3*litmusPartSize + 17)
+	if err := l.put(base+"big", content); err != nil {
+		return err
+	}
+	start, end := litmusPartSize-100, 2*litmusPartSize+100
+	return l.getRange(base+"big", fmt.Sprintf("bytes=%d-%d", start, end), content[start:end+1], len(content))
+}
+
+func checkRangeSuffix(l *litmus, base string) error {
+	content := litmusContent(2*litmusPartSize + 9)
+	if err := l.put(base+"big", content); err != nil {
+		return err
+	}
+	return l.getRange(base+"big", "bytes=-20", content[len(content)-20:], len(content))
+}
+
+func checkRangeUnsatisfiable(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("short")); err != nil {
+		return err
+	}
+	return l.expect(l.do(http.MethodGet, base+"res", nil, []string{"Range", "bytes=100-"}), http.StatusRequestedRangeNotSatisfiable)
+}
+
+func checkIfNoneMatchGet(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("x")); err != nil {
+		return err
+	}
+	res := l.do(http.MethodGet, base+"res", nil, nil)
+	if err := l.expect(res, http.StatusOK); err != nil {
+		return err
+	}
+	etag := res.header.Get("ETag")
+	if etag == "" {
+		return fmt.Errorf("GET %s: no ETag", base+"res")
+	}
+	return l.expect(l.do(http.MethodGet, base+"res", nil, []string{"If-None-Match", etag}), http.StatusNotModified)
+}
+
+func checkIfMatchPut(l *litmus, base string) error {
+	if err := l.put(base+"res", []byte("x")); err != nil {
+		return err
+	}
+	res := l.do(http.MethodGet, base+"res", nil, nil)
+	if err := l.expect(res, http.StatusOK); err != nil {
+		return err
+	}
+	etag := res.header.Get("ETag")
+	if err := l.expect(l.do(http.MethodPut, base+"res", []byte("y"), []string{"If-Match", `"stale-0"`}), http.StatusPreconditionFailed); err != nil {
+		return err
+	}
+	if err := l.expect(l.do(http.MethodPut, base+"res", []byte("z"), []string{"If-Match", etag}), http.StatusCreated, http.StatusNoContent); err != nil {
+		return err
+	}
+	return l.get(base+"res", []byte("z"))
+}
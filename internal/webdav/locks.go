@@ -0,0 +1,244 @@
+package webdav
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"pigpak/internal/db"
+)
+
+// maxLockDuration caps how long a LOCK holds without a refresh. Clients that
+// ask for an infinite timeout get this instead, so one that goes away cannot
+// keep a file locked for good.
+const maxLockDuration = time.Hour
+
+// withLocks serves h with a lock system for the request's user.
+func (fs *davFS) withLocks(h *webdav.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := fs.userID(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		served := *h
+		served.LockSystem = &lockSystem{ctx: r.Context(), fs: fs, userID: userID, method: r.Method}
+		served.ServeHTTP(w, r)
+	})
+}
+
+// lockSystem is the webdav.LockSystem of one request. Locks clients take
+// with LOCK are kept in the database, by drive and path from the drive's
+// root, so they survive restarts, are seen by every instance sharing the
+// database and hold across logins scoped to different folders.
+//
+// The handler also locks what every other write touches for the length of
+// the request, unless the request presents a lock token. Those locks are
+// checked against the stored ones but kept in memory, in davFS.held, so a
+// crash mid-request cannot leave them behind.
+type lockSystem struct {
+	ctx    context.Context
+	fs     *davFS
+	userID int64
+	method string
+	// temp holds the tokens of the in-memory locks this request took.
+	temp map[string]bool
+
+	once    sync.Once
+	driveID int64
+	// base is the path of the served root from the drive's root.
+	base string
+	err  error
+}
+
+func (ls *lockSystem) resolve() error {
+	ls.once.Do(func() {
+		rootID, err := ls.fs.rootID(ls.ctx, ls.userID)
+		if err != nil {
+			ls.err = err
+			return
+		}
+		drive, err := ls.fs.store.DriveForDir(ls.ctx, ls.userID, rootID)
+		if err != nil {
+			ls.err = err
+			return
+		}
+		ls.driveID = drive.ID
+		ls.base, ls.err = ls.fs.store.GetDirPath(ls.ctx, ls.userID, rootID)
+	})
+	return ls.err
+}
+
+// abs returns the drive path of a request path.
+func (ls *lockSystem) abs(name string) string {
+	return path.Join(ls.base, path.Clean("/"+name))
+}
+
+// rel returns the request path of a drive path, and false when it is not
+// below the served root.
+func (ls *lockSystem) rel(root string) (string, bool) {
+	if ls.base == "/" {
+		return root, true
+	}
+	if root == ls.base {
+		return "/", true
+	}
+	rest, ok := strings.CutPrefix(root, ls.base+"/")
+	return "/" + rest, ok
+}
+
+// heldKey names a drive path in davFS.held, which all users share.
+func (ls *lockSystem) heldKey(root string) string {
+	return path.Join("/", strconv.FormatInt(ls.userID, 10), strconv.FormatInt(ls.driveID, 10), root)
+}
+
+func (ls *lockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	if err := ls.resolve(); err != nil {
+		return nil, err
+	}
+	var locks []db.WebDAVLock
+	for _, c := range conditions {
+		if c.Token == "" {
+			continue
+		}
+		lock, err := ls.lock(c.Token)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	for _, name := range []string{name0, name1} {
+		if name != "" && !lockCovers(locks, ls.abs(name)) {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	return func() {}, nil
+}
+
+func (ls *lockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	if err := ls.resolve(); err != nil {
+		return "", err
+	}
+	root := ls.abs(details.Root)
+	held := webdav.LockDetails{Root: ls.heldKey(root), Duration: details.Duration, ZeroDepth: details.ZeroDepth}
+	if ls.method != "LOCK" {
+		_, err := ls.fs.store.ConflictingWebDAVLock(ls.ctx, ls.userID, ls.driveID, root, details.ZeroDepth)
+		if err == nil {
+			return "", webdav.ErrLocked
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+		token, err := ls.fs.held.Create(now, held)
+		if err != nil {
+			return "", err
+		}
+		if ls.temp == nil {
+			ls.temp = map[string]bool{}
+		}
+		ls.temp[token] = true
+		return token, nil
+	}
+	// A new lock also waits for writes in flight on this instance.
+	probe, err := ls.fs.held.Create(now, held)
+	if err != nil {
+		return "", err
+	}
+	defer ls.fs.held.Unlock(now, probe)
+	lock, err := ls.fs.store.CreateWebDAVLock(ls.ctx, db.WebDAVLock{
+		UserID:    ls.userID,
+		DriveID:   ls.driveID,
+		Root:      root,
+		ZeroDepth: details.ZeroDepth,
+		OwnerXML:  details.OwnerXML,
+		ExpiresAt: now.Add(lockDuration(details.Duration)),
+	})
+	if errors.Is(err, db.ErrLocked) {
+		return "", webdav.ErrLocked
+	}
+	return lock.Token, err
+}
+
+func (ls *lockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	if err := ls.resolve(); err != nil {
+		return webdav.LockDetails{}, err
+	}
+	lock, err := ls.lock(token)
+	if err == nil {
+		lock, err = ls.fs.store.RefreshWebDAVLock(ls.ctx, ls.userID, token, now.Add(lockDuration(duration)))
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	root, _ := ls.rel(lock.Root)
+	return webdav.LockDetails{Root: root, Duration: duration, OwnerXML: lock.OwnerXML, ZeroDepth: lock.ZeroDepth}, nil
+}
+
+func (ls *lockSystem) Unlock(now time.Time, token string) error {
+	if ls.temp[token] {
+		delete(ls.temp, token)
+		return ls.fs.held.Unlock(now, token)
+	}
+	if err := ls.resolve(); err != nil {
+		return err
+	}
+	if _, err := ls.lock(token); errors.Is(err, sql.ErrNoRows) {
+		return webdav.ErrNoSuchLock
+	} else if err != nil {
+		return err
+	}
+	err := ls.fs.store.DeleteWebDAVLock(ls.ctx, ls.userID, token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return webdav.ErrNoSuchLock
+	}
+	return err
+}
+
+// lock returns the stored lock with token, provided it is in the served
+// drive below the served root; sql.ErrNoRows otherwise.
+func (ls *lockSystem) lock(token string) (db.WebDAVLock, error) {
+	lock, err := ls.fs.store.GetWebDAVLock(ls.ctx, ls.userID, token)
+	if err != nil {
+		return db.WebDAVLock{}, err
+	}
+	if _, ok := ls.rel(lock.Root); !ok || lock.DriveID != ls.driveID {
+		return db.WebDAVLock{}, sql.ErrNoRows
+	}
+	return lock, nil
+}
+
+// lockCovers reports whether one of locks is on root or, not being
+// zero-depth, on a folder above it.
+func lockCovers(locks []db.WebDAVLock, root string) bool {
+	for _, lock := range locks {
+		if lock.Root == root {
+			return true
+		}
+		if !lock.ZeroDepth && (lock.Root == "/" || strings.HasPrefix(root, lock.Root+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// lockDuration returns how long a lock asked to last for d holds.
+func lockDuration(d time.Duration) time.Duration {
+	if d < 0 || d > maxLockDuration {
+		return maxLockDuration
+	}
+	return d
+}
@@ -0,0 +1,147 @@
+package webdav
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"pigpak/internal/db"
+)
+
+// moveReport is the JSON body returned for a checked MOVE.
+type moveReport struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	db.MoveReport
+}
+
+// guardMove checks MOVE requests before the WebDAV handler runs them. A
+// request carrying "X-Dry-Run: T" only gets the JSON report, with 200 when
+// the move would succeed. Real moves that would fail partway, after the
+// destination was already deleted, are refused with 409 up front, as are
+// moves into a missing folder, which the handler would answer with 403.
+func (fs *davFS) guardMove(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "MOVE" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		report, blocked, status := fs.checkMove(r)
+		if !isTrueHeader(r.Header.Get("X-Dry-Run")) {
+			if status == http.StatusConflict {
+				blocked, status = true, 0
+			}
+			if status != 0 || !blocked {
+				next.ServeHTTP(w, r)
+				return
+			}
+			status = http.StatusConflict
+		}
+		if status == 0 {
+			switch {
+			case report.OK:
+				status = http.StatusOK
+			case blocked:
+				status = http.StatusConflict
+			default:
+				status = http.StatusPreconditionFailed
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
+
+// checkMove plans the move described by r. blocked reports conflicts that
+// would break the move partway. A non-zero status means the request could not
+// be planned at all, e.g. a missing source.
+func (fs *davFS) checkMove(r *http.Request) (report moveReport, blocked bool, status int) {
+	ctx := r.Context()
+	report = moveReport{Source: path.Clean("/" + r.URL.Path), MoveReport: db.MoveReport{Overwrite: r.Header.Get("Overwrite") != "F", Conflicts: []string{}}}
+	dest, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || dest.Path == "" {
+		report.Conflicts = append(report.Conflicts, "missing or invalid Destination header")
+		return report, false, http.StatusBadRequest
+	}
+	report.Destination = path.Clean("/" + dest.Path)
+	userID, err := fs.userID(ctx)
+	if err != nil {
+		return report, false, http.StatusUnauthorized
+	}
+	entry, err := fs.resolve(ctx, userID, report.Source)
+	if err != nil {
+		report.Conflicts = append(report.Conflicts, "source does not exist")
+		return report, false, http.StatusNotFound
+	}
+	if entry.shortcut != nil {
+		report.Conflicts = append(report.Conflicts, "shortcuts cannot be moved")
+		return report, true, 0
+	}
+	if fs.inTrash(ctx, report.Destination) {
+		report.Conflicts = append(report.Conflicts, "the trash folder is read-only")
+		return report, true, 0
+	}
+	if entry.trash != nil {
+		return fs.checkTrashMove(ctx, userID, report, entry)
+	}
+	parentParts, base := splitPath(report.Destination)
+	parent, err := fs.findDir(ctx, userID, parentParts)
+	if err != nil {
+		report.Conflicts = append(report.Conflicts, "destination folder does not exist")
+		return report, false, http.StatusConflict
+	}
+	if entry.junk != nil || !entry.isDir && fs.hidesJunk(report.Destination) {
+		return fs.checkJunkMove(ctx, report, entry, parent.ID, base)
+	}
+	var plan db.MovePlan
+	if entry.isDir {
+		plan, err = fs.store.PlanDirMove(ctx, userID, entry.dir.ID, parent.ID, base)
+	} else {
+		plan, err = fs.store.PlanFileMove(ctx, userID, entry.file.ID, parent.ID, base)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return report, false, http.StatusNotFound
+		}
+		return report, false, http.StatusInternalServerError
+	}
+	report.MoveReport = plan.Report(report.Overwrite)
+	if plan.TargetExists && !report.Overwrite {
+		report.Conflicts = append(report.Conflicts, "destination exists and Overwrite is F")
+	}
+	return report, len(plan.Conflicts) > 0, 0
+}
+
+// checkJunkMove plans a move from or to a junk name, which Rename allows
+// only between junk files.
+func (fs *davFS) checkJunkMove(ctx context.Context, report moveReport, entry davEntry, dirID int64, base string) (moveReport, bool, int) {
+	if entry.junk == nil || !fs.hidesJunk(report.Destination) {
+		report.Conflicts = append(report.Conflicts, "ignored files can only be renamed to other ignored names")
+		return report, true, 0
+	}
+	report.Files, report.Bytes = 1, int64(len(entry.junk.Content))
+	if existing, err := fs.store.GetWebDAVJunk(ctx, dirID, base); err == nil && existing.ID != entry.junk.ID {
+		report.Exists = true
+		if report.Overwrite {
+			report.Replaced = &db.MoveSize{Files: 1, Bytes: int64(len(existing.Content))}
+		} else {
+			report.Conflicts = append(report.Conflicts, "destination exists and Overwrite is F")
+		}
+	}
+	report.OK = len(report.Conflicts) == 0
+	return report, false, 0
+}
+
+func isTrueHeader(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "t", "true", "1", "yes":
+		return true
+	}
+	return false
+}
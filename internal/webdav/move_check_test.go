@@ -0,0 +1,67 @@
+package webdav_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDryRunMoveReport(t *testing.T) {
+	l := newLitmus(t)
+	if err := l.store.SetQuota(context.Background(), litmusUserID, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.mkcol("/dir/"); err != nil {
+		t.Fatal(err)
+	}
+	for path, size := range map[string]int{"/dir/a": 100, "/dir/b": 50, "/c": 30} {
+		if err := l.put(path, litmusContent(size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type report struct {
+		Bytes      int64    `json:"bytes"`
+		Exists     bool     `json:"destination_exists"`
+		UsageDelta int64    `json:"usage_delta"`
+		QuotaBytes int64    `json:"quota_bytes"`
+		UsedBytes  int64    `json:"used_bytes"`
+		Conflicts  []string `json:"conflicts"`
+		OK         bool     `json:"ok"`
+	}
+	tests := []struct {
+		name      string
+		src, dst  string
+		overwrite string
+		status    int
+		want      report
+	}{
+		{"free name", "/dir/", "/moved/", "T", http.StatusOK,
+			report{Bytes: 150, QuotaBytes: 1000, UsedBytes: 180, Conflicts: []string{}, OK: true}},
+		{"overwrite frees the replaced file", "/c", "/dir/a", "T", http.StatusOK,
+			report{Bytes: 30, Exists: true, UsageDelta: -100, QuotaBytes: 1000, UsedBytes: 180, Conflicts: []string{}, OK: true}},
+		{"no overwrite", "/c", "/dir/a", "F", http.StatusPreconditionFailed,
+			report{Bytes: 30, Exists: true, QuotaBytes: 1000, UsedBytes: 180, Conflicts: []string{"destination exists and Overwrite is F"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := l.do("MOVE", tt.src, nil, []string{"Destination", l.srv.URL + tt.dst, "Overwrite", tt.overwrite, "X-Dry-Run", "T"})
+			if err := l.expect(res, tt.status); err != nil {
+				t.Fatal(err)
+			}
+			var got report
+			if err := json.Unmarshal(res.body, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("report = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	// A dry run changes nothing.
+	if err := l.get("/c", litmusContent(30)); err != nil {
+		t.Fatal(err)
+	}
+}
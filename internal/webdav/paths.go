@@ -0,0 +1,71 @@
+package webdav
+
+import (
+	"sync"
+	"time"
+
+	"pigpak/internal/telegram"
+)
+
+// downloadPathTTL is how long a resolved download path is reused. The Bot
+// API guarantees a link for at least an hour.
+const downloadPathTTL = 50 * time.Minute
+
+// maxDownloadPaths bounds the cache; past it expired entries are dropped,
+// and everything if still full.
+const maxDownloadPaths = 4096
+
+// downloadPaths caches the getFile paths of the files and parts being read,
+// across requests. A video player seeking through a file sends a Range
+// request for every jump, each opening the file afresh; without the cache
+// every one of them would cost a getFile call before the first byte.
+var downloadPaths = &pathCache{entries: make(map[pathKey]cachedPath)}
+
+type pathKey struct {
+	tg     telegram.BotAPI
+	fileID string
+}
+
+type cachedPath struct {
+	path    string
+	expires time.Time
+}
+
+type pathCache struct {
+	mu      sync.Mutex
+	entries map[pathKey]cachedPath
+}
+
+func (c *pathCache) get(tg telegram.BotAPI, fileID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[pathKey{tg, fileID}]
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.path, true
+}
+
+func (c *pathCache) put(tg telegram.BotAPI, fileID, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= maxDownloadPaths {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= maxDownloadPaths {
+			clear(c.entries)
+		}
+	}
+	c.entries[pathKey{tg, fileID}] = cachedPath{path: path, expires: now.Add(downloadPathTTL)}
+}
+
+// forget drops a path that stopped working before it expired.
+func (c *pathCache) forget(tg telegram.BotAPI, fileID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, pathKey{tg, fileID})
+}
@@ -0,0 +1,73 @@
+package webdav
+
+import "io"
+
+// prefetchWindow is how near the end of a part a sequential read gets before
+// the next part is opened in the background, and how much it must have read
+// since its last seek to count as sequential. Opening a part costs a getFile
+// call and a new download, which would otherwise stall a large download at
+// every part boundary; a short Range read never gets far enough to start one.
+const prefetchWindow = 16 << 20
+
+// prefetch is the next part of a readFile being opened ahead of the read.
+type prefetch struct {
+	index  int
+	done   chan struct{}
+	reader io.ReadCloser
+	err    error
+}
+
+// prefetchLocked starts opening the part after the current one once a
+// sequential read is within prefetchWindow of the current part's end.
+func (f *readFile) prefetchLocked() {
+	if f.next != nil || f.partIndex+1 >= len(f.parts) || f.streamed < prefetchWindow {
+		return
+	}
+	if f.parts[f.partIndex].Size-f.partOffset > prefetchWindow {
+		return
+	}
+	part := f.parts[f.partIndex+1]
+	p := &prefetch{index: f.partIndex + 1, done: make(chan struct{})}
+	f.next = p
+	go func() {
+		p.reader, p.err = f.download(part.TelegramFileID, part.FileUniqueID, part.Size, 0)
+		close(p.done)
+	}()
+}
+
+// takePrefetchLocked returns the prefetched stream when it is for where the
+// read is now, waiting for it to open. Any other prefetch is discarded.
+func (f *readFile) takePrefetchLocked() io.ReadCloser {
+	p := f.next
+	if p == nil {
+		return nil
+	}
+	f.next = nil
+	if p.index != f.partIndex || f.partOffset != 0 {
+		p.discard()
+		return nil
+	}
+	<-p.done
+	if p.err != nil {
+		// Opening the part again reports the error if it persists.
+		return nil
+	}
+	return p.reader
+}
+
+// discardPrefetchLocked drops a prefetch the read no longer needs.
+func (f *readFile) discardPrefetchLocked() {
+	if f.next != nil {
+		f.next.discard()
+		f.next = nil
+	}
+}
+
+func (p *prefetch) discard() {
+	go func() {
+		<-p.done
+		if p.reader != nil {
+			_ = p.reader.Close()
+		}
+	}()
+}
@@ -0,0 +1,126 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"pigpak/internal/db"
+)
+
+type davTreeKey struct{}
+
+// davTree is the subtree a Depth: infinity PROPFIND lists, loaded with one
+// ListTree call so that walking it does not look every entry up by path.
+type davTree struct {
+	// entries are keyed by clean path; listings by folder id hold what a
+	// folder's Readdir returns before its shortcuts.
+	entries  map[string]davEntry
+	listings map[int64][]os.FileInfo
+}
+
+// deepPropfind serves a PROPFIND with Depth: infinity, or without a Depth
+// header, which means the same, from a tree loaded up front. Trees over
+// WEB_DAV_PROPFIND_MAX_ITEMS or _MAX_DEPTH get 403 with the
+// propfind-finite-depth precondition of RFC 4918, which tells clients to
+// list folder by folder instead.
+func (fs *davFS) deepPropfind(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if depth := strings.TrimSpace(r.Header.Get("Depth")); depth != "" && !strings.EqualFold(depth, "infinity") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		userID, err := fs.userID(ctx)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name := path.Clean("/" + r.URL.Path)
+		entry, err := fs.resolve(ctx, userID, name)
+		if err != nil || !entry.isDir {
+			// The handler answers missing targets; a file lists alone.
+			next.ServeHTTP(w, r)
+			return
+		}
+		if fs.propfindMaxItems <= 0 {
+			refuseInfiniteDepth(w)
+			return
+		}
+		tree, err := fs.store.ListTree(ctx, userID, entry.dir.ID, fs.propfindMaxItems, fs.propfindMaxDepth)
+		if errors.Is(err, db.ErrTreeTooLarge) {
+			refuseInfiniteDepth(w)
+			return
+		}
+		if err != nil {
+			log.Printf("webdav propfind %s: %v", name, err)
+			http.Error(w, "list failed", http.StatusInternalServerError)
+			return
+		}
+		ctx = context.WithValue(ctx, davTreeKey{}, fs.newDavTree(name, entry.dir, tree))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// refuseInfiniteDepth answers a Depth: infinity PROPFIND the server will not
+// list.
+func refuseInfiniteDepth(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`))
+}
+
+// newDavTree indexes the tree below root, served at name, the way resolve
+// and dirFile would find it. Files hidden as junk are left out, so resolve
+// goes on looking them up itself.
+func (fs *davFS) newDavTree(name string, root db.Directory, tree db.Tree) *davTree {
+	t := &davTree{entries: make(map[string]davEntry), listings: make(map[int64][]os.FileInfo)}
+	paths := map[int64]string{root.ID: name}
+	t.entries[name] = davEntry{isDir: true, dir: root}
+	t.listings[root.ID] = []os.FileInfo{}
+	for _, dir := range tree.Dirs {
+		parent, ok := paths[dir.ParentID.Int64]
+		if !ok {
+			continue
+		}
+		p := path.Join(parent, dir.Name)
+		paths[dir.ID] = p
+		t.entries[p] = davEntry{isDir: true, dir: dir}
+		t.listings[dir.ParentID.Int64] = append(t.listings[dir.ParentID.Int64], dirInfo(dir))
+		t.listings[dir.ID] = []os.FileInfo{}
+	}
+	for _, file := range tree.Files {
+		dirPath, ok := paths[file.DirID]
+		if !ok || fs.hidesJunk(file.Name) {
+			continue
+		}
+		p := path.Join(dirPath, file.Name)
+		if _, taken := t.entries[p]; !taken {
+			t.entries[p] = davEntry{file: file}
+		}
+		t.listings[file.DirID] = append(t.listings[file.DirID], fileInfo(file))
+	}
+	return t
+}
+
+// listing returns what a folder of the tree lists, apart from shortcuts.
+func (t *davTree) listing(dirID int64) ([]os.FileInfo, bool) {
+	if t == nil {
+		return nil, false
+	}
+	infos, ok := t.listings[dirID]
+	return infos, ok
+}
+
+func treeFrom(ctx context.Context) *davTree {
+	t, _ := ctx.Value(davTreeKey{}).(*davTree)
+	return t
+}
@@ -0,0 +1,132 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"pigpak/internal/db"
+)
+
+// mtimeProps are the properties sync clients PROPPATCH to carry a file's
+// modification time over, since DAV:getlastmodified itself is read-only:
+// rclone's ownCloud and Nextcloud vendors send DAV:lastmodified in Unix
+// seconds, Windows Explorer sends Win32LastModifiedTime as an HTTP date. On
+// a file they set its modification time instead of being stored.
+var mtimeProps = map[xml.Name]bool{
+	{Space: "DAV:", Local: "lastmodified"}:                                true,
+	{Space: "urn:schemas-microsoft-com:", Local: "Win32LastModifiedTime"}: true,
+}
+
+// parseMTime reads a modification time sent as Unix seconds or an HTTP
+// date, the forms X-OC-MTime and mtimeProps come in.
+func parseMTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil && secs > 0 {
+		return time.Unix(secs, 0).UTC(), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t.UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// davProps holds the dead properties of the file or folder a readFile or
+// dirFile serves, making them a webdav.DeadPropsHolder.
+type davProps struct {
+	ctx    context.Context
+	store  *db.Store
+	entity string
+	id     int64
+	// ownerID is the file's owner, for setting its modification time; 0
+	// on folders.
+	ownerID int64
+}
+
+func (p davProps) DeadProps() (map[xml.Name]webdav.Property, error) {
+	if p.store == nil {
+		return nil, nil
+	}
+	props, err := p.store.ListDAVProperties(p.ctx, p.entity, p.id)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[xml.Name]webdav.Property, len(props))
+	for _, prop := range props {
+		name := xml.Name{Space: prop.Namespace, Local: prop.Name}
+		out[name] = webdav.Property{XMLName: name, Lang: prop.Lang, InnerXML: []byte(prop.Value)}
+	}
+	return out, nil
+}
+
+func (p davProps) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	var set, remove []db.DAVProperty
+	var mtime time.Time
+	var bad []webdav.Property
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			dp := db.DAVProperty{Namespace: prop.XMLName.Space, Name: prop.XMLName.Local, Lang: prop.Lang, Value: string(prop.InnerXML)}
+			switch {
+			case p.entity == db.DAVPropertyFile && mtimeProps[prop.XMLName]:
+				if patch.Remove {
+					continue
+				}
+				t, ok := parseMTime(dp.Value)
+				if !ok {
+					bad = append(bad, webdav.Property{XMLName: prop.XMLName})
+					continue
+				}
+				mtime = t
+			case patch.Remove:
+				remove = append(remove, dp)
+			default:
+				set = append(set, dp)
+			}
+		}
+	}
+	if len(bad) > 0 {
+		// PROPPATCH is all or nothing: the rest fail with them.
+		failed := webdav.Propstat{Status: webdav.StatusFailedDependency}
+		for _, patch := range patches {
+			for _, prop := range patch.Props {
+				if !containsProp(bad, prop.XMLName) {
+					failed.Props = append(failed.Props, webdav.Property{XMLName: prop.XMLName})
+				}
+			}
+		}
+		out := []webdav.Propstat{{Status: http.StatusConflict, Props: bad}}
+		if len(failed.Props) > 0 {
+			out = append(out, failed)
+		}
+		return out, nil
+	}
+	if !mtime.IsZero() {
+		if err := p.store.SetFileModifiedAt(p.ctx, p.ownerID, p.id, mtime); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.store.PatchDAVProperties(p.ctx, p.entity, p.id, set, remove); err != nil {
+		return nil, err
+	}
+	ok := webdav.Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			ok.Props = append(ok.Props, webdav.Property{XMLName: prop.XMLName})
+		}
+	}
+	return []webdav.Propstat{ok}, nil
+}
+
+func containsProp(props []webdav.Property, name xml.Name) bool {
+	for _, p := range props {
+		if p.XMLName == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+
+	"pigpak/internal/db"
+)
+
+// guardQuota answers 507 Insufficient Storage to a PUT whose body would not
+// fit in the quota of the folder's owner, before any of it is sent to
+// Telegram. Bodies of unknown length get past it and are refused when the
+// file is added.
+func (fs *davFS) guardQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		if r.Method != http.MethodPut || r.ContentLength <= 0 || fs.isJunk(name) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		userID, err := fs.userID(ctx)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		parentParts, base := splitPath(name)
+		parent, err := fs.findDir(ctx, userID, parentParts)
+		if err != nil || base == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		err = fs.store.CheckQuota(ctx, parent.UserID, base, r.ContentLength-replacedSize(ctx, fs.store, parent.UserID, parent.ID, base))
+		if errors.Is(err, db.ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// replacedSize is the size of the file an upload to name in dirID replaces,
+// which no longer counts against the quota once it does.
+func replacedSize(ctx context.Context, store *db.Store, userID, dirID int64, name string) int64 {
+	file, err := store.GetFileByName(ctx, userID, dirID, name)
+	if err != nil {
+		return 0
+	}
+	return file.Size
+}
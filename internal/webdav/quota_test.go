@@ -0,0 +1,79 @@
+package webdav_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+
+	"pigpak/internal/db"
+)
+
+func TestPutRespectsQuota(t *testing.T) {
+	l := newLitmus(t)
+	if err := l.store.SetQuota(context.Background(), litmusUserID, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.put("/a.bin", litmusContent(60)); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.expect(l.do(http.MethodPut, "/b.bin", litmusContent(60), nil), http.StatusInsufficientStorage); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.missing("/b.bin"); err != nil {
+		t.Fatal(err)
+	}
+	// Replacing a file only needs room for the difference.
+	if err := l.put("/a.bin", litmusContent(90)); err != nil {
+		t.Fatal(err)
+	}
+	// A body of unknown length is refused once it is stored.
+	req, err := http.NewRequest(http.MethodPut, l.srv.URL+"/c.bin", io.MultiReader(bytes.NewReader(litmusContent(20))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(litmusUsername, litmusPassword)
+	resp, err := l.srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 400 {
+		t.Fatalf("PUT of unknown length over the quota got %d", resp.StatusCode)
+	}
+	if err := l.missing("/c.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	quota, err := l.store.GetQuota(context.Background(), litmusUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.Used != 90 {
+		t.Fatalf("quota used = %d, want 90", quota.Used)
+	}
+
+	events, err := l.store.ListOutbox(context.Background(), 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refused []string
+	for _, e := range events {
+		if e.Event == db.EventQuotaExceeded {
+			var payload struct {
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal([]byte(e.Payload), &payload); err != nil {
+				t.Fatal(err)
+			}
+			refused = append(refused, payload.Name)
+		}
+	}
+	if want := []string{"b.bin", "c.bin"}; !slices.Equal(refused, want) {
+		t.Fatalf("quota.exceeded events for %v, want %v", refused, want)
+	}
+}
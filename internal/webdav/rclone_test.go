@@ -0,0 +1,358 @@
+package webdav_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rcloneRemote is the name the scratch server is configured under.
+const rcloneRemote = "pigpak"
+
+// rcloneVendors are the WebDAV vendors TestRclone configures the remote
+// with. Remotes pointing at pigpak should use owncloud, which carries
+// modification times with X-OC-Mtime. The nextcloud vendor sends large
+// files through Nextcloud's chunked upload API, which pigpak does not
+// serve; set its chunk_size to 0 to use it.
+var rcloneVendors = []string{"owncloud", "other"}
+
+// rcloneModTimeVendors are the vendors rclone sets modification times for.
+var rcloneModTimeVendors = map[string]bool{"owncloud": true, "nextcloud": true, "fastmail": true, "rclone": true}
+
+// rcloneCheck is one step of the suite. Steps run in order and build on
+// each other within a vendor's folder, so a vendor stops at its first
+// failure.
+type rcloneCheck struct {
+	name string
+	// modTimes marks steps that need the vendor to carry modification
+	// times.
+	modTimes bool
+	run      func(r *rcloneRun) error
+}
+
+var rcloneChecks = []rcloneCheck{
+	{name: "sync", run: checkRcloneSync},
+	{name: "resync", run: checkRcloneResync},
+	{name: "modtime", modTimes: true, run: checkRcloneModTime},
+	{name: "update", run: checkRcloneUpdate},
+	{name: "rcat", run: checkRcloneRcat},
+	{name: "moveto", run: checkRcloneMoveto},
+	{name: "delete", run: checkRcloneDelete},
+	{name: "purge", run: checkRclonePurge},
+}
+
+// TestRclone runs the rclone binary on PATH against a litmus server. For
+// each vendor it syncs a local tree up and checks it byte for byte, syncs
+// again expecting no transfers, compares modification times where the
+// vendor carries them, streams a file of unknown size with rclone rcat (a
+// chunked PUT without Content-Length), and moves, deletes and purges.
+func TestRclone(t *testing.T) {
+	bin, err := exec.LookPath("rclone")
+	if err != nil {
+		t.Skip("rclone is not on PATH")
+	}
+	r := &rcloneRun{bin: bin, l: newLitmus(t), work: t.TempDir()}
+	if r.pass, err = r.obscure(""); err != nil {
+		t.Fatalf("rclone obscure: %v", err)
+	}
+	for _, vendor := range rcloneVendors {
+		t.Run(vendor, func(t *testing.T) {
+			r.vendor = vendor
+			r.base = rcloneRemote + ":" + vendor + "/"
+			r.local = filepath.Join(r.work, vendor)
+			for _, check := range rcloneChecks {
+				if check.modTimes && !rcloneModTimeVendors[vendor] {
+					continue
+				}
+				ok := t.Run(check.name, func(t *testing.T) {
+					if err := check.run(r); err != nil {
+						t.Fatal(err)
+					}
+				})
+				if !ok {
+					break
+				}
+			}
+		})
+	}
+}
+
+// TestRcloneBackend runs rclone's own WebDAV backend integration tests
+// from the rclone source checkout named by PIGPAK_RCLONE_SOURCE against a
+// litmus server:
+//
+//	PIGPAK_RCLONE_SOURCE=~/src/rclone go test ./internal/webdav -run TestRcloneBackend
+func TestRcloneBackend(t *testing.T) {
+	dir := os.Getenv("PIGPAK_RCLONE_SOURCE")
+	if dir == "" {
+		t.Skip("PIGPAK_RCLONE_SOURCE is not set")
+	}
+	r := &rcloneRun{l: newLitmus(t), work: t.TempDir(), vendor: rcloneVendors[0]}
+	var err error
+	if r.pass, err = r.obscure(dir); err != nil {
+		t.Fatalf("rclone obscure: %v", err)
+	}
+	cmd := exec.Command("go", "test", "./backend/webdav", "-run", "^TestIntegration$", "-remote", rcloneRemote+":")
+	cmd.Dir = dir
+	cmd.Env = r.env()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("rclone backend tests: %v\n%s", err, out)
+	}
+}
+
+type rcloneRun struct {
+	bin  string
+	l    *litmus
+	work string
+	// pass is the litmus password, obscured as rclone configs hold it.
+	pass string
+	// vendor is the vendor being checked; base is its folder on the
+	// remote and local its tree on disk.
+	vendor string
+	base   string
+	local  string
+}
+
+// env configures the remote through rclone's environment variables, with
+// a config file of its own so the user's remotes are left alone.
+func (r *rcloneRun) env() []string {
+	return append(os.Environ(),
+		"RCLONE_CONFIG="+filepath.Join(r.work, "rclone.conf"),
+		"RCLONE_CONFIG_PIGPAK_TYPE=webdav",
+		"RCLONE_CONFIG_PIGPAK_URL="+r.l.srv.URL,
+		"RCLONE_CONFIG_PIGPAK_VENDOR="+r.vendor,
+		"RCLONE_CONFIG_PIGPAK_USER="+litmusUsername,
+		"RCLONE_CONFIG_PIGPAK_PASS="+r.pass,
+	)
+}
+
+// obscure returns the litmus password as rclone stores it, using the
+// rclone binary or, when dir is an rclone checkout, its source.
+func (r *rcloneRun) obscure(dir string) (string, error) {
+	cmd := exec.Command(r.bin, "obscure", litmusPassword)
+	if dir != "" {
+		cmd = exec.Command("go", "run", ".", "obscure", litmusPassword)
+		cmd.Dir = dir
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// rclone runs rclone with args and returns what it wrote to stdout and to
+// stderr, where it logs. A failure carries the log.
+func (r *rcloneRun) rclone(stdin io.Reader, args ...string) ([]byte, []byte, error) {
+	cmd := exec.Command(r.bin, args...)
+	cmd.Env = r.env()
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, nil, fmt.Errorf("rclone %s: %w\n%s", strings.Join(args, " "), err, stderr.Bytes())
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
+
+// rcloneFile is a file of the local tree.
+type rcloneFile struct {
+	path  string
+	size  int
+	mtime time.Time
+}
+
+// rcloneTree spans split files and names that need escaping. Modification
+// times are whole seconds in the past, as WebDAV carries them.
+var rcloneTree = []rcloneFile{
+	{"a.txt", 10, time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)},
+	{"empty", 0, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+	{"dir one/big.bin", 3*litmusPartSize + 123, time.Date(2022, 6, 7, 8, 9, 10, 0, time.UTC)},
+	{"dir one/sub/c #1 100%.txt", 300, time.Date(2019, 11, 12, 13, 14, 15, 0, time.UTC)},
+}
+
+func (r *rcloneRun) writeLocal(f rcloneFile, content []byte) error {
+	p := filepath.Join(r.local, filepath.FromSlash(f.path))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, content, 0o644); err != nil {
+		return err
+	}
+	return os.Chtimes(p, f.mtime, f.mtime)
+}
+
+// sync runs rclone sync of the local tree and returns how many files it
+// copied.
+func (r *rcloneRun) sync() (int, error) {
+	_, logs, err := r.rclone(nil, "sync", "-v", r.local, r.base)
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Count(logs, []byte(": Copied")), nil
+}
+
+// check fails unless the remote holds the local tree, compared by content.
+func (r *rcloneRun) check() error {
+	_, _, err := r.rclone(nil, "check", "--download", r.local, r.base)
+	return err
+}
+
+// list returns the remote's files below path.
+func (r *rcloneRun) list(path string) (map[string]time.Time, error) {
+	out, _, err := r.rclone(nil, "lsjson", "-R", "--files-only", r.base+path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []struct {
+		Path    string
+		ModTime time.Time
+	}
+	if err := json.Unmarshal(out, &entries); err != nil {
+		return nil, fmt.Errorf("rclone lsjson: %w", err)
+	}
+	files := make(map[string]time.Time, len(entries))
+	for _, e := range entries {
+		files[e.Path] = e.ModTime
+	}
+	return files, nil
+}
+
+func checkRcloneSync(r *rcloneRun) error {
+	for _, f := range rcloneTree {
+		if err := r.writeLocal(f, litmusContent(f.size)); err != nil {
+			return err
+		}
+	}
+	copied, err := r.sync()
+	if err != nil {
+		return err
+	}
+	if copied != len(rcloneTree) {
+		return fmt.Errorf("sync copied %d files, want %d", copied, len(rcloneTree))
+	}
+	return r.check()
+}
+
+func checkRcloneResync(r *rcloneRun) error {
+	copied, err := r.sync()
+	if err != nil {
+		return err
+	}
+	if copied != 0 {
+		return fmt.Errorf("second sync copied %d files, want none", copied)
+	}
+	return nil
+}
+
+func checkRcloneModTime(r *rcloneRun) error {
+	files, err := r.list("")
+	if err != nil {
+		return err
+	}
+	for _, f := range rcloneTree {
+		got, ok := files[f.path]
+		if !ok {
+			return fmt.Errorf("%s is missing", f.path)
+		}
+		if !got.Equal(f.mtime) {
+			return fmt.Errorf("%s: modification time %s, want %s", f.path, got.UTC().Format(time.RFC3339), f.mtime.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+func checkRcloneUpdate(r *rcloneRun) error {
+	f := rcloneTree[0]
+	f.size += 5
+	f.mtime = f.mtime.Add(time.Hour)
+	if err := r.writeLocal(f, bytes.Repeat([]byte("u"), f.size)); err != nil {
+		return err
+	}
+	copied, err := r.sync()
+	if err != nil {
+		return err
+	}
+	if copied != 1 {
+		return fmt.Errorf("sync of a changed file copied %d files, want 1", copied)
+	}
+	return r.check()
+}
+
+func checkRcloneRcat(r *rcloneRun) error {
+	content := litmusContent(5*litmusPartSize/2 + 7)
+	// Without a streaming cutoff rclone sends stdin as it reads it, with
+	// no Content-Length.
+	if _, _, err := r.rclone(bytes.NewReader(content), "rcat", "--streaming-upload-cutoff", "0", r.base+"streamed.bin"); err != nil {
+		return err
+	}
+	got, _, err := r.rclone(nil, "cat", r.base+"streamed.bin")
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, content) {
+		return fmt.Errorf("streamed.bin: got %d bytes back, want %d", len(got), len(content))
+	}
+	return nil
+}
+
+func checkRcloneMoveto(r *rcloneRun) error {
+	if _, _, err := r.rclone(nil, "moveto", r.base+"streamed.bin", r.base+"moved/streamed.bin"); err != nil {
+		return err
+	}
+	files, err := r.list("")
+	if err != nil {
+		return err
+	}
+	if _, ok := files["streamed.bin"]; ok {
+		return errors.New("streamed.bin is still there after moveto")
+	}
+	if _, ok := files["moved/streamed.bin"]; !ok {
+		return errors.New("moved/streamed.bin is missing after moveto")
+	}
+	_, _, err = r.rclone(nil, "purge", r.base+"moved")
+	return err
+}
+
+func checkRcloneDelete(r *rcloneRun) error {
+	f := rcloneTree[3]
+	if err := os.Remove(filepath.Join(r.local, filepath.FromSlash(f.path))); err != nil {
+		return err
+	}
+	if _, err := r.sync(); err != nil {
+		return err
+	}
+	files, err := r.list("")
+	if err != nil {
+		return err
+	}
+	if _, ok := files[f.path]; ok {
+		return fmt.Errorf("%s is still there after sync", f.path)
+	}
+	return r.check()
+}
+
+func checkRclonePurge(r *rcloneRun) error {
+	if _, _, err := r.rclone(nil, "purge", r.base); err != nil {
+		return err
+	}
+	out, _, err := r.rclone(nil, "lsf", rcloneRemote+":")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == r.vendor+"/" {
+			return fmt.Errorf("%s is still there after purge", r.vendor)
+		}
+	}
+	return nil
+}
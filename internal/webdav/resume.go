@@ -0,0 +1,73 @@
+package webdav
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+
+	"pigpak/internal/db"
+)
+
+// storedPrefix tracks the start of a retried PUT that an interrupted attempt
+// at the same path and size already stored. Clients that cannot resume with
+// Content-Range simply send the whole file again; its stored parts are read
+// and checked against their hashes instead of being uploaded a second time.
+type storedPrefix struct {
+	parts []db.FilePartInput
+	// index is the part being read and offset how much of it has been.
+	index  int
+	offset int64
+	hash   hash.Hash
+}
+
+func newStoredPrefix(parts []db.FilePartInput) *storedPrefix {
+	return &storedPrefix{parts: parts, hash: sha256.New()}
+}
+
+// retriedBy reports whether a PUT without a resume offset is a retry of the
+// interrupted upload in s: its body is the size the upload was started with
+// and s holds hashed parts to skip.
+func (s *uploadSession) retriedBy(cr contentRange, contentLength int64) bool {
+	if s == nil || cr.ok || len(s.parts) == 0 || contentLength <= 0 {
+		return false
+	}
+	if s.totalSize != contentLength || s.uploadedSize >= contentLength {
+		return false
+	}
+	for _, part := range s.parts {
+		if part.Size <= 0 || part.SHA256 == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// skipPrefixLocked reads the start of p as the stored prefix and returns how
+// many bytes it took. A part whose bytes differ from what was stored means
+// the file changed since the interrupted attempt; the upload then fails so
+// the client's next try starts over.
+func (f *uploadFile) skipPrefixLocked(p []byte) (int, error) {
+	sp := f.prefix
+	part := sp.parts[sp.index]
+	n := int(min(int64(len(p)), part.Size-sp.offset))
+	_, _ = sp.hash.Write(p[:n])
+	f.acceptLocked(p[:n])
+	sp.offset += int64(n)
+	if sp.offset < part.Size {
+		return n, nil
+	}
+	if hex.EncodeToString(sp.hash.Sum(nil)) != part.SHA256 {
+		err := fmt.Errorf("%s changed since its upload was interrupted (part %d differs), retry to upload it again: %w", f.name, part.PartIndex+1, os.ErrInvalid)
+		f.abortLocked(err)
+		return n, err
+	}
+	sp.index++
+	sp.offset = 0
+	sp.hash.Reset()
+	if sp.index == len(sp.parts) {
+		f.prefix = nil
+	}
+	return n, nil
+}
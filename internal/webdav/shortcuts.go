@@ -0,0 +1,165 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"pigpak/internal/db"
+)
+
+// shortcutExt names shortcuts over WebDAV, where they are Internet Shortcut
+// files that desktop file managers open in a browser. A real file with the
+// same name hides the shortcut.
+const shortcutExt = ".url"
+
+// shortcutEntry is a shortcut with the file served for it.
+type shortcutEntry struct {
+	shortcut db.Shortcut
+	content  []byte
+}
+
+func (e shortcutEntry) info() davFileInfo {
+	return davFileInfo{
+		name:    e.shortcut.Name + shortcutExt,
+		size:    int64(len(e.content)),
+		mode:    0o444,
+		modTime: e.shortcut.CreatedAt,
+		etag:    fmt.Sprintf(`"sc%d-%08x"`, e.shortcut.ID, crc32.ChecksumIEEE(e.content)),
+	}
+}
+
+// findShortcut looks up the shortcut served as the file parts names.
+func (fs *davFS) findShortcut(ctx context.Context, userID, rootID int64, parts []string) (shortcutEntry, error) {
+	base := parts[len(parts)-1]
+	name, ok := strings.CutSuffix(base, shortcutExt)
+	if !ok || name == "" {
+		return shortcutEntry{}, os.ErrNotExist
+	}
+	dirID := rootID
+	if len(parts) > 1 {
+		dir, err := fs.store.FindDirByPathFrom(ctx, userID, rootID, parts[:len(parts)-1])
+		if err != nil {
+			return shortcutEntry{}, os.ErrNotExist
+		}
+		dirID = dir.ID
+	}
+	sc, err := fs.store.GetShortcutByName(ctx, userID, dirID, name)
+	if err != nil {
+		return shortcutEntry{}, os.ErrNotExist
+	}
+	entry, ok := fs.shortcutEntry(ctx, userID, sc)
+	if !ok {
+		return shortcutEntry{}, os.ErrNotExist
+	}
+	return entry, nil
+}
+
+// shortcutEntry renders sc as an Internet Shortcut. Shortcuts without a URL
+// to point at are not served: broken ones, own targets outside the served
+// folder, and targets when the public URLs they need are not configured.
+func (fs *davFS) shortcutEntry(ctx context.Context, userID int64, sc db.Shortcut) (shortcutEntry, bool) {
+	link, ok := fs.shortcutLink(ctx, userID, sc)
+	if !ok {
+		return shortcutEntry{}, false
+	}
+	content := "[InternetShortcut]\r\nURL=" + link + "\r\n"
+	return shortcutEntry{shortcut: sc, content: []byte(content)}, true
+}
+
+func (fs *davFS) shortcutLink(ctx context.Context, userID int64, sc db.Shortcut) (string, bool) {
+	res, err := fs.store.ResolveShortcut(ctx, sc)
+	if err != nil {
+		return "", false
+	}
+	base := strings.TrimSuffix(fs.publicURL, "/")
+	switch {
+	case res.Share != nil:
+		if fs.shareBaseURL == "" {
+			return "", false
+		}
+		return fs.shareBaseURL + "?start=share_" + url.QueryEscape(res.Share.Token), true
+	case res.Page != nil:
+		if base == "" {
+			return "", false
+		}
+		// The gateway serves published pages at /p/<token>.
+		return base + "/p/" + res.Page.Token, true
+	}
+	if base == "" {
+		return "", false
+	}
+	var dirID int64
+	if res.Dir != nil {
+		dirID = res.Dir.ID
+	} else {
+		dirID = res.File.DirID
+	}
+	p, ok := fs.servedPath(ctx, userID, dirID)
+	if !ok {
+		return "", false
+	}
+	if res.File != nil {
+		p = path.Join(p, res.File.Name)
+	}
+	return base + (&url.URL{Path: p}).EscapedPath(), true
+}
+
+// servedPath returns where dirID appears in this request's WebDAV tree.
+func (fs *davFS) servedPath(ctx context.Context, userID, dirID int64) (string, bool) {
+	rootID, err := fs.rootID(ctx, userID)
+	if err != nil {
+		return "", false
+	}
+	served, err := fs.store.DriveForDir(ctx, userID, rootID)
+	if err != nil {
+		return "", false
+	}
+	drive, err := fs.store.DriveForDir(ctx, userID, dirID)
+	if err != nil || drive.ID != served.ID {
+		return "", false
+	}
+	rootPath, err := fs.store.GetDirPath(ctx, userID, rootID)
+	if err != nil {
+		return "", false
+	}
+	dirPath, err := fs.store.GetDirPath(ctx, userID, dirID)
+	if err != nil {
+		return "", false
+	}
+	if rootPath == "/" {
+		return dirPath, true
+	}
+	if dirPath != rootPath && !strings.HasPrefix(dirPath, rootPath+"/") {
+		return "", false
+	}
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(dirPath, rootPath), "/"), true
+}
+
+// shortcutFile serves a shortcut's Internet Shortcut file.
+type shortcutFile struct {
+	*bytes.Reader
+	entry shortcutEntry
+}
+
+func newShortcutFile(entry shortcutEntry) *shortcutFile {
+	return &shortcutFile{Reader: bytes.NewReader(entry.content), entry: entry}
+}
+
+func (f *shortcutFile) Stat() (os.FileInfo, error) { return f.entry.info(), nil }
+
+func (f *shortcutFile) Write(p []byte) (int, error) {
+	return 0, errors.New("read-only")
+}
+
+func (f *shortcutFile) Close() error { return nil }
+
+func (f *shortcutFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
@@ -0,0 +1,39 @@
+package webdav
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// uploadAbortTimeout bounds how long Shutdown waits for cancelled uploads to
+// unwind, so the database is not closed under them.
+const uploadAbortTimeout = 5 * time.Second
+
+// Shutdown stops accepting connections and waits for the requests in flight
+// to finish until ctx is done. Uploads still running then are cancelled:
+// every part they finished is already recorded in their upload session, so
+// a retry of the same PUT, or one resuming with Content-Range, continues
+// from the last stored part once the server is back.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	server, cancel := s.server, s.cancel
+	s.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	if n := s.uploads.running(); n > 0 {
+		log.Printf("webdav: waiting for %d upload(s) to finish", n)
+	}
+	err := server.Shutdown(ctx)
+	if err == nil {
+		return nil
+	}
+	log.Printf("webdav: cancelling %d upload(s) still running; they can be resumed", s.uploads.running())
+	cancel()
+	_ = server.Close()
+	if !s.uploads.wait(uploadAbortTimeout) {
+		log.Printf("webdav: uploads still running after %s", uploadAbortTimeout)
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package webdav
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+
+	"pigpak/internal/db"
+)
+
+type webdavScopeKey struct{}
+
+// davScope restricts a request authenticated with temporary credentials.
+type davScope struct {
+	rootID   int64
+	readOnly bool
+}
+
+// serveTemp authenticates a temporary login and runs next within its scope.
+func (s *Server) serveTemp(w http.ResponseWriter, r *http.Request, next http.Handler, username, password string) {
+	cred, ok, err := s.store.VerifyWebDAVTempCredential(r.Context(), username, password)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="webdav"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	scope := davScope{readOnly: cred.ReadOnly}
+	if cred.ScopeDirID.Valid {
+		scope.rootID = cred.ScopeDirID.Int64
+	}
+	if scope.readOnly && isWriteMethod(r.Method) {
+		http.Error(w, "read-only credentials", http.StatusForbidden)
+		return
+	}
+	ctx := context.WithValue(r.Context(), webdavScopeKey{}, scope)
+	s.serveUser(ctx, w, r, next, cred.UserID)
+}
+
+func scopeFromContext(ctx context.Context) (davScope, bool) {
+	scope, ok := ctx.Value(webdavScopeKey{}).(davScope)
+	return scope, ok
+}
+
+// isWriteMethod reports whether a WebDAV method can change the drive or
+// the locks held on it.
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPut, http.MethodDelete, http.MethodPost, http.MethodPatch, "MKCOL", "MOVE", "COPY", "PROPPATCH", "LOCK", "UNLOCK":
+		return true
+	}
+	return false
+}
+
+// checkWritable rejects changes made with read-only credentials.
+func (fs *davFS) checkWritable(ctx context.Context) error {
+	if scope, ok := scopeFromContext(ctx); ok && scope.readOnly {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// rootID returns the folder served as "/": the credential's scope folder, or
+// the root of the user's primary drive.
+func (fs *davFS) rootID(ctx context.Context, userID int64) (int64, error) {
+	if scope, ok := scopeFromContext(ctx); ok && scope.rootID != 0 {
+		return scope.rootID, nil
+	}
+	return fs.store.GetRootDirID(ctx, userID)
+}
+
+// findDir resolves a folder path below the served root. A missing folder
+// is os.ErrNotExist, which the handler answers with 409 Conflict when it is
+// the parent of a PUT or MKCOL.
+func (fs *davFS) findDir(ctx context.Context, userID int64, parts []string) (db.Directory, error) {
+	rootID, err := fs.rootID(ctx, userID)
+	if err != nil {
+		return db.Directory{}, err
+	}
+	dir, err := fs.store.FindDirByPathFrom(ctx, userID, rootID, parts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.Directory{}, os.ErrNotExist
+	}
+	return dir, err
+}
@@ -0,0 +1,100 @@
+package webdav
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// uploadRetryAfter is the Retry-After, in seconds, sent with a PUT turned
+// away because too many uploads are running.
+const uploadRetryAfter = 5
+
+// uploadLimiter bounds the PUTs streaming parts to Telegram, in total and
+// per user. Each PUT holds a slot for as long as it runs: its body is piped
+// straight into a part upload, so a PUT is an upload in flight from its
+// first byte to the stored file. A zero limit leaves that side unbounded.
+type uploadLimiter struct {
+	total   chan struct{}
+	perUser int
+
+	mu    sync.Mutex
+	users map[int64]int
+	// wg counts the uploads holding a slot, for a shutdown to wait on.
+	wg sync.WaitGroup
+}
+
+func newUploadLimiter(total, perUser int) *uploadLimiter {
+	l := &uploadLimiter{perUser: perUser, users: make(map[int64]int)}
+	if total > 0 {
+		l.total = make(chan struct{}, total)
+	}
+	return l
+}
+
+// acquire takes a slot for one of userID's uploads without waiting, and
+// reports false when the user or the server is at its limit.
+func (l *uploadLimiter) acquire(userID int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perUser > 0 && l.users[userID] >= l.perUser {
+		return false
+	}
+	if l.total != nil {
+		select {
+		case l.total <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	l.users[userID]++
+	l.wg.Add(1)
+	return true
+}
+
+func (l *uploadLimiter) release(userID int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.total != nil {
+		<-l.total
+	}
+	if l.users[userID]--; l.users[userID] <= 0 {
+		delete(l.users, userID)
+	}
+	l.wg.Done()
+}
+
+// running returns how many uploads hold a slot.
+func (l *uploadLimiter) running() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, count := range l.users {
+		n += count
+	}
+	return n
+}
+
+// wait blocks until every upload has released its slot or timeout passes,
+// and reports whether they all did.
+func (l *uploadLimiter) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// refuseUpload answers a PUT over the limits with 503 and Retry-After, which
+// rclone and the desktop clients honor by retrying the transfer later.
+func refuseUpload(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(uploadRetryAfter))
+	http.Error(w, "too many uploads in progress, retry later", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,39 @@
+package webdav
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serve runs server over HTTPS when a certificate or ACME domains are
+// configured, so Basic Auth never crosses the network in the clear, and
+// over plain HTTP behind a TLS-terminating proxy otherwise.
+func (s *Server) serve(server *http.Server) error {
+	switch {
+	case s.cfg.WebDAVTLSCert != "":
+		return server.ListenAndServeTLS(s.cfg.WebDAVTLSCert, s.cfg.WebDAVTLSKey)
+	case len(s.cfg.WebDAVACMEDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(s.cfg.WebDAVACMEDomains...),
+			Cache:      autocert.DirCache(s.cfg.WebDAVACMECacheDir),
+			Email:      s.cfg.WebDAVACMEEmail,
+		}
+		// The TLS config answers TLS-ALPN-01 challenges on the WebDAV port.
+		server.TLSConfig = m.TLSConfig()
+		if addr := s.cfg.WebDAVACMEHTTPAddr; addr != "" {
+			go func() {
+				challenge := &http.Server{Addr: addr, Handler: m.HTTPHandler(nil), ReadHeaderTimeout: 10 * time.Second}
+				log.Printf("webdav acme http listening on %s", addr)
+				if err := challenge.ListenAndServe(); err != nil {
+					log.Printf("webdav acme http server stopped: %v", err)
+				}
+			}()
+		}
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
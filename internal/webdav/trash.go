@@ -0,0 +1,237 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"pigpak/internal/db"
+)
+
+// trashFolder is the read-only folder at the root of a drive that lists its
+// trash, the same items /trash shows in the bot. DELETE moves files and
+// folders there; MOVE-ing an item out restores it. It hides a real folder of
+// the same name, and sessions scoped to a subfolder do not have it.
+const trashFolder = ".trash"
+
+// trashEntry is .trash itself, when item is zero, or an item in it.
+type trashEntry struct {
+	// name is what the entry is listed as: the item's name, with its id
+	// added when a more recently deleted item has the same name.
+	name string
+	item db.TrashItem
+	// modTime is when the newest item was deleted, for .trash itself.
+	modTime time.Time
+}
+
+func (t trashEntry) isRoot() bool {
+	return t.item.ID == 0
+}
+
+func (t trashEntry) info() davFileInfo {
+	if t.isRoot() {
+		return davFileInfo{name: t.name, mode: os.ModeDir | 0o555, modTime: t.modTime, isDir: true}
+	}
+	if t.item.IsDir() {
+		return davFileInfo{name: t.name, mode: os.ModeDir | 0o555, modTime: t.item.DeletedAt, isDir: true, etag: fmt.Sprintf(`"tr-%d"`, t.item.ID)}
+	}
+	return davFileInfo{name: t.name, size: t.item.Size, mode: 0o444, modTime: t.item.DeletedAt, etag: fmt.Sprintf(`"tr-%d"`, t.item.ID)}
+}
+
+// showsTrash reports whether the session has .trash: it is served at the
+// user's drive root, not scoped to a folder below it.
+func (fs *davFS) showsTrash(ctx context.Context) bool {
+	scope, ok := scopeFromContext(ctx)
+	return !ok || scope.rootID == 0
+}
+
+// inTrash reports whether name is .trash or a path inside it.
+func (fs *davFS) inTrash(ctx context.Context, name string) bool {
+	clean := strings.TrimPrefix(path.Clean("/"+name), "/")
+	first, _, _ := strings.Cut(clean, "/")
+	return first == trashFolder && fs.showsTrash(ctx)
+}
+
+// listTrash returns the items trashed from the served drive, most recently
+// deleted first.
+func (fs *davFS) listTrash(ctx context.Context, userID int64) ([]trashEntry, error) {
+	rootID, err := fs.rootID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	items, err := fs.store.ListTrash(ctx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	var out []trashEntry
+	taken := make(map[string]bool)
+	for _, item := range items {
+		if item.OriginalRootID != 0 && item.OriginalRootID != rootID {
+			continue
+		}
+		name := item.Name
+		if taken[name] {
+			ext := ""
+			if !item.IsDir() {
+				ext = path.Ext(name)
+			}
+			name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), item.ID, ext)
+		}
+		taken[name] = true
+		out = append(out, trashEntry{name: name, item: item})
+	}
+	return out, nil
+}
+
+// resolveTrash resolves the path parts below the served root, parts[0]
+// being .trash. Trashed folders are listed as empty: their content comes
+// back when they are restored.
+func (fs *davFS) resolveTrash(ctx context.Context, userID int64, parts []string) (davEntry, error) {
+	if len(parts) > 2 {
+		return davEntry{}, os.ErrNotExist
+	}
+	entries, err := fs.listTrash(ctx, userID)
+	if err != nil {
+		return davEntry{}, err
+	}
+	if len(parts) == 1 {
+		root := trashEntry{name: trashFolder}
+		if len(entries) > 0 {
+			root.modTime = entries[0].item.DeletedAt
+		}
+		return davEntry{isDir: true, trash: &root}, nil
+	}
+	for _, entry := range entries {
+		if entry.name == parts[1] {
+			return davEntry{isDir: entry.item.IsDir(), trash: &entry}, nil
+		}
+	}
+	return davEntry{}, os.ErrNotExist
+}
+
+// openTrash opens .trash or one of its items for reading.
+func (fs *davFS) openTrash(ctx context.Context, userID int64, entry trashEntry) (webdav.File, error) {
+	if entry.isRoot() {
+		entries, err := fs.listTrash(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		infos := make([]os.FileInfo, 0, len(entries))
+		for _, e := range entries {
+			infos = append(infos, e.info())
+		}
+		return &trashDirFile{info: entry.info(), infos: infos}, nil
+	}
+	if entry.item.IsDir() {
+		return &trashDirFile{info: entry.info()}, nil
+	}
+	file, parts, err := fs.store.TrashedFile(ctx, userID, entry.item.ID)
+	if err != nil {
+		return nil, err
+	}
+	info := entry.info()
+	info.mimeType = file.MimeType
+	return &trashReadFile{readFile: newReadFile(ctx, fs.tg, fs.store, fs.cache, file, parts), info: info}, nil
+}
+
+// restoreTrash restores a trashed item as newName, which must be outside
+// .trash.
+func (fs *davFS) restoreTrash(ctx context.Context, userID int64, entry trashEntry, newName string) error {
+	if entry.isRoot() || fs.inTrash(ctx, newName) {
+		return os.ErrPermission
+	}
+	parentParts, base := splitPath(newName)
+	if base == "" {
+		return errors.New("invalid target name")
+	}
+	parentDir, err := fs.findDir(ctx, userID, parentParts)
+	if err != nil {
+		return err
+	}
+	return fs.store.RestoreTrashItem(ctx, userID, entry.item.ID, parentDir.ID, base)
+}
+
+// checkTrashMove plans a MOVE out of .trash, which restores the item.
+func (fs *davFS) checkTrashMove(ctx context.Context, userID int64, report moveReport, entry davEntry) (moveReport, bool, int) {
+	if entry.trash.isRoot() {
+		report.Conflicts = append(report.Conflicts, "the trash folder cannot be moved")
+		return report, true, 0
+	}
+	item := entry.trash.item
+	report.IsDir, report.Files, report.Bytes = item.IsDir(), item.Files, item.Size
+	// The trash does not count against the quota, so a restore adds to it.
+	report.UsageDelta = item.Size
+	quota, err := fs.store.GetQuota(ctx, userID)
+	if err != nil {
+		return report, false, http.StatusInternalServerError
+	}
+	report.QuotaBytes, report.UsedBytes = quota.Limit, quota.Used
+	blocked := false
+	if _, err := fs.resolve(ctx, userID, report.Destination); err == nil {
+		report.Exists, blocked = true, true
+		report.Conflicts = append(report.Conflicts, "restoring does not replace existing files")
+	}
+	if !quota.Allows(item.Size) {
+		blocked = true
+		report.Conflicts = append(report.Conflicts, "the item does not fit in the storage quota")
+	}
+	report.OK = len(report.Conflicts) == 0
+	return report, blocked, 0
+}
+
+// trashDirFile lists .trash, or a trashed folder, which lists as empty.
+type trashDirFile struct {
+	info  davFileInfo
+	infos []os.FileInfo
+}
+
+func (d *trashDirFile) Stat() (os.FileInfo, error) { return d.info, nil }
+
+func (d *trashDirFile) Read(p []byte) (int, error) { return 0, io.EOF }
+
+func (d *trashDirFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("seek not supported on directory")
+}
+
+func (d *trashDirFile) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+
+func (d *trashDirFile) Close() error { return nil }
+
+func (d *trashDirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if count <= 0 {
+		out := d.infos
+		d.infos = nil
+		return out, nil
+	}
+	if len(d.infos) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.infos))
+	out := d.infos[:n]
+	d.infos = d.infos[n:]
+	return out, nil
+}
+
+// trashReadFile reads a trashed file. It has no properties and cannot be
+// patched.
+type trashReadFile struct {
+	*readFile
+	info davFileInfo
+}
+
+func (f *trashReadFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+func (f *trashReadFile) DeadProps() (map[xml.Name]webdav.Property, error) { return nil, nil }
+
+func (f *trashReadFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return nil, os.ErrPermission
+}
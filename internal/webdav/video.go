@@ -0,0 +1,65 @@
+package webdav
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os/exec"
+
+	"pigpak/internal/media"
+	"pigpak/internal/telegram"
+)
+
+// videoHeadSize is how much of a video upload is buffered for probing and
+// the thumbnail before the rest streams through.
+const videoHeadSize = 8 * 1024 * 1024
+
+// singlePartUpload reports whether an upload of contentLength bytes will be
+// stored as one fresh part, which is the only case sent as a video: a
+// split file's parts are not playable on their own.
+func (f *uploadFile) singlePartUpload(contentLength int64) bool {
+	return contentLength > 0 && contentLength <= f.maxPartSize && f.partIndex == 0 && f.totalSize == 0
+}
+
+// uploadVideo sends the part read from pr as a streamable video, with the
+// duration, size and thumbnail that can be read from its first bytes.
+func (f *uploadFile) uploadVideo(filename string, pr *io.PipeReader) (*telegram.Message, error) {
+	head := make([]byte, videoHeadSize)
+	n, err := io.ReadFull(pr, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		_ = pr.CloseWithError(err)
+		return nil, err
+	}
+	head = head[:n]
+	var meta telegram.VideoMeta
+	if info, ok := media.ProbeMP4(head); ok {
+		meta.Duration = int(info.Duration.Seconds())
+		meta.Width = info.Width
+		meta.Height = info.Height
+	}
+	thumb, err := media.Thumbnail(f.ctx, f.ffmpegPath, head)
+	switch {
+	case err == nil:
+		meta.Thumbnail = thumb
+		f.mu.Lock()
+		f.thumbnail = thumb
+		f.mu.Unlock()
+	case !errors.Is(err, exec.ErrNotFound):
+		log.Printf("video thumbnail for %s: %v", f.name, err)
+	}
+	body := &headPipeReader{Reader: io.MultiReader(bytes.NewReader(head), pr), pipe: pr}
+	return f.tg.UploadVideo(f.ctx, f.storageChatID, filename, body, meta)
+}
+
+// headPipeReader replays a buffered head before the rest of a pipe and
+// still lets a failed upload close the pipe, so the writer is not left
+// blocked.
+type headPipeReader struct {
+	io.Reader
+	pipe *io.PipeReader
+}
+
+func (r *headPipeReader) CloseWithError(err error) error {
+	return r.pipe.CloseWithError(err)
+}
@@ -2,10 +2,18 @@ package webdav
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding"
+	"encoding/hex"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"log"
+	"mime"
+	"net"
 	"net/http"
 	"os"
 	"path"
@@ -16,47 +24,131 @@ import (
 
 	"golang.org/x/net/webdav"
 
+	"pigpak/internal/apierror"
 	"pigpak/internal/config"
 	"pigpak/internal/db"
+	"pigpak/internal/media"
+	"pigpak/internal/partcache"
+	"pigpak/internal/rules"
 	"pigpak/internal/telegram"
 )
 
 // Server hosts the WebDAV endpoint.
 type Server struct {
-	cfg   config.Config
-	store *db.Store
-	tg    *telegram.Client
+	cfg     config.Config
+	store   *db.Store
+	tg      telegram.BotAPI
+	cache   *partcache.Cache
+	uploads *uploadLimiter
+	routes  []route
+
+	// mu guards server and cancel, the running listener and what cancels
+	// the requests it serves.
+	mu     sync.Mutex
+	server *http.Server
+	cancel context.CancelFunc
 }
 
-// NewServer creates a WebDAV server.
-func NewServer(cfg config.Config, store *db.Store, tg *telegram.Client) (*Server, error) {
-	return &Server{cfg: cfg, store: store, tg: tg}, nil
+// route is an extra HTTP handler served next to WebDAV on the same listener.
+type route struct {
+	pattern string
+	handler http.Handler
 }
 
-// Handler builds the WebDAV handler.
-func (s *Server) Handler() http.Handler {
-	fs := &davFS{
+// NewServer creates a WebDAV server, opening the part cache when
+// cfg.PartCacheSizeBytes enables it.
+func NewServer(cfg config.Config, store *db.Store, tg telegram.BotAPI) (*Server, error) {
+	s := &Server{cfg: cfg, store: store, tg: tg, uploads: newUploadLimiter(cfg.WebDAVMaxUploads, cfg.WebDAVMaxUserUploads)}
+	if cfg.PartCacheSizeBytes > 0 {
+		cache, err := partcache.Open(cfg.PartCacheDir, cfg.PartCacheSizeBytes)
+		if err != nil {
+			return nil, fmt.Errorf("part cache: %w", err)
+		}
+		s.cache = cache
+	}
+	return s, nil
+}
+
+// PartCache returns the cache WebDAV reads go through, for the routes served
+// next to WebDAV to share; nil when caching is off.
+func (s *Server) PartCache() *partcache.Cache {
+	return s.cache
+}
+
+// UploadsRunning returns how many uploads are streaming parts to Telegram.
+func (s *Server) UploadsRunning() int {
+	return s.uploads.running()
+}
+
+// Handle registers an extra handler for pattern. Routes take precedence over
+// WebDAV paths and are not wrapped in Basic Auth; handlers authenticate themselves.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.routes = append(s.routes, route{pattern: pattern, handler: handler})
+}
+
+// fileSystem returns the drive WebDAV requests and chunked uploads work on.
+func (s *Server) fileSystem() *davFS {
+	return &davFS{
 		store:         s.store,
 		tg:            s.tg,
+		cache:         s.cache,
 		storageChatID: s.cfg.StorageChatID,
 		maxPartSize:   s.cfg.MaxPartSizeBytes,
+		videoUploads:  s.cfg.VideoUploads,
+		ffmpegPath:    s.cfg.FFmpegPath,
+		publicURL:     s.cfg.WebDAVPublicURL,
+		shareBaseURL:  s.cfg.ShareBaseURL,
+		junkPatterns:  s.cfg.WebDAVIgnore,
+		junkMode:      s.cfg.WebDAVIgnoreMode,
+		held:          webdav.NewMemLS(),
+		// Caps the part size users choose with /partsize.
+		maxUserPartSize:  s.cfg.MaxUserPartSizeBytes,
+		propfindMaxItems: s.cfg.WebDAVPropfindMaxItems,
+		propfindMaxDepth: s.cfg.WebDAVPropfindMaxDepth,
 	}
-	h := &webdav.Handler{
+}
+
+// Handler builds the WebDAV handler.
+func (s *Server) Handler() http.Handler {
+	fs := s.fileSystem()
+	// withLocks gives each request its own LockSystem.
+	h := fs.withContentType(fs.deepPropfind(fs.withLocks(&webdav.Handler{
 		Prefix:     "/",
 		FileSystem: fs,
-		LockSystem: webdav.NewMemLS(),
+	})))
+	if len(s.routes) == 0 {
+		return apierror.WithRequestID(s.wrapAuth(fs.rejectJunk(fs.guardIfMatch(fs.guardQuota(fs.guardMove(h))))))
 	}
-	return s.wrapAuth(h)
+	mux := http.NewServeMux()
+	for _, r := range s.routes {
+		mux.Handle(r.pattern, r.handler)
+	}
+	mux.Handle("/", s.wrapAuth(fs.rejectJunk(fs.guardIfMatch(fs.guardQuota(fs.guardMove(h))))))
+	return apierror.WithRequestID(mux)
 }
 
-// ListenAndServe starts the WebDAV server.
+// idleTimeout keeps a keep-alive connection open between requests. Media
+// players streaming from /stream/ pause between range requests while their
+// buffer drains and reuse the connection when they resume; abandoned
+// connections are closed after it.
+const idleTimeout = 2 * time.Minute
+
+// ListenAndServe starts the WebDAV server. After Shutdown it returns
+// http.ErrServerClosed.
 func (s *Server) ListenAndServe() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	server := &http.Server{
 		Addr:              s.cfg.WebDAVAddr,
 		Handler:           s.Handler(),
 		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       idleTimeout,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
 	}
-	return server.ListenAndServe()
+	s.mu.Lock()
+	s.server, s.cancel = server, cancel
+	s.mu.Unlock()
+	return s.serve(server)
 }
 
 func (s *Server) wrapAuth(next http.Handler) http.Handler {
@@ -67,6 +159,10 @@ func (s *Server) wrapAuth(next http.Handler) http.Handler {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		if strings.HasPrefix(username, db.WebDAVTempUsernamePrefix) {
+			s.serveTemp(w, r, next, username, password)
+			return
+		}
 		userID, err := s.store.GetUserIDByUsername(r.Context(), username)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
@@ -77,42 +173,96 @@ func (s *Server) wrapAuth(next http.Handler) http.Handler {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		if strings.HasPrefix(password, db.APITokenPrefix) {
+			s.serveToken(w, r, next, userID, password)
+			return
+		}
 		ok, err = s.store.VerifyWebDAVPassword(r.Context(), userID, password)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		if !ok {
+			s.recordFailedLogin(r, userID)
 			w.Header().Set("WWW-Authenticate", `Basic realm="webdav"`)
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-		ctx := context.WithValue(r.Context(), webdavUserKey{}, userID)
+		s.serveUser(r.Context(), w, r, next, userID)
+	})
+}
+
+// recordFailedLogin notes a wrong password for a known user in the audit log.
+func (s *Server) recordFailedLogin(r *http.Request, userID int64) {
+	err := s.store.RecordAudit(r.Context(), db.AuditEvent{UserID: userID, Source: db.AuditSourceWebDAV, Action: db.AuditAuthFailed, Detail: "from " + r.RemoteAddr})
+	if err != nil {
+		log.Printf("webdav audit: %v", err)
+	}
+}
+
+// serveUser runs next as the authenticated userID.
+func (s *Server) serveUser(ctx context.Context, w http.ResponseWriter, r *http.Request, next http.Handler, userID int64) {
+	ctx = context.WithValue(ctx, webdavUserKey{}, userID)
+	ctx = db.WithActor(ctx, db.AuditSourceWebDAV, userID)
+	// Only a PUT's body is the file; a LOCK on a new path creates it
+	// empty whatever its body.
+	if r.Method == http.MethodPut {
+		if !s.uploads.acquire(userID) {
+			refuseUpload(w)
+			return
+		}
+		defer s.uploads.release(userID)
 		ctx = context.WithValue(ctx, webdavContentLengthKey{}, r.ContentLength)
-		if value := r.Header.Get("Content-Range"); value != "" {
-			cr, err := parseContentRange(value)
-			if err != nil {
-				http.Error(w, "invalid Content-Range", http.StatusBadRequest)
-				return
-			}
-			if cr.ok {
-				ctx = context.WithValue(ctx, webdavContentRangeKey{}, cr)
-			}
+		// ownCloud and Nextcloud clients send the file's own modification
+		// time along and expect to hear that it was kept.
+		if mtime, ok := parseMTime(r.Header.Get("X-OC-MTime")); ok {
+			ctx = context.WithValue(ctx, webdavMTimeKey{}, mtime)
+			w.Header().Set("X-OC-MTime", "accepted")
 		}
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+	}
+	if value := r.Header.Get("Content-Range"); value != "" {
+		cr, err := parseContentRange(value)
+		if err != nil {
+			http.Error(w, "invalid Content-Range", http.StatusBadRequest)
+			return
+		}
+		if cr.ok {
+			ctx = context.WithValue(ctx, webdavContentRangeKey{}, cr)
+		}
+	}
+	next.ServeHTTP(w, r.WithContext(ctx))
 }
 
 type davFS struct {
 	store         *db.Store
-	tg            *telegram.Client
+	tg            telegram.BotAPI
+	cache         *partcache.Cache
 	storageChatID int64
 	maxPartSize   int64
+	videoUploads  bool
+	ffmpegPath    string
+	// publicURL and shareBaseURL are where shortcuts link to.
+	publicURL    string
+	shareBaseURL string
+	// junkPatterns and junkMode are WEB_DAV_IGNORE and its mode; see
+	// isJunk.
+	junkPatterns []string
+	junkMode     string
+	// maxUserPartSize caps the part sizes users choose; see partSizeFor.
+	maxUserPartSize int64
+	// propfindMaxItems and propfindMaxDepth bound Depth: infinity
+	// listings; see deepPropfind.
+	propfindMaxItems int
+	propfindMaxDepth int
+	// held keeps the locks the handler takes for the length of a request;
+	// see lockSystem.
+	held webdav.LockSystem
 }
 
 type webdavUserKey struct{}
 type webdavContentLengthKey struct{}
 type webdavContentRangeKey struct{}
+type webdavMTimeKey struct{}
 
 type contentRange struct {
 	start int64
@@ -138,11 +288,17 @@ func (fs *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error
 	if err != nil {
 		return err
 	}
+	if err := fs.checkWritable(ctx); err != nil {
+		return err
+	}
+	if fs.inTrash(ctx, name) {
+		return os.ErrPermission
+	}
 	parentParts, base := splitPath(name)
 	if base == "" {
 		return nil
 	}
-	parentDir, err := fs.store.FindDirByPath(ctx, userID, parentParts)
+	parentDir, err := fs.findDir(ctx, userID, parentParts)
 	if err != nil {
 		return err
 	}
@@ -158,31 +314,65 @@ func (fs *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.Fi
 	if name == "." {
 		name = "/"
 	}
+	if flag&(os.O_CREATE|os.O_WRONLY|os.O_RDWR|os.O_TRUNC) != 0 {
+		if err := fs.checkWritable(ctx); err != nil {
+			return nil, err
+		}
+		if fs.inTrash(ctx, name) {
+			return nil, os.ErrPermission
+		}
+	}
 	entry, err := fs.resolve(ctx, userID, name)
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			return nil, err
 		}
 		if flag&(os.O_CREATE|os.O_WRONLY|os.O_RDWR) != 0 {
+			switch {
+			case fs.hidesJunk(name):
+				return fs.createJunkFile(ctx, userID, name)
+			case fs.junkMode == config.IgnoreReject && fs.isJunk(name):
+				return nil, os.ErrPermission
+			}
 			return fs.createUploadFile(ctx, userID, name, flag)
 		}
 		return nil, err
 	}
-	if entry.isDir {
+	// PROPPATCH opens its target read-write without creating or
+	// truncating it, to patch its properties; every other write truncates.
+	propPatch := flag&(os.O_CREATE|os.O_TRUNC) == 0 && flag&os.O_RDWR != 0
+	if entry.trash != nil {
+		return fs.openTrash(ctx, userID, *entry.trash)
+	}
+	if entry.junk != nil {
+		if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 && !propPatch {
+			return fs.createJunkFile(ctx, userID, name)
+		}
+		return newJunkFile(ctx, fs.store, *entry.junk), nil
+	}
+	if entry.shortcut != nil {
 		if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+			return nil, os.ErrPermission
+		}
+		return newShortcutFile(*entry.shortcut), nil
+	}
+	if entry.isDir {
+		if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 && !propPatch {
 			return nil, errors.New("cannot write to directory")
 		}
-		return newDirFile(ctx, fs.store, userID, entry.dir.ID), nil
+		d := newDirFile(ctx, fs, userID, entry.dir.ID)
+		d.trash = path.Clean("/"+name) == "/" && fs.showsTrash(ctx)
+		return d, nil
 	}
 
-	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 && !propPatch {
 		return fs.createUploadFile(ctx, userID, name, flag)
 	}
 	parts, err := fs.store.ListFileParts(ctx, entry.file.ID)
 	if err != nil {
 		return nil, err
 	}
-	return newReadFile(ctx, fs.tg, entry.file, parts), nil
+	return newReadFile(ctx, fs.tg, fs.store, fs.cache, entry.file, parts), nil
 }
 
 func (fs *davFS) RemoveAll(ctx context.Context, name string) error {
@@ -190,14 +380,30 @@ func (fs *davFS) RemoveAll(ctx context.Context, name string) error {
 	if err != nil {
 		return err
 	}
+	if err := fs.checkWritable(ctx); err != nil {
+		return err
+	}
 	entry, err := fs.resolve(ctx, userID, name)
 	if err != nil {
 		return err
 	}
+	if entry.shortcut != nil {
+		return fs.store.DeleteShortcut(ctx, userID, entry.shortcut.shortcut.ID)
+	}
+	if entry.junk != nil {
+		return fs.store.DeleteWebDAVJunk(ctx, entry.junk.ID)
+	}
+	if entry.trash != nil {
+		return os.ErrPermission
+	}
+	// Deletes go to the trash, as they do in the bot; see trashFolder.
+	ctx = conditional(ctx, name)
 	if entry.isDir {
-		return fs.store.DeleteDirRecursive(ctx, userID, entry.dir.ID)
+		_, err = fs.store.TrashDir(ctx, userID, entry.dir.ID)
+		return err
 	}
-	return fs.store.DeleteFile(ctx, userID, entry.file.ID)
+	_, err = fs.store.TrashFile(ctx, userID, entry.file.ID)
+	return err
 }
 
 func (fs *davFS) Rename(ctx context.Context, oldName, newName string) error {
@@ -205,28 +411,40 @@ func (fs *davFS) Rename(ctx context.Context, oldName, newName string) error {
 	if err != nil {
 		return err
 	}
+	if err := fs.checkWritable(ctx); err != nil {
+		return err
+	}
 	entry, err := fs.resolve(ctx, userID, oldName)
 	if err != nil {
 		return err
 	}
+	if entry.shortcut != nil || fs.inTrash(ctx, newName) {
+		return os.ErrPermission
+	}
+	if entry.trash != nil {
+		return fs.restoreTrash(ctx, userID, *entry.trash, newName)
+	}
 	parentParts, base := splitPath(newName)
 	if base == "" {
 		return errors.New("invalid target name")
 	}
-	parentDir, err := fs.store.FindDirByPath(ctx, userID, parentParts)
+	// Junk files stay junk: they are kept apart from the files in the
+	// storage chat, so they can only be renamed to other junk names.
+	if entry.junk != nil && !fs.hidesJunk(newName) || !entry.isDir && entry.junk == nil && fs.hidesJunk(newName) {
+		return os.ErrPermission
+	}
+	parentDir, err := fs.findDir(ctx, userID, parentParts)
 	if err != nil {
 		return err
 	}
-	if entry.isDir {
-		if err := fs.store.MoveDir(ctx, userID, entry.dir.ID, parentDir.ID); err != nil {
-			return err
-		}
-		return fs.store.RenameDir(ctx, userID, entry.dir.ID, base)
+	if entry.junk != nil {
+		return fs.store.MoveWebDAVJunk(ctx, entry.junk.ID, parentDir.ID, base)
 	}
-	if err := fs.store.MoveFile(ctx, userID, entry.file.ID, parentDir.ID); err != nil {
-		return err
+	ctx = conditional(ctx, oldName)
+	if entry.isDir {
+		return fs.store.MoveAndRename(ctx, userID, entry.dir.ID, 0, parentDir.ID, base)
 	}
-	return fs.store.RenameFile(ctx, userID, entry.file.ID, base)
+	return fs.store.MoveAndRename(ctx, userID, 0, entry.file.ID, parentDir.ID, base)
 }
 
 func (fs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
@@ -238,47 +456,119 @@ func (fs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	if entry.shortcut != nil {
+		return entry.shortcut.info(), nil
+	}
+	if entry.junk != nil {
+		return junkInfo(*entry.junk), nil
+	}
+	if entry.trash != nil {
+		return entry.trash.info(), nil
+	}
 	if entry.isDir {
 		return dirInfo(entry.dir), nil
 	}
 	return fileInfo(entry.file), nil
 }
 
-func (fs *davFS) createUploadFile(ctx context.Context, userID int64, name string, flag int) (webdav.File, error) {
-	if fs.storageChatID == 0 {
-		return nil, errors.New("STORAGE_CHAT_ID is required for WebDAV uploads")
+// storageChatFor picks the chat uploads into dirID are stored in: the
+// drive's own storage chat, then the user's, then the server's
+// STORAGE_CHAT_ID. Zero means there is none.
+func (fs *davFS) storageChatFor(ctx context.Context, userID, dirID int64) (int64, error) {
+	drive, err := fs.store.DriveForDir(ctx, userID, dirID)
+	if err != nil {
+		return 0, err
+	}
+	if drive.StorageChatID != 0 {
+		return drive.StorageChatID, nil
+	}
+	us, err := fs.store.GetUserStorage(ctx, userID)
+	if err == nil {
+		return us.ChatID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	return fs.storageChatID, nil
+}
+
+// partSizeFor returns the part size of userID's uploads: their own choice,
+// capped by MAX_USER_PART_SIZE_BYTES, or the server's MAX_PART_SIZE_BYTES.
+func (fs *davFS) partSizeFor(ctx context.Context, userID int64) (int64, error) {
+	size, err := fs.store.GetUserPartSize(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if size <= 0 {
+		return fs.maxPartSize, nil
+	}
+	if fs.maxUserPartSize > 0 {
+		size = min(size, fs.maxUserPartSize)
 	}
+	return size, nil
+}
+
+func (fs *davFS) createUploadFile(ctx context.Context, userID int64, name string, flag int) (webdav.File, error) {
 	parentParts, base := splitPath(name)
 	if base == "" {
 		return nil, errors.New("invalid file name")
 	}
-	parentDir, err := fs.store.FindDirByPath(ctx, userID, parentParts)
+	parentDir, err := fs.findDir(ctx, userID, parentParts)
 	if err != nil {
 		return nil, err
 	}
+	storageChatID, err := fs.storageChatFor(ctx, userID, parentDir.ID)
+	if err != nil {
+		return nil, err
+	}
+	if storageChatID == 0 {
+		return nil, errors.New("WebDAV uploads need a storage chat: set one with /storage in the bot, or STORAGE_CHAT_ID on the server")
+	}
 	var existing *db.File
-	if entry, err := fs.resolve(ctx, userID, name); err == nil && !entry.isDir {
+	if entry, err := fs.resolve(ctx, userID, name); err == nil && entry.isStoredFile() {
 		existing = &entry.file
+		ctx = conditional(ctx, name)
 	}
 	contentLength, _ := ctx.Value(webdavContentLengthKey{}).(int64)
 	rangeInfo, _ := ctx.Value(webdavContentRangeKey{}).(contentRange)
-	file, err := newUploadFile(ctx, fs.tg, fs.store, userID, fs.storageChatID, parentDir.ID, base, existing, fs.maxPartSize, contentLength, rangeInfo)
+	maxPartSize, err := fs.partSizeFor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	file, err := newUploadFile(ctx, fs.tg, fs.store, userID, storageChatID, parentDir.ID, base, existing, maxPartSize, contentLength, rangeInfo)
 	if err != nil {
 		return nil, err
 	}
+	if fs.videoUploads && file.singlePartUpload(contentLength) && media.IsStreamableVideo(base, "") {
+		file.ffmpegPath = fs.ffmpegPath
+		file.asVideo = true
+	}
 	return file, nil
 }
 
 type davEntry struct {
-	isDir bool
-	dir   db.Directory
-	file  db.File
+	isDir    bool
+	dir      db.Directory
+	file     db.File
+	shortcut *shortcutEntry
+	junk     *db.WebDAVJunkFile
+	trash    *trashEntry
+}
+
+// isStoredFile reports whether the entry is a file with a row of its own.
+func (e davEntry) isStoredFile() bool {
+	return !e.isDir && e.shortcut == nil && e.junk == nil && e.trash == nil
 }
 
 func (fs *davFS) resolve(ctx context.Context, userID int64, name string) (davEntry, error) {
 	clean := path.Clean("/" + name)
+	if tree := treeFrom(ctx); tree != nil {
+		if entry, ok := tree.entries[clean]; ok {
+			return entry, nil
+		}
+	}
 	if clean == "/" {
-		rootID, err := fs.store.GetRootDirID(ctx, userID)
+		rootID, err := fs.rootID(ctx, userID)
 		if err != nil {
 			return davEntry{}, err
 		}
@@ -289,18 +579,36 @@ func (fs *davFS) resolve(ctx context.Context, userID int64, name string) (davEnt
 		return davEntry{isDir: true, dir: dir}, nil
 	}
 	parts := strings.Split(strings.TrimPrefix(clean, "/"), "/")
-	parentParts := parts[:len(parts)-1]
-	base := parts[len(parts)-1]
-	parentDir, err := fs.store.FindDirByPath(ctx, userID, parentParts)
+	if parts[0] == trashFolder && fs.showsTrash(ctx) {
+		return fs.resolveTrash(ctx, userID, parts)
+	}
+	rootID, err := fs.rootID(ctx, userID)
 	if err != nil {
 		return davEntry{}, err
 	}
-	if dir, err := fs.store.GetDirByName(ctx, userID, parentDir.ID, base); err == nil {
+	dir, err := fs.store.FindDirByPathFrom(ctx, userID, rootID, parts)
+	if err == nil {
 		return davEntry{isDir: true, dir: dir}, nil
 	}
-	file, err := fs.store.GetFileByName(ctx, userID, parentDir.ID, base)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return davEntry{}, err
+	}
+	// Files with junk names live only in the database; files stored under
+	// such names before are not served.
+	if fs.hidesJunk(clean) {
+		junk, err := fs.findJunk(ctx, userID, rootID, parts)
+		if err != nil {
+			return davEntry{}, err
+		}
+		return davEntry{junk: junk}, nil
+	}
+	file, err := fs.store.FindFileByPathFrom(ctx, userID, rootID, parts)
 	if err != nil {
-		return davEntry{}, os.ErrNotExist
+		shortcut, err := fs.findShortcut(ctx, userID, rootID, parts)
+		if err != nil {
+			return davEntry{}, os.ErrNotExist
+		}
+		return davEntry{shortcut: &shortcut}, nil
 	}
 	return davEntry{isDir: false, file: file}, nil
 }
@@ -377,6 +685,9 @@ type davFileInfo struct {
 	mode    os.FileMode
 	modTime time.Time
 	isDir   bool
+	etag    string
+	// mimeType is the stored type of a file.
+	mimeType string
 }
 
 func (fi davFileInfo) Name() string       { return fi.name }
@@ -386,30 +697,89 @@ func (fi davFileInfo) ModTime() time.Time { return fi.modTime }
 func (fi davFileInfo) IsDir() bool        { return fi.isDir }
 func (fi davFileInfo) Sys() any           { return nil }
 
+// ETag implements webdav.ETager with the entity's revision tag, the one
+// If-Match is checked against. An upload still in progress has none yet.
+func (fi davFileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.etag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.etag, nil
+}
+
+// ContentType implements webdav.ContentTyper so PROPFIND reports a file's
+// stored type instead of downloading its first bytes to sniff one.
+func (fi davFileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.isDir {
+		return "", webdav.ErrNotImplemented
+	}
+	return contentType(fi.name, fi.mimeType), nil
+}
+
+// contentType returns the type to serve a file called name as: its stored
+// type unless that is missing or generic, then the type its extension
+// suggests.
+func contentType(name, mimeType string) string {
+	if mimeType != "" && mimeType != "application/octet-stream" {
+		return mimeType
+	}
+	if byExt := mime.TypeByExtension(path.Ext(name)); byExt != "" {
+		return byExt
+	}
+	return "application/octet-stream"
+}
+
+// withContentType sets the Content-Type of a file GET or HEAD returns from
+// the stored type; the handler otherwise guesses from the name or content.
+func (fs *davFS) withContentType(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if userID, err := fs.userID(r.Context()); err == nil {
+				entry, err := fs.resolve(r.Context(), userID, r.URL.Path)
+				if err == nil && entry.isStoredFile() {
+					w.Header().Set("Content-Type", contentType(entry.file.Name, entry.file.MimeType))
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func dirInfo(dir db.Directory) os.FileInfo {
 	name := dir.Name
 	if !dir.ParentID.Valid {
 		name = ""
 	}
-	return davFileInfo{name: name, size: 0, mode: os.ModeDir | 0o755, modTime: dir.UpdatedAt, isDir: true}
+	return davFileInfo{name: name, size: 0, mode: os.ModeDir | 0o755, modTime: dir.UpdatedAt, isDir: true, etag: dir.ETag()}
 }
 
 func fileInfo(file db.File) os.FileInfo {
-	return davFileInfo{name: file.Name, size: file.Size, mode: 0o644, modTime: file.CreatedAt, isDir: false}
+	return davFileInfo{name: file.Name, size: file.Size, mode: 0o644, modTime: file.ModifiedAt, isDir: false, etag: file.ETag(), mimeType: file.MimeType}
 }
 
-// dirFile implements webdav.File for directory listing.
+// readdirBatch bounds how many entries a directory listing loads per query.
+const readdirBatch = 500
+
+// dirFile implements webdav.File for directory listing. Entries are read
+// in name order, folders first and shortcuts last, a batch at a time.
 type dirFile struct {
 	ctx    context.Context
+	fs     *davFS
 	store  *db.Store
 	userID int64
 	dirID  int64
-	infos  []os.FileInfo
-	pos    int
+	// after is the last name returned in the current phase; files is set
+	// once the folders are exhausted, shortcuts once the files are, and
+	// done once the shortcuts are.
+	after     string
+	files     bool
+	shortcuts bool
+	done      bool
+	// trash lists .trash after the shortcuts, in the served root.
+	trash bool
 }
 
-func newDirFile(ctx context.Context, store *db.Store, userID, dirID int64) *dirFile {
-	return &dirFile{ctx: ctx, store: store, userID: userID, dirID: dirID}
+func newDirFile(ctx context.Context, fs *davFS, userID, dirID int64) *dirFile {
+	return &dirFile{ctx: ctx, fs: fs, store: fs.store, userID: userID, dirID: dirID}
 }
 
 func (d *dirFile) Stat() (os.FileInfo, error) {
@@ -420,6 +790,16 @@ func (d *dirFile) Stat() (os.FileInfo, error) {
 	return dirInfo(dir), nil
 }
 
+func (d *dirFile) props() davProps {
+	return davProps{ctx: d.ctx, store: d.store, entity: db.DAVPropertyDir, id: d.dirID}
+}
+
+func (d *dirFile) DeadProps() (map[xml.Name]webdav.Property, error) { return d.props().DeadProps() }
+
+func (d *dirFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return d.props().Patch(patches)
+}
+
 func (d *dirFile) Read(p []byte) (int, error) {
 	return 0, io.EOF
 }
@@ -435,56 +815,113 @@ func (d *dirFile) Write(p []byte) (int, error) {
 func (d *dirFile) Close() error { return nil }
 
 func (d *dirFile) Readdir(count int) ([]os.FileInfo, error) {
-	if d.infos == nil {
-		dirs, err := d.store.ListDirs(d.ctx, d.userID, d.dirID)
+	if count <= 0 {
+		var out []os.FileInfo
+		if listing, ok := treeFrom(d.ctx).listing(d.dirID); ok && !d.files {
+			// Only the shortcuts are left to load.
+			out = append(out, listing...)
+			d.files, d.shortcuts, d.after = true, true, ""
+		}
+		for !d.done {
+			batch, err := d.next(readdirBatch)
+			if err != nil {
+				return out, err
+			}
+			out = append(out, batch...)
+		}
+		return out, nil
+	}
+	var out []os.FileInfo
+	for len(out) < count && !d.done {
+		batch, err := d.next(count - len(out))
 		if err != nil {
-			return nil, err
+			return out, err
 		}
-		files, err := d.store.ListFiles(d.ctx, d.userID, d.dirID)
+		out = append(out, batch...)
+	}
+	if len(out) == 0 {
+		return nil, io.EOF
+	}
+	return out, nil
+}
+
+// next loads up to limit entries following the ones already returned.
+func (d *dirFile) next(limit int) ([]os.FileInfo, error) {
+	page := db.ListPage{After: d.after, Limit: limit}
+	var out []os.FileInfo
+	if !d.files {
+		dirs, err := d.store.ListDirsPage(d.ctx, d.userID, d.dirID, page)
 		if err != nil {
 			return nil, err
 		}
 		for _, dir := range dirs {
-			d.infos = append(d.infos, dirInfo(dir))
+			out = append(out, dirInfo(dir))
+		}
+		if len(dirs) < limit {
+			d.files, d.after = true, ""
+		} else {
+			d.after = dirs[len(dirs)-1].Name
+		}
+		return out, nil
+	}
+	if !d.shortcuts {
+		files, err := d.store.ListFilesPage(d.ctx, d.userID, d.dirID, page)
+		if err != nil {
+			return nil, err
 		}
 		for _, file := range files {
-			d.infos = append(d.infos, fileInfo(file))
+			if !d.fs.hidesJunk(file.Name) {
+				out = append(out, fileInfo(file))
+			}
+		}
+		if len(files) < limit {
+			d.shortcuts, d.after = true, ""
+		} else {
+			d.after = files[len(files)-1].Name
 		}
+		return out, nil
 	}
-	if count <= 0 {
-		if d.pos >= len(d.infos) {
-			return nil, nil
+	shortcuts, err := d.store.ListShortcutsPage(d.ctx, d.userID, d.dirID, page)
+	if err != nil {
+		return nil, err
+	}
+	for _, sc := range shortcuts {
+		if entry, ok := d.fs.shortcutEntry(d.ctx, d.userID, sc); ok {
+			out = append(out, entry.info())
 		}
-		count = len(d.infos) - d.pos
-	} else if d.pos >= len(d.infos) {
-		return nil, io.EOF
 	}
-	end := d.pos + count
-	if end > len(d.infos) {
-		end = len(d.infos)
+	if len(shortcuts) < limit {
+		d.done = true
+		if d.trash {
+			out = append(out, trashEntry{name: trashFolder}.info())
+		}
+	} else {
+		d.after = shortcuts[len(shortcuts)-1].Name
 	}
-	chunk := d.infos[d.pos:end]
-	d.pos = end
-	return chunk, nil
+	return out, nil
 }
 
 // readFile streams from Telegram.
 type readFile struct {
 	ctx        context.Context
-	tg         *telegram.Client
+	tg         telegram.BotAPI
+	store      *db.Store
+	cache      *partcache.Cache
 	file       db.File
-	filePath   string
 	parts      []db.FilePart
 	partIndex  int
 	partOffset int64
-	partPaths  map[int]string
 	offset     int64
 	totalSize  int64
 	reader     io.ReadCloser
-	mu         sync.Mutex
+	// streamed counts the bytes read since the last seek, and next is the
+	// following part being opened ahead of the read.
+	streamed int64
+	next     *prefetch
+	mu       sync.Mutex
 }
 
-func newReadFile(ctx context.Context, tg *telegram.Client, file db.File, parts []db.FilePart) *readFile {
+func newReadFile(ctx context.Context, tg telegram.BotAPI, store *db.Store, cache *partcache.Cache, file db.File, parts []db.FilePart) *readFile {
 	total := file.Size
 	if total == 0 && len(parts) > 0 {
 		for _, part := range parts {
@@ -494,52 +931,103 @@ func newReadFile(ctx context.Context, tg *telegram.Client, file db.File, parts [
 	return &readFile{
 		ctx:       ctx,
 		tg:        tg,
+		store:     store,
+		cache:     cache,
 		file:      file,
 		parts:     parts,
 		totalSize: total,
-		partPaths: make(map[int]string),
 	}
 }
 
+// OpenFileReader streams a stored file, including multi-part files, from
+// Telegram with seek support, reading through cache when it is not nil. It
+// backs HTTP download routes served next to WebDAV.
+func OpenFileReader(ctx context.Context, tg telegram.BotAPI, store *db.Store, cache *partcache.Cache, file db.File) (io.ReadSeekCloser, error) {
+	parts, err := store.ListFileParts(ctx, file.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newReadFile(ctx, tg, store, cache, file, parts), nil
+}
+
 func (f *readFile) Stat() (os.FileInfo, error) {
 	return fileInfo(f.file), nil
 }
 
-func (f *readFile) ensurePath() (string, error) {
-	if f.filePath != "" {
-		return f.filePath, nil
+func (f *readFile) props() davProps {
+	return davProps{ctx: f.ctx, store: f.store, entity: db.DAVPropertyFile, id: f.file.ID, ownerID: f.file.UserID}
+}
+
+func (f *readFile) DeadProps() (map[xml.Name]webdav.Property, error) { return f.props().DeadProps() }
+
+func (f *readFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return f.props().Patch(patches)
+}
+
+// download opens the file or part fileID, size bytes long, at offset. The
+// part cache serves it when it holds the content; otherwise only the part
+// being read is resolved, and its path comes from downloadPaths when another
+// request resolved it recently. A cached path that fails is resolved again
+// once. Downloads from the first byte fill the part cache as they are read.
+func (f *readFile) download(fileID, fileUniqueID string, size, offset int64) (io.ReadCloser, error) {
+	if reader, ok := f.cache.Get(fileUniqueID, offset); ok {
+		return reader, nil
 	}
-	info, err := f.tg.GetFile(f.ctx, f.file.FileID)
+	reader, err := f.fetch(fileID, fileUniqueID, offset)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	f.filePath = info.FilePath
-	return f.filePath, nil
+	if offset == 0 {
+		reader = f.cache.Fill(fileUniqueID, size, reader)
+	}
+	return reader, nil
 }
 
-func (f *readFile) ensurePartPath(index int) (string, error) {
-	if path, ok := f.partPaths[index]; ok {
-		return path, nil
+// fetch downloads fileID from Telegram at offset.
+func (f *readFile) fetch(fileID, fileUniqueID string, offset int64) (io.ReadCloser, error) {
+	if path, ok := downloadPaths.get(f.tg, fileID); ok {
+		reader, err := f.tg.DownloadFile(f.ctx, path, offset)
+		if err == nil {
+			return reader, nil
+		}
+		downloadPaths.forget(f.tg, fileID)
+		if f.ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	info, err := f.tg.GetFile(f.ctx, fileID)
+	if err != nil {
+		f.flagUnreachable(fileUniqueID, err)
+		return nil, err
 	}
-	part := f.parts[index]
-	info, err := f.tg.GetFile(f.ctx, part.TelegramFileID)
+	reader, err := f.tg.DownloadFile(f.ctx, info.FilePath, offset)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	downloadPaths.put(f.tg, fileID, info.FilePath)
+	return reader, nil
+}
+
+// flagUnreachable records a dead file reference so the bot can offer the
+// owner a recovery flow the next time the file is opened.
+func (f *readFile) flagUnreachable(fileUniqueID string, err error) {
+	if f.store == nil || !telegram.IsFileReferenceError(err) {
+		return
+	}
+	if markErr := f.store.MarkBlobUnreachable(f.ctx, fileUniqueID); markErr != nil {
+		log.Printf("mark blob %s unreachable: %v", fileUniqueID, markErr)
 	}
-	f.partPaths[index] = info.FilePath
-	return info.FilePath, nil
 }
 
 func (f *readFile) ensureReader() error {
 	if f.reader != nil {
 		return nil
 	}
+	if f.file.IsEmpty() {
+		return io.EOF
+	}
 	if len(f.parts) == 0 {
-		path, err := f.ensurePath()
-		if err != nil {
-			return err
-		}
-		reader, err := f.tg.DownloadFile(f.ctx, path, f.offset)
+		reader, err := f.download(f.file.FileID, f.file.FileUniqueID, f.totalSize, f.offset)
 		if err != nil {
 			return err
 		}
@@ -549,11 +1037,12 @@ func (f *readFile) ensureReader() error {
 	if f.partIndex >= len(f.parts) {
 		return io.EOF
 	}
-	path, err := f.ensurePartPath(f.partIndex)
-	if err != nil {
-		return err
+	if reader := f.takePrefetchLocked(); reader != nil {
+		f.reader = reader
+		return nil
 	}
-	reader, err := f.tg.DownloadFile(f.ctx, path, f.partOffset)
+	part := f.parts[f.partIndex]
+	reader, err := f.download(part.TelegramFileID, part.FileUniqueID, part.Size, f.partOffset)
 	if err != nil {
 		return err
 	}
@@ -589,6 +1078,8 @@ func (f *readFile) Read(p []byte) (int, error) {
 		n, err := f.reader.Read(p)
 		f.partOffset += int64(n)
 		f.offset += int64(n)
+		f.streamed += int64(n)
+		f.prefetchLocked()
 		if err == io.EOF {
 			_ = f.reader.Close()
 			f.reader = nil
@@ -628,9 +1119,17 @@ func (f *readFile) Seek(offset int64, whence int) (int64, error) {
 	if f.totalSize > 0 && newOffset > f.totalSize {
 		return f.offset, errors.New("seek beyond end")
 	}
+	if newOffset == f.offset {
+		// Keep the open stream; the handler seeks to where it already is.
+		return f.offset, nil
+	}
 	f.offset = newOffset
+	f.streamed = 0
 	if len(f.parts) > 0 {
 		f.partIndex, f.partOffset = locatePart(f.parts, newOffset)
+		if f.next != nil && (f.next.index != f.partIndex || f.partOffset != 0) {
+			f.discardPrefetchLocked()
+		}
 	}
 	if f.reader != nil {
 		_ = f.reader.Close()
@@ -646,6 +1145,7 @@ func (f *readFile) Write(p []byte) (int, error) {
 func (f *readFile) Close() error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	f.discardPrefetchLocked()
 	if f.reader != nil {
 		return f.reader.Close()
 	}
@@ -669,14 +1169,14 @@ func locatePart(parts []db.FilePart, offset int64) (int, int64) {
 
 // uploadFile streams uploads into Telegram, splitting into parts when needed.
 type uploadFile struct {
-	ctx           context.Context
-	tg            *telegram.Client
-	store         *db.Store
-	ownerID       int64
-	storageChatID int64
-	parentDirID   int64
-	name          string
-	existing      *db.File
+	ctx            context.Context
+	tg             telegram.BotAPI
+	store          *db.Store
+	ownerID        int64
+	storageChatID  int64
+	parentDirID    int64
+	name           string
+	existing       *db.File
 	maxPartSize    int64
 	splitFromStart bool
 	uploadID       int64
@@ -684,17 +1184,43 @@ type uploadFile struct {
 	totalSize      int64
 	parts          []db.FilePartInput
 	mimeType       string
-	current        *uploadPart
-	closed         bool
-	aborted        bool
-	abortErr       error
-	doneCh         chan struct{}
-	mu             sync.Mutex
+	asVideo        bool
+	ffmpegPath     string
+	// fileHash checksums the whole upload; nil when a resumed upload
+	// predates checksums and its earlier parts were never hashed.
+	fileHash hash.Hash
+	// head keeps the first bytes of a fresh upload for sniffing its type
+	// and metadata; nil when an upload is resumed from an offset.
+	head []byte
+	// prefix is the part of a retried PUT's body that an interrupted
+	// attempt already stored; nil once it has been read.
+	prefix *storedPrefix
+	// bodySize is the request's Content-Length and bodyStart the upload's
+	// size when the request began, so Close can tell a body that was cut
+	// short from a finished one.
+	bodySize  int64
+	bodyStart int64
+	// expectSize, when not 0, is the size of an upload sent in chunks;
+	// Close stores the file only once that much of it has arrived, and
+	// otherwise just the parts so far. See WriteUpload.
+	expectSize int64
+	// etag and fileID are the stored file's once Close has stored it.
+	etag   string
+	fileID int64
+	// thumbnail is the preview made while the upload was sent as a video.
+	thumbnail []byte
+	current   *uploadPart
+	closed    bool
+	aborted   bool
+	abortErr  error
+	doneCh    chan struct{}
+	mu        sync.Mutex
 }
 
 type uploadPart struct {
 	index int
 	size  int64
+	hash  hash.Hash
 	pipeW *io.PipeWriter
 	done  chan uploadResult
 }
@@ -710,6 +1236,7 @@ type uploadSession struct {
 	uploadedSize int64
 	totalSize    int64
 	mimeType     string
+	hashState    []byte
 }
 
 func loadUploadSession(ctx context.Context, store *db.Store, userID, dirID int64, name string) (*uploadSession, error) {
@@ -732,10 +1259,13 @@ func loadUploadSession(ctx context.Context, store *db.Store, userID, dirID int64
 			return nil, fmt.Errorf("upload parts missing index %d: %w", expectedIndex, os.ErrInvalid)
 		}
 		inputs = append(inputs, db.FilePartInput{
-			PartIndex:      part.PartIndex,
-			TelegramFileID: part.TelegramFileID,
-			FileUniqueID:   part.FileUniqueID,
-			Size:           part.Size,
+			PartIndex:        part.PartIndex,
+			TelegramFileID:   part.TelegramFileID,
+			FileUniqueID:     part.FileUniqueID,
+			Size:             part.Size,
+			StorageChatID:    part.StorageChatID,
+			StorageMessageID: part.StorageMessageID,
+			SHA256:           part.SHA256,
 		})
 		uploadedSize += part.Size
 		expectedIndex++
@@ -746,10 +1276,11 @@ func loadUploadSession(ctx context.Context, store *db.Store, userID, dirID int64
 		uploadedSize: uploadedSize,
 		totalSize:    upload.TotalSize,
 		mimeType:     upload.MimeType,
+		hashState:    upload.HashState,
 	}, nil
 }
 
-func newUploadFile(ctx context.Context, tg *telegram.Client, store *db.Store, ownerID, storageChatID, parentDirID int64, name string, existing *db.File, maxPartSize int64, contentLength int64, contentRange contentRange) (*uploadFile, error) {
+func newUploadFile(ctx context.Context, tg telegram.BotAPI, store *db.Store, ownerID, storageChatID, parentDirID int64, name string, existing *db.File, maxPartSize int64, contentLength int64, contentRange contentRange) (*uploadFile, error) {
 	if maxPartSize <= 0 {
 		maxPartSize = 1900 * 1024 * 1024
 	}
@@ -759,6 +1290,7 @@ func newUploadFile(ctx context.Context, tg *telegram.Client, store *db.Store, ow
 		return nil, err
 	}
 	resumeRequested := contentRange.ok && contentRange.start > 0
+	var prefix *storedPrefix
 	if resumeRequested {
 		if session == nil {
 			return nil, fmt.Errorf("resume upload not found: %w", os.ErrNotExist)
@@ -769,6 +1301,8 @@ func newUploadFile(ctx context.Context, tg *telegram.Client, store *db.Store, ow
 		if totalSize := inferTotalSize(contentRange, contentLength); totalSize > 0 && totalSize != session.totalSize {
 			_ = store.UpdateWebDAVUploadTotal(ctx, session.id, totalSize)
 		}
+	} else if session.retriedBy(contentRange, contentLength) {
+		prefix = newStoredPrefix(session.parts)
 	} else {
 		if session != nil {
 			if err := store.DeleteWebDAVUpload(ctx, session.id); err != nil {
@@ -783,15 +1317,21 @@ func newUploadFile(ctx context.Context, tg *telegram.Client, store *db.Store, ow
 		}
 		session = &uploadSession{id: created.ID, totalSize: created.TotalSize}
 	}
+	return openSessionFile(ctx, tg, store, ownerID, storageChatID, parentDirID, name, existing, maxPartSize, splitFromStart, contentLength, session, prefix), nil
+}
+
+// openSessionFile continues session with a request body of contentLength
+// bytes, of which prefix, when not nil, was stored already.
+func openSessionFile(ctx context.Context, tg telegram.BotAPI, store *db.Store, ownerID, storageChatID, parentDirID int64, name string, existing *db.File, maxPartSize int64, splitFromStart bool, contentLength int64, session *uploadSession, prefix *storedPrefix) *uploadFile {
 	f := &uploadFile{
-		ctx:           ctx,
-		tg:            tg,
-		store:         store,
-		ownerID:       ownerID,
-		storageChatID: storageChatID,
-		parentDirID:   parentDirID,
-		name:          name,
-		existing:      existing,
+		ctx:            ctx,
+		tg:             tg,
+		store:          store,
+		ownerID:        ownerID,
+		storageChatID:  storageChatID,
+		parentDirID:    parentDirID,
+		name:           name,
+		existing:       existing,
 		maxPartSize:    maxPartSize,
 		splitFromStart: splitFromStart,
 		uploadID:       session.id,
@@ -799,10 +1339,23 @@ func newUploadFile(ctx context.Context, tg *telegram.Client, store *db.Store, ow
 		totalSize:      session.uploadedSize,
 		parts:          append([]db.FilePartInput(nil), session.parts...),
 		mimeType:       session.mimeType,
+		fileHash:       resumeHash(session),
+		prefix:         prefix,
+		bodySize:       contentLength,
 		doneCh:         make(chan struct{}),
 	}
+	if prefix != nil {
+		// The whole body arrives again, so the upload is hashed and
+		// sniffed from its first byte as if it were fresh.
+		f.totalSize = 0
+		f.fileHash = sha256.New()
+	}
+	f.bodyStart = f.totalSize
+	if len(session.parts) == 0 || prefix != nil {
+		f.head = make([]byte, 0, 64*1024)
+	}
 	go f.watchContext()
-	return f, nil
+	return f
 }
 
 func (f *uploadFile) Write(p []byte) (int, error) {
@@ -824,6 +1377,17 @@ func (f *uploadFile) Write(p []byte) (int, error) {
 			}
 			return written, err
 		}
+		if f.prefix != nil {
+			n, err := f.skipPrefixLocked(p)
+			f.mu.Unlock()
+			written += n
+			p = p[n:]
+			if err != nil {
+				_ = f.store.DeleteWebDAVUpload(f.ctx, f.uploadID)
+				return written, err
+			}
+			continue
+		}
 		if f.current == nil {
 			if err := f.startPartLocked(); err != nil {
 				f.mu.Unlock()
@@ -857,8 +1421,9 @@ func (f *uploadFile) Write(p []byte) (int, error) {
 		}
 		if f.current != nil {
 			f.current.size += int64(n)
+			_, _ = f.current.hash.Write(p[:n])
 		}
-		f.totalSize += int64(n)
+		f.acceptLocked(p[:n])
 		f.mu.Unlock()
 		written += n
 		p = p[n:]
@@ -893,6 +1458,15 @@ func (f *uploadFile) Close() error {
 		}
 		return err
 	}
+	if got := f.totalSize - f.bodyStart; f.bodySize > 0 && got < f.bodySize {
+		// Keep the session: a retry of the same PUT picks up from the
+		// parts stored so far.
+		err := fmt.Errorf("upload ended after %d of %d bytes", got, f.bodySize)
+		f.abortLocked(err)
+		close(f.doneCh)
+		f.mu.Unlock()
+		return err
+	}
 	f.mu.Unlock()
 
 	if err := f.finishPart(); err != nil {
@@ -903,36 +1477,148 @@ func (f *uploadFile) Close() error {
 	}
 
 	f.mu.Lock()
+	if f.expectSize > 0 && f.totalSize < f.expectSize {
+		close(f.doneCh)
+		f.mu.Unlock()
+		return nil
+	}
 	parts := append([]db.FilePartInput(nil), f.parts...)
 	totalSize := f.totalSize
 	mimeType := f.mimeType
 	name := f.name
 	existing := f.existing
 	uploadID := f.uploadID
+	var checksum string
+	if f.fileHash != nil {
+		checksum = hex.EncodeToString(f.fileHash.Sum(nil))
+	}
+	var meta media.Metadata
+	thumb := f.thumbnail
+	if f.head != nil {
+		mimeType = media.SniffMIME(f.head, mimeType, name)
+		meta = media.Probe(f.head, totalSize)
+		if thumb == nil && int64(len(f.head)) == totalSize && strings.HasPrefix(mimeType, "image/") {
+			thumb, _ = media.ImageThumbnail(f.head)
+		}
+	}
 	close(f.doneCh)
 	f.mu.Unlock()
 
-	if len(parts) == 0 {
-		return errors.New("empty upload")
+	if len(parts) == 0 && totalSize != 0 {
+		return errors.New("upload stored no parts")
+	}
+	// A zero-byte upload has no parts and is stored as metadata only.
+	var first db.FilePartInput
+	if len(parts) > 0 {
+		first = parts[0]
 	}
-	first := parts[0]
+	var fileID int64
 	var err error
 	if existing != nil {
+		fileID = existing.ID
 		err = f.store.ReplaceFileWithParts(f.ctx, f.ownerID, existing.ID, name, first.TelegramFileID, first.FileUniqueID, totalSize, mimeType, parts)
-	} else if len(parts) > 1 {
-		_, err = f.store.CreateFileWithParts(f.ctx, f.ownerID, f.parentDirID, name, first.TelegramFileID, first.FileUniqueID, totalSize, mimeType, parts)
 	} else {
-		_, err = f.store.CreateFile(f.ctx, f.ownerID, f.parentDirID, name, first.TelegramFileID, first.FileUniqueID, totalSize, mimeType)
+		dirID := f.parentDirID
+		// Logins scoped to a folder must not file uploads outside of it.
+		if scope, _ := scopeFromContext(f.ctx); scope.rootID == 0 {
+			if rule, ok, ruleErr := f.store.MatchRule(f.ctx, f.ownerID, rules.Item{Name: name, Size: totalSize, MimeType: mimeType, Meta: meta}, true); ruleErr == nil && ok {
+				dirID = rule.TargetDirID
+			}
+		}
+		fileID, err = f.createFile(dirID, name, totalSize, mimeType, parts)
+		if err != nil && dirID != f.parentDirID {
+			fileID, err = f.createFile(f.parentDirID, name, totalSize, mimeType, parts)
+		}
 	}
 	if err != nil {
 		return err
 	}
+	if checksum != "" {
+		if err := f.store.SetFileChecksum(f.ctx, f.ownerID, fileID, checksum); err != nil {
+			log.Printf("webdav checksum for file %d: %v", fileID, err)
+		}
+	}
+	if !meta.IsZero() || existing != nil {
+		if err := f.store.SetFileMetadata(f.ctx, f.ownerID, fileID, meta); err != nil {
+			log.Printf("webdav metadata for file %d: %v", fileID, err)
+		}
+	}
+	if thumb != nil || existing != nil {
+		if err := f.store.SetThumbnail(f.ctx, f.ownerID, fileID, thumb); err != nil {
+			log.Printf("webdav thumbnail for file %d: %v", fileID, err)
+		}
+	}
+	if mtime, ok := f.ctx.Value(webdavMTimeKey{}).(time.Time); ok {
+		if err := f.store.SetFileModifiedAt(f.ctx, f.ownerID, fileID, mtime); err != nil {
+			log.Printf("webdav modification time for file %d: %v", fileID, err)
+		}
+	}
+	f.mu.Lock()
+	f.fileID = fileID
+	f.mu.Unlock()
+	if file, err := f.store.GetFileByID(f.ctx, f.ownerID, fileID); err == nil {
+		f.mu.Lock()
+		f.etag = file.ETag()
+		f.mu.Unlock()
+	}
 	if uploadID != 0 {
 		_ = f.store.DeleteWebDAVUpload(f.ctx, uploadID)
 	}
 	return nil
 }
 
+func (f *uploadFile) createFile(dirID int64, name string, totalSize int64, mimeType string, parts []db.FilePartInput) (int64, error) {
+	var first db.FilePartInput
+	if len(parts) > 0 {
+		first = parts[0]
+	}
+	// A single part is stored as a plain file; passing it along keeps its
+	// storage message and checksum on record.
+	file, err := f.store.CreateFileWithParts(f.ctx, f.ownerID, dirID, name, first.TelegramFileID, first.FileUniqueID, totalSize, mimeType, parts)
+	return file.ID, err
+}
+
+// acceptLocked counts b, the next bytes of the upload, into its size, hash
+// and head.
+func (f *uploadFile) acceptLocked(b []byte) {
+	if f.fileHash != nil {
+		_, _ = f.fileHash.Write(b)
+	}
+	if f.head != nil && len(f.head) < media.HeadSize {
+		f.head = append(f.head, b[:min(len(b), media.HeadSize-len(f.head))]...)
+	}
+	f.totalSize += int64(len(b))
+}
+
+// resumeHash returns the whole-file hash to continue an upload session with.
+func resumeHash(session *uploadSession) hash.Hash {
+	h := sha256.New()
+	if len(session.parts) == 0 {
+		return h
+	}
+	if len(session.hashState) == 0 {
+		return nil
+	}
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(session.hashState); err != nil {
+		return nil
+	}
+	return h
+}
+
+// hashState marshals the whole-file hash so a later request can resume it.
+func (f *uploadFile) hashState() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fileHash == nil {
+		return nil
+	}
+	state, err := f.fileHash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	return state
+}
+
 func (f *uploadFile) Read(p []byte) (int, error) {
 	return 0, io.EOF
 }
@@ -942,7 +1628,25 @@ func (f *uploadFile) Seek(offset int64, whence int) (int64, error) {
 }
 
 func (f *uploadFile) Stat() (os.FileInfo, error) {
-	return davFileInfo{name: f.name, size: f.totalSize, mode: 0o644, modTime: time.Now().UTC(), isDir: false}, nil
+	info := davFileInfo{name: f.name, size: f.totalSize, mode: 0o644, modTime: time.Now().UTC(), isDir: false}
+	return uploadInfo{davFileInfo: info, f: f}, nil
+}
+
+// uploadInfo describes an upload. The WebDAV handler stats a PUT's file
+// before closing it but asks for the ETag it answers with only after, so
+// the ETag is looked up late, once the file is stored.
+type uploadInfo struct {
+	davFileInfo
+	f *uploadFile
+}
+
+func (fi uploadInfo) ETag(ctx context.Context) (string, error) {
+	fi.f.mu.Lock()
+	defer fi.f.mu.Unlock()
+	if fi.f.etag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.f.etag, nil
 }
 
 func (f *uploadFile) Readdir(count int) ([]os.FileInfo, error) {
@@ -961,12 +1665,20 @@ func (f *uploadFile) startPartLocked() error {
 	partIndex := f.partIndex
 	filename := f.partFilename(partIndex)
 	done := make(chan uploadResult, 1)
+	asVideo := f.asVideo
 	go func() {
-		msg, err := f.tg.UploadDocument(f.ctx, f.storageChatID, filename, pr)
+		var msg *telegram.Message
+		var err error
+		if asVideo {
+			msg, err = f.uploadVideo(filename, pr)
+		} else {
+			msg, err = f.tg.UploadDocument(f.ctx, f.storageChatID, filename, pr)
+		}
 		done <- uploadResult{msg: msg, err: err}
 	}()
 	f.current = &uploadPart{
 		index: partIndex,
+		hash:  sha256.New(),
 		pipeW: pw,
 		done:  done,
 	}
@@ -993,30 +1705,38 @@ func (f *uploadFile) finishPart() error {
 		f.mu.Unlock()
 		return res.err
 	}
-	if res.msg == nil || res.msg.Document == nil {
+	doc := res.msg.StoredDocument()
+	if doc == nil {
 		err := errors.New("telegram upload returned no document")
 		f.mu.Lock()
 		f.abortLocked(err)
 		f.mu.Unlock()
 		return err
 	}
-	doc := res.msg.Document
 	size := doc.FileSize
 	if size == 0 {
 		size = part.size
 	}
+	sum := hex.EncodeToString(part.hash.Sum(nil))
 	partInput := db.FilePartInput{
-		PartIndex:      part.index,
-		TelegramFileID: doc.FileID,
-		FileUniqueID:   doc.FileUniqueID,
-		Size:           size,
+		PartIndex:        part.index,
+		TelegramFileID:   doc.FileID,
+		FileUniqueID:     doc.FileUniqueID,
+		Size:             size,
+		StorageChatID:    res.msg.Chat.ID,
+		StorageMessageID: res.msg.MessageID,
+		SHA256:           sum,
 	}
 	if f.uploadID != 0 {
 		if err := f.store.AddWebDAVUploadPart(f.ctx, f.uploadID, db.WebDAVUploadPartInput{
-			PartIndex:      part.index,
-			TelegramFileID: doc.FileID,
-			FileUniqueID:   doc.FileUniqueID,
-			Size:           size,
+			PartIndex:        part.index,
+			TelegramFileID:   doc.FileID,
+			FileUniqueID:     doc.FileUniqueID,
+			Size:             size,
+			StorageChatID:    res.msg.Chat.ID,
+			StorageMessageID: res.msg.MessageID,
+			SHA256:           sum,
+			HashState:        f.hashState(),
 		}, doc.MimeType); err != nil {
 			f.mu.Lock()
 			f.abortLocked(err)
@@ -0,0 +1,300 @@
+// Package webhook posts outbox events to the URLs users register with
+// /webhooks in the bot.
+//
+// Each event is a POST of a JSON object:
+//
+//	{"id": 42, "event": "file.created", "user_id": 7,
+//	 "created_at": "2024-05-01T12:00:00Z", "data": {...}}
+//
+// where data is the event's outbox payload. The X-Pigpak-Event header names
+// the event, X-Pigpak-Delivery numbers the delivery, the same on retries,
+// and X-Pigpak-Signature carries "sha256=" and the hex HMAC-SHA256 of the
+// body keyed with the webhook's secret. Any 2xx answer delivers the event;
+// other answers and errors are retried with backoff up to
+// WEBHOOK_MAX_ATTEMPTS times. Delivery is at least once: an attempt cut
+// short by a shutdown is made again. Events may arrive out of order; their
+// ids order them.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"pigpak/internal/config"
+	"pigpak/internal/db"
+)
+
+// consumer is the dispatcher's outbox cursor.
+const consumer = "webhooks"
+
+const (
+	pollInterval  = 5 * time.Second
+	pruneInterval = time.Hour
+	batchSize     = 100
+	// workers bounds the deliveries in flight, so one slow endpoint does
+	// not hold up the rest.
+	workers = 4
+	// firstRetry doubles with every failed attempt, up to maxRetry.
+	firstRetry = 30 * time.Second
+	maxRetry   = 6 * time.Hour
+	// maxErrorBody is how much of a failed answer the delivery log keeps.
+	maxErrorBody = 256
+)
+
+// Dispatcher queues outbox events for the webhooks that take them and
+// delivers them.
+type Dispatcher struct {
+	cfg    config.Config
+	store  *db.Store
+	client *http.Client
+}
+
+// New returns a dispatcher delivering with the timeouts and limits of cfg.
+func New(cfg config.Config, store *db.Store) *Dispatcher {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !cfg.WebhookAllowPrivate {
+		dialer.Control = refusePrivate
+	}
+	return &Dispatcher{
+		cfg:   cfg,
+		store: store,
+		client: &http.Client{
+			Timeout: cfg.WebhookTimeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 10 * time.Second,
+				MaxIdleConnsPerHost: 2,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			// A redirect is an answer like any other non-2xx: following
+			// it would post the event somewhere the user never named.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+// Run queues and delivers events until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	var lastPrune time.Time
+	for {
+		if err := d.enqueue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("webhook enqueue: %v", err)
+		}
+		d.deliverDue(ctx)
+		if d.cfg.OutboxRetention > 0 && time.Since(lastPrune) >= pruneInterval {
+			lastPrune = time.Now()
+			if n, err := d.store.PruneWebhookDeliveries(ctx, d.cfg.OutboxRetention); err != nil {
+				log.Printf("prune webhook deliveries: %v", err)
+			} else if n > 0 {
+				log.Printf("pruned %d webhook deliveries", n)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// enqueue turns the outbox events past the cursor into deliveries.
+func (d *Dispatcher) enqueue(ctx context.Context) error {
+	hooks, err := d.store.ListAllWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	cursor, err := d.store.OutboxCursor(ctx, consumer)
+	if err != nil {
+		return err
+	}
+	for {
+		events, err := d.store.ListOutbox(ctx, cursor, batchSize)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		var deliveries []db.WebhookDelivery
+		for _, e := range events {
+			for _, h := range hooks {
+				if !d.wants(h, e) {
+					continue
+				}
+				body, err := eventBody(e)
+				if err != nil {
+					return err
+				}
+				deliveries = append(deliveries, db.WebhookDelivery{WebhookID: h.ID, EventID: e.ID, Event: e.Event, Body: body})
+			}
+		}
+		cursor = events[len(events)-1].ID
+		if err := d.store.EnqueueWebhookDeliveries(ctx, consumer, cursor, deliveries); err != nil {
+			return err
+		}
+		if len(events) < batchSize {
+			return nil
+		}
+	}
+}
+
+// wants reports whether h takes e. Events from before a webhook was
+// registered are not sent to it.
+func (d *Dispatcher) wants(h db.Webhook, e db.OutboxEvent) bool {
+	if !h.Wants(e.Event) || e.CreatedAt.Before(h.CreatedAt) {
+		return false
+	}
+	return h.UserID == e.UserID || (h.AllUsers && d.cfg.IsAdmin(h.UserID))
+}
+
+type eventJSON struct {
+	ID        int64           `json:"id"`
+	Event     string          `json:"event"`
+	UserID    int64           `json:"user_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func eventBody(e db.OutboxEvent) (string, error) {
+	data := json.RawMessage(e.Payload)
+	if !json.Valid(data) {
+		data = json.RawMessage("{}")
+	}
+	body, err := json.Marshal(eventJSON{ID: e.ID, Event: e.Event, UserID: e.UserID, CreatedAt: e.CreatedAt.UTC(), Data: data})
+	return string(body), err
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	due, err := d.store.DueWebhookDeliveries(ctx, batchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("webhook load deliveries: %v", err)
+		}
+		return
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for _, del := range due {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(del db.WebhookDelivery) {
+			defer func() { <-sem; wg.Done() }()
+			d.attempt(ctx, del)
+		}(del)
+	}
+	wg.Wait()
+}
+
+// attempt sends one delivery and records the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, del db.WebhookDelivery) {
+	code, err := d.send(ctx, del)
+	if ctx.Err() != nil {
+		return
+	}
+	attempts := del.Attempts + 1
+	status, errText, next := db.DeliveryDelivered, "", time.Now()
+	if err != nil {
+		errText = err.Error()
+		status = db.DeliveryPending
+		next = next.Add(backoff(attempts))
+		if attempts >= d.cfg.WebhookMaxAttempts {
+			status = db.DeliveryFailed
+			log.Printf("webhook delivery %d to %s failed after %d attempts: %v", del.ID, del.URL, attempts, err)
+		}
+	}
+	if err := d.store.RecordWebhookAttempt(ctx, del.ID, status, code, errText, next); err != nil {
+		log.Printf("webhook record delivery %d: %v", del.ID, err)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, del db.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, del.URL, strings.NewReader(del.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pigpak-webhook")
+	req.Header.Set("X-Pigpak-Event", del.Event)
+	req.Header.Set("X-Pigpak-Delivery", strconv.FormatInt(del.ID, 10))
+	req.Header.Set("X-Pigpak-Signature", "sha256="+Signature(del.Secret, []byte(del.Body)))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		if text := strings.TrimSpace(string(snippet)); text != "" {
+			return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, text)
+		}
+		return resp.StatusCode, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Probe checks that a webhook's URL can be reached with a HEAD request,
+// through the same address checks as deliveries. Any HTTP answer counts,
+// as receivers need not handle HEAD; no event is delivered.
+func (d *Dispatcher) Probe(ctx context.Context, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "pigpak-webhook")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Signature returns the hex HMAC-SHA256 of body keyed with secret, as sent
+// in X-Pigpak-Signature after "sha256=".
+func Signature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff is the wait after a delivery's attempts-th failure.
+func backoff(attempts int) time.Duration {
+	wait := firstRetry
+	for i := 1; i < attempts && wait < maxRetry; i++ {
+		wait *= 2
+	}
+	return min(wait, maxRetry)
+}
+
+// refusePrivate keeps webhooks from reaching the host and its network. It
+// checks the address dialed, after name resolution, so a public name that
+// resolves to a private address is refused too.
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("webhook address %s is not public; set WEBHOOK_ALLOW_PRIVATE to allow it", ip)
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+// pigpak web UI. Everything goes through /api/v1 with the session cookie
+// /auth/telegram or /auth/webapp sets; uploads use its tus endpoint.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+const state = { dir: null, path: [] };
+
+async function api(method, path, body) {
+  const opts = { method, headers: {} };
+  if (body !== undefined) {
+    opts.headers["Content-Type"] = "application/json";
+    opts.body = JSON.stringify(body);
+  }
+  const res = await fetch("/api/v1" + path, opts);
+  if (res.status === 401) {
+    showLogin();
+    throw new Error("signed out");
+  }
+  if (res.status === 204) return null;
+  const data = await res.json().catch(() => null);
+  if (!res.ok) throw new Error((data && data.error && data.error.message) || res.statusText);
+  return data;
+}
+
+function fail(err) {
+  const box = $("error");
+  box.textContent = err.message || String(err);
+  box.hidden = false;
+  setTimeout(() => { box.hidden = true; }, 6000);
+}
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (k.startsWith("on")) node.addEventListener(k.slice(2), v);
+    else node.setAttribute(k, v);
+  }
+  for (const child of children) node.append(child);
+  return node;
+}
+
+function button(label, onclick, cls) {
+  const b = el("button", cls ? { class: cls } : {}, label);
+  b.addEventListener("click", (e) => { e.preventDefault(); Promise.resolve(onclick()).catch(fail); });
+  return b;
+}
+
+function size(n) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+function when(t) {
+  return t ? new Date(t).toLocaleString() : "";
+}
+
+// Signing in.
+
+async function init() {
+  const form = new URLSearchParams(location.search);
+  if (form.has("hash")) {
+    // The login widget redirects back here with the signed user fields.
+    history.replaceState(null, "", "/ui/");
+    await login("/auth/telegram", form);
+  }
+  const res = await fetch("/auth/session");
+  if (res.ok) return showApp();
+  const webApp = window.Telegram && window.Telegram.WebApp;
+  if (webApp && webApp.initData) {
+    webApp.ready();
+    if (await login("/auth/webapp", new URLSearchParams({ init_data: webApp.initData }))) return showApp();
+  }
+  showLogin();
+}
+
+async function login(url, form) {
+  const res = await fetch(url, { method: "POST", body: form });
+  if (!res.ok) {
+    const data = await res.json().catch(() => null);
+    fail(new Error("Sign-in failed: " + ((data && data.error && data.error.message) || res.statusText)));
+  }
+  return res.ok;
+}
+
+function showLogin() {
+  for (const id of ["files", "shares", "trash"]) $(id).hidden = true;
+  $("tabs").hidden = true;
+  $("login").hidden = false;
+  const bot = document.body.dataset.bot;
+  if (!bot) {
+    $("login-help").textContent = "The server does not know its bot's username; set BOT_USERNAME.";
+    return;
+  }
+  if ($("widget").childElementCount) return;
+  const script = document.createElement("script");
+  script.src = "https://telegram.org/js/telegram-widget.js?22";
+  script.async = true;
+  script.dataset.telegramLogin = bot;
+  script.dataset.size = "large";
+  script.dataset.authUrl = location.origin + "/ui/";
+  $("widget").append(script);
+  $("login-help").textContent = "The button only works on the domain set for @" + bot + " with /setdomain in @BotFather.";
+}
+
+function showApp() {
+  $("login").hidden = true;
+  $("tabs").hidden = false;
+  showTab("files");
+}
+
+function showTab(name) {
+  for (const b of document.querySelectorAll("#tabs [data-tab]")) b.classList.toggle("active", b.dataset.tab === name);
+  for (const id of ["files", "shares", "trash"]) $(id).hidden = id !== name;
+  const load = { files: () => openDir(state.dir ? state.dir.public_id : "root"), shares: loadShares, trash: loadTrash }[name];
+  load().catch(fail);
+}
+
+// Files.
+
+async function openDir(id) {
+  const [dir, children] = await Promise.all([api("GET", "/dirs/" + id), api("GET", "/dirs/" + id + "/children")]);
+  const at = state.path.findIndex((d) => d.public_id === dir.public_id);
+  if (at >= 0) state.path = state.path.slice(0, at + 1);
+  else if (!dir.parent_id) state.path = [dir];
+  else state.path.push(dir);
+  state.dir = dir;
+  renderCrumbs();
+  const rows = [];
+  for (const d of children.dirs) {
+    rows.push(el("tr", {},
+      el("td", {}, el("a", { class: "dir", onclick: () => openDir(d.public_id).catch(fail) }, d.name + "/")),
+      el("td", { class: "num" }, ""),
+      el("td", {}, when(d.updated_at)),
+      el("td", { class: "ops" },
+        button("Rename", () => rename("/dirs/" + d.public_id, d.name)),
+        button("Delete", () => remove("/dirs/" + d.public_id, d.name), "danger"))));
+  }
+  for (const f of children.files) {
+    rows.push(el("tr", {},
+      el("td", {}, el("a", { href: "/api/v1/files/" + f.public_id + "/download" }, f.name)),
+      el("td", { class: "num" }, size(f.size)),
+      el("td", {}, when(f.modified_at)),
+      el("td", { class: "ops" },
+        ...(/^(video|audio)\//.test(f.mime_type || "") ? [el("a", { class: "button", href: "/api/v1/files/" + f.public_id + "/stream", target: "_blank" }, "Play")] : []),
+        button("Share", () => share(f)),
+        button("Rename", () => rename("/files/" + f.public_id, f.name)),
+        button("Delete", () => remove("/files/" + f.public_id, f.name), "danger"))));
+  }
+  if (!rows.length) rows.push(el("tr", {}, el("td", { colspan: "4", class: "muted" }, "This folder is empty.")));
+  $("listing").replaceChildren(...rows);
+}
+
+function renderCrumbs() {
+  const parts = [];
+  state.path.forEach((d, i) => {
+    if (i > 0) parts.push(el("span", { class: "sep" }, "/"));
+    parts.push(el("a", { onclick: () => openDir(d.public_id).catch(fail) }, i === 0 ? "Home" : d.name));
+  });
+  $("crumbs").replaceChildren(...parts);
+}
+
+function reload() {
+  return openDir(state.dir.public_id);
+}
+
+async function rename(path, current) {
+  const name = prompt("New name", current);
+  if (!name || name === current) return;
+  await api("PATCH", path, { name });
+  await reload();
+}
+
+async function remove(path, name) {
+  if (!confirm("Move " + name + " to the trash?")) return;
+  await api("DELETE", path);
+  await reload();
+}
+
+async function share(file) {
+  const days = prompt("Share " + file.name + " for how many days? 0 never expires.", "7");
+  if (days === null) return;
+  const password = prompt("Password for the link, or leave empty for none.", "") || "";
+  const sh = await api("POST", "/shares", { file_id: file.public_id, expires_in_days: parseInt(days, 10) || 0, password });
+  const link = sh.url || location.origin + sh.path;
+  prompt("Share link", link);
+}
+
+// Uploads go over tus, so one cut short resumes where its stored parts
+// end rather than from the start. Each upload's URL is kept in
+// localStorage until it completes: choosing the same file again after a
+// reload picks it up too.
+
+const tusHeaders = { "Tus-Resumable": "1.0.0" };
+const maxRetries = 8;
+
+async function upload(files) {
+  const dir = state.dir;
+  for (const file of files) {
+    const bar = el("progress", { max: "100", value: "0" });
+    const status = el("span", { class: "muted" }, "waiting");
+    const row = el("li", {}, el("span", {}, file.name), bar, status);
+    $("uploads").append(row);
+    try {
+      await tusUpload(file, dir.public_id, bar, status);
+      status.textContent = "done";
+      setTimeout(() => row.remove(), 4000);
+    } catch (err) {
+      status.textContent = err.message;
+    }
+  }
+  if (state.dir && state.dir.public_id === dir.public_id) await reload();
+}
+
+async function tusUpload(file, dirID, bar, status) {
+  const key = ["tus", dirID, file.name, file.size, file.lastModified].join(":");
+  let url = localStorage.getItem(key);
+  let offset = url ? await tusOffset(url) : null;
+  if (offset === null) {
+    url = await tusCreate(file, dirID);
+    // Empty files are stored as soon as they are created.
+    if (!url) return;
+    localStorage.setItem(key, url);
+    offset = 0;
+  }
+  for (let attempt = 0; ; attempt++) {
+    try {
+      await tusPatch(url, file, offset, bar, status);
+      localStorage.removeItem(key);
+      return;
+    } catch (err) {
+      if (err.fatal || attempt >= maxRetries) throw err;
+      const wait = Math.min(30, 2 ** attempt);
+      status.textContent = err.message + ", retrying in " + wait + "s";
+      await new Promise((resolve) => setTimeout(resolve, wait * 1000));
+      offset = await tusOffset(url);
+      if (offset === null) {
+        localStorage.removeItem(key);
+        throw new Error("upload expired, choose the file again");
+      }
+    }
+  }
+}
+
+function b64(s) {
+  return btoa(unescape(encodeURIComponent(s)));
+}
+
+async function tusCreate(file, dirID) {
+  const res = await fetch("/api/v1/tus/", {
+    method: "POST",
+    headers: { ...tusHeaders, "Upload-Length": String(file.size), "Upload-Metadata": "filename " + b64(file.name) + ",dir_id " + b64(String(dirID)) },
+  });
+  if (res.status === 401) {
+    showLogin();
+    throw new Error("signed out");
+  }
+  if (res.status !== 201) {
+    const data = await res.json().catch(() => null);
+    throw new Error((data && data.error && data.error.message) || res.statusText);
+  }
+  return res.headers.get("Location");
+}
+
+// tusOffset returns how much of the upload at url is stored, or null when
+// it is gone: completed, expired or dropped.
+async function tusOffset(url) {
+  const res = await fetch(url, { method: "HEAD", headers: tusHeaders });
+  if (res.status === 404) return null;
+  if (!res.ok) throw new Error("upload status failed: " + res.status);
+  return parseInt(res.headers.get("Upload-Offset"), 10);
+}
+
+function tusPatch(url, file, offset, bar, status) {
+  return new Promise((resolve, reject) => {
+    const xhr = new XMLHttpRequest();
+    xhr.open("PATCH", url);
+    for (const [k, v] of Object.entries(tusHeaders)) xhr.setRequestHeader(k, v);
+    xhr.setRequestHeader("Upload-Offset", String(offset));
+    xhr.setRequestHeader("Content-Type", "application/offset+octet-stream");
+    xhr.upload.onprogress = (e) => {
+      const done = offset + e.loaded;
+      bar.value = Math.floor((done / file.size) * 100);
+      status.textContent = size(done) + " of " + size(file.size);
+    };
+    xhr.onload = () => {
+      if (xhr.status === 204) return resolve();
+      const err = new Error("upload failed: " + xhr.status + " " + xhr.statusText);
+      // Conflicts and server errors are retried from the stored offset.
+      err.fatal = xhr.status !== 409 && xhr.status < 500;
+      reject(err);
+    };
+    xhr.onerror = () => reject(new Error("connection lost"));
+    status.textContent = offset ? "resuming" : "uploading";
+    xhr.send(file.slice(offset));
+  });
+}
+
+// Shares.
+
+async function loadShares() {
+  const { shares } = await api("GET", "/shares");
+  const rows = shares.map((sh) => {
+    const link = sh.path ? el("code", {}, sh.url || location.origin + sh.path) : el("span", { class: "muted" }, "for " + sh.recipient + ", in Telegram");
+    const ops = el("td", { class: "ops" });
+    if (sh.path) {
+      ops.append(button(sh.password ? "Change password" : "Set password", async () => {
+        const password = prompt("New password, or leave empty to remove it.", "");
+        if (password === null) return;
+        await api("PATCH", "/shares/" + sh.id, { password });
+        await loadShares();
+      }));
+    }
+    ops.append(button("Revoke", async () => {
+      if (!confirm("Revoke the share of " + sh.file_name + "?")) return;
+      await api("DELETE", "/shares/" + sh.id);
+      await loadShares();
+    }, "danger"));
+    return el("tr", {},
+      el("td", {}, sh.file_name + (sh.password ? " (password)" : "")),
+      el("td", {}, link),
+      el("td", { class: "num" }, String(sh.uses)),
+      el("td", {}, sh.expires_at ? when(sh.expires_at) : "never"),
+      ops);
+  });
+  if (!rows.length) rows.push(el("tr", {}, el("td", { colspan: "5", class: "muted" }, "No shares. Use Share on a file to create one.")));
+  $("share-list").replaceChildren(...rows);
+}
+
+// Trash.
+
+async function loadTrash() {
+  const { items } = await api("GET", "/trash");
+  const rows = items.map((item) => el("tr", {},
+    el("td", {}, item.name + (item.kind === "dir" ? "/" : "")),
+    el("td", {}, item.path),
+    el("td", { class: "num" }, size(item.size)),
+    el("td", {}, when(item.deleted_at)),
+    el("td", { class: "ops" },
+      button("Restore", async () => {
+        await api("POST", "/trash/" + item.id + "/restore", {});
+        await loadTrash();
+      }),
+      button("Delete forever", async () => {
+        if (!confirm("Delete " + item.name + " for good? This cannot be undone.")) return;
+        await api("DELETE", "/trash/" + item.id);
+        await loadTrash();
+      }, "danger"))));
+  if (!rows.length) rows.push(el("tr", {}, el("td", { colspan: "5", class: "muted" }, "The trash is empty.")));
+  $("trash-list").replaceChildren(...rows);
+}
+
+// Wiring.
+
+document.addEventListener("DOMContentLoaded", () => {
+  for (const b of document.querySelectorAll("#tabs [data-tab]")) b.addEventListener("click", () => showTab(b.dataset.tab));
+  $("logout").addEventListener("click", async () => {
+    await fetch("/auth/logout", { method: "POST" });
+    state.dir = null;
+    state.path = [];
+    showLogin();
+  });
+  $("mkdir").addEventListener("click", async () => {
+    const name = prompt("Folder name");
+    if (!name) return;
+    await api("POST", "/dirs", { parent_id: state.dir.public_id, name }).catch(fail);
+    await reload().catch(fail);
+  });
+  $("upload").addEventListener("change", (e) => {
+    upload([...e.target.files]).catch(fail);
+    e.target.value = "";
+  });
+  document.addEventListener("dragover", (e) => {
+    if ($("files").hidden) return;
+    e.preventDefault();
+    document.body.classList.add("dragging");
+  });
+  document.addEventListener("dragleave", (e) => {
+    if (!e.relatedTarget) document.body.classList.remove("dragging");
+  });
+  document.addEventListener("drop", (e) => {
+    document.body.classList.remove("dragging");
+    if ($("files").hidden || !e.dataTransfer.files.length) return;
+    e.preventDefault();
+    upload([...e.dataTransfer.files]).catch(fail);
+  });
+  init().catch(fail);
+});
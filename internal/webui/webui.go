@@ -0,0 +1,78 @@
+// Package webui serves pigpak's browser interface: a single page that
+// browses, uploads, shares and restores files through the JSON API, signed
+// in with Telegram.
+package webui
+
+import (
+	"context"
+	"embed"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"pigpak/internal/telegram"
+)
+
+//go:embed static
+var static embed.FS
+
+var page = template.Must(template.ParseFS(static, "static/index.html"))
+
+// Handler serves:
+//
+//	GET /ui/         the page
+//	GET /ui/<asset>  its script and style sheet
+//
+// Signing in uses the Telegram Login Widget, which only works on the domain
+// set for the bot with /setdomain in @BotFather, or the Mini App init data
+// when the page is opened from the bot.
+type Handler struct {
+	// BotUsername is the bot the login widget signs in with. When empty it
+	// is looked up with getMe on first use.
+	BotUsername string
+	Telegram    telegram.BotAPI
+
+	once   sync.Once
+	assets http.Handler
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.once.Do(h.init)
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'self' https://telegram.org; frame-src https://oauth.telegram.org; img-src 'self' data: https:; style-src 'self'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "same-origin")
+	if r.URL.Path != "/ui/" {
+		h.assets.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := page.Execute(w, struct{ BotUsername string }{h.BotUsername}); err != nil {
+		log.Printf("webui page: %v", err)
+	}
+}
+
+func (h *Handler) init() {
+	sub, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	h.assets = http.StripPrefix("/ui/", http.FileServer(http.FS(sub)))
+	if h.BotUsername == "" && h.Telegram != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if me, err := h.Telegram.GetMe(ctx); err == nil {
+			h.BotUsername = me.Username
+		} else {
+			log.Printf("webui bot username: %v", err)
+		}
+	}
+}